@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader is the header identifying the client, its fingerprint is recorded as the actor
+const APIKeyHeader = "X-API-Key"
+
+// maxBodySize is the largest request body inspected to find the keys of a write
+const maxBodySize = 32 << 20
+
+// Event records a key changed through the HTTP API
+type Event struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`       // Fingerprint of the API key, or "anonymous"
+	RemoteAddr string    `json:"remote_addr"` // Client IP
+	Operation  string    `json:"operation"`   // Endpoint used: set, del, purge...
+	Path       string    `json:"path"`        // Full request path, tenant prefix included
+	Key        string    `json:"key"`
+}
+
+// Sink receives the audit events
+type Sink func(Event)
+
+// FileLog appends audit events to a file as JSON lines
+type FileLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFile opens (or creates) an append-only audit log file
+func OpenFile(filePath string) (*FileLog, error) {
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLog{file: file}, nil
+}
+
+// Write appends an event to the file and syncs it
+func (l *FileLog) Write(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Sink returns a Sink writing to the file, errors are passed to onError if it is not nil
+func (l *FileLog) Sink(onError func(error)) Sink {
+	return func(event Event) {
+		if err := l.Write(event); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Close closes the file
+func (l *FileLog) Close() error {
+	return l.file.Close()
+}
+
+// Actor returns the identity recorded for a request: a fingerprint of its API key, never the key itself
+func Actor(r *http.Request) string {
+	apiKey := r.Header.Get(APIKeyHeader)
+	if apiKey == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Handler wraps the API handler and sends one event to sink for every key changed by a successful write.
+// Endpoints are recognized by the last segment of their path, so tenant prefixes are supported.
+func Handler(next http.Handler, sink Sink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := path.Base(r.URL.Path)
+		keys, ok := mutatedKeys(operation, r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 400 {
+			return
+		}
+
+		remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remoteAddr = r.RemoteAddr
+		}
+		now := time.Now()
+		for _, key := range keys {
+			sink(Event{
+				Time:       now,
+				Actor:      Actor(r),
+				RemoteAddr: remoteAddr,
+				Operation:  operation,
+				Path:       r.URL.Path,
+				Key:        key,
+			})
+		}
+	})
+}
+
+// mutatedKeys returns the keys a request is about to change, and false if it is not a write
+func mutatedKeys(operation string, r *http.Request) ([]string, bool) {
+	switch operation {
+	case "del", "purge", "setpath":
+		key := r.URL.Query().Get("key")
+		return []string{key}, key != ""
+	case "blob":
+		key := r.URL.Query().Get("key")
+		return []string{key}, key != "" && r.Method == http.MethodPut
+	case "set":
+		// Read the body to find the keys and hand an identical copy to the handler
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			return nil, false
+		}
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, false
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys, true
+	case "tx":
+		// The keys written and deleted by the transaction, its reads don't change anything
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			return nil, false
+		}
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		var tx struct {
+			Writes  map[string]json.RawMessage `json:"writes"`
+			Deletes []string                   `json:"deletes"`
+		}
+		if err := json.Unmarshal(body, &tx); err != nil {
+			return nil, false
+		}
+		keys := append([]string(nil), tx.Deletes...)
+		for key := range tx.Writes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys, len(keys) > 0
+	}
+	return nil, false
+}
@@ -0,0 +1,242 @@
+// Package cdc publishes the mutations of a database, read from its WAL, to external sinks so downstream systems
+// can mirror the store. Delivery is at least once: the position of the last published record is saved in a cursor
+// file only after the sink accepted it, so changes published before a crash or a sink failure are sent again.
+package cdc
+
+import (
+	"StorageEngine/memdb"
+	"StorageEngine/vfs"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operations of a change
+const (
+	OpSet      = "set"
+	OpDel      = "del"
+	OpDelRange = "delrange" // Deletes the keys from Key up to End
+)
+
+const (
+	// DefaultInterval is the time between two reads of the WAL when none is configured
+	DefaultInterval = time.Second
+	// DefaultBatchSize is the maximum number of changes published at once when none is configured
+	DefaultBatchSize = 100
+)
+
+// Change is a mutation of the database, in the order it was written to the WAL
+type Change struct {
+	Op       string `json:"op"`
+	Key      string `json:"key"`
+	Value    []byte `json:"value,omitempty"` // Base64 in JSON, empty for deletions
+	End      string `json:"end,omitempty"`   // End of the keys deleted by a delrange, excluded, none if empty
+	Position int64  `json:"position"`        // Offset of the record in the WAL, increasing until the WAL is reset
+}
+
+// Sink receives the changes of the database
+type Sink interface {
+	// Publish delivers a batch of changes, in order. An error means the batch wasn't delivered and
+	// will be published again, so a sink may see a batch more than once.
+	Publish(changes []Change) error
+}
+
+// Config describes what to publish and where
+type Config struct {
+	WALPath    string        // WAL of the database
+	CursorPath string        // File keeping track of the published records
+	Sink       Sink          // Where the changes are published
+	Interval   time.Duration // Time between two reads of the WAL, DefaultInterval if 0
+	BatchSize  int           // Maximum number of changes per Publish call, DefaultBatchSize if 0
+}
+
+// cursor is the last record published, saved in the cursor file. The checksum of the record tells whether the
+// WAL still holds it: when the WAL is reset, new records are written over the old positions.
+type cursor struct {
+	Position int64  `json:"position"` // Offset of the last record published
+	Size     int64  `json:"size"`     // Size of the last record published, header included
+	Checksum uint32 `json:"checksum"` // CRC32 of the key and value of the last record published
+}
+
+// next returns the position of the first record not published yet
+func (c cursor) next() int64 {
+	return c.Position + c.Size
+}
+
+// Publisher tails the WAL of a database and publishes its changes to a sink
+type Publisher struct {
+	cfg     Config
+	mu      sync.Mutex // Serializes Poll
+	cursor  cursor
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// New returns a publisher for cfg, resuming after the records listed in the cursor file if it exists
+func New(cfg Config) (*Publisher, error) {
+	if cfg.Sink == nil {
+		return nil, errors.New("No sink configured")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	p := &Publisher{cfg: cfg}
+	data, err := os.ReadFile(cfg.CursorPath)
+	if err == nil {
+		err = json.Unmarshal(data, &p.cursor)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return p, err
+}
+
+// Start publishes the changes every interval in the background until Close is called.
+// Errors are logged and the changes retried at the next interval.
+func (p *Publisher) Start() {
+	p.closing = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := p.Poll(); err != nil {
+				log.Printf("Change data capture: %s", err)
+			}
+			select {
+			case <-p.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the background publishing started by Start
+func (p *Publisher) Close() error {
+	if p.closing != nil {
+		close(p.closing)
+		<-p.done
+		p.closing = nil
+	}
+	return nil
+}
+
+// Poll publishes the changes written to the WAL since the last call, in batches, and returns how many were
+// published. It stops at the first batch the sink refuses, which is published again by the next call. The changes
+// of a transaction are published in the same batch, which may hold more than BatchSize changes then.
+func (p *Publisher) Poll() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start, err := p.resume()
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	batch := make([]Change, 0, p.cfg.BatchSize)
+	var last cursor
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := p.cfg.Sink.Publish(batch); err != nil {
+			return err
+		}
+		if err := p.save(last); err != nil {
+			return err
+		}
+		published += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	_, err = memdb.ScanWALFileFrom(p.cfg.WALPath, start, func(entry memdb.WALEntry) error {
+		changes, err := ChangesOf(entry)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, changes...)
+		last = cursor{Position: entry.Position, Size: entry.Size, Checksum: recordChecksum(entry.WALRecord)}
+		if len(batch) >= p.cfg.BatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return published, err
+	}
+	return published, flush()
+}
+
+// ChangesOf returns the changes of a record of the WAL: one, or those of the writes of a transaction, committed
+// with a single record, all at its position
+func ChangesOf(entry memdb.WALEntry) ([]Change, error) {
+	records, err := memdb.DecodeBatch(entry.WALRecord)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]Change, len(records))
+	for i, record := range records {
+		changes[i] = Change{Op: OpSet, Key: string(record.Key), Value: record.Value, Position: entry.Position}
+		switch record.Operation {
+		case memdb.OpDel:
+			changes[i].Op, changes[i].Value = OpDel, nil
+		case memdb.OpDelRange:
+			changes[i].Op, changes[i].Value, changes[i].End = OpDelRange, nil, string(record.Value)
+		}
+	}
+	return changes, nil
+}
+
+// resume returns the position to publish from: after the cursor if the WAL still holds the record it points to,
+// from the first record otherwise, as the WAL was reset since
+func (p *Publisher) resume() (int64, error) {
+	if p.cursor.Size == 0 {
+		return 0, nil
+	}
+	var found bool
+	_, err := memdb.ScanWALFileFrom(p.cfg.WALPath, p.cursor.Position, func(entry memdb.WALEntry) error {
+		found = entry.Size == p.cursor.Size && recordChecksum(entry.WALRecord) == p.cursor.Checksum
+		return errStopScan
+	})
+	if err != nil && err != errStopScan && err != memdb.ErrTruncatedWAL && err != memdb.ErrCorruptedWALRecord {
+		return 0, err
+	}
+	if !found {
+		return 0, nil
+	}
+	return p.cursor.next(), nil
+}
+
+// errStopScan stops a WAL scan after the first record
+var errStopScan = errors.New("Stop scan")
+
+// save records the last published record in the cursor file, replacing it atomically
+func (p *Publisher) save(last cursor) error {
+	data, err := json.Marshal(last)
+	if err != nil {
+		return err
+	}
+	if err := vfs.WriteFileAtomic(vfs.OS, p.cfg.CursorPath, data, 0644); err != nil {
+		return err
+	}
+	p.cursor = last
+	return nil
+}
+
+// recordChecksum is the CRC32 of the operation, key and value of a record
+func recordChecksum(record memdb.WALRecord) uint32 {
+	crc := crc32.NewIEEE()
+	crc.Write([]byte{byte(record.Operation)})
+	crc.Write(record.Key)
+	crc.Write(record.Value)
+	return crc.Sum32()
+}
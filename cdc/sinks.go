@@ -0,0 +1,152 @@
+package cdc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookSink posts each batch of changes as a JSON array to a URL.
+// Any status other than 2xx is a failed delivery.
+type WebhookSink struct {
+	URL     string
+	Headers map[string]string // Added to every request, e.g. for authentication
+	Client  *http.Client      // http.DefaultClient if nil
+}
+
+// Publish posts changes to the webhook
+func (s *WebhookSink) Publish(changes []Change) error {
+	body, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// NATSSink publishes each change as a JSON message on a NATS subject, speaking the NATS client protocol.
+// The batch is followed by a PING, and is only delivered once the server answers PONG, i.e. has processed
+// every message before it. The connection is opened on the first batch and again after a failure.
+type NATSSink struct {
+	Addr    string        // Address of the NATS server, host:port
+	Subject string        // Subject the changes are published on
+	Timeout time.Duration // Timeout of the connection and of each batch, 10 seconds if 0
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Publish sends changes to the NATS server
+func (s *NATSSink) Publish(changes []Change) error {
+	if err := s.publish(changes); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *NATSSink) publish(changes []Change) error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if s.conn == nil {
+		if err := s.connect(timeout); err != nil {
+			return err
+		}
+	}
+	s.conn.SetDeadline(time.Now().Add(timeout))
+
+	var buf bytes.Buffer
+	for _, change := range changes {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", s.Subject, len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.waitPong()
+}
+
+// connect opens the connection and introduces the client to the server
+func (s *NATSSink) connect(timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", s.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+
+	// The server starts with an INFO line
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("Unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"storage-engine-cdc\"}\r\n")
+	return err
+}
+
+// waitPong reads the server's messages until the PONG answering the PING of the batch
+func (s *NATSSink) waitPong() error {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := io.WriteString(s.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// Close closes the connection to the server, if any
+func (s *NATSSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.reader = nil, nil
+	return err
+}
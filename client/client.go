@@ -0,0 +1,350 @@
+// Package client is a Go client for the HTTP API of the server, with typed methods, connection reuse,
+// retries with exponential backoff, and context support.
+package client
+
+import (
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"StorageEngine/replication"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetries is the number of times a failed request is retried
+	DefaultRetries = 3
+	// DefaultBackoff is the wait before the first retry, doubled before each of the next ones
+	DefaultBackoff = 100 * time.Millisecond
+	// DefaultMaxIdleConns is the number of idle connections kept open to the server
+	DefaultMaxIdleConns = 16
+)
+
+// ErrNotFound is returned when a key doesn't exist
+var ErrNotFound = errors.New("Key not found")
+
+// ErrNotReplicated is returned by a sync write the leader took but not enough replicas applied in time.
+// The write isn't undone, but it may be lost if the leader fails.
+var ErrNotReplicated = errors.New("Write not applied by enough replicas")
+
+// Consistency levels of a clustered server, see the replication package
+const (
+	ReadStale  = replication.ReadStale  // Any node answers, a replica may miss the latest writes
+	ReadLeader = replication.ReadLeader // The leader answers
+	ReadQuorum = replication.ReadQuorum // The answer includes every write acknowledged before the request
+	WriteAsync = replication.WriteAsync // Acknowledged once the leader wrote it
+	WriteSync  = replication.WriteSync  // Acknowledged once replicas applied it too
+)
+
+// StatusError is returned when the server answers with an unexpected status
+type StatusError struct {
+	Code    int
+	Message string // Body of the response
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// KeyValue is a key with its value, as returned by Scan
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// ScanOptions select the keys returned by Scan
+type ScanOptions struct {
+	Prefix string // Only keys starting with Prefix
+	Start  string // Only keys >= Start
+	End    string // Only keys < End, no upper bound if empty
+	Limit  int    // Maximum number of results, no limit if 0
+}
+
+// Client sends requests to a server. It is safe for concurrent use.
+type Client struct {
+	base    string
+	tenant  string
+	apiKey  string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+	read    string // Consistency level of reads, the server's default if empty
+	write   string // Consistency level of writes, the server's default if empty
+}
+
+// Option is a functional option for Client
+type Option func(*Client)
+
+// Tenant addresses the requests to a tenant of a multi-tenant server, with its API key if it has one
+func Tenant(name string, apiKey string) Option {
+	return func(c *Client) {
+		c.tenant = name
+		c.apiKey = apiKey
+	}
+}
+
+// HTTPClient sends the requests with httpClient instead of the client's own pooled one
+func HTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.http = httpClient
+	}
+}
+
+// Retries sets how many times a request is retried after a connection error or a 429, 502, 503 or 504 answer,
+// waiting backoff before the first retry and twice as long before each of the next ones. 0 retries disables them.
+func Retries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// Consistency sets the consistency levels of the reads and of the writes of the client, e.g. ReadQuorum and
+// WriteSync. Empty levels keep the defaults of the server, ReadStale and WriteAsync.
+func Consistency(read string, write string) Option {
+	return func(c *Client) {
+		c.read = read
+		c.write = write
+	}
+}
+
+// consistencyKey is the context key of the level set by WithConsistency
+type consistencyKey struct{}
+
+// WithConsistency returns a copy of ctx whose requests ask for the consistency level, instead of the one
+// set by the Consistency option
+func WithConsistency(ctx context.Context, level string) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, level)
+}
+
+// New returns a client of the server at addr, e.g. http://localhost:8080.
+// Connections are kept open and reused between requests.
+func New(addr string, options ...Option) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = DefaultMaxIdleConns
+	transport.MaxIdleConnsPerHost = DefaultMaxIdleConns
+	c := &Client{
+		base:    strings.TrimSuffix(addr, "/"),
+		http:    &http.Client{Transport: transport},
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+// retryable reports whether a request answered with status may succeed if sent again
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a request, retrying it as configured, and returns the response body.
+// Statuses other than 200 are returned as a *StatusError, or ErrNotFound for 404.
+// Writes carry an idempotency key, the same for every attempt, so that the server applies them once.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body []byte) ([]byte, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	idempotencyKey := ""
+	if method != "GET" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		idempotencyKey = hex.EncodeToString(random)
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		data, status, err := c.send(ctx, method, u, body, idempotencyKey)
+		if err == nil && status == http.StatusOK {
+			return data, nil
+		}
+
+		// Only connection errors and overload answers are worth sending again,
+		// and a write still being served after an attempt that seemed to fail
+		temporary := err != nil || retryable(status) || (status == http.StatusConflict && idempotencyKey != "")
+		if !temporary || attempt >= c.retries || ctx.Err() != nil {
+			if err != nil {
+				return nil, err
+			}
+			if status == http.StatusNotFound {
+				return nil, ErrNotFound
+			}
+			if status == http.StatusAccepted {
+				return nil, ErrNotReplicated
+			}
+			return nil, &StatusError{Code: status, Message: strings.TrimSpace(string(data))}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send sends one request and returns the body and status of the response
+func (c *Client) send(ctx context.Context, method string, u string, body []byte, idempotencyKey string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant", c.tenant)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(handlers.IdempotencyKeyHeader, idempotencyKey)
+	}
+	level, _ := ctx.Value(consistencyKey{}).(string)
+	if level == "" && method == "GET" {
+		level = c.read
+	} else if level == "" {
+		level = c.write
+	}
+	if level != "" {
+		req.Header.Set(replication.ConsistencyHeader, level)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, resp.StatusCode, nil
+}
+
+// Get returns the value of key, or ErrNotFound
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.do(ctx, "GET", "/get", url.Values{"key": {key}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimPrefix(data, []byte("Value: ")), nil
+}
+
+// GetMulti returns the values of keys all read at the same point in time, leaving out the keys that don't exist
+func (c *Client) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	data, err := c.do(ctx, "GET", "/getmulti", url.Values{"key": keys}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(results))
+	for _, result := range results {
+		values[result.Key] = []byte(result.Value)
+	}
+	return values, nil
+}
+
+// Set sets the value of key. Values are sent as JSON strings, so they must be valid UTF-8.
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	return c.Batch(ctx, map[string][]byte{key: value})
+}
+
+// Batch sets several keys in one request. The keys are set one after the other by the server:
+// if the request fails, some of them may be set.
+func (c *Client) Batch(ctx context.Context, kvs map[string][]byte) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(kvs))
+	for key, value := range kvs {
+		values[key] = string(value)
+	}
+	body, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "POST", "/set", nil, body)
+	return err
+}
+
+// Delete deletes key and returns its value before deletion, or ErrNotFound
+func (c *Client) Delete(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.do(ctx, "DELETE", "/del", url.Values{"key": {key}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimPrefix(data, []byte("Deleted value: ")), nil
+}
+
+// Scan returns the live keys selected by opts, in ascending order, with their values
+func (c *Client) Scan(ctx context.Context, opts ScanOptions) ([]KeyValue, error) {
+	query := url.Values{}
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.Start != "" {
+		query.Set("start", opts.Start)
+	}
+	if opts.End != "" {
+		query.Set("end", opts.End)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	data, err := c.do(ctx, "GET", "/scan", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	kvs := make([]KeyValue, 0, len(results))
+	for _, result := range results {
+		kvs = append(kvs, KeyValue{Key: result.Key, Value: []byte(result.Value)})
+	}
+	return kvs, nil
+}
+
+// Stats returns the statistics of the database
+func (c *Client) Stats(ctx context.Context) (memdb.Stats, error) {
+	var stats memdb.Stats
+	data, err := c.do(ctx, "GET", "/stats", nil, nil)
+	if err != nil {
+		return stats, err
+	}
+	return stats, json.Unmarshal(data, &stats)
+}
@@ -0,0 +1,70 @@
+// Command analyze reads the SSTables of a database and reports the shape of its key space: key length and
+// value size distributions, prefix cardinality, tombstone ratios and overlap between files, to help choose
+// compaction and compression settings. Nothing is modified.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/memdb"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+)
+
+var (
+	sstDir       = flag.String("sstables", "SSTableFiles", "SSTable directory of the database")
+	configPath   = flag.String("config", "", "Configuration file of the server to take the -sstables path from")
+	separator    = flag.String("separator", ":", "Keys are grouped by their prefix up to the first separator")
+	prefixLength = flag.Int("prefix-length", 4, "Prefix length for the keys without the separator")
+	top          = flag.Int("top", 10, "Number of prefixes listed")
+	jsonOut      = flag.Bool("json", false, "Print the analysis as JSON")
+)
+
+func main() {
+	flag.Parse()
+	var walPath string
+	if err := config.StoragePaths(*configPath, &walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+
+	analysis, err := memdb.Analyze(*sstDir, memdb.AnalyzeOptions{Separator: *separator, PrefixLength: *prefixLength, TopPrefixes: *top})
+	if err != nil {
+		log.Fatalf("Error analyzing %s: %s", *sstDir, err)
+	}
+
+	if *jsonOut {
+		data, err := json.MarshalIndent(analysis, "", "  ")
+		if err != nil {
+			log.Fatalf("Error encoding analysis: %s", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("%d SSTables, %d entries, %d distinct keys, %d live keys, %d tombstones (%.1f%%)\n",
+		len(analysis.Tables), analysis.Entries, analysis.DistinctKeys, analysis.LiveKeys, analysis.Tombstones, 100*analysis.TombstoneRatio)
+	for _, table := range analysis.Tables {
+		fmt.Printf("  %s: %d bytes, %d entries, %d tombstones, %d shadowed, keys %q to %q\n",
+			table.Path, table.Size, table.Entries, table.Tombstones, table.Shadowed, table.SmallestKey, table.LargestKey)
+	}
+	printDistribution("Key lengths", analysis.KeyLengths)
+	printDistribution("Value sizes", analysis.ValueSizes)
+
+	fmt.Printf("%d distinct prefixes\n", analysis.DistinctPrefixes)
+	for _, prefix := range analysis.TopPrefixes {
+		fmt.Printf("  %-24q %d keys\n", prefix.Prefix, prefix.Keys)
+	}
+
+	fmt.Printf("%d overlapping SSTable pairs\n", len(analysis.Overlaps))
+	for _, overlap := range analysis.Overlaps {
+		fmt.Printf("  %s and %s: %d shared keys\n", overlap.Older, overlap.Newer, overlap.SharedKeys)
+	}
+}
+
+func printDistribution(name string, d memdb.Distribution) {
+	fmt.Printf("%s: min %d, mean %.1f, max %d, p50 <= %d, p90 <= %d, p99 <= %d\n", name, d.Min, d.Mean, d.Max, d.P50, d.P90, d.P99)
+	for _, bucket := range d.Buckets {
+		fmt.Printf("  <= %-10d %d\n", bucket.UpTo, bucket.Count)
+	}
+}
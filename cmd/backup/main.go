@@ -0,0 +1,102 @@
+// Command backup takes a consistent snapshot of a database into a new directory that can be opened directly,
+// or uploads it to an object store with -remote, only sending the SSTables the store doesn't have yet.
+// With -addr, the snapshot is taken by the running server through /admin/backup, without stopping it;
+// otherwise the database files are opened directly, and the server must not be running.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/memdb"
+	"StorageEngine/objstore"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	dest       = flag.String("dest", "", "Directory to write the backup to, must be empty or not exist")
+	remote     = flag.String("remote", "", "Object store to upload the backup to instead, s3://bucket/prefix, gs://bucket/prefix or a directory")
+	keep       = flag.Int("keep", 0, "With -remote, number of most recent backups to keep (0 to keep them all unless -max-age is set)")
+	maxAge     = flag.Duration("max-age", 0, "With -remote, age of the backups to keep (0 to keep them all unless -keep is set)")
+	addr       = flag.String("addr", "", "Address of a running server to ask for the backup, e.g. http://localhost:8080")
+	walPath    = flag.String("wal", "wal.log", "WAL of the database, when not going through a server")
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the database, when not going through a server")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal and -sstables paths from")
+)
+
+func main() {
+	flag.Parse()
+	if err := config.StoragePaths(*configPath, walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+	if (*dest == "") == (*remote == "") {
+		log.Fatal("One of -dest and -remote must be set")
+	}
+
+	if *addr != "" {
+		// The server writes the backup, relative paths are resolved against its working directory
+		query := url.Values{"dir": {*dest}}
+		if *remote != "" {
+			query = url.Values{"remote": {*remote}, "keep": {fmt.Sprint(*keep)}, "max_age": {maxAge.String()}}
+		}
+		resp, err := http.Post(strings.TrimSuffix(*addr, "/")+"/admin/backup?"+query.Encode(), "", nil)
+		if err != nil {
+			log.Fatalf("Error contacting server: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Backup failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		os.Stdout.Write(body)
+		return
+	}
+
+	wal, err := memdb.OpenWAL(*walPath)
+	if err != nil {
+		log.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, *sstDir)
+	if err != nil {
+		log.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	if *remote != "" {
+		backupRemote(db)
+		return
+	}
+	manifest, err := db.Backup(*dest)
+	if err != nil {
+		log.Fatalf("Backup failed: %s", err)
+	}
+	fmt.Printf("Backed up %d SSTables and %d WAL records to %s\n", len(manifest.SSTables), manifest.WALRecords, *dest)
+}
+
+// backupRemote uploads a backup to the -remote store, then deletes the backups the retention flags don't keep
+func backupRemote(db *memdb.DB) {
+	store, err := objstore.Open(*remote)
+	if err != nil {
+		log.Fatalf("Error opening object store: %s", err)
+	}
+	backup, err := db.BackupRemote(store)
+	if err != nil {
+		log.Fatalf("Backup failed: %s", err)
+	}
+	fmt.Printf("Backed up %d SSTables and %d WAL records to %s as %s, uploading %d files (%d bytes)\n",
+		len(backup.Manifest.SSTables), backup.Manifest.WALRecords, *remote, backup.ID, backup.Uploaded, backup.UploadedBytes)
+
+	if *keep > 0 || *maxAge > 0 {
+		deleted, err := memdb.PruneRemoteBackups(store, memdb.RetentionPolicy{Keep: *keep, MaxAge: *maxAge})
+		if err != nil {
+			log.Fatalf("Pruning old backups failed: %s", err)
+		}
+		fmt.Printf("Deleted %d old backups\n", len(deleted))
+	}
+}
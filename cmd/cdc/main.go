@@ -0,0 +1,58 @@
+// Command cdc publishes the changes written to the WAL of a database to a webhook or a NATS subject, for
+// downstream systems to mirror the store. It runs next to the server and keeps track of what it published
+// in a cursor file, so it resumes where it stopped and delivers every change at least once.
+package main
+
+import (
+	"StorageEngine/cdc"
+	"StorageEngine/config"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+)
+
+var (
+	walPath    = flag.String("wal", "wal.log", "WAL of the database")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal path from")
+	cursorPath = flag.String("cursor", "cdc.cursor", "File keeping track of the published changes")
+	webhook    = flag.String("webhook", "", "URL to post the changes to, as JSON arrays")
+	natsAddr   = flag.String("nats", "", "NATS server to publish the changes to, host:port")
+	subject    = flag.String("subject", "storage.changes", "NATS subject of the changes")
+	interval   = flag.Duration("interval", cdc.DefaultInterval, "Time between two reads of the WAL")
+	batchSize  = flag.Int("batch", cdc.DefaultBatchSize, "Maximum number of changes published at once")
+)
+
+func main() {
+	flag.Parse()
+	var sstDir string
+	if err := config.StoragePaths(*configPath, walPath, &sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+
+	var sink cdc.Sink
+	switch {
+	case *webhook != "" && *natsAddr != "":
+		log.Fatal("Only one of -webhook and -nats can be set")
+	case *webhook != "":
+		sink = &cdc.WebhookSink{URL: *webhook}
+	case *natsAddr != "":
+		natsSink := &cdc.NATSSink{Addr: *natsAddr, Subject: *subject}
+		defer natsSink.Close()
+		sink = natsSink
+	default:
+		log.Fatal("One of -webhook and -nats must be set")
+	}
+
+	publisher, err := cdc.New(cdc.Config{WALPath: *walPath, CursorPath: *cursorPath, Sink: sink, Interval: *interval, BatchSize: *batchSize})
+	if err != nil {
+		log.Fatalf("Error reading cursor: %s", err)
+	}
+	publisher.Start()
+	log.Printf("Publishing the changes of %s...", *walPath)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+	publisher.Close()
+}
@@ -0,0 +1,36 @@
+// Command compact merges all the SSTables of a closed database into one, dropping overwritten values and deleted keys,
+// e.g. to shrink a store before shipping it or after large deletions. The server must not be running.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/memdb"
+	"flag"
+	"fmt"
+	"log"
+)
+
+var (
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the database")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -sstables path from")
+	targetSize = flag.Int64("target-size", 0, "Split the output into SSTables of at most this many bytes (0 for a single SSTable)")
+)
+
+func main() {
+	flag.Parse()
+	var walPath string
+	if err := config.StoragePaths(*configPath, &walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+
+	report, err := memdb.CompactOffline(*sstDir, *targetSize)
+	if err != nil {
+		log.Fatalf("Error compacting %s: %s", *sstDir, err)
+	}
+	if len(report.Inputs) == 0 {
+		fmt.Println("No SSTables to compact")
+		return
+	}
+	fmt.Printf("Compacted %d SSTables (%d bytes, %d entries) into %v (%d bytes, %d entries), %d deleted keys dropped\n",
+		len(report.Inputs), report.InputBytes, report.InputEntries, report.Outputs, report.OutputBytes, report.OutputEntries, report.DroppedTombstones)
+}
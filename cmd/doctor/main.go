@@ -0,0 +1,52 @@
+// Command doctor checks the files of a closed database without modifying them and prints what it finds,
+// with what to do about it. The exit status is 0 when nothing worse than information was found,
+// 1 for warnings, 2 for errors, and 3 when the checks couldn't run.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/memdb"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	walPath    = flag.String("wal", "wal.log", "WAL of the database")
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the database")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal and -sstables paths from")
+	jsonOut    = flag.Bool("json", false, "Print the report as JSON")
+	exitCode   = map[string]int{"": 0, memdb.SeverityInfo: 0, memdb.SeverityWarning: 1, memdb.SeverityError: 2}
+)
+
+func main() {
+	flag.Parse()
+	if err := config.StoragePaths(*configPath, walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+
+	report, err := memdb.Doctor(*walPath, *sstDir)
+	if err != nil {
+		log.Printf("Checks failed: %s", err)
+		os.Exit(3)
+	}
+
+	if *jsonOut {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("Error encoding report: %s", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, finding := range report.Findings {
+			fmt.Printf("%-7s %s: %s\n", finding.Severity, finding.Path, finding.Problem)
+			if finding.Action != "" {
+				fmt.Printf("        -> %s\n", finding.Action)
+			}
+		}
+		fmt.Printf("Checked %d SSTables and %d WAL records: %d findings\n", report.Tables, report.WALRecords, len(report.Findings))
+	}
+	os.Exit(exitCode[report.Worst()])
+}
@@ -0,0 +1,132 @@
+// Command export writes the keys of a database, or those of a prefix or key range, as CSV or NDJSON.
+// With -addr, the keys are read from a running server through /scan; otherwise the database files are opened
+// directly, and the server must not be running. Either way the keys come from a single scan, which sees
+// the database as of one point in time.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/exporter"
+	"StorageEngine/memdb"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	format     = flag.String("format", exporter.FormatNDJSON, "Output format: csv or ndjson")
+	out        = flag.String("out", "", "Output file, standard output if empty")
+	prefix     = flag.String("prefix", "", "Only export keys starting with this prefix")
+	start      = flag.String("start", "", "Only export keys >= start")
+	end        = flag.String("end", "", "Only export keys < end")
+	b64        = flag.Bool("base64", false, "Encode values in base64")
+	b64Keys    = flag.Bool("base64-keys", false, "Encode keys in base64, for keys that aren't valid UTF-8")
+	addr       = flag.String("addr", "", "Address of a running server to export from, e.g. http://localhost:8080")
+	tenant     = flag.String("tenant", "", "Tenant to export, when the server hosts several")
+	apiKey     = flag.String("api-key", "", "API key of the tenant")
+	walPath    = flag.String("wal", "wal.log", "WAL of the database, when not going through a server")
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the database, when not going through a server")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal and -sstables paths from")
+)
+
+func main() {
+	flag.Parse()
+	if err := config.StoragePaths(*configPath, walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+	opts := memdb.ScanOptions{Prefix: *prefix, Start: *start, End: *end}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Error creating %s: %s", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	writer, err := exporter.NewWriter(w, exporter.Options{Format: *format, Base64: *b64, Base64Keys: *b64Keys})
+	if err != nil {
+		log.Fatalf("Error writing export: %s", err)
+	}
+	if *addr != "" {
+		err = scanServer(opts, writer)
+	} else {
+		err = scanFiles(opts, writer)
+	}
+	if err != nil {
+		log.Fatalf("Error exporting keys: %s", err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatalf("Error writing export: %s", err)
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d keys to %s\n", writer.Written(), *out)
+	}
+}
+
+// scanFiles opens the database files and writes the keys of an iterator over them as it reads them
+func scanFiles(opts memdb.ScanOptions, writer *exporter.Writer) error {
+	wal, err := memdb.OpenWAL(*walPath)
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, *sstDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	it, err := db.NewIterator(memdb.IteratorOptions{Prefix: opts.Prefix, Start: opts.Start, End: opts.End})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		if err := writer.Write(memdb.KeyValue{Key: it.Key(), Value: it.Value()}); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// scanServer scans the database of a running server through /scan and writes the keys as they are decoded from the
+// response. Keys and values are asked in base64, so that those that aren't valid UTF-8 survive the JSON response.
+func scanServer(opts memdb.ScanOptions, writer *exporter.Writer) error {
+	query := url.Values{"encoding": {"base64"}, "value_encoding": {"base64"}}
+	if opts.Prefix != "" {
+		query.Set("prefix", base64.StdEncoding.EncodeToString([]byte(opts.Prefix)))
+	}
+	if opts.Start != "" {
+		query.Set("start", base64.StdEncoding.EncodeToString([]byte(opts.Start)))
+	}
+	if opts.End != "" {
+		query.Set("end", base64.StdEncoding.EncodeToString([]byte(opts.End)))
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(*addr, "/")+"/scan?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if *tenant != "" {
+		req.Header.Set("X-Tenant", *tenant)
+	}
+	if *apiKey != "" {
+		req.Header.Set("X-API-Key", *apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return exporter.WriteScan(writer, resp.Body)
+}
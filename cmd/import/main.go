@@ -0,0 +1,68 @@
+// Command import bulk-loads the data of a LevelDB/RocksDB directory, a Redis RDB dump, or a CSV or NDJSON file
+// into a database, writing SSTables directly instead of replaying every key through the WAL.
+// With -offline, the SSTables are written without opening the database, which must not be running.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/importer"
+	"StorageEngine/memdb"
+	"flag"
+	"fmt"
+	"log"
+)
+
+var (
+	format     = flag.String("format", importer.FormatLevelDB, "Format of the source: leveldb (LevelDB or RocksDB directory), rdb (Redis dump), csv or ndjson")
+	source     = flag.String("source", "", "Source database directory or dump file")
+	redisDB    = flag.Int("redis-db", 0, "Redis database to import from an RDB dump")
+	b64        = flag.Bool("base64", false, "Values of CSV and NDJSON files are encoded in base64")
+	b64Keys    = flag.Bool("base64-keys", false, "Keys of CSV and NDJSON files are encoded in base64")
+	batchSize  = flag.Int("batch", 100000, "Maximum number of keys per ingested SSTable (0 for a single SSTable)")
+	offline    = flag.Bool("offline", false, "Write the SSTables directly, without opening the database or its WAL")
+	walPath    = flag.String("wal", "wal.log", "WAL of the destination database")
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the destination database")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal and -sstables paths from")
+)
+
+func main() {
+	flag.Parse()
+	if err := config.StoragePaths(*configPath, walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+	if *source == "" {
+		log.Fatal("Missing -source")
+	}
+	opts := importer.Options{Format: *format, Database: *redisDB, Base64: *b64, Base64Keys: *b64Keys}
+
+	if *offline {
+		kvs, report, err := importer.Read(*source, opts)
+		if err != nil {
+			log.Fatalf("Error reading %s: %s", *source, err)
+		}
+		tables, err := memdb.IngestOffline(*sstDir, kvs, *batchSize)
+		if err != nil {
+			log.Fatalf("Error writing SSTables: %s", err)
+		}
+		fmt.Printf("Imported %d keys from %s into %d SSTables (%d deleted, %d skipped)\n", report.Keys, report.Source, len(tables), report.Deleted, report.Skipped)
+		return
+	}
+
+	wal, err := memdb.OpenWAL(*walPath)
+	if err != nil {
+		log.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+
+	db, err := memdb.NewDB(wal, *sstDir)
+	if err != nil {
+		log.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	report, err := importer.Import(db, *source, opts, *batchSize)
+	if err != nil {
+		log.Fatalf("Error importing %s: %s", *source, err)
+	}
+	fmt.Printf("Imported %d keys from %s (%d deleted, %d skipped)\n", report.Keys, report.Source, report.Deleted, report.Skipped)
+}
@@ -0,0 +1,44 @@
+// Command migrate rewrites the SSTables and the WAL of a closed database from older format versions
+// to the current ones, verifying every checksum. The server must not be running.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/memdb"
+	"flag"
+	"fmt"
+	"log"
+)
+
+var (
+	walPath    = flag.String("wal", "wal.log", "WAL of the database")
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the database")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal and -sstables paths from")
+	dryRun     = flag.Bool("dry-run", false, "Only verify the files and report what would be rewritten")
+)
+
+func main() {
+	flag.Parse()
+	if err := config.StoragePaths(*configPath, walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+
+	report, err := memdb.Migrate(*walPath, *sstDir, *dryRun)
+	if err != nil {
+		log.Fatalf("Migration failed: %s", err)
+	}
+
+	rewritten := 0
+	for _, table := range report.Tables {
+		if table.Rewritten {
+			rewritten++
+			fmt.Printf("%s: version %d -> %d\n", table.Path, table.FromVersion, table.ToVersion)
+		}
+	}
+	verb := "Rewrote"
+	if *dryRun {
+		verb = "Would rewrite"
+	}
+	fmt.Printf("Verified %d SSTables and %d WAL records (WAL format %d). %s %d SSTables.\n",
+		report.TablesVerified, report.WALRecords, report.WALVersion, verb, rewritten)
+}
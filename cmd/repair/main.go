@@ -0,0 +1,56 @@
+// Command repair salvages the readable records of a closed database after disk trouble: corrupted SSTables are
+// rewritten with the entries that can still be decoded, the WAL is cut after its last readable record,
+// and the order of the SSTables is made unambiguous. The server must not be running.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/memdb"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	walPath    = flag.String("wal", "wal.log", "WAL of the database")
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the database")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal and -sstables paths from")
+	reportPath = flag.String("report", "", "File to write the JSON repair report to (printed on stdout if empty)")
+)
+
+func main() {
+	flag.Parse()
+	if err := config.StoragePaths(*configPath, walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+
+	report, err := memdb.Repair(*walPath, *sstDir)
+	if err != nil {
+		log.Printf("Repair failed: %s", err)
+	}
+
+	for _, table := range report.Tables {
+		line := fmt.Sprintf("%-8s %s (%d entries)", table.Status, table.Path, table.Entries)
+		if table.Error != "" {
+			line += ": " + table.Error
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("WAL: %d records kept, %d recovered past the stored offset, %d bytes cut\n",
+		report.WAL.Records, report.WAL.Recovered, report.WAL.TruncatedBytes)
+
+	data, jsonErr := json.MarshalIndent(report, "", "  ")
+	if jsonErr != nil {
+		log.Fatalf("Error encoding report: %s", jsonErr)
+	}
+	if *reportPath == "" {
+		fmt.Println(string(data))
+	} else if err := os.WriteFile(*reportPath, data, 0644); err != nil {
+		log.Fatalf("Error writing report: %s", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
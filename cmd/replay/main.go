@@ -0,0 +1,81 @@
+// Command replay executes the operations recorded by a server started with -record against a new database, to
+// reproduce a bug or compare the performance of two builds of the engine on the same workload, and prints how long
+// each kind of operation took compared with the recording and which operations returned another error.
+package main
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	recording = flag.String("recording", "", "Recording written by the server with -record")
+	dir       = flag.String("dir", "", "Directory of the new database, which must not exist (a temporary directory removed at the end if empty)")
+	threshold = flag.Int("threshold", memdb.DefaultThreshold, "Keys in the memtable before it is flushed, as set on the recorded server")
+	speed     = flag.Float64("speed", 0, "Pace of the replay: 1 for the recorded timing, 2 twice as fast (0 as fast as possible)")
+	asJSON    = flag.Bool("json", false, "Print the report as JSON")
+)
+
+func main() {
+	flag.Parse()
+	if *recording == "" {
+		log.Fatal("Missing -recording")
+	}
+	file, err := os.Open(*recording)
+	if err != nil {
+		log.Fatalf("Error opening the recording: %s", err)
+	}
+	defer file.Close()
+
+	// The operations are replayed on a new database, whatever they find is what they wrote
+	dbDir := *dir
+	if dbDir == "" {
+		if dbDir, err = os.MkdirTemp("", "replay"); err != nil {
+			log.Fatalf("Error creating the database directory: %s", err)
+		}
+		defer os.RemoveAll(dbDir)
+	} else if err := os.Mkdir(dbDir, 0755); err != nil {
+		log.Fatalf("Error creating the database directory: %s", err)
+	}
+	wal, err := memdb.OpenWAL(filepath.Join(dbDir, "wal.log"))
+	if err != nil {
+		log.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, filepath.Join(dbDir, "SSTableFiles"), memdb.Threshold(*threshold))
+	if err != nil {
+		log.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	report, err := memdb.Replay(db, file, memdb.ReplayOptions{Speed: *speed})
+	if err != nil {
+		log.Printf("Error replaying %s: %s", *recording, err)
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Printf("Replayed %d operations in %s\n", report.Ops, report.Duration)
+	ops := make([]string, 0, len(report.ByOp))
+	for op := range report.ByOp {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		stats := report.ByOp[op]
+		fmt.Printf("  %-12s %8d ops, %12s (recorded %s)\n", op, stats.Count, stats.Duration, stats.Recorded)
+	}
+	fmt.Printf("%d operations returned another error than when recorded\n", report.Diverged)
+	for _, divergence := range report.Divergences {
+		fmt.Println("  " + divergence)
+	}
+}
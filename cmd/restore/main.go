@@ -0,0 +1,77 @@
+// Command restore verifies a backup written by cmd/backup or /admin/backup against its manifest,
+// and installs it as a new database. The backup is read from a directory, or downloaded from an object store
+// with -remote. The server must not be running on the target files.
+package main
+
+import (
+	"StorageEngine/config"
+	"StorageEngine/memdb"
+	"StorageEngine/objstore"
+	"flag"
+	"fmt"
+	"log"
+)
+
+var (
+	from       = flag.String("from", "", "Backup directory to restore")
+	remote     = flag.String("remote", "", "Object store to restore from instead, s3://bucket/prefix, gs://bucket/prefix or a directory")
+	backupID   = flag.String("id", "", "With -remote, backup to restore, the most recent one if empty")
+	list       = flag.Bool("list", false, "With -remote, list the backups instead of restoring one")
+	walPath    = flag.String("wal", "wal.log", "WAL of the restored database, must not exist")
+	sstDir     = flag.String("sstables", "SSTableFiles", "SSTable directory of the restored database, must be empty or not exist")
+	configPath = flag.String("config", "", "Configuration file of the server to take the -wal and -sstables paths from")
+	verifyOnly = flag.Bool("verify", false, "Only verify the backup, without restoring it")
+)
+
+func main() {
+	flag.Parse()
+	if err := config.StoragePaths(*configPath, walPath, sstDir); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+	if *remote != "" {
+		restoreRemote()
+		return
+	}
+	if *from == "" {
+		log.Fatal("Missing -from")
+	}
+
+	if *verifyOnly {
+		manifest, err := memdb.VerifyBackup(*from)
+		if err != nil {
+			log.Fatalf("Verification failed: %s", err)
+		}
+		fmt.Printf("Backup of %s is valid: %d SSTables and %d WAL records\n", manifest.Time.Format("2006-01-02 15:04:05"), len(manifest.SSTables), manifest.WALRecords)
+		return
+	}
+
+	manifest, err := memdb.Restore(*from, *walPath, *sstDir)
+	if err != nil {
+		log.Fatalf("Restore failed: %s", err)
+	}
+	fmt.Printf("Restored the backup of %s: %d SSTables into %s and %d WAL records into %s\n", manifest.Time.Format("2006-01-02 15:04:05"), len(manifest.SSTables), *sstDir, manifest.WALRecords, *walPath)
+}
+
+// restoreRemote lists or restores the backups of the -remote store
+func restoreRemote() {
+	store, err := objstore.Open(*remote)
+	if err != nil {
+		log.Fatalf("Error opening object store: %s", err)
+	}
+	if *list {
+		backups, err := memdb.ListRemoteBackups(store)
+		if err != nil {
+			log.Fatalf("Error listing backups: %s", err)
+		}
+		for _, backup := range backups {
+			fmt.Printf("%s\t%s\t%d SSTables\t%d WAL records\n", backup.ID, backup.Manifest.Time.Format("2006-01-02 15:04:05"), len(backup.Manifest.SSTables), backup.Manifest.WALRecords)
+		}
+		return
+	}
+
+	manifest, err := memdb.RestoreRemote(store, *backupID, *walPath, *sstDir)
+	if err != nil {
+		log.Fatalf("Restore failed: %s", err)
+	}
+	fmt.Printf("Restored the backup of %s: %d SSTables into %s and %d WAL records into %s\n", manifest.Time.Format("2006-01-02 15:04:05"), len(manifest.SSTables), *sstDir, manifest.WALRecords, *walPath)
+}
@@ -0,0 +1,175 @@
+// Command storagecli talks to a running server over HTTP. With a command on the command line it runs it and exits,
+// otherwise it reads commands from a prompt:
+//
+//	get <key>
+//	set <key> <value>
+//	del <key>
+//	scan [prefix] [limit]
+//	stats
+//
+// Arguments may be quoted with double quotes to hold spaces.
+package main
+
+import (
+	"StorageEngine/client"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	addr   = flag.String("addr", "http://localhost:8080", "Address of the server")
+	tenant = flag.String("tenant", "", "Tenant to address on a multi-tenant server")
+	apiKey = flag.String("api-key", "", "API key of the tenant")
+)
+
+// run executes a command with c and writes its result to out
+func run(c *client.Client, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	usage := errors.New("Usage: get <key> | set <key> <value> | del <key> | scan [prefix] [limit] | stats")
+	switch args[0] {
+	case "get":
+		if len(args) != 2 {
+			return usage
+		}
+		value, err := c.Get(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(value))
+	case "set":
+		if len(args) != 3 {
+			return usage
+		}
+		if err := c.Set(ctx, args[1], []byte(args[2])); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "OK")
+	case "del":
+		if len(args) != 2 {
+			return usage
+		}
+		value, err := c.Delete(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(value))
+	case "scan":
+		if len(args) > 3 {
+			return usage
+		}
+		var opts client.ScanOptions
+		if len(args) > 1 {
+			opts.Prefix = args[1]
+		}
+		if len(args) > 2 {
+			limit, err := strconv.Atoi(args[2])
+			if err != nil {
+				return usage
+			}
+			opts.Limit = limit
+		}
+		results, err := c.Scan(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			fmt.Fprintf(out, "%s\t%s\n", result.Key, result.Value)
+		}
+		fmt.Fprintf(out, "(%d keys)\n", len(results))
+	case "stats":
+		if len(args) != 1 {
+			return usage
+		}
+		stats, err := c.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+	default:
+		return usage
+	}
+	return nil
+}
+
+// splitArgs splits a command line on spaces, keeping double-quoted strings together
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == '\\' && quoted && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("Unterminated quoted string")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+func main() {
+	flag.Parse()
+	c := client.New(*addr, client.Tenant(*tenant, *apiKey))
+
+	// One-shot command
+	if flag.NArg() > 0 {
+		if err := run(c, flag.Args(), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Interactive prompt
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		args, err := splitArgs(scanner.Text())
+		if err == nil && len(args) > 0 && (args[0] == "quit" || args[0] == "exit") {
+			return
+		}
+		if err == nil {
+			err = run(c, args, os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Command waldump prints the metadata and the records of a WAL file, without modifying it,
+// to help debugging recovery issues.
+package main
+
+import (
+	"StorageEngine/memdb"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+var (
+	showValues = flag.Bool("values", false, "Print the values, quoted, in addition to their sizes")
+	pending    = flag.Bool("pending", false, "Only print the records above the watermark, which a recovery would replay")
+)
+
+// operationName returns the name of a WAL operation
+func operationName(op memdb.Operation) string {
+	switch op {
+	case memdb.OpSet:
+		return "SET"
+	case memdb.OpDel:
+		return "DEL"
+	case memdb.OpDelRange:
+		return "DELRANGE"
+	case memdb.OpBatch:
+		return "BATCH"
+	default:
+		return fmt.Sprintf("OP(%d)", op)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] wal.log\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Records carry no sequence number or timestamp on disk: the sequence number printed is the index of the record
+	fmt.Printf("%-8s %-12s %-4s %-8s %-10s %s\n", "SEQ", "POSITION", "OP", "STATE", "VALUE_LEN", "KEY")
+	records, flushed := 0, 0
+	meta, err := memdb.ScanWALFile(flag.Arg(0), func(entry memdb.WALEntry) error {
+		records++
+		state := "pending"
+		if entry.Flushed {
+			flushed++
+			state = "flushed"
+			if *pending {
+				return nil
+			}
+		}
+		line := fmt.Sprintf("%-8d %-12d %-4s %-8s %-10d %s", entry.Seq, entry.Position, operationName(entry.Operation), state, len(entry.Value), strconv.Quote(string(entry.Key)))
+		if *showValues && entry.Operation != memdb.OpBatch {
+			line += " " + strconv.Quote(string(entry.Value))
+		}
+		fmt.Println(line)
+
+		// The writes of a transaction follow its record, indented
+		if entry.Operation == memdb.OpBatch {
+			batch, err := memdb.DecodeBatch(entry.WALRecord)
+			if err != nil {
+				return err
+			}
+			for _, record := range batch {
+				line := fmt.Sprintf("%-8s %-12s %-4s %-8s %-10d %s", "", "", operationName(record.Operation), "", len(record.Value), strconv.Quote(string(record.Key)))
+				if *showValues {
+					line += " " + strconv.Quote(string(record.Value))
+				}
+				fmt.Println(line)
+			}
+		}
+		return nil
+	})
+
+	fmt.Printf("\nOffset: %d  Watermark: %d  Records: %d (%d flushed, %d pending)\n", meta.Offset, meta.Watermark, records, flushed, records-flushed)
+	if err != nil {
+		log.Fatalf("Error reading WAL: %s", err)
+	}
+}
@@ -0,0 +1,349 @@
+// Package config loads the configuration shared by the server and the command-line tools from a TOML file,
+// applying defaults and validating the values.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Addr     string `toml:"addr"`      // Address to listen on
+	TLSCert  string `toml:"tls_cert"`  // Certificate file, HTTPS is served when it is set with TLSKey
+	TLSKey   string `toml:"tls_key"`   // Private key file of the certificate
+	AuditLog string `toml:"audit_log"` // File recording who changed which key, disabled if empty
+	Record   string `toml:"record"`    // File the operations on the keys are recorded to for cmd/replay, disabled if empty
+}
+
+// StorageConfig configures the files and the engine of a database
+type StorageConfig struct {
+	WAL            string        `toml:"wal"`              // WAL file of the database
+	SSTables       string        `toml:"sstables"`         // SSTable directory of the database
+	Threshold      int           `toml:"threshold"`        // Keys in the memtable before it is flushed
+	MinFreeSpace   uint64        `toml:"min_free_space"`   // Refuse writes below this many free bytes, 0 to disable
+	Scrub          time.Duration `toml:"scrub"`            // Interval between background checksum verifications, 0 to disable
+	Warmup         int           `toml:"warmup"`           // SSTables read at the same time by the startup warmup, 0 to disable
+	TargetFileSize int64         `toml:"target_file_size"` // Bytes the SSTables written by flushes and compactions are split at, 0 for no limit
+	Follow         time.Duration `toml:"follow"`           // Refresh interval when serving reads as a follower of another server, 0 for the writer
+	MaxTableAge    time.Duration `toml:"max_table_age"`    // Age of the oldest SSTable that triggers a compaction of all of them, 0 to disable
+	TombstoneGrace time.Duration `toml:"tombstone_grace"`  // Time deletion markers are kept after their flush before compactions drop them
+	CompactMin     int           `toml:"compact_min"`      // SSTables that trigger a compaction, 2 if 0
+	CompactMax     int           `toml:"compact_max"`      // Oldest SSTables merged at once by a compaction, compact_min if lower
+	AutoCompact    bool          `toml:"auto_compact"`     // Compact after every flush that leaves compact_min SSTables or more
+	LookupWorkers  int           `toml:"lookup_workers"`   // SSTables probed at the same time by a lookup, 0 or 1 to probe them in turn
+	ValueCache     int64         `toml:"value_cache"`      // Bytes of values recently read from the SSTables kept in memory, 0 to disable
+	MaxKeySize     int           `toml:"max_key_size"`     // Longest key accepted by writes, 64 KiB if 0
+	MaxValueSize   int           `toml:"max_value_size"`   // Longest value accepted by writes, 16 MiB if 0
+	DirectIO       bool          `toml:"direct_io"`        // Read whole SSTables around the page cache, for compactions and scans
+	SyncWrites     bool          `toml:"sync_writes"`      // Sync the WAL before acknowledging each write, unless the request says otherwise
+	KeyMode        string        `toml:"key_mode"`         // Keys accepted: "binary" (the default), "utf8" or "escaped"
+	Checksum       string        `toml:"checksum"`         // Checksum of the SSTables written: "crc32" (the default), "crc32c" or "xxhash64"
+	ReadAhead      int64         `toml:"read_ahead"`       // Bytes of SSTables opened in the background ahead of a scan, 0 to disable
+	Indexes        []string      `toml:"indexes"`          // JSON paths of the secondary indexes, e.g. "user.email"
+	FullText       []string      `toml:"full_text"`        // JSON paths of the fields indexed for full-text search, e.g. "title"
+	TimeWindow     time.Duration `toml:"time_window"`      // Span of the time keys of an SSTable in time-series mode, 0 to disable
+	Retention      time.Duration `toml:"retention"`        // Time-series windows ending longer ago than this are dropped, 0 to keep them
+}
+
+// StatsConfig configures the access statistics
+type StatsConfig struct {
+	HotKeys       int `toml:"hot_keys"`        // Keys reported by /admin/hotkeys, 0 to disable
+	HotKeysSample int `toml:"hot_keys_sample"` // Accesses sampled by the hot key tracker, 1 out of HotKeysSample
+	AccessStats   int `toml:"access_stats"`    // Keys reported by /stats/hotkeys, 0 to disable
+}
+
+// TenantsConfig configures the multi-tenant mode
+type TenantsConfig struct {
+	DataDir    string   `toml:"data_dir"`    // Directory holding one sub-directory per tenant
+	List       []string `toml:"list"`        // Tenants to host, as name or name:apikey, single database if empty
+	QuotaKeys  int64    `toml:"quota_keys"`  // Maximum number of keys per tenant, 0 for no limit
+	QuotaBytes int64    `toml:"quota_bytes"` // Maximum number of bytes per tenant, 0 for no limit
+	QuotaRate  int      `toml:"quota_rate"`  // Requests per second allowed per tenant on average, 0 for no limit
+	QuotaBurst int      `toml:"quota_burst"` // Requests allowed at once above the rate, one second of requests if 0
+}
+
+// ObjectStoreConfig configures the copy of the SSTables in an object store, a directory or an S3-compatible bucket
+type ObjectStoreConfig struct {
+	Dir       string        `toml:"dir"`        // Directory used as object store, e.g. a network file system mount
+	Endpoint  string        `toml:"endpoint"`   // Base URL of the S3-compatible service, e.g. https://storage.googleapis.com
+	Region    string        `toml:"region"`     // Region the requests are signed for, us-east-1 if empty
+	Bucket    string        `toml:"bucket"`     // Bucket holding the SSTables
+	Prefix    string        `toml:"prefix"`     // Prepended to the object names, tenants add their name and a slash
+	AccessKey string        `toml:"access_key"` // Access key ID, $AWS_ACCESS_KEY_ID if empty
+	SecretKey string        `toml:"secret_key"` // Secret access key, $AWS_SECRET_ACCESS_KEY if empty
+	CacheSize int64         `toml:"cache_size"` // Bytes of recently read blocks kept in memory, 64 MiB if 0
+	ColdAfter time.Duration `toml:"cold_after"` // Age after which rarely read SSTables only stay in the object store, 0 to keep them local
+	HotReads  uint64        `toml:"hot_reads"`  // Lookups per tiering pass that keep an SSTable local, or bring it back
+}
+
+// ReplicationConfig configures the asynchronous replication with a peer server, each side taking writes
+type ReplicationConfig struct {
+	NodeID   string        `toml:"node_id"`   // Unique ID of this server, replication is disabled if empty
+	Peer     string        `toml:"peer"`      // Base URL of the peer server, e.g. https://eu.example.com:8080
+	Token    string        `toml:"token"`     // Secret shared by both servers, sent with every batch of changes
+	StateDir string        `toml:"state_dir"` // Directory of the versions and of the changes waiting for the peer, replication/ next to the WAL if empty
+	Interval time.Duration `toml:"interval"`  // Time between two shipments to the peer, 1 second if 0
+}
+
+// ReplicaConfig configures the read-only replicas of a primary server, on the primary and on the replicas
+type ReplicaConfig struct {
+	Primary  string        `toml:"primary"`  // Base URL of the primary to replicate, this server then only serves reads; empty on the primary
+	Token    string        `toml:"token"`    // Secret of the snapshot and WAL endpoints, which the primary only serves when it is set
+	Interval time.Duration `toml:"interval"` // Time between two reads of the WAL of the primary, 1 second if 0
+}
+
+// FailoverConfig configures the election of the server taking the writes among a primary and its replicas
+type FailoverConfig struct {
+	NodeID    string        `toml:"node_id"`    // Unique ID of this server
+	Address   string        `toml:"address"`    // Base URL of this server, clients are redirected to it while it leads
+	LeaseFile string        `toml:"lease_file"` // Lease file on a volume shared by the servers, failover is disabled if empty
+	TTL       time.Duration `toml:"ttl"`        // Validity of the lease, a leader that can't renew it for half of it stops taking writes
+}
+
+// ConsistencyConfig configures the consistency levels clients can ask for in the X-Consistency header
+type ConsistencyConfig struct {
+	SyncReplicas int           `toml:"sync_replicas"` // Replicas that must apply a write sent with "sync" before it is acknowledged, 1 if 0
+	SyncTimeout  time.Duration `toml:"sync_timeout"`  // Time a "sync" write waits for them before answering 202, 5 seconds if 0
+}
+
+// IdempotencyConfig configures the answers remembered for the writes sent with an Idempotency-Key header
+type IdempotencyConfig struct {
+	TTL  time.Duration `toml:"ttl"`  // Time an answer is replayed to the retries of a write, 24 hours if 0
+	Keys int           `toml:"keys"` // Answers remembered at most, the oldest are forgotten first, 10000 if 0
+}
+
+// Config is the whole configuration, one field per section of the file
+type Config struct {
+	Server      ServerConfig      `toml:"server"`
+	Storage     StorageConfig     `toml:"storage"`
+	Stats       StatsConfig       `toml:"stats"`
+	Tenants     TenantsConfig     `toml:"tenants"`
+	ObjectStore ObjectStoreConfig `toml:"object_store"`
+	Replication ReplicationConfig `toml:"replication"`
+	Replica     ReplicaConfig     `toml:"replica"`
+	Failover    FailoverConfig    `toml:"failover"`
+	Consistency ConsistencyConfig `toml:"consistency"`
+	Idempotency IdempotencyConfig `toml:"idempotency"`
+}
+
+// Default returns the configuration used when no file is given
+func Default() Config {
+	return Config{
+		Server:  ServerConfig{Addr: ":8080"},
+		Storage: StorageConfig{WAL: "wal.log", SSTables: "SSTableFiles", Threshold: 5},
+		Stats:   StatsConfig{HotKeys: 10, HotKeysSample: 1, AccessStats: 10},
+		Tenants: TenantsConfig{DataDir: "tenants"},
+	}
+}
+
+// Load reads the configuration file at path over the defaults and validates the result.
+// Unknown keys are errors, so typos don't go unnoticed.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	file, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer file.Close()
+
+	values, err := parseTOML(file)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := bind(reflect.ValueOf(&cfg).Elem(), "", values); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(values) > 0 {
+		unknown := make([]string, 0, len(values))
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		return cfg, fmt.Errorf("%s: unknown settings %s", path, strings.Join(unknown, ", "))
+	}
+	return cfg, cfg.Validate()
+}
+
+// bind sets the fields of v from values, removing the values it uses
+func bind(v reflect.Value, prefix string, values map[string]interface{}) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		key := prefix + v.Type().Field(i).Tag.Get("toml")
+		if field.Kind() == reflect.Struct {
+			if err := bind(field, key+".", values); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		delete(values, key)
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// setField sets a field from a parsed value of the matching type
+func setField(field reflect.Value, value interface{}) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		s, ok := value.(string)
+		if !ok {
+			return errors.New("expected a duration such as \"30s\"")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return errors.New("expected a string")
+		}
+		field.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return errors.New("expected true or false")
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, ok := value.(int64)
+		if !ok {
+			return errors.New("expected an integer")
+		}
+		field.SetInt(n)
+	case reflect.Uint64:
+		n, ok := value.(int64)
+		if !ok || n < 0 {
+			return errors.New("expected a positive integer")
+		}
+		field.SetUint(uint64(n))
+	case reflect.Slice:
+		list, ok := value.([]string)
+		if !ok {
+			return errors.New("expected an array of strings")
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate checks that the values make sense together
+func (c Config) Validate() error {
+	switch {
+	case c.Server.Addr == "":
+		return errors.New("server.addr must be set")
+	case (c.Server.TLSCert == "") != (c.Server.TLSKey == ""):
+		return errors.New("server.tls_cert and server.tls_key must be set together")
+	case c.Storage.WAL == "" || c.Storage.SSTables == "":
+		return errors.New("storage.wal and storage.sstables must be set")
+	case c.Storage.Threshold <= 0:
+		return errors.New("storage.threshold must be positive")
+	case c.Storage.Scrub < 0 || c.Storage.Warmup < 0 || c.Storage.Follow < 0 || c.Storage.TargetFileSize < 0 || c.Storage.MaxTableAge < 0 || c.Storage.LookupWorkers < 0 || c.Storage.ValueCache < 0 || c.Storage.ReadAhead < 0:
+		return errors.New("storage.scrub, storage.warmup, storage.follow, storage.target_file_size, storage.max_table_age, storage.lookup_workers, storage.value_cache and storage.read_ahead can't be negative")
+	case c.Storage.TombstoneGrace < 0:
+		return errors.New("storage.tombstone_grace can't be negative")
+	case c.Storage.TimeWindow < 0 || c.Storage.Retention < 0:
+		return errors.New("storage.time_window and storage.retention can't be negative")
+	case c.Storage.Retention > 0 && c.Storage.TimeWindow == 0:
+		return errors.New("storage.retention requires storage.time_window")
+	case c.Storage.MaxKeySize < 0 || c.Storage.MaxValueSize < 0:
+		return errors.New("storage.max_key_size and storage.max_value_size can't be negative")
+	case c.Storage.KeyMode != "" && c.Storage.KeyMode != "binary" && c.Storage.KeyMode != "utf8" && c.Storage.KeyMode != "escaped":
+		return errors.New(`storage.key_mode must be "binary", "utf8" or "escaped"`)
+	case c.Storage.Checksum != "" && c.Storage.Checksum != "crc32" && c.Storage.Checksum != "crc32c" && c.Storage.Checksum != "xxhash64":
+		return errors.New(`storage.checksum must be "crc32", "crc32c" or "xxhash64"`)
+	case c.Storage.CompactMin < 0 || c.Storage.CompactMin == 1 || c.Storage.CompactMax < 0:
+		return errors.New("storage.compact_min must be at least 2 and storage.compact_max can't be negative")
+	case c.Storage.Follow > 0 && len(c.Tenants.List) > 0:
+		return errors.New("storage.follow can't be used with tenants")
+	case c.Server.Record != "" && len(c.Tenants.List) > 0:
+		return errors.New("server.record can't be used with tenants")
+	case c.Stats.HotKeys < 0 || c.Stats.AccessStats < 0 || c.Stats.HotKeysSample < 0:
+		return errors.New("stats settings can't be negative")
+	case c.Stats.HotKeys > 0 && c.Stats.HotKeysSample == 0:
+		return errors.New("stats.hot_keys_sample must be positive when stats.hot_keys is")
+	case c.Tenants.QuotaKeys < 0 || c.Tenants.QuotaBytes < 0 || c.Tenants.QuotaRate < 0 || c.Tenants.QuotaBurst < 0:
+		return errors.New("tenants quotas can't be negative")
+	case len(c.Tenants.List) > 0 && c.Tenants.DataDir == "":
+		return errors.New("tenants.data_dir must be set to host tenants")
+	case c.ObjectStore.Dir != "" && c.ObjectStore.Bucket != "":
+		return errors.New("only one of object_store.dir and object_store.bucket can be set")
+	case c.ObjectStore.Bucket != "" && c.ObjectStore.Endpoint == "":
+		return errors.New("object_store.endpoint must be set with object_store.bucket")
+	case c.ObjectStore.CacheSize < 0 || c.ObjectStore.ColdAfter < 0:
+		return errors.New("object_store.cache_size and object_store.cold_after can't be negative")
+	case c.ObjectStore.Enabled() && c.Storage.Follow > 0:
+		return errors.New("object_store can't be used by a follower")
+	case c.Replication.NodeID != "" && c.Replication.Peer == "":
+		return errors.New("replication.peer must be set with replication.node_id")
+	case c.Replication.NodeID != "" && (c.Storage.Follow > 0 || len(c.Tenants.List) > 0):
+		return errors.New("replication can't be used by a follower or with tenants")
+	case c.Replication.Interval < 0 || c.Replica.Interval < 0:
+		return errors.New("replication.interval and replica.interval can't be negative")
+	case c.Replica.Primary != "" && (c.Storage.Follow > 0 || len(c.Tenants.List) > 0 || c.Replication.NodeID != ""):
+		return errors.New("replica.primary can't be used by a follower, with tenants or with replication")
+	case c.Failover.LeaseFile != "" && (c.Failover.NodeID == "" || c.Failover.Address == "" || c.Replica.Token == ""):
+		return errors.New("failover.node_id, failover.address and replica.token must be set with failover.lease_file")
+	case c.Failover.LeaseFile != "" && (c.Storage.Follow > 0 || len(c.Tenants.List) > 0 || c.Replication.NodeID != ""):
+		return errors.New("failover can't be used by a follower, with tenants or with replication")
+	case c.Failover.TTL < 0:
+		return errors.New("failover.ttl can't be negative")
+	case c.Idempotency.TTL < 0 || c.Idempotency.Keys < 0:
+		return errors.New("idempotency.ttl and idempotency.keys can't be negative")
+	case c.Consistency.SyncReplicas < 0 || c.Consistency.SyncTimeout < 0:
+		return errors.New("consistency.sync_replicas and consistency.sync_timeout can't be negative")
+	}
+	for _, spec := range c.Tenants.List {
+		if name, _, _ := strings.Cut(spec, ":"); strings.TrimSpace(name) == "" {
+			return fmt.Errorf("tenant %q has no name", spec)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether an object store is configured
+func (c ObjectStoreConfig) Enabled() bool {
+	return c.Dir != "" || c.Bucket != ""
+}
+
+// StoragePaths loads the configuration file at path, if not empty, into walPath and sstableDir,
+// unless the -wal or -sstables flags were set on the command line, which take precedence.
+// It lets the command-line tools share the paths of the server.
+func StoragePaths(path string, walPath *string, sstableDir *string) error {
+	if path == "" {
+		return nil
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["wal"] {
+		*walPath = cfg.Storage.WAL
+	}
+	if !set["sstables"] {
+		*sstableDir = cfg.Storage.SSTables
+	}
+	return nil
+}
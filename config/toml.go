@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML used by configuration files: [section] headers, key = value pairs
+// where the value is a double-quoted string, an integer, a boolean or a single-line array of strings,
+// and # comments. It returns the values by "section.key", or "key" before the first section.
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header", line)
+			}
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if section == "" {
+				return nil, fmt.Errorf("line %d: empty section name", line)
+			}
+			continue
+		}
+
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key", line)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is set twice", line, key)
+		}
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", line, key, err)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// stripComment removes a # comment from a line, leaving # inside strings alone
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && quoted:
+			i++
+		case line[i] == '"':
+			quoted = !quoted
+		case line[i] == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseValue parses a string, integer, boolean or array of strings
+func parseValue(raw string) (interface{}, error) {
+	switch {
+	case raw == "":
+		return nil, fmt.Errorf("missing value")
+	case raw == "true" || raw == "false":
+		return raw == "true", nil
+	case strings.HasPrefix(raw, "\""):
+		s, rest, err := parseString(raw)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after string", rest)
+		}
+		return s, nil
+	case strings.HasPrefix(raw, "["):
+		return parseArray(raw)
+	default:
+		n, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", raw)
+		}
+		return n, nil
+	}
+}
+
+// parseString parses the double-quoted string at the start of raw and returns it with what follows it
+func parseString(raw string) (string, string, error) {
+	for i := 1; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '"':
+			s, err := strconv.Unquote(raw[:i+1])
+			return s, raw[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+// parseArray parses a single-line array of strings
+func parseArray(raw string) ([]string, error) {
+	rest := strings.TrimSpace(raw[1:])
+	values := make([]string, 0)
+	for {
+		if strings.HasPrefix(rest, "]") {
+			if strings.TrimSpace(rest[1:]) != "" {
+				return nil, fmt.Errorf("unexpected %q after array", rest[1:])
+			}
+			return values, nil
+		}
+		if !strings.HasPrefix(rest, "\"") {
+			return nil, fmt.Errorf("arrays may only hold strings")
+		}
+		s, after, err := parseString(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, fmt.Errorf("unterminated array")
+		}
+	}
+}
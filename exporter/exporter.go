@@ -0,0 +1,140 @@
+// Package exporter writes key-value pairs of a database as CSV or newline-delimited JSON.
+package exporter
+
+import (
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// KeyValue is a key with its value, as returned by a scan
+type KeyValue = memdb.KeyValue
+
+// Output formats
+const (
+	FormatCSV    = "csv"    // A key,value header, then one row per key
+	FormatNDJSON = "ndjson" // One {"key": ..., "value": ...} object per line
+)
+
+// Options select how keys and values are written
+type Options struct {
+	Format     string
+	Base64     bool // Encode values in base64, for values that aren't text
+	Base64Keys bool // Encode keys in base64, for keys that aren't valid UTF-8
+}
+
+// record is a line of an NDJSON export
+type record struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Writer writes key-value pairs one at a time, so that an export doesn't hold the keys it writes in memory
+type Writer struct {
+	opts    Options
+	csv     *csv.Writer
+	json    *json.Encoder
+	buffer  *bufio.Writer
+	written int
+}
+
+// NewWriter returns a Writer writing to w in the format of opts. The CSV header is written with the first Flush at the
+// latest.
+func NewWriter(w io.Writer, opts Options) (*Writer, error) {
+	writer := &Writer{opts: opts}
+	switch opts.Format {
+	case FormatCSV:
+		writer.csv = csv.NewWriter(w)
+		if err := writer.csv.Write([]string{"key", "value"}); err != nil {
+			return nil, err
+		}
+	case FormatNDJSON:
+		writer.buffer = bufio.NewWriter(w)
+		writer.json = json.NewEncoder(writer.buffer)
+	default:
+		return nil, fmt.Errorf("Unknown export format %q", opts.Format)
+	}
+	return writer, nil
+}
+
+// Write writes the next key-value pair
+func (w *Writer) Write(kv KeyValue) error {
+	key, value := kv.Key, string(kv.Value)
+	if w.opts.Base64Keys {
+		key = base64.StdEncoding.EncodeToString([]byte(kv.Key))
+	}
+	if w.opts.Base64 {
+		value = base64.StdEncoding.EncodeToString(kv.Value)
+	}
+	var err error
+	if w.csv != nil {
+		err = w.csv.Write([]string{key, value})
+	} else {
+		err = w.json.Encode(record{Key: key, Value: value})
+	}
+	if err == nil {
+		w.written++
+	}
+	return err
+}
+
+// Written returns the number of keys written
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Flush writes any buffered data to the underlying writer
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return w.buffer.Flush()
+}
+
+// Write writes kvs to w in the format of opts, in the order given, and returns the number of keys written
+func Write(w io.Writer, kvs []KeyValue, opts Options) (int, error) {
+	writer, err := NewWriter(w, opts)
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range kvs {
+		if err := writer.Write(kv); err != nil {
+			return writer.Written(), err
+		}
+	}
+	return writer.Written(), writer.Flush()
+}
+
+// WriteScan writes the key-value pairs of a /scan response, asked with encoding=base64 and value_encoding=base64, as
+// they are decoded from r, so that the response isn't held in memory
+func WriteScan(w *Writer, r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	if _, err := decoder.Token(); err != nil { // [
+		return err
+	}
+	for decoder.More() {
+		var result handlers.ScanResult
+		if err := decoder.Decode(&result); err != nil {
+			return err
+		}
+		key, err := base64.StdEncoding.DecodeString(result.Key)
+		if err != nil {
+			return err
+		}
+		value, err := base64.StdEncoding.DecodeString(result.Value)
+		if err != nil {
+			return err
+		}
+		if err := w.Write(KeyValue{Key: string(key), Value: value}); err != nil {
+			return err
+		}
+	}
+	_, err := decoder.Token() // ]
+	return err
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"StorageEngine/objstore"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func BackupHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if remote := r.URL.Query().Get("remote"); remote != "" {
+			backupRemote(w, r, db, remote)
+			return
+		}
+		dir := r.URL.Query().Get("dir")
+		if dir == "" {
+			http.Error(w, "Backup directory not provided", http.StatusBadRequest)
+			return
+		}
+
+		manifest, err := db.Backup(dir)
+		if err == memdb.ErrBackupDirNotEmpty {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Backup failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(manifest)
+	}
+}
+
+// backupRemote uploads a backup to the object store at location, with the credentials of the server,
+// then applies the retention policy given by the keep and max_age parameters, if any
+func backupRemote(w http.ResponseWriter, r *http.Request, db *memdb.DB, location string) {
+	var policy memdb.RetentionPolicy
+	var err error
+	if keep := r.URL.Query().Get("keep"); keep != "" {
+		if policy.Keep, err = strconv.Atoi(keep); err != nil || policy.Keep < 0 {
+			http.Error(w, "Invalid keep", http.StatusBadRequest)
+			return
+		}
+	}
+	if maxAge := r.URL.Query().Get("max_age"); maxAge != "" {
+		if policy.MaxAge, err = time.ParseDuration(maxAge); err != nil || policy.MaxAge < 0 {
+			http.Error(w, "Invalid max_age", http.StatusBadRequest)
+			return
+		}
+	}
+	store, err := objstore.Open(location)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backup, err := db.BackupRemote(store)
+	if err != nil {
+		http.Error(w, "Backup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if policy.Keep > 0 || policy.MaxAge > 0 {
+		if _, err := memdb.PruneRemoteBackups(store, policy); err != nil {
+			http.Error(w, "Pruning old backups failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(backup)
+}
+
+func RegisterBackupHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/admin/backup", BackupHandler(db))
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"net/http"
+)
+
+// sentWriter remembers whether anything was written to the response
+type sentWriter struct {
+	http.ResponseWriter
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.ResponseWriter.Write(p)
+}
+
+// BlobHandler streams the value of ?key= without holding it in memory: PUT stores the request body, of any size,
+// through memdb.DB.SetReader, GET answers the raw value through memdb.DB.GetWriter, or 304 Not Modified for a
+// request whose If-None-Match holds its ETag
+func BlobHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			if err := db.SetReader(key, r.Body); err != nil {
+				setError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodGet:
+			if notModified(w, r, db, key) {
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			sw := &sentWriter{ResponseWriter: w}
+			err := db.GetWriter(key, sw)
+			switch {
+			case err == nil:
+			case sw.sent:
+				// The status is gone with the first bytes, the client must see a truncated answer
+				panic(http.ErrAbortHandler)
+			case err == memdb.ErrKeyNotFound:
+				http.Error(w, "Key not found", http.StatusNotFound)
+			case isInvalidKey(err):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func RegisterBlobHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/blob", BlobHandler(db))
+}
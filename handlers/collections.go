@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// readElement returns the body of the request, an element of a collection, at most as large as a value. ok is false
+// if it couldn't be read, which was answered.
+func readElement(w http.ResponseWriter, r *http.Request, db *memdb.DB) (element []byte, ok bool) {
+	_, maxValue := db.Limits()
+	element, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxValue)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, memdb.ErrValueTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return element, true
+}
+
+// requestIndex returns the integer parameter name of the request, def if it is missing
+func requestIndex(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("Invalid " + name)
+	}
+	return index, nil
+}
+
+// ListPushHandler pushes the request body to the back of the list under ?key=, or to its front with ?front=true,
+// and answers the length of the list, as {"length": 3}
+func ListPushHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		value, ok := readElement(w, r, db)
+		if !ok {
+			return
+		}
+
+		var length int
+		if r.URL.Query().Get("front") == "true" {
+			length, err = db.ListPushFront(key, value)
+		} else {
+			length, err = db.ListPush(key, value)
+		}
+		if err != nil {
+			setError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"length": length})
+	}
+}
+
+// ListPopHandler removes the last element of the list under ?key=, or its first one with ?front=true, and answers
+// it as the raw body, or 404 Not Found if the list is empty
+func ListPopHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		value, err := db.ListPop(key, r.URL.Query().Get("front") == "true")
+		if err == memdb.ErrKeyNotFound {
+			http.Error(w, "List is empty", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			setError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+	}
+}
+
+// ListRangeHandler answers the elements of the list under ?key= from index ?start= to index ?stop=, both included
+// and negative from the back, the whole list by default, as a JSON array of base64 strings
+func ListRangeHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, err := requestIndex(r, "start", 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stop, err := requestIndex(r, "stop", -1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		values, err := db.ListRange(key, start, stop)
+		if isInvalidKey(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(values)
+	}
+}
+
+// SetAddHandler adds the request body to the set under ?key=, and answers whether it is new, as {"added": 1}
+func SetAddHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		member, ok := readElement(w, r, db)
+		if !ok {
+			return
+		}
+
+		added, err := db.SetAdd(key, member)
+		if err != nil {
+			setError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"added": added})
+	}
+}
+
+// SetRemoveHandler removes the request body from the set under ?key=, and answers whether it held it, as
+// {"removed": 1}
+func SetRemoveHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		member, ok := readElement(w, r, db)
+		if !ok {
+			return
+		}
+
+		removed, err := db.SetRemove(key, member)
+		if err != nil {
+			setError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+	}
+}
+
+// SetMembersHandler answers the members of the set under ?key= as a JSON array of base64 strings, in ascending
+// byte order
+func SetMembersHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		members, err := db.SetMembers(key)
+		if isInvalidKey(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(members)
+	}
+}
+
+func RegisterCollectionHandlers(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/list/push", ListPushHandler(db))
+	mux.HandleFunc("/list/pop", ListPopHandler(db))
+	mux.HandleFunc("/list/range", ListRangeHandler(db))
+	mux.HandleFunc("/set/add", SetAddHandler(db))
+	mux.HandleFunc("/set/remove", SetRemoveHandler(db))
+	mux.HandleFunc("/set/members", SetMembersHandler(db))
+}
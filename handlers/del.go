@@ -8,20 +8,36 @@ import (
 
 func DeleteHandler(db *memdb.DB, wal *memdb.WAL) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
-        keys, ok := r.URL.Query()["key"]
-        if !ok || len(keys[0]) < 1 {
-            http.Error(w, "Key not provided", http.StatusBadRequest)
+        key, err := requestKey(r)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
             return
         }
 
-        key := keys[0]
+        opts, err := requestWriteOptions(r.URL.Query())
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
 
-		val, err := db.Delete(key)
+		val, err := db.DeleteReturningWith(key, opts)
         if err != nil {
             if err == memdb.ErrKeyNotFound {
                 http.Error(w, "Key not found", http.StatusNotFound)
                 return
             }
+            if err == memdb.ErrLowDiskSpace {
+                http.Error(w, err.Error(), http.StatusInsufficientStorage)
+                return
+            }
+            if err == memdb.ErrReadOnly {
+                http.Error(w, err.Error(), http.StatusForbidden)
+                return
+            }
+            if isInvalidKey(err) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
             http.Error(w, "Internal server error", http.StatusInternalServerError)
             return
         }
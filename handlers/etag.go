@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// notModified sets the ETag header of the value of key, and answers 304 Not Modified when the If-None-Match header
+// of the request holds it, which the caller must not answer again: clients polling a key only get its value when
+// it changed. Errors are left to the read of the value that follows.
+func notModified(w http.ResponseWriter, r *http.Request, db *memdb.DB, key string) bool {
+	tag, err := db.ETag(key)
+	if err != nil {
+		return false
+	}
+	w.Header().Set("ETag", tag)
+	if !etagMatch(r.Header.Values("If-None-Match"), tag, false) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch reports whether the If-None-Match or If-Match values hold tag, or *. With strong, as If-Match requires
+// (RFC 9110), weak tags never match; otherwise tags are compared weakly, as If-None-Match requires, ignoring the W/
+// prefix: the tags of the values are never weak.
+func etagMatch(values []string, tag string, strong bool) bool {
+	for _, value := range values {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if weak := strings.TrimPrefix(candidate, "W/"); weak != candidate {
+				if strong {
+					continue
+				}
+				candidate = weak
+			}
+			if candidate == "*" || candidate == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setIfMatch implements a /set sent with an If-Match header: the single pair of data is only set if the current
+// value of its key has one of the strong tags, or exists for *, with db.CompareAndSwap, and 412 Precondition Failed
+// is answered otherwise. The value is read before its tag, so that a write in between fails the swap instead of
+// replacing a value the client didn't see.
+func setIfMatch(w http.ResponseWriter, r *http.Request, db *memdb.DB, encoding keyEncoding, data map[string]interface{},
+	opts memdb.WriteOptions) {
+	if len(data) != 1 {
+		http.Error(w, "If-Match requires a single key-value pair", http.StatusBadRequest)
+		return
+	}
+	for key, value := range data {
+		key, err := encoding.decode(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var newValue []byte
+		if s, ok := value.(string); ok {
+			newValue = []byte(s)
+		} else if newValue, err = json.Marshal(value); err != nil {
+			http.Error(w, "Failed to encode value", http.StatusInternalServerError)
+			return
+		}
+
+		current, err := db.Get(key)
+		var tag string
+		if err == nil {
+			tag, err = db.ETag(key)
+		}
+		if err == memdb.ErrKeyNotFound || err == nil && !etagMatch(r.Header.Values("If-Match"), tag, true) {
+			http.Error(w, memdb.ErrCompareFailed.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			setError(w, err)
+			return
+		}
+		if err := db.CompareAndSwapWith(key, current, newValue, opts); err != nil {
+			setError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+func EventsHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(db.Events()); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func RegisterEventsHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/admin/events", EventsHandler(db))
+}
@@ -8,19 +8,24 @@ import (
 
 func GetHandler(db *memdb.DB) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
-        keys, ok := r.URL.Query()["key"]
-        if !ok || len(keys[0]) < 1 {
-            http.Error(w, "Key not provided", http.StatusBadRequest)
+        key, err := requestKey(r)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if notModified(w, r, db, key) {
             return
         }
-
-        key := keys[0]
         value, err := db.Get(key)
         if err != nil {
             if err == memdb.ErrKeyNotFound {
                 http.Error(w, "Key not found", http.StatusNotFound)
                 return
             }
+            if isInvalidKey(err) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
             http.Error(w, "Internal server error", http.StatusInternalServerError)
             return
         }
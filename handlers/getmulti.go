@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+// GetMultiHandler answers the values of the keys given as repeated ?key= parameters, all read at the same point in
+// time, as a JSON array of the pairs found in the order of the request, like /scan. Missing keys are left out.
+func GetMultiHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		encoding, err := requestKeyEncoding(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(query["key"]) == 0 {
+			http.Error(w, errKeyNotProvided.Error(), http.StatusBadRequest)
+			return
+		}
+		keys := make([]string, 0, len(query["key"]))
+		for _, key := range query["key"] {
+			key, err := encoding.decode(key)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			keys = append(keys, key)
+		}
+
+		values, err := db.GetMulti(keys)
+		if isInvalidKey(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		results := make([]ScanResult, 0, len(values))
+		for _, key := range keys {
+			if value, ok := values[key]; ok {
+				results = append(results, ScanResult{Key: encoding.encode(key), Value: string(value)})
+				delete(values, key)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+func RegisterGetMultiHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/getmulti", GetMultiHandler(db))
+}
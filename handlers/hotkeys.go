@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+func HotKeysHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hot := db.HotKeys()
+		if hot == nil {
+			http.Error(w, "Hot key tracking is disabled", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(hot); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func RegisterHotKeysHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/admin/hotkeys", HotKeysHandler(db))
+}
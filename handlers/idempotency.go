@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader carries a key chosen by the client for a write. A retry sent with the same key gets the
+// answer of the first request instead of applying the write again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set to "true" on an answer replayed from an earlier request with the same key
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// Defaults of NewIdempotency
+const (
+	DefaultIdempotencyTTL  = 24 * time.Hour
+	DefaultIdempotencyKeys = 10000
+)
+
+// maxIdempotentBody is the largest request body of a write sent with an idempotency key
+const maxIdempotentBody = 32 << 20
+
+// idempotentWrites are the endpoints taking an idempotency key, by full path: the last segment isn't enough, that
+// of /stream/append is append
+var idempotentWrites = map[string]bool{
+	"/set": true, "/del": true, "/setpath": true, "/stream/append": true, "/list/push": true, "/list/pop": true, "/tx": true,
+	"/lock/acquire": true, "/lock/refresh": true, "/lock/release": true,
+}
+
+// isIdempotentWrite returns whether the request to urlPath is a write taking an idempotency key, with or without
+// the tenant prefix, /{tenant}/set
+func isIdempotentWrite(urlPath string) bool {
+	if idempotentWrites[urlPath] {
+		return true
+	}
+	_, rest, ok := strings.Cut(strings.TrimPrefix(urlPath, "/"), "/")
+	return ok && idempotentWrites["/"+rest]
+}
+
+// idempotentRequest is a write seen with an idempotency key
+type idempotentRequest struct {
+	scope       string
+	fingerprint [sha256.Size]byte // Of the method, the query and the body, a retry must send the same ones
+	expires     time.Time
+	done        chan struct{} // Closed once the answer is recorded
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// Idempotency remembers the answers of the writes sent with an idempotency key, for TTL and at most keys of them,
+// the oldest being forgotten first
+type Idempotency struct {
+	ttl      time.Duration
+	keys     int
+	mu       sync.Mutex
+	requests map[string]*list.Element // Of *idempotentRequest, by scope
+	order    *list.List               // Oldest first
+}
+
+// NewIdempotency returns a store remembering at most keys answers for ttl, DefaultIdempotencyKeys and
+// DefaultIdempotencyTTL if 0
+func NewIdempotency(ttl time.Duration, keys int) *Idempotency {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	if keys <= 0 {
+		keys = DefaultIdempotencyKeys
+	}
+	return &Idempotency{ttl: ttl, keys: keys, requests: make(map[string]*list.Element), order: list.New()}
+}
+
+// Handler wraps handler so that a write to /set, /del, /setpath, /stream/append, /list/push, /list/pop or /tx sent
+// again with the same Idempotency-Key is answered like the first one without being applied again:
+//   - while the first request is being served, the retry is answered with 409 Conflict;
+//   - a key sent with another method, query or body is answered with 422 Unprocessable Entity.
+//
+// Keys are scoped by path, tenant and API key. Answers with a 5xx or 429 status aren't remembered,
+// the retry is applied as a new request.
+func (s *Idempotency) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || !isIdempotentWrite(r.URL.Path) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "Failed to read the request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.RawQuery + "\n"))
+		hash.Write(body)
+		var fingerprint [sha256.Size]byte
+		hash.Sum(fingerprint[:0])
+		scope := r.Header.Get("X-Tenant") + "\n" + r.Header.Get("X-API-Key") + "\n" + r.URL.Path + "\n" + key
+
+		request, first := s.begin(scope, fingerprint)
+		switch {
+		case request.fingerprint != fingerprint:
+			http.Error(w, "Idempotency key already used by another request", http.StatusUnprocessableEntity)
+		case !first:
+			select {
+			case <-request.done:
+				if request.status == 0 {
+					// It failed in the meantime and was forgotten, the next retry applies it again
+					http.Error(w, "A request with this idempotency key is in progress", http.StatusConflict)
+					return
+				}
+				for name, values := range request.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(request.status)
+				w.Write(request.body)
+			default:
+				http.Error(w, "A request with this idempotency key is in progress", http.StatusConflict)
+			}
+		default:
+			recorder := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+			handler.ServeHTTP(recorder, r)
+			s.finish(request, recorder)
+			for name, values := range recorder.header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(recorder.status)
+			w.Write(recorder.body.Bytes())
+		}
+	})
+}
+
+// begin returns the request remembered for scope, or remembers a new one in progress and reports it is the first
+func (s *Idempotency) begin(scope string, fingerprint [sha256.Size]byte) (*idempotentRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		oldest := front.Value.(*idempotentRequest)
+		if s.order.Len() < s.keys && now.Before(oldest.expires) {
+			break
+		}
+		s.remove(oldest)
+	}
+
+	if element, ok := s.requests[scope]; ok {
+		return element.Value.(*idempotentRequest), false
+	}
+	request := &idempotentRequest{scope: scope, fingerprint: fingerprint, expires: now.Add(s.ttl), done: make(chan struct{})}
+	s.requests[scope] = s.order.PushBack(request)
+	return request, true
+}
+
+// finish records the answer to request, or forgets the request if it may succeed when retried
+func (s *Idempotency) finish(request *idempotentRequest, recorder *bufferedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if recorder.status >= 500 || recorder.status == http.StatusTooManyRequests {
+		s.remove(request)
+	} else {
+		request.status, request.header, request.body = recorder.status, recorder.header, recorder.body.Bytes()
+	}
+	close(request.done)
+}
+
+// remove forgets request, the caller must hold s.mu
+func (s *Idempotency) remove(request *idempotentRequest) {
+	if element, ok := s.requests[request.scope]; ok && element.Value == request {
+		s.order.Remove(element)
+		delete(s.requests, request.scope)
+	}
+}
+
+// bufferedResponse keeps the answer of a handler until it can be sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
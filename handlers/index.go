@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+// IndexHandler returns the keys of the documents whose element at ?path= equals ?value=, through the index
+// declared on the path. The value is JSON, or taken as a plain string if it isn't.
+func IndexHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		encoding, err := requestKeyEncoding(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		values, ok := query["value"]
+		if !ok {
+			http.Error(w, "Value not provided", http.StatusBadRequest)
+			return
+		}
+		value := []byte(values[0])
+		if !json.Valid(value) {
+			value, _ = json.Marshal(values[0])
+		}
+
+		keys, err := db.QueryIndex(query.Get("path"), value)
+		if err == memdb.ErrNoIndex {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		for i, key := range keys {
+			keys[i] = encoding.encode(key)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+func RegisterIndexHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/index", IndexHandler(db))
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+var (
+	errKeyNotProvided       = errors.New("Key not provided")
+	errInvalidKeyEncoding   = errors.New("Invalid key encoding")
+	errInvalidValueEncoding = errors.New("Invalid value encoding")
+)
+
+// keyEncoding is how the keys of a request and of its response are written, set by the encoding parameter.
+// Keys are taken as they are by default: in a query parameter they are URL-escaped, %XX standing for any byte,
+// but JSON strings can only hold valid UTF-8. With encoding=base64 they are in standard base64 everywhere.
+type keyEncoding bool
+
+const keysBase64 keyEncoding = true
+
+// requestKeyEncoding returns the key encoding of the query
+func requestKeyEncoding(query url.Values) (keyEncoding, error) {
+	switch query.Get("encoding") {
+	case "":
+		return false, nil
+	case "base64":
+		return keysBase64, nil
+	}
+	return false, errInvalidKeyEncoding
+}
+
+// requestValueEncoding returns the encoding of the values of the response set by the value_encoding parameter of
+// the query, values being taken as they are by default and in standard base64 with value_encoding=base64
+func requestValueEncoding(query url.Values) (keyEncoding, error) {
+	switch query.Get("value_encoding") {
+	case "":
+		return false, nil
+	case "base64":
+		return keysBase64, nil
+	}
+	return false, errInvalidValueEncoding
+}
+
+// decode returns the key written as key
+func (e keyEncoding) decode(key string) (string, error) {
+	if e != keysBase64 {
+		return key, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", errInvalidKeyEncoding
+	}
+	return string(decoded), nil
+}
+
+// encode returns key written in the encoding
+func (e keyEncoding) encode(key string) string {
+	if e != keysBase64 {
+		return key
+	}
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+// requestKey returns the key parameter of the request, decoded, answered with 400 Bad Request if it fails
+func requestKey(r *http.Request) (string, error) {
+	query := r.URL.Query()
+	keys, ok := query["key"]
+	if !ok || len(keys[0]) < 1 {
+		return "", errKeyNotProvided
+	}
+	encoding, err := requestKeyEncoding(query)
+	if err != nil {
+		return "", err
+	}
+	return encoding.decode(keys[0])
+}
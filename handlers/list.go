@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+// ListKeysHandler answers the live keys selected by the prefix, start, end and limit query parameters, in
+// ascending order, without their values. With limit the keys are paginated as by /scan, the cursor of the next
+// page in X-Next-Cursor.
+func ListKeysHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding, err := requestKeyEncoding(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := scanOptions(r.URL.Query(), encoding)
+		if err != nil {
+			http.Error(w, "Invalid scan parameters: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := db.ListKeysPage(opts, r.URL.Query().Get("cursor"))
+		if !writePageHeaders(w, page, err) {
+			return
+		}
+		keys := make([]string, 0, len(page.KVs))
+		for _, kv := range page.KVs {
+			keys = append(keys, encoding.encode(kv.Key))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+func RegisterListKeysHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/keys", ListKeysHandler(db))
+}
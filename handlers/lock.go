@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// requestLock returns the name and ttl parameters of the request, the name decoded like a key and the TTL
+// parsed like "30s", 0 if it is missing
+func requestLock(r *http.Request) (string, time.Duration, error) {
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		return "", 0, errors.New("Name not provided")
+	}
+	encoding, err := requestKeyEncoding(query)
+	if err != nil {
+		return "", 0, err
+	}
+	if name, err = encoding.decode(name); err != nil {
+		return "", 0, err
+	}
+	var ttl time.Duration
+	if s := query.Get("ttl"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+			return "", 0, errors.New("Invalid ttl")
+		}
+	}
+	return name, ttl, nil
+}
+
+// requestToken returns the token parameter of the request
+func requestToken(r *http.Request) (uint64, error) {
+	token, err := strconv.ParseUint(r.URL.Query().Get("token"), 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid token")
+	}
+	return token, nil
+}
+
+// lockError answers the error of a lock operation
+func lockError(w http.ResponseWriter, err error) {
+	if err == memdb.ErrLockHeld || err == memdb.ErrLockNotHeld {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err == memdb.ErrInvalidTTL {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setError(w, err)
+}
+
+// LockAcquireHandler acquires the lock ?name= for ?ttl=, and answers it as {"name", "token", "expires"}, or
+// 409 Conflict if it is held
+func LockAcquireHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name, ttl, err := requestLock(r)
+		if err == nil && ttl == 0 {
+			err = errors.New("TTL not provided")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lock, err := db.AcquireLock(name, ttl)
+		if err != nil {
+			lockError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lock)
+	}
+}
+
+// LockRefreshHandler extends the lock ?name= held with ?token= to ?ttl= from now, and answers it like
+// LockAcquireHandler, or 409 Conflict if it expired or was acquired again since
+func LockRefreshHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name, ttl, err := requestLock(r)
+		if err == nil && ttl == 0 {
+			err = errors.New("TTL not provided")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token, err := requestToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lock, err := db.RefreshLock(name, token, ttl)
+		if err != nil {
+			lockError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lock)
+	}
+}
+
+// LockReleaseHandler releases the lock ?name= held with ?token=, or answers 409 Conflict if it expired or was
+// acquired again since
+func LockReleaseHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name, _, err := requestLock(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token, err := requestToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := db.ReleaseLock(name, token); err != nil {
+			lockError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func RegisterLockHandlers(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/lock/acquire", LockAcquireHandler(db))
+	mux.HandleFunc("/lock/refresh", LockRefreshHandler(db))
+	mux.HandleFunc("/lock/release", LockReleaseHandler(db))
+}
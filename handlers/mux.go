@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"net/http"
+)
+
+// NewMux returns a ServeMux with every endpoint of the API registered for db
+func NewMux(db *memdb.DB, wal *memdb.WAL) *http.ServeMux {
+	mux := http.NewServeMux()
+	RegisterGetHandler(mux, db)
+	RegisterGetMultiHandler(mux, db)
+	RegisterSetHandler(mux, db, wal)
+	RegisterDeleteHandler(mux, db, wal)
+	RegisterPathHandlers(mux, db)
+	RegisterTxHandler(mux, db)
+	RegisterBlobHandler(mux, db)
+	RegisterScanHandler(mux, db)
+	RegisterListKeysHandler(mux, db)
+	RegisterQueryHandler(mux, db)
+	RegisterIndexHandler(mux, db)
+	RegisterSearchHandler(mux, db)
+	RegisterStreamHandlers(mux, db)
+	RegisterCollectionHandlers(mux, db)
+	RegisterLockHandlers(mux, db)
+	RegisterHotKeysHandler(mux, db)
+	RegisterStatsHandler(mux, db)
+	RegisterAccessStatsHandler(mux, db)
+	RegisterVersionHandler(mux, db)
+	RegisterPurgeHandler(mux, db)
+	RegisterBackupHandler(mux, db)
+	RegisterEventsHandler(mux, db)
+	RegisterSSTablesHandler(mux, db)
+	RegisterVerifyHandler(mux, db)
+	return mux
+}
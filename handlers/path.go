@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// pathError reports a failed GetPath or SetPath to the client
+func pathError(w http.ResponseWriter, err error) {
+	switch err {
+	case memdb.ErrKeyNotFound, memdb.ErrPathNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case memdb.ErrInvalidPath, memdb.ErrNotJSON:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		setError(w, err)
+	}
+}
+
+// GetPathHandler returns the JSON element at ?path= in the document stored under ?key=
+func GetPathHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		elem, err := db.GetPath(key, r.URL.Query().Get("path"))
+		if err != nil {
+			pathError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(elem)
+	}
+}
+
+// SetPathHandler replaces the JSON element at ?path= in the document stored under ?key= with the request body
+func SetPathHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := requestKey(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The body is the new element, no larger than the whole value
+		_, maxValue := db.Limits()
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxValue)))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, memdb.ErrValueTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := db.SetPath(key, r.URL.Query().Get("path"), value); err != nil {
+			pathError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func RegisterPathHandlers(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/getpath", GetPathHandler(db))
+	mux.HandleFunc("/setpath", SetPathHandler(db))
+}
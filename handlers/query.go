@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+// maxQueryBody is the largest body accepted by /query
+const maxQueryBody = 64 << 10
+
+// QueryRequest is the body of /query: a filter expression, as parsed by memdb.ParseFilter, evaluated on the
+// values of the keys selected by the bounds of a scan
+type QueryRequest struct {
+	Filter string `json:"filter"`
+	Prefix string `json:"prefix"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Limit  int    `json:"limit"`
+}
+
+// queryOptions builds the scan options of a query, its keys being decoded with encoding
+func queryOptions(req QueryRequest, encoding keyEncoding) (memdb.ScanOptions, error) {
+	opts := memdb.ScanOptions{Limit: req.Limit}
+	var err error
+	if opts.Prefix, err = encoding.decode(req.Prefix); err != nil {
+		return opts, err
+	}
+	if opts.Start, err = encoding.decode(req.Start); err != nil {
+		return opts, err
+	}
+	if opts.End, err = encoding.decode(req.End); err != nil {
+		return opts, err
+	}
+	if req.Filter != "" {
+		if opts.Filter, err = memdb.ParseFilter(req.Filter); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// QueryHandler returns the key-value pairs whose value matches the filter of the request, like /scan
+func QueryHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		encoding, err := requestKeyEncoding(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req QueryRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBody)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		opts, err := queryOptions(req, encoding)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		kvs, err := db.Scan(opts)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		results := make([]ScanResult, 0, len(kvs))
+		for _, kv := range kvs {
+			results = append(results, ScanResult{Key: encoding.encode(kv.Key), Value: string(kv.Value)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+func RegisterQueryHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/query", QueryHandler(db))
+}
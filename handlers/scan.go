@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ScanResult is a key-value pair returned by /scan
+type ScanResult struct {
+	Key   string `json:"key"`   // In base64 with encoding=base64
+	Value string `json:"value"` // In base64 with value_encoding=base64
+}
+
+// scanFilter builds the value filter described by the query parameters:
+// field with eq (JSON value, or plain string) or contains, and minsize/maxsize
+func scanFilter(query url.Values) (memdb.ValueFilter, error) {
+	var filters []memdb.ValueFilter
+
+	if field := query.Get("field"); field != "" {
+		if eq, ok := query["eq"]; ok {
+			expected := []byte(eq[0])
+			if !json.Valid(expected) {
+				expected, _ = json.Marshal(eq[0])
+			}
+			filter, err := memdb.JSONFieldEquals(field, expected)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+		if contains, ok := query["contains"]; ok {
+			filter, err := memdb.JSONFieldContains(field, contains[0])
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+	}
+
+	if query.Get("minsize") != "" || query.Get("maxsize") != "" {
+		min, max := 0, 0
+		var err error
+		if s := query.Get("minsize"); s != "" {
+			if min, err = strconv.Atoi(s); err != nil {
+				return nil, err
+			}
+		}
+		if s := query.Get("maxsize"); s != "" {
+			if max, err = strconv.Atoi(s); err != nil {
+				return nil, err
+			}
+		}
+		filters = append(filters, memdb.ValueSizeBetween(min, max))
+	}
+
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return memdb.AllOf(filters...), nil
+}
+
+// scanOptions builds the scan options from the query parameters prefix, start, end, limit and the filters,
+// the keys being decoded with encoding.
+// With the bucket parameter, the keys are instead the time keys of the bucket between the RFC 3339 times from and to.
+func scanOptions(query url.Values, encoding keyEncoding) (memdb.ScanOptions, error) {
+	var opts memdb.ScanOptions
+	var err error
+	if opts.Prefix, err = encoding.decode(query.Get("prefix")); err != nil {
+		return opts, err
+	}
+	if opts.Start, err = encoding.decode(query.Get("start")); err != nil {
+		return opts, err
+	}
+	if opts.End, err = encoding.decode(query.Get("end")); err != nil {
+		return opts, err
+	}
+	if bucket := query.Get("bucket"); bucket != "" {
+		var from, to time.Time
+		if s := query.Get("from"); s != "" {
+			if from, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return opts, err
+			}
+		}
+		if s := query.Get("to"); s != "" {
+			if to, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return opts, err
+			}
+		}
+		opts = memdb.TimeRangeOptions(bucket, from, to)
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Limit = limit
+	}
+	filter, err := scanFilter(query)
+	if err != nil {
+		return opts, err
+	}
+	opts.Filter = filter
+	return opts, nil
+}
+
+// writePageHeaders answers err, or sets the headers describing page: X-Next-Cursor, the cursor of the next page
+// if there is one, and X-Cursor-Changed when the database was written since the first page. It returns false if
+// the request was answered.
+func writePageHeaders(w http.ResponseWriter, page memdb.Page, err error) bool {
+	switch {
+	case err == memdb.ErrInvalidCursor:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	case err != nil:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if page.Cursor != "" {
+		w.Header().Set("X-Next-Cursor", page.Cursor)
+	}
+	if page.Changed {
+		w.Header().Set("X-Cursor-Changed", "true")
+	}
+	return true
+}
+
+// ScanHandler answers the pairs selected by the query parameters. With limit, or cursor, it answers a page, and the
+// cursor of the next one, if any, in X-Next-Cursor: passing it as cursor with the same parameters returns the next
+// page, even if writes happened in between. With value_encoding=base64, values are in base64, so that values that
+// aren't valid UTF-8 survive the JSON response.
+func ScanHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding, err := requestKeyEncoding(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		valueEncoding, err := requestValueEncoding(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := scanOptions(r.URL.Query(), encoding)
+		if err != nil {
+			http.Error(w, "Invalid scan parameters: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// A page, resumable with its cursor, unless the whole range is asked for
+		var kvs []memdb.KeyValue
+		if cursor := r.URL.Query().Get("cursor"); opts.Limit > 0 || cursor != "" {
+			page, err := db.ScanPage(opts, cursor)
+			if !writePageHeaders(w, page, err) {
+				return
+			}
+			kvs = page.KVs
+		} else if kvs, err = db.Scan(opts); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]ScanResult, 0, len(kvs))
+		for _, kv := range kvs {
+			results = append(results, ScanResult{Key: encoding.encode(kv.Key), Value: valueEncoding.encode(string(kv.Value))})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+func RegisterScanHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/scan", ScanHandler(db))
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// SearchHandler returns the keys whose indexed fields hold every word of ?q=, the best match first,
+// as a JSON array of {"key", "score"}. ?limit= caps the number of results.
+func SearchHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		encoding, err := requestKeyEncoding(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q, ok := query["q"]
+		if !ok {
+			http.Error(w, "Query not provided", http.StatusBadRequest)
+			return
+		}
+		limit := 0
+		if s := query.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		results, err := db.Search(q[0], limit)
+		if err == memdb.ErrSearchDisabled {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		for i := range results {
+			results[i].Key = encoding.encode(results[i].Key)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+func RegisterSearchHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/search", SearchHandler(db))
+}
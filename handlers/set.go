@@ -3,15 +3,42 @@ package handlers
 import (
 	"StorageEngine/memdb"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
+var errInvalidSync = errors.New("Invalid sync parameter, expected true or false")
+
+// maxSetBody returns the largest body accepted by /set: room for a key and a value at the limits of db,
+// with their JSON escaping
+func maxSetBody(db *memdb.DB) int64 {
+    maxKey, maxValue := db.Limits()
+    return 2*int64(maxKey+maxValue) + 1024
+}
+
 func SetHandler(db *memdb.DB, wal *memdb.WAL) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         var data map[string]interface{}
 
-        if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+        encoding, err := requestKeyEncoding(r.URL.Query())
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        opts, err := requestWriteOptions(r.URL.Query())
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSetBody(db))).Decode(&data); err != nil {
+            var tooLarge *http.MaxBytesError
+            if errors.As(err, &tooLarge) {
+                http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+                return
+            }
             http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
             return
         }
@@ -20,10 +47,18 @@ func SetHandler(db *memdb.DB, wal *memdb.WAL) http.HandlerFunc {
             http.Error(w, "No key-value pairs found in the payload", http.StatusBadRequest)
             return
         }
+        if r.Header.Get("If-Match") != "" {
+            setIfMatch(w, r, db, encoding, data, opts)
+            return
+        }
 
         for key, value := range data {
-            // Convert key to string
-            keyStr := fmt.Sprintf("%v", key)
+            // Decode the key, JSON strings can't hold every byte
+            keyStr, err := encoding.decode(key)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
             keyBytes := []byte(keyStr)
 
             // Convert value to byte slice based on its type
@@ -37,18 +72,18 @@ func SetHandler(db *memdb.DB, wal *memdb.WAL) http.HandlerFunc {
                     http.Error(w, "Failed to encode value", http.StatusInternalServerError)
                     return
                 }
-				err = db.Set(string(keyBytes), valueBytes)
+				err = db.SetWith(string(keyBytes), valueBytes, opts)
 				if err != nil {
-					http.Error(w, "Failed to set key-value pair", http.StatusInternalServerError)
+					setError(w, err)
 					return
 				}
 				w.WriteHeader(http.StatusOK)
 				return
             }
 
-            err := db.Set(string(keyBytes), valueBytes)
+            err = db.SetWith(string(keyBytes), valueBytes, opts)
             if err != nil {
-                http.Error(w, "Failed to set key-value pair", http.StatusInternalServerError)
+                setError(w, err)
                 return
             }
         }
@@ -57,6 +92,58 @@ func SetHandler(db *memdb.DB, wal *memdb.WAL) http.HandlerFunc {
     }
 }
 
+// requestWriteOptions returns the options of the writes of the request: sync=true flushes the WAL to stable storage
+// before answering, sync=false doesn't, whatever the default of the server
+func requestWriteOptions(query url.Values) (memdb.WriteOptions, error) {
+    var opts memdb.WriteOptions
+    if query.Has("sync") {
+        sync, err := strconv.ParseBool(query.Get("sync"))
+        if err != nil {
+            return opts, errInvalidSync
+        }
+        opts.Sync = memdb.SyncOff
+        if sync {
+            opts.Sync = memdb.SyncOn
+        }
+    }
+    return opts, nil
+}
+
+// setError reports a failed db.Set to the client
+func setError(w http.ResponseWriter, err error) {
+    if err == memdb.ErrQuotaExceeded || err == memdb.ErrLowDiskSpace {
+        http.Error(w, err.Error(), http.StatusInsufficientStorage)
+        return
+    }
+    if err == memdb.ErrReadOnly {
+        http.Error(w, err.Error(), http.StatusForbidden)
+        return
+    }
+    if err == memdb.ErrKeyTooLarge || err == memdb.ErrValueTooLarge {
+        http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+        return
+    }
+    if err == memdb.ErrCompareFailed {
+        http.Error(w, err.Error(), http.StatusPreconditionFailed)
+        return
+    }
+    if err == memdb.ErrTxConflict {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+    if isInvalidKey(err) || err == memdb.ErrReservedValue {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    http.Error(w, "Failed to set key-value pair", http.StatusInternalServerError)
+}
+
+// isInvalidKey reports whether err is an *memdb.InvalidKeyError, answered with 400 Bad Request
+func isInvalidKey(err error) bool {
+    var invalid *memdb.InvalidKeyError
+    return errors.As(err, &invalid)
+}
+
 func RegisterSetHandler(mux *http.ServeMux, db *memdb.DB, wal *memdb.WAL) {
     mux.HandleFunc("/set", SetHandler(db, wal))
 }
\ No newline at end of file
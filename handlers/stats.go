@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+func StatsHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := db.Stats()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func RegisterStatsHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/stats", StatsHandler(db))
+}
+
+// AccessStatsHandler reports the most accessed keys with their reads and writes,
+// or the counters of a single key with the key parameter
+func AccessStatsHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report interface{}
+		if key := r.URL.Query().Get("key"); key != "" {
+			access, ok := db.KeyAccessStats(key)
+			if !ok {
+				http.Error(w, "Access statistics are disabled", http.StatusNotFound)
+				return
+			}
+			report = access
+		} else {
+			hot := db.AccessHotKeys()
+			if hot == nil {
+				http.Error(w, "Access statistics are disabled", http.StatusNotFound)
+				return
+			}
+			report = hot
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func RegisterAccessStatsHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/stats/hotkeys", AccessStatsHandler(db))
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// requestStream returns the stream parameter of the request, decoded like a key
+func requestStream(r *http.Request) (string, error) {
+	query := r.URL.Query()
+	stream := query.Get("stream")
+	if stream == "" {
+		return "", errors.New("Stream not provided")
+	}
+	encoding, err := requestKeyEncoding(query)
+	if err != nil {
+		return "", err
+	}
+	return encoding.decode(stream)
+}
+
+// requestSeq returns the sequence number in the parameter name of the request, 0 if it is missing
+func requestSeq(r *http.Request, name string) (uint64, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid " + name)
+	}
+	return seq, nil
+}
+
+// StreamAppendHandler appends the request body to ?stream= and answers its sequence number, as {"seq": 42}
+func StreamAppendHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stream, err := requestStream(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, maxValue := db.Limits()
+		payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxValue)))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, memdb.ErrValueTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		seq, err := db.StreamAppend(stream, payload)
+		if err != nil {
+			setError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]uint64{"seq": seq})
+	}
+}
+
+// StreamReadHandler returns the entries of ?stream= from sequence number ?from= on, at most ?limit= of them,
+// as a JSON array of {"seq", "payload"}, the payloads in base64
+func StreamReadHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream, err := requestStream(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, err := requestSeq(r, "from")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 0
+		if s := r.URL.Query().Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		entries, err := db.StreamRead(stream, from, limit)
+		if isInvalidKey(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// StreamTrimHandler deletes the entries of ?stream= before sequence number ?before=, and answers how many it
+// deleted, as {"deleted": 10}
+func StreamTrimHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stream, err := requestStream(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		before, err := requestSeq(r, "before")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := db.StreamTrim(stream, before)
+		if err != nil {
+			setError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+	}
+}
+
+func RegisterStreamHandlers(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/stream/append", StreamAppendHandler(db))
+	mux.HandleFunc("/stream/read", StreamReadHandler(db))
+	mux.HandleFunc("/stream/trim", StreamTrimHandler(db))
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// TxRequest is the body of /tx: the values the client read, and the writes it computed from them
+type TxRequest struct {
+	Reads   map[string]*string `json:"reads"`   // Value of each key read, null for a missing key
+	Writes  map[string]string  `json:"writes"`  // Values to set
+	Deletes []string           `json:"deletes"` // Keys to delete
+}
+
+// TxHandler commits the writes of the request body, a TxRequest, in a transaction, only if the keys it read still
+// have the values the client read, e.g. with /getmulti, and answers 409 Conflict otherwise, writing nothing: a
+// read-modify-write over HTTP is retried from the read until it commits. Keys are decoded like the ones of /set.
+func TxHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		encoding, err := requestKeyEncoding(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := requestWriteOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req TxRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSetBody(db))).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		tx := db.BeginTx()
+		defer tx.Rollback()
+		for key, expected := range req.Reads {
+			if key, err = encoding.decode(key); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			value, err := tx.Get(key)
+			if err != nil && err != memdb.ErrKeyNotFound {
+				setError(w, err)
+				return
+			}
+			if (err == nil) != (expected != nil) || expected != nil && *expected != string(value) {
+				http.Error(w, memdb.ErrTxConflict.Error(), http.StatusConflict)
+				return
+			}
+		}
+		for key, value := range req.Writes {
+			if key, err = encoding.decode(key); err == nil {
+				err = tx.Set(key, []byte(value))
+			}
+			if err != nil {
+				setError(w, err)
+				return
+			}
+		}
+		for _, key := range req.Deletes {
+			if key, err = encoding.decode(key); err == nil {
+				err = tx.Delete(key)
+			}
+			if err != nil {
+				setError(w, err)
+				return
+			}
+		}
+
+		if err := tx.CommitWith(opts); err != nil {
+			setError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func RegisterTxHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/tx", TxHandler(db))
+}
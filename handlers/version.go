@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"StorageEngine/memdb"
+	"encoding/json"
+	"net/http"
+)
+
+// VersionResponse is returned by /version
+type VersionResponse struct {
+	Build   memdb.BuildInfo    `json:"build"`
+	Formats memdb.FormatReport `json:"formats"` // Formats found on disk
+}
+
+func VersionHandler(db *memdb.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		formats, err := db.FormatVersions()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(VersionResponse{Build: memdb.Build(), Formats: formats})
+	}
+}
+
+func RegisterVersionHandler(mux *http.ServeMux, db *memdb.DB) {
+	mux.HandleFunc("/version", VersionHandler(db))
+}
@@ -0,0 +1,76 @@
+// Package importer reads the data of other key-value stores, LevelDB, RocksDB and Redis,
+// or CSV and NDJSON files, and bulk-loads it into a database.
+package importer
+
+import (
+	"StorageEngine/memdb"
+	"fmt"
+	"sort"
+)
+
+// KeyValue is a key with its value read from a source database
+type KeyValue = memdb.KeyValue
+
+// Formats of the source databases
+const (
+	FormatLevelDB = "leveldb" // LevelDB or RocksDB directory
+	FormatRDB     = "rdb"     // Redis RDB dump
+	FormatCSV     = "csv"     // key,value rows, as written by the exporter package
+	FormatNDJSON  = "ndjson"  // One {"key": ..., "value": ...} object per line, as written by the exporter package
+)
+
+// Options describe how to read a source database
+type Options struct {
+	Format     string
+	Database   int  // Redis database to read from an RDB dump
+	Base64     bool // Values of CSV and NDJSON files are encoded in base64
+	Base64Keys bool // Keys of CSV and NDJSON files are encoded in base64
+}
+
+// Report summarizes what was read from a source database
+type Report struct {
+	Source  string `json:"source"`
+	Keys    int    `json:"keys"`    // Live keys read
+	Deleted int    `json:"deleted"` // Keys whose latest version is a deletion
+	Skipped int    `json:"skipped"` // Keys that couldn't be imported, such as expired keys or Redis values that aren't strings
+}
+
+// sortKeyValues sorts key-value pairs by key
+func sortKeyValues(kvs []KeyValue) {
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+}
+
+// Read reads the live keys of the source database at path, sorted by key
+func Read(path string, opts Options) ([]KeyValue, Report, error) {
+	switch opts.Format {
+	case FormatLevelDB:
+		return ReadLevelDB(path)
+	case FormatRDB:
+		return ReadRDB(path, opts.Database)
+	case FormatCSV:
+		return ReadCSV(path, opts)
+	case FormatNDJSON:
+		return ReadNDJSON(path, opts)
+	default:
+		return nil, Report{}, fmt.Errorf("Unknown import format %q", opts.Format)
+	}
+}
+
+// Import reads the source database at path and ingests its keys into db.
+// Keys are written in batches of at most batchSize keys per SSTable, all in one SSTable if batchSize is 0.
+func Import(db *memdb.DB, path string, opts Options, batchSize int) (Report, error) {
+	kvs, report, err := Read(path, opts)
+	if err != nil {
+		return report, err
+	}
+
+	if batchSize <= 0 {
+		batchSize = len(kvs)
+	}
+	for start := 0; start < len(kvs); start += batchSize {
+		if err := db.Ingest(kvs[start:min(start+batchSize, len(kvs))]); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,404 @@
+package importer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+const (
+	levelDBTableMagic = 0xdb4775248b80fb57 // LevelDB and legacy RocksDB tables
+	rocksDBTableMagic = 0x88e241b785f4cff7 // RocksDB block-based tables
+
+	legacyFooterSize  = 48
+	rocksDBFooterSize = 53
+	blockTrailerSize  = 5 // Compression type and checksum
+
+	logBlockSize = 32768
+)
+
+// Types of the entries of a table or a write batch
+const (
+	typeDeletion       = 0x0
+	typeValue          = 0x1
+	typeCFDeletion     = 0x4
+	typeCFValue        = 0x5
+	typeSingleDeletion = 0x7
+	typeCFSingleDel    = 0x8
+)
+
+// Types of the fragments of a log record
+const (
+	logFull   = 1
+	logFirst  = 2
+	logMiddle = 3
+	logLast   = 4
+	// Recyclable fragments, written by RocksDB, carry the log number in their header
+	logRecyclableFull = 5
+	logRecyclableLast = 8
+)
+
+var (
+	ErrUnsupportedTable = errors.New("Unsupported table format")
+	errCorruptTable     = errors.New("Corrupt table")
+	errCorruptLog       = errors.New("Corrupt log record")
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// unmaskCRC reverses the masking applied by LevelDB to the checksums it stores
+func unmaskCRC(masked uint32) uint32 {
+	rot := masked - 0xa282ead8
+	return rot>>17 | rot<<15
+}
+
+// version is the latest version of a user key seen in the source database
+type version struct {
+	seq     uint64
+	value   []byte
+	deleted bool
+}
+
+// versions keeps the newest version of each user key, by sequence number
+type versions map[string]version
+
+func (v versions) add(key []byte, seq uint64, value []byte, deleted bool) {
+	current, ok := v[string(key)]
+	if ok && seq < current.seq {
+		return
+	}
+	v[string(key)] = version{seq: seq, value: append([]byte(nil), value...), deleted: deleted}
+}
+
+// ReadLevelDB reads the live keys of a LevelDB or RocksDB database directory, from its tables (.ldb and .sst files)
+// and from its write-ahead logs (.log files). The newest version of each key wins, by sequence number.
+// Only snappy compressed or uncompressed tables with the bytewise comparator are supported, and RocksDB databases
+// must use a single column family. The database should have been closed cleanly.
+func ReadLevelDB(dir string) ([]KeyValue, Report, error) {
+	report := Report{Source: dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, report, err
+	}
+
+	latest := make(versions)
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch filepath.Ext(entry.Name()) {
+		case ".ldb", ".sst":
+			err = readTable(path, latest)
+		case ".log":
+			err = readLog(path, latest)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, report, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	kvs := make([]KeyValue, 0, len(latest))
+	for key, v := range latest {
+		if v.deleted {
+			report.Deleted++
+			continue
+		}
+		kvs = append(kvs, KeyValue{Key: key, Value: v.value})
+	}
+	sortKeyValues(kvs)
+	report.Keys = len(kvs)
+	return kvs, report, nil
+}
+
+// blockHandle locates a block in a table file
+type blockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+// decodeBlockHandle decodes a block handle and returns the number of bytes read
+func decodeBlockHandle(buf []byte) (blockHandle, int, error) {
+	offset, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return blockHandle{}, 0, errCorruptTable
+	}
+	size, m := binary.Uvarint(buf[n:])
+	if m <= 0 {
+		return blockHandle{}, 0, errCorruptTable
+	}
+	return blockHandle{offset: offset, size: size}, n + m, nil
+}
+
+// readTable adds the entries of a table file to latest
+func readTable(path string, latest versions) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(file) < legacyFooterSize {
+		return errCorruptTable
+	}
+
+	// The footer ends with the magic number, and holds the handles of the metaindex and index blocks
+	var handles []byte
+	checksumType := byte(1) // crc32c
+	switch binary.LittleEndian.Uint64(file[len(file)-8:]) {
+	case levelDBTableMagic:
+		handles = file[len(file)-legacyFooterSize:]
+	case rocksDBTableMagic:
+		if len(file) < rocksDBFooterSize {
+			return errCorruptTable
+		}
+		footer := file[len(file)-rocksDBFooterSize:]
+		// Starting with version 4, index blocks are delta encoded
+		if formatVersion := binary.LittleEndian.Uint32(footer[rocksDBFooterSize-12:]); formatVersion > 3 {
+			return fmt.Errorf("%w: RocksDB format version %d", ErrUnsupportedTable, formatVersion)
+		}
+		checksumType = footer[0]
+		handles = footer[1:]
+	default:
+		return fmt.Errorf("%w: bad magic number", ErrUnsupportedTable)
+	}
+	_, n, err := decodeBlockHandle(handles)
+	if err != nil {
+		return err
+	}
+	indexHandle, _, err := decodeBlockHandle(handles[n:])
+	if err != nil {
+		return err
+	}
+
+	index, err := readBlock(file, indexHandle, checksumType)
+	if err != nil {
+		return err
+	}
+	return iterateBlock(index, func(_ []byte, value []byte) error {
+		handle, _, err := decodeBlockHandle(value)
+		if err != nil {
+			return err
+		}
+		data, err := readBlock(file, handle, checksumType)
+		if err != nil {
+			return err
+		}
+		return iterateBlock(data, func(internalKey []byte, value []byte) error {
+			// An internal key is the user key followed by the sequence number and the type of the entry
+			if len(internalKey) < 8 {
+				return errCorruptTable
+			}
+			userKey := internalKey[:len(internalKey)-8]
+			trailer := binary.LittleEndian.Uint64(internalKey[len(internalKey)-8:])
+			switch trailer & 0xff {
+			case typeValue:
+				latest.add(userKey, trailer>>8, value, false)
+			case typeDeletion, typeSingleDeletion:
+				latest.add(userKey, trailer>>8, nil, true)
+			default:
+				return fmt.Errorf("%w: entry type %d", ErrUnsupportedTable, trailer&0xff)
+			}
+			return nil
+		})
+	})
+}
+
+// readBlock returns the uncompressed content of a block, after verifying its checksum
+func readBlock(file []byte, handle blockHandle, checksumType byte) ([]byte, error) {
+	end := handle.offset + handle.size + blockTrailerSize
+	if end > uint64(len(file)) || end < handle.offset {
+		return nil, errCorruptTable
+	}
+	data := file[handle.offset : handle.offset+handle.size]
+	compression := file[handle.offset+handle.size]
+
+	// Only crc32c checksums are verified, RocksDB's xxHash variants are trusted
+	if checksumType == 1 {
+		stored := binary.LittleEndian.Uint32(file[handle.offset+handle.size+1 : end])
+		crc := crc32.Update(crc32.Checksum(data, crc32c), crc32c, []byte{compression})
+		if crc != unmaskCRC(stored) {
+			return nil, errCorruptTable
+		}
+	}
+
+	switch compression {
+	case 0:
+		return data, nil
+	case 1:
+		return snappyDecode(data)
+	default:
+		return nil, fmt.Errorf("%w: compression type %d", ErrUnsupportedTable, compression)
+	}
+}
+
+// iterateBlock calls fn with each key and value of a block. Keys are prefix compressed: each entry stores
+// the length of the prefix it shares with the previous key, followed by the rest of the key and the value.
+func iterateBlock(block []byte, fn func(key []byte, value []byte) error) error {
+	if len(block) < 4 {
+		return errCorruptTable
+	}
+	numRestarts := uint64(binary.LittleEndian.Uint32(block[len(block)-4:]))
+	restartsSize := 4 * (numRestarts + 1)
+	if restartsSize > uint64(len(block)) {
+		return errCorruptTable
+	}
+	entries := block[:uint64(len(block))-restartsSize]
+
+	var key []byte
+	for len(entries) > 0 {
+		var header [3]uint64
+		for i := range header {
+			v, n := binary.Uvarint(entries)
+			if n <= 0 {
+				return errCorruptTable
+			}
+			header[i] = v
+			entries = entries[n:]
+		}
+		shared, nonShared, valueLen := header[0], header[1], header[2]
+		if shared > uint64(len(key)) || nonShared+valueLen > uint64(len(entries)) {
+			return errCorruptTable
+		}
+		key = append(key[:shared], entries[:nonShared]...)
+		value := entries[nonShared : nonShared+valueLen]
+		entries = entries[nonShared+valueLen:]
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLog adds the write batches of a write-ahead log to latest. A truncated last record,
+// left by a crash in the middle of a write, is ignored.
+func readLog(path string, latest versions) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var record []byte
+	inRecord := false
+	for blockStart := 0; blockStart < len(file); blockStart += logBlockSize {
+		block := file[blockStart:min(blockStart+logBlockSize, len(file))]
+		for len(block) >= 7 {
+			length := int(binary.LittleEndian.Uint16(block[4:6]))
+			fragmentType := block[6]
+			headerSize := 7
+			if fragmentType >= logRecyclableFull && fragmentType <= logRecyclableLast {
+				headerSize = 11
+				fragmentType -= logRecyclableFull - logFull
+			}
+			if fragmentType == 0 && length == 0 {
+				// Zeroed space left at the end of a preallocated block
+				break
+			}
+			if headerSize+length > len(block) {
+				if blockStart+len(block) == len(file) {
+					return nil
+				}
+				return errCorruptLog
+			}
+			stored := binary.LittleEndian.Uint32(block[:4])
+			if crc32.Checksum(block[6:headerSize+length], crc32c) != unmaskCRC(stored) {
+				if blockStart+len(block) == len(file) {
+					return nil
+				}
+				return errCorruptLog
+			}
+			payload := block[headerSize : headerSize+length]
+			block = block[headerSize+length:]
+
+			switch fragmentType {
+			case logFull:
+				record, inRecord = payload, false
+			case logFirst:
+				record, inRecord = append([]byte(nil), payload...), true
+				continue
+			case logMiddle:
+				if !inRecord {
+					return errCorruptLog
+				}
+				record = append(record, payload...)
+				continue
+			case logLast:
+				if !inRecord {
+					return errCorruptLog
+				}
+				record, inRecord = append(record, payload...), false
+			default:
+				return errCorruptLog
+			}
+			if err := applyWriteBatch(record, latest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyWriteBatch adds the operations of a write batch to latest. A batch holds the sequence number
+// of its first operation, the number of operations, then the operations themselves.
+func applyWriteBatch(batch []byte, latest versions) error {
+	if len(batch) < 12 {
+		return errCorruptLog
+	}
+	seq := binary.LittleEndian.Uint64(batch[:8])
+	count := binary.LittleEndian.Uint32(batch[8:12])
+	batch = batch[12:]
+
+	readSlice := func() ([]byte, bool) {
+		length, n := binary.Uvarint(batch)
+		if n <= 0 || length > uint64(len(batch)-n) {
+			return nil, false
+		}
+		slice := batch[n : n+int(length)]
+		batch = batch[n+int(length):]
+		return slice, true
+	}
+
+	for i := uint32(0); i < count; i, seq = i+1, seq+1 {
+		if len(batch) == 0 {
+			return errCorruptLog
+		}
+		tag := batch[0]
+		batch = batch[1:]
+		if tag == typeCFValue || tag == typeCFDeletion || tag == typeCFSingleDel {
+			columnFamily, n := binary.Uvarint(batch)
+			if n <= 0 {
+				return errCorruptLog
+			}
+			if columnFamily != 0 {
+				return fmt.Errorf("%w: column family %d", ErrUnsupportedTable, columnFamily)
+			}
+			batch = batch[n:]
+			switch tag {
+			case typeCFValue:
+				tag = typeValue
+			case typeCFDeletion:
+				tag = typeDeletion
+			case typeCFSingleDel:
+				tag = typeSingleDeletion
+			}
+		}
+
+		key, ok := readSlice()
+		if !ok {
+			return errCorruptLog
+		}
+		switch tag {
+		case typeValue:
+			value, ok := readSlice()
+			if !ok {
+				return errCorruptLog
+			}
+			latest.add(key, seq, value, false)
+		case typeDeletion, typeSingleDeletion:
+			latest.add(key, seq, nil, true)
+		default:
+			return fmt.Errorf("%w: write batch operation %d", ErrUnsupportedTable, tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Opcodes of an RDB file, other bytes introduce a key of the corresponding value type
+const (
+	rdbOpSlotInfo     = 0xF4
+	rdbOpFunction2    = 0xF5
+	rdbOpFunction     = 0xF6
+	rdbOpModuleAux    = 0xF7
+	rdbOpIdle         = 0xF8
+	rdbOpFreq         = 0xF9
+	rdbOpAux          = 0xFA
+	rdbOpResizeDB     = 0xFB
+	rdbOpExpireTimeMs = 0xFC
+	rdbOpExpireTime   = 0xFD
+	rdbOpSelectDB     = 0xFE
+	rdbOpEOF          = 0xFF
+)
+
+// Value types of an RDB file
+const (
+	rdbTypeString         = 0
+	rdbTypeList           = 1
+	rdbTypeSet            = 2
+	rdbTypeZSet           = 3
+	rdbTypeHash           = 4
+	rdbTypeZSet2          = 5
+	rdbTypeHashZipmap     = 9
+	rdbTypeListZiplist    = 10
+	rdbTypeSetIntset      = 11
+	rdbTypeZSetZiplist    = 12
+	rdbTypeHashZiplist    = 13
+	rdbTypeListQuicklist  = 14
+	rdbTypeHashListpack   = 16
+	rdbTypeZSetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeSetListpack    = 20
+)
+
+// Special encodings of RDB strings
+const (
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var (
+	ErrNotRDB         = errors.New("Not a Redis RDB file")
+	ErrUnsupportedRDB = errors.New("Unsupported RDB content")
+	errCorruptRDB     = errors.New("Corrupt RDB file")
+)
+
+// rdbReader decodes the primitives of the RDB format
+type rdbReader struct {
+	r *bufio.Reader
+}
+
+func (rd *rdbReader) readByte() (byte, error) {
+	b, err := rd.r.ReadByte()
+	if err == io.EOF {
+		return 0, errCorruptRDB
+	}
+	return b, err
+}
+
+func (rd *rdbReader) readFull(n uint64) ([]byte, error) {
+	if n > 1<<32 {
+		return nil, errCorruptRDB
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rd.r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errCorruptRDB
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readLength reads a length. If encoded is set, the length is instead the type of a specially encoded string.
+func (rd *rdbReader) readLength() (length uint64, encoded bool, err error) {
+	b, err := rd.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := rd.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			buf, err := rd.readFull(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := rd.readFull(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, errCorruptRDB
+	default:
+		return uint64(b & 0x3f), true, nil
+	}
+}
+
+// readPlainLength reads a length that can't be a special encoding
+func (rd *rdbReader) readPlainLength() (uint64, error) {
+	length, encoded, err := rd.readLength()
+	if err == nil && encoded {
+		err = errCorruptRDB
+	}
+	return length, err
+}
+
+// readString reads a string, which may be stored as an integer or compressed with LZF
+func (rd *rdbReader) readString() ([]byte, error) {
+	length, encoded, err := rd.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return rd.readFull(length)
+	}
+
+	switch length {
+	case rdbEncInt8:
+		b, err := rd.readByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case rdbEncInt16:
+		buf, err := rd.readFull(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf))))), nil
+	case rdbEncInt32:
+		buf, err := rd.readFull(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf))))), nil
+	case rdbEncLZF:
+		compressedLen, err := rd.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		uncompressedLen, err := rd.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := rd.readFull(compressedLen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecode(compressed, uncompressedLen)
+	default:
+		return nil, errCorruptRDB
+	}
+}
+
+// skipStrings reads and discards n strings
+func (rd *rdbReader) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := rd.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipValue reads and discards a value of a type that can't be imported
+func (rd *rdbReader) skipValue(valueType byte) error {
+	switch valueType {
+	case rdbTypeHashZipmap, rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZSetZiplist, rdbTypeHashZiplist,
+		rdbTypeHashListpack, rdbTypeZSetListpack, rdbTypeSetListpack:
+		// Encoded in a single string
+		return rd.skipStrings(1)
+	case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+		n, err := rd.readPlainLength()
+		if err != nil {
+			return err
+		}
+		return rd.skipStrings(n)
+	case rdbTypeHash:
+		n, err := rd.readPlainLength()
+		if err != nil {
+			return err
+		}
+		return rd.skipStrings(2 * n)
+	case rdbTypeZSet:
+		n, err := rd.readPlainLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := rd.skipStrings(1); err != nil {
+				return err
+			}
+			// Scores are stored as a length byte followed by their text, with lengths 253 to 255 for NaN and infinities
+			scoreLen, err := rd.readByte()
+			if err != nil {
+				return err
+			}
+			if scoreLen < 253 {
+				if _, err := rd.readFull(uint64(scoreLen)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case rdbTypeZSet2:
+		n, err := rd.readPlainLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := rd.skipStrings(1); err != nil {
+				return err
+			}
+			if _, err := rd.readFull(8); err != nil {
+				return err
+			}
+		}
+		return nil
+	case rdbTypeListQuicklist2:
+		n, err := rd.readPlainLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := rd.readPlainLength(); err != nil {
+				return err
+			}
+			if err := rd.skipStrings(1); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		// Streams, modules and hashes with field expiration can't be skipped without decoding them
+		return fmt.Errorf("%w: value type %d", ErrUnsupportedRDB, valueType)
+	}
+}
+
+// lzfDecode decompresses LZF data to its expected length
+func lzfDecode(src []byte, length uint64) ([]byte, error) {
+	if length > 1<<32 {
+		return nil, errCorruptRDB
+	}
+	dst := make([]byte, 0, length)
+	for i := 0; i < len(src); {
+		ctrl := int(src[i])
+		i++
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes
+			if i+ctrl+1 > len(src) {
+				return nil, errCorruptRDB
+			}
+			dst = append(dst, src[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+
+		// Back reference
+		refLen := ctrl >> 5
+		if refLen == 7 {
+			if i >= len(src) {
+				return nil, errCorruptRDB
+			}
+			refLen += int(src[i])
+			i++
+		}
+		if i >= len(src) {
+			return nil, errCorruptRDB
+		}
+		ref := len(dst) - (ctrl&0x1f)<<8 - int(src[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorruptRDB
+		}
+		for j := 0; j < refLen+2; j++ {
+			dst = append(dst, dst[ref+j])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, errCorruptRDB
+	}
+	return dst, nil
+}
+
+// ReadRDB reads the string keys of a database of a Redis RDB dump. Keys that already expired are skipped,
+// and so are keys holding other types (lists, sets, hashes...) which have no equivalent in this engine.
+func ReadRDB(path string, database int) ([]KeyValue, Report, error) {
+	report := Report{Source: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, report, err
+	}
+	defer file.Close()
+	rd := &rdbReader{r: bufio.NewReader(file)}
+
+	header, err := rd.readFull(9)
+	if err != nil || string(header[:5]) != "REDIS" {
+		return nil, report, ErrNotRDB
+	}
+	if _, err := strconv.Atoi(string(header[5:])); err != nil {
+		return nil, report, ErrNotRDB
+	}
+
+	latest := make(map[string][]byte)
+	now := time.Now().UnixMilli()
+	currentDB := 0
+	expireAt := int64(-1)
+	for {
+		opcode, err := rd.readByte()
+		if err != nil {
+			return nil, report, err
+		}
+
+		switch opcode {
+		case rdbOpEOF:
+			// The checksum that may follow isn't verified
+			kvs := make([]KeyValue, 0, len(latest))
+			for key, value := range latest {
+				kvs = append(kvs, KeyValue{Key: key, Value: value})
+			}
+			sortKeyValues(kvs)
+			report.Keys = len(kvs)
+			return kvs, report, nil
+		case rdbOpSelectDB:
+			n, err := rd.readPlainLength()
+			if err != nil {
+				return nil, report, err
+			}
+			currentDB = int(n)
+		case rdbOpResizeDB:
+			if _, err := rd.readPlainLength(); err != nil {
+				return nil, report, err
+			}
+			if _, err := rd.readPlainLength(); err != nil {
+				return nil, report, err
+			}
+		case rdbOpSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := rd.readPlainLength(); err != nil {
+					return nil, report, err
+				}
+			}
+		case rdbOpAux:
+			if err := rd.skipStrings(2); err != nil {
+				return nil, report, err
+			}
+		case rdbOpFunction2:
+			if err := rd.skipStrings(1); err != nil {
+				return nil, report, err
+			}
+		case rdbOpFunction, rdbOpModuleAux:
+			return nil, report, fmt.Errorf("%w: opcode %#x", ErrUnsupportedRDB, opcode)
+		case rdbOpIdle:
+			if _, err := rd.readPlainLength(); err != nil {
+				return nil, report, err
+			}
+		case rdbOpFreq:
+			if _, err := rd.readByte(); err != nil {
+				return nil, report, err
+			}
+		case rdbOpExpireTime:
+			buf, err := rd.readFull(4)
+			if err != nil {
+				return nil, report, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(buf)) * 1000
+		case rdbOpExpireTimeMs:
+			buf, err := rd.readFull(8)
+			if err != nil {
+				return nil, report, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(buf))
+		default:
+			// A key, preceded by the type of its value
+			key, err := rd.readString()
+			if err != nil {
+				return nil, report, err
+			}
+			var value []byte
+			if opcode == rdbTypeString {
+				if value, err = rd.readString(); err != nil {
+					return nil, report, err
+				}
+			} else if err := rd.skipValue(opcode); err != nil {
+				return nil, report, err
+			}
+
+			switch {
+			case currentDB != database:
+			case opcode != rdbTypeString || (expireAt >= 0 && expireAt <= now):
+				report.Skipped++
+			default:
+				latest[string(key)] = value
+			}
+			expireAt = -1
+		}
+	}
+}
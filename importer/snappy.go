@@ -0,0 +1,96 @@
+package importer
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCorruptSnappy = errors.New("Corrupt snappy block")
+
+// snappyDecode decompresses a block in the snappy raw format, as used by LevelDB and RocksDB tables
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > 1<<31 {
+		return nil, errCorruptSnappy
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 0x03 {
+		case 0x00:
+			// Literal, whose length is stored in the tag or in the 1 to 4 bytes following it
+			litLen := int(tag >> 2)
+			src = src[1:]
+			if litLen >= 60 {
+				extra := litLen - 59
+				if len(src) < extra {
+					return nil, errCorruptSnappy
+				}
+				litLen = 0
+				for i := extra - 1; i >= 0; i-- {
+					litLen = litLen<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			litLen++
+			if litLen <= 0 || len(src) < litLen {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[:litLen]...)
+			src = src[litLen:]
+			continue
+		case 0x01:
+			// Copy with a 1 byte offset
+			if len(src) < 2 {
+				return nil, errCorruptSnappy
+			}
+			copyLen := 4 + int(tag>>2)&0x07
+			offset := int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+			if err := snappyCopy(&dst, offset, copyLen); err != nil {
+				return nil, err
+			}
+		case 0x02:
+			// Copy with a 2 bytes offset
+			if len(src) < 3 {
+				return nil, errCorruptSnappy
+			}
+			copyLen := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+			if err := snappyCopy(&dst, offset, copyLen); err != nil {
+				return nil, err
+			}
+		case 0x03:
+			// Copy with a 4 bytes offset
+			if len(src) < 5 {
+				return nil, errCorruptSnappy
+			}
+			copyLen := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+			if err := snappyCopy(&dst, offset, copyLen); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
+
+// snappyCopy appends copyLen bytes starting offset bytes back in dst, the ranges may overlap
+func snappyCopy(dst *[]byte, offset int, copyLen int) error {
+	if offset <= 0 || offset > len(*dst) {
+		return errCorruptSnappy
+	}
+	start := len(*dst) - offset
+	for i := 0; i < copyLen; i++ {
+		*dst = append(*dst, (*dst)[start+i])
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// ReadCSV reads the key,value rows of a CSV file, skipping a key,value header.
+// When a key appears several times, the last row wins. Keys and values are decoded from base64 if opts.Base64Keys
+// and opts.Base64 are set.
+func ReadCSV(path string, opts Options) ([]KeyValue, Report, error) {
+	report := Report{Source: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, report, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = 2
+	reader.ReuseRecord = true
+	var kvs []KeyValue
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, report, err
+		}
+		if row == 1 && record[0] == "key" && record[1] == "value" {
+			continue
+		}
+		key, err := decodeText(record[0], opts.Base64Keys)
+		if err != nil {
+			return nil, report, fmt.Errorf("%s: row %d: %w", path, row, err)
+		}
+		value, err := decodeText(record[1], opts.Base64)
+		if err != nil {
+			return nil, report, fmt.Errorf("%s: row %d: %w", path, row, err)
+		}
+		kvs = append(kvs, KeyValue{Key: string(key), Value: value})
+	}
+
+	kvs = sortLastWins(kvs)
+	report.Keys = len(kvs)
+	return kvs, report, nil
+}
+
+// ReadNDJSON reads a file of {"key": ..., "value": ...} objects, one per line, skipping empty lines.
+// When a key appears several times, the last line wins. Keys and values are decoded from base64 if opts.Base64Keys
+// and opts.Base64 are set.
+func ReadNDJSON(path string, opts Options) ([]KeyValue, Report, error) {
+	report := Report{Source: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, report, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	var kvs []KeyValue
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record struct {
+			Key   *string `json:"key"`
+			Value string  `json:"value"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, report, fmt.Errorf("%s: line %d: %w", path, line, err)
+		}
+		if record.Key == nil {
+			return nil, report, fmt.Errorf("%s: line %d: missing key", path, line)
+		}
+		key, err := decodeText(*record.Key, opts.Base64Keys)
+		if err != nil {
+			return nil, report, fmt.Errorf("%s: line %d: %w", path, line, err)
+		}
+		value, err := decodeText(record.Value, opts.Base64)
+		if err != nil {
+			return nil, report, fmt.Errorf("%s: line %d: %w", path, line, err)
+		}
+		kvs = append(kvs, KeyValue{Key: string(key), Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, report, err
+	}
+
+	kvs = sortLastWins(kvs)
+	report.Keys = len(kvs)
+	return kvs, report, nil
+}
+
+// decodeText returns the bytes of a key or a value read from a text file, in base64 if encoded is set
+func decodeText(text string, encoded bool) ([]byte, error) {
+	if encoded {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// sortLastWins sorts key-value pairs by key, keeping only the last occurrence of each key.
+// Input that is already sorted isn't sorted again.
+func sortLastWins(kvs []KeyValue) []KeyValue {
+	if !sort.SliceIsSorted(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key }) {
+		sort.SliceStable(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	}
+	unique := kvs[:0]
+	for i, kv := range kvs {
+		if i+1 < len(kvs) && kvs[i+1].Key == kv.Key {
+			continue
+		}
+		unique = append(unique, kv)
+	}
+	return unique
+}
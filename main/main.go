@@ -1,34 +1,444 @@
 package main
 
 import (
+	"StorageEngine/audit"
+	"StorageEngine/config"
 	"StorageEngine/handlers"
 	"StorageEngine/memdb"
+	"StorageEngine/objstore"
+	"StorageEngine/replication"
+	"StorageEngine/sstable"
+	"StorageEngine/tenant"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+var (
+	configPath = flag.String("config", "", "TOML configuration file, the flags below override its settings")
+	tenants    = flag.String("tenants", "", "Comma-separated tenants to host, as name or name:apikey (single database if empty)")
+	dataDir    = flag.String("data", "tenants", "Directory holding one sub-directory per tenant")
+	quotaKeys  = flag.Int64("quota-keys", 0, "Maximum number of keys per tenant (0 for no limit)")
+	quotaBytes = flag.Int64("quota-bytes", 0, "Maximum number of bytes per tenant (0 for no limit)")
+	quotaRate  = flag.Int("quota-rate", 0, "Requests per second allowed per tenant on average (0 for no limit)")
+	quotaBurst = flag.Int("quota-burst", 0, "Requests allowed at once per tenant above -quota-rate (one second of requests if 0)")
+	minFree    = flag.Uint64("min-free", 0, "Refuse writes when the data volumes have less free bytes than this (0 to disable)")
+	scrub      = flag.Duration("scrub", 0, "Interval between background SSTable checksum verifications (0 to disable)")
+	warmup     = flag.Int("warmup", 0, "SSTables read at the same time to warm the page cache at startup (0 to disable)")
+	auditLog   = flag.String("audit", "", "File recording who changed which key (disabled if empty)")
+	record     = flag.String("record", "", "File the operations on the keys are recorded to, for cmd/replay (disabled if empty)")
+	fileSize   = flag.Int64("target-file-size", 0, "Split flushed and compacted SSTables at this many bytes (0 for no limit)")
+	follow     = flag.Duration("follow", 0, "Serve reads only, refreshing from the files of the writer at this interval (0 for the writer)")
+	maxAge     = flag.Duration("max-table-age", 0, "Compact all SSTables once the oldest one is older than this, e.g. 168h (0 to disable)")
+	grace      = flag.Duration("tombstone-grace", 0, "Keep deletion markers this long after their flush before compactions drop them, e.g. 72h")
+	lookups    = flag.Int("lookup-workers", 0, "SSTables probed at the same time by a lookup (0 or 1 to probe them in turn)")
+	valueCache = flag.Int64("value-cache", 0, "Bytes of values recently read from the SSTables kept in memory (0 to disable)")
+	maxKey     = flag.Int("max-key-size", 0, "Longest key accepted by writes, in bytes (64 KiB if 0)")
+	maxValue   = flag.Int("max-value-size", 0, "Longest value accepted by writes, in bytes (16 MiB if 0)")
+	directIO   = flag.Bool("direct-io", false, "Read whole SSTables, for compactions and scans, around the page cache")
+	syncWrites = flag.Bool("sync-writes", false, "Sync the WAL before acknowledging each write, unless the request sets sync=false")
+	keyMode    = flag.String("key-mode", "", "Keys accepted: binary (the default), utf8 or escaped")
+	checksum   = flag.String("checksum", "", "Checksum of the SSTables written: crc32 (the default), crc32c or xxhash64")
+	readAhead  = flag.Int64("read-ahead", 0, "Bytes of SSTables opened in the background ahead of a scan (0 to disable)")
+	indexes    = flag.String("indexes", "", "Comma-separated JSON paths of the secondary indexes, e.g. user.email")
+	fullText   = flag.String("full-text", "", "Comma-separated JSON paths of the fields indexed for full-text search, e.g. title")
+	timeWindow = flag.Duration("time-window", 0, "Span of the time keys of an SSTable in time-series mode, e.g. 1h (0 to disable)")
+	retention  = flag.Duration("retention", 0, "Drop the time-series windows ending longer ago than this, e.g. 720h (0 to keep them)")
+)
+
+// cfg is the configuration of the server, from the configuration file and the flags
+var cfg config.Config
+
 func main() {
+	flag.Parse()
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Error loading configuration: %s", err)
+	}
+	// SSTables are encrypted with the key of the environment if it is set, check it before writing any
+	if _, err := sstable.EncryptionFromEnv(); err != nil {
+		log.Fatalf("Error reading %s: %s", sstable.EncryptionKeyEnv, err)
+	}
+
+	if len(cfg.Tenants.List) > 0 {
+		serveTenants()
+		return
+	}
 
-	// Open WAL file
-	wal, err := memdb.OpenWAL("wal.log")
+	// Open WAL file, without writing to it when following the server that owns it
+	options := dbOptions()
+	openWAL := memdb.OpenWAL
+	if cfg.Storage.Follow > 0 {
+		openWAL = memdb.OpenWALReadOnly
+		options = append(options, memdb.Follower(cfg.Storage.Follow))
+	}
+	if cfg.Replica.Primary != "" {
+		bootstrapReplica()
+	}
+	// With failover, even the primary only takes writes once it holds the lease
+	if cfg.Replica.Primary != "" || cfg.Failover.LeaseFile != "" {
+		options = append(options, memdb.Replica())
+	}
+	wal, err := openWAL(cfg.Storage.WAL)
 	if err != nil {
 		log.Fatalf("Error opening WAL: %v", err)
 	}
 	defer wal.Close()
 
-	db, err := memdb.NewDB(wal, "SSTableFiles", memdb.Threshold(5))
+	options = append(options, objectStorage("")...)
+	options = append(options, recordOption())
+	db, err := memdb.NewDB(wal, cfg.Storage.SSTables, options...)
 	if err != nil {
 		log.Fatalf("Error creating DB: %s", err)
 	}
+	defer db.Close()
 
 	// Mounting handlers from the external package
-	mux := http.NewServeMux()
-	handlers.RegisterGetHandler(mux, db)
-	handlers.RegisterSetHandler(mux, db, wal)
-	handlers.RegisterDeleteHandler(mux, db, wal)
-
-	fmt.Println("Server is running on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", mux))
-	
-}
\ No newline at end of file
+	mux := handlers.NewMux(db, wal)
+	if cfg.Replication.NodeID != "" {
+		replicator := startReplication(db)
+		defer replicator.Close()
+		replication.RegisterHandler(mux, replicator, cfg.Replication.Token)
+	}
+	var replica *replication.Replica
+	if cfg.Replica.Primary != "" {
+		replica, err = replication.NewReplica(db, replicaConfig())
+		if err != nil {
+			log.Fatalf("Error starting replica: %s", err)
+		}
+		replica.Start()
+		defer replica.Close()
+	}
+	// A replica that may be promoted serves the other replicas once it leads
+	var acks *replication.AckTracker
+	if cfg.Replica.Token != "" && (cfg.Replica.Primary == "" || cfg.Failover.LeaseFile != "") {
+		acks = replication.NewAckTracker()
+		replication.RegisterPrimaryHandlers(mux, db, cfg.Storage.WAL, cfg.Replica.Token, acks)
+	}
+	var failover *replication.Failover
+	if cfg.Failover.LeaseFile != "" {
+		failover = startFailover(db, replica)
+		defer failover.Close()
+	}
+	handler := replication.NewConsistency(replication.ConsistencyConfig{
+		Acks:         acks,
+		Position:     wal.Offset,
+		Replica:      replica,
+		Failover:     failover,
+		SyncReplicas: cfg.Consistency.SyncReplicas,
+		SyncTimeout:  cfg.Consistency.SyncTimeout,
+	}).Handler(mux)
+	if failover != nil {
+		handler = failover.Redirect(handler)
+	}
+
+	fmt.Printf("Server is running on %s...\n", cfg.Server.Addr)
+	log.Fatal(listen(withIdempotency(withAudit(handler))))
+
+}
+
+// loadConfig loads the configuration file, if any, then applies the flags set on the command line
+func loadConfig() error {
+	cfg = config.Default()
+	if *configPath != "" {
+		var err error
+		if cfg, err = config.Load(*configPath); err != nil {
+			return err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tenants":
+			cfg.Tenants.List = nil
+			for _, spec := range strings.Split(*tenants, ",") {
+				if spec = strings.TrimSpace(spec); spec != "" {
+					cfg.Tenants.List = append(cfg.Tenants.List, spec)
+				}
+			}
+		case "data":
+			cfg.Tenants.DataDir = *dataDir
+		case "quota-keys":
+			cfg.Tenants.QuotaKeys = *quotaKeys
+		case "quota-bytes":
+			cfg.Tenants.QuotaBytes = *quotaBytes
+		case "quota-rate":
+			cfg.Tenants.QuotaRate = *quotaRate
+		case "quota-burst":
+			cfg.Tenants.QuotaBurst = *quotaBurst
+		case "min-free":
+			cfg.Storage.MinFreeSpace = *minFree
+		case "scrub":
+			cfg.Storage.Scrub = *scrub
+		case "warmup":
+			cfg.Storage.Warmup = *warmup
+		case "audit":
+			cfg.Server.AuditLog = *auditLog
+		case "record":
+			cfg.Server.Record = *record
+		case "target-file-size":
+			cfg.Storage.TargetFileSize = *fileSize
+		case "follow":
+			cfg.Storage.Follow = *follow
+		case "max-table-age":
+			cfg.Storage.MaxTableAge = *maxAge
+		case "tombstone-grace":
+			cfg.Storage.TombstoneGrace = *grace
+		case "lookup-workers":
+			cfg.Storage.LookupWorkers = *lookups
+		case "value-cache":
+			cfg.Storage.ValueCache = *valueCache
+		case "max-key-size":
+			cfg.Storage.MaxKeySize = *maxKey
+		case "max-value-size":
+			cfg.Storage.MaxValueSize = *maxValue
+		case "direct-io":
+			cfg.Storage.DirectIO = *directIO
+		case "sync-writes":
+			cfg.Storage.SyncWrites = *syncWrites
+		case "key-mode":
+			cfg.Storage.KeyMode = *keyMode
+		case "checksum":
+			cfg.Storage.Checksum = *checksum
+		case "read-ahead":
+			cfg.Storage.ReadAhead = *readAhead
+		case "indexes":
+			cfg.Storage.Indexes = nil
+			for _, path := range strings.Split(*indexes, ",") {
+				if path = strings.TrimSpace(path); path != "" {
+					cfg.Storage.Indexes = append(cfg.Storage.Indexes, path)
+				}
+			}
+		case "time-window":
+			cfg.Storage.TimeWindow = *timeWindow
+		case "retention":
+			cfg.Storage.Retention = *retention
+		case "full-text":
+			cfg.Storage.FullText = nil
+			for _, path := range strings.Split(*fullText, ",") {
+				if path = strings.TrimSpace(path); path != "" {
+					cfg.Storage.FullText = append(cfg.Storage.FullText, path)
+				}
+			}
+		}
+	})
+	return cfg.Validate()
+}
+
+// dbOptions returns the options shared by every database of the server
+func dbOptions() []memdb.Option {
+	keyMode, _ := memdb.ParseKeyMode(cfg.Storage.KeyMode) // Checked by Validate
+	checksum, _ := sstable.ParseChecksumType(cfg.Storage.Checksum)
+	return []memdb.Option{
+		memdb.Threshold(cfg.Storage.Threshold),
+		memdb.Compaction(memdb.CompactionOptions{MinFiles: cfg.Storage.CompactMin, MaxFiles: cfg.Storage.CompactMax, Auto: cfg.Storage.AutoCompact}),
+		memdb.HotKeys(cfg.Stats.HotKeys, cfg.Stats.HotKeysSample),
+		memdb.AccessStats(cfg.Stats.AccessStats, 0, 0),
+		memdb.MinFreeSpace(cfg.Storage.MinFreeSpace),
+		scrubOption(),
+		memdb.Warmup(cfg.Storage.Warmup),
+		memdb.TargetFileSize(cfg.Storage.TargetFileSize),
+		memdb.PeriodicCompaction(cfg.Storage.MaxTableAge),
+		memdb.TombstoneGrace(cfg.Storage.TombstoneGrace),
+		memdb.ParallelLookup(cfg.Storage.LookupWorkers),
+		memdb.ValueCache(cfg.Storage.ValueCache),
+		memdb.SizeLimits(cfg.Storage.MaxKeySize, cfg.Storage.MaxValueSize),
+		memdb.DirectIO(cfg.Storage.DirectIO),
+		memdb.SyncWrites(cfg.Storage.SyncWrites),
+		memdb.Keys(keyMode),
+		memdb.Checksum(checksum),
+		memdb.ReadAhead(cfg.Storage.ReadAhead),
+		memdb.Indexes(cfg.Storage.Indexes...),
+		memdb.FullText(cfg.Storage.FullText...),
+		memdb.TimeSeries(memdb.TimeSeriesPolicy{Window: cfg.Storage.TimeWindow, Retention: cfg.Storage.Retention}),
+	}
+}
+
+// objectStorage returns the options keeping the SSTables in the configured object store under prefix, if any
+func objectStorage(prefix string) []memdb.Option {
+	c := cfg.ObjectStore
+	tiering := memdb.Tiering(memdb.TierPolicy{ColdAfter: c.ColdAfter, HotReads: c.HotReads})
+	switch {
+	case c.Dir != "":
+		store, err := objstore.NewDir(filepath.Join(c.Dir, filepath.FromSlash(c.Prefix+prefix)))
+		if err != nil {
+			log.Fatalf("Error opening object store: %s", err)
+		}
+		return []memdb.Option{memdb.ObjectStorage(store, c.CacheSize), tiering}
+	case c.Bucket != "":
+		if c.AccessKey == "" {
+			c.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if c.SecretKey == "" {
+			c.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		store := objstore.NewS3(objstore.S3Config{
+			Endpoint:  c.Endpoint,
+			Region:    c.Region,
+			Bucket:    c.Bucket,
+			Prefix:    c.Prefix + prefix,
+			AccessKey: c.AccessKey,
+			SecretKey: c.SecretKey,
+		})
+		return []memdb.Option{memdb.ObjectStorage(store, c.CacheSize), tiering}
+	}
+	return nil
+}
+
+// startReplication replicates db with the configured peer in the background
+func startReplication(db *memdb.DB) *replication.Replicator {
+	c := cfg.Replication
+	if c.StateDir == "" {
+		c.StateDir = filepath.Join(filepath.Dir(cfg.Storage.WAL), "replication")
+	}
+	replicator, err := replication.New(db, replication.Config{
+		NodeID:    c.NodeID,
+		WALPath:   cfg.Storage.WAL,
+		StateDir:  c.StateDir,
+		Transport: &replication.HTTPTransport{URL: strings.TrimSuffix(c.Peer, "/"), Token: c.Token},
+		Interval:  c.Interval,
+	})
+	if err != nil {
+		log.Fatalf("Error starting replication: %s", err)
+	}
+	replicator.Start()
+	return replicator
+}
+
+// replicaConfig returns the configuration of the replica of the configured primary
+func replicaConfig() replication.ReplicaConfig {
+	nodeID := cfg.Failover.NodeID
+	if nodeID == "" {
+		nodeID, _ = os.Hostname()
+	}
+	return replication.ReplicaConfig{
+		Primary:    cfg.Replica.Primary,
+		Token:      cfg.Replica.Token,
+		NodeID:     nodeID,
+		CursorPath: cfg.Storage.WAL + ".replica",
+		Interval:   cfg.Replica.Interval,
+	}
+}
+
+// bootstrapReplica copies a snapshot of the primary into the data directory of a new replica
+func bootstrapReplica() {
+	c := replicaConfig()
+	if _, err := os.Stat(c.CursorPath); err == nil {
+		return
+	}
+	fmt.Printf("Bootstrapping replica from %s...\n", c.Primary)
+	manifest, err := replication.Bootstrap(c, cfg.Storage.WAL, cfg.Storage.SSTables)
+	if err != nil {
+		log.Fatalf("Error bootstrapping replica: %s", err)
+	}
+	fmt.Printf("Replica bootstrapped with %d SSTables, resuming at WAL offset %d\n", len(manifest.SSTables), manifest.WALOffset)
+}
+
+// startFailover takes part in the election of the server taking the writes: db takes writes while this server
+// leads, and replica, if any, stops following the primary once it does
+func startFailover(db *memdb.DB, replica *replication.Replica) *replication.Failover {
+	c := cfg.Failover
+	failover, err := replication.NewFailover(replication.FailoverConfig{
+		NodeID:  c.NodeID,
+		Address: strings.TrimSuffix(c.Address, "/"),
+		Store:   &replication.FileLeaseStore{Path: c.LeaseFile},
+		TTL:     c.TTL,
+		Standby: replica != nil,
+		OnPromote: func(lease replication.Lease) error {
+			if replica != nil {
+				replica.Close()
+				// The primary is most likely gone, but it may still have records to give
+				if _, err := replica.Poll(); err != nil {
+					log.Printf("Replica: last catch-up failed: %s", err)
+				}
+			}
+			db.SetReplica(false)
+			return nil
+		},
+		OnDemote: func(lease replication.Lease) {
+			db.SetReplica(true)
+		},
+	})
+	if err != nil {
+		log.Fatalf("Error starting failover: %s", err)
+	}
+	failover.Start()
+	return failover
+}
+
+// listen serves handler on the configured address, over HTTPS when a certificate is configured
+func listen(handler http.Handler) error {
+	if cfg.Server.TLSCert != "" {
+		return http.ListenAndServeTLS(cfg.Server.Addr, cfg.Server.TLSCert, cfg.Server.TLSKey, handler)
+	}
+	return http.ListenAndServe(cfg.Server.Addr, handler)
+}
+
+// serveTenants hosts one database per configured tenant
+func serveTenants() {
+	registry := tenant.NewRegistry()
+	defer registry.Close()
+
+	for _, spec := range cfg.Tenants.List {
+		name, apiKey, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		_, err := registry.Open(tenant.Config{
+			Name:    name,
+			Dir:     filepath.Join(cfg.Tenants.DataDir, name),
+			APIKey:  apiKey,
+			Options: append(append(dbOptions(), objectStorage(name+"/")...), memdb.Quota(cfg.Tenants.QuotaKeys, cfg.Tenants.QuotaBytes)),
+			Rate:    float64(cfg.Tenants.QuotaRate),
+			Burst:   cfg.Tenants.QuotaBurst,
+		})
+		if err != nil {
+			log.Fatalf("Error opening tenant %q: %s", name, err)
+		}
+	}
+
+	fmt.Printf("Server is running on %s with tenants %v...\n", cfg.Server.Addr, registry.Names())
+	log.Fatal(listen(withIdempotency(withAudit(registry))))
+}
+
+// scrubOption returns the scrubber option, corrupted SSTables are logged and quarantined
+func scrubOption() memdb.Option {
+	return memdb.Scrub(cfg.Storage.Scrub, true, func(table memdb.CorruptTable) {
+		log.Printf("Corrupted SSTable %s: %s (moved to %s)", table.Path, table.Error, table.Quarantined)
+	})
+}
+
+// recordOption returns the option recording the operations to the configured file, if any. Write errors are logged
+// and stop the recording.
+func recordOption() memdb.Option {
+	if cfg.Server.Record == "" {
+		return memdb.Record(nil, nil)
+	}
+	file, err := os.OpenFile(cfg.Server.Record, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("Error opening the recording: %s", err)
+	}
+	return memdb.Record(file, func(err error) {
+		log.Printf("Error writing the recording, which stops: %s", err)
+	})
+}
+
+// withIdempotency wraps handler to answer the retries of a write with the answer to the first attempt, which
+// isn't applied or audited again
+func withIdempotency(handler http.Handler) http.Handler {
+	return handlers.NewIdempotency(cfg.Idempotency.TTL, cfg.Idempotency.Keys).Handler(handler)
+}
+
+// withAudit wraps handler to record mutations in the audit log when one is configured
+func withAudit(handler http.Handler) http.Handler {
+	if cfg.Server.AuditLog == "" {
+		return handler
+	}
+	file, err := audit.OpenFile(cfg.Server.AuditLog)
+	if err != nil {
+		log.Fatalf("Error opening audit log: %s", err)
+	}
+	return audit.Handler(handler, file.Sink(func(err error) {
+		log.Printf("Error writing audit log: %s", err)
+	}))
+}
@@ -0,0 +1,197 @@
+package memdb
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultSketchWidth is the number of counters per row of the access sketches
+	DefaultSketchWidth = 2048
+	// DefaultSketchDepth is the number of rows of the access sketches
+	DefaultSketchDepth = 4
+)
+
+// KeyAccess is a key together with its estimated number of reads and writes
+type KeyAccess struct {
+	Key    string `json:"key"`
+	Reads  uint64 `json:"reads"`
+	Writes uint64 `json:"writes"`
+}
+
+// countMinSketch estimates the number of occurrences of keys in bounded memory. Each key increments
+// one counter per row, and its estimate is the smallest of these counters: estimates may be too high
+// when keys share counters, but never too low.
+type countMinSketch struct {
+	width uint64
+	rows  [][]uint64
+}
+
+func newCountMinSketch(width int, depth int) *countMinSketch {
+	rows := make([][]uint64, depth)
+	for i := range rows {
+		rows[i] = make([]uint64, width)
+	}
+	return &countMinSketch{width: uint64(width), rows: rows}
+}
+
+// keyHash returns the two hashes of a key from which the counter of each row is derived
+func keyHash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+// add counts one occurrence of a key from its hashes
+func (s *countMinSketch) add(h1 uint64, h2 uint64) {
+	for i, row := range s.rows {
+		row[(h1+uint64(i)*h2)%s.width]++
+	}
+}
+
+// estimate returns the estimated number of occurrences of a key from its hashes
+func (s *countMinSketch) estimate(h1 uint64, h2 uint64) uint64 {
+	var min uint64
+	for i, row := range s.rows {
+		if c := row[(h1+uint64(i)*h2)%s.width]; i == 0 || c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// accessTracker counts the reads and writes of every key in two count-min sketches,
+// and keeps the k keys with the most accesses as candidates for the hot key report
+type accessTracker struct {
+	mu         sync.Mutex
+	k          int
+	reads      *countMinSketch
+	writes     *countMinSketch
+	candidates map[string]uint64 // Estimated accesses of the hot key candidates, as of their last access
+}
+
+// AccessStats enables per-key read and write counters, reporting the k most accessed keys.
+// The counters are estimated by count-min sketches of depth rows of width counters, which bounds their memory
+// whatever the number of keys; 0 selects DefaultSketchWidth and DefaultSketchDepth.
+func AccessStats(k int, width int, depth int) Option {
+	return func(db *DB) {
+		if k <= 0 {
+			return
+		}
+		if width <= 0 {
+			width = DefaultSketchWidth
+		}
+		if depth <= 0 {
+			depth = DefaultSketchDepth
+		}
+		db.access = &accessTracker{
+			k:          k,
+			reads:      newCountMinSketch(width, depth),
+			writes:     newCountMinSketch(width, depth),
+			candidates: make(map[string]uint64),
+		}
+	}
+}
+
+// record counts a read or a write of key
+func (t *accessTracker) record(key string, write bool) {
+	if t == nil {
+		return
+	}
+	h1, h2 := keyHash(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if write {
+		t.writes.add(h1, h2)
+	} else {
+		t.reads.add(h1, h2)
+	}
+	total := t.reads.estimate(h1, h2) + t.writes.estimate(h1, h2)
+
+	if _, ok := t.candidates[key]; ok || len(t.candidates) < t.k {
+		t.candidates[key] = total
+		return
+	}
+	// Replace the least accessed candidate if the key now has more accesses
+	var minKey string
+	var minTotal uint64
+	first := true
+	for k, c := range t.candidates {
+		if first || c < minTotal {
+			minKey, minTotal, first = k, c, false
+		}
+	}
+	if total > minTotal {
+		delete(t.candidates, minKey)
+		t.candidates[key] = total
+	}
+}
+
+// lookup returns the estimated reads and writes of key
+func (t *accessTracker) lookup(key string) KeyAccess {
+	h1, h2 := keyHash(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return KeyAccess{Key: key, Reads: t.reads.estimate(h1, h2), Writes: t.writes.estimate(h1, h2)}
+}
+
+// top returns the hot key candidates, sorted by decreasing number of accesses
+func (t *accessTracker) top() []KeyAccess {
+	t.mu.Lock()
+	keys := make([]string, 0, len(t.candidates))
+	for key := range t.candidates {
+		keys = append(keys, key)
+	}
+	t.mu.Unlock()
+
+	hot := make([]KeyAccess, 0, len(keys))
+	for _, key := range keys {
+		hot = append(hot, t.lookup(key))
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		ti, tj := hot[i].Reads+hot[i].Writes, hot[j].Reads+hot[j].Writes
+		if ti != tj {
+			return ti > tj
+		}
+		return hot[i].Key < hot[j].Key
+	})
+	return hot
+}
+
+// forget removes key from the hot key candidates. Its counters stay in the sketches, which don't hold keys.
+func (t *accessTracker) forget(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.candidates, key)
+}
+
+// recordAccess feeds an access to key to the hot key and access trackers that are enabled
+func (db *DB) recordAccess(key string, write bool) {
+	db.hotKeys.record(key)
+	db.access.record(key, write)
+}
+
+// KeyAccessStats returns the estimated number of reads and writes of key,
+// and false if access statistics are not enabled
+func (db *DB) KeyAccessStats(key string) (KeyAccess, bool) {
+	if db.access == nil {
+		return KeyAccess{}, false
+	}
+	return db.access.lookup(key), true
+}
+
+// AccessHotKeys returns the most accessed keys with their estimated reads and writes.
+// It returns nil if access statistics are not enabled.
+func (db *DB) AccessHotKeys() []KeyAccess {
+	if db.access == nil {
+		return nil
+	}
+	return db.access.top()
+}
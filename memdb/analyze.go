@@ -0,0 +1,238 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+)
+
+// AnalyzeOptions configures how Analyze groups keys by prefix
+type AnalyzeOptions struct {
+	Separator    string // A key's prefix ends after the first separator, e.g. "user:" for "user:42"
+	PrefixLength int    // Without a separator, or when a key doesn't contain it, the prefix is this many bytes
+	TopPrefixes  int    // Prefixes with the most keys listed in the analysis
+}
+
+// SizeBucket counts the sizes between the previous bucket and UpTo, inclusive
+type SizeBucket struct {
+	UpTo  int `json:"up_to"`
+	Count int `json:"count"`
+}
+
+// Distribution summarizes sizes in bytes. Percentiles are the upper bounds of the power of two buckets they fall in.
+type Distribution struct {
+	Count   int          `json:"count"`
+	Min     int          `json:"min"`
+	Max     int          `json:"max"`
+	Mean    float64      `json:"mean"`
+	P50     int          `json:"p50"`
+	P90     int          `json:"p90"`
+	P99     int          `json:"p99"`
+	Buckets []SizeBucket `json:"buckets"`
+}
+
+// PrefixCount is the number of live keys sharing a prefix
+type PrefixCount struct {
+	Prefix string `json:"prefix"`
+	Keys   int    `json:"keys"`
+}
+
+// TableAnalysis describes the content of one SSTable
+type TableAnalysis struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Entries     int    `json:"entries"`
+	Tombstones  int    `json:"tombstones"`
+	Shadowed    int    `json:"shadowed"` // Entries replaced by a newer SSTable, which a compaction would drop
+	SmallestKey string `json:"smallest_key"`
+	LargestKey  string `json:"largest_key"`
+}
+
+// Overlap describes two SSTables whose key ranges overlap
+type Overlap struct {
+	Older      string `json:"older"`
+	Newer      string `json:"newer"`
+	SharedKeys int    `json:"shared_keys"` // Keys present in both
+}
+
+// Analysis is the outcome of Analyze
+type Analysis struct {
+	Tables           []TableAnalysis `json:"tables"`
+	Entries          int             `json:"entries"`
+	Tombstones       int             `json:"tombstones"`
+	TombstoneRatio   float64         `json:"tombstone_ratio"`
+	DistinctKeys     int             `json:"distinct_keys"`
+	LiveKeys         int             `json:"live_keys"`
+	KeyLengths       Distribution    `json:"key_lengths"` // Over every entry
+	ValueSizes       Distribution    `json:"value_sizes"` // Over the live values
+	DistinctPrefixes int             `json:"distinct_prefixes"`
+	TopPrefixes      []PrefixCount   `json:"top_prefixes"`
+	Overlaps         []Overlap       `json:"overlaps"`
+}
+
+// histogram accumulates a Distribution in power of two buckets
+type histogram struct {
+	count, min, max, sum int
+	buckets              [33]int // Bucket i counts the sizes up to 2^i - 1, bucket 0 the empty ones
+}
+
+func (h *histogram) add(size int) {
+	if h.count == 0 || size < h.min {
+		h.min = size
+	}
+	if size > h.max {
+		h.max = size
+	}
+	h.count++
+	h.sum += size
+	h.buckets[min(bits.Len(uint(size)), len(h.buckets)-1)]++
+}
+
+// percentile returns the upper bound of the bucket holding the p-th percentile
+func (h *histogram) percentile(p float64) int {
+	rank := int(p * float64(h.count))
+	seen := 0
+	for i, count := range h.buckets {
+		seen += count
+		if seen > rank {
+			return min(1<<i-1, h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) distribution() Distribution {
+	d := Distribution{Count: h.count, Min: h.min, Max: h.max, Buckets: make([]SizeBucket, 0)}
+	if h.count == 0 {
+		return d
+	}
+	d.Mean = float64(h.sum) / float64(h.count)
+	d.P50, d.P90, d.P99 = h.percentile(0.5), h.percentile(0.9), h.percentile(0.99)
+	for i, count := range h.buckets {
+		if count > 0 {
+			d.Buckets = append(d.Buckets, SizeBucket{UpTo: 1<<i - 1, Count: count})
+		}
+	}
+	return d
+}
+
+// prefix returns the prefix a key is grouped under
+func (o AnalyzeOptions) prefix(key string) string {
+	if o.Separator != "" {
+		if i := strings.Index(key, o.Separator); i >= 0 {
+			return key[:i+len(o.Separator)]
+		}
+	}
+	if o.PrefixLength > 0 && len(key) > o.PrefixLength {
+		return key[:o.PrefixLength]
+	}
+	return key
+}
+
+// Analyze reads every SSTable of a directory and reports the shape of the key space: key length and value size
+// distributions, prefix cardinality, tombstones, and how much the SSTables overlap. It doesn't modify anything
+// and doesn't need the database to be closed, but SSTables flushed or compacted meanwhile may be missed.
+func Analyze(sstableDir string, opts AnalyzeOptions) (Analysis, error) {
+	analysis := Analysis{Tables: make([]TableAnalysis, 0), TopPrefixes: make([]PrefixCount, 0), Overlaps: make([]Overlap, 0)}
+	paths, err := listSSTables(sstableDir)
+	if err != nil && !os.IsNotExist(err) {
+		return analysis, err
+	}
+
+	type entry struct {
+		tables    []int // Indexes of the SSTables holding the key, oldest first
+		tombstone bool  // The newest entry is a deletion
+		valueSize int   // Size of the newest value
+	}
+	keys := make(map[string]*entry)
+	var keyLengths, valueSizes histogram
+
+	for i, path := range paths {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return analysis, err
+		}
+		sst, err := sstable.ReadSSTable(path)
+		if err != nil {
+			return analysis, err
+		}
+		table := TableAnalysis{Path: path, Size: fileInfo.Size(), Entries: len(sst.KeyValues)}
+		if len(sst.KeyValues) > 0 {
+			table.SmallestKey = string(sst.KeyValues[0].Key)
+			table.LargestKey = string(sst.KeyValues[len(sst.KeyValues)-1].Key)
+		}
+		for j, kv := range sst.KeyValues {
+			keyLengths.add(len(kv.Key))
+			if kv.Operation != sstable.OpSet {
+				table.Tombstones++
+			}
+			if kv.Operation == sstable.OpDelRange {
+				continue
+			}
+			e, ok := keys[string(kv.Key)]
+			if !ok {
+				e = &entry{}
+				keys[string(kv.Key)] = e
+			}
+			if n := len(e.tables); n == 0 || e.tables[n-1] != i {
+				e.tables = append(e.tables, i)
+			}
+			if !sstable.ShadowedByDeletion(sst.KeyValues, j) {
+				e.tombstone = kv.Operation == sstable.OpDel
+				e.valueSize = len(kv.Value)
+			}
+		}
+		analysis.Entries += table.Entries
+		analysis.Tombstones += table.Tombstones
+		analysis.Tables = append(analysis.Tables, table)
+	}
+
+	shared := make(map[[2]int]int)
+	prefixes := make(map[string]int)
+	for key, e := range keys {
+		for j, older := range e.tables[:len(e.tables)-1] {
+			analysis.Tables[older].Shadowed++
+			for _, newer := range e.tables[j+1:] {
+				shared[[2]int{older, newer}]++
+			}
+		}
+		if e.tombstone {
+			continue
+		}
+		analysis.LiveKeys++
+		valueSizes.add(e.valueSize)
+		prefixes[opts.prefix(key)]++
+	}
+	analysis.DistinctKeys = len(keys)
+	if analysis.Entries > 0 {
+		analysis.TombstoneRatio = float64(analysis.Tombstones) / float64(analysis.Entries)
+	}
+	analysis.KeyLengths = keyLengths.distribution()
+	analysis.ValueSizes = valueSizes.distribution()
+
+	analysis.DistinctPrefixes = len(prefixes)
+	for prefix, count := range prefixes {
+		analysis.TopPrefixes = append(analysis.TopPrefixes, PrefixCount{Prefix: prefix, Keys: count})
+	}
+	sort.Slice(analysis.TopPrefixes, func(i, j int) bool {
+		a, b := analysis.TopPrefixes[i], analysis.TopPrefixes[j]
+		return a.Keys > b.Keys || a.Keys == b.Keys && a.Prefix < b.Prefix
+	})
+	if len(analysis.TopPrefixes) > opts.TopPrefixes {
+		analysis.TopPrefixes = analysis.TopPrefixes[:max(opts.TopPrefixes, 0)]
+	}
+
+	// Key ranges are compared rather than shared keys only, as overlapping ranges slow down lookups too
+	for i, older := range analysis.Tables {
+		for j := i + 1; j < len(analysis.Tables); j++ {
+			newer := analysis.Tables[j]
+			if older.Entries == 0 || newer.Entries == 0 || older.LargestKey < newer.SmallestKey || newer.LargestKey < older.SmallestKey {
+				continue
+			}
+			analysis.Overlaps = append(analysis.Overlaps, Overlap{Older: older.Path, Newer: newer.Path, SharedKeys: shared[[2]int{i, j}]})
+		}
+	}
+	return analysis, nil
+}
@@ -0,0 +1,195 @@
+package memdb
+
+import (
+	"StorageEngine/vfs"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// BackupWALName is the name of the WAL file inside a backup directory
+	BackupWALName = "wal.log"
+	// BackupSSTableDirName is the name of the SSTable directory inside a backup directory
+	BackupSSTableDirName = "SSTableFiles"
+	// BackupManifestName is the name of the file describing a backup, written last
+	BackupManifestName = "BACKUP.json"
+)
+
+// ErrBackupDirNotEmpty is returned by Backup when the destination directory already holds files
+var ErrBackupDirNotEmpty = errors.New("Backup directory is not empty")
+
+// BackupFile describes a file of a backup
+type BackupFile struct {
+	Name    string    `json:"name"` // Path relative to the backup directory
+	Size    int64     `json:"size"`
+	CRC32   uint32    `json:"crc32"` // Checksum of the whole file
+	ModTime time.Time `json:"mod_time"`
+}
+
+// BackupManifest describes the content of a backup directory
+type BackupManifest struct {
+	Time          time.Time    `json:"time"`
+	EngineVersion string       `json:"engine_version"`
+	WALFormat     int          `json:"wal_format"`
+	SSTables      []BackupFile `json:"sstables"` // Oldest first
+	WAL           BackupFile   `json:"wal"`
+	WALRecords    int          `json:"wal_records"` // Unflushed records copied to the backup WAL
+	WALOffset     int64        `json:"wal_offset"`  // Offset of the source WAL at the time of the backup, where its later records start
+}
+
+// Backup takes a consistent snapshot of the database into destDir, which must be empty or not exist.
+// The memtable is flushed, then the live SSTables are hard-linked (or copied across file systems)
+// and the unflushed tail of the WAL is copied, while holding the write lock. SSTables are never modified
+// in place, so writes are only blocked for the time of the flush and the links.
+// destDir can be opened directly, with its BackupWALName WAL and its BackupSSTableDirName SSTable directory.
+// The manifest describing the backup is written last, so a backup without one is incomplete.
+func (db *DB) Backup(destDir string) (BackupManifest, error) {
+	manifest := BackupManifest{Time: time.Now(), EngineVersion: EngineVersion, WALFormat: WALFormatVersion, SSTables: make([]BackupFile, 0)}
+
+	if files, err := os.ReadDir(destDir); err == nil && len(files) > 0 {
+		return manifest, ErrBackupDirNotEmpty
+	} else if err != nil && !os.IsNotExist(err) {
+		return manifest, err
+	}
+	sstableDir := filepath.Join(destDir, BackupSSTableDirName)
+	if err := os.MkdirAll(sstableDir, 0755); err != nil {
+		return manifest, err
+	}
+
+	sstables, pending, offset, err := db.snapshotFiles(sstableDir)
+	if err != nil {
+		return manifest, err
+	}
+
+	// Write the unflushed records to the backup WAL
+	walPath := filepath.Join(destDir, BackupWALName)
+	wal, err := OpenWAL(walPath)
+	if err != nil {
+		return manifest, err
+	}
+	if err := wal.WriteEntries(pending); err != nil {
+		wal.Close()
+		return manifest, err
+	}
+	if err := wal.Close(); err != nil {
+		return manifest, err
+	}
+	manifest.WALRecords = len(pending)
+	manifest.WALOffset = offset
+
+	for _, path := range append(sstables, walPath) {
+		file, err := describeBackupFile(destDir, path)
+		if err != nil {
+			return manifest, err
+		}
+		if path == walPath {
+			manifest.WAL = file
+		} else {
+			manifest.SSTables = append(manifest.SSTables, file)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, vfs.WriteFileAtomic(vfs.OS, filepath.Join(destDir, BackupManifestName), data, 0644)
+}
+
+// snapshotFiles flushes the memtable, links the live SSTables into sstableDir and returns their new paths,
+// oldest first, together with the WAL records that are not flushed yet and the offset of the WAL
+func (db *DB) snapshotFiles(sstableDir string) ([]string, []WALRecord, int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.data) > 0 || len(db.ranges) > 0 {
+		if err := db.flushLocked(); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	paths := make([]string, 0, len(db.SSTableIDs))
+	for _, sstableID := range db.SSTableIDs {
+		path := filepath.Join(sstableDir, filepath.Base(sstableID))
+		if err := linkOrCopy(sstableID, path); err != nil {
+			return nil, nil, 0, err
+		}
+		paths = append(paths, path)
+	}
+	if err := writeManifest(sstableDir, paths); err != nil {
+		return nil, nil, 0, err
+	}
+
+	var pending []WALRecord
+	_, err := ScanWALFile(db.wal.file.Name(), func(entry WALEntry) error {
+		if !entry.Flushed {
+			pending = append(pending, entry.WALRecord)
+		}
+		return nil
+	})
+	return paths, pending, db.wal.MetaData.Offset, err
+}
+
+// linkOrCopy hard-links src to dst, or copies it if linking fails, keeping its modification time
+func linkOrCopy(src string, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile copies src to dst, which must not exist, keeping its modification time
+func copyFile(src string, dst string) error {
+	fileInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fileInfo.ModTime(), fileInfo.ModTime())
+}
+
+// describeBackupFile returns the size, checksum and modification time of a file of a backup
+func describeBackupFile(destDir string, path string) (BackupFile, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	name, err := filepath.Rel(destDir, path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	defer file.Close()
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, file); err != nil {
+		return BackupFile{}, err
+	}
+	return BackupFile{Name: filepath.ToSlash(name), Size: fileInfo.Size(), CRC32: crc.Sum32(), ModTime: fileInfo.ModTime()}, nil
+}
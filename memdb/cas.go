@@ -0,0 +1,62 @@
+package memdb
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// ErrCompareFailed is returned by CompareAndSwap when the current value of the key isn't the expected one
+var ErrCompareFailed = errors.New("Current value doesn't match the expected value")
+
+// CompareAndSwap sets key to new only if its current value is expected, or only if the key doesn't exist when
+// expected is nil, and returns ErrCompareFailed otherwise, leaving the key as it is. The comparison and the write
+// run under the write lock, so that clients can update a key optimistically: read it, compute the new value, and
+// retry from the read when another write came first. A value written by SetReader is compared whole.
+func (db *DB) CompareAndSwap(key string, expected []byte, new []byte) error {
+	return db.CompareAndSwapWith(key, expected, new, WriteOptions{})
+}
+
+// CompareAndSwapWith swaps a value like CompareAndSwap, with the durability of opts, see SetWith
+func (db *DB) CompareAndSwapWith(key string, expected []byte, new []byte, opts WriteOptions) (err error) {
+	if db.recorder != nil {
+		op := RecordedOp{Op: RecordCompareAndSwap, Key: key, Expected: expected, Absent: expected == nil, Value: new}
+		defer db.recorder.record(op, time.Now(), &err)
+	}
+	key, err = db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := db.checkSize(key, new); err != nil {
+		return err
+	}
+	if err := db.compareAndSwap(key, expected, new); err != nil {
+		return err
+	}
+	return db.syncWAL(opts)
+}
+
+// compareAndSwap implements CompareAndSwapWith for a key already validated
+func (db *DB) compareAndSwap(key string, expected []byte, new []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.recordAccess(key, true)
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+
+	current, err := db.get(key)
+	if err == nil {
+		current, err = db.resolveValue(key, current)
+	}
+	if err == ErrKeyNotFound {
+		if expected != nil {
+			return ErrCompareFailed
+		}
+	} else if err != nil {
+		return err
+	} else if expected == nil || !bytes.Equal(current, expected) {
+		return ErrCompareFailed
+	}
+	return db.set(key, new)
+}
@@ -0,0 +1,13 @@
+package memdb
+
+import "StorageEngine/sstable"
+
+// Checksum sets the algorithm of the checksums of the SSTables written by flushes, compactions and ingestions,
+// sstable.ChecksumCRC32 by default. Which one is the fastest depends on the CPU: CRC32 and CRC32C are computed with
+// CPU instructions on amd64 and arm64, xxHash64 is portable and beats them elsewhere. Every table records its
+// algorithm, so the tables written before a change stay readable and take the new one when compacted.
+func Checksum(c sstable.ChecksumType) Option {
+	return func(db *DB) {
+		db.checksum = c
+	}
+}
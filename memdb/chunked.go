@@ -0,0 +1,265 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A value written by SetReader that doesn't fit in one chunk is stored as chunks, each a record of its own under a
+// reserved key, then a manifest under the key itself listing them. The chunks of each write are named after an upload
+// ID, so that an upload interrupted half-way leaves the previous value whole: readers only follow the manifest, which
+// is written last. Chunks no manifest refers to any more, of overwritten or deleted values and of abandoned uploads,
+// are dropped by compactions.
+
+const (
+	// DefaultChunkSize is the size of the chunks of the values written by SetReader
+	DefaultChunkSize = 1 << 20
+	// chunkPrefix starts the keys of the chunks, in the reserved namespace
+	chunkPrefix = ReservedKeyPrefix + "chunk/"
+	// chunkMagic starts the manifests of the chunked values, which values written by Set can't start with
+	chunkMagic = "\x00CHUNKS\x01"
+	// chunkMemory is the size of the chunks SetReader leaves in the memtable before flushing it
+	chunkMemory = 64 << 20
+	// abandonedUpload is the age after which the chunks of an upload no manifest refers to are dropped, even if no
+	// newer upload replaced them: an upload may still be running, e.g. on the primary of a replica
+	abandonedUpload = time.Hour
+)
+
+var (
+	// ErrReservedValue is returned when a value written by Set starts like the manifest of a chunked value
+	ErrReservedValue = errors.New("Value starting with the bytes reserved for chunked values")
+	// ErrChunkMissing is returned by GetWriter when the value was overwritten while it was read, and its chunks dropped
+	ErrChunkMissing = errors.New("Chunk of the value missing, it was overwritten or deleted while being read")
+)
+
+// chunkManifest describes a chunked value
+type chunkManifest struct {
+	upload uint64 // Upload ID, the time the upload started in nanoseconds
+	chunks uint64 // Number of chunks
+	size   uint64 // Bytes of the value
+}
+
+// uploadSet holds the IDs of the running uploads, whose chunks compactions keep
+type uploadSet map[uint64]bool
+
+// ChunkSize sets the size of the chunks of the values written by SetReader, DefaultChunkSize if 0, at most the
+// largest value accepted by writes. SetReader flushes the memtable once it holds 64 MiB of chunks, so the memory
+// taken by a large value doesn't depend on its size.
+func ChunkSize(size int) Option {
+	return func(db *DB) {
+		db.chunkSize = size
+	}
+}
+
+// chunkKey returns the key of chunk index of upload of key
+func chunkKey(key string, upload uint64, index uint64) string {
+	return fmt.Sprintf("%s%s\x00%016x%08x", chunkPrefix, key, upload, index)
+}
+
+// parseChunkKey returns the key and the upload of a chunk key, ok is false if chunk isn't one
+func parseChunkKey(chunk string) (key string, upload uint64, ok bool) {
+	rest, found := strings.CutPrefix(chunk, chunkPrefix)
+	if !found || len(rest) < 25 || rest[len(rest)-25] != 0 {
+		return "", 0, false
+	}
+	if _, err := fmt.Sscanf(rest[len(rest)-24:len(rest)-8], "%016x", &upload); err != nil {
+		return "", 0, false
+	}
+	return rest[:len(rest)-25], upload, true
+}
+
+// encode returns the manifest as stored under the key of the value
+func (m chunkManifest) encode() []byte {
+	b := []byte(chunkMagic)
+	b = binary.AppendUvarint(b, m.upload)
+	b = binary.AppendUvarint(b, m.chunks)
+	return binary.AppendUvarint(b, m.size)
+}
+
+// decodeManifest returns the manifest stored as value, ok is false if value is a regular value
+func decodeManifest(value []byte) (m chunkManifest, ok bool) {
+	rest, found := bytes.CutPrefix(value, []byte(chunkMagic))
+	if !found {
+		return m, false
+	}
+	for _, field := range []*uint64{&m.upload, &m.chunks, &m.size} {
+		n := 0
+		if *field, n = binary.Uvarint(rest); n <= 0 {
+			return m, false
+		}
+		rest = rest[n:]
+	}
+	return m, true
+}
+
+// checkValue returns ErrReservedValue if value could be read as the manifest of a chunked value
+func checkValue(value []byte) error {
+	if bytes.HasPrefix(value, []byte(chunkMagic)) {
+		return ErrReservedValue
+	}
+	return nil
+}
+
+// SetReader sets key to the bytes read from r until io.EOF, which may be larger than the largest value accepted by
+// Set and than the memory: the value is stored in chunks of ChunkSize bytes, each one read, logged and added to the
+// memtable before the next one. A value that fits in one chunk is stored as a regular value. Get, Scan and the other
+// reads return the whole value, GetWriter streams it. The previous value of key stays visible until the last chunk
+// is written, and if r fails, the write is abandoned.
+func (db *DB) SetReader(key string, r io.Reader) error {
+	key, err := db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, db.chunkSize)
+	n, err := io.ReadFull(r, chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return db.setValidated(key, chunk[:n])
+	}
+	if err != nil {
+		return err
+	}
+
+	// Write the chunks, then the manifest naming them
+	m := chunkManifest{upload: uint64(time.Now().UnixNano())}
+	db.mu.Lock()
+	if db.uploads == nil {
+		db.uploads = make(uploadSet)
+	}
+	db.uploads[m.upload] = true
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		delete(db.uploads, m.upload)
+		db.mu.Unlock()
+	}()
+	for n > 0 {
+		if err := db.writeChunk(key, m, chunk[:n]); err != nil {
+			return err
+		}
+		m.chunks++
+		m.size += uint64(n)
+
+		chunk = make([]byte, db.chunkSize) // The memtable keeps the previous one
+		n, err = io.ReadFull(r, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.recordAccess(key, true)
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+	return db.set(key, m.encode())
+}
+
+// writeChunk writes the next chunk of the upload m of key, flushing the memtable every chunkMemory bytes
+func (db *DB) writeChunk(key string, m chunkManifest, chunk []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+	if err := db.set(chunkKey(key, m.upload, m.chunks), chunk); err != nil {
+		return err
+	}
+	if every := max(chunkMemory/db.chunkSize, 1); (m.chunks+1)%uint64(every) == 0 && len(db.keys) > 0 {
+		return db.flushLocked()
+	}
+	return nil
+}
+
+// GetWriter writes the value of key to w, a chunk at a time for a value written by SetReader, so that it never
+// needs to fit in memory. The lock is only held while a chunk is read: if the value is overwritten in the meantime,
+// GetWriter goes on with the chunks of the value it started with, or fails with ErrChunkMissing once a compaction
+// dropped them. It returns ErrKeyNotFound, before writing anything, if the key doesn't exist.
+func (db *DB) GetWriter(key string, w io.Writer) error {
+	key, err := db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+	db.mu.RLock()
+	db.recordAccess(key, false)
+	value, err := db.get(key)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	m, ok := decodeManifest(value)
+	if !ok {
+		_, err := w.Write(value)
+		return err
+	}
+
+	for index := uint64(0); index < m.chunks; index++ {
+		db.mu.RLock()
+		chunk, err := db.get(chunkKey(key, m.upload, index))
+		db.mu.RUnlock()
+		if err == ErrKeyNotFound {
+			return ErrChunkMissing
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveValue returns the whole value of key if value is the manifest of a chunked value, value otherwise.
+// The caller must hold the lock.
+func (db *DB) resolveValue(key string, value []byte) ([]byte, error) {
+	m, ok := decodeManifest(value)
+	if !ok {
+		return value, nil
+	}
+	whole := make([]byte, 0, m.size)
+	for index := uint64(0); index < m.chunks; index++ {
+		chunk, err := db.get(chunkKey(key, m.upload, index))
+		if err == ErrKeyNotFound {
+			return nil, ErrChunkMissing
+		}
+		if err != nil {
+			return nil, err
+		}
+		whole = append(whole, chunk...)
+	}
+	return whole, nil
+}
+
+// chunkFilter returns whether an entry of a compaction is kept: the chunks no manifest refers to any more go, those
+// of uploads older than the one of the current value of their key, and those of uploads older than abandonedUpload
+// the current value doesn't refer to, e.g. of a deleted value. It returns the same for an entry every time, so that
+// the entries of a compaction are the same at every pass over them. The caller must hold the write lock.
+func (db *DB) chunkFilter() func(kv sstable.KeyValuePair) (bool, error) {
+	now := time.Now()
+	current := make(map[string]uint64) // Upload of the current value of the keys with chunks, 0 for a regular value
+	return func(kv sstable.KeyValuePair) (bool, error) {
+		key, upload, ok := parseChunkKey(string(kv.Key))
+		if !ok || db.uploads[upload] {
+			return true, nil
+		}
+		live, seen := current[key]
+		if !seen {
+			value, err := db.get(key)
+			if err != nil && err != ErrKeyNotFound {
+				return false, err
+			}
+			m, _ := decodeManifest(value)
+			live, current[key] = m.upload, m.upload
+		}
+		abandoned := now.Sub(time.Unix(0, int64(upload))) > abandonedUpload
+		return upload == live || (upload > live && !abandoned), nil
+	}
+}
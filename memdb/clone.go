@@ -0,0 +1,73 @@
+package memdb
+
+import (
+	"StorageEngine/objstore"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrCloneDirNotEmpty is returned by Clone when the destination directory already holds files
+var ErrCloneDirNotEmpty = errors.New("Clone directory is not empty")
+
+// Clone creates in newDir, which must be empty or not exist, an independent copy of the database that can be opened
+// and written to without affecting it, e.g. to try a migration against production data. newDir is laid out like a
+// backup: open its BackupWALName WAL with OpenWAL and its BackupSSTableDirName SSTable directory with NewDB.
+// The live SSTables are hard-linked (or copied across file systems), so the copy takes no room until the two
+// databases diverge through their own WAL and flushes: SSTables are never modified in place, and a compaction of
+// one of them only removes its own link. Tables only kept in the object store are downloaded. The unflushed records
+// of the WAL are copied to the WAL of the clone, which rebuilds the memtable from them when opened.
+// Unlike Backup, the memtable isn't flushed, so the database is left as it is. Writes wait for the links and the
+// copy, reads don't. A clone of an encrypted database is opened with the same keys.
+func (db *DB) Clone(newDir string) error {
+	if files, err := os.ReadDir(newDir); err == nil && len(files) > 0 {
+		return ErrCloneDirNotEmpty
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sstableDir := filepath.Join(newDir, BackupSSTableDirName)
+	if err := os.MkdirAll(sstableDir, 0755); err != nil {
+		return err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, sstableID := range db.SSTableIDs {
+		path := filepath.Join(sstableDir, filepath.Base(sstableID))
+		err := linkOrCopy(sstableID, path)
+		if os.IsNotExist(err) && db.remote != nil {
+			err = objstore.GetFile(db.remote.store, objectName(sstableID), path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := writeManifest(sstableDir, db.SSTableIDs); err != nil {
+		return err
+	}
+
+	// The memtable goes to the WAL of the clone
+	wal, err := OpenWAL(filepath.Join(newDir, BackupWALName))
+	if err != nil {
+		return err
+	}
+	var pending []WALRecord
+	if _, err := ScanWALFile(db.wal.file.Name(), func(entry WALEntry) error {
+		if !entry.Flushed {
+			pending = append(pending, entry.WALRecord)
+		}
+		return nil
+	}); err != nil {
+		wal.Close()
+		return err
+	}
+	if err := wal.WriteEntries(pending); err != nil {
+		wal.Close()
+		return err
+	}
+	if err := wal.Sync(); err != nil {
+		wal.Close()
+		return err
+	}
+	return wal.Close()
+}
@@ -0,0 +1,331 @@
+package memdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lists and sets are stored one element per record, under reserved keys starting with the kind of the collection and
+// the key it is stored under, so that adding an element writes that element only instead of the whole collection.
+// The key is written with its length, e.g. "\x00list/6:orders", so that no collection is a prefix of another. The
+// elements of a list are numbered from listMiddle, down when pushed to the front and up when pushed to the back, the
+// index written in hexadecimal so that they sort in list order. The members of a set are written as they are after
+// the key, with an empty value. Collections don't collide with the regular keys and don't show in listings; the
+// records of their elements are written to the WAL, replicated and published by package cdc like any write.
+
+const (
+	// listPrefix starts the keys of the elements of the lists, in the reserved namespace
+	listPrefix = ReservedKeyPrefix + "list/"
+	// setPrefix starts the keys of the members of the sets, in the reserved namespace
+	setPrefix = ReservedKeyPrefix + "set/"
+	// listMiddle is the index of the first element pushed to an empty list
+	listMiddle = uint64(1) << 63
+	// listIndexDigits is the width of the indexes in the keys of list elements
+	listIndexDigits = 16
+)
+
+// listBounds are the indexes of the first element of a list and after its last one, equal if it is empty
+type listBounds struct {
+	head uint64
+	tail uint64
+}
+
+// listBoundsMap maps the key prefix of the lists pushed to or popped from, to their bounds
+type listBoundsMap map[string]listBounds
+
+// collectionPrefix returns the prefix of the keys of the elements of the collection stored under key
+func (db *DB) collectionPrefix(kind string, key string) (string, error) {
+	key, err := db.ValidateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return kind + strconv.Itoa(len(key)) + ":" + key, nil
+}
+
+// listElementKey returns the key of the element of a list at index
+func listElementKey(prefix string, index uint64) string {
+	return fmt.Sprintf("%s%0*x", prefix, listIndexDigits, index)
+}
+
+// scanCollection returns the elements of a collection, in key order, the caller must hold the lock
+func (db *DB) scanCollection(prefix string) ([]KeyValue, error) {
+	return db.scan(ScanOptions{Prefix: prefix, internal: true})
+}
+
+// listBoundsOf returns the bounds of a list, read from the data the first time, the caller must hold the write lock
+func (db *DB) listBoundsOf(prefix string) (listBounds, error) {
+	if bounds, ok := db.lists[prefix]; ok {
+		return bounds, nil
+	}
+	kvs, err := db.scanCollection(prefix)
+	if err != nil {
+		return listBounds{}, err
+	}
+	bounds := listBounds{head: listMiddle, tail: listMiddle}
+	for i, kv := range kvs {
+		index, err := strconv.ParseUint(strings.TrimPrefix(kv.Key, prefix), 16, 64)
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			bounds.head = index
+		}
+		bounds.tail = index + 1
+	}
+	if db.lists == nil {
+		db.lists = make(listBoundsMap)
+	}
+	db.lists[prefix] = bounds
+	return bounds, nil
+}
+
+// ListPush appends values to the back of the list stored under key, in order, and returns the length of the list
+func (db *DB) ListPush(key string, values ...[]byte) (int, error) {
+	return db.listPush(key, false, values)
+}
+
+// ListPushFront pushes values to the front of the list stored under key, one after the other, so that the last one
+// ends up first, and returns the length of the list
+func (db *DB) ListPushFront(key string, values ...[]byte) (int, error) {
+	return db.listPush(key, true, values)
+}
+
+func (db *DB) listPush(key string, front bool, values [][]byte) (int, error) {
+	prefix, err := db.collectionPrefix(listPrefix, key)
+	if err != nil {
+		return 0, err
+	}
+	length, err := db.listPushLocked(prefix, front, values)
+	if err != nil {
+		return 0, err
+	}
+	return length, db.syncWAL(WriteOptions{})
+}
+
+func (db *DB) listPushLocked(prefix string, front bool, values [][]byte) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return 0, err
+	}
+	bounds, err := db.listBoundsOf(prefix)
+	if err != nil {
+		return 0, err
+	}
+	for _, value := range values {
+		index := bounds.tail
+		if front {
+			index = bounds.head - 1
+		}
+		elementKey := listElementKey(prefix, index)
+		if err := db.checkSize(elementKey, value); err != nil {
+			return int(bounds.tail - bounds.head), err
+		}
+		if err := db.set(elementKey, value); err != nil {
+			return int(bounds.tail - bounds.head), err
+		}
+		if front {
+			bounds.head--
+		} else {
+			bounds.tail++
+		}
+		db.lists[prefix] = bounds
+	}
+	return int(bounds.tail - bounds.head), nil
+}
+
+// ListPop removes the first element of the list stored under key, or its last one if front is false, and returns
+// it. It returns ErrKeyNotFound if the list is empty.
+func (db *DB) ListPop(key string, front bool) ([]byte, error) {
+	prefix, err := db.collectionPrefix(listPrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := db.listPop(prefix, front)
+	if err != nil {
+		return nil, err
+	}
+	return value, db.syncWAL(WriteOptions{})
+}
+
+func (db *DB) listPop(prefix string, front bool) ([]byte, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return nil, err
+	}
+	bounds, err := db.listBoundsOf(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if bounds.head == bounds.tail {
+		return nil, ErrKeyNotFound
+	}
+	index := bounds.tail - 1
+	if front {
+		index = bounds.head
+	}
+	value, err := db.deleteReturning(listElementKey(prefix, index))
+	if err != nil {
+		return nil, err
+	}
+	if front {
+		bounds.head++
+	} else {
+		bounds.tail--
+	}
+	if bounds.head == bounds.tail {
+		bounds = listBounds{head: listMiddle, tail: listMiddle}
+	}
+	db.lists[prefix] = bounds
+	return value, nil
+}
+
+// ListRange returns the elements of the list stored under key from index start to index stop, both included,
+// counted from 0 at the front, or from -1 at the back if negative, like LRANGE. An empty or missing list, or a range
+// past its end, returns no elements.
+func (db *DB) ListRange(key string, start int, stop int) ([][]byte, error) {
+	prefix, err := db.collectionPrefix(listPrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	kvs, err := db.scanCollection(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start = max(len(kvs)+start, 0)
+	}
+	if stop < 0 {
+		stop = len(kvs) + stop
+	}
+	stop = min(stop, len(kvs)-1)
+	values := make([][]byte, 0)
+	for i := start; i <= stop; i++ {
+		values = append(values, kvs[i].Value)
+	}
+	return values, nil
+}
+
+// ListLen returns the number of elements of the list stored under key, 0 if there is none
+func (db *DB) ListLen(key string) (int, error) {
+	prefix, err := db.collectionPrefix(listPrefix, key)
+	if err != nil {
+		return 0, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if bounds, ok := db.lists[prefix]; ok {
+		return int(bounds.tail - bounds.head), nil
+	}
+	kvs, err := db.scanCollection(prefix)
+	return len(kvs), err
+}
+
+// SetAdd adds members to the set stored under key and returns how many of them it didn't hold yet
+func (db *DB) SetAdd(key string, members ...[]byte) (int, error) {
+	prefix, err := db.collectionPrefix(setPrefix, key)
+	if err != nil {
+		return 0, err
+	}
+	added, err := db.setAdd(prefix, members)
+	if err != nil {
+		return added, err
+	}
+	return added, db.syncWAL(WriteOptions{})
+}
+
+func (db *DB) setAdd(prefix string, members [][]byte) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, member := range members {
+		memberKey := prefix + string(member)
+		if _, err := db.get(memberKey); err == nil {
+			continue
+		} else if err != ErrKeyNotFound {
+			return added, err
+		}
+		if err := db.checkSize(memberKey, nil); err != nil {
+			return added, err
+		}
+		if err := db.set(memberKey, nil); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+// SetRemove removes members from the set stored under key and returns how many of them it held
+func (db *DB) SetRemove(key string, members ...[]byte) (int, error) {
+	prefix, err := db.collectionPrefix(setPrefix, key)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := db.setRemove(prefix, members)
+	if err != nil {
+		return removed, err
+	}
+	return removed, db.syncWAL(WriteOptions{})
+}
+
+func (db *DB) setRemove(prefix string, members [][]byte) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		_, err := db.deleteReturning(prefix + string(member))
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// SetMembers returns the members of the set stored under key, in ascending byte order, none if there is no set
+func (db *DB) SetMembers(key string) ([][]byte, error) {
+	prefix, err := db.collectionPrefix(setPrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	kvs, err := db.scanCollection(prefix)
+	if err != nil {
+		return nil, err
+	}
+	members := make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		members[i] = []byte(kv.Key[len(prefix):])
+	}
+	return members, nil
+}
+
+// SetContains reports whether the set stored under key holds member
+func (db *DB) SetContains(key string, member []byte) (bool, error) {
+	prefix, err := db.collectionPrefix(setPrefix, key)
+	if err != nil {
+		return false, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	_, err = db.get(prefix + string(member))
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,136 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CompactionReport describes an offline compaction
+type CompactionReport struct {
+	Inputs            []string `json:"inputs"`
+	Outputs           []string `json:"outputs"` // Empty if nothing was left to write
+	InputBytes        int64    `json:"input_bytes"`
+	OutputBytes       int64    `json:"output_bytes"`
+	InputEntries      int      `json:"input_entries"`
+	OutputEntries     int      `json:"output_entries"`
+	DroppedTombstones int      `json:"dropped_tombstones"`
+}
+
+// listSSTables returns the SSTables of a directory in the order the database reads them, oldest first: the tables its
+// MANIFEST lists, or without one all the tables found
+func listSSTables(sstableDir string) ([]string, error) {
+	m, err := readManifest(vfs.OS, sstableDir)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil {
+		return m.tables(sstableDir), nil
+	}
+	return listDirSSTables(sstableDir)
+}
+
+// listDirSSTables returns the SSTables found in a directory, ordered by generation or modification time
+func listDirSSTables(sstableDir string) ([]string, error) {
+	files, err := os.ReadDir(sstableDir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	modTimes := make(map[string]time.Time)
+	for _, file := range files {
+		if file.IsDir() || !isDBFile(file.Name()) {
+			continue
+		}
+		fileInfo, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(sstableDir, file.Name())
+		paths = append(paths, path)
+		modTimes[path] = fileInfo.ModTime()
+	}
+	sortSSTables(paths, modTimes)
+	return paths, nil
+}
+
+// CompactOffline merges every SSTable of a closed database into a single one, dropping overwritten values
+// and deletion markers, which is safe because all older versions are part of the merge.
+// With a targetFileSize, the output is split into SSTables of at most that many bytes instead, as by TargetFileSize.
+// The outputs are written before the inputs are removed and take the generation of the newest of them,
+// so an interruption never leaves an older table shadowing them.
+func CompactOffline(sstableDir string, targetFileSize int64) (CompactionReport, error) {
+	report := CompactionReport{Inputs: make([]string, 0), Outputs: make([]string, 0)}
+	inputs, err := listSSTables(sstableDir)
+	if err != nil || len(inputs) == 0 {
+		return report, err
+	}
+	report.Inputs = inputs
+	report.InputBytes = filesSize(vfs.OS, inputs)
+
+	// The tables are merged by iterators over their readers, twice: to build the outputs, then to write them
+	readers := make([]*sstable.Reader, 0, len(inputs))
+	defer func() {
+		for _, reader := range readers {
+			reader.Close()
+		}
+	}()
+	for _, input := range inputs {
+		reader, err := sstable.OpenReader(input)
+		if err != nil {
+			return report, err
+		}
+		readers = append(readers, reader)
+		report.InputEntries += reader.Len()
+	}
+	merge := func(counted bool) sstable.Iterator {
+		iterators := make([]sstable.Iterator, len(readers))
+		for i, reader := range readers {
+			iterators[i] = reader.NewIterator()
+		}
+		return newFilterIterator(sstable.WithRangeDeletionsIterator(sstable.NewMergingIterator(iterators, false)), func(kv sstable.KeyValuePair) (bool, error) {
+			if kv.Operation != sstable.OpSet && counted {
+				report.DroppedTombstones++
+			}
+			return kv.Operation == sstable.OpSet, nil
+		})
+	}
+
+	window := func(key []byte) int64 { return noWindow }
+	runs, entries, err := buildTables(merge(true), targetFileSize, window, func() *sstable.TableBuilder {
+		return sstable.NewTableBuilder(sstable.ChecksumCRC32)
+	})
+	if err != nil {
+		return report, err
+	}
+	report.OutputEntries = entries
+	if entries > 0 {
+		report.Outputs, err = writeBuiltTables(vfs.OS, merge(false), runs[noWindow], func() (string, error) {
+			return compactionFilename(vfs.OS, sstableDir, inputs, inputs[len(inputs)-1])
+		}, func(string, *sstable.Properties) {})
+		if err != nil {
+			return report, err
+		}
+		if err := vfs.OS.SyncDir(sstableDir); err != nil {
+			return report, err
+		}
+		report.OutputBytes = filesSize(vfs.OS, report.Outputs)
+	}
+	for _, reader := range readers {
+		reader.Close()
+	}
+	readers = nil
+
+	// The outputs replace the inputs in the manifest before they are removed
+	if err := logOffline(sstableDir, report.Outputs, inputs); err != nil {
+		return report, err
+	}
+	for _, input := range inputs {
+		if err := os.Remove(input); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,118 @@
+package memdb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a cursor token that wasn't returned by a paginated listing
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// cursorVersion starts the cursor tokens, to tell them from another format
+const cursorVersion = 1
+
+// Cursor is where a paginated listing resumes: after the last key of the previous page. It also carries the
+// sequence of the database when the first page was read, to tell whether the pages see one point in time.
+type Cursor struct {
+	After    string
+	Sequence uint64
+}
+
+// Token returns the cursor as an opaque string that can be used in a URL as it is
+func (c Cursor) Token() string {
+	b := binary.AppendUvarint([]byte{cursorVersion}, c.Sequence)
+	return base64.RawURLEncoding.EncodeToString(append(b, c.After...))
+}
+
+// ParseCursor returns the cursor of a token returned by Cursor.Token, or ErrInvalidCursor
+func ParseCursor(token string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) == 0 || b[0] != cursorVersion {
+		return Cursor{}, ErrInvalidCursor
+	}
+	sequence, n := binary.Uvarint(b[1:])
+	if n <= 0 || 1+n == len(b) {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{After: string(b[1+n:]), Sequence: sequence}, nil
+}
+
+// Sequence returns a number that changes with every write to the database, by a client, an ingestion, a purge or a
+// retention, but not with flushes and compactions, which keep the contents as they are. It starts at the time the
+// database was opened in nanoseconds, so that the sequence of a previous run is never taken for the current one.
+func (db *DB) Sequence() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sequence
+}
+
+// Page is a page of a paginated listing
+type Page struct {
+	KVs     []KeyValue
+	Cursor  string // Token of the next page, empty for the last page
+	Changed bool   // Whether the database was written since the first page was read
+}
+
+// ScanPage returns a page of at most opts.Limit of the live keys selected by opts, in ascending order, with their
+// values: the first page if cursor is empty, otherwise the page following the one that returned cursor. Each page
+// is read with an Iterator, seeking to the key after the last one of the previous page, so that listing a large
+// range costs no more than a scan of it, and no key is returned twice or skipped while writes continue between
+// pages. Such writes show in the later pages, though, which are then not a view of one point in time: Changed
+// reports it, from the sequence of the database recorded by the first page in the cursors. All pages must be read
+// with the same options.
+func (db *DB) ScanPage(opts ScanOptions, cursor string) (page Page, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordScan, Prefix: opts.Prefix, Start: opts.Start, End: opts.End, Limit: opts.Limit, Filtered: opts.Filter != nil, Paged: true, Cursor: cursor}, time.Now(), &err)
+	}
+	return db.page(opts, cursor, false)
+}
+
+// ListKeysPage returns a page of the live keys selected by opts, like ScanPage, without their values.
+// opts.Filter is ignored.
+func (db *DB) ListKeysPage(opts ScanOptions, cursor string) (page Page, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordListKeys, Prefix: opts.Prefix, Start: opts.Start, End: opts.End, Limit: opts.Limit, Paged: true, Cursor: cursor}, time.Now(), &err)
+	}
+	return db.page(opts, cursor, true)
+}
+
+// page implements ScanPage and ListKeysPage
+func (db *DB) page(opts ScanOptions, cursor string, keysOnly bool) (Page, error) {
+	var after Cursor
+	if cursor != "" {
+		var err error
+		if after, err = ParseCursor(cursor); err != nil {
+			return Page{}, err
+		}
+	}
+	it, err := db.NewIterator(IteratorOptions{Prefix: opts.Prefix, Start: opts.Start, End: opts.End, After: after.After, manifests: keysOnly})
+	if err != nil {
+		return Page{}, err
+	}
+	defer it.Close()
+
+	page := Page{KVs: make([]KeyValue, 0)}
+	sequence := it.Sequence()
+	if cursor != "" {
+		page.Changed = after.Sequence != sequence
+		sequence = after.Sequence
+	}
+	for ; it.Valid(); it.Next() {
+		if opts.Limit > 0 && len(page.KVs) == opts.Limit {
+			// There are more keys after the page
+			page.Cursor = Cursor{After: page.KVs[len(page.KVs)-1].Key, Sequence: sequence}.Token()
+			break
+		}
+		kv := KeyValue{Key: it.Key()}
+		if !keysOnly {
+			if opts.Filter != nil && !opts.Filter.Match(it.Value()) {
+				continue
+			}
+			kv.Value = it.Value()
+		}
+		page.KVs = append(page.KVs, kv)
+	}
+	return page, it.Err()
+}
@@ -0,0 +1,151 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrForeignFiles is returned by Destroy when the directory of the database holds files the engine did not create
+var ErrForeignFiles = errors.New("Database directory contains files not owned by the database")
+
+// isDBFile reports whether name is a file created by the database inside its SSTable directory
+func isDBFile(name string) bool {
+	return strings.HasSuffix(name, ".sst")
+}
+
+// isOwnedFile reports whether the entry name of the SSTable directory was created by the engine: tables, the
+// MANIFEST, the LOCK file, the quarantine and full-text index directories, and the temporary files of interrupted
+// rewrites, the MANIFEST's included
+func isOwnedFile(name string, dir bool) bool {
+	if dir {
+		return name == QuarantineDirName || name == SearchDir
+	}
+	return isDBFile(name) || strings.HasSuffix(name, ".tmp") || name == ManifestFileName || name == LockFileName
+}
+
+// Destroy removes a closed database from dir, laid out like a backup or a tenant: the BackupWALName WAL and its
+// temporary file, the BackupManifestName of a restored backup, the BackupSSTableDirName directory with every SSTable
+// (quarantined ones included), the MANIFEST and the segments of the full-text index, then dir itself.
+// Nothing is removed if dir or the SSTable directory contains files that do not belong to the database,
+// so a wrong path can't wipe out unrelated data.
+func Destroy(fsys vfs.FS, dir string) error {
+	files, err := fsys.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sstableDir := filepath.Join(dir, BackupSSTableDirName)
+	tables, err := fsys.ReadDir(sstableDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Check everything first, then delete
+	for _, file := range files {
+		switch name := file.Name(); {
+		case file.IsDir() && name == BackupSSTableDirName:
+		case !file.IsDir() && (name == BackupWALName || name == BackupWALName+".tmp" || name == BackupManifestName):
+		default:
+			return ErrForeignFiles
+		}
+	}
+	for _, file := range tables {
+		if !isOwnedFile(file.Name(), file.IsDir()) {
+			return ErrForeignFiles
+		}
+	}
+
+	// The tables go first and the WAL last, so that an interrupted Destroy is completed by running it again
+	if err := fsys.RemoveAll(sstableDir); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := fsys.RemoveAll(filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	if err := fsys.RemoveAll(dir); err != nil {
+		return err
+	}
+	return fsys.SyncDir(filepath.Dir(dir))
+}
+
+// DropAll discards all the data of the database while keeping it open and its directory in place.
+// The memtable is first flushed, so that the WAL holds no record that isn't in an SSTable, and the segments of the
+// full-text index are removed; then a single MANIFEST edit removes every table, the commit point: a crash before it
+// leaves the database as it was, a crash after it an empty database, whose leftover tables are listed nowhere.
+// Only then are the tables deleted and the WAL truncated. An error before the commit point leaves the database as it
+// was, one after it leaves the data discarded.
+func (db *DB) DropAll() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+
+	// Nothing left to replay: every record of the WAL is in the tables about to be removed
+	if len(db.data) > 0 || len(db.ranges) > 0 {
+		if err := db.flushLocked(); err != nil {
+			return err
+		}
+	}
+	if err := db.wal.markFlushed(); err != nil {
+		return err
+	}
+	if err := db.wal.Sync(); err != nil {
+		return err
+	}
+	// Without its segments, the full-text index is rebuilt from the data when the database opens. If the data stays,
+	// the index in memory is written back as a single segment.
+	restore := func(err error) error {
+		if db.search != nil && db.search.dir != "" {
+			db.search.writeBase(db.fs, db.data, db.generation)
+		}
+		return err
+	}
+	if err := db.search.removeSegments(db.fs); err != nil {
+		return restore(err)
+	}
+
+	// The commit point
+	dropped := db.SSTableIDs
+	db.SSTableIDs = make([]string, 0)
+	if err := db.logTables(); err != nil {
+		db.SSTableIDs = dropped
+		return restore(err)
+	}
+
+	// Clear in-memory state
+	db.data = make(map[string]sstable.Pair)
+	db.keys = make([]string, 0)
+	db.ranges = nil
+	db.sequence++
+	db.values.clear()
+	db.indexes.clear()
+	db.search.clear()
+	db.streams = nil
+	db.lists = nil
+	for _, sstableID := range dropped {
+		db.readers.evict(sstableID)
+		if db.timeSeries != nil {
+			delete(db.timeSeries.windows, sstableID)
+		}
+	}
+
+	// Truncate the WAL, whose records are all marked flushed, and delete the files of the tables
+	if err := db.wal.Reset(); err != nil {
+		return err
+	}
+	for _, sstableID := range dropped {
+		if err := db.fs.Remove(sstableID); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return db.syncRemote()
+}
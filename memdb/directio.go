@@ -0,0 +1,32 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"bytes"
+)
+
+// DirectIO makes the reads of whole SSTables, by compactions, scans, key listings and the scrubber, bypass
+// the page cache of the operating system, so that they don't evict the pages point lookups keep reading.
+// Lookups still go through the page cache. It only applies to the files of vfs.OS.
+func DirectIO(enabled bool) Option {
+	return func(db *DB) {
+		db.directIO = enabled
+	}
+}
+
+// readSSTableFile reads a whole SSTable from the SSTable directory, around the page cache if DirectIO is set
+func (db *DB) readSSTableFile(sstableID string) (*sstable.SSTable, error) {
+	if !db.directIO || sstable.BaseFS(db.fs) != vfs.OS {
+		return sstable.ReadSSTableFS(db.fs, sstableID)
+	}
+	enc, err := sstable.EncryptionOf(db.fs)
+	if err != nil {
+		return nil, err
+	}
+	data, err := vfs.ReadFileDirect(sstableID)
+	if err != nil {
+		return nil, err
+	}
+	return sstable.ReadSSTableAtWith(bytes.NewReader(data), enc)
+}
@@ -0,0 +1,114 @@
+package memdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrLowDiskSpace is returned by writes while free disk space is below the MinFreeSpace threshold
+var ErrLowDiskSpace = errors.New("Not enough free disk space, the database is read-only")
+
+// errDiskUsageUnsupported is returned by freeSpace on platforms where free space can't be queried
+var errDiskUsageUnsupported = errors.New("Disk usage is not supported on this platform")
+
+// diskCheckInterval is the minimum time between two free space checks on the write path
+const diskCheckInterval = time.Second
+
+// DiskStats reports the free space of the volumes holding the SSTables and the WAL
+type DiskStats struct {
+	SSTableFreeBytes uint64 `json:"sstable_free_bytes"`
+	WALFreeBytes     uint64 `json:"wal_free_bytes"`
+	MinFreeBytes     uint64 `json:"min_free_bytes"` // Threshold below which writes are refused, 0 if disabled
+	ReadOnly         bool   `json:"read_only"`      // Whether writes are refused because of low disk space
+}
+
+// diskMonitor refuses writes when a volume used by the database runs low on space
+type diskMonitor struct {
+	mu        sync.Mutex
+	minFree   uint64
+	lastCheck time.Time
+	readOnly  bool
+}
+
+// MinFreeSpace switches the database to read-only when the free space of the SSTable or WAL volume
+// falls below minFree bytes, so that writes fail early with ErrLowDiskSpace instead of in the middle of a flush.
+// Writes are accepted again once space is freed.
+func MinFreeSpace(minFree uint64) Option {
+	return func(db *DB) {
+		db.minFreeSpace = minFree
+	}
+}
+
+// existingDir returns path, or its closest existing parent, so free space can be queried
+// before the SSTable directory is created
+func existingDir(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// diskFree returns the free space of the SSTable and WAL volumes
+func (db *DB) diskFree() (sstableFree uint64, walFree uint64, err error) {
+	sstableFree, err = freeSpace(existingDir(db.sstableDir))
+	if err != nil {
+		return 0, 0, err
+	}
+	walFree, err = freeSpace(existingDir(filepath.Dir(db.wal.file.Name())))
+	if err != nil {
+		return 0, 0, err
+	}
+	return sstableFree, walFree, nil
+}
+
+// checkDisk returns ErrLowDiskSpace if writes must be refused.
+// Free space is queried at most once per diskCheckInterval.
+func (db *DB) checkDisk() error {
+	m := db.disk
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.lastCheck) >= diskCheckInterval {
+		sstableFree, walFree, err := db.diskFree()
+		if err != nil && err != errDiskUsageUnsupported {
+			return err
+		}
+		m.readOnly = err == nil && (sstableFree < m.minFree || walFree < m.minFree)
+		m.lastCheck = time.Now()
+	}
+	if m.readOnly {
+		return ErrLowDiskSpace
+	}
+	return nil
+}
+
+// diskStats returns the disk statistics, or nil if free space can't be queried on this platform
+func (db *DB) diskStats() (*DiskStats, error) {
+	sstableFree, walFree, err := db.diskFree()
+	if err == errDiskUsageUnsupported {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats := &DiskStats{SSTableFreeBytes: sstableFree, WALFreeBytes: walFree}
+	if db.disk != nil {
+		db.disk.mu.Lock()
+		stats.MinFreeBytes = db.disk.minFree
+		stats.ReadOnly = db.disk.readOnly
+		db.disk.mu.Unlock()
+	}
+	return stats, nil
+}
@@ -0,0 +1,247 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Severities of the findings of Doctor, from the least to the most serious
+const (
+	SeverityInfo    = "info"    // Worth knowing, nothing to fix
+	SeverityWarning = "warning" // The database opens, but something should be fixed
+	SeverityError   = "error"   // Data is unreadable or may be read wrong
+)
+
+// Finding is a problem found by Doctor, with what to do about it
+type Finding struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Problem  string `json:"problem"`
+	Action   string `json:"action,omitempty"`
+}
+
+// DoctorReport is the outcome of Doctor
+type DoctorReport struct {
+	Time       time.Time `json:"time"`
+	Tables     int       `json:"tables"`      // SSTables checked
+	WALRecords int       `json:"wal_records"` // WAL records checked
+	Findings   []Finding `json:"findings"`
+}
+
+// Worst returns the most serious severity among the findings, empty if there are none
+func (r DoctorReport) Worst() string {
+	rank := map[string]int{SeverityInfo: 1, SeverityWarning: 2, SeverityError: 3}
+	worst := ""
+	for _, finding := range r.Findings {
+		if rank[finding.Severity] > rank[worst] {
+			worst = finding.Severity
+		}
+	}
+	return worst
+}
+
+// add records a finding
+func (r *DoctorReport) add(severity string, path string, action string, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Severity: severity, Path: path, Problem: fmt.Sprintf(format, args...), Action: action})
+}
+
+// Doctor checks the files of a closed database without modifying them:
+//   - every SSTable is readable, in a supported format, with its checksum and keys in order,
+//     and without bytes past its end that reads would ignore;
+//   - the MANIFEST can be read and the SSTables it lists exist, or, in a directory without one, the SSTables named
+//     by time have distinct modification times, which give the order they are read in;
+//   - no leftovers of interrupted rewrites, SSTables the MANIFEST doesn't list or quarantined tables are lying around;
+//   - the WAL metadata matches the file and every record decodes.
+//
+// SSTables all belong to level 0, where overlapping key ranges are expected: overlaps are reported for information.
+// The error is only set when the checks couldn't run, problems with the files are findings.
+func Doctor(walPath string, sstableDir string) (DoctorReport, error) {
+	report := DoctorReport{Time: time.Now(), Findings: make([]Finding, 0)}
+	if err := doctorSSTables(&report, sstableDir); err != nil {
+		return report, err
+	}
+	_, err := doctorWAL(&report, vfs.OS, walPath)
+	return report, err
+}
+
+// doctorSSTables checks the SSTable directory
+func doctorSSTables(report *DoctorReport, sstableDir string) error {
+	files, err := os.ReadDir(sstableDir)
+	if os.IsNotExist(err) {
+		report.add(SeverityInfo, sstableDir, "", "The SSTable directory doesn't exist, the database has never been flushed")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The MANIFEST lists the tables the database reads, a directory written by an earlier version has none
+	m, err := readManifest(vfs.OS, sstableDir)
+	if err == ErrCorruptedManifest {
+		report.add(SeverityError, filepath.Join(sstableDir, ManifestFileName), "Run cmd/repair, which writes it again from the SSTables",
+			"%s, the database can't be opened", err)
+	} else if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		path := filepath.Join(sstableDir, file.Name())
+		switch {
+		case file.IsDir() && file.Name() == QuarantineDirName:
+			quarantined, err := os.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			if len(quarantined) > 0 {
+				report.add(SeverityInfo, path, "Inspect them, then delete them or run cmd/repair to salvage their entries",
+					"%d corrupted SSTables are quarantined", len(quarantined))
+			}
+		case strings.HasSuffix(file.Name(), ".tmp"):
+			report.add(SeverityWarning, path, "Delete it, or run cmd/repair", "Leftover of an interrupted rewrite")
+		case m != nil && !file.IsDir() && isDBFile(file.Name()):
+			if _, ok := m.live[file.Name()]; !ok {
+				report.add(SeverityWarning, path, "Delete it, or run cmd/repair",
+					"SSTable the MANIFEST doesn't list, left by an interrupted flush or compaction")
+			}
+		}
+	}
+
+	var tables []string
+	if m != nil {
+		tables = m.tables(sstableDir)
+	} else if tables, err = listDirSSTables(sstableDir); err != nil {
+		return err
+	}
+	type bounds struct {
+		path              string
+		smallest, largest []byte
+	}
+	var ranges []bounds
+	var lastTime time.Time
+	for i, path := range tables {
+		report.Tables++
+		fileInfo, err := os.Stat(path)
+		if os.IsNotExist(err) && m != nil {
+			report.add(SeverityError, path, "Restore the database from a backup, unless the table is kept in an object store",
+				"SSTable listed in the MANIFEST missing from the directory, its keys can't be read")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, _, ok := parseGeneration(path); !ok && m == nil && i > 0 && fileInfo.ModTime().Equal(lastTime) {
+			report.add(SeverityWarning, path, "Run cmd/repair, which gives every SSTable a distinct modification time",
+				"Same modification time as %s, the order of the two tables is ambiguous", filepath.Base(tables[i-1]))
+		}
+		lastTime = fileInfo.ModTime()
+
+		sst, err := sstable.ReadSSTable(path)
+		if err != nil {
+			report.add(SeverityError, path, "Run cmd/repair to salvage the readable entries", "Unreadable SSTable: %s", err)
+			continue
+		}
+		if checkSSTable(report, path, fileInfo.Size(), sst) && len(sst.KeyValues) > 0 {
+			ranges = append(ranges, bounds{path: path, smallest: sst.KeyValues[0].Key, largest: sst.KeyValues[len(sst.KeyValues)-1].Key})
+		}
+	}
+
+	// Count the tables whose key range overlaps another one
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].smallest, ranges[j].smallest) < 0 })
+	overlapping := make(map[string]bool)
+	for i := 1; i < len(ranges); i++ {
+		for j := 0; j < i; j++ {
+			if bytes.Compare(ranges[j].largest, ranges[i].smallest) >= 0 {
+				overlapping[ranges[i].path], overlapping[ranges[j].path] = true, true
+			}
+		}
+	}
+	if len(overlapping) > 0 {
+		report.add(SeverityInfo, sstableDir, "Run cmd/compact to merge them if lookups are slow",
+			"%d of the %d SSTables of level 0 have overlapping key ranges, a lookup may read all of them", len(overlapping), len(tables))
+	}
+	return nil
+}
+
+// checkSSTable reports the problems of an SSTable read from a file of size bytes, -1 if unknown: unknown format,
+// keys out of order or bytes past its end. It returns false if the table isn't in a format it can check.
+func checkSSTable(report *DoctorReport, path string, size int64, sst *sstable.SSTable) bool {
+	if sst.Header.MagicNumber != 221003 {
+		report.add(SeverityError, path, "Check that the file is an SSTable, or move it out of the directory",
+			"Unknown magic number %d", sst.Header.MagicNumber)
+		return false
+	}
+	if !supportedSSTableVersion(sst.Header.Version) {
+		report.add(SeverityError, path, "Open the database with a build that supports it, or run its cmd/migrate",
+			"Unsupported format version %d", sst.Header.Version)
+		return false
+	}
+
+	for j, kv := range sst.KeyValues {
+		if j > 0 && bytes.Compare(kv.Key, sst.KeyValues[j-1].Key) < 0 {
+			report.add(SeverityError, path, "Run cmd/compact to rewrite the table in order",
+				"Key %q of entry %d is out of order, lookups may miss it", kv.Key, j)
+			break
+		}
+	}
+	// The blocks of encrypted tables take more room than their entries
+	if extra := size - sst.EncodedSize(); size >= 0 && !sst.Header.Encrypted && extra > 0 {
+		report.add(SeverityError, path, "Inspect the file: another table may have been appended to it, as flushes within the same second did before SSTables were named by generation",
+			"%d bytes past the end of the table are ignored by reads", extra)
+	}
+	return true
+}
+
+// doctorWAL checks the WAL metadata and records of a file of fsys, and returns the metadata read from it, zero if
+// it couldn't be read
+func doctorWAL(report *DoctorReport, fsys vfs.FS, walPath string) (WALMetadata, error) {
+	fileInfo, err := fsys.Stat(walPath)
+	if os.IsNotExist(err) {
+		report.add(SeverityInfo, walPath, "", "The WAL doesn't exist, it is created when the database is opened")
+		return WALMetadata{}, nil
+	}
+	if err != nil {
+		return WALMetadata{}, err
+	}
+	if fileInfo.Size() < WALMetadataSize {
+		report.add(SeverityError, walPath, "Run cmd/repair to rewrite the metadata", "The WAL is shorter than its metadata")
+		return WALMetadata{}, nil
+	}
+
+	invalid := false
+	flushedEnd := int64(WALMetadataSize) // End of the last record below the watermark
+	meta, err := scanWALFile(fsys, walPath, WALMetadataSize, func(entry WALEntry) error {
+		report.WALRecords++
+		if entry.Operation > OpBatch && !invalid {
+			invalid = true
+			report.add(SeverityError, walPath, "Run cmd/repair to cut the WAL before it",
+				"Record %d at offset %d has an unknown operation %d", entry.Seq, entry.Position, entry.Operation)
+		}
+		if entry.Flushed {
+			flushedEnd = entry.Position + entry.Size
+		}
+		return nil
+	})
+	if meta.Watermark > meta.Offset {
+		report.add(SeverityError, walPath, "Run cmd/repair to move the watermark back",
+			"The watermark %d is past the offset %d", meta.Watermark, meta.Offset)
+	}
+	if err != nil {
+		report.add(SeverityError, walPath, "Run cmd/repair to cut the WAL after its last readable record", "%s", err)
+		return meta, nil
+	}
+	if meta.Watermark <= meta.Offset && flushedEnd != meta.Watermark {
+		report.add(SeverityError, walPath, "Run cmd/repair to move the watermark back onto a record boundary",
+			"The watermark %d falls inside a record, recovery would start mid-record", meta.Watermark)
+	}
+	if extra := fileInfo.Size() - meta.Offset; extra > 0 {
+		report.add(SeverityWarning, walPath, "Run cmd/repair before opening the database to recover the complete records among them",
+			"%d bytes past the last complete record are ignored by recovery and cut when the WAL is opened", extra)
+	}
+	return meta, nil
+}
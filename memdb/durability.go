@@ -0,0 +1,33 @@
+package memdb
+
+// SyncMode tells whether a write flushes the WAL to stable storage before it is acknowledged
+type SyncMode int
+
+const (
+	SyncDefault SyncMode = iota // As set by the SyncWrites option of the database
+	SyncOn                      // The write survives a power loss once acknowledged
+	SyncOff                     // The write survives a crash of the process once acknowledged, not a power loss
+)
+
+// WriteOptions are the options of a single write, the zero value being the defaults of the database
+type WriteOptions struct {
+	Sync SyncMode
+}
+
+// SyncWrites flushes the WAL to stable storage before acknowledging the writes whose options don't say otherwise,
+// so that they survive a power loss and not only a crash of the process, at the cost of a disk sync per write.
+// Writes are not synced by default.
+func SyncWrites(enabled bool) Option {
+	return func(db *DB) {
+		db.syncWrites = enabled
+	}
+}
+
+// syncWAL flushes the WAL to stable storage after a write made with opts, if they ask for it. It is called once the
+// write lock is released, so that writers that don't sync don't wait for the disk.
+func (db *DB) syncWAL(opts WriteOptions) error {
+	if opts.Sync == SyncOn || (opts.Sync == SyncDefault && db.syncWrites) {
+		return db.wal.Sync()
+	}
+	return nil
+}
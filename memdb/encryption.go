@@ -0,0 +1,30 @@
+package memdb
+
+import "StorageEngine/sstable"
+
+// keySource returns the encryption of the SSTables, set through the Encryption and EncryptionKeys options
+type keySource func() (*sstable.Encryption, error)
+
+// Encryption encrypts the SSTables the database writes, and the segments of its full-text index, with AES-GCM
+// and key, 16, 24 or 32 bytes long for AES-128, AES-192 or AES-256, so that they are unreadable without it.
+// Without the option, the key of sstable.EncryptionKeyEnv is used if it is set. Tables written in clear before
+// stay readable and are encrypted once a compaction rewrites them. The WAL isn't encrypted.
+func Encryption(key []byte) Option {
+	return func(db *DB) {
+		db.encryption = func() (*sstable.Encryption, error) {
+			return sstable.NewEncryption(key)
+		}
+	}
+}
+
+// EncryptionKeys encrypts the SSTables like Encryption, with named keys fetched from provider, e.g. a client of a key
+// management service: new tables are encrypted with the key named current, and existing ones are read with the key
+// they were encrypted with. To rotate keys, open the database with a new current key: compactions encrypt the tables
+// they rewrite with it, so older keys must stay available until ListSSTables reports no table still using them.
+func EncryptionKeys(provider sstable.KeyProvider, current string) Option {
+	return func(db *DB) {
+		db.encryption = func() (*sstable.Encryption, error) {
+			return sstable.NewKeyring(provider, current)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package memdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ETag returns an entity tag of the value of key, quoted as in an HTTP ETag header, which changes whenever the value
+// does: a hash of the value, or of the manifest of a value written by SetReader, so that the chunks of a large value
+// aren't read. It returns ErrKeyNotFound like Get. A tag taken before reading the value is never newer than the
+// value read, so a client that keeps them together at worst reads an unchanged value again.
+func (db *DB) ETag(key string) (string, error) {
+	key, err := db.ValidateKey(key)
+	if err != nil {
+		return "", err
+	}
+	db.mu.RLock()
+	value, err := db.get(key)
+	db.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(value)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
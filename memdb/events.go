@@ -0,0 +1,102 @@
+package memdb
+
+import (
+	"StorageEngine/vfs"
+	"sync"
+	"time"
+)
+
+// DefaultEventHistory is the default number of engine events kept in memory
+const DefaultEventHistory = 64
+
+// Types of engine events
+const (
+	EventFlush      = "flush"
+	EventCompaction = "compaction"
+	EventPurge      = "purge"
+	EventIngest     = "ingest"
+	EventWarmup     = "warmup"
+	EventRetention  = "retention"
+)
+
+// Event describes a flush, compaction, purge, ingestion, warmup or retention pass performed by the engine
+type Event struct {
+	Type        string        `json:"type"`
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration"`
+	Inputs      []string      `json:"inputs,omitempty"` // SSTables read
+	Outputs     []string      `json:"outputs"`          // SSTables written
+	InputBytes  int64         `json:"input_bytes"`
+	OutputBytes int64         `json:"output_bytes"`
+	Entries     int           `json:"entries"` // Keys written to the outputs, deletion markers included
+	Error       string        `json:"error,omitempty"`
+}
+
+// eventLog is a bounded history of the most recent events
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event // Ring buffer
+	next   int     // Index of the next event to write
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]Event, size)}
+}
+
+// EventHistory sets the number of recent flush and compaction events kept by the database
+func EventHistory(size int) Option {
+	return func(db *DB) {
+		if size > 0 {
+			db.events = newEventLog(size)
+		}
+	}
+}
+
+// add records an event, replacing the oldest one if the history is full
+func (l *eventLog) add(event Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the events from the oldest to the most recent
+func (l *eventLog) list() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]Event(nil), l.events[:l.next]...)
+	}
+	return append(append([]Event(nil), l.events[l.next:]...), l.events[:l.next]...)
+}
+
+// Events returns the recent flush, compaction and purge events, from the oldest to the most recent
+func (db *DB) Events() []Event {
+	return db.events.list()
+}
+
+// recordEvent completes an event started at event.Start and adds it to the history.
+// InputBytes must be set by the caller, as inputs may be gone by now.
+func (db *DB) recordEvent(event Event, err error) {
+	event.Duration = time.Since(event.Start)
+	event.OutputBytes = filesSize(db.fs, event.Outputs)
+	if err != nil {
+		event.Error = err.Error()
+	}
+	db.events.add(event)
+}
+
+// filesSize returns the total size of the files of fsys that still exist
+func filesSize(fsys vfs.FS, paths []string) int64 {
+	var size int64
+	for _, path := range paths {
+		if fileInfo, err := fsys.Stat(path); err == nil {
+			size += fileInfo.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,35 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Expiring values are the values of the keys kept for a time, leases and the sessions of package sessions, until
+// the database has TTLs of its own: entries carry no expiry, so the expiry is stored before the data in the value,
+// in nanoseconds since 1970, and checked by whoever reads it against its clock. An expired value stays stored until
+// it is deleted or overwritten. Keeping a single encoding leaves one thing to move once keys can expire.
+
+// expirySize is the size of the expiry at the start of an expiring value
+const expirySize = 8
+
+// EncodeExpiring returns the value holding data until expires
+func EncodeExpiring(expires time.Time, data []byte) []byte {
+	value := make([]byte, expirySize, expirySize+len(data))
+	binary.BigEndian.PutUint64(value, uint64(expires.UnixNano()))
+	return append(value, data...)
+}
+
+// DecodeExpiring returns the expiry and the data of a value written by EncodeExpiring, ok is false if it is too
+// short to be one
+func DecodeExpiring(value []byte) (expires time.Time, data []byte, ok bool) {
+	if len(value) < expirySize {
+		return time.Time{}, nil, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value[:expirySize]))), value[expirySize:], true
+}
+
+// Expired reports whether a value expiring at expires has expired at now
+func Expired(expires time.Time, now time.Time) bool {
+	return !now.Before(expires)
+}
@@ -0,0 +1,93 @@
+package memdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// ValueFilter selects values during a scan
+type ValueFilter interface {
+	Match(value []byte) bool
+}
+
+// FilterFunc adapts a function to the ValueFilter interface
+type FilterFunc func(value []byte) bool
+
+// Match calls f(value)
+func (f FilterFunc) Match(value []byte) bool {
+	return f(value)
+}
+
+// AllOf matches values matched by every filter
+func AllOf(filters ...ValueFilter) ValueFilter {
+	return FilterFunc(func(value []byte) bool {
+		for _, filter := range filters {
+			if !filter.Match(value) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// ValueSizeBetween matches values whose size is between min and max bytes, inclusive.
+// A max of 0 means no upper bound.
+func ValueSizeBetween(min int, max int) ValueFilter {
+	return FilterFunc(func(value []byte) bool {
+		return len(value) >= min && (max == 0 || len(value) <= max)
+	})
+}
+
+// jsonField returns the element at path in a JSON encoded value
+func jsonField(value []byte, segments []pathSegment) (interface{}, bool) {
+	var doc interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, false
+	}
+	elem, err := lookupPath(doc, segments)
+	return elem, err == nil
+}
+
+// JSONFieldEquals matches JSON documents whose element at path equals the JSON encoded expected value.
+// Values that aren't JSON documents never match.
+func JSONFieldEquals(path string, expected []byte) (ValueFilter, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	var want interface{}
+	if err := json.Unmarshal(expected, &want); err != nil {
+		return nil, ErrNotJSON
+	}
+	return FilterFunc(func(value []byte) bool {
+		elem, ok := jsonField(value, segments)
+		return ok && reflect.DeepEqual(elem, want)
+	}), nil
+}
+
+// JSONFieldContains matches JSON documents whose element at path is a string containing substr,
+// or an array holding the string substr
+func JSONFieldContains(path string, substr string) (ValueFilter, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return FilterFunc(func(value []byte) bool {
+		elem, ok := jsonField(value, segments)
+		if !ok {
+			return false
+		}
+		switch e := elem.(type) {
+		case string:
+			return strings.Contains(e, substr)
+		case []interface{}:
+			for _, item := range e {
+				if s, ok := item.(string); ok && s == substr {
+					return true
+				}
+			}
+		}
+		return false
+	}), nil
+}
@@ -0,0 +1,211 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrReadOnly is returned by the writes of a database opened with the Follower option
+var ErrReadOnly = errors.New("Database is read-only")
+
+// DefaultFollowInterval is the refresh interval of a follower when none is given
+const DefaultFollowInterval = time.Second
+
+// Follower opens the database as a read-only follower of a writer owning the same files, for instance on
+// shared storage: every interval, the SSTable directory and the WAL are checked for changes and the view of the
+// follower is rebuilt from them. The follower serves reads but refuses every write with ErrReadOnly, and never
+// modifies the files. Its WAL must be opened with OpenWALReadOnly.
+// The view lags the writer by up to interval, and a refresh that catches the writer in the middle of writing
+// an SSTable keeps the previous view until the next one.
+func Follower(interval time.Duration) Option {
+	return func(db *DB) {
+		if interval <= 0 {
+			interval = DefaultFollowInterval
+		}
+		db.follower = &follower{interval: interval, tables: make(map[string]time.Time)}
+	}
+}
+
+// follower tracks the files of the writer a follower last loaded
+type follower struct {
+	interval time.Duration
+	walSize  int64
+	walTime  time.Time
+	tables   map[string]time.Time // Modification time of each SSTable loaded
+	lastErr  error                // Error of the last refresh, nil if it succeeded
+}
+
+// OpenWALReadOnly opens the WAL of a database owned by another process, for a follower.
+// Its metadata is read but never written, and writing entries fails.
+func OpenWALReadOnly(filePath string) (*WAL, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	wal := &WAL{file: file, fs: vfs.OS, readOnly: true}
+	if err := wal.readMetadata(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return wal, nil
+}
+
+// checkWritable returns ErrReadOnly if the database is a follower
+func (db *DB) checkWritable() error {
+	if db.follower != nil {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// IsFollower reports whether the database was opened with the Follower option
+func (db *DB) IsFollower() bool {
+	return db.follower != nil
+}
+
+// LastRefreshError returns the error of the last refresh of a follower, nil if it succeeded or for a writer
+func (db *DB) LastRefreshError() error {
+	if db.follower == nil {
+		return nil
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.follower.lastErr
+}
+
+// follow refreshes the view of a follower until the database is closed
+func (db *DB) follow() {
+	defer db.background.Done()
+
+	ticker := time.NewTicker(db.follower.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-ticker.C:
+			err := db.Refresh()
+			db.mu.Lock()
+			db.follower.lastErr = err
+			db.mu.Unlock()
+		}
+	}
+}
+
+// Refresh reloads the view of a follower from the files of the writer if they changed since the last refresh.
+// It is called every interval by the follower, and can be called to catch up right away.
+// The WAL is read before the SSTables are listed: a flush in between leaves the flushed records in both,
+// instead of in neither. New SSTables are opened, and their checksums verified, before the view is replaced,
+// without blocking reads.
+func (db *DB) Refresh() error {
+	if db.follower == nil {
+		return nil
+	}
+
+	walInfo, err := db.wal.file.Stat()
+	if err != nil {
+		return err
+	}
+	tables, modTimes, err := sstableModTimes(db.sstableDir)
+	if err != nil {
+		return err
+	}
+	db.mu.RLock()
+	unchanged := walInfo.Size() == db.follower.walSize && walInfo.ModTime().Equal(db.follower.walTime) && sameModTimes(modTimes, db.follower.tables)
+	db.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	// Records past the watermark are the memtable of the writer
+	memtable := &DB{data: make(map[string]sstable.Pair), keys: make([]string, 0)}
+	meta, err := ScanWALFile(db.wal.file.Name(), func(entry WALEntry) error {
+		if !entry.Flushed {
+			memtable.apply(entry.WALRecord)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Open the new and rewritten SSTables, so a table still being written fails the refresh
+	if tables, modTimes, err = sstableModTimes(db.sstableDir); err != nil {
+		return err
+	}
+	opened := make(map[string]*sstable.Reader)
+	db.mu.RLock()
+	for path, modTime := range modTimes {
+		if loaded, ok := db.follower.tables[path]; !ok || !loaded.Equal(modTime) {
+			reader, err := sstable.OpenReaderFS(db.fs, path)
+			if err != nil {
+				db.mu.RUnlock()
+				for _, reader := range opened {
+					reader.Close()
+				}
+				return err
+			}
+			opened[path] = reader
+		}
+	}
+	db.mu.RUnlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for path, loaded := range db.follower.tables {
+		if modTime, ok := modTimes[path]; !ok || !loaded.Equal(modTime) {
+			db.readers.evict(path)
+		}
+	}
+	db.readers.add(opened)
+	db.values.clear()
+	db.SSTableIDs = tables
+	db.sequence++
+	db.data = memtable.data
+	db.keys = memtable.keys
+	db.ranges = memtable.ranges
+	db.wal.MetaData = meta
+	db.follower.walSize = walInfo.Size()
+	db.follower.walTime = walInfo.ModTime()
+	db.follower.tables = modTimes
+	if err := db.buildIndexes(); err != nil {
+		return err
+	}
+	return db.buildSearch()
+}
+
+// sstableModTimes lists the SSTables of a directory, oldest first, with their modification times
+func sstableModTimes(sstableDir string) ([]string, map[string]time.Time, error) {
+	tables, err := listSSTables(sstableDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	modTimes := make(map[string]time.Time, len(tables))
+	for _, path := range tables {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, err // Removed by a compaction since the listing, the next refresh will see it
+		}
+		modTimes[path] = fileInfo.ModTime()
+	}
+	if tables == nil {
+		tables = make([]string, 0)
+	}
+	return tables, modTimes, nil
+}
+
+// sameModTimes reports whether two sets of SSTables are the same, with the same modification times
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, modTime := range a {
+		if other, ok := b[path]; !ok || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,263 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSTables are named by generation, a number increasing with every table written by a flush or an ingestion:
+// 000001.sst, 000002.sst and so on. The output of a compaction takes the generation of the newest table it
+// merged followed by a sequence number, e.g. 000002-1.sst, so it keeps the place of its inputs among the tables.
+// Tables named by the time they were written, e.g. sstable_file_YYMMDDHHMMSS.sst, are older than all of them.
+
+// generationFilename returns the name of the SSTable of generation gen in sstableDir, seq is 0 unless it was
+// written by a compaction
+func generationFilename(sstableDir string, gen uint64, seq uint64) string {
+	if seq == 0 {
+		return filepath.Join(sstableDir, fmt.Sprintf("%06d.sst", gen))
+	}
+	return filepath.Join(sstableDir, fmt.Sprintf("%06d-%d.sst", gen, seq))
+}
+
+// parseGeneration returns the generation and the sequence number of an SSTable, ok is false if it isn't named
+// by generation. Only the names written by generationFilename are accepted, so a generation has a single name.
+func parseGeneration(sstableID string) (gen uint64, seq uint64, ok bool) {
+	name, found := strings.CutSuffix(filepath.Base(sstableID), ".sst")
+	if !found {
+		return 0, 0, false
+	}
+	genPart, seqPart, compacted := strings.Cut(name, "-")
+	gen, err := strconv.ParseUint(genPart, 10, 64)
+	if err != nil || fmt.Sprintf("%06d", gen) != genPart {
+		return 0, 0, false
+	}
+	if compacted {
+		seq, err = strconv.ParseUint(seqPart, 10, 64)
+		if err != nil || seq == 0 || strconv.FormatUint(seq, 10) != seqPart {
+			return 0, 0, false
+		}
+	}
+	return gen, seq, true
+}
+
+// sortSSTables sorts tables oldest first, as by olderSSTable
+func sortSSTables(tables []string, modTimes map[string]time.Time) {
+	sort.SliceStable(tables, func(i, j int) bool {
+		return olderSSTable(tables[i], modTimes[tables[i]], tables[j], modTimes[tables[j]])
+	})
+}
+
+// olderSSTable reports whether table a is older than table b: the tables named by time sort by modification time,
+// then by name, before the others, which sort by generation
+func olderSSTable(a string, aTime time.Time, b string, bTime time.Time) bool {
+	genA, seqA, okA := parseGeneration(a)
+	genB, seqB, okB := parseGeneration(b)
+	switch {
+	case !okA && !okB && !aTime.Equal(bTime):
+		return aTime.Before(bTime)
+	case !okA && !okB:
+		return filepath.Base(a) < filepath.Base(b)
+	case okA != okB:
+		return !okA
+	case genA != genB:
+		return genA < genB
+	}
+	return seqA < seqB
+}
+
+// nextGeneration returns the first generation after last and the generations of tables with no file in sstableDir.
+// A file left with that name, e.g. by an interrupted flush, is skipped rather than written over.
+func nextGeneration(fsys vfs.FS, sstableDir string, tables []string, last uint64) (uint64, error) {
+	for _, table := range tables {
+		if gen, _, ok := parseGeneration(table); ok && gen > last {
+			last = gen
+		}
+	}
+	for gen := last + 1; ; gen++ {
+		_, err := fsys.Stat(generationFilename(sstableDir, gen, 0))
+		if os.IsNotExist(err) {
+			return gen, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// newSSTableFilename returns the name of a new SSTable, newer than every table of the database.
+// The caller must hold the write lock.
+func (db *DB) newSSTableFilename() (string, error) {
+	gen, err := nextGeneration(db.fs, db.sstableDir, db.SSTableIDs, db.generation)
+	if err != nil {
+		return "", err
+	}
+	db.generation = gen
+	return generationFilename(db.sstableDir, gen, 0), nil
+}
+
+// compactionFilename returns the name of a new output of a compaction whose newest input is newest: its generation,
+// 0 for a table named by time, with a sequence number neither in tables nor in sstableDir
+func compactionFilename(fsys vfs.FS, sstableDir string, tables []string, newest string) (string, error) {
+	gen, _, _ := parseGeneration(newest)
+	used := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		used[filepath.Base(table)] = true
+	}
+	for seq := uint64(1); ; seq++ {
+		name := generationFilename(sstableDir, gen, seq)
+		if used[filepath.Base(name)] {
+			continue
+		}
+		_, err := fsys.Stat(name)
+		if os.IsNotExist(err) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// writeSSTables writes key-value pairs sorted by key to SSTables of fsys of at most targetSize bytes, split as by
+// sstable.Split, each named by filename, their properties completed by props, see tableProperties. It returns the
+// names of the tables written, none if keyValues is empty. Each table is written to a temporary file renamed once
+// complete, so that a crash never leaves a part of a table under a name the database reads.
+func writeSSTables(fsys vfs.FS, keyValues []sstable.KeyValuePair, targetSize int64, filename func() (string, error), props *tableProps) ([]string, error) {
+	outputs := make([]string, 0)
+	if len(keyValues) == 0 {
+		return outputs, nil
+	}
+	for _, run := range sstable.Split(keyValues, targetSize) {
+		output, err := filename()
+		if err != nil {
+			return outputs, err
+		}
+		table := sstable.NewSSTable(run)
+		props.complete(output, table)
+		if err := writeRenamed(fsys, output, func(tmp string) error {
+			return sstable.WriteSSTableFS(fsys, tmp, table)
+		}); err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, output)
+	}
+	// The new tables are synced, their directory entries and renames too
+	return outputs, fsys.SyncDir(filepath.Dir(outputs[0]))
+}
+
+// writeRenamed writes the file output with write, to a temporary file renamed once complete
+func writeRenamed(fsys vfs.FS, output string, write func(tmp string) error) error {
+	tmp := output + ".tmp"
+	if err := fsys.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := write(tmp); err != nil {
+		return err
+	}
+	return fsys.Rename(tmp, output)
+}
+
+// writeSSTablesFrom writes the entries of the iterators returned by open to SSTables like writeSSTables, without
+// holding them in memory: a first pass over the entries builds the header, the properties and the checksum of each
+// table, then a second pass writes them, so open must return the same entries every time. If windowed is set in
+// time-series mode, the keys of each window go to tables of their own, written from a pass over the entries of the
+// window, oldest window first, like writeWindowTables. It returns the names of the tables written, and the number of
+// their entries.
+func (db *DB) writeSSTablesFrom(open func() (sstable.Iterator, error), windowed bool, filename func() (string, error), props *tableProps) ([]string, int, error) {
+	outputs := make([]string, 0)
+	window := func(key []byte) int64 { return noWindow }
+	if windowed && db.timeSeries != nil {
+		window = func(key []byte) int64 { return db.timeSeries.keyWindow(string(key)) }
+	}
+	it, err := open()
+	if err != nil {
+		return outputs, 0, err
+	}
+	runs, entries, err := buildTables(it, db.maxFileSize, window, props.builder)
+	if err != nil {
+		return outputs, 0, err
+	}
+	windows := make([]int64, 0, len(runs))
+	for w := range runs {
+		windows = append(windows, w)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+
+	for _, w := range windows {
+		it, err := open()
+		if err != nil {
+			return outputs, 0, err
+		}
+		if len(windows) > 1 {
+			w := w
+			it = newFilterIterator(it, func(kv sstable.KeyValuePair) (bool, error) { return window(kv.Key) == w, nil })
+		}
+		written, err := writeBuiltTables(db.fs, it, runs[w], filename, props.setSequences)
+		outputs = append(outputs, written...)
+		if err != nil {
+			return outputs, 0, err
+		}
+		if windowed && db.timeSeries != nil {
+			for _, output := range written {
+				db.timeSeries.windows[output] = w
+			}
+		}
+	}
+	if len(outputs) == 0 {
+		return outputs, 0, nil
+	}
+	// The new tables are synced, their directory entries and renames too
+	return outputs, entries, db.fs.SyncDir(filepath.Dir(outputs[0]))
+}
+
+// buildTables makes the first pass over the entries of a table output streamed from it: it adds each entry to the
+// builder of the table it goes to, the tables being cut as by sstable.Split between different keys, into separate
+// runs for the windows returned by window. It returns the tables of each window, and the number of entries.
+func buildTables(it sstable.Iterator, targetSize int64, window func(key []byte) int64, newBuilder func() *sstable.TableBuilder) (map[int64][]*sstable.TableBuilder, int, error) {
+	runs := make(map[int64][]*sstable.TableBuilder)
+	last := make(map[int64][]byte) // Last key of each window
+	entries := 0
+	for ; it.Valid(); it.Next() {
+		kv := sstable.KeyValuePair{Operation: it.Operation(), Key: it.Key(), Value: it.Value()}
+		w := window(kv.Key)
+		run := runs[w]
+		if len(run) == 0 || (targetSize > 0 && !bytes.Equal(kv.Key, last[w]) && run[len(run)-1].Size()+sstable.EntrySize(kv) > targetSize) {
+			run = append(run, newBuilder())
+			runs[w] = run
+		}
+		run[len(run)-1].Add(kv)
+		last[w] = append(last[w][:0], kv.Key...)
+		entries++
+	}
+	return runs, entries, it.Err()
+}
+
+// writeBuiltTables makes the second pass over the entries of a table output streamed from it: it writes the tables
+// of builders one after another, each named by filename and completed by named before it is written to a temporary
+// file renamed once complete. It returns the names of the tables written.
+func writeBuiltTables(fsys vfs.FS, it sstable.Iterator, builders []*sstable.TableBuilder, filename func() (string, error), named func(output string, props *sstable.Properties)) ([]string, error) {
+	outputs := make([]string, 0, len(builders))
+	for _, builder := range builders {
+		output, err := filename()
+		if err != nil {
+			return outputs, err
+		}
+		table := builder.Table()
+		named(output, table.Header.Properties)
+		if err := writeRenamed(fsys, output, func(tmp string) error {
+			return sstable.WriteSSTableFrom(fsys, tmp, table, it)
+		}); err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
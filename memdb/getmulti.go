@@ -0,0 +1,39 @@
+package memdb
+
+import "time"
+
+// GetMulti returns the values of keys as they all were at a single point in time, keyed like keys, leaving out the
+// keys that don't exist: no write lands between the reads of two keys, so values written together, e.g. the parts
+// of a composite object, are never returned half updated. The keys are read under one read lock, which holds up
+// the writes until the last one is read; reads spanning several calls take a Snapshot instead. It returns an error
+// if a key is invalid.
+func (db *DB) GetMulti(keys []string) (values map[string][]byte, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordGetMulti, Keys: keys}, time.Now(), &err)
+	}
+	validated := make([]string, len(keys))
+	for i, key := range keys {
+		if validated[i], err = db.ValidateKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	values = make(map[string][]byte, len(keys))
+	for i, key := range validated {
+		db.recordAccess(key, false)
+		value, err := db.get(key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err == nil {
+			value, err = db.resolveValue(key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[keys[i]] = value
+	}
+	return values, nil
+}
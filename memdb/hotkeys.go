@@ -0,0 +1,116 @@
+package memdb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// HotKey is a key together with its estimated number of accesses
+type HotKey struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// hotKeyTracker samples key accesses and keeps the most frequent keys using the Space-Saving algorithm:
+// a fixed number of counters is kept and, when a new key shows up while all of them are taken,
+// the smallest counter is reassigned to it. Frequent keys are guaranteed to stay in the table.
+type hotKeyTracker struct {
+	mu         sync.Mutex
+	k          int               // Number of keys reported
+	capacity   int               // Number of counters kept, larger than k to improve accuracy
+	sampleRate uint64            // One in every sampleRate accesses is recorded
+	accesses   uint64            // Total number of accesses, used for sampling
+	counts     map[string]uint64 // Sampled counts of the tracked keys
+}
+
+func newHotKeyTracker(k int, sampleRate int) *hotKeyTracker {
+	if sampleRate < 1 {
+		sampleRate = 1
+	}
+	return &hotKeyTracker{
+		k:          k,
+		capacity:   4 * k,
+		sampleRate: uint64(sampleRate),
+		counts:     make(map[string]uint64),
+	}
+}
+
+// HotKeys enables hot key tracking, reporting the k most accessed keys.
+// Only one in every sampleRate accesses is recorded to keep the overhead low.
+func HotKeys(k int, sampleRate int) Option {
+	return func(db *DB) {
+		if k > 0 {
+			db.hotKeys = newHotKeyTracker(k, sampleRate)
+		}
+	}
+}
+
+// record counts an access to key if it is sampled
+func (t *hotKeyTracker) record(key string) {
+	if t == nil {
+		return
+	}
+	if atomic.AddUint64(&t.accesses, 1)%t.sampleRate != 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.counts[key]; ok || len(t.counts) < t.capacity {
+		t.counts[key]++
+		return
+	}
+	// Replace the key with the smallest count, the new key inherits its count
+	var minKey string
+	var minCount uint64
+	first := true
+	for k, c := range t.counts {
+		if first || c < minCount {
+			minKey, minCount, first = k, c, false
+		}
+	}
+	delete(t.counts, minKey)
+	t.counts[key] = minCount + 1
+}
+
+// top returns the k most accessed keys, sorted by decreasing count
+func (t *hotKeyTracker) top() []HotKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hot := make([]HotKey, 0, len(t.counts))
+	for key, count := range t.counts {
+		hot = append(hot, HotKey{Key: key, Count: count * t.sampleRate})
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Count != hot[j].Count {
+			return hot[i].Count > hot[j].Count
+		}
+		return hot[i].Key < hot[j].Key
+	})
+	if len(hot) > t.k {
+		hot = hot[:t.k]
+	}
+	return hot
+}
+
+// HotKeys returns the most frequently accessed keys with their estimated access counts.
+// It returns nil if hot key tracking is not enabled.
+func (db *DB) HotKeys() []HotKey {
+	if db.hotKeys == nil {
+		return nil
+	}
+	return db.hotKeys.top()
+}
+
+// forget stops tracking key, so that no trace of a purged key is kept in memory
+func (t *hotKeyTracker) forget(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.counts, key)
+}
@@ -0,0 +1,168 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// ErrNoIndex is returned when querying a path that isn't indexed
+var ErrNoIndex = errors.New("No index on this path")
+
+// Indexes declares secondary indexes on the JSON elements at paths, e.g. "user.email" or "tags[0]", so that
+// QueryIndex finds the keys of the documents holding a value there without scanning the database.
+// Indexes are kept in memory: they are built from the data when the database is opened, then updated under
+// the write lock with every write, so a query never sees a write the index doesn't.
+func Indexes(paths ...string) Option {
+	return func(db *DB) {
+		db.indexPaths = append(db.indexPaths, paths...)
+	}
+}
+
+// index maps the JSON element at a path of the documents to their keys
+type index struct {
+	segments []pathSegment
+	values   map[string]string              // Indexed element of each key, JSON encoded
+	keys     map[string]map[string]struct{} // Keys of each indexed element
+}
+
+// indexSet holds the indexes of the database by path, nil if there are none.
+// It is only modified while holding the database write lock.
+type indexSet map[string]*index
+
+// newIndexSet returns empty indexes on paths, nil if there are none
+func newIndexSet(paths []string) (indexSet, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	indexes := make(indexSet, len(paths))
+	for _, path := range paths {
+		segments, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		indexes[path] = &index{segments: segments, values: make(map[string]string), keys: make(map[string]map[string]struct{})}
+	}
+	return indexes, nil
+}
+
+// indexValue returns the canonical JSON encoding of a value, so that 1 and 1.0 are indexed alike
+func indexValue(elem interface{}) (string, bool) {
+	encoded, err := json.Marshal(elem)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// put indexes the value of key in place of its previous one. Values that aren't JSON documents,
+// or don't hold the path of an index, leave key out of it.
+func (s indexSet) put(key string, value []byte) {
+	for _, idx := range s {
+		idx.remove(key)
+		elem, ok := jsonField(value, idx.segments)
+		if !ok {
+			continue
+		}
+		encoded, ok := indexValue(elem)
+		if !ok {
+			continue
+		}
+		idx.values[key] = encoded
+		if idx.keys[encoded] == nil {
+			idx.keys[encoded] = make(map[string]struct{})
+		}
+		idx.keys[encoded][key] = struct{}{}
+	}
+}
+
+// remove drops key from the indexes, once deleted
+func (s indexSet) remove(key string) {
+	for _, idx := range s {
+		idx.remove(key)
+	}
+}
+
+// removeRange drops the keys of a range deletion from the indexes
+func (s indexSet) removeRange(r sstable.RangeDeletion) {
+	for _, idx := range s {
+		for key := range idx.values {
+			if r.Covers([]byte(key)) {
+				idx.remove(key)
+			}
+		}
+	}
+}
+
+// clear empties the indexes, keeping their paths
+func (s indexSet) clear() {
+	for _, idx := range s {
+		idx.values = make(map[string]string)
+		idx.keys = make(map[string]map[string]struct{})
+	}
+}
+
+func (idx *index) remove(key string) {
+	encoded, ok := idx.values[key]
+	if !ok {
+		return
+	}
+	delete(idx.values, key)
+	delete(idx.keys[encoded], key)
+	if len(idx.keys[encoded]) == 0 {
+		delete(idx.keys, encoded)
+	}
+}
+
+// buildIndexes fills the indexes from the live keys of the database, the caller must hold the write lock
+func (db *DB) buildIndexes() error {
+	if db.indexes == nil {
+		return nil
+	}
+	kvs, err := db.scan(ScanOptions{manifests: true})
+	if err != nil {
+		return err
+	}
+	db.indexes.clear()
+	for _, kv := range kvs {
+		db.indexes.put(kv.Key, kv.Value)
+	}
+	return nil
+}
+
+// QueryIndex returns the sorted keys of the documents whose element at path equals the JSON encoded value,
+// through the index declared on path by Indexes. It returns ErrNoIndex if there is none.
+func (db *DB) QueryIndex(path string, value []byte) ([]string, error) {
+	var want interface{}
+	if err := json.Unmarshal(value, &want); err != nil {
+		return nil, ErrNotJSON
+	}
+	encoded, ok := indexValue(want)
+	if !ok {
+		return nil, ErrNotJSON
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	idx, ok := db.indexes[path]
+	if !ok {
+		return nil, ErrNoIndex
+	}
+	keys := make([]string, 0, len(idx.keys[encoded]))
+	for key := range idx.keys[encoded] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// IndexPaths returns the sorted paths of the indexes of the database
+func (db *DB) IndexPaths() []string {
+	paths := make([]string, 0, len(db.indexes))
+	for path := range db.indexes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
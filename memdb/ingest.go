@@ -0,0 +1,158 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"os"
+	"sort"
+	"time"
+)
+
+// Ingest bulk-loads key-value pairs by writing them straight to a new SSTable, bypassing the memtable and the WAL.
+// The memtable is flushed first, so the ingested values are newer than everything already in the database.
+// When a key appears several times in kvs, the last value wins.
+func (db *DB) Ingest(kvs []KeyValue) (err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordIngest, KVs: kvs}, time.Now(), &err)
+	}
+	if len(kvs) == 0 {
+		return nil
+	}
+	stored := make([]KeyValue, len(kvs))
+	for i, kv := range kvs {
+		key, err := db.ValidateKey(kv.Key)
+		if err != nil {
+			return err
+		}
+		if err := db.checkSize(key, kv.Value); err != nil {
+			return err
+		}
+		stored[i] = KeyValue{Key: key, Value: kv.Value}
+	}
+	kvs = stored
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+	if err := db.checkDisk(); err != nil {
+		return err
+	}
+	if len(db.data) > 0 || len(db.ranges) > 0 {
+		if err := db.flushLocked(); err != nil {
+			return err
+		}
+	}
+
+	keyValues := sortedKeyValuePairs(kvs)
+	event := Event{Type: EventIngest, Start: time.Now(), Entries: len(keyValues)}
+	defer func() { db.recordEvent(event, err) }()
+
+	if err := db.fs.MkdirAll(db.sstableDir, 0755); err != nil {
+		return err
+	}
+	sstableFilename, err := db.newSSTableFilename()
+	if err != nil {
+		return err
+	}
+	table := sstable.NewSSTable(keyValues)
+	db.tableProperties(nil, nil).complete(sstableFilename, table)
+	if err := sstable.WriteSSTableFS(db.fs, sstableFilename, table); err != nil {
+		return err
+	}
+	event.Outputs = []string{sstableFilename}
+	db.SSTableIDs = append(db.SSTableIDs, sstableFilename)
+	db.sequence++
+	db.readers.open(event.Outputs)
+	if err := db.logTables(); err != nil {
+		return err
+	}
+	for _, kv := range keyValues {
+		db.values.invalidate(string(kv.Key))
+		db.indexes.put(string(kv.Key), kv.Value)
+		db.search.put(string(kv.Key), kv.Value)
+	}
+	db.streams = nil // The ingested keys may extend streams
+	db.lists = nil
+	keys := make([]string, len(keyValues))
+	for i, kv := range keyValues {
+		keys[i] = string(kv.Key)
+	}
+	if err := db.search.writeSegment(db.fs, keys, nil, db.generation); err != nil {
+		return err
+	}
+	if err := db.syncRemote(); err != nil {
+		return err
+	}
+
+	// Usage of the quota changed in ways that are simpler to recompute
+	if db.quota != nil {
+		used, err := db.computeUsage()
+		if err != nil {
+			return err
+		}
+		db.quota.used = used
+	}
+	return nil
+}
+
+// IngestOffline bulk-loads key-value pairs into the SSTable directory of a closed database, bypassing the memtable
+// and the WAL, as SSTables of at most tableEntries entries each (a single one if 0) whose key ranges don't overlap.
+// The new SSTables take the next generations, so the ingested values win over the values already stored.
+// When a key appears several times in kvs, the last value wins. It returns the paths of the new SSTables.
+func IngestOffline(sstableDir string, kvs []KeyValue, tableEntries int) ([]string, error) {
+	outputs := make([]string, 0)
+	if len(kvs) == 0 {
+		return outputs, nil
+	}
+	if err := os.MkdirAll(sstableDir, 0755); err != nil {
+		return outputs, err
+	}
+	existing, err := listSSTables(sstableDir)
+	if err != nil {
+		return outputs, err
+	}
+
+	keyValues := sortedKeyValuePairs(kvs)
+	if tableEntries <= 0 {
+		tableEntries = len(keyValues)
+	}
+	var gen uint64
+	for start := 0; start < len(keyValues); start += tableEntries {
+		if gen, err = nextGeneration(vfs.OS, sstableDir, existing, gen); err != nil {
+			return outputs, err
+		}
+		sstableFilename := generationFilename(sstableDir, gen, 0)
+		tmp := sstableFilename + ".tmp"
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return outputs, err
+		}
+		if err := sstable.WriteSSTable(tmp, sstable.NewSSTable(keyValues[start:min(start+tableEntries, len(keyValues))])); err != nil {
+			return outputs, err
+		}
+		if err := vfs.ReplaceFile(vfs.OS, tmp, sstableFilename); err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, sstableFilename)
+	}
+	return outputs, logOffline(sstableDir, outputs, nil)
+}
+
+// sortedKeyValuePairs returns the SSTable entries setting kvs, sorted by key, keeping the last occurrence of each key
+func sortedKeyValuePairs(kvs []KeyValue) []sstable.KeyValuePair {
+	sorted := make([]KeyValue, len(kvs))
+	copy(sorted, kvs)
+	if !sort.SliceIsSorted(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key }) {
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	}
+	keyValues := make([]sstable.KeyValuePair, 0, len(sorted))
+	for i, kv := range sorted {
+		if i+1 < len(sorted) && sorted[i+1].Key == kv.Key {
+			continue
+		}
+		keyValues = append(keyValues, sstable.KeyValuePair{Operation: sstable.OpSet, Key: []byte(kv.Key), Value: kv.Value})
+	}
+	return keyValues
+}
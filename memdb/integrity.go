@@ -0,0 +1,152 @@
+package memdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VerifyIntegrity checks the consistency of the open database without modifying it, and reports its findings like
+// Doctor:
+//   - the live SSTables match the SSTable directory: each of them has a file, or a copy in the object store, and the
+//     directory holds no table the database doesn't read, nor leftovers of interrupted rewrites;
+//   - the tables all belong to level 0 and are ordered from the oldest to the newest, none of them past the last
+//     generation named, which a flush would write over;
+//   - every table is readable, its checksum matches, its format is supported and its keys are in order;
+//   - the WAL metadata on disk matches the WAL in memory, its watermark is on a record boundary no further than
+//     its offset, and every record decodes.
+//
+// The tables are read without holding the database lock, like ScrubNow, and the ones a compaction removed in the
+// meantime are skipped. The error is only set when the checks couldn't run, problems found are findings.
+func (db *DB) VerifyIntegrity() (DoctorReport, error) {
+	report := DoctorReport{Time: time.Now(), Findings: make([]Finding, 0)}
+	db.mu.RLock()
+	sstableIDs := append([]string(nil), db.SSTableIDs...)
+	err := db.verifyTableList(&report)
+	if err == nil {
+		err = db.verifyWAL(&report)
+	}
+	db.mu.RUnlock()
+	if err != nil {
+		return report, err
+	}
+
+	for _, sstableID := range sstableIDs {
+		sst, err := db.readSSTable(sstableID)
+		if os.IsNotExist(err) {
+			continue // Removed by a compaction in the meantime, or reported missing
+		}
+		report.Tables++
+		if err != nil {
+			report.add(SeverityError, sstableID, "Run ScrubNow to quarantine it, or cmd/repair with the server stopped",
+				"Unreadable SSTable: %s", err)
+			continue
+		}
+		size := int64(-1) // Unknown for the tables only in the object store
+		if fileInfo, err := db.fs.Stat(sstableID); err == nil {
+			size = fileInfo.Size()
+		}
+		checkSSTable(&report, sstableID, size, sst)
+	}
+	return report, nil
+}
+
+// verifyTableList checks the list of live SSTables against the SSTable directory and its MANIFEST, the caller must
+// hold the lock
+func (db *DB) verifyTableList(report *DoctorReport) error {
+	// The tables named by time are ordered as the MANIFEST added them, not by modification time
+	var added map[string]time.Time
+	if db.manifest != nil {
+		added = db.manifest.addedTimes(db.sstableDir)
+	}
+	live := make(map[string]bool, len(db.SSTableIDs))
+	var previous string
+	var previousTime time.Time
+	for i, sstableID := range db.SSTableIDs {
+		live[sstableID] = true
+		var modTime time.Time
+		fileInfo, err := db.fs.Stat(sstableID)
+		switch {
+		case err == nil:
+			modTime = fileInfo.ModTime()
+		case !os.IsNotExist(err):
+			return err
+		case db.remote == nil:
+			report.add(SeverityError, sstableID, "Restore the database from a backup",
+				"Live SSTable missing from the SSTable directory, its keys can't be read")
+		default:
+			if _, err := db.remote.store.Size(objectName(sstableID)); err != nil {
+				report.add(SeverityError, sstableID, "Restore the database from a backup",
+					"Live SSTable missing from the SSTable directory and the object store: %s", err)
+			}
+		}
+
+		if added != nil {
+			if _, ok := added[sstableID]; !ok {
+				report.add(SeverityError, sstableID, "Flush the memtable, which lists the live tables in the MANIFEST again",
+					"Live SSTable missing from the MANIFEST, the database won't read it if it is opened again")
+			}
+			modTime = added[sstableID]
+		}
+
+		if gen, _, ok := parseGeneration(sstableID); ok && gen > db.generation {
+			report.add(SeverityError, sstableID, "Restart the database, which names new tables after the newest one",
+				"Generation %d is past the last generation named, %d, the next flush may be read as older", gen, db.generation)
+		}
+		if i > 0 && !olderSSTable(previous, previousTime, sstableID, modTime) {
+			report.add(SeverityError, sstableID, "Restart the database, which sorts the tables again",
+				"Listed after %s, which is newer: lookups may return overwritten values", filepath.Base(previous))
+		}
+		previous, previousTime = sstableID, modTime
+	}
+	for sstableID := range added {
+		if !live[sstableID] {
+			report.add(SeverityError, sstableID, "Flush the memtable, which lists the live tables in the MANIFEST again",
+				"SSTable listed in the MANIFEST that the database no longer reads, it is read again if the database is opened again")
+		}
+	}
+
+	files, err := db.fs.ReadDir(db.sstableDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path := filepath.Join(db.sstableDir, file.Name())
+		switch {
+		case file.IsDir():
+		case strings.HasSuffix(file.Name(), ".tmp"):
+			report.add(SeverityWarning, path, "Delete it, or run cmd/repair with the server stopped", "Leftover of an interrupted rewrite")
+		case isDBFile(file.Name()) && !live[path] && added != nil:
+			if _, ok := added[path]; !ok {
+				report.add(SeverityWarning, path, "Delete it, or run cmd/repair with the server stopped",
+					"SSTable the MANIFEST doesn't list, left by an interrupted flush or compaction")
+			}
+		case isDBFile(file.Name()) && !live[path]:
+			report.add(SeverityWarning, path, "Move it out of the directory: the database reads it if it is opened again",
+				"SSTable in the directory that the database doesn't read")
+		}
+	}
+	return nil
+}
+
+// verifyWAL checks the WAL file and its metadata against the WAL in memory, the caller must hold the lock
+func (db *DB) verifyWAL(report *DoctorReport) error {
+	walPath := db.wal.file.Name()
+	meta, err := doctorWAL(report, db.fs, walPath)
+	if err != nil || meta.Offset == 0 || db.follower != nil {
+		return err // The WAL of a follower is written by another process
+	}
+	db.wal.mu.Lock()
+	inMemory := db.wal.MetaData
+	db.wal.mu.Unlock()
+	if meta != inMemory {
+		report.add(SeverityError, walPath, "Close the database cleanly, which writes the metadata again",
+			"The metadata on disk (offset %d, watermark %d) differs from the WAL in memory (offset %d, watermark %d)",
+			meta.Offset, meta.Watermark, inMemory.Offset, inMemory.Watermark)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"sort"
+	"strings"
+)
+
+// IteratorOptions selects the keys walked by an Iterator
+type IteratorOptions struct {
+	Prefix string // Only keys starting with Prefix
+	Start  string // Only keys >= Start
+	End    string // Only keys < End, no upper bound if empty
+	After  string // Only keys > After, to resume after the last key of a page
+
+	manifests bool // Whether the manifests of the chunked values are returned instead of the values, for listings
+	internal  bool // Whether the internal keys are walked too, e.g. the elements of a collection
+}
+
+// Iterator walks the live keys of the database in ascending order with their values, merging the memtable and the
+// SSTables as it goes instead of loading them: an SSTable takes the memory of its keys only, which its open reader
+// already holds, and each value is read from the file when the iterator gets to it. The iterator holds the read
+// lock of the database from NewIterator to Close, so it sees the keys as they were when it was created and writes
+// wait until it is closed. It must not call other methods of the database meanwhile.
+type Iterator struct {
+	db       *DB
+	snapshot *Snapshot // Snapshot the iterator reads, nil if it reads the database under its read lock
+	merged   *sstable.MergingIterator
+	opts     IteratorOptions
+	sequence uint64 // Sequence of the database the iterator sees
+	locked   bool   // Whether the iterator holds the read lock, released by Close
+	key      string
+	value    []byte
+	err      error
+	closed   bool
+}
+
+// memtableIterator walks the entries of a memtable, deletion markers included. The caller must hold the lock of the
+// database, unless the memtable is the copy of a snapshot.
+type memtableIterator struct {
+	keys   []string
+	data   map[string]sstable.Pair
+	ranges sstable.RangeDeletions
+	pos    int
+}
+
+func (it *memtableIterator) Valid() bool { return it.pos < len(it.keys) }
+func (it *memtableIterator) Next()       { it.pos++ }
+func (it *memtableIterator) Seek(key []byte) {
+	it.pos = sort.SearchStrings(it.keys, string(key))
+}
+func (it *memtableIterator) Key() []byte   { return []byte(it.keys[it.pos]) }
+func (it *memtableIterator) Value() []byte { return it.data[it.keys[it.pos]].Value }
+func (it *memtableIterator) Operation() sstable.Operation {
+	if it.data[it.keys[it.pos]].Marker {
+		return sstable.OpDel
+	}
+	return sstable.OpSet
+}
+func (it *memtableIterator) Err() error { return nil }
+func (it *memtableIterator) RangeDeletions() sstable.RangeDeletions {
+	return it.ranges
+}
+
+// filterIterator walks the entries of an iterator that keep returns true for, e.g. leaving out the tombstones a
+// compaction drops
+type filterIterator struct {
+	sstable.Iterator
+	keep func(kv sstable.KeyValuePair) (bool, error)
+	err  error
+}
+
+// newFilterIterator returns an iterator over the entries of it kept by keep, positioned at the first of them
+func newFilterIterator(it sstable.Iterator, keep func(kv sstable.KeyValuePair) (bool, error)) sstable.Iterator {
+	f := &filterIterator{Iterator: it, keep: keep}
+	f.skip()
+	return f
+}
+
+// skip moves to the first entry kept from the current one
+func (f *filterIterator) skip() {
+	for f.err == nil && f.Iterator.Valid() {
+		kept, err := f.keep(sstable.KeyValuePair{Operation: f.Operation(), Key: f.Key(), Value: f.Value()})
+		if err != nil || kept {
+			f.err = err
+			return
+		}
+		f.Iterator.Next()
+	}
+}
+
+func (f *filterIterator) Valid() bool { return f.err == nil && f.Iterator.Valid() }
+func (f *filterIterator) Next()       { f.Iterator.Next(); f.skip() }
+func (f *filterIterator) Seek(key []byte) {
+	f.Iterator.Seek(key)
+	f.skip()
+}
+func (f *filterIterator) Err() error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.Iterator.Err()
+}
+
+// NewIterator returns an iterator over the live keys selected by opts, positioned at the first of them. The
+// iterator must be closed. Chunked values written by SetReader are returned whole.
+func (db *DB) NewIterator(opts IteratorOptions) (*Iterator, error) {
+	db.mu.RLock()
+	it, err := db.newIterator(opts)
+	if err != nil {
+		db.mu.RUnlock()
+		return nil, err
+	}
+	it.locked = true
+	return it, nil
+}
+
+// newIterator returns an iterator like NewIterator for a caller holding the lock, which must hold it until the
+// iterator is closed
+func (db *DB) newIterator(opts IteratorOptions) (*Iterator, error) {
+	// The SSTables from the oldest to the newest, then the memtable
+	inputs, err := db.tableIterators(db.SSTableIDs)
+	if err != nil {
+		return nil, err
+	}
+	inputs = append(inputs, &memtableIterator{keys: db.keys, data: db.data, ranges: db.ranges})
+
+	// Every older version of the keys is merged, so deleted keys can be left out
+	it := &Iterator{db: db, merged: sstable.NewMergingIterator(inputs, true), opts: opts, sequence: db.sequence}
+	it.start()
+	return it, nil
+}
+
+// start positions the iterator at the first key selected. The internal keys all sort before the first byte a key
+// can start with, they are skipped without reading their values unless they are asked for.
+func (it *Iterator) start() {
+	first := max(it.opts.Start, it.opts.Prefix, it.opts.After)
+	if !it.opts.internal {
+		first = max(first, "\x01")
+	}
+	it.merged.Seek([]byte(first))
+	it.settle()
+}
+
+// settle moves the iterator to the current entry of the merge if it is selected, or ends it
+func (it *Iterator) settle() {
+	it.key, it.value = "", nil
+	for it.merged.Valid() {
+		key := string(it.merged.Key())
+		if !strings.HasPrefix(key, it.opts.Prefix) || (it.opts.End != "" && key >= it.opts.End) {
+			break // Past the keys selected, which are contiguous
+		}
+		if (internalKey(key) && !it.opts.internal) || (it.opts.After != "" && key <= it.opts.After) {
+			it.merged.Next()
+			continue
+		}
+		value := it.merged.Value()
+		if !it.opts.manifests {
+			var err error
+			if it.snapshot != nil {
+				value, err = it.snapshot.resolveValue(key, value)
+			} else {
+				value, err = it.db.resolveValue(key, value)
+			}
+			if err != nil {
+				it.err = err
+				return
+			}
+		}
+		it.key, it.value = key, value
+		return
+	}
+	if err := it.merged.Err(); err != nil {
+		it.err = err
+	}
+	it.merged = nil
+}
+
+// Valid reports whether the iterator is at a key, false once past the last one or if it failed, see Err
+func (it *Iterator) Valid() bool {
+	return it.merged != nil && it.err == nil && !it.closed
+}
+
+// Next moves to the next key
+func (it *Iterator) Next() {
+	if it.Valid() {
+		it.merged.Next()
+		it.settle()
+	}
+}
+
+// Key returns the current key
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+// Value returns the value of the current key, valid until the next call to Next
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Sequence returns the sequence of the database the iterator sees, see DB.Sequence
+func (it *Iterator) Sequence() uint64 {
+	return it.sequence
+}
+
+// Cursor returns the cursor resuming after the current key
+func (it *Iterator) Cursor() Cursor {
+	return Cursor{After: it.key, Sequence: it.sequence}
+}
+
+// Err returns the error that stopped the iteration, nil if it ended at the last key
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the read lock of the database. It may be called more than once.
+func (it *Iterator) Close() error {
+	if !it.closed {
+		it.closed = true
+		if it.locked {
+			it.db.mu.RUnlock()
+		}
+	}
+	return nil
+}
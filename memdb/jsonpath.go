@@ -0,0 +1,216 @@
+package memdb
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPath  = errors.New("Invalid JSON path")
+	ErrPathNotFound = errors.New("JSON path not found")
+	ErrNotJSON      = errors.New("Value is not a JSON document")
+)
+
+// pathSegment is an element of a JSON path: an object field, or an array index if isIndex is set
+type pathSegment struct {
+	field   string
+	index   int
+	isIndex bool
+}
+
+// parsePath parses a path such as "a.b[2].c" into its segments.
+// The empty path designates the whole document.
+func parsePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	afterDot := false
+	for path != "" {
+		switch {
+		case path[0] == '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 || afterDot {
+				return nil, ErrInvalidPath
+			}
+			index, err := strconv.Atoi(path[1:end])
+			if err != nil || index < 0 {
+				return nil, ErrInvalidPath
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			path = path[end+1:]
+		case path[0] == '.':
+			// A dot separates two segments
+			if len(segments) == 0 || afterDot {
+				return nil, ErrInvalidPath
+			}
+			afterDot = true
+			path = path[1:]
+			continue
+		default:
+			// A field starts the path or follows a dot
+			if len(segments) > 0 && !afterDot {
+				return nil, ErrInvalidPath
+			}
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			segments = append(segments, pathSegment{field: path[:end]})
+			path = path[end:]
+		}
+		afterDot = false
+	}
+	if afterDot {
+		return nil, ErrInvalidPath
+	}
+	return segments, nil
+}
+
+// lookupPath returns the element of doc designated by segments
+func lookupPath(doc interface{}, segments []pathSegment) (interface{}, error) {
+	for _, seg := range segments {
+		if seg.isIndex {
+			arr, ok := doc.([]interface{})
+			if !ok || seg.index >= len(arr) {
+				return nil, ErrPathNotFound
+			}
+			doc = arr[seg.index]
+		} else {
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			if doc, ok = obj[seg.field]; !ok {
+				return nil, ErrPathNotFound
+			}
+		}
+	}
+	return doc, nil
+}
+
+// setPath returns doc with the element designated by segments replaced by value.
+// Missing object fields are created, and an array index equal to the array length appends to it.
+func setPath(doc interface{}, segments []pathSegment, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	seg := segments[0]
+
+	if seg.isIndex {
+		if doc == nil {
+			doc = []interface{}{}
+		}
+		arr, ok := doc.([]interface{})
+		if !ok || seg.index > len(arr) {
+			return nil, ErrPathNotFound
+		}
+		var child interface{}
+		if seg.index < len(arr) {
+			child = arr[seg.index]
+		}
+		child, err := setPath(child, segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		if seg.index == len(arr) {
+			return append(arr, child), nil
+		}
+		arr[seg.index] = child
+		return arr, nil
+	}
+
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, ErrPathNotFound
+	}
+	child, err := setPath(obj[seg.field], segments[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	obj[seg.field] = child
+	return obj, nil
+}
+
+// GetPath returns the JSON encoding of the element at path in the JSON document stored under key,
+// e.g. GetPath("user", "address.lines[0]")
+func (db *DB) GetPath(key string, path string) ([]byte, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	value, err := db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, ErrNotJSON
+	}
+	elem, err := lookupPath(doc, segments)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(elem)
+}
+
+// SetPath replaces the element at path in the JSON document stored under key with the JSON encoded value.
+// Missing objects along the path are created, as well as the document itself if the key doesn't exist.
+// The read-modify-write runs under the write lock, so concurrent updates of the same document aren't lost.
+func (db *DB) SetPath(key string, path string, value []byte) (err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordSetPath, Key: key, Path: path, Value: value}, time.Now(), &err)
+	}
+	key, err = db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := db.checkSize(key, value); err != nil {
+		return err
+	}
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	var elem interface{}
+	if err := json.Unmarshal(value, &elem); err != nil {
+		return ErrNotJSON
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.recordAccess(key, true)
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+
+	var doc interface{}
+	current, err := db.get(key)
+	if err == nil {
+		current, err = db.resolveValue(key, current)
+	}
+	if err == nil {
+		if err := json.Unmarshal(current, &doc); err != nil {
+			return ErrNotJSON
+		}
+	} else if err != ErrKeyNotFound {
+		return err
+	}
+
+	if doc, err = setPath(doc, segments, elem); err != nil {
+		return err
+	}
+	updated, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// The element fits, the whole document may not
+	if err := db.checkSize(key, updated); err != nil {
+		return err
+	}
+	return db.set(key, updated)
+}
@@ -0,0 +1,99 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ReservedKeyPrefix starts the keys the engine writes for itself, e.g. indexes and metadata, under a namespace
+// of their own. Writes and reads through the public API refuse, or escape, the keys starting with it,
+// so that user data never collides with them.
+const ReservedKeyPrefix = "\x00"
+
+// KeyMode sets the keys accepted by the database, beyond being non-empty and outside the reserved namespaces
+type KeyMode int
+
+const (
+	KeysBinary  KeyMode = iota // Any bytes, the default
+	KeysUTF8                   // Valid UTF-8 only
+	KeysEscaped                // Any bytes, invalid UTF-8 and the reserved prefix being escaped as \xNN and \ as \\
+)
+
+// ParseKeyMode returns the mode named "binary", "utf8" or "escaped", KeysBinary if name is empty
+func ParseKeyMode(name string) (KeyMode, error) {
+	switch name {
+	case "", "binary":
+		return KeysBinary, nil
+	case "utf8":
+		return KeysUTF8, nil
+	case "escaped":
+		return KeysEscaped, nil
+	}
+	return 0, fmt.Errorf("Unknown key mode %q", name)
+}
+
+// Keys sets the keys accepted by Set, Get, Delete and the other operations taking a key, KeysBinary by default.
+// With KeysEscaped, keys are stored escaped and listings and scans return them in that form; switching
+// an existing database to it changes the keys holding a backslash.
+func Keys(mode KeyMode) Option {
+	return func(db *DB) {
+		db.keyMode = mode
+	}
+}
+
+// InvalidKeyError is returned when a key breaks the rules of the KeyMode of the database
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return "Invalid key: " + e.Reason
+}
+
+// internalKey reports whether key was written by the engine for itself, e.g. a chunk of a value, which listings
+// and scans leave out
+func internalKey(key string) bool {
+	return strings.HasPrefix(key, ReservedKeyPrefix)
+}
+
+// ValidateKey returns key as stored by the database, escaped with KeysEscaped, or an *InvalidKeyError if it is
+// empty, starts with ReservedKeyPrefix, or isn't valid UTF-8 with KeysUTF8
+func (db *DB) ValidateKey(key string) (string, error) {
+	switch {
+	case key == "":
+		return "", &InvalidKeyError{Key: key, Reason: "empty"}
+	case db.keyMode == KeysEscaped:
+		return escapeKey(key), nil
+	case strings.HasPrefix(key, ReservedKeyPrefix):
+		return "", &InvalidKeyError{Key: key, Reason: "reserved prefix"}
+	case db.keyMode == KeysUTF8 && !utf8.ValidString(key):
+		return "", &InvalidKeyError{Key: key, Reason: "not valid UTF-8"}
+	}
+	return key, nil
+}
+
+// escapeKey escapes the bytes of key that aren't valid UTF-8 and a leading reserved prefix as \xNN, and \ as \\,
+// so that distinct keys stay distinct
+func escapeKey(key string) string {
+	reserved := strings.HasPrefix(key, ReservedKeyPrefix)
+	if utf8.ValidString(key) && !reserved && !strings.Contains(key, `\`) {
+		return key
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); {
+		r, size := utf8.DecodeRuneInString(key[i:])
+		switch {
+		case r == utf8.RuneError && size == 1, reserved && i < len(ReservedKeyPrefix):
+			fmt.Fprintf(&b, `\x%02x`, key[i])
+			size = 1
+		case r == '\\':
+			b.WriteString(`\\`)
+		default:
+			b.WriteString(key[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
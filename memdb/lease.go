@@ -0,0 +1,200 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// A lease is a lock with an expiry, stored under a reserved key: the name is held until the holder releases it or
+// lets it expire, and can then be acquired by anyone. Each acquisition is given a fencing token, the sequence of the
+// database after the write or the token of the previous holder plus one, whichever is larger: a resource guarded by
+// the lease rejects the requests carrying a smaller token than the largest it has seen, so that a holder paused past
+// its expiry can't overwrite the work of the next one. The sequence starts from the clock when the database opens,
+// which may be behind the one of the node that gave the previous token, e.g. after a failover, so the tokens only
+// grow because the last one is kept: a released lease stays stored, expired, with its token. Leases are written to
+// the WAL like any key, and survive restarts and reach replicas. The value is an expiring value, see EncodeExpiring,
+// checked against the clock of the database.
+
+// lockPrefix starts the keys of the leases, in the reserved namespace
+const lockPrefix = ReservedKeyPrefix + "lock/"
+
+var (
+	// ErrLockHeld is returned by AcquireLock when the lease is held by someone else and hasn't expired
+	ErrLockHeld = errors.New("Lock is held")
+	// ErrLockNotHeld is returned when refreshing or releasing a lease that expired or was acquired again since
+	ErrLockNotHeld = errors.New("Lock is not held with this token")
+	// ErrInvalidTTL is returned when the time to live of a lease isn't positive
+	ErrInvalidTTL = errors.New("Lock TTL must be positive")
+)
+
+// Lock is a lease acquired by AcquireLock
+type Lock struct {
+	Name    string    `json:"name"`
+	Token   uint64    `json:"token"`   // Fencing token, larger than the tokens of the previous holders
+	Expires time.Time `json:"expires"` // The lease is lost once this time is past, unless refreshed before
+
+	db *DB
+}
+
+// lease is the value stored under the key of a lease
+type lease struct {
+	token   uint64
+	expires time.Time
+}
+
+// encode returns the lease as stored, an expiring value holding its token
+func (l lease) encode() []byte {
+	return EncodeExpiring(l.expires, binary.BigEndian.AppendUint64(nil, l.token))
+}
+
+// lockKey returns the key of the lease of name
+func (db *DB) lockKey(name string) (string, error) {
+	name, err := db.ValidateKey(name)
+	if err != nil {
+		return "", err
+	}
+	return lockPrefix + name, nil
+}
+
+// currentLease returns the lease stored under key, ok is false if there is none or it expired, l then holding the
+// token of the expired or released lease. The caller must hold the lock.
+func (db *DB) currentLease(key string) (l lease, ok bool, err error) {
+	value, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return l, false, nil
+	}
+	if err != nil {
+		return l, false, err
+	}
+	expires, token, ok := DecodeExpiring(value)
+	if !ok || len(token) != 8 {
+		return l, false, nil
+	}
+	l = lease{token: binary.BigEndian.Uint64(token), expires: expires}
+	return l, !Expired(l.expires, time.Now()), nil
+}
+
+// AcquireLock acquires the lease of name for ttl, or returns ErrLockHeld if it is held and hasn't expired. Names are
+// validated like keys, and don't collide with them.
+func (db *DB) AcquireLock(name string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	key, err := db.lockKey(name)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := db.acquireLock(key, ttl)
+	if err != nil {
+		return nil, err
+	}
+	lock.Name = name
+	return lock, db.syncWAL(WriteOptions{})
+}
+
+func (db *DB) acquireLock(key string, ttl time.Duration) (*Lock, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return nil, err
+	}
+	if err := db.checkSize(key, nil); err != nil {
+		return nil, err
+	}
+	previous, held, err := db.currentLease(key)
+	if err != nil || held {
+		if err == nil {
+			err = ErrLockHeld
+		}
+		return nil, err
+	}
+
+	// The write increments the sequence, which becomes the token: no earlier write on this node had it, and the
+	// previous token, kept by expired and released leases, is smaller
+	l := lease{token: max(db.sequence, previous.token) + 1, expires: time.Now().Add(ttl)}
+	if err := db.set(key, l.encode()); err != nil {
+		return nil, err
+	}
+	return &Lock{Token: l.token, Expires: l.expires, db: db}, nil
+}
+
+// RefreshLock extends the lease of name held with token to ttl from now, keeping its token. It returns
+// ErrLockNotHeld if the lease expired or was acquired again since.
+func (db *DB) RefreshLock(name string, token uint64, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	key, err := db.lockKey(name)
+	if err != nil {
+		return nil, err
+	}
+	expires, err := db.refreshLock(key, token, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{Name: name, Token: token, Expires: expires, db: db}, db.syncWAL(WriteOptions{})
+}
+
+func (db *DB) refreshLock(key string, token uint64, ttl time.Duration) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return time.Time{}, err
+	}
+	l, held, err := db.currentLease(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !held || l.token != token {
+		return time.Time{}, ErrLockNotHeld
+	}
+	l.expires = time.Now().Add(ttl)
+	return l.expires, db.set(key, l.encode())
+}
+
+// ReleaseLock releases the lease of name held with token, so that it can be acquired at once. It returns
+// ErrLockNotHeld if the lease expired or was acquired again since.
+func (db *DB) ReleaseLock(name string, token uint64) error {
+	key, err := db.lockKey(name)
+	if err != nil {
+		return err
+	}
+	if err := db.releaseLock(key, token); err != nil {
+		return err
+	}
+	return db.syncWAL(WriteOptions{})
+}
+
+func (db *DB) releaseLock(key string, token uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+	l, held, err := db.currentLease(key)
+	if err != nil {
+		return err
+	}
+	if !held || l.token != token {
+		return ErrLockNotHeld
+	}
+	// The lease is kept expired rather than deleted, so that the next token is larger than this one
+	l.expires = time.Unix(0, 0)
+	return db.set(key, l.encode())
+}
+
+// Refresh extends the lease to ttl from now, see RefreshLock
+func (l *Lock) Refresh(ttl time.Duration) error {
+	refreshed, err := l.db.RefreshLock(l.Name, l.Token, ttl)
+	if err != nil {
+		return err
+	}
+	l.Expires = refreshed.Expires
+	return nil
+}
+
+// Release releases the lease, see ReleaseLock
+func (l *Lock) Release() error {
+	return l.db.ReleaseLock(l.Name, l.Token)
+}
@@ -0,0 +1,44 @@
+package memdb
+
+import (
+	"errors"
+)
+
+// Defaults of the SizeLimits option
+const (
+	DefaultMaxKeySize   = 64 << 10
+	DefaultMaxValueSize = 16 << 20
+)
+
+// ErrKeyTooLarge is returned when a write's key is longer than the limit set by SizeLimits
+var ErrKeyTooLarge = errors.New("Key too large")
+
+// ErrValueTooLarge is returned when a write's value is longer than the limit set by SizeLimits
+var ErrValueTooLarge = errors.New("Value too large")
+
+// SizeLimits refuses the writes of keys longer than maxKey bytes or values longer than maxValue bytes,
+// DefaultMaxKeySize and DefaultMaxValueSize if 0. Every record is held whole in the memtable, replayed whole
+// from the WAL and read whole from the SSTables, so a single huge value would take that much memory each time.
+// Data written before the limits were lowered stays readable.
+func SizeLimits(maxKey int, maxValue int) Option {
+	return func(db *DB) {
+		db.maxKey, db.maxValue = maxKey, maxValue
+	}
+}
+
+// Limits returns the largest key and value accepted by writes, in bytes
+func (db *DB) Limits() (maxKey int, maxValue int) {
+	return db.maxKey, db.maxValue
+}
+
+// checkSize returns ErrKeyTooLarge or ErrValueTooLarge if a key or its value is over the limits, and
+// ErrReservedValue if the value starts like the manifest of a chunked value
+func (db *DB) checkSize(key string, value []byte) error {
+	if len(key) > db.maxKey {
+		return ErrKeyTooLarge
+	}
+	if len(value) > db.maxValue {
+		return ErrValueTooLarge
+	}
+	return checkValue(value)
+}
@@ -0,0 +1,32 @@
+package memdb
+
+import (
+	"StorageEngine/vfs"
+	"errors"
+	"io"
+	"path/filepath"
+)
+
+// LockFileName is the file of the SSTable directory locked by the process that opened the database
+const LockFileName = "LOCK"
+
+// ErrLocked is returned when the WAL or the SSTable directory is already open in another process.
+// Two processes writing the same files would overwrite each other's records.
+var ErrLocked = errors.New("Database is locked by another process")
+
+// lockPath takes an exclusive lock on the file path of fsys, held until the returned Closer is closed
+func lockPath(fsys vfs.FS, path string) (io.Closer, error) {
+	lock, err := fsys.Lock(path)
+	if err == vfs.ErrLocked {
+		return nil, ErrLocked
+	}
+	return lock, err
+}
+
+// lockDir creates the LOCK file of dir and takes an exclusive lock on it
+func lockDir(fsys vfs.FS, dir string) (io.Closer, error) {
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return lockPath(fsys, filepath.Join(dir, LockFileName))
+}
@@ -0,0 +1,86 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelLookup probes up to workers SSTables at the same time when a key may be in several of them, so that
+// a lookup waits for the slowest table instead of for each one in turn, e.g. with tables read from an object
+// store or opened for the first time. The newest table holding the key answers, as with the tables probed one
+// after the other, which is what 0 or 1 does.
+func ParallelLookup(workers int) Option {
+	return func(db *DB) {
+		db.lookups = workers
+	}
+}
+
+// probe is the answer of one SSTable to a lookup
+type probe struct {
+	kv    sstable.KeyValuePair
+	found bool
+	err   error
+}
+
+// probe looks key up in an SSTable, tables whose key range doesn't hold the key aren't read
+func (db *DB) probe(sstableID string, key []byte) probe {
+	reader, err := db.readers.get(sstableID)
+	if err != nil {
+		return probe{err: err}
+	}
+	if !reader.InRange(key) {
+		return probe{}
+	}
+	db.tiering.count(sstableID)
+	kv, found, err := reader.Get(key)
+	return probe{kv: kv, found: found, err: err}
+}
+
+// candidates returns the indexes in SSTableIDs of the tables that may hold key, newest first.
+// Tables without an open reader may, their key range is only known once they are opened.
+func (db *DB) candidates(key []byte) []int {
+	candidates := make([]int, 0, len(db.SSTableIDs))
+	for i := len(db.SSTableIDs) - 1; i >= 0; i-- {
+		if reader := db.readers.peek(db.SSTableIDs[i]); reader == nil || reader.InRange(key) {
+			candidates = append(candidates, i)
+		}
+	}
+	return candidates
+}
+
+// probeParallel probes the candidate tables with up to db.lookups workers, newest first, and returns the answers
+// indexed like candidates. Tables older than one holding the key aren't probed, their answer is left empty.
+// The caller must hold the lock.
+func (db *DB) probeParallel(candidates []int, key []byte) []probe {
+	probes := make([]probe, len(candidates))
+	var newestFound atomic.Int64 // Index in candidates of the newest table found to hold the key so far
+	newestFound.Store(int64(len(candidates)))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(db.lookups, len(candidates)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next {
+				if int64(c) > newestFound.Load() {
+					continue
+				}
+				probes[c] = db.probe(db.SSTableIDs[candidates[c]], key)
+				for probes[c].found {
+					found := newestFound.Load()
+					if int64(c) >= found || newestFound.CompareAndSwap(found, int64(c)) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	for c := range candidates {
+		next <- c
+	}
+	close(next)
+	wg.Wait()
+	return probes
+}
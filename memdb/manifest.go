@@ -0,0 +1,333 @@
+package memdb
+
+import (
+	"StorageEngine/vfs"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The MANIFEST file of the SSTable directory lists the live SSTables as a log of edits, each adding and removing
+// tables. The flushes, compactions, ingestions, purges and retentions append an edit and sync it once their new
+// tables are written, and before the tables they replace are deleted, so that the change is recorded at once or not
+// at all. Opening a database replays the manifest instead of listing the directory: a table written by a flush or a
+// compaction that didn't complete isn't read, and the order of the tables never depends on the modification times
+// of the files, which copies and restores don't always keep. Each edit is
+//
+//	payload length (4 bytes) | CRC32 of the payload (4 bytes) | payload
+//
+// its payload being a list of operations, add or remove (1 byte) | name length (uvarint) | table name. An edit torn
+// by a crash, at the end of the file, is cut off when the manifest is opened: the change it records didn't happen.
+// Once there are more than manifestMinEdits edits and twice as many as live tables, the manifest is rewritten as a
+// single edit adding the live tables. A directory without a manifest, written by an earlier version, is listed and
+// sorted as before, and given a manifest when the database is opened.
+
+const (
+	// ManifestFileName is the name of the manifest in the SSTable directory
+	ManifestFileName = "MANIFEST"
+	// manifestMinEdits is the number of edits the manifest may hold before it is rewritten
+	manifestMinEdits = 64
+
+	manifestAdd        byte = 1
+	manifestRemove     byte = 2
+	manifestHeaderSize      = 8
+)
+
+// ErrCorruptedManifest is returned when an edit of the MANIFEST other than the last one doesn't match its checksum
+// or can't be decoded
+var ErrCorruptedManifest = errors.New("Corrupted MANIFEST")
+
+// manifestEdit is an edit of the manifest, with the names of the tables it adds and removes
+type manifestEdit struct {
+	added   []string
+	removed []string
+}
+
+// manifest is the replayed MANIFEST of an SSTable directory
+type manifest struct {
+	fs    vfs.FS
+	path  string
+	file  vfs.File       // Where edits are appended, nil if read-only or lost by a failed rewrite
+	live  map[string]int // Names of the live tables, with the number of tables added before them
+	added int            // Tables added so far
+	edits int            // Edits in the file
+	size  int64          // Bytes of the edits in the file, where the next one is written
+}
+
+// encode returns the edit as written to the file
+func (e manifestEdit) encode() []byte {
+	data := make([]byte, manifestHeaderSize)
+	for _, op := range []struct {
+		kind  byte
+		names []string
+	}{{manifestAdd, e.added}, {manifestRemove, e.removed}} {
+		for _, name := range op.names {
+			data = append(data, op.kind)
+			data = binary.AppendUvarint(data, uint64(len(name)))
+			data = append(data, name...)
+		}
+	}
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(data)-manifestHeaderSize))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(data[manifestHeaderSize:]))
+	return data
+}
+
+// decodeEdit returns the edit of a payload, ok is false if it doesn't decode
+func decodeEdit(payload []byte) (edit manifestEdit, ok bool) {
+	for len(payload) > 0 {
+		kind := payload[0]
+		length, n := binary.Uvarint(payload[1:])
+		if n <= 0 || length > uint64(len(payload)-1-n) {
+			return edit, false
+		}
+		name := string(payload[1+n : 1+n+int(length)])
+		switch kind {
+		case manifestAdd:
+			edit.added = append(edit.added, name)
+		case manifestRemove:
+			edit.removed = append(edit.removed, name)
+		default:
+			return edit, false
+		}
+		payload = payload[1+n+int(length):]
+	}
+	return edit, true
+}
+
+// apply applies an edit to the live tables
+func (m *manifest) apply(edit manifestEdit) {
+	for _, name := range edit.added {
+		if _, ok := m.live[name]; !ok {
+			m.live[name] = m.added
+			m.added++
+		}
+	}
+	for _, name := range edit.removed {
+		delete(m.live, name)
+	}
+	m.edits++
+}
+
+// replay applies the edits of data, the content of the manifest, and returns the size of those that are whole
+func (m *manifest) replay(data []byte) (int64, error) {
+	pos := 0
+	for len(data)-pos >= manifestHeaderSize {
+		length := int64(binary.BigEndian.Uint32(data[pos:]))
+		if length > int64(len(data)-pos-manifestHeaderSize) {
+			break // Torn
+		}
+		end := pos + manifestHeaderSize + int(length)
+		payload := data[pos+manifestHeaderSize : end]
+		edit, ok := decodeEdit(payload)
+		if !ok || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[pos+4:]) {
+			if end == len(data) {
+				break // Torn, the length was written but not the whole payload
+			}
+			return 0, ErrCorruptedManifest
+		}
+		m.apply(edit)
+		pos = end
+	}
+	return int64(pos), nil
+}
+
+// readManifest replays the manifest of sstableDir without modifying it, nil if there is none
+func readManifest(fsys vfs.FS, sstableDir string) (*manifest, error) {
+	m := &manifest{fs: fsys, path: filepath.Join(sstableDir, ManifestFileName), live: make(map[string]int)}
+	data, err := vfs.ReadFile(fsys, m.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.size, err = m.replay(data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// openManifest replays the manifest of sstableDir and opens it for new edits, cutting off a torn edit at its end.
+// It returns nil if there is none.
+func openManifest(fsys vfs.FS, sstableDir string) (*manifest, error) {
+	m, err := readManifest(fsys, sstableDir)
+	if m == nil || err != nil {
+		return nil, err
+	}
+	file, err := fsys.OpenFile(m.path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo, err := file.Stat(); err != nil || fileInfo.Size() > m.size {
+		if err == nil {
+			err = file.Truncate(m.size)
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	m.file = file
+	return m, nil
+}
+
+// createManifest writes a manifest listing tables, oldest first, to sstableDir, replacing the one it may hold
+func createManifest(fsys vfs.FS, sstableDir string, tables []string) (*manifest, error) {
+	m := &manifest{fs: fsys, path: filepath.Join(sstableDir, ManifestFileName), live: make(map[string]int)}
+	return m, m.rewrite(manifestEdit{added: tableNames(tables)})
+}
+
+// writeManifest writes a manifest listing tables, oldest first, to the SSTable directory of a database that isn't
+// open, e.g. a backup or a restored copy
+func writeManifest(sstableDir string, tables []string) error {
+	m, err := createManifest(vfs.OS, sstableDir, tables)
+	if err != nil {
+		return err
+	}
+	return m.close()
+}
+
+// tableNames returns the names of the files of tables
+func tableNames(tables []string) []string {
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = filepath.Base(table)
+	}
+	return names
+}
+
+// log appends an edit and syncs it: once it returns, the change survives a crash. A failed append leaves the
+// manifest as it was, without the edit.
+func (m *manifest) log(edit manifestEdit) error {
+	if m.file == nil || (m.edits >= manifestMinEdits && m.edits > 2*len(m.live)) {
+		return m.rewrite(edit)
+	}
+	data := edit.encode()
+	if _, err := m.file.WriteAt(data, m.size); err != nil {
+		m.file.Truncate(m.size)
+		return err
+	}
+	if err := m.file.Sync(); err != nil {
+		m.file.Truncate(m.size)
+		return err
+	}
+	m.size += int64(len(data))
+	m.apply(edit)
+	return nil
+}
+
+// rewrite replaces the manifest with a single edit adding the live tables once edit is applied, written to a
+// temporary file renamed over it
+func (m *manifest) rewrite(edit manifestEdit) error {
+	next := &manifest{live: make(map[string]int, len(m.live)), added: m.added}
+	for name, rank := range m.live {
+		next.live[name] = rank
+	}
+	next.apply(edit)
+	names := make([]string, 0, len(next.live))
+	for name := range next.live {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return next.live[names[i]] < next.live[names[j]] })
+	data := manifestEdit{added: names}.encode()
+
+	// The file written to before may have been replaced, even if the rename fails
+	if m.file != nil {
+		m.file.Close()
+		m.file = nil
+	}
+	if err := vfs.WriteFileAtomic(m.fs, m.path, data, 0644); err != nil {
+		return err
+	}
+	file, err := m.fs.OpenFile(m.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	m.file, m.live, m.added, m.edits, m.size = file, make(map[string]int, len(names)), 0, 0, 0
+	m.apply(manifestEdit{added: names})
+	m.size = int64(len(data))
+	return nil
+}
+
+// close closes the file of the manifest
+func (m *manifest) close() error {
+	if m == nil || m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+// addedTimes returns the order the live tables were added in, as times standing for modification times, for
+// olderSSTable: the tables named by time sort in that order, which was the order of their modification times when
+// they were first listed
+func (m *manifest) addedTimes(sstableDir string) map[string]time.Time {
+	times := make(map[string]time.Time, len(m.live))
+	for name, rank := range m.live {
+		times[filepath.Join(sstableDir, name)] = time.Unix(0, int64(rank))
+	}
+	return times
+}
+
+// tables returns the paths of the live tables in sstableDir, oldest first
+func (m *manifest) tables(sstableDir string) []string {
+	times := m.addedTimes(sstableDir)
+	tables := make([]string, 0, len(times))
+	for path := range times {
+		tables = append(tables, path)
+	}
+	sortSSTables(tables, times)
+	return tables
+}
+
+// logTables appends to the manifest the tables added to SSTableIDs and removed from it since the last call, creating
+// the manifest if the database has none yet. It must be called once new tables are written and before the files of
+// the removed ones are deleted. The caller must hold the write lock.
+func (db *DB) logTables() error {
+	if db.follower != nil {
+		return nil // The writer keeps the manifest
+	}
+	if db.manifest == nil {
+		m, err := createManifest(db.fs, db.sstableDir, db.SSTableIDs)
+		if err != nil {
+			return err
+		}
+		db.manifest = m
+		return nil
+	}
+
+	var edit manifestEdit
+	live := make(map[string]bool, len(db.SSTableIDs))
+	for _, name := range tableNames(db.SSTableIDs) {
+		live[name] = true
+		if _, ok := db.manifest.live[name]; !ok {
+			edit.added = append(edit.added, name)
+		}
+	}
+	for name := range db.manifest.live {
+		if !live[name] {
+			edit.removed = append(edit.removed, name)
+		}
+	}
+	if len(edit.added) == 0 && len(edit.removed) == 0 {
+		return nil
+	}
+	sort.Strings(edit.removed)
+	return db.manifest.log(edit)
+}
+
+// logOffline records an edit in the manifest of sstableDir, for the tools working on a closed database. A directory
+// without a manifest is listed when the database is opened, so it is left without one.
+func logOffline(sstableDir string, added []string, removed []string) error {
+	m, err := openManifest(vfs.OS, sstableDir)
+	if m == nil || err != nil {
+		return err
+	}
+	defer m.close()
+	return m.log(manifestEdit{added: tableNames(added), removed: tableNames(removed)})
+}
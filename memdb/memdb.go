@@ -2,8 +2,11 @@ package memdb
 
 import (
 	"StorageEngine/sstable"
+	"StorageEngine/vfs"
 	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
@@ -14,19 +17,69 @@ var ErrKeyNotFound = errors.New("Key not found")
 const (
 	DefaultThreshold = 100 // The default threshold value for the memtable size which
 	// represents the number of key-value pairs
-	CompactionThreshold = 2 // The thershold to perform compaction, i.e. if the number of sst files exceeds
-	// CompactionThreshold, we perform compaction on these files
+	CompactionThreshold = 2 // The default thershold to perform compaction, i.e. if the number of sst files exceeds
+	// CompactionThreshold, we perform compaction on these files. Set with the Compaction option
 )
 
 // DB is an in-memory key/value database using a sorted map.
 type DB struct {
-	mu         sync.RWMutex
-	data       map[string]sstable.Pair
-	keys       []string
-	wal        *WAL
-	threshold  int      // Threshold for the memtable size which represents the number of key-value pairs
-	sstableDir string   // Directory to store SSTables
-	SSTableIDs []string // Track associated SSTables in an ascending order based on the time of creation
+	mu           sync.RWMutex
+	data         map[string]sstable.Pair
+	keys         []string
+	ranges       sstable.RangeDeletions // Range deletions of the memtable, newer than the SSTables and older than its keys
+	wal          *WAL
+	checksum     sstable.ChecksumType
+	fs           vfs.FS         // File system of the WAL and of the SSTables
+	threshold    int            // Threshold for the memtable size which represents the number of key-value pairs
+	maxFileSize  int64          // Size the SSTables written by flushes and compactions are split at, 0 for no limit
+	maxKey       int            // Longest key accepted by writes, set through the SizeLimits option
+	maxValue     int            // Longest value accepted by writes
+	keyMode      KeyMode        // Keys accepted by the operations taking a key, set through the Keys option
+	minCompact   int            // SSTables that make CompactSSTables merge, set through the Compaction option
+	maxCompact   int            // SSTables merged at once by CompactSSTables
+	autoCompact  bool           // Whether flushes run CompactSSTables
+	gcGrace      time.Duration  // How long deletion markers are kept after their flush, set through the TombstoneGrace option
+	collectors   collectorSet   // Collectors of the properties of the SSTables, set through the PropertiesCollectors option
+	sstableDir   string         // Directory to store SSTables
+	SSTableIDs   []string       // Track associated SSTables in an ascending order based on the time of creation
+	manifest     *manifest      // Log of the changes to SSTableIDs, nil until the SSTable directory is created
+	generation   uint64         // Last generation named by newSSTableFilename
+	readers      *readerCache   // SSTables kept open for lookups
+	values       *valueCache    // Values recently read from the SSTables, nil if disabled
+	lookups      int            // SSTables probed at the same time by a lookup, set through the ParallelLookup option
+	directIO     bool           // Whether whole SSTables are read around the page cache, set through the DirectIO option
+	syncWrites   bool           // Whether writes sync the WAL unless told otherwise, set through the SyncWrites option
+	encryption   keySource      // Keys the SSTables are encrypted with, set through the Encryption options
+	readAhead    int64          // Bytes of SSTables read ahead by scans, set through the ReadAhead option
+	hotKeys      *hotKeyTracker // Optional sampling of key accesses, nil if disabled
+	access       *accessTracker // Optional per-key read and write counters, nil if disabled
+	recorder     *recorder      // Recording of the operations, nil if disabled
+	chunkSize    int            // Size of the chunks of the values written by SetReader, set through the ChunkSize option
+	uploads      uploadSet      // Running SetReader uploads, whose chunks compactions keep
+	indexPaths   []string       // Paths of the secondary indexes, set through the Indexes option
+	indexes      indexSet       // Secondary indexes on JSON elements, nil if there are none
+	searchFields []string       // Paths of the fields indexed for full-text search, set through the FullText option
+	search       *searchIndex   // Full-text index, nil if disabled
+	streams      streamSeqs     // Last sequence number of the streams appended to
+	lists        listBoundsMap  // Bounds of the lists pushed to or popped from
+	sequence     uint64         // Changes with every write, see Sequence
+	quotaLimits  *QuotaLimits   // Limits set through the Quota option
+	quota        *quota         // Usage tracking for quota enforcement, nil if no quota is set
+	minFreeSpace uint64         // Free space threshold set through the MinFreeSpace option
+	disk         *diskMonitor   // Low disk space protection, nil if disabled
+	scrubber     *scrubber      // Background checksum verification, nil if disabled
+	warmer       *warmer        // Background warmup of the SSTables, nil if disabled
+	periodic     *ageCompaction // Compaction of the SSTables once they get too old, nil if disabled
+	follower     *follower      // Refresh of a read-only follower, nil for the writer
+	replica      bool           // Whether the data only changes through ApplyReplicated, set by the Replica option
+	remote       *remoteStorage // Copies of the SSTables in an object store, nil if disabled
+	tiering      *tiering       // Moves of the SSTables between the local disk and the object store, nil if disabled
+	timeSeries   *timeSeries    // Time-series mode, nil if disabled
+	closing      chan struct{}  // Closed to stop the background tasks
+	closeOnce    sync.Once
+	events       *eventLog      // Recent flush and compaction events
+	lock         io.Closer      // Lock of the SSTable directory, nil for a follower
+	background   sync.WaitGroup // Running background tasks
 }
 
 // NewDB initializes a new in-memory key/value DB with threshold set to DefaultThreshold if none specified
@@ -35,8 +88,13 @@ func NewDB(wal *WAL, sstableDir string, options ...Option) (*DB, error) {
 		data:       make(map[string]sstable.Pair),
 		keys:       make([]string, 0),
 		wal:        wal,
+		fs:         wal.fs,
 		sstableDir: sstableDir,
 		SSTableIDs: make([]string, 0),
+		readers:    newReaderCache(wal.fs),
+		closing:    make(chan struct{}),
+		events:     newEventLog(DefaultEventHistory),
+		sequence:   uint64(time.Now().UnixNano()),
 	}
 
 	// Apply options
@@ -47,68 +105,222 @@ func NewDB(wal *WAL, sstableDir string, options ...Option) (*DB, error) {
 	if db.threshold == 0 {
 		db.threshold = DefaultThreshold
 	}
+	if db.minCompact < 2 {
+		db.minCompact = CompactionThreshold
+	}
+	if db.maxCompact < db.minCompact {
+		db.maxCompact = db.minCompact
+	}
+	if db.maxKey <= 0 {
+		db.maxKey = DefaultMaxKeySize
+	}
+	if db.maxValue <= 0 {
+		db.maxValue = DefaultMaxValueSize
+	}
+	if db.chunkSize <= 0 {
+		db.chunkSize = DefaultChunkSize
+	}
+	db.chunkSize = min(db.chunkSize, db.maxValue)
+	if db.encryption != nil {
+		enc, err := db.encryption()
+		if err != nil {
+			return nil, err
+		}
+		db.fs = sstable.EncryptFS(db.fs, enc)
+		db.readers.fs = db.fs
+	}
+
+	// Only one process may write the SSTables, the lock is released by Close
+	if db.follower == nil {
+		lock, err := lockDir(db.fs, sstableDir)
+		if err != nil {
+			return nil, err
+		}
+		db.lock = lock
+	}
+	if err := db.open(); err != nil {
+		if db.lock != nil {
+			db.lock.Close()
+		}
+		return nil, err
+	}
+	return db, nil
+}
 
+// open recovers the state of the database from its files and starts the background tasks
+func (db *DB) open() error {
 	// Updating SSTableIDs to acheive recovery
 	// Check if the directory exists
-	_, err := os.Stat(sstableDir)
+	_, err := db.fs.Stat(db.sstableDir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Recover database state
 			err = db.Recover()
 			if err != nil {
-				return nil, err
+				return err
 			}
-			return db, nil // SSTableIDs will be empty
+			// SSTableIDs will be empty
+			return db.start()
 		}
-		return nil, err
+		return err
 	}
 
-	// If the directory exists,
-	// Initialize SSTableIDs with existing file names in sstableDir
-	files, err := os.ReadDir(sstableDir)
+	// If the directory exists, its MANIFEST lists the SSTables in order. A follower only reads it, the writer
+	// may be appending to it.
+	var m *manifest
+	if db.follower != nil {
+		m, err = readManifest(db.fs, db.sstableDir)
+	} else {
+		m, err = openManifest(db.fs, db.sstableDir)
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
+	if m != nil {
+		db.SSTableIDs = m.tables(db.sstableDir)
+	} else {
+		// Without one, written by an earlier version, initialize SSTableIDs with existing file names in sstableDir
+		files, err := db.fs.ReadDir(db.sstableDir)
+		if err != nil {
+			return err
+		}
 
-	// Slice to store file information (name, creation time)
-	var fileInfos []struct {
-		name string
-		time time.Time
-	}
-	for _, file := range files {
-		if !file.IsDir() {
-			fileInfo, err := file.Info()
-			if err != nil {
-				return nil, err
+		// Tables named by generation sort by it, tables named by time before them by modification time
+		modTimes := make(map[string]time.Time)
+		for _, file := range files {
+			// Skip sub-directories and leftovers of interrupted rewrites
+			if !file.IsDir() && isDBFile(file.Name()) {
+				fileInfo, err := file.Info()
+				if err != nil {
+					return err
+				}
+				sstableID := filepath.Join(db.sstableDir, file.Name())
+				db.SSTableIDs = append(db.SSTableIDs, sstableID)
+				modTimes[sstableID] = fileInfo.ModTime()
+			}
+		}
+		sortSSTables(db.SSTableIDs, modTimes)
+		// The order found is kept from now on, whatever becomes of the modification times
+		if db.follower == nil {
+			if m, err = createManifest(db.fs, db.sstableDir, db.SSTableIDs); err != nil {
+				return err
 			}
-			fileInfos = append(fileInfos, struct {
-				name string
-				time time.Time
-			}{sstableDir + "/" + file.Name(), fileInfo.ModTime()})
 		}
 	}
-	// Sort fileInfos based on creation time
-	sort.Slice(fileInfos, func(i, j int) bool {
-		return fileInfos[i].time.Before(fileInfos[j].time)
-	})
-	// Append sorted file names to SSTableIDs
-	for _, fileInfo := range fileInfos {
-		db.SSTableIDs = append(db.SSTableIDs, fileInfo.name)
+	if db.follower == nil {
+		db.manifest = m
 	}
+	// New tables are named after the newest one
+	db.generation = lastGeneration(db.SSTableIDs)
 
 	// If we exceed the CompactionThreshhold, perform compaction
 	// err = db.CompactSSTables()
 	// if err != nil {
-	// 	return nil, err
+	// 	return err
 	// }
 
 	// Recover database state
 	err = db.Recover()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return db, nil
+	return db.start()
+}
+
+// start finishes opening the database once its state is recovered
+func (db *DB) start() error {
+	// The object store may hold tables the SSTable directory doesn't, e.g. on a replaced node
+	if db.remote != nil {
+		if err := db.openRemote(); err != nil {
+			return err
+		}
+	} else if db.tiering != nil {
+		return ErrNoColdTier
+	}
+
+	// Indexes are built from the recovered data, the writes keep them up to date
+	indexes, err := newIndexSet(db.indexPaths)
+	if err != nil {
+		return err
+	}
+	db.indexes = indexes
+	if err := db.buildIndexes(); err != nil {
+		return err
+	}
+	segments := filepath.Join(db.sstableDir, SearchDir)
+	if db.follower != nil {
+		segments = "" // Followers never write, they build the index from the data
+	}
+	if db.search, err = newSearchIndex(db.searchFields, segments); err != nil {
+		return err
+	}
+	if err := db.buildSearch(); err != nil {
+		return err
+	}
+
+	// Quotas are only enforced on new writes, the recovered data is counted as the current usage
+	if db.quotaLimits != nil {
+		usage, err := db.computeUsage()
+		if err != nil {
+			return err
+		}
+		db.quota = newQuota(*db.quotaLimits, usage)
+	}
+	// Same for disk space, recovery only replays data that is already on disk
+	if db.minFreeSpace > 0 {
+		db.disk = &diskMonitor{minFree: db.minFreeSpace}
+	}
+
+	// A follower loads the SSTables it can verify, a table being written is picked up by a later refresh
+	if db.follower != nil {
+		db.follower.lastErr = db.Refresh()
+	}
+
+	// Background tasks
+	if db.follower != nil {
+		db.background.Add(1)
+		go db.follow()
+	}
+	if db.scrubber != nil {
+		db.background.Add(1)
+		go db.runScrubber()
+	}
+	if db.warmer != nil {
+		db.background.Add(1)
+		go db.warmup()
+	}
+	if db.periodic != nil && db.follower == nil {
+		db.background.Add(1)
+		go db.runPeriodicCompaction()
+	}
+	if db.tiering != nil && db.follower == nil {
+		db.background.Add(1)
+		go db.runTiering()
+	}
+	if db.timeSeries != nil && db.timeSeries.policy.Retention > 0 && db.follower == nil {
+		db.background.Add(1)
+		go db.runRetention()
+	}
+	return nil
+}
+
+// Close stops the background tasks of the database and waits for them to finish.
+// The WAL is owned by the caller and must be closed separately.
+func (db *DB) Close() error {
+	db.closeOnce.Do(func() { close(db.closing) })
+	db.background.Wait()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readers.closeAll()
+	db.manifest.close()
+	if db.lock != nil {
+		err := db.lock.Close()
+		db.lock = nil
+		return err
+	}
+	return nil
 }
 
 // Option is a functional option for DB
@@ -121,29 +333,87 @@ func Threshold(threshold int) Option {
 	}
 }
 
+// CompactionOptions tunes the merges of CompactSSTables
+type CompactionOptions struct {
+	MinFiles int  // SSTables that make CompactSSTables merge, at least 2, CompactionThreshold if 0
+	MaxFiles int  // Oldest SSTables merged at once, MinFiles if lower
+	Auto     bool // Run CompactSSTables after every flush, instead of only when called
+}
+
+// Compaction sets when CompactSSTables merges SSTables and how many at once. Merging more tables at once
+// rewrites the data fewer times, at the cost of larger merges.
+func Compaction(opts CompactionOptions) Option {
+	return func(db *DB) {
+		db.minCompact = opts.MinFiles
+		db.maxCompact = opts.MaxFiles
+		db.autoCompact = opts.Auto
+	}
+}
+
+// TargetFileSize splits the output of flushes and compactions into SSTables of at most size bytes, cut between
+// keys, so that later compactions and range reads deal with smaller files. A key and value larger than size
+// get an SSTable of their own. 0, the default, writes a single SSTable whatever its size.
+func TargetFileSize(size int64) Option {
+	return func(db *DB) {
+		db.maxFileSize = size
+	}
+}
+
 // Set inserts or updates a key-value pair into the database while maintaining sorted order
 func (db *DB) Set(key string, value []byte) error {
+	return db.SetWith(key, value, WriteOptions{})
+}
+
+// SetWith sets a key like Set, with the durability of opts. When the WAL fails to sync, the write is visible but
+// may not survive a power loss, and the error is returned.
+func (db *DB) SetWith(key string, value []byte, opts WriteOptions) (err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordSet, Key: key, Value: value}, time.Now(), &err)
+	}
+	key, err = db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := db.setValidated(key, value); err != nil {
+		return err
+	}
+	return db.syncWAL(opts)
+}
+
+// setValidated implements Set for a key already validated
+func (db *DB) setValidated(key string, value []byte) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.recordAccess(key, true)
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+	if err := db.checkSize(key, value); err != nil {
+		return err
+	}
 
-	// 1 - Set the value in the memtable
-	// Binary search the index at which we should insert/update the key in the memtable
-	idx := sort.Search(len(db.keys), func(i int) bool {
-		return db.keys[i] >= key
-	})
+	return db.set(key, value)
+}
 
-	if idx < len(db.keys) && db.keys[idx] == key {
-		// Key already exists, update the value
-		db.data[key] = sstable.Pair{Value: value, Marker: false}
-	} else {
-		// Key doesn't exist, insert at idx
-		db.keys = append(db.keys, "")
-		copy(db.keys[idx+1:], db.keys[idx:])
-		db.keys[idx] = key
-		db.data[key] = sstable.Pair{Value: value, Marker: false}
+// set implements Set, the caller must hold the write lock
+func (db *DB) set(key string, value []byte) error {
+	// 0 - Make sure the write fits on disk and in the quota
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	if err := db.checkDisk(); err != nil {
+		return err
+	}
+	var delta usage
+	if db.quota != nil {
+		var err error
+		if delta, err = db.quotaDelta(key, value); err != nil {
+			return err
+		}
 	}
 
-	// 2 - Write to WAL
+	// 1 - Write to WAL, before anything changes in memory: a write is only visible, and acknowledged, once recovery
+	// can restore it, and a failed WAL write leaves the database as it was
 	walRecord := WALRecord{
 		Operation: OpSet,
 		Key:       []byte(key),
@@ -153,10 +423,14 @@ func (db *DB) Set(key string, value []byte) error {
 		return err
 	}
 
+	// 2 - Set the value in the memtable
+	db.putMemtable(key, sstable.Pair{Value: value, Marker: false})
+	db.quota.apply(delta)
+
 	// 3- Check if memtable size exceeds threshold
-	if len(db.keys) >= db.threshold {
+	if len(db.keys)+len(db.ranges) >= db.threshold {
 		// If so, create and write an SSTable
-		err := db.FlushToSSTable()
+		err := db.flushLocked()
 		if err != nil {
 			return err
 		}
@@ -166,10 +440,26 @@ func (db *DB) Set(key string, value []byte) error {
 }
 
 // Get gets the value for the given key if the key exists. Otherwise, it returns Key Not Found Error
-func (db *DB) Get(key string) ([]byte, error) {
-	// db.mu.RLock()
-	// defer db.mu.RUnlock()
+func (db *DB) Get(key string) (value []byte, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordGet, Key: key}, time.Now(), &err)
+	}
+	key, err = db.ValidateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.recordAccess(key, false)
 
+	if value, err = db.get(key); err != nil {
+		return nil, err
+	}
+	return db.resolveValue(key, value)
+}
+
+// get implements Get, the caller must hold the lock
+func (db *DB) get(key string) ([]byte, error) {
 	// Check in-memory data
 	value, ok := db.data[key]
 	if ok {
@@ -179,7 +469,10 @@ func (db *DB) Get(key string) ([]byte, error) {
 		return nil, ErrKeyNotFound // The key was deleted
 	}
 
-	// If not found in memory, search in SST files
+	// If not found in memory, search in the value cache, then in SST files
+	if val, ok := db.values.get(key); ok {
+		return val, nil
+	}
 	val, err := db.GetValueFromSSTables(key)
 	if err != nil {
 		// If the key is found in some sst file but with a del operation (i.e. it was deleted)
@@ -187,15 +480,96 @@ func (db *DB) Get(key string) ([]byte, error) {
 		// Then, err is KeyNotFound
 		return nil, err
 	}
+	db.values.add(key, val)
 
 	return val, nil
 }
 
-// Delete deletes the value for the given key
-func (db *DB) Delete(key string) ([]byte, error) {
+// Delete deletes the given key without reading its current value, so its cost is a memtable insert
+// and a WAL append whatever the number of SSTables. Deleting a missing key is not an error.
+// When a quota is set, the current value is still read to keep the usage accurate.
+func (db *DB) Delete(key string) error {
+	return db.DeleteWith(key, WriteOptions{})
+}
+
+// DeleteWith deletes a key like Delete, with the durability of opts, see SetWith
+func (db *DB) DeleteWith(key string, opts WriteOptions) (err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordDelete, Key: key}, time.Now(), &err)
+	}
+	key, err = db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := db.deleteValidated(key); err != nil {
+		return err
+	}
+	return db.syncWAL(opts)
+}
+
+// deleteValidated implements DeleteWith for a key already validated
+func (db *DB) deleteValidated(key string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.recordAccess(key, true)
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+	if err := db.checkDisk(); err != nil {
+		return err
+	}
+
+	if db.quota != nil {
+		if _, err := db.deleteReturning(key); err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		return nil
+	}
+	return db.writeTombstone(key)
+}
+
+// DeleteReturning deletes the given key and returns its value before deletion.
+// It returns ErrKeyNotFound if the key doesn't exist, which requires reading the SSTables when
+// the key isn't in the memtable: use Delete when the old value isn't needed.
+func (db *DB) DeleteReturning(key string) ([]byte, error) {
+	return db.DeleteReturningWith(key, WriteOptions{})
+}
+
+// DeleteReturningWith deletes a key like DeleteReturning, with the durability of opts, see SetWith
+func (db *DB) DeleteReturningWith(key string, opts WriteOptions) (value []byte, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordDeleteReturning, Key: key}, time.Now(), &err)
+	}
+	key, err = db.ValidateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if value, err = db.deleteReturningValidated(key); err != nil {
+		return nil, err
+	}
+	return value, db.syncWAL(opts)
+}
+
+// deleteReturningValidated implements DeleteReturningWith for a key already validated
+func (db *DB) deleteReturningValidated(key string) ([]byte, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.recordAccess(key, true)
+	if err := db.checkClientWrite(); err != nil {
+		return nil, err
+	}
+	if err := db.checkDisk(); err != nil {
+		return nil, err
+	}
+	value, err := db.deleteReturning(key)
+	if err != nil {
+		return nil, err
+	}
+	return db.resolveValue(key, value)
+}
 
+// deleteReturning implements DeleteReturning, the caller must hold the write lock
+func (db *DB) deleteReturning(key string) ([]byte, error) {
 	// Check if the key exists in the in-memory database
 	val, exists := db.data[key]
 	if !exists {
@@ -204,33 +578,28 @@ func (db *DB) Delete(key string) ([]byte, error) {
 		if err != nil { // If key not found in SST files, return keyn not found error
 			return nil, err
 		}
-		// Set the marker to true to indicate deletion in the in-memory database
-		// Binary search the index at which we should insert the key in the memtable
-		idx := sort.Search(len(db.keys), func(i int) bool {
-			return db.keys[i] >= key
-		})
-		db.keys = append(db.keys, "")
-		copy(db.keys[idx+1:], db.keys[idx:])
-		db.keys[idx] = key
-		db.data[key] = sstable.Pair{Value: value, Marker: true}
-
-		// Write deletion to WAL
-		walRecord := WALRecord{
-			Operation: OpDel,
-			Key:       []byte(key),
-			Value:     nil, // Value doesn't matter for delete operation in WAL
-		}
-		if err := db.wal.WriteEntry(walRecord); err != nil {
+		if err := db.writeTombstone(key); err != nil {
 			return nil, err
 		}
+		db.quota.apply(usage{keys: -1, bytes: -int64(len(key) + len(value))})
 		return value, nil
 	}
 	if exists && val.Marker == true { // If it is in memory but was already deleted
 		return nil, ErrKeyNotFound
 	}
 	// If the key exists in memory, set the marker to true to indicate deletion
-	db.data[key] = sstable.Pair{Value: nil, Marker: true}
+	if err := db.writeTombstone(key); err != nil {
+		return nil, err
+	}
+	db.quota.apply(usage{keys: -1, bytes: -int64(len(key) + len(val.Value))})
 
+	// Return the value before deletion
+	return val.Value, nil
+}
+
+// writeTombstone logs the deletion of key to the WAL, then marks key as deleted in the memtable, inserting it if
+// needed, like set does. The tombstone carries no value, so nothing of the deleted value reaches the SSTables.
+func (db *DB) writeTombstone(key string) error {
 	// Write deletion to WAL
 	walRecord := WALRecord{
 		Operation: OpDel,
@@ -238,57 +607,124 @@ func (db *DB) Delete(key string) ([]byte, error) {
 		Value:     nil, // Value doesn't matter for delete operation in WAL
 	}
 	if err := db.wal.WriteEntry(walRecord); err != nil {
-		return nil, err
+		return err
 	}
 
-	// Return the value before deletion
-	return val.Value, nil
+	db.putMemtable(key, sstable.Pair{Value: nil, Marker: true})
+	return nil
 }
 
-// ListKeys returns a sorted list of keys.
-func (db *DB) ListKeys() []string {
+// putMemtable sets the entry of key in the memtable, keeping the keys sorted, and drops its cached value
+func (db *DB) putMemtable(key string, pair sstable.Pair) {
+	db.sequence++
+	db.values.invalidate(key)
+	switch {
+	case internalKey(key):
+		// Chunks of values aren't documents of their own
+	case pair.Marker:
+		db.indexes.remove(key)
+		db.search.remove(key)
+	default:
+		db.indexes.put(key, pair.Value)
+		db.search.put(key, pair.Value)
+	}
+	if _, exists := db.data[key]; !exists {
+		// Binary search the index at which we should insert the key in the memtable
+		idx := sort.Search(len(db.keys), func(i int) bool {
+			return db.keys[i] >= key
+		})
+		db.keys = append(db.keys, "")
+		copy(db.keys[idx+1:], db.keys[idx:])
+		db.keys[idx] = key
+	}
+	db.data[key] = pair
+}
+
+// ListKeys returns the sorted list of live keys, from the memtable and the SSTables.
+// Deleted keys are left out.
+func (db *DB) ListKeys() ([]string, error) {
+	return db.ListKeysPrefix("")
+}
+
+// ListKeysPrefix returns the sorted list of live keys starting with prefix
+func (db *DB) ListKeysPrefix(prefix string) (keys []string, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordListKeys, Prefix: prefix}, time.Now(), &err)
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	keysCopy := make([]string, len(db.keys))
-	copy(keysCopy, db.keys)
-	return db.keys
+	it, err := db.newIterator(IteratorOptions{Prefix: prefix, manifests: true})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	keys = make([]string, 0)
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys, it.Err()
 }
 
+// FlushToSSTable writes the memtable to new SSTables and empties it, then compacts them if the Compaction option
+// asks for it
 func (db *DB) FlushToSSTable() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.flushLocked()
+}
+
+// flushLocked implements FlushToSSTable. The caller must hold the write lock.
+func (db *DB) flushLocked() (err error) {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	event := Event{Type: EventFlush, Start: time.Now(), Entries: len(db.data)}
+	defer func() { db.recordEvent(event, err) }()
+
 	// Ensure the directory exists or create it if it doesn't
-	if err := os.MkdirAll(db.sstableDir, 0755); err != nil {
+	if err := db.fs.MkdirAll(db.sstableDir, 0755); err != nil {
 		return err
 	}
-	// Create an SSTable and write it to a file of the format sstable_file_YYMMDDHHMMSS.sst
-	sstableFilename := db.sstableDir + "/sstable_file_" + time.Now().Format("060102150405") + ".sst"
-	err := sstable.CreateAndWriteSSTable(sstableFilename, db.data)
+	// Create an SSTable and write it to a file named by the next generation, e.g. 000001.sst
+	// Split into several SSTables, each of the next generation, if TargetFileSize is set or by time window
+	// Deletion markers carry the time of the flush, which the TombstoneGrace period counts from
+	keyValues := sstable.WithRangeDeletions(sstable.MemtableKeyValues(db.data), db.ranges)
+	db.stampTombstones(keyValues, event.Start)
+	outputs, err := db.writeWindowTables(keyValues, db.newSSTableFilename, db.tableProperties(nil, nil))
 	if err != nil {
 		return err
 	}
+	event.Outputs = outputs
+	// The words of the flushed keys go to a segment of the same generation
+	if err := db.search.writeSegment(db.fs, db.keys, db.ranges, db.generation); err != nil {
+		return err
+	}
 
 	// Clear memtable after flushing to SSTable
 	db.data = make(map[string]sstable.Pair)
 	db.keys = make([]string, 0)
+	db.ranges = nil
 
 	// Track the SSTable filename
-	db.SSTableIDs = append(db.SSTableIDs, sstableFilename)
-	// If we exceed the CompactionThreshhold, perform compaction
-	// err = db.CompactSSTables()
-	// if err != nil {
-	// 	return err
-	// }
-	
-	// Update the watermark of the wal
-	for i := 0; i < db.threshold; i++ {
-		db.wal.ReadNextEntry()
+	db.SSTableIDs = append(db.SSTableIDs, outputs...)
+	db.readers.open(outputs)
+	// The MANIFEST lists the new tables before the WAL records they hold are marked flushed
+	if err := db.logTables(); err != nil {
+		return err
 	}
-	err = db.wal.writeMetadata()
+
+	// Update the watermark of the wal: every record written so far is in the memtable, now in the SSTable
+	err = db.wal.markFlushed()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// If we exceed the compaction threshold, perform compaction
+	if db.autoCompact {
+		return db.compactLocked()
+	}
+	return db.syncRemote()
 }
 
 // ReadSSTables returns a list of all sstables of db
@@ -296,7 +732,7 @@ func (db *DB) FlushToSSTable() error {
 func (db *DB) ReadSSTables() ([]*sstable.SSTable, error) {
 	var sstables []*sstable.SSTable
 	for i := len(db.SSTableIDs) - 1; i >= 0; i-- {
-		sst, err := sstable.ReadSSTable(db.SSTableIDs[i])
+		sst, err := db.readSSTable(db.SSTableIDs[i])
 		if err != nil {
 			return nil, err
 		}
@@ -309,30 +745,33 @@ func (db *DB) ReadSSTables() ([]*sstable.SSTable, error) {
 // retrieving its associated value if present and not marked for deletion.
 // If the key is found and marked for deletion, it returns ErrKeyNotFound.
 // If the key is not found, it returns ErrKeyNotFound.
+// Keys deleted by a range deletion of the memtable, which is newer than the SSTables, are not found either.
 func (db *DB) GetValueFromSSTables(key string) ([]byte, error) {
-	// Search in SSTables from newest to oldest
-	sstables, err := db.ReadSSTables()
-	if err != nil {
-		return nil, err
+	if db.ranges.Covers([]byte(key)) {
+		return nil, ErrKeyNotFound
 	}
-
-	for _, sst := range sstables {
-		// Skip the SSTable if the key falls outside the range defined by its smallest and largest keys.
-		// if key < string(sst.Header.SmallestKey) || key > string(sst.Header.LargestKey) {
-		// 	continue
-		// }
-
-		// Binary search in SSTable in reverse order
-		idx := sort.Search(len(sst.KeyValues), func(i int) bool {
-			return string(sst.KeyValues[i].Key) >= key // Reverse search
-		})
-
-		if idx >= 0 && idx < len(sst.KeyValues) && string(sst.KeyValues[idx].Key) == key {
+	// Search in SSTables from newest to oldest, with the readers kept open
+	candidates := db.candidates([]byte(key))
+	var probes []probe
+	if db.lookups > 1 && len(candidates) > 1 {
+		probes = db.probeParallel(candidates, []byte(key))
+	}
+	for c, i := range candidates {
+		var p probe
+		if probes != nil {
+			p = probes[c]
+		} else {
+			p = db.probe(db.SSTableIDs[i], []byte(key))
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		if p.found {
 			// Check if the operation is a delete
-			if sst.KeyValues[idx].Operation == sstable.OpDel {
+			if p.kv.Operation == sstable.OpDel {
 				return nil, ErrKeyNotFound
 			}
-			return sst.KeyValues[idx].Value, nil
+			return p.kv.Value, nil
 		}
 	}
 
@@ -343,70 +782,145 @@ func (db *DB) GetValueFromSSTables(key string) ([]byte, error) {
 // to restore the database state in case of a crash or abrupt shutdown.
 // It checks for unflushed operations and replays them, applying 'Set' and 'Delete' operations
 // based on the records in the WAL, ensuring consistency after recovery.
+// The records are applied to the memtable without being logged again: they stay where they are in the WAL
+// until the memtable is flushed, which moves the watermark past them.
 func (db *DB) Recover() error {
-	// Check if the WAL has unflushed operations
-	currentOffset := db.wal.MetaData.Offset
-	if db.wal.MetaData.Watermark < currentOffset {
-
-		// Replay unflushed operations
-		for {
-			// This seeks to the watermark, reads a wal record and updates the watermark
-			if db.wal.MetaData.Watermark == currentOffset {
-				break
-			}
-			record, err := db.wal.ReadNextEntry()
+	if db.wal.MetaData.Watermark >= db.wal.MetaData.Offset {
+		return nil
+	}
 
-			if err != nil {
-				return err
-			}
-			switch record.Operation {
-			case OpSet:
-				err := db.Set(string(record.Key), record.Value)
-				if err != nil {
-					return err
-				}
-			case OpDel:
-				_, err := db.Delete(string(record.Key))
-				if err != nil {
-					return err
-				}
-			}
+	// The records below the watermark are in the SSTables, the ones past it were checked by OpenWAL
+	_, err := scanWALFile(db.fs, db.wal.file.Name(), db.wal.MetaData.Watermark, func(entry WALEntry) error {
+		if !entry.Flushed {
+			db.apply(entry.WALRecord)
 		}
+		return nil
+	})
+	// A memtable over the threshold isn't flushed here but by the next write, so opening the database stays fast
+	return err
+}
 
+// apply replays a WAL record into the memtable, without writing it to the WAL
+func (db *DB) apply(record WALRecord) {
+	switch record.Operation {
+	case OpSet:
+		db.putMemtable(string(record.Key), sstable.Pair{Value: record.Value, Marker: false})
+	case OpDel:
+		db.putMemtable(string(record.Key), sstable.Pair{Value: nil, Marker: true})
+	case OpDelRange:
+		db.applyRange(sstable.RangeDeletion{Start: record.Key, End: record.Value})
+	case OpBatch:
+		// The checksum of the record matched, so its records decode
+		records, _ := DecodeBatch(record)
+		for _, record := range records {
+			db.apply(record)
+		}
 	}
-
-	return nil
 }
 
-// Perform compaction on SSTables if the total number of sst files exceeds CompactionThreshold
+// Perform compaction on SSTables if the total number of sst files reaches the MinFiles of the Compaction option
 func (db *DB) CompactSSTables() error {
-	if len(db.SSTableIDs) < CompactionThreshold {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.compactLocked()
+}
+
+// compactLocked implements CompactSSTables. The caller must hold the write lock.
+func (db *DB) compactLocked() error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	if db.timeSeries != nil {
+		return db.compactWindows()
+	}
+	if len(db.SSTableIDs) < db.minCompact {
 		return nil // No need for compaction
 	}
 	for {
-		if len(db.SSTableIDs) < CompactionThreshold {
+		if len(db.SSTableIDs) < db.minCompact {
 			break
 		}
-		// Collect smaller SSTables for compaction (e.g., take the first CompactionThreshold (e.g. 5) SSTables for merging)
-		sstablesToCompact := db.SSTableIDs[:CompactionThreshold]
+		tables := len(db.SSTableIDs)
+		// Collect the oldest SSTables for compaction, up to MaxFiles of them
+		merged := min(len(db.SSTableIDs), db.maxCompact)
+		// but at least the tables named by time, the outputs can only take the place of the newest of them
+		for merged < len(db.SSTableIDs) {
+			if _, _, ok := parseGeneration(db.SSTableIDs[merged]); ok {
+				break
+			}
+			merged++
+		}
+		sstablesToCompact := db.SSTableIDs[:merged]
+
+		// Inputs only kept in the object store are merged from local copies
+		if err := db.fetchRemote(sstablesToCompact); err != nil {
+			return err
+		}
 
 		// Merge smaller SSTables into a single larger SSTable
-		compactedSSTable, err := sstable.MergeSSTables(sstablesToCompact, db.sstableDir)
+		event := Event{
+			Type:       EventCompaction,
+			Start:      time.Now(),
+			Inputs:     append([]string(nil), sstablesToCompact...),
+			InputBytes: filesSize(db.fs, sstablesToCompact),
+		}
+		compactedSSTables, err := db.mergeSSTables(sstablesToCompact)
 		if err != nil {
+			db.recordEvent(event, err)
 			return err
 		}
+		event.Outputs = compactedSSTables
+		db.recordEvent(event, nil)
 
-		// Update SSTableIDs to reflect the compacted SSTable
-		db.SSTableIDs = append([]string{compactedSSTable}, db.SSTableIDs[CompactionThreshold:]...) // Replace compacted SSTables with the new one at their position
+		// Update SSTableIDs to reflect the compacted SSTables
+		db.SSTableIDs = append(compactedSSTables, db.SSTableIDs[merged:]...) // Replace compacted SSTables with the new ones at their position
+		db.readers.open(compactedSSTables)
+		if err := db.logTables(); err != nil {
+			return err
+		}
 
 		// Delete the smaller SSTables that were merged during compaction
 		for _, sstableID := range sstablesToCompact {
-			err := os.Remove(sstableID)
+			db.readers.evict(sstableID)
+			err := db.fs.Remove(sstableID)
 			if err != nil {
 				return err
 			}
 		}
+
+		// With TargetFileSize, the outputs may be as many as the inputs: merging them again would never end
+		if len(db.SSTableIDs) >= tables {
+			break
+		}
 	}
 
-	return nil
+	if err := db.search.compactSegments(db.fs, db.data); err != nil {
+		return err
+	}
+	return db.syncRemote()
+}
+
+// mergeSSTables merges sstableIDs, oldest first, into SSTables of at most TargetFileSize bytes that take their place
+// among the tables, keeping the deletion markers. The tables are merged by iterators over their readers, and written
+// as they are merged, so that a compaction doesn't hold its inputs or its outputs in memory. It returns the names of
+// the merged tables.
+func (db *DB) mergeSSTables(sstableIDs []string) ([]string, error) {
+	headers, err := db.inputHeaders(sstableIDs)
+	if err != nil {
+		return nil, err
+	}
+	// The chunks of the values written by SetReader that were replaced or deleted go
+	keep := db.chunkFilter()
+	newest := sstableIDs[len(sstableIDs)-1]
+	outputs, _, err := db.writeSSTablesFrom(func() (sstable.Iterator, error) {
+		inputs, err := db.tableIterators(sstableIDs)
+		if err != nil {
+			return nil, err
+		}
+		merged := sstable.NewMergingIterator(inputs, false)
+		return newFilterIterator(sstable.WithRangeDeletionsIterator(merged), keep), nil
+	}, false, func() (string, error) {
+		return compactionFilename(db.fs, db.sstableDir, db.SSTableIDs, newest)
+	}, db.tableProperties(headers, sstableIDs))
+	return outputs, err
 }
@@ -0,0 +1,114 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrUnsupportedFormat is returned by Migrate when a file uses a format version this build can't read
+var ErrUnsupportedFormat = errors.New("Unsupported format version")
+
+// TableMigration describes what Migrate did with one SSTable
+type TableMigration struct {
+	Path        string `json:"path"`
+	FromVersion uint16 `json:"from_version"`
+	ToVersion   uint16 `json:"to_version"`
+	Rewritten   bool   `json:"rewritten"`
+}
+
+// MigrationReport is the outcome of Migrate
+type MigrationReport struct {
+	Tables         []TableMigration `json:"tables"`
+	WALVersion     int              `json:"wal_version"`     // Version of the WAL after the migration
+	WALRecords     int              `json:"wal_records"`     // Records validated in the WAL
+	TablesVerified int              `json:"tables_verified"` // SSTables whose checksum was verified
+}
+
+// supportedSSTableVersion reports whether this build can read SSTables of the given format version
+func supportedSSTableVersion(version uint16) bool {
+	for _, v := range sstable.SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Migrate rewrites the SSTables and the WAL of a closed database written in older format versions to the
+// current ones, verifying every checksum along the way. Files already in the current format are verified
+// and left untouched. Nothing is rewritten if dryRun is set, the report then tells what would be.
+// Rewritten SSTables keep their modification time, which orders them.
+func Migrate(walPath string, sstableDir string, dryRun bool) (MigrationReport, error) {
+	report := MigrationReport{Tables: make([]TableMigration, 0), WALVersion: WALFormatVersion}
+
+	tables, err := listSSTables(sstableDir)
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+
+	// Verify everything before rewriting anything
+	migrations := make([]TableMigration, 0, len(tables))
+	for _, path := range tables {
+		sst, err := sstable.ReadSSTable(path)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", path, err)
+		}
+		if !supportedSSTableVersion(sst.Header.Version) {
+			return report, fmt.Errorf("%s: %w %d", path, ErrUnsupportedFormat, sst.Header.Version)
+		}
+		report.TablesVerified++
+		migrations = append(migrations, TableMigration{Path: path, FromVersion: sst.Header.Version, ToVersion: sstable.CurrentVersion})
+	}
+	if _, err := ScanWALFile(walPath, func(WALEntry) error {
+		report.WALRecords++
+		return nil
+	}); err != nil && !os.IsNotExist(err) {
+		return report, fmt.Errorf("%s: %w", walPath, err)
+	}
+
+	for _, migration := range migrations {
+		if migration.FromVersion != migration.ToVersion {
+			migration.Rewritten = true
+			if !dryRun {
+				if err := rewriteSSTable(migration.Path); err != nil {
+					return report, fmt.Errorf("%s: %w", migration.Path, err)
+				}
+			}
+		}
+		report.Tables = append(report.Tables, migration)
+	}
+	return report, nil
+}
+
+// rewriteSSTable rewrites an SSTable in the current format, keeping its modification time
+func rewriteSSTable(path string) error {
+	sst, err := sstable.ReadSSTable(path)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Older tables were created when they were last modified, the checksum algorithm is kept
+	table := sstable.NewSSTable(sst.KeyValues)
+	table.Header.Properties.Created = fileInfo.ModTime()
+	table.SetChecksum(sst.Header.ChecksumType)
+	if err := sstable.WriteSSTable(tmp, table); err != nil {
+		return err
+	}
+	if _, err := sstable.ReadSSTable(tmp); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, fileInfo.ModTime(), fileInfo.ModTime()); err != nil {
+		return err
+	}
+	return vfs.ReplaceFile(vfs.OS, tmp, path)
+}
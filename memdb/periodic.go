@@ -0,0 +1,112 @@
+package memdb
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// maxPeriodicCheck is the longest time between two checks of the age of the SSTables
+const maxPeriodicCheck = time.Hour
+
+// PeriodicCompaction rewrites the SSTables once the oldest one is older than maxAge, even if no threshold is
+// reached, so that deletion markers and tables written in older format versions don't stay on disk forever.
+// Every table is merged into new ones in the current format, without the deletion markers older than the
+// TombstoneGrace period, which is safe as the merge includes every older version of the keys. The age of the tables is checked every maxAge/4, and at
+// least every hour. 0 disables periodic compactions.
+func PeriodicCompaction(maxAge time.Duration) Option {
+	return func(db *DB) {
+		if maxAge > 0 {
+			db.periodic = &ageCompaction{maxAge: maxAge}
+		}
+	}
+}
+
+// ageCompaction is the configuration of the periodic compactions
+type ageCompaction struct {
+	maxAge time.Duration
+}
+
+// runPeriodicCompaction checks the age of the SSTables until the database is closed
+func (db *DB) runPeriodicCompaction() {
+	defer db.background.Done()
+	interval := db.periodic.maxAge / 4
+	if interval > maxPeriodicCheck {
+		interval = maxPeriodicCheck
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-ticker.C:
+			if _, err := db.compactExpired(db.periodic.maxAge); err != nil {
+				log.Printf("Periodic compaction: %s", err)
+			}
+		}
+	}
+}
+
+// compactExpired compacts every SSTable if one of them is older than maxAge, and reports whether it did
+func (db *DB) compactExpired(maxAge time.Duration) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	expired := false
+	for _, sstableID := range db.SSTableIDs {
+		// Tables only kept in the object store have no known age, they are rewritten with the others
+		if fileInfo, err := db.fs.Stat(sstableID); err == nil && time.Since(fileInfo.ModTime()) > maxAge {
+			expired = true
+			break
+		}
+	}
+	if !expired {
+		return false, nil
+	}
+	return true, db.compactAll()
+}
+
+// compactAll merges every SSTable into new ones taking their place, dropping overwritten values and the deletion
+// markers older than the TombstoneGrace period.
+// The caller must hold the write lock.
+func (db *DB) compactAll() (err error) {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	inputs := append([]string(nil), db.SSTableIDs...)
+	event := Event{Type: EventCompaction, Start: time.Now(), Inputs: inputs, InputBytes: filesSize(db.fs, inputs), Outputs: make([]string, 0)}
+	defer func() { db.recordEvent(event, err) }()
+
+	headers, err := db.inputHeaders(inputs)
+	if err != nil {
+		return err
+	}
+	if err := db.fs.MkdirAll(db.sstableDir, 0755); err != nil {
+		return err
+	}
+	newest := inputs[len(inputs)-1]
+	event.Outputs, event.Entries, err = db.writeSSTablesFrom(db.mergeDroppingTombstones(inputs), true, func() (string, error) {
+		return compactionFilename(db.fs, db.sstableDir, inputs, newest)
+	}, db.tableProperties(headers, inputs))
+	if err != nil {
+		return err
+	}
+
+	// The outputs sort after every input, so they win over any input left behind by an interruption
+	db.SSTableIDs = append([]string(nil), event.Outputs...)
+	db.readers.open(event.Outputs)
+	if err := db.logTables(); err != nil {
+		return err
+	}
+	for _, input := range inputs {
+		db.readers.evict(input)
+		if err := db.fs.Remove(input); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := db.search.compactSegments(db.fs, db.data); err != nil {
+		return err
+	}
+	return db.syncRemote()
+}
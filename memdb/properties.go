@@ -0,0 +1,96 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"math"
+)
+
+// collectorSet creates the collectors of the user-defined properties of each SSTable
+type collectorSet []func() sstable.PropertiesCollector
+
+// tableProps completes the properties of the tables written by a flush, a compaction or an ingestion, see
+// tableProperties
+type tableProps struct {
+	minSeq, maxSeq uint64 // Span of the sequences of the inputs of a merge
+	merge          bool   // Whether the tables are merged from inputs, or written by a flush or an ingestion
+	collectors     collectorSet
+	checksum       sstable.ChecksumType
+}
+
+// PropertiesCollectors adds user-defined properties to every SSTable written by flushes, compactions and ingestions:
+// each function returns a new collector for a table, which sees all of its entries before the table is written.
+// The properties are listed by ListSSTables and read without the entries by sstable.ReadProperties.
+func PropertiesCollectors(collectors ...func() sstable.PropertiesCollector) Option {
+	return func(db *DB) {
+		db.collectors = append(db.collectors, collectors...)
+	}
+}
+
+// tableProperties returns how to complete the properties of the tables merged from the tables with headers inputs,
+// named by inputIDs, or of the tables of a flush or an ingestion if there are none. The sequences of the entries are
+// the generations of the flushes and ingestions that wrote them: the generation of the table itself for a flush, the
+// span of the sequences of the inputs for a merge. The collectors of the database then add their properties, and
+// the checksum is computed with the algorithm of the database.
+func (db *DB) tableProperties(inputs []*sstable.SSTableHeader, inputIDs []string) *tableProps {
+	p := &tableProps{minSeq: math.MaxUint64, merge: len(inputs) > 0, collectors: db.collectors, checksum: db.checksum}
+	for i, input := range inputs {
+		low, high := tableSequences(input, inputIDs[i])
+		p.minSeq, p.maxSeq = min(p.minSeq, low), max(p.maxSeq, high)
+	}
+	return p
+}
+
+// complete completes the properties of a table about to be written to output, and sets its checksum
+func (p *tableProps) complete(output string, table *sstable.SSTable) {
+	p.setSequences(output, table.Header.Properties)
+	table.CollectProperties(p.collectors.create()...)
+	table.SetChecksum(p.checksum)
+}
+
+// builder returns the builder of a table streamed to its file, which collects its properties and computes its
+// checksum as its entries are added, see writeSSTablesFrom. Its sequences are set once it is named.
+func (p *tableProps) builder() *sstable.TableBuilder {
+	return sstable.NewTableBuilder(p.checksum, p.collectors.create()...)
+}
+
+// setSequences sets the sequences of the properties of a table written to output
+func (p *tableProps) setSequences(output string, props *sstable.Properties) {
+	if !p.merge {
+		seq, _, _ := parseGeneration(output)
+		props.MinSequence, props.MaxSequence = seq, seq
+		return
+	}
+	props.MinSequence, props.MaxSequence = p.minSeq, p.maxSeq
+}
+
+// tableSequences returns the span of the sequences of the entries of an SSTable: from its properties, or its
+// generation if it was written without them, 0 for the tables named by time
+func tableSequences(header *sstable.SSTableHeader, sstableID string) (uint64, uint64) {
+	if props := header.Properties; props != nil {
+		return props.MinSequence, props.MaxSequence
+	}
+	gen, _, _ := parseGeneration(sstableID)
+	return gen, gen
+}
+
+// create returns new collectors for a table
+func (s collectorSet) create() []sstable.PropertiesCollector {
+	collectors := make([]sstable.PropertiesCollector, len(s))
+	for i, newCollector := range s {
+		collectors[i] = newCollector()
+	}
+	return collectors
+}
+
+// inputHeaders returns the headers of the SSTables merged by a compaction, from their readers
+func (db *DB) inputHeaders(sstableIDs []string) ([]*sstable.SSTableHeader, error) {
+	headers := make([]*sstable.SSTableHeader, 0, len(sstableIDs))
+	for _, sstableID := range sstableIDs {
+		reader, err := db.readers.get(sstableID)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, &reader.Header)
+	}
+	return headers, nil
+}
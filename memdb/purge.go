@@ -0,0 +1,189 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrPurgeIncomplete is returned by Purge when a copy of the key is still found on disk after purging
+var ErrPurgeIncomplete = errors.New("Purged key is still present on disk")
+
+// PurgeReport describes the files touched by a purge
+type PurgeReport struct {
+	Key            string   `json:"key"`
+	Existed        bool     `json:"existed"`         // Whether the key had a live value before the purge
+	FilesRewritten []string `json:"files_rewritten"` // SSTables rewritten without the key
+	FilesRemoved   []string `json:"files_removed"`   // SSTables removed because they only held the key
+	WALTruncated   bool     `json:"wal_truncated"`   // Whether the WAL was truncated to drop logged copies
+}
+
+// Purge deletes a key and guarantees that no copy of its value remains on disk, unlike Delete which
+// leaves older values in the SSTables until they happen to be compacted.
+// A tombstone is written and flushed first, so a crash in the middle of the purge can't resurrect the key.
+// Then the WAL, which is fully flushed at this point, is truncated, and every SSTable holding the key
+// is rewritten without it, from the oldest to the newest. Finally all SSTables are checked again.
+func (db *DB) Purge(key string) (report PurgeReport, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordPurge, Key: key}, time.Now(), &err)
+	}
+	stored, err := db.ValidateKey(key)
+	if err != nil {
+		return PurgeReport{Key: key}, err
+	}
+	key = stored
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	report = PurgeReport{Key: key, FilesRewritten: make([]string, 0), FilesRemoved: make([]string, 0)}
+	if err := db.checkClientWrite(); err != nil {
+		return report, err
+	}
+
+	// 1 - Write a tombstone, like a regular delete
+	pair, inMemory := db.data[key]
+	if inMemory {
+		report.Existed = !pair.Marker
+	} else {
+		old, err := db.GetValueFromSSTables(key)
+		if err != nil && err != ErrKeyNotFound {
+			return report, err
+		}
+		report.Existed = err == nil
+		pair.Value = old
+	}
+	if err := db.writeTombstone(key); err != nil {
+		return report, err
+	}
+	if report.Existed {
+		db.quota.apply(usage{keys: -1, bytes: -int64(len(key) + len(pair.Value))})
+	}
+	db.hotKeys.forget(key)
+	db.access.forget(key)
+
+	// 2 - Flush the memtable so the WAL holds nothing that isn't in an SSTable, then drop it
+	if err := db.flushLocked(); err != nil {
+		return report, err
+	}
+	if err := db.wal.Reset(); err != nil {
+		return report, err
+	}
+	report.WALTruncated = true
+
+	// 3 - Rewrite the SSTables holding the key, the newest one (holding the tombstone) last,
+	// from local copies of the tables only kept in the object store
+	if err := db.fetchRemote(db.SSTableIDs); err != nil {
+		return report, err
+	}
+	event := Event{Type: EventPurge, Start: time.Now(), Outputs: make([]string, 0)}
+	kept := make([]string, 0, len(db.SSTableIDs))
+	for _, sstableID := range db.SSTableIDs {
+		size := filesSize(db.fs, []string{sstableID})
+		db.readers.evict(sstableID)
+		removed, rewritten, err := rewriteWithoutKey(db.fs, sstableID, key)
+		if err != nil {
+			// The emptied tables are still there, and the MANIFEST still lists them
+			db.recordEvent(event, err)
+			return report, err
+		}
+		if removed || rewritten {
+			event.Inputs = append(event.Inputs, sstableID)
+			event.InputBytes += size
+		}
+		if removed {
+			report.FilesRemoved = append(report.FilesRemoved, sstableID)
+			continue
+		}
+		if rewritten {
+			report.FilesRewritten = append(report.FilesRewritten, sstableID)
+			event.Outputs = append(event.Outputs, sstableID)
+		}
+		kept = append(kept, sstableID)
+	}
+	db.SSTableIDs = kept
+	db.sequence++
+	db.recordEvent(event, nil)
+	// The emptied tables are deleted once the MANIFEST no longer lists them
+	if err := db.logTables(); err != nil {
+		return report, err
+	}
+	for _, sstableID := range report.FilesRemoved {
+		if err := db.fs.Remove(sstableID); err != nil {
+			return report, err
+		}
+	}
+	// The segments of the full-text index may hold the words of the value too
+	if err := db.search.compactSegments(db.fs, db.data); err != nil {
+		return report, err
+	}
+
+	if err := db.syncRemote(); err != nil {
+		return report, err
+	}
+
+	// 4 - Verify that no copy is left
+	for _, sstableID := range db.SSTableIDs {
+		sst, err := db.readSSTable(sstableID)
+		if err != nil {
+			return report, err
+		}
+		for _, kv := range sst.KeyValues {
+			if string(kv.Key) == key && kv.Operation != sstable.OpDelRange {
+				return report, ErrPurgeIncomplete
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// rewriteWithoutKey rewrites an SSTable without any record of key.
+// If nothing else is left in it, the file is left to the caller to remove and removed is true. Its modification
+// time is kept, as it determines the order of the SSTables named by time in a directory without a MANIFEST.
+func rewriteWithoutKey(fsys vfs.FS, sstableID string, key string) (removed bool, rewritten bool, err error) {
+	sst, err := sstable.ReadSSTableFS(fsys, sstableID)
+	if err != nil {
+		return false, false, err
+	}
+	keyValues := make([]sstable.KeyValuePair, 0, len(sst.KeyValues))
+	for _, kv := range sst.KeyValues {
+		// A range deletion starting at key deletes other keys too
+		if string(kv.Key) != key || kv.Operation == sstable.OpDelRange {
+			keyValues = append(keyValues, kv)
+		}
+	}
+	if len(keyValues) == len(sst.KeyValues) {
+		return false, false, nil
+	}
+	if len(keyValues) == 0 {
+		return true, false, nil
+	}
+
+	fileInfo, err := fsys.Stat(sstableID)
+	if err != nil {
+		return false, false, err
+	}
+	tmp := sstableID + ".tmp"
+	if err := fsys.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return false, false, err
+	}
+	// The rewritten table keeps the sequences of its entries, the time it was created at and its checksum algorithm
+	table := sstable.NewSSTable(keyValues)
+	if props := sst.Header.Properties; props != nil {
+		table.Header.Properties.MinSequence, table.Header.Properties.MaxSequence = props.MinSequence, props.MaxSequence
+		table.Header.Properties.Created = props.Created
+	}
+	table.SetChecksum(sst.Header.ChecksumType)
+	if err := sstable.WriteSSTableFS(fsys, tmp, table); err != nil {
+		return false, false, err
+	}
+	if err := fsys.Chtimes(tmp, fileInfo.ModTime(), fileInfo.ModTime()); err != nil {
+		return false, false, err
+	}
+	if err := vfs.ReplaceFile(fsys, tmp, sstableID); err != nil {
+		return false, false, err
+	}
+	return false, true, nil
+}
@@ -0,0 +1,367 @@
+package memdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// QueryError is returned by ParseFilter for an expression it can't parse
+type QueryError struct {
+	Offset int // Byte offset in the expression where parsing failed
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid query at offset %d: %s", e.Offset, e.Reason)
+}
+
+// ParseFilter compiles a filter expression over JSON documents into a ValueFilter, e.g.
+//
+//	city == "azilal" and age >= 30 and not exists(deleted_at)
+//	prefix(name, "im") or tags[0] != "guest"
+//
+// Paths use the syntax of GetPath. Comparisons take a JSON literal: ==, != compare any value, <, <=, >, >= compare
+// two numbers or two strings. exists(path) matches documents having the path, prefix(path, "s") those whose element
+// at path is a string starting with s. Predicates combine with and, or, not and parentheses, and binds tighter
+// than or. A comparison on a missing element, or of values of different types, is false. Values that aren't
+// JSON documents never match.
+func ParseFilter(expr string) (ValueFilter, error) {
+	p := &queryParser{input: expr}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return FilterFunc(func(value []byte) bool {
+		var doc interface{}
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return false
+		}
+		return node(doc)
+	}), nil
+}
+
+// queryNode evaluates a compiled expression on a decoded document
+type queryNode func(doc interface{}) bool
+
+type tokenKind int
+
+const (
+	tokEOF     tokenKind = iota
+	tokIdent             // A path or a keyword
+	tokLiteral           // A JSON string or number
+	tokOp                // A comparison operator
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+// queryParser is a recursive descent parser reading one token ahead
+type queryParser struct {
+	input string
+	pos   int
+	tok   token
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return &QueryError{Offset: p.tok.offset, Reason: fmt.Sprintf(format, args...)}
+}
+
+// next reads the following token into p.tok
+func (p *queryParser) next() error {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	p.tok = token{offset: start}
+	if p.pos == len(p.input) {
+		p.tok.kind = tokEOF
+		return nil
+	}
+
+	c := p.input[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		p.tok.kind = tokLParen
+	case c == ')':
+		p.pos++
+		p.tok.kind = tokRParen
+	case c == ',':
+		p.pos++
+		p.tok.kind = tokComma
+	case strings.ContainsRune("=!<>", rune(c)):
+		p.pos++
+		if p.pos < len(p.input) && p.input[p.pos] == '=' {
+			p.pos++
+		}
+		p.tok.kind = tokOp
+		switch op := p.input[start:p.pos]; op {
+		case "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return &QueryError{Offset: start, Reason: fmt.Sprintf("unknown operator %q", op)}
+		}
+	case c == '"':
+		// JSON string, up to the first unescaped quote
+		p.pos++
+		for p.pos < len(p.input) && p.input[p.pos] != '"' {
+			if p.input[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.input) {
+			return &QueryError{Offset: start, Reason: "unterminated string"}
+		}
+		p.pos++
+		p.tok.kind = tokLiteral
+	case c == '-' || (c >= '0' && c <= '9'):
+		for p.pos < len(p.input) && strings.ContainsRune("+-.eE0123456789", rune(p.input[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind = tokLiteral
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.input) && isPathByte(p.input[p.pos]) {
+			p.pos++
+		}
+		p.tok.kind = tokIdent
+	default:
+		return &QueryError{Offset: start, Reason: fmt.Sprintf("unexpected character %q", c)}
+	}
+	p.tok.text = p.input[start:p.pos]
+	return nil
+}
+
+// isPathByte reports whether c may appear in a path such as a.b[2].c
+func isPathByte(c byte) bool {
+	return c == '_' || c == '.' || c == '[' || c == ']' || c == '-' || c >= 0x80 ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// keyword reports whether the current token is the keyword word
+func (p *queryParser) keyword(word string) bool {
+	return p.tok.kind == tokIdent && p.tok.text == word
+}
+
+// expect consumes a token of the given kind
+func (p *queryParser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.tok
+	if tok.kind != kind {
+		return tok, p.errorf("expected %s", what)
+	}
+	return tok, p.next()
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(doc interface{}) bool { return l(doc) || right(doc) }
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(doc interface{}) bool { return l(doc) && right(doc) }
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	switch {
+	case p.keyword("not"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(doc interface{}) bool { return !node(doc) }, nil
+	case p.tok.kind == tokLParen:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case p.keyword("exists"), p.keyword("prefix"):
+		return p.parseFunction()
+	}
+	return p.parseComparison()
+}
+
+// parsePathToken parses the current token as a path
+func (p *queryParser) parsePathToken() ([]pathSegment, error) {
+	tok, err := p.expect(tokIdent, "a path")
+	if err != nil {
+		return nil, err
+	}
+	segments, err := parsePath(tok.text)
+	if err != nil {
+		return nil, &QueryError{Offset: tok.offset, Reason: fmt.Sprintf("invalid path %q", tok.text)}
+	}
+	return segments, nil
+}
+
+// parseLiteral parses the current token as a JSON literal: a string, a number, true, false or null
+func (p *queryParser) parseLiteral() (interface{}, error) {
+	tok := p.tok
+	if tok.kind != tokLiteral && !p.keyword("true") && !p.keyword("false") && !p.keyword("null") {
+		return nil, p.errorf("expected a value")
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(tok.text), &value); err != nil {
+		return nil, p.errorf("invalid value %s", tok.text)
+	}
+	return value, p.next()
+}
+
+// parseFunction parses exists(path) and prefix(path, "s")
+func (p *queryParser) parseFunction() (queryNode, error) {
+	name := p.tok.text
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	segments, err := p.parsePathToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var node queryNode
+	if name == "exists" {
+		node = func(doc interface{}) bool {
+			_, err := lookupPath(doc, segments)
+			return err == nil
+		}
+	} else {
+		if _, err := p.expect(tokComma, "','"); err != nil {
+			return nil, err
+		}
+		offset := p.tok.offset
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		prefix, ok := value.(string)
+		if !ok {
+			return nil, &QueryError{Offset: offset, Reason: "prefix expects a string"}
+		}
+		node = func(doc interface{}) bool {
+			elem, err := lookupPath(doc, segments)
+			s, ok := elem.(string)
+			return err == nil && ok && strings.HasPrefix(s, prefix)
+		}
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// parseComparison parses path op value
+func (p *queryParser) parseComparison() (queryNode, error) {
+	segments, err := p.parsePathToken()
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.expect(tokOp, "a comparison operator")
+	if err != nil {
+		return nil, err
+	}
+	want, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return func(doc interface{}) bool {
+		elem, err := lookupPath(doc, segments)
+		if err != nil {
+			return false
+		}
+		if op.text == "==" || op.text == "!=" {
+			return reflect.DeepEqual(elem, want) == (op.text == "==")
+		}
+		cmp, ok := compareJSON(elem, want)
+		if !ok {
+			return false
+		}
+		switch op.text {
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		}
+		return cmp >= 0
+	}, nil
+}
+
+// compareJSON orders two numbers or two strings, ok is false for other values
+func compareJSON(a interface{}, b interface{}) (cmp int, ok bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		switch {
+		case !ok:
+			return 0, false
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
@@ -0,0 +1,179 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is returned when a write would take the database over its quota
+var ErrQuotaExceeded = errors.New("Quota exceeded")
+
+// QuotaLimits holds the maximum number of live keys and bytes (keys plus values) of a database.
+// A limit of zero means no limit.
+type QuotaLimits struct {
+	MaxKeys  int64 `json:"max_keys"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// QuotaStats reports the quota of the database and its current usage
+type QuotaStats struct {
+	QuotaLimits
+	Keys     int64  `json:"keys"`
+	Bytes    int64  `json:"bytes"`
+	Rejected uint64 `json:"rejected"` // Writes refused because of the quota
+}
+
+// usage is an amount of live keys and bytes, or a change of it
+type usage struct {
+	keys  int64
+	bytes int64
+}
+
+// quota tracks the usage of the database against its limits.
+// It is only modified while holding the database write lock.
+type quota struct {
+	limits   QuotaLimits
+	used     usage
+	rejected uint64
+}
+
+func newQuota(limits QuotaLimits, used usage) *quota {
+	return &quota{limits: limits, used: used}
+}
+
+// Quota limits the number of live keys and bytes stored in the database, writes going over
+// the limits fail with ErrQuotaExceeded. Deletes and writes that shrink the usage are always accepted.
+func Quota(maxKeys int64, maxBytes int64) Option {
+	return func(db *DB) {
+		if maxKeys > 0 || maxBytes > 0 {
+			db.quotaLimits = &QuotaLimits{MaxKeys: maxKeys, MaxBytes: maxBytes}
+		}
+	}
+}
+
+// check returns ErrQuotaExceeded if delta would grow the usage over a limit
+func (q *quota) check(delta usage) error {
+	if q.limits.MaxKeys > 0 && delta.keys > 0 && q.used.keys+delta.keys > q.limits.MaxKeys {
+		atomic.AddUint64(&q.rejected, 1)
+		return ErrQuotaExceeded
+	}
+	if q.limits.MaxBytes > 0 && delta.bytes > 0 && q.used.bytes+delta.bytes > q.limits.MaxBytes {
+		atomic.AddUint64(&q.rejected, 1)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// apply records a change of usage
+func (q *quota) apply(delta usage) {
+	if q == nil {
+		return
+	}
+	q.used.keys += delta.keys
+	q.used.bytes += delta.bytes
+}
+
+// stats returns the quota statistics
+func (q *quota) stats() *QuotaStats {
+	return &QuotaStats{
+		QuotaLimits: q.limits,
+		Keys:        q.used.keys,
+		Bytes:       q.used.bytes,
+		Rejected:    atomic.LoadUint64(&q.rejected),
+	}
+}
+
+// quotaDelta returns the change of usage caused by setting key to value,
+// or ErrQuotaExceeded if it doesn't fit in the quota
+func (db *DB) quotaDelta(key string, value []byte) (usage, error) {
+	delta, err := db.writeDelta(key, sstable.Pair{Value: value})
+	if err != nil {
+		return usage{}, err
+	}
+	if err := db.quota.check(delta); err != nil {
+		return usage{}, err
+	}
+	return delta, nil
+}
+
+// writeDelta returns the change of usage caused by writing pair for key, a deletion if it is a marker, without
+// checking it against the quota
+func (db *DB) writeDelta(key string, pair sstable.Pair) (usage, error) {
+	var delta usage
+	if !pair.Marker {
+		delta = usage{keys: 1, bytes: int64(len(key) + len(pair.Value))}
+	}
+
+	// Look for the current value of the key, in memory first then in the SSTables
+	current, ok := db.data[key]
+	if ok && !current.Marker {
+		delta.keys--
+		delta.bytes -= int64(len(key) + len(current.Value))
+	} else if !ok {
+		old, err := db.GetValueFromSSTables(key)
+		if err == nil {
+			delta.keys--
+			delta.bytes -= int64(len(key) + len(old))
+		} else if err != ErrKeyNotFound {
+			return usage{}, err
+		}
+	}
+	return delta, nil
+}
+
+// computeUsage counts the live keys and bytes of the database by replaying
+// the SSTables from the oldest to the newest, then the memtable
+func (db *DB) computeUsage() (usage, error) {
+	sizes := make(map[string]int)
+	for _, sstableID := range db.SSTableIDs {
+		sst, err := db.readSSTable(sstableID)
+		if err != nil {
+			return usage{}, err
+		}
+		// Deletions flushed by older versions may also carry a set entry for the same key in the same table, the
+		// deletion prevails then. The range deletions of the table delete the keys of the older ones.
+		deleteCovered(sizes, sstable.RangeDeletionsOf(sst.KeyValues))
+		deleted := make(map[string]bool)
+		for _, kv := range sst.KeyValues {
+			if kv.Operation == sstable.OpDel {
+				deleted[string(kv.Key)] = true
+			}
+		}
+		for _, kv := range sst.KeyValues {
+			if kv.Operation == sstable.OpDelRange {
+				continue
+			}
+			if deleted[string(kv.Key)] {
+				delete(sizes, string(kv.Key))
+			} else {
+				sizes[string(kv.Key)] = len(kv.Key) + len(kv.Value)
+			}
+		}
+	}
+	deleteCovered(sizes, db.ranges)
+	for key, pair := range db.data {
+		if pair.Marker {
+			delete(sizes, key)
+		} else {
+			sizes[key] = len(key) + len(pair.Value)
+		}
+	}
+
+	var used usage
+	for _, size := range sizes {
+		used.keys++
+		used.bytes += int64(size)
+	}
+	return used, nil
+}
+
+// QuotaStats returns the quota usage of the database, or nil if no quota is set
+func (db *DB) QuotaStats() *QuotaStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.quota == nil {
+		return nil
+	}
+	return db.quota.stats()
+}
@@ -0,0 +1,134 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrRangeTimeSeries is returned by DeleteRange and DeletePrefix in time-series mode, whose data is deleted window
+// by window by the retention instead
+var ErrRangeTimeSeries = errors.New("Range deletions are not supported in time-series mode")
+
+// DeleteRange deletes the keys from start up to end, excluded, or every key from start if end is empty, with a
+// single range deletion written to the WAL and then to an SSTable instead of a deletion per key: its cost doesn't
+// grow with the keys it deletes from the SSTables, which lookups, scans and iterators leave out until compactions
+// drop them. The keys of the memtable in the range are marked deleted. Bounds that select no key delete nothing.
+// When a quota is set, the keys in the range are still read to keep the usage accurate.
+func (db *DB) DeleteRange(start string, end string) (err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordDeleteRange, Start: start, End: end}, time.Now(), &err)
+	}
+	if start, err = db.ValidateKey(start); err != nil {
+		return err
+	}
+	if end != "" {
+		if end, err = db.ValidateKey(end); err != nil {
+			return err
+		}
+		if end <= start {
+			return nil
+		}
+	}
+	if err := db.deleteRange(start, end); err != nil {
+		return err
+	}
+	return db.syncWAL(WriteOptions{})
+}
+
+// DeletePrefix deletes every key starting with prefix, with a single range deletion like DeleteRange
+func (db *DB) DeletePrefix(prefix string) (err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordDeleteRange, Prefix: prefix}, time.Now(), &err)
+	}
+	if prefix, err = db.ValidateKey(prefix); err != nil {
+		return err
+	}
+	if err := db.deleteRange(prefix, prefixEnd(prefix)); err != nil {
+		return err
+	}
+	return db.syncWAL(WriteOptions{})
+}
+
+// prefixEnd returns the smallest key after every key starting with prefix, empty if there is none
+func prefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
+
+// deleteRange implements DeleteRange for validated bounds
+func (db *DB) deleteRange(start string, end string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+	return db.writeRange(start, end)
+}
+
+// writeRange logs a range deletion to the WAL, then applies it to the memtable, the caller must hold the write lock
+func (db *DB) writeRange(start string, end string) error {
+	if db.timeSeries != nil {
+		return ErrRangeTimeSeries
+	}
+	if err := db.checkDisk(); err != nil {
+		return err
+	}
+	var delta usage
+	if db.quota != nil {
+		kvs, err := db.scan(ScanOptions{Start: start, End: end, manifests: true})
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvs {
+			delta.keys--
+			delta.bytes -= int64(len(kv.Key) + len(kv.Value))
+		}
+	}
+
+	r := sstable.RangeDeletion{Start: []byte(start), End: []byte(end)}
+	if err := db.wal.WriteEntry(WALRecord{Operation: OpDelRange, Key: r.Start, Value: r.End}); err != nil {
+		return err
+	}
+	db.applyRange(r)
+	db.quota.apply(delta)
+
+	// Range deletions count toward the threshold of the memtable, every lookup checking them
+	if len(db.keys)+len(db.ranges) >= db.threshold {
+		return db.flushLocked()
+	}
+	return nil
+}
+
+// applyRange applies a range deletion to the memtable, without writing it to the WAL: the keys of the memtable in
+// the range are marked deleted, so that its entries are all newer than its range deletions, and the range is kept
+// for the keys of the SSTables until the next flush writes it
+func (db *DB) applyRange(r sstable.RangeDeletion) {
+	for i := sort.SearchStrings(db.keys, string(r.Start)); i < len(db.keys) && r.Covers([]byte(db.keys[i])); i++ {
+		if key := db.keys[i]; !db.data[key].Marker {
+			db.putMemtable(key, sstable.Pair{Value: nil, Marker: true})
+		}
+	}
+	db.ranges = append(db.ranges, r)
+	db.sequence++
+	db.values.clear()
+	db.indexes.removeRange(r)
+	db.search.removeRange(r)
+}
+
+// deleteCovered deletes from m the keys covered by ranges, when merging the entries of tables
+func deleteCovered[V any](m map[string]V, ranges sstable.RangeDeletions) {
+	if len(ranges) == 0 {
+		return
+	}
+	for key := range m {
+		if ranges.Covers([]byte(key)) {
+			delete(m, key)
+		}
+	}
+}
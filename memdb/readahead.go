@@ -0,0 +1,57 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"sync"
+)
+
+// ReadAhead makes scans and key listings open the SSTables that aren't open yet in the background, up to size bytes
+// of tables at once and at least one table, so that a scan over many tables isn't bound by the latency of opening
+// each. 0, the default, opens the tables one after another.
+func ReadAhead(size int64) Option {
+	return func(db *DB) {
+		db.readAhead = size
+	}
+}
+
+// tableIterators returns iterators over the SSTables of sstableIDs, in order, reading their values as they get to
+// them, after opening their readers ahead as set by the ReadAhead option. The caller must hold the lock.
+func (db *DB) tableIterators(sstableIDs []string) ([]sstable.Iterator, error) {
+	if db.readAhead > 0 {
+		db.openAhead(sstableIDs)
+	}
+	inputs := make([]sstable.Iterator, 0, len(sstableIDs)+1) // And the memtable
+	for _, sstableID := range sstableIDs {
+		reader, err := db.readers.get(sstableID)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, reader.NewIterator())
+	}
+	return inputs, nil
+}
+
+// openAhead opens the readers of the SSTables of sstableIDs that aren't open yet, in parallel batches of up to
+// ReadAhead bytes of tables and at least one table. A table that can't be opened is left to tableIterators, which
+// reports the error.
+func (db *DB) openAhead(sstableIDs []string) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	batch, tables := int64(0), 0 // Bytes and tables being opened
+	for _, sstableID := range sstableIDs {
+		if db.readers.peek(sstableID) != nil {
+			continue
+		}
+		size := filesSize(db.fs, []string{sstableID}) // 0 for a table only kept in the object store
+		if tables > 0 && batch+size > db.readAhead {
+			wg.Wait()
+			batch, tables = 0, 0
+		}
+		batch, tables = batch+size, tables+1
+		wg.Add(1)
+		go func(sstableID string) {
+			defer wg.Done()
+			db.readers.get(sstableID)
+		}(sstableID)
+	}
+}
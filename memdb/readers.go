@@ -0,0 +1,137 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"os"
+	"sync"
+)
+
+// readerCache keeps the SSTables open for lookups. The tables written by the database are opened right away,
+// the others, e.g. found when the database is opened, on their first lookup or by the warmup.
+// Readers must be evicted before their file is rewritten or removed, under the database write lock. A reader pinned
+// by a snapshot is only closed once the snapshot releases it: its open file keeps the data of the table readable
+// after the file is removed or replaced.
+type readerCache struct {
+	mu      sync.Mutex
+	readers map[string]*sstable.Reader
+	pins    map[*sstable.Reader]int  // Snapshots holding each pinned reader
+	evicted map[*sstable.Reader]bool // Pinned readers evicted since, closed once unpinned
+	fs      vfs.FS                   // File system of the SSTables
+	remote  *remoteStorage           // Where the SSTables missing from the directory are read from, nil if disabled
+}
+
+func newReaderCache(fsys vfs.FS) *readerCache {
+	return &readerCache{
+		readers: make(map[string]*sstable.Reader),
+		pins:    make(map[*sstable.Reader]int),
+		evicted: make(map[*sstable.Reader]bool),
+		fs:      fsys,
+	}
+}
+
+// get returns the reader of an SSTable, opening it if needed. Tables are opened without holding c.mu, so that
+// lookups probing several tables at once open them in parallel.
+func (c *readerCache) get(sstableID string) (*sstable.Reader, error) {
+	if reader := c.peek(sstableID); reader != nil {
+		return reader, nil
+	}
+	reader, err := sstable.OpenReaderFS(c.fs, sstableID)
+	if os.IsNotExist(err) && c.remote != nil {
+		reader, err = c.remote.reader(c.fs, sstableID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another lookup may have opened it in the meantime
+	if cached, ok := c.readers[sstableID]; ok {
+		reader.Close()
+		return cached, nil
+	}
+	c.readers[sstableID] = reader
+	return reader, nil
+}
+
+// peek returns the reader of an SSTable if it is open, nil otherwise
+func (c *readerCache) peek(sstableID string) *sstable.Reader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readers[sstableID]
+}
+
+// open opens the readers of SSTables just written by a flush, a compaction or an ingestion, while their file
+// is still in the page cache, so that lookups never have to read a whole table. A table that can't be opened
+// is left to its first lookup, which reports the error.
+func (c *readerCache) open(sstableIDs []string) {
+	for _, sstableID := range sstableIDs {
+		c.get(sstableID)
+	}
+}
+
+// add caches readers opened by the caller, closing those of SSTables that already have one
+func (c *readerCache) add(readers map[string]*sstable.Reader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for sstableID, reader := range readers {
+		if _, ok := c.readers[sstableID]; ok {
+			reader.Close()
+			continue
+		}
+		c.readers[sstableID] = reader
+	}
+}
+
+// evict closes the reader of an SSTable, if it is open, or leaves it to the last snapshot pinning it
+func (c *readerCache) evict(sstableID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if reader, ok := c.readers[sstableID]; ok {
+		if c.pins[reader] > 0 {
+			c.evicted[reader] = true
+		} else {
+			reader.Close()
+		}
+		delete(c.readers, sstableID)
+	}
+}
+
+// pin returns the reader of an SSTable, opening it if needed, and keeps it open until unpin is called as many times,
+// even if it is evicted meanwhile. The caller must hold the database lock, so that it isn't evicted before it is
+// pinned.
+func (c *readerCache) pin(sstableID string) (*sstable.Reader, error) {
+	reader, err := c.get(sstableID)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pins[reader]++
+	return reader, nil
+}
+
+// unpin releases a reader returned by pin, closing it if it was evicted and no other snapshot pins it
+func (c *readerCache) unpin(reader *sstable.Reader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pins[reader]--; c.pins[reader] > 0 {
+		return
+	}
+	delete(c.pins, reader)
+	if c.evicted[reader] {
+		reader.Close()
+		delete(c.evicted, reader)
+	}
+}
+
+// closeAll closes every reader
+func (c *readerCache) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for sstableID, reader := range c.readers {
+		reader.Close()
+		delete(c.readers, sstableID)
+	}
+}
@@ -0,0 +1,233 @@
+package memdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Operations of a recording
+const (
+	RecordSet             = "set"
+	RecordGet             = "get"
+	RecordGetMulti        = "getmulti"
+	RecordDelete          = "del"
+	RecordDeleteReturning = "delreturning"
+	RecordDeleteRange     = "delrange"
+	RecordPurge           = "purge"
+	RecordSetPath         = "setpath"
+	RecordCompareAndSwap  = "cas"
+	RecordListKeys        = "keys"
+	RecordScan            = "scan"
+	RecordIngest          = "ingest"
+	RecordCommit          = "commit"
+)
+
+// RecordedOp is an operation of a recording, written as a line of JSON
+type RecordedOp struct {
+	Offset   time.Duration `json:"offset"`   // Time the operation started at, since the recording started
+	Duration time.Duration `json:"duration"` // Time the operation took
+	Op       string        `json:"op"`
+	Key      string        `json:"key,omitempty"`
+	Value    []byte        `json:"value,omitempty"`  // Base64 encoded in JSON
+	Path     string        `json:"path,omitempty"`   // JSON path of a SetPath
+	Prefix   string        `json:"prefix,omitempty"` // Prefix of a ListKeysPrefix, a scan or a DeletePrefix
+	Start    string        `json:"start,omitempty"`  // Bounds of a scan or a DeleteRange
+	End      string        `json:"end,omitempty"`
+	Limit    int           `json:"limit,omitempty"`
+	Filtered bool          `json:"filtered,omitempty"` // Whether the scan had a value filter, which isn't recorded
+	Paged    bool          `json:"paged,omitempty"`    // Whether the scan or the listing was a ScanPage or a ListKeysPage
+	Cursor   string        `json:"cursor,omitempty"`   // Cursor of the page
+	KVs      []KeyValue    `json:"kvs,omitempty"`      // Pairs of an ingestion, or set by a transaction
+	Keys     []string      `json:"keys,omitempty"`     // Keys of a GetMulti, or deleted by a transaction
+	Expected []byte        `json:"expected,omitempty"` // Expected value of a CompareAndSwap
+	Absent   bool          `json:"absent,omitempty"`   // Whether the CompareAndSwap expected the key to be missing
+	Error    string        `json:"error,omitempty"`    // Error returned by the operation
+}
+
+// recorder writes the operations of a database to a recording
+type recorder struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	start   time.Time
+	onError func(error)
+	failed  bool // Whether a write failed, which stops the recording
+}
+
+// Record captures the operations on the keys of the database to w, as lines of JSON with their timing, to replay
+// them with Replay against another database: to reproduce a bug on a copy of the data it was reported on, or to
+// compare the performance of two versions of the engine on a real workload. The operations recorded are the calls
+// to Set, Get, GetMulti, Delete, DeleteReturning, DeleteRange, DeletePrefix, Purge, SetPath, CompareAndSwap,
+// ListKeysPrefix, Scan, ScanPage, ListKeysPage, Ingest and Tx.Commit, with the values they write: the recording
+// holds the data of the database. The methods built on them are recorded as the calls they make, e.g. GetPath as a
+// Get and the reads of a transaction as Gets. Flushes and compactions aren't recorded, they follow from the writes
+// and the options of the database replayed on.
+// Operations are written in the order they complete, each with one write to w. A failed write is passed to onError,
+// if not nil, and stops the recording.
+func Record(w io.Writer, onError func(error)) Option {
+	return func(db *DB) {
+		if w != nil {
+			db.recorder = &recorder{enc: json.NewEncoder(w), start: time.Now(), onError: onError}
+		}
+	}
+}
+
+// record writes op, which started at start and returned *err
+func (r *recorder) record(op RecordedOp, start time.Time, err *error) {
+	op.Duration = time.Since(start)
+	op.Offset = start.Sub(r.start)
+	if *err != nil {
+		op.Error = (*err).Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return
+	}
+	if err := r.enc.Encode(op); err != nil {
+		r.failed = true
+		if r.onError != nil {
+			r.onError(err)
+		}
+	}
+}
+
+// ReplayOptions configures Replay
+type ReplayOptions struct {
+	Speed float64 // Pace of the replay: 1 waits for the recorded offset of each operation, 2 replays twice as fast, 0 as fast as possible
+}
+
+// ReplayStats sums the operations of a kind in a replay
+type ReplayStats struct {
+	Count    int           `json:"count"`
+	Duration time.Duration `json:"duration"` // Time the operations took in the replay
+	Recorded time.Duration `json:"recorded"` // Time they took when they were recorded
+}
+
+// ReplayReport describes a replay
+type ReplayReport struct {
+	Ops         int                    `json:"ops"`
+	Duration    time.Duration          `json:"duration"` // Time the replay took
+	ByOp        map[string]ReplayStats `json:"by_op"`
+	Diverged    int                    `json:"diverged"`              // Operations whose error differs from the recorded one
+	Divergences []string               `json:"divergences,omitempty"` // The first maxDivergences of them
+}
+
+// maxDivergences is the number of divergences described by a replay report
+const maxDivergences = 20
+
+// ErrUnknownRecordedOp is returned by Replay for an operation it doesn't know
+var ErrUnknownRecordedOp = errors.New("Unknown recorded operation")
+
+// Replay executes the operations of a recording written by Record against db, one after the other, and reports
+// how long each kind of operation took compared with the recording, and which operations returned another error
+// than when they were recorded, e.g. a key found in the replay but not in the recording: on a database holding what
+// the recorded one did when the recording started, usually an empty one, a divergence points at a behaviour change.
+// Replay stops at the first line it can't decode or execute.
+func Replay(db *DB, r io.Reader, opts ReplayOptions) (report ReplayReport, err error) {
+	report.ByOp = make(map[string]ReplayStats)
+	start := time.Now()
+	defer func() { report.Duration = time.Since(start) }()
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		var op RecordedOp
+		if err := dec.Decode(&op); err == io.EOF {
+			return report, nil
+		} else if err != nil {
+			return report, fmt.Errorf("Recording line %d: %w", line, err)
+		}
+		if opts.Speed > 0 {
+			time.Sleep(time.Duration(float64(op.Offset)/opts.Speed) - time.Since(start))
+		}
+
+		opStart := time.Now()
+		known, err := replayOp(db, op)
+		if !known {
+			return report, fmt.Errorf("Recording line %d: %w %q", line, ErrUnknownRecordedOp, op.Op)
+		}
+		stats := report.ByOp[op.Op]
+		stats.Count++
+		stats.Duration += time.Since(opStart)
+		stats.Recorded += op.Duration
+		report.ByOp[op.Op] = stats
+		report.Ops++
+
+		var got string
+		if err != nil {
+			got = err.Error()
+		}
+		if got != op.Error {
+			report.Diverged++
+			if len(report.Divergences) < maxDivergences {
+				report.Divergences = append(report.Divergences,
+					fmt.Sprintf("Line %d, %s %q: recorded error %q, got %q", line, op.Op, op.Key, op.Error, got))
+			}
+		}
+	}
+}
+
+// replayOp executes a recorded operation, returning false if it is unknown, and its error
+func replayOp(db *DB, op RecordedOp) (bool, error) {
+	var err error
+	switch op.Op {
+	case RecordSet:
+		err = db.Set(op.Key, op.Value)
+	case RecordGet:
+		_, err = db.Get(op.Key)
+	case RecordGetMulti:
+		_, err = db.GetMulti(op.Keys)
+	case RecordDelete:
+		err = db.Delete(op.Key)
+	case RecordDeleteReturning:
+		_, err = db.DeleteReturning(op.Key)
+	case RecordDeleteRange:
+		if op.Prefix != "" {
+			err = db.DeletePrefix(op.Prefix)
+		} else {
+			err = db.DeleteRange(op.Start, op.End)
+		}
+	case RecordPurge:
+		_, err = db.Purge(op.Key)
+	case RecordSetPath:
+		err = db.SetPath(op.Key, op.Path, op.Value)
+	case RecordCompareAndSwap:
+		expected := op.Expected
+		if expected == nil && !op.Absent {
+			expected = []byte{}
+		}
+		err = db.CompareAndSwap(op.Key, expected, op.Value)
+	case RecordListKeys:
+		if op.Paged {
+			_, err = db.ListKeysPage(ScanOptions{Prefix: op.Prefix, Start: op.Start, End: op.End, Limit: op.Limit}, op.Cursor)
+		} else {
+			_, err = db.ListKeysPrefix(op.Prefix)
+		}
+	case RecordScan:
+		opts := ScanOptions{Prefix: op.Prefix, Start: op.Start, End: op.End, Limit: op.Limit}
+		if op.Paged {
+			_, err = db.ScanPage(opts, op.Cursor)
+		} else {
+			_, err = db.Scan(opts)
+		}
+	case RecordIngest:
+		err = db.Ingest(op.KVs)
+	case RecordCommit:
+		tx := db.BeginTx()
+		for _, kv := range op.KVs {
+			tx.Set(kv.Key, kv.Value)
+		}
+		for _, key := range op.Keys {
+			tx.Delete(key)
+		}
+		err = tx.Commit()
+	default:
+		return false, nil
+	}
+	return true, err
+}
@@ -0,0 +1,231 @@
+package memdb
+
+import (
+	"StorageEngine/objstore"
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TableListObject is the object listing the SSTables of a database in an object store, oldest first, one name per line.
+// The order of the tables matters to lookups and isn't kept by object stores, which have no modification times to sort by.
+const TableListObject = "SSTABLES"
+
+// ObjectStorage keeps a copy of every SSTable in store, so that the database survives the loss of its node: flushes,
+// compactions and ingestions upload the tables they write and delete the ones they replace, and a database opened on
+// an empty or outdated SSTable directory uses the tables of the store. Tables missing from the local directory are
+// never downloaded as a whole: lookups read them through a cache of cacheSize bytes of recently read blocks
+// (objstore.DefaultCacheSize if 0), scans read them from the store, and compactions fetch their inputs first.
+// A failed upload fails the write that triggered it, and is retried by the next one.
+func ObjectStorage(store objstore.Store, cacheSize int64) Option {
+	return func(db *DB) {
+		db.remote = &remoteStorage{
+			store:    store,
+			cache:    objstore.NewBlockCache(objstore.DefaultBlockSize, cacheSize),
+			uploaded: make(map[string]fileStamp),
+		}
+		db.readers.remote = db.remote
+	}
+}
+
+// remoteStorage tracks the SSTables of the database stored in the object store
+type remoteStorage struct {
+	store    objstore.Store
+	cache    *objstore.BlockCache
+	uploaded map[string]fileStamp // Tables in the store, by object name, with the local file they were uploaded from
+}
+
+// fileStamp identifies a version of a local file, the zero value stands for a table that is only in the store
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func stampOf(fileInfo os.FileInfo) fileStamp {
+	return fileStamp{size: fileInfo.Size(), modTime: fileInfo.ModTime()}
+}
+
+// objectName returns the name of an SSTable in the object store
+func objectName(sstableID string) string {
+	return filepath.Base(sstableID)
+}
+
+// reader returns a reader of an SSTable of the store going through the block cache, for lookups, decrypting it
+// with the key of the tables of fsys
+func (r *remoteStorage) reader(fsys vfs.FS, sstableID string) (*sstable.Reader, error) {
+	enc, err := sstable.EncryptionOf(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return sstable.NewReaderWith(r.cache.ReaderAt(r.store, objectName(sstableID)), enc)
+}
+
+// openRemote adds the tables of the object store to the tables found in the SSTable directory: the listed tables
+// come first in the order of the list, then the local tables the store doesn't have yet, left to the next upload
+func (db *DB) openRemote() error {
+	listed, err := db.readTableList()
+	if err != nil {
+		return err
+	}
+	local := make(map[string]bool, len(db.SSTableIDs))
+	for _, sstableID := range db.SSTableIDs {
+		local[objectName(sstableID)] = true
+	}
+
+	tables := make([]string, 0, len(listed)+len(db.SSTableIDs))
+	for _, name := range listed {
+		sstableID := filepath.Join(db.sstableDir, name)
+		tables = append(tables, sstableID)
+		if !local[name] {
+			db.remote.uploaded[name] = fileStamp{}
+			continue
+		}
+		// A local copy that differs from the object was rewritten and not uploaded since
+		fileInfo, err := os.Stat(sstableID)
+		if os.IsNotExist(err) {
+			// Listed in the MANIFEST, but moved to the object store
+			db.remote.uploaded[name] = fileStamp{}
+			delete(local, name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if size, err := db.remote.store.Size(name); err == nil && size == fileInfo.Size() {
+			db.remote.uploaded[name] = stampOf(fileInfo)
+		} else if err != nil && err != objstore.ErrNotExist {
+			return err
+		}
+		delete(local, name)
+	}
+	for _, sstableID := range db.SSTableIDs {
+		if local[objectName(sstableID)] {
+			tables = append(tables, sstableID)
+		}
+	}
+	db.SSTableIDs = tables
+	return db.logTables()
+}
+
+// readTableList returns the names listed in the table list object, none if there is no list yet
+func (db *DB) readTableList() ([]string, error) {
+	r, err := db.remote.store.Get(TableListObject)
+	if err == objstore.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	names := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// syncRemote brings the object store up to date with the live SSTables, the caller must hold the write lock.
+// New and rewritten tables are uploaded first, then the table list is replaced, and only then are the tables it no
+// longer lists deleted, so the list never names a missing object.
+func (db *DB) syncRemote() error {
+	if db.remote == nil {
+		return nil
+	}
+	live := make(map[string]bool, len(db.SSTableIDs))
+	var list strings.Builder
+	for _, sstableID := range db.SSTableIDs {
+		name := objectName(sstableID)
+		live[name] = true
+		list.WriteString(name + "\n")
+
+		fileInfo, err := os.Stat(sstableID)
+		if os.IsNotExist(err) {
+			continue // Only in the store
+		}
+		if err != nil {
+			return err
+		}
+		if stamp, ok := db.remote.uploaded[name]; ok && stamp == stampOf(fileInfo) {
+			continue
+		}
+		if err := objstore.PutFile(db.remote.store, name, sstableID); err != nil {
+			return err
+		}
+		db.remote.cache.Invalidate(name)
+		db.remote.uploaded[name] = stampOf(fileInfo)
+	}
+
+	if err := db.remote.store.Put(TableListObject, strings.NewReader(list.String()), int64(list.Len())); err != nil {
+		return err
+	}
+
+	for name := range db.remote.uploaded {
+		if live[name] {
+			continue
+		}
+		if err := db.remote.store.Delete(name); err != nil {
+			return err
+		}
+		db.remote.cache.Invalidate(name)
+		delete(db.remote.uploaded, name)
+	}
+	return nil
+}
+
+// readSSTable reads a whole SSTable, from the object store if it isn't in the SSTable directory
+func (db *DB) readSSTable(sstableID string) (*sstable.SSTable, error) {
+	sst, err := db.readSSTableFile(sstableID)
+	if !os.IsNotExist(err) || db.remote == nil {
+		return sst, err
+	}
+	// One request for the whole object rather than one per buffered read
+	r, err := db.remote.store.Get(objectName(sstableID))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := sstable.EncryptionOf(db.fs)
+	if err != nil {
+		return nil, err
+	}
+	return sstable.ReadSSTableAtWith(bytes.NewReader(data), enc)
+}
+
+// fetchRemote downloads the SSTables missing from the SSTable directory, before they are rewritten
+func (db *DB) fetchRemote(sstableIDs []string) error {
+	if db.remote == nil {
+		return nil
+	}
+	for _, sstableID := range sstableIDs {
+		if _, err := os.Stat(sstableID); !os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(db.sstableDir, 0755); err != nil {
+			return err
+		}
+		name := objectName(sstableID)
+		if err := objstore.GetFile(db.remote.store, name, sstableID); err != nil {
+			return err
+		}
+		fileInfo, err := os.Stat(sstableID)
+		if err != nil {
+			return err
+		}
+		db.readers.evict(sstableID)
+		db.remote.uploaded[name] = stampOf(fileInfo)
+	}
+	return nil
+}
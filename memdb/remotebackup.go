@@ -0,0 +1,273 @@
+package memdb
+
+import (
+	"StorageEngine/objstore"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// RemoteSSTablePrefix is where the SSTables of remote backups are stored, shared by all backups
+	RemoteSSTablePrefix = "sstables/"
+	// RemoteBackupPrefix is where each remote backup keeps its manifest and its WAL, under its ID
+	RemoteBackupPrefix = "backups/"
+)
+
+// ErrRemoteBackupNotFound is returned when restoring a remote backup that doesn't exist
+var ErrRemoteBackupNotFound = errors.New("Remote backup not found")
+
+// RemoteBackup describes a backup uploaded to an object store
+type RemoteBackup struct {
+	ID            string         `json:"id"`
+	Manifest      BackupManifest `json:"manifest"`       // File names are object names
+	Uploaded      int            `json:"uploaded"`       // Files uploaded by the backup, the others were already there
+	UploadedBytes int64          `json:"uploaded_bytes"` // Bytes uploaded by the backup
+}
+
+// RetentionPolicy selects the remote backups to keep, a backup is kept if either rule keeps it
+type RetentionPolicy struct {
+	Keep   int           // Most recent backups kept, 0 to only use MaxAge
+	MaxAge time.Duration // Backups younger than this are kept, 0 to only use Keep
+}
+
+// BackupRemote takes a consistent snapshot of the database like Backup and uploads it to store.
+// Uploads are incremental: SSTables are stored once under RemoteSSTablePrefix, named after their checksum,
+// and shared by every backup that includes them, so only the tables written since the previous backup are
+// uploaded. The unflushed tail of the WAL and the manifest go under RemoteBackupPrefix and the ID of the backup,
+// the manifest last, so a backup without one is incomplete and ignored.
+func (db *DB) BackupRemote(store objstore.Store) (RemoteBackup, error) {
+	backup := RemoteBackup{}
+
+	// The snapshot is taken locally first, as hard links next to the SSTable directory
+	tmpDir, err := os.MkdirTemp(filepath.Dir(db.sstableDir), "remote-backup-")
+	if err != nil {
+		return backup, err
+	}
+	defer os.RemoveAll(tmpDir)
+	snapshotDir := filepath.Join(tmpDir, "snapshot")
+	manifest, err := db.Backup(snapshotDir)
+	if err != nil {
+		return backup, err
+	}
+
+	existing, err := store.List(RemoteSSTablePrefix)
+	if err != nil {
+		return backup, err
+	}
+	stored := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		stored[name] = true
+	}
+
+	backup.ID, err = newRemoteBackupID(store, manifest.Time)
+	if err != nil {
+		return backup, err
+	}
+	upload := func(file *BackupFile, name string) error {
+		src := filepath.Join(snapshotDir, filepath.FromSlash(file.Name))
+		file.Name = name
+		if stored[name] {
+			return nil
+		}
+		if err := objstore.PutFile(store, name, src); err != nil {
+			return err
+		}
+		backup.Uploaded++
+		backup.UploadedBytes += file.Size
+		return nil
+	}
+	for i := range manifest.SSTables {
+		file := &manifest.SSTables[i]
+		name := fmt.Sprintf("%s%08x-%s", RemoteSSTablePrefix, file.CRC32, path.Base(file.Name))
+		if err := upload(file, name); err != nil {
+			return backup, err
+		}
+	}
+	if err := upload(&manifest.WAL, RemoteBackupPrefix+backup.ID+"/"+BackupWALName); err != nil {
+		return backup, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return backup, err
+	}
+	if err := store.Put(RemoteBackupPrefix+backup.ID+"/"+BackupManifestName, strings.NewReader(string(data)), int64(len(data))); err != nil {
+		return backup, err
+	}
+	backup.Manifest = manifest
+	return backup, nil
+}
+
+// newRemoteBackupID returns an ID made of the time of the backup, unused in store
+func newRemoteBackupID(store objstore.Store, t time.Time) (string, error) {
+	base := t.UTC().Format("20060102T150405Z")
+	id := base
+	for i := 1; ; i++ {
+		names, err := store.List(RemoteBackupPrefix + id + "/")
+		if err != nil {
+			return "", err
+		}
+		if len(names) == 0 {
+			return id, nil
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// ListRemoteBackups returns the complete backups of store, oldest first
+func ListRemoteBackups(store objstore.Store) ([]RemoteBackup, error) {
+	names, err := store.List(RemoteBackupPrefix)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]RemoteBackup, 0)
+	for _, name := range names {
+		id, file, found := strings.Cut(strings.TrimPrefix(name, RemoteBackupPrefix), "/")
+		if !found || file != BackupManifestName {
+			continue
+		}
+		manifest, err := readRemoteManifest(store, id)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, RemoteBackup{ID: id, Manifest: manifest})
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].Manifest.Time.Before(backups[j].Manifest.Time) })
+	return backups, nil
+}
+
+func readRemoteManifest(store objstore.Store, id string) (BackupManifest, error) {
+	var manifest BackupManifest
+	r, err := store.Get(RemoteBackupPrefix + id + "/" + BackupManifestName)
+	if err == objstore.ErrNotExist {
+		return manifest, ErrRemoteBackupNotFound
+	}
+	if err != nil {
+		return manifest, err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("%s: %w", id, err)
+	}
+	return manifest, nil
+}
+
+// RestoreRemote downloads the remote backup id of store, the most recent one if id is empty, and restores it like
+// Restore into the WAL at walPath and the SSTable directory sstableDir. The files are downloaded next to the
+// SSTable directory and verified against the manifest before anything is installed.
+func RestoreRemote(store objstore.Store, id string, walPath string, sstableDir string) (BackupManifest, error) {
+	if id == "" {
+		backups, err := ListRemoteBackups(store)
+		if err != nil {
+			return BackupManifest{}, err
+		}
+		if len(backups) == 0 {
+			return BackupManifest{}, ErrRemoteBackupNotFound
+		}
+		id = backups[len(backups)-1].ID
+	}
+	manifest, err := readRemoteManifest(store, id)
+	if err != nil {
+		return manifest, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sstableDir), 0755); err != nil {
+		return manifest, err
+	}
+	stagingDir, err := os.MkdirTemp(filepath.Dir(sstableDir), "remote-restore-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(stagingDir)
+	if err := os.MkdirAll(filepath.Join(stagingDir, BackupSSTableDirName), 0755); err != nil {
+		return manifest, err
+	}
+
+	// Lay the files out as a local backup, with the modification times that order the SSTables
+	download := func(file *BackupFile, name string) error {
+		dst := filepath.Join(stagingDir, filepath.FromSlash(name))
+		if err := objstore.GetFile(store, file.Name, dst); err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		file.Name = name
+		return os.Chtimes(dst, file.ModTime, file.ModTime)
+	}
+	for i := range manifest.SSTables {
+		file := &manifest.SSTables[i]
+		_, base, _ := strings.Cut(path.Base(file.Name), "-") // Without the checksum prefix
+		if err := download(file, BackupSSTableDirName+"/"+base); err != nil {
+			return manifest, err
+		}
+	}
+	if err := download(&manifest.WAL, BackupWALName); err != nil {
+		return manifest, err
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return manifest, err
+	}
+	if err := os.WriteFile(filepath.Join(stagingDir, BackupManifestName), data, 0644); err != nil {
+		return manifest, err
+	}
+	return Restore(stagingDir, walPath, sstableDir)
+}
+
+// PruneRemoteBackups deletes the remote backups of store that policy doesn't keep, then the SSTables no remaining
+// backup refers to. The most recent backup is always kept. It must not run while a backup is being uploaded to the
+// same store, whose new SSTables aren't referenced by a manifest yet. It returns the IDs of the deleted backups.
+func PruneRemoteBackups(store objstore.Store, policy RetentionPolicy) ([]string, error) {
+	deleted := make([]string, 0)
+	backups, err := ListRemoteBackups(store)
+	if err != nil {
+		return deleted, err
+	}
+
+	referenced := make(map[string]bool)
+	for i, backup := range backups {
+		age := time.Since(backup.Manifest.Time)
+		keep := i == len(backups)-1 ||
+			(policy.Keep > 0 && i >= len(backups)-policy.Keep) ||
+			(policy.MaxAge > 0 && age <= policy.MaxAge)
+		if keep {
+			for _, file := range backup.Manifest.SSTables {
+				referenced[file.Name] = true
+			}
+			continue
+		}
+		// The manifest goes first, so an interrupted deletion leaves an incomplete backup rather than a broken one
+		files, err := store.List(RemoteBackupPrefix + backup.ID + "/")
+		if err != nil {
+			return deleted, err
+		}
+		manifestName := RemoteBackupPrefix + backup.ID + "/" + BackupManifestName
+		if err := store.Delete(manifestName); err != nil {
+			return deleted, err
+		}
+		for _, name := range files {
+			if err := store.Delete(name); err != nil {
+				return deleted, err
+			}
+		}
+		deleted = append(deleted, backup.ID)
+	}
+
+	tables, err := store.List(RemoteSSTablePrefix)
+	if err != nil {
+		return deleted, err
+	}
+	for _, name := range tables {
+		if !referenced[name] {
+			if err := store.Delete(name); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,283 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Outcomes of the repair of an SSTable
+const (
+	RepairOK       = "ok"       // The table was readable and left untouched
+	RepairSalvaged = "salvaged" // The table was rewritten with the entries that could be decoded
+	RepairLost     = "lost"     // Nothing could be decoded, the table was moved to the quarantine directory
+)
+
+// TableRepair describes what Repair did with one SSTable
+type TableRepair struct {
+	Path        string `json:"path"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`       // Why the table couldn't be read
+	Entries     int    `json:"entries"`               // Entries left in the table
+	Quarantined string `json:"quarantined,omitempty"` // Where the corrupted original was kept
+}
+
+// WALRepair describes what Repair did with the WAL
+type WALRepair struct {
+	Records        int   `json:"records"`         // Readable records kept in the WAL
+	Recovered      int   `json:"recovered"`       // Complete records found past the offset stored in the metadata
+	TruncatedBytes int64 `json:"truncated_bytes"` // Unreadable bytes cut from the end of the WAL
+	Offset         int64 `json:"offset"`          // Offset stored in the metadata after the repair
+	Watermark      int64 `json:"watermark"`       // Watermark stored in the metadata after the repair
+}
+
+// RepairReport is the outcome of Repair
+type RepairReport struct {
+	Time         time.Time     `json:"time"`
+	Tables       []TableRepair `json:"tables"`
+	WAL          WALRepair     `json:"wal"`
+	RemovedFiles []string      `json:"removed_files"` // Leftovers of interrupted rewrites
+	Order        []string      `json:"order"`         // SSTables in the order the database reads them, oldest first
+}
+
+// Repair brings the files of a closed database back to a state NewDB can open, salvaging what can be read:
+//   - readable SSTables are kept as they are;
+//   - corrupted SSTables, quarantined ones included, are rewritten with the entries that can still be decoded,
+//     and their originals are kept in the quarantine directory;
+//   - the WAL is cut before its first record that can't be read or doesn't match its checksum, complete records written after the offset stored
+//     in its metadata are recovered, and the watermark is moved back onto a record boundary;
+//   - leftovers of interrupted rewrites are removed, tables a readable MANIFEST doesn't list included: they were
+//     written by a flush or a compaction that didn't complete, and hold data the WAL or other tables still have;
+//   - the MANIFEST is written again, listing the SSTables in the order of the one it replaces, by modification
+//     time for the tables named by time if it was missing or corrupted. The SSTables are given distinct
+//     modification times as well, so that the order stays unambiguous without it.
+//
+// Entries lost in a corrupted SSTable can bring back older values of their keys from older SSTables.
+func Repair(walPath string, sstableDir string) (RepairReport, error) {
+	report := RepairReport{Time: time.Now(), Tables: make([]TableRepair, 0), RemovedFiles: make([]string, 0), Order: make([]string, 0)}
+
+	if err := os.MkdirAll(sstableDir, 0755); err != nil {
+		return report, err
+	}
+	quarantineDir := filepath.Join(sstableDir, QuarantineDirName)
+
+	// The order of the MANIFEST prevails over modification times, unless it can't be read
+	m, err := readManifest(vfs.OS, sstableDir)
+	if err != nil && err != ErrCorruptedManifest {
+		return report, err
+	}
+	var listed map[string]time.Time
+	if m != nil {
+		listed = m.addedTimes(sstableDir)
+	}
+
+	// Collect the SSTables, and the quarantined ones no salvaged copy exists for
+	type table struct {
+		path        string
+		modTime     time.Time
+		order       time.Time // Time the table sorts by, its modification time unless the MANIFEST lists it
+		quarantined bool
+	}
+	var tables []table
+	inDir := make(map[string]bool)
+	files, err := os.ReadDir(sstableDir)
+	if err != nil {
+		return report, err
+	}
+	for _, file := range files {
+		path := filepath.Join(sstableDir, file.Name())
+		if file.IsDir() {
+			continue
+		}
+		_, live := listed[path]
+		if strings.HasSuffix(file.Name(), ".tmp") || (isDBFile(file.Name()) && listed != nil && !live) {
+			if err := os.Remove(path); err != nil {
+				return report, err
+			}
+			report.RemovedFiles = append(report.RemovedFiles, path)
+			continue
+		}
+		if !isDBFile(file.Name()) {
+			continue
+		}
+		fileInfo, err := file.Info()
+		if err != nil {
+			return report, err
+		}
+		order := fileInfo.ModTime()
+		if listed != nil {
+			order = listed[path]
+		}
+		tables = append(tables, table{path: path, modTime: fileInfo.ModTime(), order: order})
+		inDir[file.Name()] = true
+	}
+	quarantined, err := os.ReadDir(quarantineDir)
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	for _, file := range quarantined {
+		if file.IsDir() || !isDBFile(file.Name()) || inDir[file.Name()] {
+			continue
+		}
+		fileInfo, err := file.Info()
+		if err != nil {
+			return report, err
+		}
+		path := filepath.Join(quarantineDir, file.Name())
+		tables = append(tables, table{path: path, modTime: fileInfo.ModTime(), order: fileInfo.ModTime(), quarantined: true})
+	}
+	sort.SliceStable(tables, func(i, j int) bool {
+		return olderSSTable(tables[i].path, tables[i].order, tables[j].path, tables[j].order)
+	})
+
+	// Check and salvage the SSTables, from the oldest to the newest
+	var lastTime time.Time
+	for _, t := range tables {
+		tableReport, path, err := repairSSTable(t.path, sstableDir, t.quarantined)
+		if err != nil {
+			return report, err
+		}
+		report.Tables = append(report.Tables, tableReport)
+		if path == "" {
+			continue
+		}
+
+		// Make modification times strictly increasing
+		modTime := t.modTime
+		if !modTime.After(lastTime) {
+			modTime = lastTime.Add(time.Millisecond)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return report, err
+		}
+		lastTime = modTime
+		report.Order = append(report.Order, path)
+	}
+	if err := writeManifest(sstableDir, report.Order); err != nil {
+		return report, err
+	}
+
+	report.WAL, err = repairWAL(walPath)
+	return report, err
+}
+
+// repairSSTable checks one SSTable and salvages it if it is corrupted.
+// It returns the path the table is now read from, empty if nothing could be salvaged.
+func repairSSTable(path string, sstableDir string, quarantined bool) (TableRepair, string, error) {
+	tableReport := TableRepair{Path: path}
+	sst, err := sstable.ReadSSTable(path)
+	if err == nil && !quarantined {
+		tableReport.Status = RepairOK
+		tableReport.Entries = len(sst.KeyValues)
+		return tableReport, path, nil
+	}
+	if err != nil {
+		tableReport.Error = err.Error()
+	}
+
+	keyValues, _ := sstable.SalvageSSTable(path)
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return tableReport, "", err
+	}
+
+	// Keep the corrupted original in the quarantine directory
+	original := path
+	if !quarantined {
+		quarantineDir := filepath.Join(sstableDir, QuarantineDirName)
+		if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+			return tableReport, "", err
+		}
+		original = filepath.Join(quarantineDir, filepath.Base(path))
+		if err := os.Rename(path, original); err != nil {
+			return tableReport, "", err
+		}
+	}
+	tableReport.Quarantined = original
+
+	if len(keyValues) == 0 {
+		tableReport.Status = RepairLost
+		return tableReport, "", nil
+	}
+
+	salvaged := filepath.Join(sstableDir, filepath.Base(path))
+	tmp := salvaged + ".tmp"
+	if err := sstable.WriteSSTable(tmp, sstable.NewSSTable(keyValues)); err != nil {
+		return tableReport, "", err
+	}
+	if err := os.Chtimes(tmp, fileInfo.ModTime(), fileInfo.ModTime()); err != nil {
+		return tableReport, "", err
+	}
+	if err := vfs.ReplaceFile(vfs.OS, tmp, salvaged); err != nil {
+		return tableReport, "", err
+	}
+	tableReport.Status = RepairSalvaged
+	tableReport.Path = salvaged
+	tableReport.Entries = len(keyValues)
+	return tableReport, salvaged, nil
+}
+
+// repairWAL cuts the WAL after its last readable record and rewrites its metadata to match
+func repairWAL(walPath string) (WALRepair, error) {
+	var walReport WALRepair
+	data, err := os.ReadFile(walPath)
+	if os.IsNotExist(err) {
+		walReport.Offset, walReport.Watermark = WALMetadataSize, WALMetadataSize
+		return walReport, nil
+	}
+	if err != nil {
+		return walReport, err
+	}
+
+	var offset, watermark int64 = WALMetadataSize, WALMetadataSize
+	if len(data) >= WALMetadataSize {
+		offset = int64(binary.BigEndian.Uint64(data[0:8]))
+		watermark = int64(binary.BigEndian.Uint64(data[8:16]))
+	}
+
+	// Walk the records as far as they can be decoded and match their checksums, even past the stored offset
+	position := int64(WALMetadataSize)
+	newWatermark := int64(WALMetadataSize)
+	for position < int64(len(data)) {
+		h, ok := parseRecordHeader(data[position:])
+		size := h.recordSize()
+		if !ok || h.op > OpBatch || position+size > int64(len(data)) || !h.verify(data[position+h.size:position+size]) {
+			break
+		}
+		if position >= offset {
+			walReport.Recovered++
+		}
+		walReport.Records++
+		position += size
+		if position <= watermark {
+			newWatermark = position
+		}
+	}
+
+	walReport.TruncatedBytes = int64(len(data)) - position
+	if len(data) < WALMetadataSize {
+		walReport.TruncatedBytes = 0
+	}
+	walReport.Offset, walReport.Watermark = position, newWatermark
+
+	file, err := os.OpenFile(walPath, os.O_RDWR, WALFilePermission)
+	if err != nil {
+		return walReport, err
+	}
+	defer file.Close()
+	if err := file.Truncate(position); err != nil {
+		return walReport, err
+	}
+	meta := make([]byte, WALMetadataSize)
+	binary.BigEndian.PutUint64(meta[0:8], uint64(position))
+	binary.BigEndian.PutUint64(meta[8:16], uint64(newWatermark))
+	if _, err := file.WriteAt(meta, 0); err != nil {
+		return walReport, err
+	}
+	return walReport, file.Sync()
+}
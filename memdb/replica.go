@@ -0,0 +1,105 @@
+package memdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Replica opens the database as a replica of a primary on another server: client writes are refused with
+// ErrReadOnly, and the data only changes through ApplyReplicated, with the records read from the WAL of the
+// primary. Unlike a follower, a replica owns its files: it flushes and compacts its own SSTables.
+func Replica() Option {
+	return func(db *DB) {
+		db.replica = true
+	}
+}
+
+// IsReplica reports whether the database is a replica, opened with the Replica option or demoted by SetReplica
+func (db *DB) IsReplica() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.replica
+}
+
+// SetReplica turns the database into a replica refusing client writes, or promotes a replica to take them,
+// when the primary changes. Writes in progress finish first.
+func (db *DB) SetReplica(replica bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.replica = replica
+}
+
+// checkClientWrite returns ErrReadOnly if the data can't be changed by clients, on a follower or a replica
+func (db *DB) checkClientWrite() error {
+	if db.replica {
+		return ErrReadOnly
+	}
+	return db.checkWritable()
+}
+
+// ApplyReplicated writes records read from the WAL of the primary, in order, like the primary wrote them.
+// Applying a record twice leaves the same data, so records can be applied again after a crash.
+func (db *DB) ApplyReplicated(records []WALRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	return db.applyReplicated(records)
+}
+
+// applyReplicated implements ApplyReplicated, the caller must hold the write lock
+func (db *DB) applyReplicated(records []WALRecord) error {
+	for _, record := range records {
+		key := string(record.Key)
+		var err error
+		switch record.Operation {
+		case OpSet:
+			err = db.set(key, record.Value)
+		case OpDel:
+			if err = db.checkDisk(); err != nil {
+				break
+			}
+			if db.quota != nil {
+				if _, err = db.deleteReturning(key); err == ErrKeyNotFound {
+					err = nil
+				}
+				break
+			}
+			err = db.writeTombstone(key)
+		case OpDelRange:
+			err = db.writeRange(key, string(record.Value))
+		case OpBatch:
+			var batch []WALRecord
+			if batch, err = DecodeBatch(record); err == nil {
+				err = db.applyReplicated(batch)
+			}
+		default:
+			err = fmt.Errorf("Unknown operation %d for key %q", record.Operation, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TempBackup takes a backup like Backup into a temporary directory next to the SSTable directory, so the
+// SSTables are hard-linked rather than copied, and calls fn with it. The directory is removed when fn returns.
+func (db *DB) TempBackup(fn func(dir string, manifest BackupManifest) error) error {
+	if err := os.MkdirAll(filepath.Dir(db.sstableDir), 0755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(db.sstableDir), "backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	snapshotDir := filepath.Join(tmpDir, "snapshot")
+	manifest, err := db.Backup(snapshotDir)
+	if err != nil {
+		return err
+	}
+	return fn(snapshotDir, manifest)
+}
@@ -0,0 +1,123 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrBackupIncomplete is returned when restoring a backup that has no manifest
+	ErrBackupIncomplete = errors.New("Backup has no manifest, it is incomplete")
+	// ErrBackupCorrupted is returned when a file of a backup doesn't match its manifest
+	ErrBackupCorrupted = errors.New("Backup file doesn't match the manifest")
+	// ErrRestoreTargetExists is returned when restoring over an existing database
+	ErrRestoreTargetExists = errors.New("Restore target already holds a database")
+)
+
+// ReadBackupManifest reads the manifest of the backup in backupDir
+func ReadBackupManifest(backupDir string) (BackupManifest, error) {
+	var manifest BackupManifest
+	data, err := os.ReadFile(filepath.Join(backupDir, BackupManifestName))
+	if os.IsNotExist(err) {
+		return manifest, ErrBackupIncomplete
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("%s: %w", BackupManifestName, err)
+	}
+	return manifest, nil
+}
+
+// VerifyBackup checks the backup in backupDir against its manifest: every file must be present with the size
+// and CRC32 it was backed up with, every SSTable must be readable and every WAL record must decode.
+// It returns the manifest.
+func VerifyBackup(backupDir string) (BackupManifest, error) {
+	manifest, err := ReadBackupManifest(backupDir)
+	if err != nil {
+		return manifest, err
+	}
+	if manifest.WALFormat > WALFormatVersion {
+		return manifest, fmt.Errorf("%s: %w %d", manifest.WAL.Name, ErrUnsupportedFormat, manifest.WALFormat)
+	}
+
+	for _, expected := range append(append([]BackupFile(nil), manifest.SSTables...), manifest.WAL) {
+		path := filepath.Join(backupDir, filepath.FromSlash(expected.Name))
+		actual, err := describeBackupFile(backupDir, path)
+		if err != nil {
+			return manifest, fmt.Errorf("%s: %w", expected.Name, err)
+		}
+		if actual.Size != expected.Size || actual.CRC32 != expected.CRC32 {
+			return manifest, fmt.Errorf("%s: %w", expected.Name, ErrBackupCorrupted)
+		}
+	}
+
+	for _, file := range manifest.SSTables {
+		if _, err := sstable.ReadSSTable(filepath.Join(backupDir, filepath.FromSlash(file.Name))); err != nil {
+			return manifest, fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+	records := 0
+	_, err = ScanWALFile(filepath.Join(backupDir, filepath.FromSlash(manifest.WAL.Name)), func(WALEntry) error {
+		records++
+		return nil
+	})
+	if err != nil {
+		return manifest, fmt.Errorf("%s: %w", manifest.WAL.Name, err)
+	}
+	if records != manifest.WALRecords {
+		return manifest, fmt.Errorf("%s: %w", manifest.WAL.Name, ErrBackupCorrupted)
+	}
+	return manifest, nil
+}
+
+// Restore verifies the backup in backupDir, then installs it as a new database made of the WAL at walPath
+// and the SSTable directory sstableDir. Neither may hold a database already: the WAL must not exist,
+// and the SSTable directory must be empty or not exist. The files are copied, so the backup can be restored again.
+// The WAL is written last, a restore interrupted before leaves a database without its unflushed records.
+func Restore(backupDir string, walPath string, sstableDir string) (BackupManifest, error) {
+	manifest, err := VerifyBackup(backupDir)
+	if err != nil {
+		return manifest, err
+	}
+
+	if _, err := os.Stat(walPath); err == nil {
+		return manifest, ErrRestoreTargetExists
+	} else if !os.IsNotExist(err) {
+		return manifest, err
+	}
+	if files, err := os.ReadDir(sstableDir); err == nil && len(files) > 0 {
+		return manifest, ErrRestoreTargetExists
+	} else if err != nil && !os.IsNotExist(err) {
+		return manifest, err
+	}
+	if err := os.MkdirAll(sstableDir, 0755); err != nil {
+		return manifest, err
+	}
+
+	// The MANIFEST gives the order of the SSTables, the copies keep the modification times too
+	tables := make([]string, 0, len(manifest.SSTables))
+	for _, file := range manifest.SSTables {
+		src := filepath.Join(backupDir, filepath.FromSlash(file.Name))
+		dst := filepath.Join(sstableDir, filepath.Base(src))
+		if err := copyFile(src, dst); err != nil {
+			return manifest, err
+		}
+		tables = append(tables, dst)
+	}
+	if err := writeManifest(sstableDir, tables); err != nil {
+		return manifest, err
+	}
+	tmp := walPath + ".tmp"
+	os.Remove(tmp)
+	if err := copyFile(filepath.Join(backupDir, filepath.FromSlash(manifest.WAL.Name)), tmp); err != nil {
+		return manifest, err
+	}
+	return manifest, vfs.ReplaceFile(vfs.OS, tmp, walPath)
+}
@@ -0,0 +1,62 @@
+package memdb
+
+import (
+	"strings"
+	"time"
+)
+
+// KeyValue is a live key with its value, as returned by scans
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// ScanOptions selects the keys returned by Scan
+type ScanOptions struct {
+	Prefix string      // Only keys starting with Prefix
+	Start  string      // Only keys >= Start
+	End    string      // Only keys < End, no upper bound if empty
+	Limit  int         // Maximum number of results, no limit if 0
+	Filter ValueFilter // Only values matching Filter, all values if nil
+
+	manifests bool // Whether the manifests of the chunked values are returned instead of the values, as indexed
+	internal  bool // Whether the internal keys are selected too, e.g. the elements of a collection
+}
+
+// inRange reports whether key is selected by the prefix and bounds of the options, never for the internal keys unless
+// they are asked for
+func (opts ScanOptions) inRange(key string) bool {
+	return strings.HasPrefix(key, opts.Prefix) && key >= opts.Start && (opts.End == "" || key < opts.End) && (opts.internal || !internalKey(key))
+}
+
+// Scan returns the live keys selected by opts, in ascending order, with their values.
+// Filters are evaluated inside the engine so that only matching entries are returned.
+func (db *DB) Scan(opts ScanOptions) (kvs []KeyValue, err error) {
+	if db.recorder != nil {
+		defer db.recorder.record(RecordedOp{Op: RecordScan, Prefix: opts.Prefix, Start: opts.Start, End: opts.End, Limit: opts.Limit, Filtered: opts.Filter != nil}, time.Now(), &err)
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.scan(opts)
+}
+
+// scan implements Scan, the caller must hold the lock. The keys are merged by an Iterator, so that the memory
+// a scan takes grows with its results rather than with the SSTables.
+func (db *DB) scan(opts ScanOptions) ([]KeyValue, error) {
+	it, err := db.newIterator(IteratorOptions{Prefix: opts.Prefix, Start: opts.Start, End: opts.End, manifests: opts.manifests, internal: opts.internal})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	results := make([]KeyValue, 0)
+	for ; it.Valid(); it.Next() {
+		if opts.Filter != nil && !opts.Filter.Match(it.Value()) {
+			continue
+		}
+		results = append(results, KeyValue{Key: it.Key(), Value: it.Value()})
+		if opts.Limit > 0 && len(results) == opts.Limit {
+			break
+		}
+	}
+	return results, it.Err()
+}
@@ -0,0 +1,158 @@
+package memdb
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// QuarantineDirName is the sub-directory of the SSTable directory where corrupted SSTables are moved
+const QuarantineDirName = "quarantine"
+
+// scrubPause is the time the scrubber waits between two SSTables to keep its I/O footprint low
+const scrubPause = 100 * time.Millisecond
+
+// CorruptTable describes an SSTable that failed verification
+type CorruptTable struct {
+	Path        string `json:"path"`
+	Error       string `json:"error"`
+	Quarantined string `json:"quarantined,omitempty"` // Path the table was moved to, empty if it was left in place
+}
+
+// ScrubResult is the outcome of one scrub pass over all SSTables
+type ScrubResult struct {
+	Time      time.Time      `json:"time"`
+	Checked   int            `json:"checked"`
+	Corrupted []CorruptTable `json:"corrupted"`
+}
+
+// scrubber periodically verifies the checksums of the SSTables
+type scrubber struct {
+	interval   time.Duration
+	quarantine bool
+	onCorrupt  func(CorruptTable)
+	mu         sync.Mutex
+	last       *ScrubResult
+}
+
+// Scrub starts a background task re-reading every SSTable each interval to verify its checksum,
+// so corruption is detected before a read hits it. Corrupted tables are reported to onCorrupt (if not nil)
+// and, if quarantine is set, moved to the quarantine directory and no longer used by reads.
+func Scrub(interval time.Duration, quarantine bool, onCorrupt func(CorruptTable)) Option {
+	return func(db *DB) {
+		if interval > 0 {
+			db.scrubber = &scrubber{interval: interval, quarantine: quarantine, onCorrupt: onCorrupt}
+		}
+	}
+}
+
+// runScrubber scrubs the SSTables every interval until the database is closed
+func (db *DB) runScrubber() {
+	defer db.background.Done()
+	ticker := time.NewTicker(db.scrubber.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-ticker.C:
+			db.scrub(scrubPause)
+		}
+	}
+}
+
+// ScrubNow verifies every SSTable right away and returns the result.
+// Corrupted tables are handled as configured by the Scrub option, or only reported if it isn't set.
+func (db *DB) ScrubNow() ScrubResult {
+	return db.scrub(0)
+}
+
+// LastScrub returns the result of the last scrub pass, or nil if none ran yet
+func (db *DB) LastScrub() *ScrubResult {
+	if db.scrubber == nil {
+		return nil
+	}
+	db.scrubber.mu.Lock()
+	defer db.scrubber.mu.Unlock()
+	return db.scrubber.last
+}
+
+// scrub verifies every SSTable, waiting pause between two tables.
+// Tables are read without holding the database lock so reads and writes aren't blocked.
+func (db *DB) scrub(pause time.Duration) ScrubResult {
+	db.mu.RLock()
+	sstableIDs := append([]string(nil), db.SSTableIDs...)
+	db.mu.RUnlock()
+
+	result := ScrubResult{Time: time.Now(), Corrupted: make([]CorruptTable, 0)}
+	for i, sstableID := range sstableIDs {
+		if i > 0 && pause > 0 {
+			select {
+			case <-db.closing:
+				return result
+			case <-time.After(pause):
+			}
+		}
+
+		_, err := db.readSSTableFile(sstableID)
+		if os.IsNotExist(err) {
+			continue // Removed by a compaction in the meantime
+		}
+		result.Checked++
+		if err == nil {
+			continue
+		}
+
+		corrupt := CorruptTable{Path: sstableID, Error: err.Error()}
+		if db.scrubber != nil && db.scrubber.quarantine {
+			if path, err := db.quarantineSSTable(sstableID); err == nil {
+				corrupt.Quarantined = path
+			}
+		}
+		result.Corrupted = append(result.Corrupted, corrupt)
+		if db.scrubber != nil && db.scrubber.onCorrupt != nil {
+			db.scrubber.onCorrupt(corrupt)
+		}
+	}
+
+	if db.scrubber != nil {
+		db.scrubber.mu.Lock()
+		db.scrubber.last = &result
+		db.scrubber.mu.Unlock()
+	}
+	return result
+}
+
+// quarantineSSTable moves a corrupted SSTable to the quarantine directory and stops using it
+func (db *DB) quarantineSSTable(sstableID string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return "", err
+	}
+
+	quarantineDir := filepath.Join(db.sstableDir, QuarantineDirName)
+	if err := db.fs.MkdirAll(quarantineDir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(quarantineDir, filepath.Base(sstableID))
+	db.readers.evict(sstableID)
+	// The MANIFEST stops listing the table before it moves, a crash in between only leaves it unused
+	for i, id := range db.SSTableIDs {
+		if id == sstableID {
+			db.SSTableIDs = append(db.SSTableIDs[:i], db.SSTableIDs[i+1:]...)
+			db.sequence++
+			break
+		}
+	}
+	if err := db.logTables(); err != nil {
+		return "", err
+	}
+	if err := db.fs.Rename(sstableID, path); err != nil {
+		return "", err
+	}
+	// Values read from the quarantined table may no longer be found
+	db.values.clear()
+	return path, nil
+}
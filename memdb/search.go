@@ -0,0 +1,477 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrSearchDisabled is returned by Search when no field is indexed for full-text search
+var ErrSearchDisabled = errors.New("Full-text search is disabled")
+
+// errCorruptedSegment is returned when an entry of a segment of the full-text index can't be decoded
+var errCorruptedSegment = errors.New("Corrupted full-text index segment")
+
+// SearchDir is the sub-directory of the SSTable directory holding the segments of the full-text index
+const SearchDir = "search"
+
+// FullText indexes the words of the JSON elements at paths, strings or arrays of strings, for Search.
+// The index is kept in memory and updated under the write lock with every write. Each flush also writes the
+// entries of the flushed keys to a segment, an SSTable of the search directory mapping keys to their words, and
+// compactions merge the segments, so that opening the database reads the segments instead of every value.
+// Segments missing or older than the SSTables, e.g. after a restore, are rebuilt from the data.
+func FullText(paths ...string) Option {
+	return func(db *DB) {
+		db.searchFields = append(db.searchFields, paths...)
+	}
+}
+
+// SearchResult is a key matching a search, with its score: the occurrences of the searched words in its value
+type SearchResult struct {
+	Key   string `json:"key"`
+	Score int    `json:"score"`
+}
+
+// searchIndex is an inverted index of the words of the indexed fields of the values.
+// It is only modified while holding the database write lock.
+type searchIndex struct {
+	fields   [][]pathSegment
+	docs     map[string]map[string]int // Words of each key, with their number of occurrences
+	postings map[string]map[string]int // Keys of each word, with its number of occurrences
+	dir      string                    // Directory of the segments, empty if they aren't written, e.g. by a follower
+	segments []string                  // Segment files, oldest first, from the newest base
+}
+
+// newSearchIndex returns an empty index of fields, nil if there are none
+func newSearchIndex(fields []string, dir string) (*searchIndex, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	s := &searchIndex{dir: dir}
+	for _, field := range fields {
+		segments, err := parsePath(field)
+		if err != nil {
+			return nil, err
+		}
+		s.fields = append(s.fields, segments)
+	}
+	s.clear()
+	return s, nil
+}
+
+// tokenize splits text into lowercase words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// words counts the words of the indexed fields of value, nil if it has none
+func (s *searchIndex) words(value []byte) map[string]int {
+	var words map[string]int
+	add := func(elem interface{}) {
+		text, ok := elem.(string)
+		if !ok {
+			return
+		}
+		for _, word := range tokenize(text) {
+			if words == nil {
+				words = make(map[string]int)
+			}
+			words[word]++
+		}
+	}
+	for _, field := range s.fields {
+		elem, ok := jsonField(value, field)
+		if !ok {
+			continue
+		}
+		if list, ok := elem.([]interface{}); ok {
+			for _, item := range list {
+				add(item)
+			}
+		} else {
+			add(elem)
+		}
+	}
+	return words
+}
+
+// put indexes the value of key in place of its previous one
+func (s *searchIndex) put(key string, value []byte) {
+	if s == nil {
+		return
+	}
+	s.setWords(key, s.words(value))
+}
+
+// remove drops key from the index, once deleted
+func (s *searchIndex) remove(key string) {
+	if s == nil {
+		return
+	}
+	s.setWords(key, nil)
+}
+
+// removeRange drops the keys of a range deletion from the index
+func (s *searchIndex) removeRange(r sstable.RangeDeletion) {
+	if s == nil {
+		return
+	}
+	for key := range s.docs {
+		if r.Covers([]byte(key)) {
+			s.setWords(key, nil)
+		}
+	}
+}
+
+// setWords replaces the words of key, nil to drop it
+func (s *searchIndex) setWords(key string, words map[string]int) {
+	for word := range s.docs[key] {
+		delete(s.postings[word], key)
+		if len(s.postings[word]) == 0 {
+			delete(s.postings, word)
+		}
+	}
+	delete(s.docs, key)
+	if len(words) == 0 {
+		return
+	}
+	s.docs[key] = words
+	for word, count := range words {
+		if s.postings[word] == nil {
+			s.postings[word] = make(map[string]int)
+		}
+		s.postings[word][key] = count
+	}
+}
+
+// clear empties the index, keeping its fields
+func (s *searchIndex) clear() {
+	if s == nil {
+		return
+	}
+	s.docs = make(map[string]map[string]int)
+	s.postings = make(map[string]map[string]int)
+}
+
+// encodeWords encodes the words of a key as the value of a segment entry: the length of each word,
+// the word and its count, as varints
+func encodeWords(words map[string]int) []byte {
+	sorted := make([]string, 0, len(words))
+	for word := range words {
+		sorted = append(sorted, word)
+	}
+	sort.Strings(sorted)
+	var data []byte
+	for _, word := range sorted {
+		data = binary.AppendUvarint(data, uint64(len(word)))
+		data = append(data, word...)
+		data = binary.AppendUvarint(data, uint64(words[word]))
+	}
+	return data
+}
+
+// decodeWords decodes the value of a segment entry
+func decodeWords(data []byte) (map[string]int, error) {
+	words := make(map[string]int)
+	for len(data) > 0 {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			return nil, errCorruptedSegment
+		}
+		word := string(data[n : n+int(length)])
+		data = data[n+int(length):]
+		count, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errCorruptedSegment
+		}
+		words[word] = int(count)
+		data = data[n:]
+	}
+	return words, nil
+}
+
+// Segments are SSTables of the search directory named like the SSTables: a flush writes the segment of the
+// generation of its SSTables, e.g. 000007.sst, and a merge or a rebuild a segment with a sequence number, e.g.
+// 000007-1.sst, that replaces every segment sorting before it.
+
+// sortSegments sorts segments oldest first
+func sortSegments(segments []string) {
+	sort.Slice(segments, func(i, j int) bool {
+		return olderSSTable(segments[i], time.Time{}, segments[j], time.Time{})
+	})
+}
+
+// writeSegment writes the entries of keys to the segment of generation gen: their words, or a deletion
+// for the keys no longer indexed, and the range deletions of the flush
+func (s *searchIndex) writeSegment(fsys vfs.FS, keys []string, ranges sstable.RangeDeletions, gen uint64) error {
+	if s == nil || s.dir == "" {
+		return nil
+	}
+	if err := fsys.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	keyValues := make([]sstable.KeyValuePair, 0, len(keys))
+	for _, key := range keys {
+		if words, ok := s.docs[key]; ok {
+			keyValues = append(keyValues, sstable.KeyValuePair{Operation: sstable.OpSet, Key: []byte(key), Value: encodeWords(words)})
+		} else {
+			keyValues = append(keyValues, sstable.KeyValuePair{Operation: sstable.OpDel, Key: []byte(key)})
+		}
+	}
+	if len(keyValues) == 0 && len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(keyValues, func(i, j int) bool {
+		return string(keyValues[i].Key) < string(keyValues[j].Key)
+	})
+	keyValues = sstable.WithRangeDeletions(keyValues, ranges)
+	path := generationFilename(s.dir, gen, 0)
+	if err := writeSegmentFile(fsys, path, keyValues); err != nil {
+		return err
+	}
+	if !slices.Contains(s.segments, path) {
+		s.segments = append(s.segments, path)
+	}
+	return nil
+}
+
+// writeSegmentFile writes a segment, replacing the file if it exists
+func writeSegmentFile(fsys vfs.FS, path string, keyValues []sstable.KeyValuePair) error {
+	tmp := path + ".tmp"
+	if err := fsys.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := sstable.WriteSSTableFS(fsys, tmp, sstable.NewSSTable(keyValues)); err != nil {
+		return err
+	}
+	return vfs.ReplaceFile(fsys, tmp, path)
+}
+
+// writeBase writes every indexed key but those of the memtable, which are still in the WAL, to a segment
+// replacing all the others, of generation gen or of the newest segment if it is newer
+func (s *searchIndex) writeBase(fsys vfs.FS, memtable map[string]sstable.Pair, gen uint64) error {
+	if err := fsys.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	var seq uint64
+	for _, path := range s.segments {
+		segmentGen, segmentSeq, _ := parseGeneration(path)
+		if segmentGen > gen {
+			gen, seq = segmentGen, 0
+		}
+		if segmentGen == gen {
+			seq = max(seq, segmentSeq)
+		}
+	}
+
+	keyValues := make([]sstable.KeyValuePair, 0, len(s.docs))
+	for key, words := range s.docs {
+		if _, ok := memtable[key]; !ok {
+			keyValues = append(keyValues, sstable.KeyValuePair{Operation: sstable.OpSet, Key: []byte(key), Value: encodeWords(words)})
+		}
+	}
+	// An empty base still replaces the other segments
+	keyValues = append(keyValues, sstable.KeyValuePair{Operation: sstable.OpDel, Key: []byte(ReservedKeyPrefix)})
+	sort.Slice(keyValues, func(i, j int) bool {
+		return string(keyValues[i].Key) < string(keyValues[j].Key)
+	})
+	path := generationFilename(s.dir, gen, seq+1)
+	if err := writeSegmentFile(fsys, path, keyValues); err != nil {
+		return err
+	}
+	replaced := s.segments
+	s.segments = []string{path}
+	for _, old := range replaced {
+		if err := fsys.Remove(old); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSegments deletes the segments, newest first: once the newest is gone, those left don't cover the SSTables,
+// so an interrupted removal has the index rebuilt like a complete one
+func (s *searchIndex) removeSegments(fsys vfs.FS) error {
+	if s == nil || s.dir == "" {
+		return nil
+	}
+	for len(s.segments) > 0 {
+		last := s.segments[len(s.segments)-1]
+		if err := fsys.Remove(last); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.segments = s.segments[:len(s.segments)-1]
+	}
+	return nil
+}
+
+// compactSegments merges the segments into one, once there are several
+func (s *searchIndex) compactSegments(fsys vfs.FS, memtable map[string]sstable.Pair) error {
+	if s == nil || s.dir == "" || len(s.segments) < 2 {
+		return nil
+	}
+	return s.writeBase(fsys, memtable, 0)
+}
+
+// loadSegments fills the index from its segments, and reports whether they cover the SSTables up to generation
+// gen. If they don't, the index is left empty.
+func (s *searchIndex) loadSegments(fsys vfs.FS, gen uint64) (bool, error) {
+	entries, err := fsys.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.segments = nil
+	for _, entry := range entries {
+		if _, _, ok := parseGeneration(entry.Name()); ok && !entry.IsDir() {
+			s.segments = append(s.segments, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	sortSegments(s.segments)
+	// The newest base replaces the segments before it, left by an interrupted merge
+	for i := len(s.segments) - 1; i > 0; i-- {
+		if _, seq, _ := parseGeneration(s.segments[i]); seq > 0 {
+			for _, replaced := range s.segments[:i] {
+				fsys.Remove(replaced)
+			}
+			s.segments = s.segments[i:]
+			break
+		}
+	}
+	if len(s.segments) == 0 {
+		return false, nil
+	}
+	if last, _, _ := parseGeneration(s.segments[len(s.segments)-1]); last < gen {
+		return false, nil
+	}
+
+	tables := make([]*sstable.SSTable, 0, len(s.segments))
+	for _, path := range s.segments {
+		table, err := sstable.ReadSSTableFS(fsys, path)
+		if err != nil {
+			return false, err
+		}
+		tables = append(tables, table)
+	}
+	for _, kv := range sstable.Merge(tables, true) {
+		words, err := decodeWords(kv.Value)
+		if err != nil {
+			return false, err
+		}
+		s.setWords(string(kv.Key), words)
+	}
+	return true, nil
+}
+
+// lastGeneration returns the generation of the newest SSTable named by generation, 0 if there is none
+func lastGeneration(tables []string) uint64 {
+	var last uint64
+	for _, table := range tables {
+		if gen, _, ok := parseGeneration(table); ok {
+			last = max(last, gen)
+		}
+	}
+	return last
+}
+
+// buildSearch fills the full-text index when the database is opened: from the segments and the memtable if the
+// segments are up to date, from every live key otherwise, in which case the index is written as a new base
+func (db *DB) buildSearch() error {
+	if db.search == nil {
+		return nil
+	}
+	db.search.clear()
+	if db.search.dir != "" {
+		loaded, err := db.search.loadSegments(db.fs, lastGeneration(db.SSTableIDs))
+		if err != nil {
+			return err
+		}
+		if loaded {
+			for _, r := range db.ranges {
+				db.search.removeRange(r)
+			}
+			for _, key := range db.keys {
+				if pair := db.data[key]; pair.Marker {
+					db.search.remove(key)
+				} else {
+					db.search.put(key, pair.Value)
+				}
+			}
+			return nil
+		}
+		db.search.clear()
+	}
+
+	kvs, err := db.scan(ScanOptions{manifests: true})
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		db.search.put(kv.Key, kv.Value)
+	}
+	if db.search.dir == "" {
+		return nil
+	}
+	return db.search.writeBase(db.fs, db.data, lastGeneration(db.SSTableIDs))
+}
+
+// Search returns the keys whose indexed fields hold every word of q, the highest score first, then in key order.
+// A limit of 0 returns them all. It returns ErrSearchDisabled unless FullText indexes fields.
+func (db *DB) Search(q string, limit int) ([]SearchResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.search == nil {
+		return nil, ErrSearchDisabled
+	}
+	var words []string
+	for _, word := range tokenize(q) {
+		if !slices.Contains(words, word) {
+			words = append(words, word)
+		}
+	}
+	results := make([]SearchResult, 0)
+	if len(words) == 0 {
+		return results, nil
+	}
+
+	// Go through the keys of the rarest word, checking the others
+	sort.Slice(words, func(i, j int) bool {
+		return len(db.search.postings[words[i]]) < len(db.search.postings[words[j]])
+	})
+	for key, count := range db.search.postings[words[0]] {
+		score := count
+		for _, word := range words[1:] {
+			n, ok := db.search.postings[word][key]
+			if !ok {
+				score = 0
+				break
+			}
+			score += n
+		}
+		if score > 0 {
+			results = append(results, SearchResult{Key: key, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Key < results[j].Key
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,188 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"errors"
+	"sync"
+)
+
+// ErrSnapshotReleased is returned when reading through a snapshot after it was released
+var ErrSnapshotReleased = errors.New("Snapshot released")
+
+// Snapshot is a read-only view of the database as it was when Snapshot was called. It holds a copy of the
+// memtable and pins the readers of the live SSTables: flushes, compactions and purges that remove or replace their
+// files afterwards don't change what the snapshot reads, the open files keeping their data until the snapshot is
+// released. Reads through a snapshot don't take the lock of the database, so a long scan doesn't hold up writes
+// the way an Iterator does. A snapshot must be released, and before the database is closed; the files it pins
+// take room on disk until then. Tables only kept in the object store are read from it, and fail once a later
+// compaction deleted them.
+type Snapshot struct {
+	db       *DB
+	sequence uint64
+	keys     []string                // Keys of the memtable, sorted
+	data     map[string]sstable.Pair // Entries of the memtable
+	ranges   sstable.RangeDeletions  // Range deletions of the memtable
+	tables   []*sstable.Reader       // Readers of the SSTables, oldest first
+
+	mu       sync.Mutex
+	released bool
+}
+
+// Snapshot returns a snapshot of the current contents of the database, to read many keys consistently, e.g. for a
+// backup or a long scan. Taking it copies the memtable, and waits for the writes in progress.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	s := &Snapshot{
+		db:       db,
+		sequence: db.sequence,
+		keys:     append([]string(nil), db.keys...),
+		ranges:   append(sstable.RangeDeletions(nil), db.ranges...),
+		data:     make(map[string]sstable.Pair, len(db.data)),
+		tables:   make([]*sstable.Reader, 0, len(db.SSTableIDs)),
+	}
+	for key, pair := range db.data {
+		s.data[key] = pair
+	}
+	for _, sstableID := range db.SSTableIDs {
+		reader, err := db.readers.pin(sstableID)
+		if err != nil {
+			s.Release()
+			return nil, err
+		}
+		s.tables = append(s.tables, reader)
+	}
+	return s, nil
+}
+
+// Sequence returns the sequence of the database the snapshot sees, see DB.Sequence
+func (s *Snapshot) Sequence() uint64 {
+	return s.sequence
+}
+
+// Release unpins the SSTables of the snapshot, after which it can't be read. It may be called more than once.
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	for _, reader := range s.tables {
+		s.db.readers.unpin(reader)
+	}
+	s.tables, s.data, s.keys, s.ranges = nil, nil, nil, nil
+}
+
+// checkReleased returns ErrSnapshotReleased once the snapshot is released
+func (s *Snapshot) checkReleased() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return ErrSnapshotReleased
+	}
+	return nil
+}
+
+// Get returns the value key had when the snapshot was taken, or ErrKeyNotFound
+func (s *Snapshot) Get(key string) ([]byte, error) {
+	key, err := s.db.ValidateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkReleased(); err != nil {
+		return nil, err
+	}
+	value, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.resolveValue(key, value)
+}
+
+// get looks key up in the memtable of the snapshot, then in its SSTables from the newest to the oldest
+func (s *Snapshot) get(key string) ([]byte, error) {
+	if pair, ok := s.data[key]; ok {
+		if pair.Marker {
+			return nil, ErrKeyNotFound
+		}
+		return pair.Value, nil
+	}
+	if s.ranges.Covers([]byte(key)) {
+		return nil, ErrKeyNotFound
+	}
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		if !s.tables[i].InRange([]byte(key)) {
+			continue
+		}
+		kv, found, err := s.tables[i].Get([]byte(key))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if kv.Operation == sstable.OpDel {
+			return nil, ErrKeyNotFound
+		}
+		return kv.Value, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// resolveValue returns the whole value of a chunked value, read from the chunks of the snapshot, see DB.resolveValue
+func (s *Snapshot) resolveValue(key string, value []byte) ([]byte, error) {
+	m, ok := decodeManifest(value)
+	if !ok {
+		return value, nil
+	}
+	whole := make([]byte, 0, m.size)
+	for index := uint64(0); index < m.chunks; index++ {
+		chunk, err := s.get(chunkKey(key, m.upload, index))
+		if err == ErrKeyNotFound {
+			return nil, ErrChunkMissing
+		}
+		if err != nil {
+			return nil, err
+		}
+		whole = append(whole, chunk...)
+	}
+	return whole, nil
+}
+
+// NewIterator returns an iterator over the live keys of the snapshot selected by opts, like DB.NewIterator. It
+// doesn't hold the lock of the database, and must be closed before the snapshot is released.
+func (s *Snapshot) NewIterator(opts IteratorOptions) (*Iterator, error) {
+	if err := s.checkReleased(); err != nil {
+		return nil, err
+	}
+	inputs := make([]sstable.Iterator, 0, len(s.tables)+1)
+	for _, reader := range s.tables {
+		inputs = append(inputs, reader.NewIterator())
+	}
+	inputs = append(inputs, &memtableIterator{keys: s.keys, data: s.data, ranges: s.ranges})
+	it := &Iterator{db: s.db, snapshot: s, merged: sstable.NewMergingIterator(inputs, true), opts: opts, sequence: s.sequence}
+	it.start()
+	return it, nil
+}
+
+// Scan returns the live keys of the snapshot selected by opts, in ascending order, with their values, like
+// DB.Scan
+func (s *Snapshot) Scan(opts ScanOptions) ([]KeyValue, error) {
+	it, err := s.NewIterator(IteratorOptions{Prefix: opts.Prefix, Start: opts.Start, End: opts.End})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	results := make([]KeyValue, 0)
+	for ; it.Valid(); it.Next() {
+		if opts.Filter != nil && !opts.Filter.Match(it.Value()) {
+			continue
+		}
+		results = append(results, KeyValue{Key: it.Key(), Value: it.Value()})
+		if opts.Limit > 0 && len(results) == opts.Limit {
+			break
+		}
+	}
+	return results, it.Err()
+}
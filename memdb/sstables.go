@@ -0,0 +1,88 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSTableInfo describes a live SSTable
+type SSTableInfo struct {
+	FileNumber  uint64    `json:"file_number"` // Number taken from the file name, increasing with the creation order
+	Level       int       `json:"level"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	SmallestKey string    `json:"smallest_key"`
+	LargestKey  string    `json:"largest_key"`
+	Entries     int       `json:"entries"`
+	Tombstones  int       `json:"tombstones"`
+	Created     time.Time `json:"created"`
+	Remote      bool      `json:"remote,omitempty"` // Only in the object store, Created is then unknown
+	// Name of the encryption key of the table, if it has one, see EncryptionKeys
+	KeyName string `json:"key_name,omitempty"`
+	// Algorithm of the checksum of the table, see Checksum
+	Checksum sstable.ChecksumType `json:"checksum"`
+	// Properties of the table, nil if it was written before they were, see PropertiesCollectors
+	Properties *sstable.Properties `json:"properties,omitempty"`
+}
+
+// fileNumber returns the generation of an SSTable, or the number at the end of a file name named by time,
+// 0 if there is none
+func fileNumber(sstableID string) uint64 {
+	if gen, _, ok := parseGeneration(sstableID); ok {
+		return gen
+	}
+	name := strings.TrimSuffix(filepath.Base(sstableID), ".sst")
+	start := len(name)
+	for start > 0 && name[start-1] >= '0' && name[start-1] <= '9' {
+		start--
+	}
+	n, _ := strconv.ParseUint(name[start:], 10, 64)
+	return n
+}
+
+// ListSSTables describes every live SSTable, from the oldest to the newest
+func (db *DB) ListSSTables() ([]SSTableInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	infos := make([]SSTableInfo, 0, len(db.SSTableIDs))
+	for _, sstableID := range db.SSTableIDs {
+		info := SSTableInfo{
+			FileNumber: fileNumber(sstableID),
+			Level:      0, // SSTables aren't organized in levels yet
+			Path:       sstableID,
+		}
+		fileInfo, err := db.fs.Stat(sstableID)
+		if os.IsNotExist(err) && db.remote != nil {
+			info.Remote = true
+			info.Size, err = db.remote.store.Size(objectName(sstableID))
+		} else if err == nil {
+			info.Size, info.Created = fileInfo.Size(), fileInfo.ModTime()
+		}
+		if err != nil {
+			return nil, err
+		}
+		sst, err := db.readSSTable(sstableID)
+		if err != nil {
+			return nil, err
+		}
+		info.Entries, info.KeyName, info.Properties = len(sst.KeyValues), sst.Header.KeyName, sst.Header.Properties
+		info.Checksum = sst.Header.ChecksumType
+		// The header only keeps a prefix of the bounds, take them from the entries instead
+		if len(sst.KeyValues) > 0 {
+			info.SmallestKey = string(sst.KeyValues[0].Key)
+			info.LargestKey = string(sst.KeyValues[len(sst.KeyValues)-1].Key)
+		}
+		for _, kv := range sst.KeyValues {
+			if kv.Operation != sstable.OpSet {
+				info.Tombstones++
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,83 @@
+package memdb
+
+// LevelStats describes the SSTables of one level
+type LevelStats struct {
+	Level int   `json:"level"`
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Stats is a snapshot of the state of the database
+type Stats struct {
+	MemtableEntries    int          `json:"memtable_entries"`     // Keys in the memtable, deletion markers included
+	MemtableBytes      int64        `json:"memtable_bytes"`       // Size of the keys and values held in the memtable
+	ImmutableMemtables int          `json:"immutable_memtables"`  // Memtables waiting to be flushed
+	Levels             []LevelStats `json:"levels"`               // SSTables per level
+	WALBytes           int64        `json:"wal_bytes"`            // Size of the WAL file
+	WALLag             int64        `json:"wal_lag"`              // WAL bytes written after the watermark, i.e. not yet flushed
+	PendingCompactions int          `json:"pending_compactions"`  // Compaction rounds needed to get below the compaction threshold
+	TombstoneBytes     int64        `json:"tombstone_bytes"`      // Size of the deletion markers in the SSTables, awaiting a compaction dropping them
+	Quota              *QuotaStats  `json:"quota,omitempty"`      // Quota usage, nil if no quota is set
+	Disk               *DiskStats   `json:"disk,omitempty"`       // Free disk space, nil if unsupported on the platform
+	LastScrub          *ScrubResult `json:"last_scrub,omitempty"` // Result of the last background scrub, nil if none ran
+	HotKeys            []KeyAccess  `json:"hot_keys,omitempty"`   // Most accessed keys, nil if access statistics are disabled
+	ValueCache         *CacheStats  `json:"cache,omitempty"`      // Use of the value cache, nil if it is disabled
+}
+
+// Stats returns a snapshot of the memtable, SSTable and WAL state
+func (db *DB) Stats() (Stats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := Stats{
+		MemtableEntries: len(db.keys),
+		// SSTables aren't organized in levels, they all live in level 0
+		Levels:             []LevelStats{{Level: 0, Files: len(db.SSTableIDs)}},
+		PendingCompactions: db.pendingCompactions(len(db.SSTableIDs)),
+	}
+	for key, pair := range db.data {
+		stats.MemtableBytes += int64(len(key) + len(pair.Value))
+	}
+
+	for _, sstableID := range db.SSTableIDs {
+		fileInfo, err := db.fs.Stat(sstableID)
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.Levels[0].Bytes += fileInfo.Size()
+		// Unreadable tables are left to the scrubber
+		if reader, err := db.readers.get(sstableID); err == nil {
+			stats.TombstoneBytes += reader.TombstoneBytes()
+		}
+	}
+
+	walSize, err := db.wal.Size()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.WALBytes = walSize
+	stats.WALLag = db.wal.MetaData.Offset - db.wal.MetaData.Watermark
+
+	if db.quota != nil {
+		stats.Quota = db.quota.stats()
+	}
+
+	if stats.Disk, err = db.diskStats(); err != nil {
+		return Stats{}, err
+	}
+	stats.LastScrub = db.LastScrub()
+	stats.HotKeys = db.AccessHotKeys()
+	stats.ValueCache = db.values.stats()
+
+	return stats, nil
+}
+
+// pendingCompactions returns the number of merges CompactSSTables would perform on n files.
+// Each merge replaces up to maxCompact files by one.
+func (db *DB) pendingCompactions(n int) int {
+	merges := 0
+	for ; n >= db.minCompact; merges++ {
+		n -= min(n, db.maxCompact) - 1
+	}
+	return merges
+}
@@ -0,0 +1,161 @@
+package memdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// seqDigits is the width of the sequence numbers in stream keys, enough for any uint64
+const seqDigits = 20
+
+// streamSeqs maps the key prefix of the streams appended to, to their last sequence number
+type streamSeqs map[string]uint64
+
+// StreamEntry is an entry of a stream
+type StreamEntry struct {
+	Seq     uint64 `json:"seq"`
+	Payload []byte `json:"payload"` // Base64 in JSON
+}
+
+// StreamKey returns the key of entry seq of a stream, e.g. "orders/00000000000000000042". The sequence number is
+// padded with zeros, so the entries of a stream sort in append order and reading a stream maps onto a range scan.
+// Entries are regular keys: they are written to the WAL, replicated and published by package cdc like any write.
+func StreamKey(stream string, seq uint64) string {
+	return fmt.Sprintf("%s/%0*d", stream, seqDigits, seq)
+}
+
+// streamPrefix returns the prefix of the keys of a stream, as stored by the database
+func (db *DB) streamPrefix(stream string) (string, error) {
+	return db.ValidateKey(stream + "/")
+}
+
+// parseStreamSeq returns the sequence number of a key starting with the prefix of a stream, ok is false if the
+// key isn't an entry of the stream, e.g. the entry of a stream named after a sub-path of it
+func parseStreamSeq(key string, prefix string) (seq uint64, ok bool) {
+	rest, found := strings.CutPrefix(key, prefix)
+	if !found || len(rest) != seqDigits {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(rest, 10, 64)
+	return seq, err == nil
+}
+
+// lastStreamSeq returns the sequence number of the last entry of a stream, 0 if it is empty, read from the data
+// the first time, the caller must hold the write lock
+func (db *DB) lastStreamSeq(prefix string) (uint64, error) {
+	if seq, ok := db.streams[prefix]; ok {
+		return seq, nil
+	}
+	kvs, err := db.scan(ScanOptions{Prefix: prefix})
+	if err != nil {
+		return 0, err
+	}
+	var last uint64
+	for _, kv := range kvs {
+		if seq, ok := parseStreamSeq(kv.Key, prefix); ok {
+			last = max(last, seq)
+		}
+	}
+	if db.streams == nil {
+		db.streams = make(streamSeqs)
+	}
+	db.streams[prefix] = last
+	return last, nil
+}
+
+// StreamAppend appends payload to a stream, a durable log under the keys built by StreamKey, and returns its
+// sequence number. Sequence numbers start at 1 and increase by 1 with every append to the stream.
+func (db *DB) StreamAppend(stream string, payload []byte) (uint64, error) {
+	prefix, err := db.streamPrefix(stream)
+	if err != nil {
+		return 0, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return 0, err
+	}
+	last, err := db.lastStreamSeq(prefix)
+	if err != nil {
+		return 0, err
+	}
+	key := prefix + fmt.Sprintf("%0*d", seqDigits, last+1)
+	db.recordAccess(key, true)
+	if err := db.checkSize(key, payload); err != nil {
+		return 0, err
+	}
+	if err := db.set(key, payload); err != nil {
+		return 0, err
+	}
+	db.streams[prefix] = last + 1
+	return last + 1, nil
+}
+
+// StreamRead returns the entries of a stream from sequence number fromSeq on, in order, at most limit of them,
+// all of them if limit is 0. A consumer reads from the sequence number following the last entry it processed.
+func (db *DB) StreamRead(stream string, fromSeq uint64, limit int) ([]StreamEntry, error) {
+	prefix, err := db.streamPrefix(stream)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	// Keys in the range that aren't entries of the stream, e.g. of a stream named after a sub-path of it, are skipped
+	kvs, err := db.scan(ScanOptions{Prefix: prefix, Start: prefix + fmt.Sprintf("%0*d", seqDigits, fromSeq), End: prefix + ":"})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StreamEntry, 0, len(kvs))
+	for _, kv := range kvs {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		if seq, ok := parseStreamSeq(kv.Key, prefix); ok {
+			entries = append(entries, StreamEntry{Seq: seq, Payload: kv.Value})
+		}
+	}
+	return entries, nil
+}
+
+// StreamTrim deletes the entries of a stream before sequence number beforeSeq, once every consumer is past them,
+// and returns how many it deleted. The last entry is always kept, so that the sequence numbers carry on from it
+// when the database is opened again.
+func (db *DB) StreamTrim(stream string, beforeSeq uint64) (int, error) {
+	prefix, err := db.streamPrefix(stream)
+	if err != nil {
+		return 0, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return 0, err
+	}
+	if err := db.checkDisk(); err != nil {
+		return 0, err
+	}
+	last, err := db.lastStreamSeq(prefix)
+	if err != nil {
+		return 0, err
+	}
+	beforeSeq = min(beforeSeq, last)
+	kvs, err := db.scan(ScanOptions{Prefix: prefix, End: prefix + fmt.Sprintf("%0*d", seqDigits, beforeSeq)})
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, kv := range kvs {
+		if _, ok := parseStreamSeq(kv.Key, prefix); !ok {
+			continue
+		}
+		if db.quota != nil {
+			if _, err := db.deleteReturning(kv.Key); err != nil && err != ErrKeyNotFound {
+				return deleted, err
+			}
+		} else if err := db.writeTombstone(kv.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
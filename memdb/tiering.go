@@ -0,0 +1,130 @@
+package memdb
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTierInterval is the time between two tiering passes when none is given
+const DefaultTierInterval = 10 * time.Minute
+
+// ErrNoColdTier is returned when the database is opened with the Tiering option but without ObjectStorage
+var ErrNoColdTier = errors.New("Tiering requires the ObjectStorage option")
+
+// TierPolicy decides which SSTables stay on the local disk, the hot tier, and which are only kept in the object
+// store, the cold tier
+type TierPolicy struct {
+	ColdAfter time.Duration // Tables last written longer ago than this may move to the cold tier
+	HotReads  uint64        // Tables looked up more than this many times during an interval stay in, or come back to, the hot tier
+	Interval  time.Duration // Time between two tiering passes, DefaultTierInterval if 0
+}
+
+// Tiering moves the SSTables that are old and rarely read to the cold tier of the object store configured with the
+// ObjectStorage option, removing their local copy once it is uploaded, and brings cold tables that get read often
+// back to the local disk. Reads don't depend on the tier: cold tables are read from the object store through the
+// block cache. Recent tables always stay local, as flushes and compactions write them there.
+func Tiering(policy TierPolicy) Option {
+	return func(db *DB) {
+		if policy.ColdAfter <= 0 {
+			return
+		}
+		if policy.Interval <= 0 {
+			policy.Interval = DefaultTierInterval
+		}
+		db.tiering = &tiering{policy: policy, reads: make(map[string]uint64)}
+	}
+}
+
+// tiering counts the lookups of each SSTable between two tiering passes
+type tiering struct {
+	policy TierPolicy
+	mu     sync.Mutex
+	reads  map[string]uint64
+}
+
+// count records a lookup in an SSTable, it does nothing if tiering is disabled
+func (t *tiering) count(sstableID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.reads[sstableID]++
+	t.mu.Unlock()
+}
+
+// reset returns the lookups counted since the last pass and starts counting again
+func (t *tiering) reset() map[string]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reads := t.reads
+	t.reads = make(map[string]uint64)
+	return reads
+}
+
+// TierReport lists the SSTables moved by a tiering pass
+type TierReport struct {
+	Demoted  []string `json:"demoted"`  // Moved to the cold tier, their local copy removed
+	Promoted []string `json:"promoted"` // Downloaded back to the hot tier
+}
+
+// runTiering moves the SSTables between the tiers every interval until the database is closed
+func (db *DB) runTiering() {
+	defer db.background.Done()
+	ticker := time.NewTicker(db.tiering.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-ticker.C:
+			if _, err := db.MoveTiers(); err != nil {
+				log.Printf("Tiering: %s", err)
+			}
+		}
+	}
+}
+
+// MoveTiers runs a tiering pass right away: tables old enough and read at most HotReads times since the previous
+// pass move to the cold tier, cold tables read more than HotReads times come back to the local disk
+func (db *DB) MoveTiers() (TierReport, error) {
+	report := TierReport{Demoted: make([]string, 0), Promoted: make([]string, 0)}
+	if db.tiering == nil {
+		return report, nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return report, err
+	}
+
+	reads := db.tiering.reset()
+	for _, sstableID := range db.SSTableIDs {
+		hot := reads[sstableID] > db.tiering.policy.HotReads
+		fileInfo, err := os.Stat(sstableID)
+		switch {
+		case os.IsNotExist(err) && hot:
+			if err := db.fetchRemote([]string{sstableID}); err != nil {
+				return report, err
+			}
+			report.Promoted = append(report.Promoted, sstableID)
+		case err != nil && !os.IsNotExist(err):
+			return report, err
+		case err == nil && !hot && time.Since(fileInfo.ModTime()) > db.tiering.policy.ColdAfter:
+			// The local copy is only removed once the object store has this very version of the table
+			if db.remote.uploaded[objectName(sstableID)] != stampOf(fileInfo) {
+				if err := db.syncRemote(); err != nil {
+					return report, err
+				}
+			}
+			db.readers.evict(sstableID)
+			if err := os.Remove(sstableID); err != nil {
+				return report, err
+			}
+			report.Demoted = append(report.Demoted, sstableID)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,73 @@
+package memdb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTimeKey is returned when parsing a key that wasn't built by TimeKey
+var ErrInvalidTimeKey = errors.New("Invalid time key")
+
+// timestampDigits is the width of the timestamps in time keys, enough for any positive int64
+const timestampDigits = 20
+
+// TimeKey returns a key made of a bucket, a timestamp and an optional id, e.g. "cpu/01700000000000000000/host1".
+// The timestamp is the number of nanoseconds since 1970 padded with zeros, so the keys of a bucket sort in time order
+// and ScanTimeRange maps onto a range scan. The id tells apart entries of the same bucket logged at the same time.
+// Times before 1970 are stored as 1970.
+func TimeKey(bucket string, t time.Time, id string) string {
+	nanos := t.UnixNano()
+	if nanos < 0 || t.IsZero() {
+		nanos = 0
+	}
+	key := fmt.Sprintf("%s/%0*d", bucket, timestampDigits, nanos)
+	if id != "" {
+		key += "/" + id
+	}
+	return key
+}
+
+// ParseTimeKey splits a key built by TimeKey into its bucket, time and id
+func ParseTimeKey(key string) (bucket string, t time.Time, id string, err error) {
+	// The timestamp is the first segment of exactly timestampDigits digits
+	for start := 0; start < len(key); {
+		sep := strings.IndexByte(key[start:], '/')
+		if sep < 0 {
+			break
+		}
+		sep += start
+		rest := key[sep+1:]
+		if len(rest) >= timestampDigits && (len(rest) == timestampDigits || rest[timestampDigits] == '/') {
+			if nanos, err := strconv.ParseInt(rest[:timestampDigits], 10, 64); err == nil && nanos >= 0 {
+				if len(rest) > timestampDigits {
+					id = rest[timestampDigits+1:]
+				}
+				return key[:sep], time.Unix(0, nanos), id, nil
+			}
+		}
+		start = sep + 1
+	}
+	return "", time.Time{}, "", ErrInvalidTimeKey
+}
+
+// TimeRangeOptions returns the scan options selecting the time keys of a bucket from time from (inclusive)
+// to time to (exclusive). A zero from or to leaves the range unbounded on that side.
+func TimeRangeOptions(bucket string, from time.Time, to time.Time) ScanOptions {
+	opts := ScanOptions{Prefix: bucket + "/"}
+	if !from.IsZero() {
+		opts.Start = TimeKey(bucket, from, "")
+	}
+	if !to.IsZero() {
+		opts.End = TimeKey(bucket, to, "")
+	}
+	return opts
+}
+
+// ScanTimeRange returns the entries of a bucket whose time keys fall between from (inclusive)
+// and to (exclusive), in time order
+func (db *DB) ScanTimeRange(bucket string, from time.Time, to time.Time) ([]KeyValue, error) {
+	return db.Scan(TimeRangeOptions(bucket, from, to))
+}
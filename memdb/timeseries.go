@@ -0,0 +1,332 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultRetentionInterval is the time between two retention passes when none is given
+const DefaultRetentionInterval = 10 * time.Minute
+
+// TimeSeriesPolicy configures the time-series mode
+type TimeSeriesPolicy struct {
+	Window    time.Duration // Span of the time keys of an SSTable, e.g. an hour or a day
+	Retention time.Duration // Windows ending longer ago than this are dropped, 0 to keep them forever
+	Interval  time.Duration // Time between two retention passes, DefaultRetentionInterval if 0
+}
+
+// TimeSeries optimizes the database for the time keys built by TimeKey. Flushes and compactions write the keys of
+// each time window to SSTables of their own, and compactions only merge the tables of a same window, so a window
+// that no longer receives writes ends up in a few tables nothing else is merged into. Once a window ended longer
+// ago than the retention, its tables are dropped whole by a retention pass, instead of deleting its points one by
+// one. The points stay readable until then. Keys that aren't time keys go to tables of their own and never expire.
+// Tables holding several windows, written before the mode was enabled or by Ingest, are split by a compaction of
+// every table before the retention pass drops anything.
+func TimeSeries(policy TimeSeriesPolicy) Option {
+	return func(db *DB) {
+		if policy.Window <= 0 {
+			return
+		}
+		if policy.Interval <= 0 {
+			policy.Interval = DefaultRetentionInterval
+		}
+		db.timeSeries = &timeSeries{policy: policy, windows: make(map[string]int64)}
+	}
+}
+
+const (
+	noWindow    int64 = -1 // Window of the keys that aren't time keys
+	mixedWindow int64 = -2 // Window of the tables holding the keys of several windows
+)
+
+// timeSeries tracks the window of each SSTable, only used while holding the database write lock
+type timeSeries struct {
+	policy  TimeSeriesPolicy
+	windows map[string]int64 // Start of the window of the tables, in nanoseconds since 1970
+}
+
+// keyWindow returns the start of the window of key, noWindow if it isn't a time key
+func (ts *timeSeries) keyWindow(key string) int64 {
+	_, t, _, err := ParseTimeKey(key)
+	if err != nil {
+		return noWindow
+	}
+	nanos := t.UnixNano()
+	return nanos - nanos%int64(ts.policy.Window)
+}
+
+// windowRun is a run of key-value pairs of a same window
+type windowRun struct {
+	window    int64
+	keyValues []sstable.KeyValuePair
+}
+
+// splitWindows splits key-value pairs sorted by key into runs of a window each, sorted by key, oldest window first.
+// Without time-series mode, they are a single run.
+func (ts *timeSeries) splitWindows(keyValues []sstable.KeyValuePair) []windowRun {
+	if ts == nil {
+		return []windowRun{{window: noWindow, keyValues: keyValues}}
+	}
+	byWindow := make(map[int64][]sstable.KeyValuePair)
+	for _, kv := range keyValues {
+		window := ts.keyWindow(string(kv.Key))
+		byWindow[window] = append(byWindow[window], kv)
+	}
+	runs := make([]windowRun, 0, len(byWindow))
+	for window, run := range byWindow {
+		runs = append(runs, windowRun{window: window, keyValues: run})
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].window < runs[j].window
+	})
+	return runs
+}
+
+// writeWindowTables writes key-value pairs sorted by key like writeSSTables, to separate tables for each window in
+// time-series mode
+func (db *DB) writeWindowTables(keyValues []sstable.KeyValuePair, filename func() (string, error), props *tableProps) ([]string, error) {
+	outputs := make([]string, 0)
+	for _, run := range db.timeSeries.splitWindows(keyValues) {
+		written, err := writeSSTables(db.fs, run.keyValues, db.maxFileSize, filename, props)
+		outputs = append(outputs, written...)
+		if err != nil {
+			return outputs, err
+		}
+		if db.timeSeries != nil {
+			for _, output := range written {
+				db.timeSeries.windows[output] = run.window
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// tableWindows returns the window of each SSTable of the database, in the order of SSTableIDs. The window of a
+// table is read from its keys the first time it is needed.
+func (db *DB) tableWindows() ([]int64, error) {
+	live := make(map[string]bool, len(db.SSTableIDs))
+	windows := make([]int64, len(db.SSTableIDs))
+	for i, sstableID := range db.SSTableIDs {
+		live[sstableID] = true
+		window, ok := db.timeSeries.windows[sstableID]
+		if !ok {
+			sst, err := db.readSSTable(sstableID)
+			if err != nil {
+				return nil, err
+			}
+			window = noWindow
+			for j, kv := range sst.KeyValues {
+				keyWindow := db.timeSeries.keyWindow(string(kv.Key))
+				if j > 0 && keyWindow != window {
+					window = mixedWindow
+					break
+				}
+				window = keyWindow
+			}
+			db.timeSeries.windows[sstableID] = window
+		}
+		windows[i] = window
+	}
+	// Forget the tables that are gone
+	for sstableID := range db.timeSeries.windows {
+		if !live[sstableID] {
+			delete(db.timeSeries.windows, sstableID)
+		}
+	}
+	return windows, nil
+}
+
+// compactWindows is compactLocked in time-series mode: the tables of each window with MinFiles tables or more
+// are merged, dropping overwritten values and deletion markers, which is safe as every version of the keys of a
+// window is in its tables. If some tables hold several windows, every table is compacted instead.
+// The caller must hold the write lock.
+func (db *DB) compactWindows() error {
+	windows, err := db.tableWindows()
+	if err != nil {
+		return err
+	}
+	byWindow := make(map[int64][]string)
+	for i, window := range windows {
+		if window == mixedWindow {
+			return db.compactAll()
+		}
+		byWindow[window] = append(byWindow[window], db.SSTableIDs[i])
+	}
+
+	for window, inputs := range byWindow {
+		if len(inputs) < db.minCompact {
+			continue
+		}
+		if err := db.compactWindow(window, inputs); err != nil {
+			return err
+		}
+	}
+	if err := db.search.compactSegments(db.fs, db.data); err != nil {
+		return err
+	}
+	return db.syncRemote()
+}
+
+// compactWindow merges the tables of a window, its outputs take the place of the newest of them
+func (db *DB) compactWindow(window int64, inputs []string) (err error) {
+	if err := db.fetchRemote(inputs); err != nil {
+		return err
+	}
+	event := Event{Type: EventCompaction, Start: time.Now(), Inputs: inputs, InputBytes: filesSize(db.fs, inputs), Outputs: make([]string, 0)}
+	defer func() { db.recordEvent(event, err) }()
+
+	headers, err := db.inputHeaders(inputs)
+	if err != nil {
+		return err
+	}
+	newest := inputs[len(inputs)-1]
+	event.Outputs, event.Entries, err = db.writeSSTablesFrom(db.mergeDroppingTombstones(inputs), false, func() (string, error) {
+		return compactionFilename(db.fs, db.sstableDir, db.SSTableIDs, newest)
+	}, db.tableProperties(headers, inputs))
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]bool, len(inputs))
+	for _, input := range inputs {
+		merged[input] = true
+	}
+	sstableIDs := make([]string, 0, len(db.SSTableIDs))
+	for _, sstableID := range db.SSTableIDs {
+		if sstableID == newest {
+			sstableIDs = append(sstableIDs, event.Outputs...)
+		}
+		if !merged[sstableID] {
+			sstableIDs = append(sstableIDs, sstableID)
+		}
+	}
+	db.SSTableIDs = sstableIDs
+	db.readers.open(event.Outputs)
+	if err := db.logTables(); err != nil {
+		return err
+	}
+	for _, output := range event.Outputs {
+		db.timeSeries.windows[output] = window
+	}
+	for _, input := range inputs {
+		db.readers.evict(input)
+		delete(db.timeSeries.windows, input)
+		if err := db.fs.Remove(input); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runRetention drops the expired windows every interval until the database is closed
+func (db *DB) runRetention() {
+	defer db.background.Done()
+	ticker := time.NewTicker(db.timeSeries.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-ticker.C:
+			if _, err := db.ApplyRetention(); err != nil {
+				log.Printf("Retention: %s", err)
+			}
+		}
+	}
+}
+
+// ApplyRetention runs a retention pass right away: the SSTables of the windows that ended longer ago than the
+// retention of the time-series mode are deleted. It returns the tables deleted.
+func (db *DB) ApplyRetention() (dropped []string, err error) {
+	dropped = make([]string, 0)
+	if db.timeSeries == nil || db.timeSeries.policy.Retention <= 0 {
+		return dropped, nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkWritable(); err != nil {
+		return dropped, err
+	}
+
+	windows, err := db.tableWindows()
+	if err != nil {
+		return dropped, err
+	}
+	for _, window := range windows {
+		if window == mixedWindow {
+			// Split the tables by window first
+			if err := db.compactAll(); err != nil {
+				return dropped, err
+			}
+			if windows, err = db.tableWindows(); err != nil {
+				return dropped, err
+			}
+			break
+		}
+	}
+
+	cutoff := time.Now().Add(-db.timeSeries.policy.Retention).UnixNano()
+	kept := make([]string, 0, len(db.SSTableIDs))
+	for i, sstableID := range db.SSTableIDs {
+		if windows[i] >= 0 && windows[i]+int64(db.timeSeries.policy.Window) <= cutoff {
+			dropped = append(dropped, sstableID)
+		} else {
+			kept = append(kept, sstableID)
+		}
+	}
+	if len(dropped) == 0 {
+		return dropped, nil
+	}
+	event := Event{Type: EventRetention, Start: time.Now(), Inputs: dropped, InputBytes: filesSize(db.fs, dropped), Outputs: make([]string, 0)}
+	defer func() { db.recordEvent(event, err) }()
+
+	// The keys of the dropped tables leave the indexes, unless written again since
+	if db.indexes != nil || db.search != nil {
+		for _, sstableID := range dropped {
+			sst, err := db.readSSTable(sstableID)
+			if err != nil {
+				return dropped, err
+			}
+			for _, kv := range sst.KeyValues {
+				if _, ok := db.data[string(kv.Key)]; !ok {
+					db.indexes.remove(string(kv.Key))
+					db.search.remove(string(kv.Key))
+				}
+			}
+		}
+	}
+	db.SSTableIDs = kept
+	db.sequence++
+	db.values.clear()
+	if err := db.logTables(); err != nil {
+		return dropped, err
+	}
+	for _, sstableID := range dropped {
+		db.readers.evict(sstableID)
+		delete(db.timeSeries.windows, sstableID)
+		if err := db.fs.Remove(sstableID); err != nil && !os.IsNotExist(err) {
+			return dropped, err
+		}
+	}
+	if db.search != nil && db.search.dir != "" {
+		if err := db.search.writeBase(db.fs, db.data, lastGeneration(db.SSTableIDs)); err != nil {
+			return dropped, err
+		}
+	}
+	if err := db.syncRemote(); err != nil {
+		return dropped, err
+	}
+
+	// Usage of the quota changed in ways that are simpler to recompute
+	if db.quota != nil {
+		used, err := db.computeUsage()
+		if err != nil {
+			return dropped, err
+		}
+		db.quota.used = used
+	}
+	return dropped, nil
+}
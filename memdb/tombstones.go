@@ -0,0 +1,69 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"encoding/binary"
+	"time"
+)
+
+// TombstoneGrace keeps the deletion markers for at least grace after they were flushed: the compactions that drop
+// them, by PeriodicCompaction and in time-series mode, keep the younger ones. Replicas lagging by less than grace,
+// backups and point-in-time recoveries then still see the deletions they missed instead of the values deleted,
+// at the cost of the room the markers take, reported by Stats. Markers hold the time of their flush once the option
+// is set; those flushed before, which don't, are dropped at the first chance, as they all are with 0, the default.
+func TombstoneGrace(grace time.Duration) Option {
+	return func(db *DB) {
+		db.gcGrace = grace
+	}
+}
+
+// stampTombstones records t, the time of the flush, as the value of the deletion markers of keyValues if a grace
+// period is set. Lookups and scans ignore the value of deletions.
+func (db *DB) stampTombstones(keyValues []sstable.KeyValuePair, t time.Time) {
+	if db.gcGrace <= 0 {
+		return
+	}
+	for i := range keyValues {
+		if keyValues[i].Operation == sstable.OpDel {
+			keyValues[i].Value = binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+		}
+	}
+}
+
+// tombstoneTime returns the time a deletion marker was flushed at, false if it was flushed without it
+func tombstoneTime(kv sstable.KeyValuePair) (time.Time, bool) {
+	if len(kv.Value) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(kv.Value))), true
+}
+
+// tombstoneFilter returns whether an entry of a merge is kept: the deletion markers flushed longer ago than the grace
+// period are dropped, and those flushed without their time, as range deletions are. It must only be used on a merge
+// holding every older version of the keys, see sstable.NewMergingIterator.
+func (db *DB) tombstoneFilter() func(kv sstable.KeyValuePair) (bool, error) {
+	horizon := time.Now().Add(-db.gcGrace)
+	return func(kv sstable.KeyValuePair) (bool, error) {
+		switch kv.Operation {
+		case sstable.OpDelRange:
+			return false, nil
+		case sstable.OpDel:
+			flushed, ok := tombstoneTime(kv)
+			return ok && flushed.After(horizon), nil
+		}
+		return true, nil
+	}
+}
+
+// mergeDroppingTombstones returns the function opening the merge of sstableIDs, oldest first, without the deletion
+// markers dropped by tombstoneFilter, for writeSSTablesFrom. sstableIDs must hold every older version of their keys.
+func (db *DB) mergeDroppingTombstones(sstableIDs []string) func() (sstable.Iterator, error) {
+	keep := db.tombstoneFilter()
+	return func() (sstable.Iterator, error) {
+		inputs, err := db.tableIterators(sstableIDs)
+		if err != nil {
+			return nil, err
+		}
+		return newFilterIterator(sstable.NewMergingIterator(inputs, false), keep), nil
+	}
+}
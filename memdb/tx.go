@@ -0,0 +1,228 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrTxConflict is returned by Commit when a key read by the transaction was written since
+var ErrTxConflict = errors.New("Transaction conflicts with a concurrent write")
+
+// ErrTxDone is returned when using a transaction after it was committed or rolled back
+var ErrTxDone = errors.New("Transaction already committed or rolled back")
+
+// txRead is a value read by a transaction, found is false if the key didn't exist
+type txRead struct {
+	value []byte
+	found bool
+}
+
+// Tx is a transaction with optimistic concurrency control: its reads go to the database, without taking a lock
+// between them, and its writes are buffered until Commit. Commit checks that none of the keys it read was written
+// since, then applies every write at once, or none of them. A Tx isn't safe for concurrent use.
+type Tx struct {
+	db       *DB
+	sequence uint64                  // Sequence of the database when the transaction began
+	reads    map[string]txRead       // Values read from the database, by key
+	writes   map[string]sstable.Pair // Buffered writes, a marker for a deletion
+	done     bool
+}
+
+// BeginTx starts a transaction, to read keys and write new values computed from them safely: if another write
+// changed a key read before the transaction commits, Commit fails with ErrTxConflict and writes nothing, and the
+// transaction can be run again from BeginTx.
+func (db *DB) BeginTx() *Tx {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return &Tx{db: db, sequence: db.sequence, reads: make(map[string]txRead), writes: make(map[string]sstable.Pair)}
+}
+
+// Get returns the value of key as the transaction sees it: its own write of the key if any, else the value read
+// from the database the first time, so that reading a key twice returns the same value. It returns ErrKeyNotFound
+// like DB.Get.
+func (tx *Tx) Get(key string) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	key, err := tx.db.ValidateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if pair, ok := tx.writes[key]; ok {
+		if pair.Marker {
+			return nil, ErrKeyNotFound
+		}
+		return pair.Value, nil
+	}
+	read, ok := tx.reads[key]
+	if !ok {
+		value, err := tx.db.Get(key)
+		if err != nil && err != ErrKeyNotFound {
+			return nil, err
+		}
+		read = txRead{value: value, found: err == nil}
+		tx.reads[key] = read
+	}
+	if !read.found {
+		return nil, ErrKeyNotFound
+	}
+	return read.value, nil
+}
+
+// Set buffers the write of value for key, applied by Commit
+func (tx *Tx) Set(key string, value []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	key, err := tx.db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := tx.db.checkSize(key, value); err != nil {
+		return err
+	}
+	tx.writes[key] = sstable.Pair{Value: value, Marker: false}
+	return nil
+}
+
+// Delete buffers the deletion of key, applied by Commit. Deleting a missing key is not an error.
+func (tx *Tx) Delete(key string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	key, err := tx.db.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := tx.db.checkSize(key, nil); err != nil {
+		return err
+	}
+	tx.writes[key] = sstable.Pair{Value: nil, Marker: true}
+	return nil
+}
+
+// Rollback discards the transaction and its writes. It may be called after Commit, doing nothing then.
+func (tx *Tx) Rollback() {
+	tx.done = true
+	tx.reads, tx.writes = nil, nil
+}
+
+// Commit applies the writes of the transaction, if none of the keys it read was written since it read them, and
+// returns ErrTxConflict otherwise, writing nothing. The writes are logged to the WAL as a single record, so that a
+// crash never leaves part of them, and become visible to readers all at once. A key read then written back with the
+// same value by another writer in between isn't a conflict. The transaction can't be used afterwards, whether it
+// committed or not.
+func (tx *Tx) Commit() error {
+	return tx.CommitWith(WriteOptions{})
+}
+
+// CommitWith commits the transaction like Commit, with the durability of opts, see SetWith
+func (tx *Tx) CommitWith(opts WriteOptions) (err error) {
+	if tx.done {
+		return ErrTxDone
+	}
+	db := tx.db
+	if db.recorder != nil {
+		defer db.recorder.record(tx.recordedOp(), time.Now(), &err)
+	}
+	defer tx.Rollback()
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	if len(tx.writes) == 0 {
+		return nil
+	}
+	return db.syncWAL(opts)
+}
+
+// commit implements CommitWith
+func (tx *Tx) commit() error {
+	db := tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkClientWrite(); err != nil {
+		return err
+	}
+
+	// 0 - Check the reads, unless nothing was written at all since the transaction began
+	if db.sequence != tx.sequence {
+		for key, read := range tx.reads {
+			current, err := db.get(key)
+			if err == nil {
+				current, err = db.resolveValue(key, current)
+			}
+			if err != nil && err != ErrKeyNotFound {
+				return err
+			}
+			if found := err == nil; found != read.found || !bytes.Equal(current, read.value) {
+				return ErrTxConflict
+			}
+		}
+	}
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	// 1 - Make sure the writes fit on disk and in the quota, as a whole
+	if err := db.checkDisk(); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(tx.writes))
+	for key := range tx.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var delta usage
+	if db.quota != nil {
+		for _, key := range keys {
+			keyDelta, err := db.writeDelta(key, tx.writes[key])
+			if err != nil {
+				return err
+			}
+			delta.keys += keyDelta.keys
+			delta.bytes += keyDelta.bytes
+		}
+		if err := db.quota.check(delta); err != nil {
+			return err
+		}
+	}
+
+	// 2 - Write every write to the WAL as one record, then to the memtable
+	records := make([]WALRecord, len(keys))
+	for i, key := range keys {
+		records[i] = WALRecord{Operation: OpSet, Key: []byte(key), Value: tx.writes[key].Value}
+		if tx.writes[key].Marker {
+			records[i].Operation = OpDel
+		}
+	}
+	if err := db.wal.WriteEntry(encodeBatch(records)); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		db.recordAccess(key, true)
+		db.putMemtable(key, tx.writes[key])
+	}
+	db.quota.apply(delta)
+
+	// 3 - Flush once the memtable is over the threshold, like Set
+	if len(db.keys)+len(db.ranges) >= db.threshold {
+		return db.flushLocked()
+	}
+	return nil
+}
+
+// recordedOp returns the operation recording the commit of the transaction: its writes, its reads aren't recorded
+func (tx *Tx) recordedOp() RecordedOp {
+	op := RecordedOp{Op: RecordCommit}
+	for key, pair := range tx.writes {
+		if pair.Marker {
+			op.Keys = append(op.Keys, key)
+		} else {
+			op.KVs = append(op.KVs, KeyValue{Key: key, Value: pair.Value})
+		}
+	}
+	return op
+}
@@ -0,0 +1,128 @@
+package memdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// CacheStats describes the use of the value cache
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`    // Size of the cached keys and values
+	Capacity  int64  `json:"capacity"` // Bytes cached at most
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"` // Entries dropped to make room for newer ones
+}
+
+// ValueCache keeps up to size bytes of the values recently read from the SSTables in memory, so that reading
+// a hot key again doesn't look it up in the tables. The least recently read values are dropped first.
+// A write to a key drops its cached value, the cache never returns a stale one. 0 disables the cache.
+func ValueCache(size int64) Option {
+	return func(db *DB) {
+		if size > 0 {
+			db.values = &valueCache{capacity: size, entries: make(map[string]*list.Element), lru: list.New()}
+		}
+	}
+}
+
+// cachedValue is a key and its value, as found in the SSTables
+type cachedValue struct {
+	key   string
+	value []byte
+}
+
+// valueCache is an LRU cache of values read from the SSTables. A nil cache caches nothing.
+type valueCache struct {
+	mu        sync.Mutex
+	capacity  int64
+	size      int64
+	entries   map[string]*list.Element
+	lru       *list.List // Most recently used first
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// get returns the cached value of key
+func (c *valueCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedValue).value, true
+}
+
+// add caches the value of key read from the SSTables, unless it is larger than the whole cache
+func (c *valueCache) add(key string, value []byte) {
+	if c == nil || int64(len(key)+len(value)) > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return // Read concurrently by another lookup
+	}
+	c.entries[key] = c.lru.PushFront(&cachedValue{key: key, value: value})
+	c.size += int64(len(key) + len(value))
+	for c.size > c.capacity {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// invalidate drops the cached value of key, after a write to it
+func (c *valueCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// clear drops every cached value, after the SSTables changed in ways that aren't tracked key by key
+func (c *valueCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+// remove drops a cached value, the caller must hold c.mu
+func (c *valueCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cachedValue)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.key) + len(entry.value))
+}
+
+// stats returns the use of the cache, nil if it is disabled
+func (c *valueCache) stats() *CacheStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &CacheStats{
+		Entries:   len(c.entries),
+		Bytes:     c.size,
+		Capacity:  c.capacity,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
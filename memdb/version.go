@@ -0,0 +1,86 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+const (
+	// EngineVersion is the release of the storage engine
+	EngineVersion = "0.2.0"
+	// WALFormatVersion is the version of the WAL format written by this package
+	WALFormatVersion = 2
+)
+
+// BuildCommit is the commit the binary was built from, set at link time with
+// -ldflags "-X StorageEngine/memdb.BuildCommit=$(git rev-parse HEAD)".
+// When empty, the revision recorded by the Go toolchain is used if any.
+var BuildCommit = ""
+
+// BuildInfo describes the running engine and the formats it supports
+type BuildInfo struct {
+	Version                 string   `json:"version"`
+	Commit                  string   `json:"commit"`
+	GoVersion               string   `json:"go_version"`
+	WALFormat               int      `json:"wal_format"`
+	SSTableFormat           uint16   `json:"sstable_format"`            // Format of the SSTables written
+	SupportedSSTableFormats []uint16 `json:"supported_sstable_formats"` // Formats of the SSTables that can be read
+}
+
+// FormatReport describes the on-disk formats used by an open database
+type FormatReport struct {
+	WALVersion      int            `json:"wal_version"`
+	SSTableVersions map[uint16]int `json:"sstable_versions"` // Number of live SSTables per format version
+}
+
+// Version returns the release of the storage engine
+func Version() string {
+	return EngineVersion
+}
+
+// Build returns the version, commit and supported formats of the engine
+func Build() BuildInfo {
+	info := BuildInfo{
+		Version:                 EngineVersion,
+		Commit:                  BuildCommit,
+		GoVersion:               runtime.Version(),
+		WALFormat:               WALFormatVersion,
+		SSTableFormat:           sstable.CurrentVersion,
+		SupportedSSTableFormats: append([]uint16(nil), sstable.SupportedVersions...),
+	}
+	if info.Commit == "" {
+		if buildInfo, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range buildInfo.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	sort.Slice(info.SupportedSSTableFormats, func(i, j int) bool {
+		return info.SupportedSSTableFormats[i] < info.SupportedSSTableFormats[j]
+	})
+	return info
+}
+
+// FormatVersions reports the format versions of the WAL and of the live SSTables,
+// to check whether a migration is needed before an upgrade
+func (db *DB) FormatVersions() (FormatReport, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	report := FormatReport{WALVersion: WALFormatVersion, SSTableVersions: make(map[uint16]int)}
+	for _, sstableID := range db.SSTableIDs {
+		sst, err := db.readSSTable(sstableID)
+		if err != nil {
+			return FormatReport{}, err
+		}
+		report.SSTableVersions[sst.Header.Version]++
+	}
+	return report, nil
+}
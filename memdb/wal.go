@@ -1,7 +1,10 @@
 package memdb
 
 import (
+	"StorageEngine/vfs"
+	"bufio"
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"os"
 	"sync"
@@ -11,9 +14,16 @@ const (
 	// WALFilePermission represents the file permission for the WAL file.
 	WALFilePermission = 0744
 	// WALRecordHeaderSize represents the size of the WAL record header.
-	WALRecordHeaderSize = 1 + 4 + 4 // Operation(1 byte) + KeyLength(4 bytes) + ValueLength(4 bytes)
+	WALRecordHeaderSize = 1 + 4 + 4 + 4 // Operation(1 byte) + KeyLength(4 bytes) + ValueLength(4 bytes) + CRC32(4 bytes)
 	// WALMetadataSize represents the size of the metadata in the WAL file.
 	WALMetadataSize = 16 // Size of offset then size of watermark (8 bytes each)
+
+	// walBufferSize is the size of the buffer WAL records are appended through
+	walBufferSize = 64 << 10
+	// walV1HeaderSize is the size of the header of the records written before version 2 of the format, without a CRC
+	walV1HeaderSize = 1 + 4 + 4
+	// walChecksummed is set in the operation byte of the records followed by a CRC, from version 2 of the format
+	walChecksummed = 0x80
 )
 
 // WALMetadata represents the metadata to be stored in the WAL file (watermark and offset)
@@ -25,8 +35,13 @@ type WALMetadata struct {
 // WAL represents the Write-Ahead Log.
 type WAL struct {
 	MetaData WALMetadata
-	file     *os.File
+	file     vfs.File
+	fs       vfs.FS    // File system of the WAL, used by the DB for its SSTables
+	lock     io.Closer // Lock of the WAL file, nil if read-only
 	mu       sync.Mutex
+	w        *bufio.Writer         // Appends the records to the file, positioned at the offset
+	metaBuf  [WALMetadataSize]byte // Encoded metadata, reused by every write
+	readOnly bool                  // Opened by OpenWALReadOnly, nothing is ever written
 }
 
 // Operation represents the type of operation in the WAL.
@@ -35,6 +50,8 @@ type Operation uint8
 const (
 	OpSet Operation = iota
 	OpDel
+	OpDelRange // Deletes the keys from Key up to Value, see DB.DeleteRange
+	OpBatch    // Holds the records of a transaction in Value, applied together, see DecodeBatch
 )
 
 // WALRecord represents an entry in the WAL.
@@ -44,127 +61,370 @@ type WALRecord struct {
 	Value     []byte
 }
 
+// recordSize returns the size of a record in the WAL file, header included
+func recordSize(record WALRecord) int64 {
+	return int64(WALRecordHeaderSize + len(record.Key) + len(record.Value))
+}
+
+// encodeBatch returns the OpBatch record holding records, which a crash never leaves half written as its checksum
+// covers them all. Its key is the key of the first record, and its value the records one after the other, each as
+// its operation, the lengths of its key and value on 4 bytes, then its key and value.
+func encodeBatch(records []WALRecord) WALRecord {
+	size := 0
+	for _, record := range records {
+		size += walV1HeaderSize + len(record.Key) + len(record.Value)
+	}
+	value := make([]byte, 0, size)
+	for _, record := range records {
+		value = append(value, byte(record.Operation))
+		value = binary.BigEndian.AppendUint32(value, uint32(len(record.Key)))
+		value = binary.BigEndian.AppendUint32(value, uint32(len(record.Value)))
+		value = append(append(value, record.Key...), record.Value...)
+	}
+	return WALRecord{Operation: OpBatch, Key: records[0].Key, Value: value}
+}
+
+// DecodeBatch returns the records held by an OpBatch record, or ErrCorruptedWALRecord if its value doesn't decode.
+// Records of other operations are returned as they are.
+func DecodeBatch(record WALRecord) ([]WALRecord, error) {
+	if record.Operation != OpBatch {
+		return []WALRecord{record}, nil
+	}
+	var records []WALRecord
+	for data := record.Value; len(data) > 0; {
+		if len(data) < walV1HeaderSize {
+			return nil, ErrCorruptedWALRecord
+		}
+		op := Operation(data[0])
+		keyLen := int64(binary.BigEndian.Uint32(data[1:5]))
+		valueLen := int64(binary.BigEndian.Uint32(data[5:9]))
+		data = data[walV1HeaderSize:]
+		if op > OpDelRange || keyLen == 0 || keyLen+valueLen > int64(len(data)) {
+			return nil, ErrCorruptedWALRecord
+		}
+		records = append(records, WALRecord{Operation: op, Key: data[:keyLen], Value: data[keyLen : keyLen+valueLen]})
+		data = data[keyLen+valueLen:]
+	}
+	return records, nil
+}
+
+// appendRecordHeader appends the header of a record to data: its operation, the lengths of its key and value, then
+// the CRC32 of those and of the key and value, so that a record torn by a crash or corrupted on disk is detected
+// instead of being replayed
+func appendRecordHeader(data []byte, record WALRecord) []byte {
+	start := len(data)
+	data = append(data, byte(record.Operation)|walChecksummed)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(record.Key)))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(record.Value)))
+	crc := crc32.ChecksumIEEE(data[start:])
+	crc = crc32.Update(crc, crc32.IEEETable, record.Key)
+	crc = crc32.Update(crc, crc32.IEEETable, record.Value)
+	return binary.BigEndian.AppendUint32(data, crc)
+}
+
+// recordHeader is the decoded header of a WAL record
+type recordHeader struct {
+	op       Operation
+	keyLen   int64
+	valueLen int64
+	size     int64  // Bytes of the header, walV1HeaderSize for the records without a CRC
+	checksum uint32 // CRC32 of the header before it and of the key and value
+	crc      uint32 // CRC32 of the header before the checksum, where the one of the key and value starts
+}
+
+// parseRecordHeader decodes the header of a record at the start of data, ok is false if data ends before it
+func parseRecordHeader(data []byte) (h recordHeader, ok bool) {
+	if len(data) < walV1HeaderSize {
+		return h, false
+	}
+	h = recordHeader{
+		op:       Operation(data[0] &^ walChecksummed),
+		keyLen:   int64(binary.BigEndian.Uint32(data[1:5])),
+		valueLen: int64(binary.BigEndian.Uint32(data[5:9])),
+		size:     walV1HeaderSize,
+	}
+	if data[0]&walChecksummed != 0 {
+		if len(data) < WALRecordHeaderSize {
+			return h, false
+		}
+		h.size = WALRecordHeaderSize
+		h.checksum = binary.BigEndian.Uint32(data[9:13])
+		h.crc = crc32.ChecksumIEEE(data[:walV1HeaderSize])
+	}
+	return h, true
+}
+
+// recordSize returns the size of the record, header included
+func (h recordHeader) recordSize() int64 {
+	return h.size + h.keyLen + h.valueLen
+}
+
+// valid reports whether the header can be the one of a record: a known operation on a key
+func (h recordHeader) valid() bool {
+	return h.op <= OpBatch && h.keyLen > 0
+}
+
+// verify reports whether the key and value of the record, data, match its checksum. The records written before
+// version 2 of the format have none.
+func (h recordHeader) verify(data []byte) bool {
+	return h.size == walV1HeaderSize || crc32.Update(h.crc, crc32.IEEETable, data) == h.checksum
+}
+
 // OpenWAL opens or creates a WAL file.
 func OpenWAL(filePath string) (*WAL, error) {
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, WALFilePermission)
+	return OpenWALFS(vfs.OS, filePath)
+}
+
+// OpenWALFS opens or creates a WAL file of fsys. A DB opened on the WAL keeps its SSTables in fsys too.
+func OpenWALFS(fsys vfs.FS, filePath string) (*WAL, error) {
+	// Only one process may write the WAL, the lock is released by Close
+	lock, err := lockPath(fsys, filePath)
 	if err != nil {
 		return nil, err
 	}
+	file, err := fsys.OpenFile(filePath, os.O_CREATE|os.O_RDWR, WALFilePermission)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
 
 	wal := &WAL{
 		MetaData: WALMetadata{},
 		file:     file,
+		fs:       fsys,
+		lock:     lock,
+		w:        bufio.NewWriterSize(file, walBufferSize),
 	}
 
 	// Read the metadata if it exists
 	err = wal.readMetadata()
-	if err != nil {
-		return nil, err
+	if err == nil {
+		err = wal.findEnd()
+	}
+	if err == nil {
+		// If the file is created for the first time, we write to the file the metadata: watermark=0 and offset=0
+		err = wal.writeMetadata()
 	}
-	// If the file is created for the first time, we write to the file the metadata: watermark=0 and offset=0
-	err = wal.writeMetadata()
 	if err != nil {
+		file.Close()
+		lock.Close()
 		return nil, err
 	}
 
 	return wal, nil
 }
 
-// WriteEntry writes a WAL record to the WAL file.
+// WriteEntry appends a WAL record to the WAL file with a single write, at the end of the file.
+// The metadata isn't written along: the offset is found again from the records when the WAL is opened, so it is
+// only written when the memtable is flushed, by Sync and by Close.
 func (wal *WAL) WriteEntry(record WALRecord) error {
 	wal.mu.Lock()
 	defer wal.mu.Unlock()
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+	if err := wal.bufferRecord(record); err != nil {
+		return wal.discard(err)
+	}
+	return wal.commit(recordSize(record))
+}
 
-	// Prepare the record
-	header := make([]byte, WALRecordHeaderSize)
-	keyLen := uint32(len(record.Key))
-	valueLen := uint32(len(record.Value))
-	header[0] = byte(record.Operation)
-	binary.BigEndian.PutUint32(header[1:5], keyLen)
-	binary.BigEndian.PutUint32(header[5:9], valueLen)
-
-	// Calculate the size of the written record
-	recordSize := int64(WALRecordHeaderSize + len(record.Key) + len(record.Value))
+// WriteEntries appends records to the WAL file like WriteEntry, with a single write for as many of them as the
+// buffer holds. A failed write leaves none of them in the WAL.
+func (wal *WAL) WriteEntries(records []WALRecord) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+	size := int64(0)
+	for _, record := range records {
+		if err := wal.bufferRecord(record); err != nil {
+			return wal.discard(err)
+		}
+		size += recordSize(record)
+	}
+	return wal.commit(size)
+}
 
-	// Seek to the correct offset before writing
-	_, err := wal.file.Seek(wal.MetaData.Offset, io.SeekStart)
-	if err != nil {
+// bufferRecord encodes a record in the buffer of the writer, which writes it to the file once full.
+// The caller must hold the lock.
+func (wal *WAL) bufferRecord(record WALRecord) error {
+	if _, err := wal.w.Write(appendRecordHeader(wal.w.AvailableBuffer(), record)); err != nil {
 		return err
 	}
-
-	// Write record header and content
-	_, err = wal.file.Write(header)
-	if err != nil {
+	if _, err := wal.w.Write(record.Key); err != nil {
 		return err
 	}
-	_, err = wal.file.Write(record.Key)
-	if err != nil {
+	_, err := wal.w.Write(record.Value)
+	return err
+}
+
+// commit writes the buffered records to the file and moves the offset past them, size bytes further, where the
+// position of the file now is. The caller must hold the lock.
+func (wal *WAL) commit(size int64) error {
+	if err := wal.w.Flush(); err != nil {
+		return wal.discard(err)
+	}
+	wal.MetaData.Offset += size
+	return nil
+}
+
+// discard drops the records written since the last commit after a failed write, from the buffer and from the file,
+// so that no record follows a partial one, and returns err. The caller must hold the lock.
+func (wal *WAL) discard(err error) error {
+	wal.w.Reset(wal.file)
+	if truncErr := wal.file.Truncate(wal.MetaData.Offset); truncErr != nil {
 		return err
 	}
-	_, err = wal.file.Write(record.Value)
+	wal.file.Seek(wal.MetaData.Offset, io.SeekStart)
+	return err
+}
+
+// findEnd moves the offset past the records appended since the metadata was written, and cuts the file after them:
+// a record torn by a crash would otherwise be followed by the next ones. The records not flushed yet are checked
+// against their checksums, and the file is cut at the first one that doesn't match too, even below the stored
+// offset, so that recovery replays the intact records before it instead of garbage or nothing at all.
+// The caller must hold the lock or own the WAL.
+func (wal *WAL) findEnd() error {
+	fileInfo, err := wal.file.Stat()
 	if err != nil {
 		return err
 	}
-
-	// Update the offset to where the next record should be written
-	wal.MetaData.Offset += recordSize
-	err = wal.writeMetadata()
+	// A Reset interrupted once the records were cut leaves a watermark past the end of the file: every record was
+	// flushed, and the WAL starts over
+	if size := fileInfo.Size(); size >= WALMetadataSize && wal.MetaData.Watermark > size {
+		wal.MetaData.Offset, wal.MetaData.Watermark = size, size
+	}
+	meta, err := scanRecords(wal.file, fileInfo.Size(), wal.MetaData, wal.MetaData.Watermark, nil, true)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	wal.MetaData.Offset = meta.Offset
+	if fileInfo.Size() > meta.Offset {
+		if err := wal.file.Truncate(meta.Offset); err != nil {
+			return err
+		}
+	}
+	_, err = wal.file.Seek(meta.Offset, io.SeekStart)
+	return err
 }
 
 // ReadNextEntry reads the next WAL record from the WAL file
-// It returns a WALRecord containing the operation type, key, and value.
+// It returns a WALRecord containing the operation type, key, and value, or ErrCorruptedWALRecord if they don't
+// match the checksum of the record.
 // Finally, it updates the watermark to the current file position for the next read.
 func (wal *WAL) ReadNextEntry() (WALRecord, error) {
 	wal.mu.Lock()
 	defer wal.mu.Unlock()
 
-	_, err := wal.file.Seek(wal.MetaData.Watermark, io.SeekStart)
-	if err != nil {
-		return WALRecord{}, err
-	}
-
+	// Records are read without moving the position of the file, where the next one is appended
+	reader := io.NewSectionReader(wal.file, wal.MetaData.Watermark, wal.MetaData.Offset-wal.MetaData.Watermark)
 	header := make([]byte, WALRecordHeaderSize)
-	_, err = io.ReadFull(wal.file, header)
-	if err != nil {
+	n, err := io.ReadFull(reader, header)
+	h, ok := parseRecordHeader(header[:n])
+	if !ok {
 		return WALRecord{}, err
 	}
 
-	op := Operation(header[0])
-	keyLen := binary.BigEndian.Uint32(header[1:5])
-	valueLen := binary.BigEndian.Uint32(header[5:9])
-
-	key := make([]byte, keyLen)
-	_, err = io.ReadFull(wal.file, key)
-	if err != nil {
-		return WALRecord{}, err
+	data := make([]byte, h.keyLen+h.valueLen)
+	_, err = reader.ReadAt(data, h.size)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
-
-	value := make([]byte, valueLen)
-	_, err = io.ReadFull(wal.file, value)
 	if err != nil {
 		return WALRecord{}, err
 	}
+	if !h.verify(data) {
+		return WALRecord{}, ErrCorruptedWALRecord
+	}
 
 	// Update the offset for the next read
-	wal.MetaData.Watermark, _ = wal.file.Seek(0, io.SeekCurrent)
+	wal.MetaData.Watermark += h.recordSize()
 	err = wal.writeMetadata()
 	if err != nil {
 		return WALRecord{}, err
 	}
 
-	return WALRecord{Operation: op, Key: key, Value: value}, nil
+	return WALRecord{Operation: h.op, Key: data[:h.keyLen], Value: data[h.keyLen:]}, nil
+}
+
+// markFlushed moves the watermark to the offset, once every record written so far is in an SSTable
+func (wal *WAL) markFlushed() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+
+	wal.MetaData.Watermark = wal.MetaData.Offset
+	return wal.writeMetadata()
+}
+
+// Reset truncates the WAL to its metadata, discarding every record.
+// Both the offset and the watermark are moved back to the first record position. The records must all be marked
+// flushed: a crash before the metadata is written is then recovered when the WAL is opened.
+func (wal *WAL) Reset() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := wal.file.Truncate(WALMetadataSize); err != nil {
+		return err
+	}
+	if _, err := wal.file.Seek(WALMetadataSize, io.SeekStart); err != nil {
+		return err
+	}
+	wal.MetaData.Offset = int64(WALMetadataSize)
+	wal.MetaData.Watermark = int64(WALMetadataSize)
+	if err := wal.writeMetadata(); err != nil {
+		return err
+	}
+	return wal.file.Sync()
+}
+
+// Sync writes the metadata and flushes the WAL file to stable storage, so that the records written so far survive
+// a power loss, not only a crash of the process
+func (wal *WAL) Sync() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+	if err := wal.writeMetadata(); err != nil {
+		return err
+	}
+	return wal.file.Sync()
+}
+
+// Offset returns the position the next record will be written at, the end of the records written so far
+func (wal *WAL) Offset() int64 {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	return wal.MetaData.Offset
+}
+
+// Size returns the size of the WAL file in bytes, metadata included.
+func (wal *WAL) Size() (int64, error) {
+	fileInfo, err := wal.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fileInfo.Size(), nil
 }
 
 // Close closes the WAL file.
 func (wal *WAL) Close() error {
+	if wal.readOnly {
+		return wal.file.Close()
+	}
+	defer wal.lock.Close()
 	// Write metadata to the WAL file before closing
 	err := wal.writeMetadata()
 	if err != nil {
+		wal.file.Close()
 		return err
 	}
 	return wal.file.Close()
@@ -172,7 +432,7 @@ func (wal *WAL) Close() error {
 
 // writeMetadata writes metadata (offset and watermark) to the WAL file.
 func (wal *WAL) writeMetadata() error {
-	meta := make([]byte, WALMetadataSize)
+	meta := wal.metaBuf[:]
 	binary.BigEndian.PutUint64(meta[0:8], uint64(wal.MetaData.Offset))
 	binary.BigEndian.PutUint64(meta[8:16], uint64(wal.MetaData.Watermark))
 
@@ -0,0 +1,135 @@
+package memdb
+
+import (
+	"StorageEngine/vfs"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrTruncatedWAL is returned when a WAL record extends past the end of the file
+var ErrTruncatedWAL = errors.New("WAL record extends past the end of the file")
+
+// ErrCorruptedWALRecord is returned when the key and value of a WAL record don't match its checksum
+var ErrCorruptedWALRecord = errors.New("WAL record doesn't match its checksum")
+
+// WALEntry is a record read by ScanWALFile, with its position in the file
+type WALEntry struct {
+	WALRecord
+	Seq      int64 // Index of the record in the file, starting at 0
+	Position int64 // Offset of the record header in the file
+	Size     int64 // Size of the record, header included
+	Flushed  bool  // Whether the record is below the watermark, i.e. already in an SSTable
+}
+
+// ScanWALFile calls fn with every record of the WAL file at filePath, from the first one up to the last complete
+// record, and returns the metadata. The offset stored in the metadata is only written at flushes and syncs, so the
+// records appended after it are scanned too, up to a record cut short by a crash, and the offset returned is
+// the end of the last one. Records whose checksum doesn't match are reported with ErrCorruptedWALRecord, or end the
+// scan past the stored offset, like records cut short. The file is opened read-only: unlike ReadNextEntry, scanning leaves the watermark
+// untouched, so it is safe on the WAL of a stopped database. Scanning stops at the first error returned by fn.
+func ScanWALFile(filePath string, fn func(WALEntry) error) (WALMetadata, error) {
+	return ScanWALFileFrom(filePath, WALMetadataSize, fn)
+}
+
+// ScanWALFileFrom scans the WAL file like ScanWALFile, starting with the record at position instead of the first one.
+// position must be the start of a record, e.g. the Position plus the Size of an entry from an earlier scan;
+// Seq then counts the records from there.
+func ScanWALFileFrom(filePath string, position int64, fn func(WALEntry) error) (WALMetadata, error) {
+	return scanWALFile(vfs.OS, filePath, position, fn)
+}
+
+// scanWALFile scans a WAL file of fsys like ScanWALFileFrom
+func scanWALFile(fsys vfs.FS, filePath string, position int64, fn func(WALEntry) error) (WALMetadata, error) {
+	file, err := vfs.Open(fsys, filePath)
+	if err != nil {
+		return WALMetadata{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return WALMetadata{}, err
+	}
+	meta := WALMetadata{Offset: WALMetadataSize, Watermark: WALMetadataSize}
+	if fileInfo.Size() >= WALMetadataSize {
+		buf := make([]byte, WALMetadataSize)
+		if _, err := file.ReadAt(buf, 0); err != nil {
+			return meta, err
+		}
+		meta.Offset = int64(binary.BigEndian.Uint64(buf[0:8]))
+		meta.Watermark = int64(binary.BigEndian.Uint64(buf[8:16]))
+	}
+
+	return scanRecords(file, fileInfo.Size(), meta, position, fn, false)
+}
+
+// scanRecords calls fn, unless nil, with the records of a WAL file of size bytes read through r, from the one at
+// position, and returns meta with the offset moved to the end of the last one. Below the offset of meta, a record
+// extending past the end of the file or not matching its checksum is an error; past it, scanning stops quietly at
+// the first record that is incomplete, corrupted or can't be one, which is where a crash stopped the appends after
+// the metadata was last written. With lenient, scanning stops quietly below the offset too, which is moved back to
+// the end of the last intact record: the records from the first bad one can't be told from garbage.
+func scanRecords(r io.ReaderAt, size int64, meta WALMetadata, position int64, fn func(WALEntry) error, lenient bool) (WALMetadata, error) {
+	reader := io.NewSectionReader(r, 0, size)
+	if position < WALMetadataSize {
+		position = WALMetadataSize
+	}
+	// A position past the stored offset was reached by an earlier scan of the records appended after it
+	if position > meta.Offset && position <= size {
+		meta.Offset = position
+	}
+	header := make([]byte, WALRecordHeaderSize)
+	for seq := int64(0); position < meta.Offset || position < size; seq++ {
+		appended := lenient || position >= meta.Offset
+		n, _ := reader.ReadAt(header, position)
+		h, ok := parseRecordHeader(header[:n])
+		if !ok {
+			if appended {
+				break
+			}
+			return meta, ErrTruncatedWAL
+		}
+		recordSize := h.recordSize()
+		if appended && (!h.valid() || position+recordSize > size) {
+			break
+		}
+		if position+recordSize > size {
+			return meta, ErrTruncatedWAL
+		}
+
+		// The key and value are only read to check them, or for fn
+		if fn != nil || h.size == WALRecordHeaderSize {
+			data := make([]byte, h.keyLen+h.valueLen)
+			if _, err := reader.ReadAt(data, position+h.size); err != nil {
+				return meta, err
+			}
+			if !h.verify(data) {
+				if appended {
+					break
+				}
+				return meta, ErrCorruptedWALRecord
+			}
+			if fn != nil {
+				entry := WALEntry{
+					WALRecord: WALRecord{Operation: h.op, Key: data[:h.keyLen], Value: data[h.keyLen:]},
+					Seq:       seq,
+					Position:  position,
+					Size:      recordSize,
+					Flushed:   position < meta.Watermark,
+				}
+				if err := fn(entry); err != nil {
+					return meta, err
+				}
+			}
+		}
+		position += recordSize
+		if position > meta.Offset {
+			meta.Offset = position
+		}
+	}
+	if lenient && position < meta.Offset {
+		meta.Offset = position
+	}
+	return meta, nil
+}
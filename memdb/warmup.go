@@ -0,0 +1,99 @@
+package memdb
+
+import (
+	"StorageEngine/sstable"
+	"os"
+	"sync"
+	"time"
+)
+
+// Warmup opens the reader of every SSTable in the background after the database is opened, with workers tables
+// read at the same time, so that the first lookups don't have to read a whole table. Opening the database doesn't wait for the warmup, which is recorded
+// as an EventWarmup event. 0 disables the warmup.
+func Warmup(workers int) Option {
+	return func(db *DB) {
+		if workers > 0 {
+			db.warmer = &warmer{workers: workers, done: make(chan struct{})}
+		}
+	}
+}
+
+// warmer reads the SSTables once in the background
+type warmer struct {
+	workers int
+	done    chan struct{} // Closed once the warmup is over
+}
+
+// WarmupDone returns a channel closed once the warmup is over, or nil if the Warmup option isn't set
+func (db *DB) WarmupDone() <-chan struct{} {
+	if db.warmer == nil {
+		return nil
+	}
+	return db.warmer.done
+}
+
+// warmup opens the readers of the SSTables with the workers of the warmer, stopping early if the database is closed.
+// Tables are read without holding the database lock so reads and writes aren't blocked.
+func (db *DB) warmup() {
+	defer db.background.Done()
+	defer close(db.warmer.done)
+
+	db.mu.RLock()
+	sstableIDs := append([]string(nil), db.SSTableIDs...)
+	db.mu.RUnlock()
+
+	event := Event{Type: EventWarmup, Start: time.Now(), Inputs: sstableIDs, InputBytes: filesSize(db.fs, sstableIDs)}
+	tables := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for i := 0; i < db.warmer.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sstableID := range tables {
+				reader, err := sstable.OpenReaderFS(db.fs, sstableID)
+				entries := 0
+				if err == nil {
+					entries = db.keepReader(sstableID, reader)
+				}
+				mu.Lock()
+				if os.IsNotExist(err) {
+					err = nil // In the cold tier or removed by a compaction in the meantime, nothing to warm up
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err // Left to the scrubber and to reads, the warmup goes on
+				}
+				event.Entries += entries
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, sstableID := range sstableIDs {
+		select {
+		case <-db.closing:
+			break feed
+		case tables <- sstableID:
+		}
+	}
+	close(tables)
+	wg.Wait()
+	db.recordEvent(event, firstErr)
+}
+
+// keepReader caches the reader of an SSTable opened by the warmup and returns its number of entries, or closes it
+// and returns 0 if the table was replaced in the meantime
+func (db *DB) keepReader(sstableID string, reader *sstable.Reader) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, live := range db.SSTableIDs {
+		if live == sstableID {
+			db.readers.add(map[string]*sstable.Reader{sstableID: reader})
+			return reader.Len()
+		}
+	}
+	reader.Close()
+	return 0
+}
@@ -0,0 +1,152 @@
+package objstore
+
+import (
+	"container/list"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultBlockSize is the size of the blocks fetched and cached by a BlockCache when none is given
+	DefaultBlockSize = 64 * 1024
+	// DefaultCacheSize is the capacity in bytes of a BlockCache when none is given
+	DefaultCacheSize = 64 * 1024 * 1024
+)
+
+// blockKey identifies a block of an object
+type blockKey struct {
+	name  string
+	index int64
+}
+
+// block is a cached block, shorter than the block size at the end of an object
+type block struct {
+	key  blockKey
+	data []byte
+}
+
+// BlockCache keeps recently read blocks of remote objects in memory, so that lookups reading a few bytes of a
+// hot SSTable don't each cost a request. Objects are read in aligned blocks of a fixed size, and the least
+// recently used blocks are dropped once the cache holds more than its capacity. A BlockCache is safe for
+// concurrent use.
+type BlockCache struct {
+	mu        sync.Mutex
+	blockSize int64
+	capacity  int64
+	size      int64
+	blocks    map[blockKey]*list.Element
+	lru       *list.List // Most recently used first
+}
+
+// NewBlockCache returns a cache of capacity bytes fetching blocks of blockSize bytes, defaults if not positive
+func NewBlockCache(blockSize int64, capacity int64) *BlockCache {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	if capacity <= 0 {
+		capacity = DefaultCacheSize
+	}
+	return &BlockCache{blockSize: blockSize, capacity: capacity, blocks: make(map[blockKey]*list.Element), lru: list.New()}
+}
+
+// ReaderAt returns a reader of the object name of store, going through the cache.
+// The object must not change while cached, or be invalidated with Invalidate when it does.
+func (c *BlockCache) ReaderAt(store Store, name string) io.ReaderAt {
+	return &cachedObject{cache: c, store: store, name: name}
+}
+
+// Invalidate drops the cached blocks of an object
+func (c *BlockCache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.blocks {
+		if key.name == name {
+			c.remove(elem)
+		}
+	}
+}
+
+// Size returns the number of bytes cached
+func (c *BlockCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *BlockCache) get(key blockKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.blocks[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*block).data, true
+}
+
+func (c *BlockCache) add(key blockKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocks[key]; ok {
+		return // Fetched concurrently by another reader
+	}
+	c.blocks[key] = c.lru.PushFront(&block{key: key, data: data})
+	c.size += int64(len(data))
+	for c.size > c.capacity && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *BlockCache) remove(elem *list.Element) {
+	b := c.lru.Remove(elem).(*block)
+	delete(c.blocks, b.key)
+	c.size -= int64(len(b.data))
+}
+
+// cachedObject reads an object block by block through the cache
+type cachedObject struct {
+	cache *BlockCache
+	store Store
+	name  string
+}
+
+// block returns a block of the object, fetching it on a cache miss. The last block of the object is short.
+func (o *cachedObject) block(index int64) ([]byte, error) {
+	key := blockKey{name: o.name, index: index}
+	if data, ok := o.cache.get(key); ok {
+		return data, nil
+	}
+	data := make([]byte, o.cache.blockSize)
+	n, err := o.store.ReadAt(o.name, data, index*o.cache.blockSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = data[:n]
+	o.cache.add(key, data)
+	return data, nil
+}
+
+// Size returns the size of the object, asked to the store
+func (o *cachedObject) Size() (int64, error) {
+	return o.store.Size(o.name)
+}
+
+func (o *cachedObject) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for read < len(p) {
+		pos := off + int64(read)
+		data, err := o.block(pos / o.cache.blockSize)
+		if err != nil {
+			return read, err
+		}
+		start := pos % o.cache.blockSize
+		if start >= int64(len(data)) {
+			return read, io.EOF
+		}
+		read += copy(p[read:], data[start:])
+		if int64(len(data)) < o.cache.blockSize && read < len(p) {
+			return read, io.EOF // Past the last block
+		}
+	}
+	return read, nil
+}
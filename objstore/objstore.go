@@ -0,0 +1,169 @@
+// Package objstore stores files as named objects, in a local directory or in an S3-compatible bucket,
+// so that SSTables can live beyond the local disk of a node.
+package objstore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotExist is returned when an object doesn't exist
+var ErrNotExist = errors.New("Object does not exist")
+
+// Store holds objects identified by a name made of slash-separated segments
+type Store interface {
+	// Put stores size bytes read from r under name, replacing any existing object
+	Put(name string, r io.Reader, size int64) error
+	// Get returns the content of an object
+	Get(name string) (io.ReadCloser, error)
+	// ReadAt reads len(p) bytes of an object starting at off, like io.ReaderAt
+	ReadAt(name string, p []byte, off int64) (int, error)
+	// Size returns the size of an object
+	Size(name string) (int64, error)
+	// List returns the sorted names of the objects starting with prefix
+	List(prefix string) ([]string, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(name string) error
+}
+
+// PutFile stores the file at path under name
+func PutFile(store Store, name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(name, file, fileInfo.Size())
+}
+
+// GetFile writes the object name to a new file at path, through a temporary file so that an interrupted
+// download never leaves a partial file at path
+func GetFile(store Store, name string, path string) error {
+	r, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp := path + ".download"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Dir is a Store keeping objects as files under a directory, e.g. a network file system mount
+type Dir struct {
+	root string
+}
+
+// NewDir returns a Store over the directory root, created if needed
+func NewDir(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{root: root}, nil
+}
+
+func (d *Dir) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(name))
+}
+
+// notExist converts file system errors for a missing file to ErrNotExist
+func notExist(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+	return err
+}
+
+// Put writes the object to a temporary file renamed over the object, so readers never see a partial object
+func (d *Dir) Put(name string, r io.Reader, size int64) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, io.LimitReader(r, size))
+	if err == nil && written != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *Dir) Get(name string) (io.ReadCloser, error) {
+	file, err := os.Open(d.path(name))
+	return file, notExist(err)
+}
+
+func (d *Dir) ReadAt(name string, p []byte, off int64) (int, error) {
+	file, err := os.Open(d.path(name))
+	if err != nil {
+		return 0, notExist(err)
+	}
+	defer file.Close()
+	return file.ReadAt(p, off)
+}
+
+func (d *Dir) Size(name string) (int64, error) {
+	fileInfo, err := os.Stat(d.path(name))
+	if err != nil {
+		return 0, notExist(err)
+	}
+	return fileInfo.Size(), nil
+}
+
+func (d *Dir) List(prefix string) ([]string, error) {
+	names := make([]string, 0)
+	err := filepath.WalkDir(d.root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return err
+		}
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func (d *Dir) Delete(name string) error {
+	if err := os.Remove(d.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package objstore
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Open returns the store at location, for command-line tools:
+//   - s3://bucket/prefix?endpoint=https://host&region=r for an S3-compatible bucket, AWS S3 of the region if no
+//     endpoint is given
+//   - gs://bucket/prefix for a Google Cloud Storage bucket, through its XML API
+//   - file:///path or a plain path for a directory
+//
+// Credentials of buckets are read from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY, HMAC keys for GCS.
+func Open(location string) (Store, error) {
+	scheme, _, found := strings.Cut(location, "://")
+	if !found {
+		return NewDir(location)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	cfg := S3Config{
+		Endpoint:  u.Query().Get("endpoint"),
+		Region:    u.Query().Get("region"),
+		Bucket:    u.Host,
+		Prefix:    prefix,
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+
+	switch scheme {
+	case "file":
+		return NewDir(u.Path)
+	case "s3":
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = "https://s3.amazonaws.com"
+			if cfg.Region != "" {
+				cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+			}
+		}
+	case "gs":
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = "https://storage.googleapis.com"
+		}
+		if cfg.Region == "" {
+			cfg.Region = "auto"
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported object store %q, expected s3://, gs:// or file://", scheme+"://")
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("No bucket in %q", location)
+	}
+	return NewS3(cfg), nil
+}
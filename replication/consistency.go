@@ -0,0 +1,232 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsistencyHeader carries the consistency level of a request, reads are ReadStale and writes WriteAsync without it
+const ConsistencyHeader = "X-Consistency"
+
+// ReplicaIDHeader identifies the replica reading the WAL of the primary
+const ReplicaIDHeader = "X-Replica-ID"
+
+// Consistency levels of reads and writes
+const (
+	ReadStale  = "stale"  // Served by any node, a replica may not have applied the latest writes yet
+	ReadLeader = "leader" // Served by the leader, other nodes redirect the request to it
+	ReadQuorum = "quorum" // Served once the node applied every write the leader acknowledged before the request
+	WriteAsync = "async"  // Acknowledged once the leader wrote it, the replicas apply it later
+	WriteSync  = "sync"   // Acknowledged once the leader wrote it and enough replicas applied it
+)
+
+// DefaultSyncTimeout is the time a sync write waits for the replicas when none is configured
+const DefaultSyncTimeout = 5 * time.Second
+
+// AckTracker records how far each replica applied the WAL of the primary. A replica reading the records from
+// a position applied every record before it.
+type AckTracker struct {
+	mu        sync.Mutex
+	positions map[string]int64 // Position reached by each replica
+	changed   chan struct{}    // Closed when a position moves
+}
+
+// NewAckTracker returns a tracker no replica reported to yet
+func NewAckTracker() *AckTracker {
+	return &AckTracker{positions: make(map[string]int64), changed: make(chan struct{})}
+}
+
+// Observe records that replica applied the records before position
+func (t *AckTracker) Observe(replica string, position int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if position <= t.positions[replica] {
+		return
+	}
+	t.positions[replica] = position
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Acknowledged returns the number of replicas that applied the records before position
+func (t *AckTracker) Acknowledged(position int64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.acknowledged(position)
+}
+
+// acknowledged is Acknowledged for a caller holding t.mu
+func (t *AckTracker) acknowledged(position int64) int {
+	n := 0
+	for _, reached := range t.positions {
+		if reached >= position {
+			n++
+		}
+	}
+	return n
+}
+
+// Wait waits until replicas replicas applied the records before position, or ctx is done
+func (t *AckTracker) Wait(ctx context.Context, position int64, replicas int) error {
+	for {
+		t.mu.Lock()
+		n, changed := t.acknowledged(position), t.changed
+		t.mu.Unlock()
+		if n >= replicas {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// replicaID returns the ID a replica sent in ReplicaIDHeader, or its address
+func replicaID(r *http.Request) string {
+	if id := r.Header.Get(ReplicaIDHeader); id != "" {
+		return id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ConsistencyConfig describes the place of a node in the cluster
+type ConsistencyConfig struct {
+	Acks         *AckTracker   // Positions reached by the replicas of this node, sync writes are refused if nil
+	Position     func() int64  // Position in the WAL of this node after the writes done so far
+	Replica      *Replica      // Replica following the primary, nil on the primary
+	Failover     *Failover     // Election of the leader, nil if the primary always leads
+	SyncReplicas int           // Replicas that must apply a sync write, 1 if 0
+	SyncTimeout  time.Duration // Time a sync write waits for them, DefaultSyncTimeout if 0
+}
+
+// Consistency serves each request at the consistency level it asks for in ConsistencyHeader
+type Consistency struct {
+	cfg ConsistencyConfig
+}
+
+// NewConsistency returns the consistency levels of the node described by cfg
+func NewConsistency(cfg ConsistencyConfig) *Consistency {
+	if cfg.SyncReplicas <= 0 {
+		cfg.SyncReplicas = 1
+	}
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = DefaultSyncTimeout
+	}
+	return &Consistency{cfg: cfg}
+}
+
+// leader reports whether the node takes the writes, and the address of the leader otherwise, empty if unknown
+func (c *Consistency) leader() (bool, string) {
+	switch {
+	case c.cfg.Failover != nil:
+		return c.cfg.Failover.Role() == RoleLeader, c.cfg.Failover.Leader().Address
+	case c.cfg.Replica != nil:
+		return false, strings.TrimSuffix(c.cfg.Replica.cfg.Primary, "/")
+	}
+	return true, ""
+}
+
+// Handler wraps handler to serve the requests at their consistency level:
+//   - a leader read is redirected to the leader with 307 Temporary Redirect by the other nodes;
+//   - a quorum read on a replica first applies the records the primary wrote so far, it is redirected to the leader
+//     like a leader read on the other nodes that don't lead;
+//   - a sync write is acknowledged once SyncReplicas replicas applied it. If they don't within SyncTimeout,
+//     the answer is 202 Accepted: the leader wrote it, but it may be lost if the leader fails.
+//
+// Writes on a node that doesn't lead are left to handler, e.g. Failover.Redirect.
+// An unknown level is answered with 400 Bad Request.
+func (c *Consistency) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		level := r.Header.Get(ConsistencyHeader)
+		read := isRead(r)
+		if level == "" || strings.HasPrefix(r.URL.Path, "/replication/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if !validLevel(read, level) {
+			http.Error(w, fmt.Sprintf("Invalid consistency level %q", level), http.StatusBadRequest)
+			return
+		}
+		leads, address := c.leader()
+		switch {
+		case level == ReadStale || level == WriteAsync || (read && leads) || (!read && !leads):
+			handler.ServeHTTP(w, r)
+		case level == WriteSync:
+			c.serveSync(w, r, handler)
+		case level == ReadQuorum && c.cfg.Replica != nil && c.cfg.Failover == nil:
+			if _, err := c.cfg.Replica.Poll(); err != nil {
+				http.Error(w, "Can't catch up with the primary: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		case address == "":
+			http.Error(w, "No leader available", http.StatusServiceUnavailable)
+		default:
+			http.Redirect(w, r, address+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+		}
+	})
+}
+
+// validLevel reports whether level applies to a read, or to a write
+func validLevel(read bool, level string) bool {
+	if read {
+		return level == ReadStale || level == ReadLeader || level == ReadQuorum
+	}
+	return level == WriteAsync || level == WriteSync
+}
+
+// serveSync serves a sync write on the leader, holding the answer of handler until the replicas applied it
+func (c *Consistency) serveSync(w http.ResponseWriter, r *http.Request, handler http.Handler) {
+	if c.cfg.Acks == nil {
+		http.Error(w, "Sync writes need replicas", http.StatusBadRequest)
+		return
+	}
+	buffered := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	handler.ServeHTTP(buffered, r)
+
+	if buffered.status == http.StatusOK {
+		ctx, cancel := context.WithTimeout(r.Context(), c.cfg.SyncTimeout)
+		defer cancel()
+		position := c.cfg.Position()
+		if err := c.cfg.Acks.Wait(ctx, position, c.cfg.SyncReplicas); err != nil {
+			http.Error(w, fmt.Sprintf("Written, but applied by %d of %d replicas within %s", c.cfg.Acks.Acknowledged(position), c.cfg.SyncReplicas, c.cfg.SyncTimeout), http.StatusAccepted)
+			return
+		}
+	}
+	for name, values := range buffered.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(buffered.status)
+	w.Write(buffered.body.Bytes())
+}
+
+// bufferedResponse keeps the answer of a handler until it can be sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
@@ -0,0 +1,286 @@
+package replication
+
+import (
+	"StorageEngine/vfs"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is the validity of the leadership lease when none is configured
+const DefaultLeaseTTL = 10 * time.Second
+
+// Roles of a node taking part in the election
+const (
+	RoleCandidate = "candidate" // Not leading, the leader is healthy or unknown
+	RoleLeader    = "leader"    // Holds the lease and takes the writes
+	RoleFenced    = "fenced"    // Lost the lease it held, its data may miss the writes of the new leader
+)
+
+// Lease designates the leader, the node taking the writes, until it expires
+type Lease struct {
+	Holder  string    `json:"holder"`  // Node ID of the leader, empty if no node ever led
+	Address string    `json:"address"` // Base URL of the leader, where clients are redirected
+	Epoch   uint64    `json:"epoch"`   // Incremented with every new leader
+	Expires time.Time `json:"expires"` // The lease can be taken over after this time
+}
+
+// LeaseStore keeps the lease where every node can read and update it, e.g. a shared volume or a lock service
+type LeaseStore interface {
+	// Read returns the current lease, a zero Lease if none was ever written
+	Read() (Lease, error)
+	// CompareAndSwap replaces the lease with next if it is still current, and reports whether it did
+	CompareAndSwap(current Lease, next Lease) (bool, error)
+}
+
+// FileLeaseStore keeps the lease in a JSON file of a directory shared by the nodes. The updates are serialized
+// by a lock file created exclusively next to it, which is considered abandoned after StaleLock.
+type FileLeaseStore struct {
+	Path      string
+	StaleLock time.Duration // 10 seconds if 0
+}
+
+// Read returns the lease stored in the file
+func (s *FileLeaseStore) Read() (Lease, error) {
+	var lease Lease
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return lease, nil
+	}
+	if err != nil {
+		return lease, err
+	}
+	return lease, json.Unmarshal(data, &lease)
+}
+
+// CompareAndSwap writes next to the file if it still holds current
+func (s *FileLeaseStore) CompareAndSwap(current Lease, next Lease) (bool, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	stored, err := s.Read()
+	if err != nil {
+		return false, err
+	}
+	if !stored.Expires.Equal(current.Expires) || stored.Holder != current.Holder || stored.Epoch != current.Epoch {
+		return false, nil
+	}
+	data, err := json.Marshal(next)
+	if err != nil {
+		return false, err
+	}
+	return true, vfs.WriteFileAtomic(vfs.OS, s.Path, data, 0644)
+}
+
+// errLocked is returned when another node is updating the lease
+var errLocked = errors.New("Lease is being updated by another node")
+
+// lock creates the lock file, removing it first if it was abandoned
+func (s *FileLeaseStore) lock() (func(), error) {
+	staleLock := s.StaleLock
+	if staleLock <= 0 {
+		staleLock = 10 * time.Second
+	}
+	lockPath := s.Path + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		if fileInfo, statErr := os.Stat(lockPath); statErr == nil && time.Since(fileInfo.ModTime()) > staleLock {
+			os.Remove(lockPath)
+		}
+		return nil, errLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return func() { os.Remove(lockPath) }, nil
+}
+
+// FailoverConfig describes a node taking part in the election of the leader
+type FailoverConfig struct {
+	NodeID    string            // Unique ID of the node
+	Address   string            // Base URL of the node, given to clients while it leads
+	Store     LeaseStore        // Where the lease is kept
+	TTL       time.Duration     // Validity of the lease, DefaultLeaseTTL if 0
+	Standby   bool              // Only take over a lease some node held, so the configured primary leads first
+	OnPromote func(Lease) error // Called when the node becomes the leader, before it takes writes
+	OnDemote  func(Lease)       // Called when the node loses the lease it held, after it stopped taking writes
+}
+
+// Failover elects a leader among the nodes sharing a lease store. The leader renews the lease every third of
+// TTL. When it stops doing so, because it crashed, is cut from the store or is too slow, another node takes
+// the lease over once it expires, with the next epoch, and is promoted. A leader that can't renew the lease
+// for half of TTL fences itself before anyone can take over: it stops taking writes and is demoted.
+type Failover struct {
+	cfg     FailoverConfig
+	mu      sync.Mutex
+	role    string
+	lease   Lease     // Last lease read or written
+	renewed time.Time // When the leader last renewed the lease, by the local clock
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewFailover returns a node of the election, as a candidate until the first Step
+func NewFailover(cfg FailoverConfig) (*Failover, error) {
+	if cfg.NodeID == "" {
+		return nil, errors.New("No node ID configured")
+	}
+	if cfg.Store == nil {
+		return nil, errors.New("No lease store configured")
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultLeaseTTL
+	}
+	return &Failover{cfg: cfg, role: RoleCandidate}, nil
+}
+
+// Start runs the election every third of TTL in the background until Close is called.
+// Errors are logged and retried at the next interval.
+func (f *Failover) Start() {
+	f.closing = make(chan struct{})
+	f.done = make(chan struct{})
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(f.cfg.TTL / 3)
+		defer ticker.Stop()
+		for {
+			if err := f.Step(); err != nil {
+				log.Printf("Failover: %s", err)
+			}
+			select {
+			case <-f.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the background election started by Start. The lease isn't released: another node takes over
+// once it expires.
+func (f *Failover) Close() error {
+	if f.closing != nil {
+		close(f.closing)
+		<-f.done
+		f.closing = nil
+	}
+	return nil
+}
+
+// Role returns the role of the node: RoleLeader, RoleCandidate or RoleFenced
+func (f *Failover) Role() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.role
+}
+
+// Leader returns the last lease seen by the node
+func (f *Failover) Leader() Lease {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lease
+}
+
+// Step runs one round of the election: the leader renews its lease or fences itself, a candidate takes the lease
+// over if it expired
+func (f *Failover) Step() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+
+	if f.role == RoleLeader {
+		next := f.lease
+		next.Expires = now.Add(f.cfg.TTL)
+		swapped, err := f.cfg.Store.CompareAndSwap(f.lease, next)
+		if err == nil && swapped {
+			f.lease, f.renewed = next, now
+			return nil
+		}
+		if err == nil || now.Sub(f.renewed) >= f.cfg.TTL/2 {
+			// Another node took the lease over, or may soon do
+			f.demote()
+		}
+		return err
+	}
+
+	lease, err := f.cfg.Store.Read()
+	if err != nil {
+		return err
+	}
+	f.lease = lease
+	// A fenced node never leads again, it may miss writes of the leader that replaced it
+	if f.role == RoleFenced || (lease.Holder == "" && f.cfg.Standby) {
+		return nil
+	}
+	// A primary restarting after another node took over has data older than the leader's
+	if !f.cfg.Standby && lease.Holder != "" && lease.Holder != f.cfg.NodeID {
+		f.role = RoleFenced
+		log.Printf("Failover: %s leads with epoch %d, %s fenced", lease.Holder, lease.Epoch, f.cfg.NodeID)
+		return nil
+	}
+	// The node may take back its own lease after a restart, nobody else led since
+	if lease.Holder != "" && lease.Holder != f.cfg.NodeID && now.Before(lease.Expires) {
+		return nil
+	}
+	next := Lease{Holder: f.cfg.NodeID, Address: f.cfg.Address, Epoch: lease.Epoch + 1, Expires: now.Add(f.cfg.TTL)}
+	swapped, err := f.cfg.Store.CompareAndSwap(lease, next)
+	if err != nil || !swapped {
+		return err
+	}
+	f.lease, f.renewed = next, now
+	if f.cfg.OnPromote != nil {
+		if err := f.cfg.OnPromote(next); err != nil {
+			// Let the lease expire for another node rather than leading without taking writes
+			f.role = RoleFenced
+			return err
+		}
+	}
+	f.role = RoleLeader
+	log.Printf("Failover: %s leads with epoch %d", f.cfg.NodeID, next.Epoch)
+	return nil
+}
+
+// demote fences a leader that lost its lease, the caller must hold f.mu
+func (f *Failover) demote() {
+	f.role = RoleFenced
+	log.Printf("Failover: %s lost the lease of epoch %d, fenced", f.cfg.NodeID, f.lease.Epoch)
+	if f.cfg.OnDemote != nil {
+		f.cfg.OnDemote(f.lease)
+	}
+}
+
+// Redirect wraps handler so that only the leader takes writes: a candidate serves reads, GET and HEAD requests
+// except deletions, and redirects the other requests to the leader with 307 Temporary Redirect. A fenced node
+// redirects every request, as its data may be stale. 503 Service Unavailable is answered while no leader is known.
+// The replication endpoints are always served, a replica must keep reading the WAL of the node it bootstrapped from.
+func (f *Failover) Redirect(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		role, lease := f.role, f.lease
+		f.mu.Unlock()
+
+		if role == RoleLeader || (role == RoleCandidate && isRead(r)) || strings.HasPrefix(r.URL.Path, "/replication/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if lease.Address == "" || lease.Holder == f.cfg.NodeID {
+			http.Error(w, "No leader available", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, lease.Address+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
+
+// isRead reports whether r only reads data: GET and HEAD requests, except deletions
+func isRead(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.URL.Path != "/del"
+}
@@ -0,0 +1,90 @@
+package replication
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ApplyPath is the path of the endpoint receiving the changes of the peer
+const ApplyPath = "/replication/apply"
+
+// TokenHeader carries the token shared by the peers, when one is configured
+const TokenHeader = "X-Replication-Token"
+
+// HTTPTransport posts each batch of changes as a JSON array to the apply endpoint of the peer.
+// Any status other than 2xx is a failed delivery.
+type HTTPTransport struct {
+	URL    string       // Base URL of the peer, e.g. https://eu.example.com:8080
+	Token  string       // Sent in TokenHeader if not empty
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// Send posts changes to the peer
+func (t *HTTPTransport) Send(changes []Change) error {
+	body, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.URL+ApplyPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Token != "" {
+		req.Header.Set(TokenHeader, t.Token)
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Peer answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Handler applies the changes posted by the peer, refusing requests without token if one is given
+func Handler(r *Replicator, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(req, token) {
+			http.Error(w, "Invalid replication token", http.StatusUnauthorized)
+			return
+		}
+		var changes []Change
+		if err := json.NewDecoder(req.Body).Decode(&changes); err != nil {
+			http.Error(w, "Invalid changes: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		applied, err := r.Apply(changes)
+		if err != nil {
+			http.Error(w, "Apply failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"received": len(changes), "applied": applied})
+	}
+}
+
+// authorized reports whether r carries token, when one is required
+func authorized(r *http.Request, token string) bool {
+	return token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(TokenHeader)), []byte(token)) == 1
+}
+
+func RegisterHandler(mux *http.ServeMux, r *Replicator, token string) {
+	mux.HandleFunc(ApplyPath, Handler(r, token))
+}
@@ -0,0 +1,404 @@
+package replication
+
+import (
+	"StorageEngine/cdc"
+	"StorageEngine/memdb"
+	"StorageEngine/vfs"
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SnapshotPath is the path of the endpoint streaming a snapshot of the primary
+	SnapshotPath = "/replication/snapshot"
+	// TailPath is the path of the endpoint returning the records of the WAL of the primary
+	TailPath = "/replication/wal"
+	// DefaultTailLimit is the maximum number of records returned by the tail endpoint when none is asked
+	DefaultTailLimit = 1000
+)
+
+var (
+	// ErrNotBootstrapped is returned by NewReplica when the replica has no cursor, i.e. Bootstrap never completed
+	ErrNotBootstrapped = errors.New("Replica not bootstrapped")
+	// ErrWALReset is returned when the WAL of the primary no longer holds the position of the replica, which
+	// must then be bootstrapped again
+	ErrWALReset = errors.New("WAL of the primary was reset since the replica read it")
+)
+
+// TailBatch is the answer of the tail endpoint
+type TailBatch struct {
+	Records []cdc.Change `json:"records"` // In WAL order, from the requested position
+	Next    int64        `json:"next"`    // Position to ask for the next records
+}
+
+// SnapshotHandler streams a consistent snapshot of db as a tar archive: the SSTables, the WAL holding the records
+// not flushed yet, then the BACKUP.json manifest, laid out like a backup directory. The manifest gives the offset
+// of the WAL of db the replica resumes from.
+func SnapshotHandler(db *memdb.DB, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			http.Error(w, "Invalid replication token", http.StatusUnauthorized)
+			return
+		}
+
+		err := db.TempBackup(func(dir string, manifest memdb.BackupManifest) error {
+			w.Header().Set("Content-Type", "application/x-tar")
+			archive := tar.NewWriter(w)
+			files := append(append([]memdb.BackupFile{}, manifest.SSTables...), manifest.WAL, memdb.BackupFile{Name: memdb.BackupManifestName})
+			for _, file := range files {
+				if err := addToArchive(archive, dir, file.Name); err != nil {
+					return err
+				}
+			}
+			return archive.Close()
+		})
+		if err != nil {
+			// Once the archive started, the error can only cut it short: the replica misses the manifest
+			log.Printf("Replication: snapshot failed: %s", err)
+			http.Error(w, "Snapshot failed: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// addToArchive writes the file name of dir to archive, with its modification time
+func addToArchive(archive *tar.Writer, dir string, name string) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: fileInfo.Size(), ModTime: fileInfo.ModTime(), Typeflag: tar.TypeReg}
+	header.Format = tar.FormatPAX // Keeps the nanoseconds of the modification times, which order the SSTables
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, file)
+	return err
+}
+
+// TailHandler returns the records of the WAL at walPath from the position given by the from parameter, at most
+// limit of them, DefaultTailLimit by default. It answers 410 Gone if the WAL is shorter than the position, as
+// it was reset since. The position is recorded in acks, if not nil, as reached by the replica.
+func TailHandler(walPath string, token string, acks *AckTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			http.Error(w, "Invalid replication token", http.StatusUnauthorized)
+			return
+		}
+		from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if err != nil || from < memdb.WALMetadataSize {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+		limit := DefaultTailLimit
+		if s := r.URL.Query().Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		batch := TailBatch{Records: make([]cdc.Change, 0), Next: from}
+		meta, err := memdb.ScanWALFileFrom(walPath, from, func(entry memdb.WALEntry) error {
+			// The changes of a transaction are sent together, the replica applying them at once
+			changes, err := cdc.ChangesOf(entry)
+			if err != nil {
+				return err
+			}
+			batch.Records = append(batch.Records, changes...)
+			batch.Next = entry.Position + entry.Size
+			if len(batch.Records) >= limit {
+				return errLimitReached
+			}
+			return nil
+		})
+		if err != nil && err != errLimitReached {
+			http.Error(w, "Reading the WAL failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if from > meta.Offset {
+			http.Error(w, ErrWALReset.Error(), http.StatusGone)
+			return
+		}
+		// A replica asks for the records after the ones it applied
+		if acks != nil {
+			acks.Observe(replicaID(r), from)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batch)
+	}
+}
+
+// errLimitReached stops a WAL scan once enough records were read
+var errLimitReached = errors.New("Limit reached")
+
+// RegisterPrimaryHandlers serves the snapshot and the WAL at walPath of db to the replicas, which must send token.
+// The positions they reach are recorded in acks, if not nil.
+func RegisterPrimaryHandlers(mux *http.ServeMux, db *memdb.DB, walPath string, token string, acks *AckTracker) {
+	mux.HandleFunc(SnapshotPath, SnapshotHandler(db, token))
+	mux.HandleFunc(TailPath, TailHandler(walPath, token, acks))
+}
+
+// ReplicaConfig describes the primary a replica follows
+type ReplicaConfig struct {
+	Primary    string        // Base URL of the primary, e.g. https://primary.example.com:8080
+	Token      string        // Sent in TokenHeader if not empty
+	NodeID     string        // Sent in ReplicaIDHeader, the primary tells the replicas apart by their address if empty
+	CursorPath string        // File keeping the position of the replica in the WAL of the primary, written by Bootstrap
+	Interval   time.Duration // Time between two reads of the WAL of the primary, DefaultInterval if 0
+	BatchSize  int           // Maximum number of records read at once, DefaultTailLimit if 0
+	Client     *http.Client  // http.DefaultClient if nil
+}
+
+// replicaCursor is the position of the replica in the WAL of the primary, saved in the cursor file
+type replicaCursor struct {
+	Position int64 `json:"position"`
+}
+
+// get sends a GET request to the primary, with the token
+func (cfg ReplicaConfig) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(cfg.Primary, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Token != "" {
+		req.Header.Set(TokenHeader, cfg.Token)
+	}
+	if cfg.NodeID != "" {
+		req.Header.Set(ReplicaIDHeader, cfg.NodeID)
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// Bootstrap downloads a snapshot of the primary and restores it into the WAL at walPath and the SSTable directory
+// sstableDir, which must not hold a database yet, then writes the cursor of the replica. The snapshot is
+// staged next to the SSTable directory and verified against its manifest before anything is installed.
+func Bootstrap(cfg ReplicaConfig, walPath string, sstableDir string) (memdb.BackupManifest, error) {
+	var manifest memdb.BackupManifest
+	resp, err := cfg.get(SnapshotPath)
+	if err != nil {
+		return manifest, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return manifest, fmt.Errorf("Primary answered %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sstableDir), 0755); err != nil {
+		return manifest, err
+	}
+	stagingDir, err := os.MkdirTemp(filepath.Dir(sstableDir), "replica-bootstrap-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(stagingDir)
+	if err := extractArchive(resp.Body, stagingDir); err != nil {
+		return manifest, err
+	}
+
+	if manifest, err = memdb.Restore(stagingDir, walPath, sstableDir); err != nil {
+		return manifest, err
+	}
+	return manifest, saveReplicaCursor(cfg.CursorPath, replicaCursor{Position: manifest.WALOffset})
+}
+
+// extractArchive writes the files of a snapshot archive into dir, with their modification times
+func extractArchive(r io.Reader, dir string) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("Unexpected file %q in snapshot", header.Name)
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, archive)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(dst, header.ModTime, header.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+// saveReplicaCursor replaces the cursor file atomically
+func saveReplicaCursor(cursorPath string, cursor replicaCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	return vfs.WriteFileAtomic(vfs.OS, cursorPath, data, 0644)
+}
+
+// Replica applies the records of the WAL of a primary to a database opened with the memdb.Replica option
+type Replica struct {
+	cfg     ReplicaConfig
+	db      *memdb.DB
+	mu      sync.Mutex // Serializes Poll
+	cursor  replicaCursor
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewReplica returns a replica of cfg.Primary writing to db, resuming from its cursor file.
+// It returns ErrNotBootstrapped if the cursor file doesn't exist.
+func NewReplica(db *memdb.DB, cfg ReplicaConfig) (*Replica, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultTailLimit
+	}
+	r := &Replica{cfg: cfg, db: db}
+	data, err := os.ReadFile(cfg.CursorPath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotBootstrapped
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.cursor); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Position returns the position of the replica in the WAL of the primary
+func (r *Replica) Position() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cursor.Position
+}
+
+// Start applies the records of the primary every interval in the background until Close is called.
+// Errors are logged and retried at the next interval.
+func (r *Replica) Start() {
+	r.closing = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := r.Poll(); err != nil {
+				log.Printf("Replica: %s", err)
+			}
+			select {
+			case <-r.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the background replication started by Start
+func (r *Replica) Close() error {
+	if r.closing != nil {
+		close(r.closing)
+		<-r.done
+		r.closing = nil
+	}
+	return nil
+}
+
+// Poll applies the records written to the WAL of the primary since the last call, and returns how many were
+// applied. The cursor is saved after each batch, once its records are in the WAL of the replica.
+func (r *Replica) Poll() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	applied := 0
+	for {
+		query := url.Values{"from": {strconv.FormatInt(r.cursor.Position, 10)}, "limit": {strconv.Itoa(r.cfg.BatchSize)}}
+		batch, err := r.fetch(TailPath + "?" + query.Encode())
+		if err != nil || len(batch.Records) == 0 {
+			return applied, err
+		}
+		records := make([]memdb.WALRecord, len(batch.Records))
+		for i, change := range batch.Records {
+			records[i] = memdb.WALRecord{Operation: memdb.OpSet, Key: []byte(change.Key), Value: change.Value}
+			switch change.Op {
+			case cdc.OpDel:
+				records[i].Operation = memdb.OpDel
+			case cdc.OpDelRange:
+				records[i].Operation, records[i].Value = memdb.OpDelRange, []byte(change.End)
+			}
+		}
+		if err := r.db.ApplyReplicated(records); err != nil {
+			return applied, err
+		}
+		cursor := replicaCursor{Position: batch.Next}
+		if err := saveReplicaCursor(r.cfg.CursorPath, cursor); err != nil {
+			return applied, err
+		}
+		r.cursor = cursor
+		applied += len(records)
+	}
+}
+
+// fetch reads a batch of records from the primary
+func (r *Replica) fetch(path string) (TailBatch, error) {
+	var batch TailBatch
+	resp, err := r.cfg.get(path)
+	if err != nil {
+		return batch, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&batch)
+	case http.StatusGone:
+		err = ErrWALReset
+	default:
+		err = fmt.Errorf("Primary answered %s", resp.Status)
+	}
+	return batch, err
+}
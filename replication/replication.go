@@ -0,0 +1,419 @@
+// Package replication replicates the writes of a database to a peer database and back, asynchronously, for
+// deployments spanning several regions where each side takes writes.
+//
+// Each key carries a version, a hybrid timestamp and the ID of the node that wrote it, kept in a state database
+// next to the replicated one. Local writes are read from the WAL, stamped with a timestamp and queued, then
+// shipped to the peer in batches. A change received from the peer is checked against the local version of its key
+// by a conflict Resolver, last writer wins by default, and applied if it wins. Both sides resolve every conflict the
+// same way, so they converge once the changes stop.
+package replication
+
+import (
+	"StorageEngine/cdc"
+	"StorageEngine/memdb"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is the time between two shipments to the peer when none is configured
+	DefaultInterval = time.Second
+	// DefaultBatchSize is the maximum number of changes shipped at once when none is configured
+	DefaultBatchSize = 100
+)
+
+// Prefixes of the keys of the state database
+const (
+	versionPrefix = "v/" // Version of each key, by key
+	queuePrefix   = "q/" // Changes waiting to be shipped, by sequence number
+)
+
+// Version orders the writes of a key across the nodes
+type Version struct {
+	Timestamp uint64 `json:"ts"`     // Hybrid timestamp: nanoseconds since the epoch, moved past every timestamp seen
+	Origin    string `json:"origin"` // ID of the node that wrote the value
+}
+
+// Newer reports whether v is after other, the origin breaking ties between equal timestamps
+func (v Version) Newer(other Version) bool {
+	if v.Timestamp != other.Timestamp {
+		return v.Timestamp > other.Timestamp
+	}
+	return v.Origin > other.Origin
+}
+
+// Change is the state of a key after a write, as shipped to the peer
+type Change struct {
+	Key     string  `json:"key"`
+	Value   []byte  `json:"value,omitempty"` // Base64 in JSON, empty for deletions
+	Deleted bool    `json:"deleted,omitempty"`
+	Version Version `json:"version"`
+}
+
+// Resolver picks the state a key ends up in when a change from the peer meets a local version of the key. It may
+// return either argument, or a merge of both, but must be deterministic and give the same result whatever the
+// order of its arguments, as the peer resolves the same conflict the other way around.
+type Resolver func(local Change, remote Change) Change
+
+// LastWriterWins keeps the change with the newest version
+func LastWriterWins(local Change, remote Change) Change {
+	if remote.Version.Newer(local.Version) {
+		return remote
+	}
+	return local
+}
+
+// Transport sends changes to the peer
+type Transport interface {
+	// Send delivers a batch of changes to the peer, which applies them in order. An error means the batch
+	// will be sent again, so the peer may see a change more than once.
+	Send(changes []Change) error
+}
+
+// Config describes the replication of a database
+type Config struct {
+	NodeID    string        // Unique ID of this node, also breaks ties between equal timestamps
+	WALPath   string        // WAL of the replicated database, read for its local writes
+	StateDir  string        // Directory of the state database and of the WAL cursor, owned by the replicator
+	Transport Transport     // Where the local changes are shipped
+	Resolver  Resolver      // Conflict policy, LastWriterWins if nil
+	Interval  time.Duration // Time between two shipments, DefaultInterval if 0
+	BatchSize int           // Maximum number of changes per Send, DefaultBatchSize if 0
+}
+
+// Replicator replicates a database with a peer
+type Replicator struct {
+	cfg      Config
+	db       *memdb.DB
+	state    *memdb.DB
+	stateWAL *memdb.WAL
+	tailer   *cdc.Publisher
+
+	mu      sync.Mutex // Serializes the stamping of local writes and the application of remote changes
+	clock   uint64     // Last timestamp given or seen
+	seq     uint64     // Sequence number of the last queued change
+	shipMu  sync.Mutex // Serializes the shipments
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// New returns a replicator of db, opening its state database in cfg.StateDir
+func New(db *memdb.DB, cfg Config) (*Replicator, error) {
+	if cfg.NodeID == "" {
+		return nil, errors.New("No node ID configured")
+	}
+	if cfg.Transport == nil {
+		return nil, errors.New("No transport configured")
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = LastWriterWins
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if err := os.MkdirAll(cfg.StateDir, 0755); err != nil {
+		return nil, err
+	}
+
+	r := &Replicator{cfg: cfg, db: db}
+	var err error
+	if r.stateWAL, err = memdb.OpenWAL(filepath.Join(cfg.StateDir, "state_wal.log")); err != nil {
+		return nil, err
+	}
+	if r.state, err = memdb.NewDB(r.stateWAL, filepath.Join(cfg.StateDir, "StateSSTables")); err != nil {
+		r.stateWAL.Close()
+		return nil, err
+	}
+	r.tailer, err = cdc.New(cdc.Config{
+		WALPath:    cfg.WALPath,
+		CursorPath: filepath.Join(cfg.StateDir, "wal.cursor"),
+		Sink:       (*stamper)(r),
+		BatchSize:  cfg.BatchSize,
+	})
+	if err == nil {
+		err = r.loadQueue()
+	}
+	if err != nil {
+		r.state.Close()
+		r.stateWAL.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// loadQueue resumes the sequence numbers after the last queued change
+func (r *Replicator) loadQueue() error {
+	queued, err := r.state.Scan(memdb.ScanOptions{Prefix: queuePrefix})
+	if err != nil {
+		return err
+	}
+	if len(queued) > 0 {
+		_, err = fmt.Sscanf(queued[len(queued)-1].Key, queuePrefix+"%d", &r.seq)
+	}
+	return err
+}
+
+// Start stamps and ships the local changes every interval in the background until Close is called.
+// Errors are logged and retried at the next interval.
+func (r *Replicator) Start() {
+	r.closing = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := r.Sync(); err != nil {
+				log.Printf("Replication: %s", err)
+			}
+			select {
+			case <-r.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the background replication and closes the state database
+func (r *Replicator) Close() error {
+	if r.closing != nil {
+		close(r.closing)
+		<-r.done
+		r.closing = nil
+	}
+	r.state.Close()
+	return r.stateWAL.Close()
+}
+
+// Sync stamps the local writes made since the last call and ships the queued changes to the peer, returning the
+// number of changes shipped
+func (r *Replicator) Sync() (int, error) {
+	if err := r.stampLocal(); err != nil {
+		return 0, err
+	}
+	return r.ship()
+}
+
+// stampLocal reads the local writes from the WAL and queues them
+func (r *Replicator) stampLocal() error {
+	_, err := r.tailer.Poll()
+	return err
+}
+
+// now returns a timestamp after every timestamp given or seen so far, the caller must hold r.mu
+func (r *Replicator) now() uint64 {
+	ts := uint64(time.Now().UnixNano())
+	if ts <= r.clock {
+		ts = r.clock + 1
+	}
+	r.clock = ts
+	return ts
+}
+
+// stamper is the sink of the WAL tailer, stamping the local writes it reads
+type stamper Replicator
+
+// Publish stamps the keys written by changes. The current state of each key is queued rather than the value
+// of the WAL record: a later write, local or from the peer, may have replaced it since.
+func (s *stamper) Publish(changes []cdc.Change) error {
+	r := (*Replicator)(s)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, change := range changes {
+		local, found, err := r.local(change.Key)
+		if err != nil {
+			return err
+		}
+		// A state the version already describes was stamped before, or written by the peer
+		if found && local.digest == stateDigest(local.Change) {
+			continue
+		}
+		if _, err := r.stamp(local.Change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localState is the state of a key in the replicated database, with the version kept for it in the state
+// database and the digest of the state that version describes
+type localState struct {
+	Change
+	digest string
+}
+
+// local returns the state of key, found is false if the key has no version yet
+func (r *Replicator) local(key string) (localState, bool, error) {
+	state := localState{Change: Change{Key: key}}
+	value, err := r.db.Get(key)
+	if err == memdb.ErrKeyNotFound {
+		state.Deleted = true
+	} else if err != nil {
+		return state, false, err
+	}
+	state.Value = value
+
+	data, err := r.state.Get(versionPrefix + key)
+	if err == memdb.ErrKeyNotFound {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	var stored storedVersion
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return state, false, err
+	}
+	state.Version, state.digest = stored.Version, stored.Digest
+	return state, true, nil
+}
+
+// stamp gives a new local version to change and queues it, the caller must hold r.mu
+func (r *Replicator) stamp(change Change) (Change, error) {
+	change.Version = Version{Timestamp: r.now(), Origin: r.cfg.NodeID}
+	if err := r.setVersion(change); err != nil {
+		return change, err
+	}
+	return change, r.enqueue(change)
+}
+
+// storedVersion is the version of a key kept in the state database, with a digest of the state it describes
+type storedVersion struct {
+	Version Version `json:"version"`
+	Digest  string  `json:"digest"`
+}
+
+func (r *Replicator) setVersion(change Change) error {
+	data, err := json.Marshal(storedVersion{Version: change.Version, Digest: stateDigest(change)})
+	if err != nil {
+		return err
+	}
+	return r.state.Set(versionPrefix+change.Key, data)
+}
+
+// stateDigest identifies the value or the deletion of a change
+func stateDigest(change Change) string {
+	if change.Deleted {
+		return "deleted"
+	}
+	sum := sha256.Sum256(change.Value)
+	return fmt.Sprintf("%x", sum[:16])
+}
+
+// enqueue adds a change to the changes waiting to be shipped, the caller must hold r.mu
+func (r *Replicator) enqueue(change Change) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	r.seq++
+	return r.state.Set(fmt.Sprintf("%s%020d", queuePrefix, r.seq), data)
+}
+
+// ship sends the queued changes to the peer in batches, removing each batch once delivered
+func (r *Replicator) ship() (int, error) {
+	r.shipMu.Lock()
+	defer r.shipMu.Unlock()
+	shipped := 0
+	for {
+		queued, err := r.state.Scan(memdb.ScanOptions{Prefix: queuePrefix, Limit: r.cfg.BatchSize})
+		if err != nil || len(queued) == 0 {
+			return shipped, err
+		}
+		changes := make([]Change, len(queued))
+		for i, kv := range queued {
+			if err := json.Unmarshal(kv.Value, &changes[i]); err != nil {
+				return shipped, err
+			}
+		}
+		if err := r.cfg.Transport.Send(changes); err != nil {
+			return shipped, err
+		}
+		for _, kv := range queued {
+			if err := r.state.Delete(kv.Key); err != nil {
+				return shipped, err
+			}
+		}
+		shipped += len(changes)
+	}
+}
+
+// Pending returns the number of changes waiting to be shipped
+func (r *Replicator) Pending() (int, error) {
+	queued, err := r.state.Scan(memdb.ScanOptions{Prefix: queuePrefix})
+	return len(queued), err
+}
+
+// Apply applies changes received from the peer, in order, resolving the conflicts with the local versions.
+// It returns the number of changes that modified the database.
+func (r *Replicator) Apply(changes []Change) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	applied := 0
+	for _, remote := range changes {
+		if remote.Version.Timestamp > r.clock {
+			r.clock = remote.Version.Timestamp
+		}
+		local, found, err := r.local(remote.Key)
+		if err != nil {
+			return applied, err
+		}
+		// A local write the WAL tailer hasn't read yet is stamped now, or the conflict would go unnoticed
+		if (found && local.digest != stateDigest(local.Change)) || (!found && !local.Deleted) {
+			if local.Change, err = r.stamp(local.Change); err != nil {
+				return applied, err
+			}
+			found = true
+		}
+
+		winner := remote
+		if found {
+			if local.Version == remote.Version {
+				continue // Delivered again
+			}
+			winner = r.cfg.Resolver(local.Change, remote)
+			winner.Key = remote.Key
+			// Both sides keep the newest of the two versions, whichever state wins
+			if local.Version.Newer(remote.Version) {
+				winner.Version = local.Version
+			} else {
+				winner.Version = remote.Version
+			}
+			if stateDigest(winner) == stateDigest(local.Change) {
+				if winner.Version != local.Version {
+					if err := r.setVersion(winner); err != nil {
+						return applied, err
+					}
+				}
+				continue
+			}
+		}
+
+		// The version is written first: the WAL record of the write then matches it and isn't shipped back
+		if err := r.setVersion(winner); err != nil {
+			return applied, err
+		}
+		if winner.Deleted {
+			err = r.db.Delete(winner.Key)
+		} else {
+			err = r.db.Set(winner.Key, winner.Value)
+		}
+		if err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
@@ -0,0 +1,169 @@
+package sessions
+
+import (
+	"StorageEngine/memdb"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPrefix is the key prefix of the sessions when New is given none
+const DefaultPrefix = "session/"
+
+// idBytes is the number of random bytes of a session ID, written in hexadecimal
+const idBytes = 16
+
+// ErrSessionNotFound is returned for a session that doesn't exist, was destroyed or expired
+var ErrSessionNotFound = errors.New("Session not found")
+
+// Session is a session of a web application, with the data it keeps between requests
+type Session struct {
+	ID      string    `json:"id"`
+	Data    []byte    `json:"data"`
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps expiring sessions in a database, each under a key made of the prefix of the store and its ID, e.g.
+// "session/4f0c...", its data as an expiring value of memdb. The TTL slides: a session expires once it hasn't been
+// touched or updated for the TTL of the store. Expired sessions are never returned, and are deleted the next time
+// they are read or by Sweep. The store serializes its own writes, so sessions must only be written through one
+// Store per database.
+type Store struct {
+	db     *memdb.DB
+	prefix string
+	ttl    time.Duration
+	mu     sync.Mutex // Held by writes, so that a touch doesn't bring back a session destroyed meanwhile
+}
+
+// New returns a store of sessions expiring ttl after their last use, under the keys starting with prefix,
+// DefaultPrefix if empty
+func New(db *memdb.DB, ttl time.Duration, prefix string) *Store {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &Store{db: db, prefix: prefix, ttl: ttl}
+}
+
+// encode returns the value stored for a session, an expiring value of memdb holding its data
+func encode(session Session) []byte {
+	return memdb.EncodeExpiring(session.Expires, session.Data)
+}
+
+// decode returns the session stored as value, ok is false if it isn't one
+func decode(id string, value []byte) (session Session, ok bool) {
+	expires, data, ok := memdb.DecodeExpiring(value)
+	return Session{ID: id, Data: data, Expires: expires}, ok
+}
+
+// Create creates a session holding data with a new random ID
+func (s *Store) Create(data []byte) (Session, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return Session{}, err
+	}
+	session := Session{ID: hex.EncodeToString(b), Data: data, Expires: time.Now().Add(s.ttl)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return session, s.db.Set(s.prefix+session.ID, encode(session))
+}
+
+// Get returns a session without extending it, or ErrSessionNotFound
+func (s *Store) Get(id string) (Session, error) {
+	session, expired, err := s.read(id)
+	if expired {
+		s.Destroy(id)
+	}
+	return session, err
+}
+
+// Touch extends a session to the TTL of the store from now, and returns it
+func (s *Store) Touch(id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, _, err := s.read(id)
+	if err != nil {
+		return Session{}, err
+	}
+	session.Expires = time.Now().Add(s.ttl)
+	return session, s.db.Set(s.prefix+id, encode(session))
+}
+
+// Update replaces the data of a session and extends it like Touch
+func (s *Store) Update(id string, data []byte) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, _, err := s.read(id)
+	if err != nil {
+		return Session{}, err
+	}
+	session.Data, session.Expires = data, time.Now().Add(s.ttl)
+	return session, s.db.Set(s.prefix+id, encode(session))
+}
+
+// read returns a session that hasn't expired, or ErrSessionNotFound, expired telling whether it is because the
+// session expired
+func (s *Store) read(id string) (session Session, expired bool, err error) {
+	value, err := s.db.Get(s.prefix + id)
+	if err == memdb.ErrKeyNotFound {
+		return Session{}, false, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+	session, ok := decode(id, value)
+	if !ok {
+		return Session{}, false, ErrSessionNotFound
+	}
+	if memdb.Expired(session.Expires, time.Now()) {
+		return Session{}, true, ErrSessionNotFound
+	}
+	return session, false, nil
+}
+
+// Destroy deletes a session, e.g. on logout. Destroying a missing session isn't an error.
+func (s *Store) Destroy(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.db.Delete(s.prefix + id)
+	if err == memdb.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// Sweep deletes the expired sessions and returns how many it deleted. Expired sessions are never returned, it only
+// frees their room, and should be run from time to time, e.g. every TTL.
+func (s *Store) Sweep() (int, error) {
+	now := time.Now()
+	it, err := s.db.NewIterator(memdb.IteratorOptions{Prefix: s.prefix})
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for ; it.Valid(); it.Next() {
+		if session, ok := decode(strings.TrimPrefix(it.Key(), s.prefix), it.Value()); ok && memdb.Expired(session.Expires, now) {
+			expired = append(expired, session.ID)
+		}
+	}
+	err = it.Err()
+	it.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// An expired session can't be touched any more, so it is still expired
+	deleted := 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range expired {
+		if err := s.db.Delete(s.prefix + id); err != nil && err != memdb.ErrKeyNotFound {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,104 @@
+package sstable
+
+import (
+	"StorageEngine/vfs"
+	"errors"
+	"hash"
+	"time"
+)
+
+// ErrEntriesChanged is returned by WriteSSTableFrom when the entries read from the iterator aren't those added to the
+// TableBuilder of the table
+var ErrEntriesChanged = errors.New("SSTable entries changed since the table was built")
+
+// TableBuilder computes the header, the properties and the checksum of an SSTable from its entries, added one at a
+// time in key order, so that a table is written from an Iterator without holding its entries in memory: a first
+// pass over them adds each one to the builder, a second one writes them with WriteSSTableFrom.
+type TableBuilder struct {
+	table      SSTable
+	checksum   hash.Hash32
+	collectors []PropertiesCollector
+	index      *indexBuilder // Cuts the entries into blocks like the writer, to tell the bytes they take
+	prev       []byte        // Key of the last entry
+	size       int64         // Size of the table as counted by Split
+}
+
+// NewTableBuilder returns the builder of a table of the current version whose entries are checksummed with
+// checksum, collectors computing its user properties
+func NewTableBuilder(checksum ChecksumType, collectors ...PropertiesCollector) *TableBuilder {
+	b := &TableBuilder{
+		table: SSTable{Header: SSTableHeader{
+			MagicNumber:  uint32(221003),
+			Version:      CurrentVersion,
+			ChecksumType: checksum,
+			Properties:   &Properties{Created: time.Now()},
+		}},
+		checksum:   checksum.newHash(),
+		collectors: collectors,
+		index:      newIndexBuilder(CurrentVersion, checksum, 0, false),
+		size:       SSTableHeaderSize + 4, // Header and checksum
+	}
+	return b
+}
+
+// Add adds the next entry of the table, whose key sorts after the keys added before
+func (b *TableBuilder) Add(kv KeyValuePair) {
+	header, props := &b.table.Header, b.table.Header.Properties
+	if header.EntryCount == 0 {
+		header.SmallestKey = append([]byte(nil), kv.Key...)
+	}
+	var entryHeader [maxEntryHeaderSize]byte
+	shared := b.index.shared(b.prev, kv.Key, int(header.EntryCount), header.Version)
+	data := appendEntryHeader(entryHeader[:0], header.Version, &kv, shared)
+	b.prev = append([]byte(nil), kv.Key...)
+	b.index.add(&KeyValuePair{Operation: kv.Operation, Key: b.prev, Value: kv.Value}, data, shared)
+
+	header.EntryCount++
+	props.Entries++
+	if kv.Operation != OpSet {
+		props.Tombstones++
+	}
+	props.RawSize += uint64(len(kv.Key) + len(kv.Value))
+	props.DataSize += uint64(len(data) + len(kv.Key) - shared + len(kv.Value))
+	b.checksum.Write(kv.Key)
+	b.checksum.Write(kv.Value)
+	for _, collector := range b.collectors {
+		collector.Add(kv)
+	}
+	b.size += EntrySize(kv)
+}
+
+// Len returns the number of entries added
+func (b *TableBuilder) Len() int {
+	return int(b.table.Header.EntryCount)
+}
+
+// Size returns the size of the table as counted by Split, to cut an output into tables of a target size
+func (b *TableBuilder) Size() int64 {
+	return b.size
+}
+
+// Table returns the table built, with its header, its properties and its checksum but without its entries, to be
+// written by WriteSSTableFrom. The builder can't be used afterwards.
+func (b *TableBuilder) Table() *SSTable {
+	table := &b.table
+	table.Header.LargestKey = b.prev
+	if len(b.collectors) > 0 {
+		table.Header.Properties.User = make(map[string]string)
+		for _, collector := range b.collectors {
+			for name, value := range collector.Finish() {
+				table.Header.Properties.User[name] = value
+			}
+		}
+	}
+	table.Checksum = b.checksum.Sum32()
+	return table
+}
+
+// WriteSSTableFrom writes a table returned by TableBuilder.Table to a new file of fsys like WriteSSTableFS, reading
+// its entries from entries, which must return the entries added to the builder, in the same order. It fails with
+// ErrEntriesChanged otherwise. The iterator is left after the last entry of the table, at the first entry of the
+// next table of a split output.
+func WriteSSTableFrom(fsys vfs.FS, filename string, table *SSTable, entries Iterator) error {
+	return writeTable(fsys, filename, table, entries, true)
+}
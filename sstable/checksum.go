@@ -0,0 +1,97 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// The checksum of the entries of an SSTable, at the end of the file, is computed with the algorithm stored in bits
+// 12 and 13 of the version field of the header, CRC32 (0) in the tables written before it could be chosen. The
+// checksum takes 4 bytes whatever the algorithm: xxHash64 checksums are truncated to their low 32 bits. The properties
+// block always has a CRC32 checksum.
+
+const (
+	// checksumMask selects the checksum algorithm in the version field of the header
+	checksumMask uint16 = 0x3000
+	// checksumShift is the position of the checksum algorithm in the version field
+	checksumShift = 12
+)
+
+// ChecksumType is the algorithm the checksum of the entries of an SSTable is computed with
+type ChecksumType uint8
+
+const (
+	ChecksumCRC32    ChecksumType = iota // CRC32 IEEE, the default
+	ChecksumCRC32C                       // CRC32 Castagnoli, as used by LevelDB and RocksDB
+	ChecksumXXHash64                     // xxHash64, the low 32 bits of the hash, portable and fast without CRC instructions
+)
+
+// ErrUnknownChecksum is returned when the header of an SSTable names a checksum algorithm this package doesn't know
+var ErrUnknownChecksum = errors.New("Unknown SSTable checksum algorithm")
+
+// castagnoli is the table of the CRC32C checksums
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseChecksumType returns the algorithm named "crc32", "crc32c" or "xxhash64", ChecksumCRC32 if name is empty
+func ParseChecksumType(name string) (ChecksumType, error) {
+	switch name {
+	case "", "crc32":
+		return ChecksumCRC32, nil
+	case "crc32c":
+		return ChecksumCRC32C, nil
+	case "xxhash64":
+		return ChecksumXXHash64, nil
+	}
+	return 0, fmt.Errorf("Unknown checksum algorithm %q", name)
+}
+
+// String returns the name of the algorithm, as accepted by ParseChecksumType
+func (c ChecksumType) String() string {
+	switch c {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash64:
+		return "xxhash64"
+	}
+	return fmt.Sprintf("checksum(%d)", uint8(c))
+}
+
+// MarshalText encodes the algorithm as its name, e.g. in JSON
+func (c ChecksumType) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// valid reports whether the algorithm is one this package computes
+func (c ChecksumType) valid() bool {
+	return c <= ChecksumXXHash64
+}
+
+// newHash returns a hash computing checksums with the algorithm
+func (c ChecksumType) newHash() hash.Hash32 {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoli)
+	case ChecksumXXHash64:
+		return newXXHash64()
+	}
+	return crc32.NewIEEE()
+}
+
+// SetChecksum computes the checksum of the table with the algorithm c, written along with it
+func (t *SSTable) SetChecksum(c ChecksumType) {
+	if t.Header.ChecksumType == c {
+		return
+	}
+	t.Header.ChecksumType = c
+	t.Checksum = calculateChecksum(t)
+}
+
+// splitVersion returns the format version and the checksum algorithm held by the version field of a header,
+// without the flag of encrypted tables
+func splitVersion(field uint16) (uint16, ChecksumType) {
+	return field &^ (encryptedFlag | checksumMask), ChecksumType((field & checksumMask) >> checksumShift)
+}
@@ -0,0 +1,383 @@
+package sstable
+
+import (
+	"StorageEngine/vfs"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Encrypted SSTables keep their header in clear, with encryptedFlag set in the version field and the name of their
+// key in place of the key prefixes, followed by the rest of the file (the entries and the checksum) in blocks of up
+// to blockSize bytes, each sealed with AES-GCM as a random nonce, the ciphertext and the tag. The header and the index
+// of the block are authenticated along with it, so blocks can't be altered, swapped or moved to another table
+// unnoticed.
+
+const (
+	// EncryptionKeyEnv is the environment variable holding the key SSTables are encrypted with when none is given
+	// through EncryptFS, in the format of ParseEncryptionKey
+	EncryptionKeyEnv = "STORAGE_ENCRYPTION_KEY"
+	// MaxKeyNameSize is the length of the longest key name, stored in the header of the tables
+	MaxKeyNameSize = 8
+
+	// encryptedFlag is set in the version field of the header of encrypted SSTables
+	encryptedFlag uint16 = 0x8000
+	// blockSize is the size of the plaintext of the blocks of encrypted SSTables, but the last one
+	blockSize = 64 << 10
+	nonceSize = 12
+	tagSize   = 16
+)
+
+var (
+	// ErrInvalidEncryptionKey is returned for a key that isn't 16, 24 or 32 bytes long, or 32, 48 or 64 hex digits
+	ErrInvalidEncryptionKey = errors.New("Invalid encryption key: 16, 24 or 32 bytes expected")
+	// ErrInvalidKeyName is returned for a key name longer than MaxKeyNameSize bytes or holding a NUL byte
+	ErrInvalidKeyName = errors.New("Invalid encryption key name: at most 8 bytes, without NUL, expected")
+	// ErrUnknownEncryptionKey is returned by StaticKeys for a name it has no key for
+	ErrUnknownEncryptionKey = errors.New("Unknown encryption key")
+	// ErrNoEncryptionKey is returned when reading an encrypted SSTable without a key
+	ErrNoEncryptionKey = errors.New("SSTable is encrypted and no encryption key is configured")
+	// ErrDecryption is returned when a block of an encrypted SSTable doesn't decrypt: wrong key or corrupted data
+	ErrDecryption = errors.New("SSTable can't be decrypted: wrong key or corrupted data")
+)
+
+// KeyProvider fetches the data-encryption keys of SSTables by name, e.g. from a key management service (KMS)
+type KeyProvider interface {
+	// Key returns the key of a name, 16, 24 or 32 bytes long
+	Key(name string) ([]byte, error)
+}
+
+// KeyProviderFunc adapts a function to the KeyProvider interface
+type KeyProviderFunc func(name string) ([]byte, error)
+
+// Key calls f(name)
+func (f KeyProviderFunc) Key(name string) ([]byte, error) {
+	return f(name)
+}
+
+// StaticKeys is a KeyProvider holding its keys by name
+type StaticKeys map[string][]byte
+
+// Key returns the key of a name, ErrUnknownEncryptionKey if there is none
+func (k StaticKeys) Key(name string) ([]byte, error) {
+	key, ok := k[name]
+	if !ok {
+		return nil, ErrUnknownEncryptionKey
+	}
+	return key, nil
+}
+
+// Encryption encrypts new SSTables with its current key and decrypts SSTables with the key they were encrypted with,
+// fetched by name from its KeyProvider the first time it is needed and kept from then on
+type Encryption struct {
+	provider KeyProvider
+	current  string
+
+	mu    sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+// NewEncryption returns the encryption of SSTables with a single unnamed key, 16, 24 or 32 bytes long
+func NewEncryption(key []byte) (*Encryption, error) {
+	return NewKeyring(StaticKeys{"": key}, "")
+}
+
+// NewKeyring returns the encryption of SSTables with the keys of provider: new tables are encrypted with the key
+// named current, fetched right away, and existing ones are read with the key named in their header. Rotating keys
+// is opening the database with a new current key, the tables encrypted with older keys are encrypted with the new
+// one as compactions rewrite them, so the older keys must stay available from provider until then.
+func NewKeyring(provider KeyProvider, current string) (*Encryption, error) {
+	if len(current) > MaxKeyNameSize || strings.IndexByte(current, 0) >= 0 {
+		return nil, ErrInvalidKeyName
+	}
+	e := &Encryption{provider: provider, current: current, aeads: make(map[string]cipher.AEAD)}
+	if _, err := e.aead(current); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Current returns the name of the key new SSTables are encrypted with
+func (e *Encryption) Current() string {
+	return e.current
+}
+
+// aead returns the cipher of the key named name
+func (e *Encryption) aead(name string) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if aead, ok := e.aeads[name]; ok {
+		return aead, nil
+	}
+	key, err := e.provider.Key(name)
+	if err != nil {
+		return nil, fmt.Errorf("Encryption key %q: %w", name, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidEncryptionKey
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads[name] = aead
+	return aead, nil
+}
+
+// ParseEncryptionKey returns the encryption of SSTables with a key written in hexadecimal, or with named keys written
+// as name:hex and separated by commas, e.g. "2026b:<hex>,2026a:<hex>". The first named key encrypts new tables, the
+// others read the tables encrypted with them before a rotation.
+func ParseEncryptionKey(s string) (*Encryption, error) {
+	if !strings.Contains(s, ":") {
+		key, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, ErrInvalidEncryptionKey
+		}
+		return NewEncryption(key)
+	}
+	keys := make(StaticKeys)
+	current := ""
+	for i, entry := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, ErrInvalidEncryptionKey
+		}
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, ErrInvalidEncryptionKey
+		}
+		if _, err := NewEncryption(key); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			current = name
+		}
+		keys[name] = key
+	}
+	return NewKeyring(keys, current)
+}
+
+// envEncryption is the encryption with the key of EncryptionKeyEnv, read once
+var envEncryption struct {
+	once sync.Once
+	enc  *Encryption
+	err  error
+}
+
+// EncryptionFromEnv returns the encryption with the key of EncryptionKeyEnv, nil if it isn't set
+func EncryptionFromEnv() (*Encryption, error) {
+	envEncryption.once.Do(func() {
+		if key := os.Getenv(EncryptionKeyEnv); key != "" {
+			envEncryption.enc, envEncryption.err = ParseEncryptionKey(key)
+		}
+	})
+	return envEncryption.enc, envEncryption.err
+}
+
+// encryptedFS is a file system whose SSTables are encrypted with enc
+type encryptedFS struct {
+	vfs.FS
+	enc *Encryption
+}
+
+// EncryptFS returns fsys, on which the functions of this package write SSTables encrypted with enc and read them
+// with it. On other file systems, they use the key of EncryptionKeyEnv if it is set. Plaintext SSTables are read
+// alike, so encryption can be enabled on existing tables, which stay in clear until rewritten.
+func EncryptFS(fsys vfs.FS, enc *Encryption) vfs.FS {
+	if enc == nil {
+		return fsys
+	}
+	if e, ok := fsys.(encryptedFS); ok {
+		fsys = e.FS
+	}
+	return encryptedFS{FS: fsys, enc: enc}
+}
+
+// BaseFS returns the file system fsys was set up on by EncryptFS, fsys itself otherwise
+func BaseFS(fsys vfs.FS) vfs.FS {
+	if e, ok := fsys.(encryptedFS); ok {
+		return e.FS
+	}
+	return fsys
+}
+
+// EncryptionOf returns the encryption of the SSTables of fsys, nil if they aren't encrypted
+func EncryptionOf(fsys vfs.FS) (*Encryption, error) {
+	if e, ok := fsys.(encryptedFS); ok {
+		return e.enc, nil
+	}
+	return EncryptionFromEnv()
+}
+
+// blockAAD returns the data authenticated with block index of a table: its header and the index
+func blockAAD(header []byte, index int64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), header...), uint64(index))
+}
+
+// blockWriter encrypts what is written to it in blocks
+type blockWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  int64
+}
+
+// newBlockWriter returns a writer encrypting the rest of a table after its raw header, with its current key
+func (e *Encryption) newBlockWriter(w io.Writer, header []byte) (*blockWriter, error) {
+	aead, err := e.aead(e.current)
+	if err != nil {
+		return nil, err
+	}
+	return &blockWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, blockSize)}, nil
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), blockSize-len(b.buf))
+		b.buf = append(b.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(b.buf) == blockSize {
+			if err := b.seal(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// seal writes the buffered block
+func (b *blockWriter) seal() error {
+	sealed := make([]byte, nonceSize, nonceSize+len(b.buf)+tagSize)
+	if _, err := rand.Read(sealed); err != nil {
+		return err
+	}
+	sealed = b.aead.Seal(sealed, sealed[:nonceSize], b.buf, blockAAD(b.header, b.index))
+	if _, err := b.w.Write(sealed); err != nil {
+		return err
+	}
+	b.index++
+	b.buf = b.buf[:0]
+	return nil
+}
+
+// Close writes the last block
+func (b *blockWriter) Close() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	return b.seal()
+}
+
+// blockReaderAt reads an encrypted table as if it were in clear, decrypting the blocks it reads.
+// The last block read is kept, so that sequential reads decrypt each block once.
+type blockReaderAt struct {
+	r      io.ReaderAt
+	aead   cipher.AEAD
+	header []byte
+
+	mu     sync.Mutex
+	index  int64 // Index of the block in plain, -1 if none
+	sealed []byte
+	plain  []byte
+}
+
+// decrypt returns the view in clear of a table read through r, whose raw header is header, encrypted with the key
+// named name
+func (e *Encryption) decrypt(r io.ReaderAt, header []byte, name string) (*blockReaderAt, error) {
+	aead, err := e.aead(name)
+	if err != nil {
+		return nil, err
+	}
+	return &blockReaderAt{r: r, aead: aead, header: header, index: -1, sealed: make([]byte, nonceSize+blockSize+tagSize)}, nil
+}
+
+// openBody returns the view in clear of a table read through r, r itself unless it is encrypted
+func openBody(r io.ReaderAt, header *SSTableHeader, enc *Encryption) (io.ReaderAt, error) {
+	if !header.Encrypted {
+		return r, nil
+	}
+	if enc == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	raw := encodeHeader(header)
+	return enc.decrypt(r, raw[:], header.KeyName)
+}
+
+func (b *blockReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos < SSTableHeaderSize {
+			n += copy(p[n:], b.header[pos:])
+			continue
+		}
+		index := (pos - SSTableHeaderSize) / blockSize
+		if err := b.load(index); err != nil {
+			return n, err
+		}
+		start := int(pos - SSTableHeaderSize - index*blockSize)
+		if start >= len(b.plain) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], b.plain[start:])
+	}
+	return n, nil
+}
+
+// load decrypts block index, an empty one past the end of the table
+func (b *blockReaderAt) load(index int64) error {
+	if index == b.index {
+		return nil
+	}
+	b.index = -1
+	n, err := b.r.ReadAt(b.sealed, SSTableHeaderSize+index*int64(len(b.sealed)))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n == 0 {
+		b.plain = b.plain[:0]
+		b.index = index
+		return nil
+	}
+	if n < nonceSize+tagSize {
+		return ErrDecryption
+	}
+	b.plain, err = b.aead.Open(b.plain[:0], b.sealed[:nonceSize], b.sealed[nonceSize:n], blockAAD(b.header, index))
+	if err != nil {
+		return ErrDecryption
+	}
+	b.index = index
+	return nil
+}
+
+// Size returns the size of the table in clear, from the size of the underlying reader and the number of its blocks
+func (b *blockReaderAt) Size() (int64, error) {
+	size, ok := sizeOf(b.r)
+	if !ok {
+		return 0, errUnknownSize
+	}
+	blocks := (size - SSTableHeaderSize + int64(len(b.sealed)) - 1) / int64(len(b.sealed))
+	return size - blocks*(nonceSize+tagSize), nil
+}
+
+// Close closes the underlying reader if it is an io.Closer
+func (b *blockReaderAt) Close() error {
+	if closer, ok := b.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
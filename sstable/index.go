@@ -0,0 +1,380 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"sort"
+)
+
+// From version 4, the checksum of the entries of an SSTable is followed by an index block and a footer, so that a
+// Reader finds a key with a couple of reads instead of decoding the whole table when it opens it. The entries are cut
+// into blocks of about indexBlockSize bytes, each starting with an entry storing its whole key. The index lists the
+// first key of each block, the position of that entry in the table, where the block lies and its checksum, then the
+// last key of the table and the bytes of its deletion entries, then its range deletions if it has any, so that a
+// Reader knows them without reading the blocks:
+//
+//	blocks (uvarint) | per block: key length (uvarint) | key | entry (uvarint) | offset (uvarint) | length (uvarint) |
+//	checksum (4 bytes) | last key length (uvarint) | last key | tombstone bytes (uvarint) | [range deletions (uvarint) |
+//	per range deletion: start length (uvarint) | start | end length (uvarint) | end]
+//
+// The footer, the last footerSize bytes of the table, locates the index:
+//
+//	index offset (8 bytes) | index length (4 bytes) | index checksum (4 bytes) | footerMagic (4 bytes)
+//
+// Offsets are in the view in clear of the table, checksums of the algorithm of its header. In encrypted tables, the
+// index and the footer are encrypted along with the entries.
+
+const (
+	// indexVersion is the first format version with an index block
+	indexVersion uint16 = 4
+	// indexBlockSize is the size past which the next entry starts a new block
+	indexBlockSize = 4 << 10
+	// footerSize is the size of the footer
+	footerSize = 8 + 4 + 4 + 4
+	// footerMagic ends the tables with an index
+	footerMagic = 0x53535449
+	// maxIndexSize bounds the index read from a file, whose footer can't be trusted
+	maxIndexSize = 256 << 20
+)
+
+// ErrCorruptedIndex is returned when the footer or the index block of an SSTable doesn't match its checksum or can't
+// be decoded
+var ErrCorruptedIndex = errors.New("Corrupted SSTable index")
+
+// errUnknownSize is returned by the readers whose size can't be told
+var errUnknownSize = errors.New("Unknown size")
+
+// hasIndex reports whether tables of a format version have an index block
+func hasIndex(version uint16) bool {
+	return version >= indexVersion
+}
+
+// blockHandle is the entry of a block in the index
+type blockHandle struct {
+	firstKey []byte
+	entry    uint64 // Position of the first entry of the block in the table
+	offset   int64
+	length   int64
+	checksum uint32
+}
+
+// tableIndex is the decoded index block of a table
+type tableIndex struct {
+	blocks         []blockHandle
+	lastKey        []byte
+	tombstoneBytes int64
+	ranges         RangeDeletions
+}
+
+// indexBuilder cuts the entries of a table into blocks as they are written, and builds its index
+type indexBuilder struct {
+	index    tableIndex
+	checksum ChecksumType
+	hash     hash.Hash32 // Of the current block, nil when only the size of the index is computed
+	offset   int64       // Offset of the next entry
+	size     int64       // Bytes of the current block
+	entries  uint64      // Entries added
+}
+
+// newIndexBuilder returns the builder of the index of a table of version whose entries start at offset, nil if
+// tables of version have no index. If hashing isn't set, the checksums of the blocks aren't computed.
+func newIndexBuilder(version uint16, checksum ChecksumType, offset int64, hashing bool) *indexBuilder {
+	if !hasIndex(version) {
+		return nil
+	}
+	b := &indexBuilder{checksum: checksum, offset: offset}
+	if hashing {
+		b.hash = checksum.newHash()
+	}
+	return b
+}
+
+// shared returns the bytes of key, the key of entry i, stored as shared with prev, the key of the previous entry,
+// none for the first entry of a block
+func (b *indexBuilder) shared(prev []byte, key []byte, i int, version uint16) int {
+	if b != nil && (len(b.index.blocks) == 0 || b.size >= indexBlockSize) {
+		return 0
+	}
+	return sharedPrefix(prev, key, i, version)
+}
+
+// add records the next entry, written as its header followed by its key without the shared bytes and its value
+func (b *indexBuilder) add(kv *KeyValuePair, header []byte, shared int) {
+	if b == nil {
+		return
+	}
+	if len(b.index.blocks) == 0 || b.size >= indexBlockSize {
+		b.closeBlock()
+		b.index.blocks = append(b.index.blocks, blockHandle{firstKey: kv.Key, entry: b.entries, offset: b.offset})
+		b.size = 0
+	}
+	size := int64(len(header) + len(kv.Key) - shared + len(kv.Value))
+	if b.hash != nil {
+		b.hash.Write(header)
+		b.hash.Write(kv.Key[shared:])
+		b.hash.Write(kv.Value)
+	}
+	if kv.Operation != OpSet {
+		b.index.tombstoneBytes += int64(len(kv.Key) + len(kv.Value))
+	}
+	if kv.Operation == OpDelRange {
+		b.index.ranges = append(b.index.ranges, RangeDeletion{Start: kv.Key, End: kv.Value})
+	}
+	b.index.lastKey = kv.Key
+	b.offset += size
+	b.size += size
+	b.entries++
+}
+
+// closeBlock sets the length and the checksum of the current block
+func (b *indexBuilder) closeBlock() {
+	if len(b.index.blocks) == 0 {
+		return
+	}
+	block := &b.index.blocks[len(b.index.blocks)-1]
+	block.length = b.size
+	if b.hash != nil {
+		block.checksum = b.hash.Sum32()
+		b.hash.Reset()
+	}
+}
+
+// finish returns the index block and the footer, the index starting at offset
+func (b *indexBuilder) finish(offset int64) []byte {
+	b.closeBlock()
+	data := binary.AppendUvarint(nil, uint64(len(b.index.blocks)))
+	for _, block := range b.index.blocks {
+		data = binary.AppendUvarint(data, uint64(len(block.firstKey)))
+		data = append(data, block.firstKey...)
+		data = binary.AppendUvarint(data, block.entry)
+		data = binary.AppendUvarint(data, uint64(block.offset))
+		data = binary.AppendUvarint(data, uint64(block.length))
+		data = binary.BigEndian.AppendUint32(data, block.checksum)
+	}
+	data = binary.AppendUvarint(data, uint64(len(b.index.lastKey)))
+	data = append(data, b.index.lastKey...)
+	data = binary.AppendUvarint(data, uint64(b.index.tombstoneBytes))
+	if len(b.index.ranges) > 0 {
+		data = binary.AppendUvarint(data, uint64(len(b.index.ranges)))
+		for _, r := range b.index.ranges {
+			data = binary.AppendUvarint(data, uint64(len(r.Start)))
+			data = append(data, r.Start...)
+			data = binary.AppendUvarint(data, uint64(len(r.End)))
+			data = append(data, r.End...)
+		}
+	}
+
+	crc := b.checksum.newHash()
+	crc.Write(data)
+	length := len(data)
+	data = binary.BigEndian.AppendUint64(data, uint64(offset))
+	data = binary.BigEndian.AppendUint32(data, uint32(length))
+	data = binary.BigEndian.AppendUint32(data, crc.Sum32())
+	return binary.BigEndian.AppendUint32(data, footerMagic)
+}
+
+// indexSize returns the size of the index block and the footer of keyValues written as a table of version whose
+// entries start at offset, 0 if tables of version have no index
+func indexSize(keyValues []KeyValuePair, version uint16, offset int64) int64 {
+	b := newIndexBuilder(version, ChecksumCRC32, offset, false)
+	if b == nil {
+		return 0
+	}
+	var header [maxEntryHeaderSize]byte
+	for i := range keyValues {
+		shared := b.shared(previousKey(keyValues, i), keyValues[i].Key, i, version)
+		b.add(&keyValues[i], appendEntryHeader(header[:0], version, &keyValues[i], shared), shared)
+	}
+	return int64(len(b.finish(b.offset + 4)))
+}
+
+// decodeIndex decodes an index block, without its footer
+func decodeIndex(data []byte) (*tableIndex, error) {
+	next := func() (uint64, error) {
+		n, size := binary.Uvarint(data)
+		if size <= 0 {
+			return 0, ErrCorruptedIndex
+		}
+		data = data[size:]
+		return n, nil
+	}
+	bytesOf := func(n uint64) ([]byte, error) {
+		if n > uint64(len(data)) {
+			return nil, ErrCorruptedIndex
+		}
+		b := data[:n:n]
+		data = data[n:]
+		return b, nil
+	}
+
+	count, err := next()
+	if err != nil || count > uint64(len(data)) {
+		return nil, ErrCorruptedIndex
+	}
+	index := &tableIndex{blocks: make([]blockHandle, count)}
+	for i := range index.blocks {
+		block := &index.blocks[i]
+		var fields [4]uint64 // Key length, entry, offset, length
+		for j := range fields {
+			if fields[j], err = next(); err != nil {
+				return nil, err
+			}
+			if j == 0 {
+				if block.firstKey, err = bytesOf(fields[0]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		block.entry, block.offset, block.length = fields[1], int64(fields[2]), int64(fields[3])
+		checksum, err := bytesOf(4)
+		if err != nil {
+			return nil, err
+		}
+		block.checksum = binary.BigEndian.Uint32(checksum)
+	}
+	n, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if index.lastKey, err = bytesOf(n); err != nil {
+		return nil, err
+	}
+	tombstoneBytes, err := next()
+	if err != nil {
+		return nil, err
+	}
+	index.tombstoneBytes = int64(tombstoneBytes)
+	if len(data) == 0 {
+		return index, nil
+	}
+	if count, err = next(); err != nil || count == 0 || count > uint64(len(data)) {
+		return nil, ErrCorruptedIndex
+	}
+	index.ranges = make(RangeDeletions, count)
+	for i := range index.ranges {
+		for _, bound := range []*[]byte{&index.ranges[i].Start, &index.ranges[i].End} {
+			if n, err = next(); err != nil {
+				return nil, err
+			}
+			if *bound, err = bytesOf(n); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(data) != 0 {
+		return nil, ErrCorruptedIndex
+	}
+	return index, nil
+}
+
+// readIndex reads the footer and the index block of a table of size bytes through r, its view in clear
+func readIndex(r io.ReaderAt, size int64, checksum ChecksumType) (*tableIndex, error) {
+	if size < SSTableHeaderSize+footerSize {
+		return nil, ErrCorruptedIndex
+	}
+	var footer [footerSize]byte
+	if err := readFullAt(r, footer[:], size-footerSize); err != nil {
+		return nil, err
+	}
+	offset := int64(binary.BigEndian.Uint64(footer[0:8]))
+	length := int64(binary.BigEndian.Uint32(footer[8:12]))
+	if binary.BigEndian.Uint32(footer[16:20]) != footerMagic || length > maxIndexSize || offset < SSTableHeaderSize ||
+		offset+length != size-footerSize {
+		return nil, ErrCorruptedIndex
+	}
+	data := make([]byte, length)
+	if err := readFullAt(r, data, offset); err != nil {
+		return nil, err
+	}
+	crc := checksum.newHash()
+	crc.Write(data)
+	if crc.Sum32() != binary.BigEndian.Uint32(footer[12:16]) {
+		return nil, ErrCorruptedIndex
+	}
+	return decodeIndex(data)
+}
+
+// verifyIndex reads the index block and the footer that follow the checksum of the entries of a table from r, and
+// checks that they describe the entries
+func verifyIndex(r io.Reader, keyValues []KeyValuePair, header *SSTableHeader, offset int64) error {
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	b := newIndexBuilder(header.Version, header.ChecksumType, offset, true)
+	var entryHeader [maxEntryHeaderSize]byte
+	for i := range keyValues {
+		shared := b.shared(previousKey(keyValues, i), keyValues[i].Key, i, header.Version)
+		b.add(&keyValues[i], appendEntryHeader(entryHeader[:0], header.Version, &keyValues[i], shared), shared)
+	}
+	if !bytes.Equal(rest, b.finish(b.offset+4)) {
+		return ErrCorruptedIndex
+	}
+	return nil
+}
+
+// sizeOf returns the size of the table read through r, false if r can't tell it
+func sizeOf(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }: // e.g. bytes.Reader, io.SectionReader
+		return r.Size(), true
+	case interface{ Size() (int64, error) }: // e.g. an object of an object store
+		size, err := r.Size()
+		return size, err == nil
+	case interface{ Stat() (os.FileInfo, error) }: // Files
+		info, err := r.Stat()
+		if err != nil {
+			return 0, false
+		}
+		return info.Size(), true
+	}
+	return 0, false
+}
+
+// findBlocks returns the range of the blocks that may hold key: those from the last block starting before key up to
+// the last one starting with key, an entry for a key being possibly followed by another one in the next block
+func (index *tableIndex) findBlocks(key []byte) (int, int) {
+	first := sort.Search(len(index.blocks), func(i int) bool {
+		return bytes.Compare(index.blocks[i].firstKey, key) >= 0
+	})
+	end := sort.Search(len(index.blocks), func(i int) bool {
+		return bytes.Compare(index.blocks[i].firstKey, key) > 0
+	})
+	return max(first-1, 0), end
+}
+
+// readBlock reads and verifies block i of the table, and decodes its entries, whose keys and values share one buffer
+func (r *Reader) readBlock(i int) ([]KeyValuePair, error) {
+	block := r.index.blocks[i]
+	data := make([]byte, block.length)
+	if err := readFullAt(r.file, data, block.offset); err != nil {
+		return nil, err
+	}
+	crc := r.Header.ChecksumType.newHash()
+	crc.Write(data)
+	if crc.Sum32() != block.checksum {
+		return nil, ErrChecksumMismatch
+	}
+
+	var keyValues []KeyValuePair
+	var prev []byte
+	for entry := block.entry; len(data) > 0; entry++ {
+		h, err := parseEntryHeader(data, r.Header.Version, int(entry), len(prev))
+		if err != nil || h.size+h.unshared+h.valueLen > len(data) {
+			return nil, ErrCorruptedEntry
+		}
+		key := data[h.size : h.size+h.unshared : h.size+h.unshared]
+		if h.shared > 0 {
+			key = append(append(make([]byte, 0, h.keyLen()), prev[:h.shared]...), key...)
+		}
+		value := data[h.size+h.unshared : h.size+h.unshared+h.valueLen]
+		keyValues = append(keyValues, KeyValuePair{Operation: h.op, Key: key, Value: value})
+		prev = key
+		data = data[h.size+h.unshared+h.valueLen:]
+	}
+	return keyValues, nil
+}
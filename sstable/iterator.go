@@ -0,0 +1,141 @@
+package sstable
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Iterator walks entries in ascending key order. A new iterator is positioned at its first entry, Valid reports
+// false once it is past the last one or failed, Err telling which. Key and Value return slices that are only valid
+// until the next call to Next or Seek, and must not be modified.
+type Iterator interface {
+	Valid() bool
+	Next()
+	Seek(key []byte) // Positions the iterator at the first entry whose key is >= key
+	Key() []byte
+	Value() []byte
+	Operation() Operation // OpDel for a deletion marker, OpDelRange for a range deletion
+	Err() error
+}
+
+// rangeDeleter is implemented by the iterators over tables that may hold range deletions, which a MergingIterator
+// reads beforehand
+type rangeDeleter interface {
+	RangeDeletions() RangeDeletions
+}
+
+// sliceIterator walks key-value pairs held in memory
+type sliceIterator struct {
+	keyValues []KeyValuePair
+	pos       int
+}
+
+// NewSliceIterator returns an iterator over key-value pairs sorted by key, e.g. the entries of an SSTable
+func NewSliceIterator(keyValues []KeyValuePair) Iterator {
+	return &sliceIterator{keyValues: keyValues}
+}
+
+func (it *sliceIterator) Valid() bool { return it.pos < len(it.keyValues) }
+func (it *sliceIterator) Next()       { it.pos++ }
+func (it *sliceIterator) Seek(key []byte) {
+	it.pos = sort.Search(len(it.keyValues), func(i int) bool {
+		return bytes.Compare(it.keyValues[i].Key, key) >= 0
+	})
+}
+func (it *sliceIterator) Key() []byte          { return it.keyValues[it.pos].Key }
+func (it *sliceIterator) Value() []byte        { return it.keyValues[it.pos].Value }
+func (it *sliceIterator) Operation() Operation { return it.keyValues[it.pos].Operation }
+func (it *sliceIterator) Err() error           { return nil }
+func (it *sliceIterator) RangeDeletions() RangeDeletions {
+	return RangeDeletionsOf(it.keyValues)
+}
+
+// readerIterator walks the entries of an SSTable file, reading each value when the iterator gets to it
+type readerIterator struct {
+	r     *Reader
+	pos   int
+	value []byte // Value of the entry at pos, nil until read
+	err   error
+}
+
+// NewIterator returns an iterator over the entries of the SSTable that reads the values from the file one at a
+// time, so walking a table takes the memory of its keys only, which the Reader already holds, or a block at a time
+// for a table read through its index. The Reader must stay open while the iterator is used.
+func (r *Reader) NewIterator() Iterator {
+	if r.index != nil {
+		it := &blockIterator{r: r}
+		it.load(0)
+		return it
+	}
+	return &readerIterator{r: r}
+}
+
+func (it *readerIterator) Valid() bool { return it.err == nil && it.pos < len(it.r.entries) }
+func (it *readerIterator) Next()       { it.pos, it.value = it.pos+1, nil }
+func (it *readerIterator) Seek(key []byte) {
+	it.value = nil
+	it.pos = sort.Search(len(it.r.entries), func(i int) bool {
+		return bytes.Compare(it.r.entries[i].key, key) >= 0
+	})
+}
+func (it *readerIterator) Key() []byte          { return it.r.entries[it.pos].key }
+func (it *readerIterator) Operation() Operation { return it.r.entries[it.pos].operation }
+func (it *readerIterator) Err() error           { return it.err }
+func (it *readerIterator) RangeDeletions() RangeDeletions {
+	return it.r.ranges
+}
+
+// Value reads the value of the entry. A failed read makes the iterator invalid.
+func (it *readerIterator) Value() []byte {
+	entry := it.r.entries[it.pos]
+	if it.value != nil {
+		return it.value
+	}
+	value := make([]byte, entry.valueLen)
+	if _, err := it.r.file.ReadAt(value, entry.valueOffset); err != nil {
+		it.err = err
+		return nil
+	}
+	it.value = value
+	return value
+}
+
+// blockIterator walks the entries of an SSTable read through its index, holding one block in memory
+type blockIterator struct {
+	r         *Reader
+	block     int            // Index of the block loaded
+	keyValues []KeyValuePair // Entries of the block
+	pos       int
+	err       error
+}
+
+// load reads block i, and positions the iterator at its first entry. The iterator is past the end if there is no
+// such block.
+func (it *blockIterator) load(i int) {
+	it.block, it.keyValues, it.pos = i, nil, 0
+	if i >= len(it.r.index.blocks) {
+		return
+	}
+	it.keyValues, it.err = it.r.readBlock(i)
+}
+
+func (it *blockIterator) Valid() bool { return it.err == nil && it.pos < len(it.keyValues) }
+func (it *blockIterator) Next() {
+	if it.pos++; it.pos == len(it.keyValues) {
+		it.load(it.block + 1)
+	}
+}
+func (it *blockIterator) Seek(key []byte) {
+	first, _ := it.r.index.findBlocks(key)
+	it.load(first)
+	for it.Valid() && bytes.Compare(it.Key(), key) < 0 {
+		it.Next()
+	}
+}
+func (it *blockIterator) Key() []byte          { return it.keyValues[it.pos].Key }
+func (it *blockIterator) Value() []byte        { return it.keyValues[it.pos].Value }
+func (it *blockIterator) Operation() Operation { return it.keyValues[it.pos].Operation }
+func (it *blockIterator) Err() error           { return it.err }
+func (it *blockIterator) RangeDeletions() RangeDeletions {
+	return it.r.ranges
+}
@@ -0,0 +1,244 @@
+package sstable
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+)
+
+// mergeCursor is an input of a MergingIterator
+type mergeCursor struct {
+	it     Iterator
+	age    int            // Index of the input, higher is newer
+	ranges RangeDeletions // Range deletions of the input, which delete the keys of the older inputs
+}
+
+// mergeHeap orders the inputs by current key, the newest input first for equal keys
+type mergeHeap []*mergeCursor
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].it.Key(), h[j].it.Key()); c != 0 {
+		return c < 0
+	}
+	return h[i].age > h[j].age
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeCursor)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// MergingIterator merges iterators, ordered from the oldest to the newest, into the newest version of each key,
+// with a heap of their current entries: a k-way merge that never holds more than one entry per input.
+type MergingIterator struct {
+	inputs         []*mergeCursor
+	h              mergeHeap
+	dropTombstones bool
+	key            []byte
+	value          []byte
+	op             Operation
+	valid          bool
+	err            error
+}
+
+// NewMergingIterator returns an iterator over the newest version of each key of inputs, ordered from the oldest to
+// the newest. Deletions flushed by older versions may carry a set entry for the same key in the same input, the
+// deletion prevails then. If dropTombstones is set, deleted keys are left out: this is only correct when the
+// inputs include every older version of the keys, i.e. for a full compaction. The range deletions of the inputs
+// aren't returned: the keys of the older inputs they cover are left out, see Merge.
+func NewMergingIterator(inputs []Iterator, dropTombstones bool) *MergingIterator {
+	m := &MergingIterator{dropTombstones: dropTombstones}
+	for age, it := range inputs {
+		c := &mergeCursor{it: it, age: age}
+		if deleter, ok := it.(rangeDeleter); ok {
+			c.ranges = deleter.RangeDeletions()
+		}
+		m.inputs = append(m.inputs, c)
+	}
+	m.reset()
+	return m
+}
+
+// reset rebuilds the heap from the current positions of the inputs and moves to the first entry
+func (m *MergingIterator) reset() {
+	m.h = m.h[:0]
+	for _, c := range m.inputs {
+		if c.it.Valid() {
+			m.h = append(m.h, c)
+		} else if err := c.it.Err(); err != nil {
+			m.err = err
+		}
+	}
+	heap.Init(&m.h)
+	m.Next()
+}
+
+// Next moves to the next key
+func (m *MergingIterator) Next() {
+	for m.err == nil && m.h.Len() > 0 {
+		// The top of the heap holds the newest version of the smallest key, range deletions were read beforehand
+		newest := m.h[0]
+		if newest.it.Operation() == OpDelRange {
+			m.advance()
+			continue
+		}
+		key := append([]byte(nil), newest.it.Key()...)
+		op, value := newest.it.Operation(), newest.it.Value()
+		age := newest.age
+
+		// Skip the other versions of the key
+		for m.h.Len() > 0 && bytes.Equal(m.h[0].it.Key(), key) {
+			c := m.h[0]
+			if c.age == age && c.it.Operation() == OpDel {
+				op, value = OpDel, c.it.Value()
+			}
+			m.advance()
+		}
+		if err := newest.it.Err(); err != nil && m.err == nil {
+			m.err = err // The value couldn't be read
+		}
+
+		if m.deleted(key, age) || (op == OpDel && m.dropTombstones) {
+			continue
+		}
+		m.key, m.value, m.op, m.valid = key, value, op, m.err == nil
+		return
+	}
+	m.valid = false
+}
+
+// advance moves the input at the top of the heap to its next entry
+func (m *MergingIterator) advance() {
+	c := m.h[0]
+	c.it.Next()
+	if c.it.Valid() {
+		heap.Fix(&m.h, 0)
+		return
+	}
+	if err := c.it.Err(); err != nil {
+		m.err = err
+	}
+	heap.Pop(&m.h)
+}
+
+// deleted reports whether a range deletion of an input newer than age covers key
+func (m *MergingIterator) deleted(key []byte, age int) bool {
+	for _, c := range m.inputs[age+1:] {
+		if c.ranges.Covers(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Seek moves every input to the first entry whose key is >= key, and the iterator to the first of them
+func (m *MergingIterator) Seek(key []byte) {
+	for _, c := range m.inputs {
+		c.it.Seek(key)
+	}
+	m.reset()
+}
+
+// RangeDeletions returns the range deletions of the inputs, from the oldest input to the newest, which a merge
+// keeping them writes along with the entries, see WithRangeDeletionsIterator
+func (m *MergingIterator) RangeDeletions() RangeDeletions {
+	var ranges RangeDeletions
+	for _, c := range m.inputs {
+		ranges = append(ranges, c.ranges...)
+	}
+	return ranges
+}
+
+func (m *MergingIterator) Valid() bool          { return m.valid }
+func (m *MergingIterator) Key() []byte          { return m.key }
+func (m *MergingIterator) Value() []byte        { return m.value }
+func (m *MergingIterator) Operation() Operation { return m.op }
+func (m *MergingIterator) Err() error           { return m.err }
+
+// Merge merges tables, ordered from the oldest to the newest, into sorted key-value pairs holding the newest
+// version of each key, with a MergingIterator over their entries. If dropTombstones is set, deleted keys are left
+// out, see NewMergingIterator. Otherwise the range deletions of the tables are kept, for the tables older than
+// them.
+func Merge(tables []*SSTable, dropTombstones bool) []KeyValuePair {
+	inputs := make([]Iterator, len(tables))
+	for i, table := range tables {
+		inputs[i] = NewSliceIterator(table.KeyValues)
+	}
+	var it Iterator = NewMergingIterator(inputs, dropTombstones)
+	if !dropTombstones {
+		it = WithRangeDeletionsIterator(it.(*MergingIterator))
+	}
+	var merged []KeyValuePair
+	for ; it.Valid(); it.Next() {
+		merged = append(merged, KeyValuePair{Operation: it.Operation(), Key: it.Key(), Value: it.Value()})
+	}
+	return merged
+}
+
+// rangeDeletionIterator returns the entries of a merge with the range deletions of its inputs at their place
+type rangeDeletionIterator struct {
+	it     Iterator
+	ranges RangeDeletions // Sorted by start key, from the current one on
+	all    RangeDeletions
+}
+
+// WithRangeDeletionsIterator returns an iterator over the entries of m with the range deletions of its inputs
+// inserted at their place, before the entries of the same key, like WithRangeDeletions
+func WithRangeDeletionsIterator(m *MergingIterator) Iterator {
+	sorted := m.RangeDeletions()
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Start, sorted[j].Start) < 0
+	})
+	return &rangeDeletionIterator{it: m, ranges: sorted, all: sorted}
+}
+
+// atRange reports whether the current entry is a range deletion
+func (r *rangeDeletionIterator) atRange() bool {
+	return len(r.ranges) > 0 && (!r.it.Valid() || bytes.Compare(r.ranges[0].Start, r.it.Key()) <= 0)
+}
+
+func (r *rangeDeletionIterator) Valid() bool {
+	return len(r.ranges) > 0 && r.it.Err() == nil || r.it.Valid()
+}
+
+func (r *rangeDeletionIterator) Next() {
+	if r.atRange() {
+		r.ranges = r.ranges[1:]
+	} else {
+		r.it.Next()
+	}
+}
+
+func (r *rangeDeletionIterator) Seek(key []byte) {
+	r.it.Seek(key)
+	r.ranges = r.all[sort.Search(len(r.all), func(i int) bool {
+		return bytes.Compare(r.all[i].Start, key) >= 0
+	}):]
+}
+
+func (r *rangeDeletionIterator) Key() []byte {
+	if r.atRange() {
+		return r.ranges[0].Start
+	}
+	return r.it.Key()
+}
+
+func (r *rangeDeletionIterator) Value() []byte {
+	if r.atRange() {
+		return r.ranges[0].End
+	}
+	return r.it.Value()
+}
+
+func (r *rangeDeletionIterator) Operation() Operation {
+	if r.atRange() {
+		return OpDelRange
+	}
+	return r.it.Operation()
+}
+
+func (r *rangeDeletionIterator) Err() error { return r.it.Err() }
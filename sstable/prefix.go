@@ -0,0 +1,168 @@
+package sstable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// From version 2, the entries of an SSTable only store the part of their key they don't share with the key of the
+// previous entry, which sorts right before, so keys following a common scheme such as "user:0000123:field" take a
+// few bytes each. Every restartInterval-th entry is a restart point storing its whole key, so that decoding a key
+// never depends on more than the entries since the last restart point. Lengths are varints instead of 4 bytes:
+//
+//	op (1 byte) | shared (uvarint) | unshared (uvarint) | value length (uvarint) | key[shared:] | value
+//
+// Version 1 entries store the whole key, with lengths of 4 bytes:
+//
+//	op (1 byte) | key length (4 bytes) | value length (4 bytes) | key | value
+
+const (
+	// prefixVersion is the first format version with prefix-compressed keys
+	prefixVersion uint16 = 2
+	// restartInterval is the number of entries between two restart points
+	restartInterval = 16
+	// maxEntryHeaderSize is the size of the longest entry header, with lengths of 5 bytes
+	maxEntryHeaderSize = 1 + 3*binary.MaxVarintLen32
+)
+
+// ErrCorruptedEntry is returned when an entry header can't be decoded, e.g. a key sharing more bytes than the
+// previous key has
+var ErrCorruptedEntry = errors.New("Corrupted SSTable entry")
+
+// prefixCompressed reports whether tables of a format version have prefix-compressed keys
+func prefixCompressed(version uint16) bool {
+	return version >= prefixVersion
+}
+
+// entryHeader is the decoded header of an entry
+type entryHeader struct {
+	op       Operation
+	shared   int // Bytes of the key shared with the previous key
+	unshared int // Bytes of the key stored in the entry
+	valueLen int
+	size     int // Bytes of the header
+}
+
+// keyLen returns the length of the key of the entry
+func (h entryHeader) keyLen() int {
+	return h.shared + h.unshared
+}
+
+// sharedPrefix returns the bytes of key, the key of entry i, stored as shared with prev, the key of the previous
+// entry, in the format of version
+func sharedPrefix(prev []byte, key []byte, i int, version uint16) int {
+	if !prefixCompressed(version) || i%restartInterval == 0 {
+		return 0
+	}
+	n := 0
+	for n < len(prev) && n < len(key) && prev[n] == key[n] {
+		n++
+	}
+	return n
+}
+
+// appendEntryHeader appends the header of an entry in the format of version to data, shared being the bytes of its
+// key shared with the previous key
+func appendEntryHeader(data []byte, version uint16, kv *KeyValuePair, shared int) []byte {
+	data = append(data, byte(kv.Operation))
+	if !prefixCompressed(version) {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(kv.Key)))
+		return binary.BigEndian.AppendUint32(data, uint32(len(kv.Value)))
+	}
+	data = binary.AppendUvarint(data, uint64(shared))
+	data = binary.AppendUvarint(data, uint64(len(kv.Key)-shared))
+	return binary.AppendUvarint(data, uint64(len(kv.Value)))
+}
+
+// parseEntryHeader decodes the header of entry i at the start of data, in the format of version, prevLen being the
+// length of the key of the previous entry. It returns io.ErrUnexpectedEOF if data ends before the header.
+func parseEntryHeader(data []byte, version uint16, i int, prevLen int) (entryHeader, error) {
+	if !prefixCompressed(version) {
+		if len(data) < 9 {
+			return entryHeader{}, io.ErrUnexpectedEOF
+		}
+		return entryHeader{
+			op:       Operation(data[0]),
+			unshared: int(binary.BigEndian.Uint32(data[1:5])),
+			valueLen: int(binary.BigEndian.Uint32(data[5:9])),
+			size:     9,
+		}, nil
+	}
+
+	if len(data) == 0 {
+		return entryHeader{}, io.ErrUnexpectedEOF
+	}
+	h := entryHeader{op: Operation(data[0]), size: 1}
+	var lengths [3]int
+	for j := range lengths {
+		length, n := binary.Uvarint(data[h.size:])
+		if n == 0 {
+			return entryHeader{}, io.ErrUnexpectedEOF
+		}
+		if n < 0 || length > math.MaxUint32 {
+			return entryHeader{}, ErrCorruptedEntry
+		}
+		lengths[j] = int(length)
+		h.size += n
+	}
+	h.shared, h.unshared, h.valueLen = lengths[0], lengths[1], lengths[2]
+	if h.shared > prevLen || (h.shared > 0 && i%restartInterval == 0) {
+		return entryHeader{}, ErrCorruptedEntry
+	}
+	return h, nil
+}
+
+// readEntryHeader reads the header of entry i from r like parseEntryHeader, io.EOF if r is at its end
+func readEntryHeader(r *bufio.Reader, version uint16, i int, prevLen int) (entryHeader, error) {
+	data, err := r.Peek(maxEntryHeaderSize)
+	if len(data) == 0 {
+		return entryHeader{}, err
+	}
+	h, err := parseEntryHeader(data, version, i, prevLen)
+	if err != nil {
+		return entryHeader{}, err
+	}
+	_, err = r.Discard(h.size)
+	return h, err
+}
+
+// previousKey returns the key of the entry before entry i of keyValues, nil for the first one
+func previousKey(keyValues []KeyValuePair, i int) []byte {
+	if i == 0 {
+		return nil
+	}
+	return keyValues[i-1].Key
+}
+
+// readKey reads the key of an entry whose header is h into key, which must be h.keyLen() bytes long, after the
+// bytes it shares with prev
+func readKey(r io.Reader, h entryHeader, prev []byte, key []byte) error {
+	copy(key, prev[:h.shared])
+	_, err := io.ReadFull(r, key[h.shared:])
+	return err
+}
+
+// EncodedSize returns the size of the file the table is written to, before encryption
+func (t *SSTable) EncodedSize() int64 {
+	start := SSTableHeaderSize + propertiesBlockSize(t.properties(), t.Header.Version)
+	size := start + entriesSize(t.KeyValues, t.Header.Version) + 4 // Entries and checksum
+	return size + indexSize(t.KeyValues, t.Header.Version, start)
+}
+
+// entriesSize returns the size of keyValues once written as the entries of a table of version
+func entriesSize(keyValues []KeyValuePair, version uint16) int64 {
+	var size int64
+	var header [maxEntryHeaderSize]byte
+	index := newIndexBuilder(version, ChecksumCRC32, 0, false) // Blocks start with a whole key
+	for i := range keyValues {
+		kv := &keyValues[i]
+		shared := index.shared(previousKey(keyValues, i), kv.Key, i, version)
+		entryHeader := appendEntryHeader(header[:0], version, kv, shared)
+		index.add(kv, entryHeader, shared)
+		size += int64(len(entryHeader) + len(kv.Key) - shared + len(kv.Value))
+	}
+	return size
+}
@@ -0,0 +1,245 @@
+package sstable
+
+import (
+	"StorageEngine/vfs"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+)
+
+// From version 3, the header of an SSTable is followed by a properties block describing the table, so that tools and
+// compaction heuristics can tell what it holds from a couple of reads rather than from all of its entries:
+//
+//	length (4 bytes) | properties (length bytes) | CRC32 of the properties (4 bytes)
+//
+// The properties are uvarints: entries, tombstones, raw size, data size, min sequence, max sequence, creation time
+// in nanoseconds since the epoch, then the count of the user properties followed by each of them, sorted by name, as
+// the length of the name, the name, the length of the value and the value. In encrypted tables, the block is
+// encrypted along with the entries.
+
+const (
+	// propertiesVersion is the first format version with a properties block
+	propertiesVersion uint16 = 3
+	// maxPropertiesSize bounds the properties read from a file, whose length can't be trusted
+	maxPropertiesSize = 1 << 20
+)
+
+// ErrCorruptedProperties is returned when the properties block of an SSTable doesn't match its checksum or can't be
+// decoded
+var ErrCorruptedProperties = errors.New("Corrupted SSTable properties")
+
+// Properties describes an SSTable, and is stored in its properties block from format version 3
+type Properties struct {
+	Entries     uint64            `json:"entries"`
+	Tombstones  uint64            `json:"tombstones"`   // Deletion entries
+	RawSize     uint64            `json:"raw_size"`     // Bytes of the keys and values
+	DataSize    uint64            `json:"data_size"`    // Bytes of the entries as written, prefix-compressed keys included
+	MinSequence uint64            `json:"min_sequence"` // Oldest sequence of the entries, set by the writer of the table
+	MaxSequence uint64            `json:"max_sequence"` // Newest sequence of the entries
+	Created     time.Time         `json:"created"`
+	User        map[string]string `json:"user,omitempty"` // Properties added by a PropertiesCollector
+}
+
+// PropertiesCollector computes user-defined properties of an SSTable from its entries, e.g. the number of keys of
+// a prefix or the range of the timestamps of the values, stored in its properties block by CollectProperties
+type PropertiesCollector interface {
+	// Add is called with every entry of the table, in key order
+	Add(kv KeyValuePair)
+	// Finish returns the properties to store once every entry was added
+	Finish() map[string]string
+}
+
+// hasProperties reports whether tables of a format version have a properties block
+func hasProperties(version uint16) bool {
+	return version >= propertiesVersion
+}
+
+// newProperties returns the properties of a table of version holding keyValues, created now
+func newProperties(keyValues []KeyValuePair, version uint16) *Properties {
+	p := &Properties{Entries: uint64(len(keyValues)), DataSize: uint64(entriesSize(keyValues, version)), Created: time.Now()}
+	for _, kv := range keyValues {
+		if kv.Operation != OpSet {
+			p.Tombstones++
+		}
+		p.RawSize += uint64(len(kv.Key) + len(kv.Value))
+	}
+	return p
+}
+
+// CollectProperties runs collectors over the entries of the table, and adds the properties they return to its user
+// properties, written along with it
+func (t *SSTable) CollectProperties(collectors ...PropertiesCollector) {
+	if len(collectors) == 0 {
+		return
+	}
+	props := t.properties()
+	if props.User == nil {
+		props.User = make(map[string]string)
+	}
+	for _, collector := range collectors {
+		for _, kv := range t.KeyValues {
+			collector.Add(kv)
+		}
+		for name, value := range collector.Finish() {
+			props.User[name] = value
+		}
+	}
+	t.Header.Properties = props
+}
+
+// properties returns the properties of the table, computed from its entries if it has none
+func (t *SSTable) properties() *Properties {
+	if t.Header.Properties == nil {
+		return newProperties(t.KeyValues, t.Header.Version)
+	}
+	return t.Header.Properties
+}
+
+// encodeProperties returns the properties block holding p
+func encodeProperties(p *Properties) []byte {
+	data := make([]byte, 4, 64)
+	for _, n := range []uint64{p.Entries, p.Tombstones, p.RawSize, p.DataSize, p.MinSequence, p.MaxSequence, uint64(p.Created.UnixNano())} {
+		data = binary.AppendUvarint(data, n)
+	}
+	names := make([]string, 0, len(p.User))
+	for name := range p.User {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data = binary.AppendUvarint(data, uint64(len(names)))
+	for _, name := range names {
+		data = binary.AppendUvarint(data, uint64(len(name)))
+		data = append(data, name...)
+		data = binary.AppendUvarint(data, uint64(len(p.User[name])))
+		data = append(data, p.User[name]...)
+	}
+	binary.BigEndian.PutUint32(data[:4], uint32(len(data)-4))
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data[4:]))
+}
+
+// decodeProperties decodes the properties of a block, without its length and checksum
+func decodeProperties(data []byte) (*Properties, error) {
+	next := func() (uint64, error) {
+		n, size := binary.Uvarint(data)
+		if size <= 0 {
+			return 0, ErrCorruptedProperties
+		}
+		data = data[size:]
+		return n, nil
+	}
+	nextString := func() (string, error) {
+		n, err := next()
+		if err != nil || n > uint64(len(data)) {
+			return "", ErrCorruptedProperties
+		}
+		s := string(data[:n])
+		data = data[n:]
+		return s, nil
+	}
+
+	var fields [8]uint64
+	for i := range fields {
+		n, err := next()
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = n
+	}
+	p := &Properties{
+		Entries:     fields[0],
+		Tombstones:  fields[1],
+		RawSize:     fields[2],
+		DataSize:    fields[3],
+		MinSequence: fields[4],
+		MaxSequence: fields[5],
+		Created:     time.Unix(0, int64(fields[6])),
+	}
+	if fields[7] > uint64(len(data)) {
+		return nil, ErrCorruptedProperties
+	}
+	if fields[7] > 0 {
+		p.User = make(map[string]string, fields[7])
+	}
+	for i := uint64(0); i < fields[7]; i++ {
+		name, err := nextString()
+		if err != nil {
+			return nil, err
+		}
+		if p.User[name], err = nextString(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// readProperties reads the properties block of a table of version through r, its view in clear. It returns the size
+// of the block, where the entries start after the header, nil properties and 0 for versions without a block.
+func readProperties(r io.ReaderAt, version uint16) (*Properties, int64, error) {
+	if !hasProperties(version) {
+		return nil, 0, nil
+	}
+	var length [4]byte
+	if err := readFullAt(r, length[:], SSTableHeaderSize); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxPropertiesSize {
+		return nil, 0, ErrCorruptedProperties
+	}
+	data := make([]byte, size+4)
+	if err := readFullAt(r, data, SSTableHeaderSize+4); err != nil {
+		return nil, 0, err
+	}
+	if binary.BigEndian.Uint32(data[size:]) != crc32.ChecksumIEEE(data[:size]) {
+		return nil, 0, ErrCorruptedProperties
+	}
+	props, err := decodeProperties(data[:size])
+	return props, int64(size) + 8, err
+}
+
+// readFullAt reads len(p) bytes at off from r, io.ErrUnexpectedEOF if r ends before
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadProperties reads the properties of an SSTable file of fsys from its header and properties block only,
+// decrypting them with the key of fsys. The properties are nil for tables written before format version 3.
+func ReadProperties(fsys vfs.FS, filename string) (*Properties, error) {
+	enc, err := EncryptionOf(fsys)
+	if err != nil {
+		return nil, err
+	}
+	file, err := vfs.Open(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, err := readHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	body, err := openBody(file, header, enc)
+	if err != nil {
+		return nil, err
+	}
+	props, _, err := readProperties(body, header.Version)
+	return props, err
+}
+
+// propertiesBlockSize returns the size of the properties block of tables of version holding p
+func propertiesBlockSize(p *Properties, version uint16) int64 {
+	if !hasProperties(version) {
+		return 0
+	}
+	return int64(len(encodeProperties(p)))
+}
@@ -0,0 +1,74 @@
+package sstable
+
+import (
+	"bytes"
+	"sort"
+)
+
+// A range deletion deletes every key from a start key up to an end key with a single OpDelRange entry, whose key is
+// the start and whose value is the end, excluded, or empty for no end. It sorts by its start key, before the other
+// entries of the same key. A range deletion only deletes the entries older than it, those of the older tables: the
+// entries of its own table are newer, a memtable dropping the keys a range deletion covers when it is applied.
+
+// RangeDeletion is the range of keys deleted by an OpDelRange entry
+type RangeDeletion struct {
+	Start []byte
+	End   []byte // Excluded, no end if empty
+}
+
+// Covers reports whether key lies in the range
+func (r RangeDeletion) Covers(key []byte) bool {
+	return bytes.Compare(key, r.Start) >= 0 && (len(r.End) == 0 || bytes.Compare(key, r.End) < 0)
+}
+
+// Entry returns the entry the range deletion is stored as
+func (r RangeDeletion) Entry() KeyValuePair {
+	return KeyValuePair{Operation: OpDelRange, Key: r.Start, Value: r.End}
+}
+
+// RangeDeletions are the range deletions of a table or of a memtable
+type RangeDeletions []RangeDeletion
+
+// Covers reports whether one of the ranges holds key
+func (rs RangeDeletions) Covers(key []byte) bool {
+	for _, r := range rs {
+		if r.Covers(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// RangeDeletionsOf returns the range deletions among key-value pairs
+func RangeDeletionsOf(keyValues []KeyValuePair) RangeDeletions {
+	var ranges RangeDeletions
+	for _, kv := range keyValues {
+		if kv.Operation == OpDelRange {
+			ranges = append(ranges, RangeDeletion{Start: kv.Key, End: kv.Value})
+		}
+	}
+	return ranges
+}
+
+// WithRangeDeletions returns key-value pairs sorted by key with the entries of ranges inserted at their place
+func WithRangeDeletions(keyValues []KeyValuePair, ranges RangeDeletions) []KeyValuePair {
+	if len(ranges) == 0 {
+		return keyValues
+	}
+	sorted := append(RangeDeletions(nil), ranges...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Start, sorted[j].Start) < 0
+	})
+	merged := make([]KeyValuePair, 0, len(keyValues)+len(sorted))
+	for _, kv := range keyValues {
+		for len(sorted) > 0 && bytes.Compare(sorted[0].Start, kv.Key) <= 0 {
+			merged = append(merged, sorted[0].Entry())
+			sorted = sorted[1:]
+		}
+		merged = append(merged, kv)
+	}
+	for _, r := range sorted {
+		merged = append(merged, r.Entry())
+	}
+	return merged
+}
@@ -0,0 +1,260 @@
+package sstable
+
+import (
+	"StorageEngine/vfs"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+// entryLocation is where the value of an entry lies in an SSTable file
+type entryLocation struct {
+	key         []byte
+	operation   Operation
+	valueOffset int64
+	valueLen    uint32
+}
+
+// Reader looks keys up in an SSTable file it keeps open. A table with an index, from format version 4, is opened by
+// reading its footer and its index only: lookups binary search the first keys of the blocks in memory, then read and
+// verify the one block that may hold the key, so the memory and the time it takes to open a table don't grow with
+// its entries. Older tables, and tables read through a reader that can't tell their size, are read whole once to
+// verify the checksum and locate every entry; lookups then binary search the keys in memory and read the value
+// with a single positioned read, without seeking. A Reader is safe for concurrent use.
+type Reader struct {
+	file    io.ReaderAt
+	Header  SSTableHeader
+	entries []entryLocation // Sorted by key, nil if the table is read through its index
+	index   *tableIndex     // Index of the table, nil if the entries are located
+	ranges  RangeDeletions  // Range deletions of the table
+}
+
+// OpenReader opens an SSTable file for lookups
+func OpenReader(filename string) (*Reader, error) {
+	return OpenReaderFS(vfs.OS, filename)
+}
+
+// OpenReaderFS opens an SSTable file of fsys for lookups, decrypting it with the key of fsys
+func OpenReaderFS(fsys vfs.FS, filename string) (*Reader, error) {
+	enc, err := EncryptionOf(fsys)
+	if err != nil {
+		return nil, err
+	}
+	file, err := vfs.Open(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReaderWith(file, enc)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewReader reads the header and locates the entries of an SSTable read through file, e.g. a remote object,
+// decrypting it with the key of EncryptionKeyEnv. Closing the Reader closes file if it is an io.Closer.
+func NewReader(file io.ReaderAt) (*Reader, error) {
+	enc, err := EncryptionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewReaderWith(file, enc)
+}
+
+// NewReaderWith reads an SSTable through file like NewReader, decrypting it with enc.
+// Values are decrypted along with their block at every lookup.
+func NewReaderWith(file io.ReaderAt, enc *Encryption) (*Reader, error) {
+	header, err := readHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	if file, err = openBody(file, header, enc); err != nil {
+		return nil, err
+	}
+
+	var propertiesSize int64
+	if header.Properties, propertiesSize, err = readProperties(file, header.Version); err != nil {
+		return nil, err
+	}
+	if size, ok := sizeOf(file); ok && hasIndex(header.Version) {
+		index, err := readIndex(file, size, header.ChecksumType)
+		if err != nil {
+			return nil, err
+		}
+		return &Reader{file: file, Header: *header, index: index, ranges: index.ranges}, nil
+	}
+
+	r := &Reader{file: file, Header: *header, entries: make([]entryLocation, 0, header.EntryCount)}
+	offset := SSTableHeaderSize + propertiesSize
+	body := bufio.NewReader(io.NewSectionReader(file, offset, math.MaxInt64-offset))
+	crc := header.ChecksumType.newHash()
+	var key, value []byte
+	for i := uint32(0); i < header.EntryCount; i++ {
+		h, err := readEntryHeader(body, header.Version, int(i), len(key))
+		if err != nil {
+			return nil, err
+		}
+		prev := key
+		key = make([]byte, h.keyLen())
+		if err := readKey(body, h, prev, key); err != nil {
+			return nil, err
+		}
+		if cap(value) < h.valueLen {
+			value = make([]byte, h.valueLen)
+		}
+		if _, err := io.ReadFull(body, value[:h.valueLen]); err != nil {
+			return nil, err
+		}
+		crc.Write(key)
+		crc.Write(value[:h.valueLen])
+		if h.op == OpDelRange {
+			r.ranges = append(r.ranges, RangeDeletion{Start: key, End: append([]byte(nil), value[:h.valueLen]...)})
+		}
+
+		r.entries = append(r.entries, entryLocation{
+			key:         key,
+			operation:   h.op,
+			valueOffset: offset + int64(h.size+h.unshared),
+			valueLen:    uint32(h.valueLen),
+		})
+		offset += int64(h.size + h.unshared + h.valueLen)
+	}
+
+	checksum := make([]byte, 4)
+	if _, err := io.ReadFull(body, checksum); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(checksum) != crc.Sum32() {
+		return nil, ErrChecksumMismatch
+	}
+	return r, nil
+}
+
+// Len returns the number of entries of the SSTable
+func (r *Reader) Len() int {
+	if r.index != nil {
+		return int(r.Header.EntryCount)
+	}
+	return len(r.entries)
+}
+
+// TombstoneBytes returns the size of the keys and values of the deletion entries of the SSTable
+func (r *Reader) TombstoneBytes() int64 {
+	if r.index != nil {
+		return r.index.tombstoneBytes
+	}
+	var size int64
+	for _, entry := range r.entries {
+		if entry.operation != OpSet {
+			size += int64(len(entry.key)) + int64(entry.valueLen)
+		}
+	}
+	return size
+}
+
+// RangeDeletions returns the range deletions of the SSTable
+func (r *Reader) RangeDeletions() RangeDeletions {
+	return r.ranges
+}
+
+// InRange reports whether key lies between the smallest and the largest key of the SSTable, or in one of its range
+// deletions, false if it is empty
+func (r *Reader) InRange(key []byte) bool {
+	if r.ranges.Covers(key) {
+		return true
+	}
+	if r.index != nil {
+		return len(r.index.blocks) > 0 && bytes.Compare(key, r.index.blocks[0].firstKey) >= 0 &&
+			bytes.Compare(key, r.index.lastKey) <= 0
+	}
+	return len(r.entries) > 0 && bytes.Compare(key, r.entries[0].key) >= 0 &&
+		bytes.Compare(key, r.entries[len(r.entries)-1].key) <= 0
+}
+
+// Get looks key up and returns its entry, and false if the SSTable has none.
+// When the table holds both a set and a delete for the key, the delete wins. A key without an entry but covered by
+// a range deletion of the table is returned as a deletion.
+func (r *Reader) Get(key []byte) (KeyValuePair, bool, error) {
+	var kv KeyValuePair
+	var found bool
+	var err error
+	if r.index != nil {
+		kv, found, err = r.getIndexed(key)
+	} else {
+		kv, found, err = r.getLocated(key)
+	}
+	if err == nil && !found && r.ranges.Covers(key) {
+		return KeyValuePair{Operation: OpDel, Key: key}, true, nil
+	}
+	return kv, found, err
+}
+
+// getLocated looks key up like Get in the entries located when the table was opened
+func (r *Reader) getLocated(key []byte) (KeyValuePair, bool, error) {
+	idx := sort.Search(len(r.entries), func(i int) bool {
+		return bytes.Compare(r.entries[i].key, key) >= 0
+	})
+	found := -1
+	for i := idx; i < len(r.entries) && bytes.Equal(r.entries[i].key, key); i++ {
+		if r.entries[i].operation == OpDelRange {
+			continue
+		}
+		if found < 0 || r.entries[i].operation == OpDel {
+			found = i
+		}
+	}
+	if found < 0 {
+		return KeyValuePair{}, false, nil
+	}
+
+	entry := r.entries[found]
+	kv := KeyValuePair{Operation: entry.operation, Key: entry.key}
+	if entry.operation == OpDel {
+		return kv, true, nil
+	}
+	kv.Value = make([]byte, entry.valueLen)
+	if _, err := r.file.ReadAt(kv.Value, entry.valueOffset); err != nil {
+		return KeyValuePair{}, false, err
+	}
+	return kv, true, nil
+}
+
+// getIndexed looks key up like Get in the blocks of the index that may hold it
+func (r *Reader) getIndexed(key []byte) (KeyValuePair, bool, error) {
+	var found KeyValuePair
+	ok := false
+	first, end := r.index.findBlocks(key)
+	for i := first; i < end; i++ {
+		keyValues, err := r.readBlock(i)
+		if err != nil {
+			return KeyValuePair{}, false, err
+		}
+		j := sort.Search(len(keyValues), func(j int) bool {
+			return bytes.Compare(keyValues[j].Key, key) >= 0
+		})
+		for ; j < len(keyValues) && bytes.Equal(keyValues[j].Key, key); j++ {
+			if keyValues[j].Operation == OpDelRange {
+				continue
+			}
+			if !ok || keyValues[j].Operation == OpDel {
+				found, ok = keyValues[j], true
+			}
+		}
+	}
+	if ok && found.Operation == OpDel {
+		found.Value = nil
+	}
+	return found, ok, nil
+}
+
+// Close closes the SSTable file
+func (r *Reader) Close() error {
+	if closer, ok := r.file.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package sstable
+
+import (
+	"StorageEngine/vfs"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// EntrySize is the size of a key-value pair once written in an SSTable with its whole key, as in version 1.
+// Prefix compression makes entries smaller from version 2, so it is an upper bound for the tables written now.
+func EntrySize(kv KeyValuePair) int64 {
+	return int64(9 + len(kv.Key) + len(kv.Value))
+}
+
+// Split cuts key-value pairs sorted by key into runs whose SSTables stay within targetSize bytes.
+// Runs are only cut between different keys, so the entries of a key stay in one table, and a key larger
+// than targetSize gets a table of its own. A targetSize of 0 or less keeps everything in a single run.
+func Split(keyValues []KeyValuePair, targetSize int64) [][]KeyValuePair {
+	if targetSize <= 0 || len(keyValues) == 0 {
+		return [][]KeyValuePair{keyValues}
+	}
+
+	var runs [][]KeyValuePair
+	start := 0
+	size := int64(SSTableHeaderSize + 4) // Header and checksum
+	for i := 0; i < len(keyValues); {
+		// The entries of a key, a deletion and a set for instance, go to the same table
+		end, keySize := i, int64(0)
+		for ; end < len(keyValues) && bytes.Equal(keyValues[end].Key, keyValues[i].Key); end++ {
+			keySize += EntrySize(keyValues[end])
+		}
+		if i > start && size+keySize > targetSize {
+			runs = append(runs, keyValues[start:i])
+			start = i
+			size = SSTableHeaderSize + 4
+		}
+		size += keySize
+		i = end
+	}
+	return append(runs, keyValues[start:])
+}
+
+// SplitFilename returns the name of the i-th table of a split output named filename, filename itself for the first
+func SplitFilename(filename string, i int) string {
+	if i == 0 {
+		return filename
+	}
+	return fmt.Sprintf("%s_%d.sst", strings.TrimSuffix(filename, ".sst"), i)
+}
+
+// WriteSSTables writes key-value pairs sorted by key to SSTables of at most targetSize bytes, split as by Split
+// and named by SplitFilename. It returns the names of the tables written.
+func WriteSSTables(filename string, keyValues []KeyValuePair, targetSize int64) ([]string, error) {
+	return WriteSSTablesFS(vfs.OS, filename, keyValues, targetSize)
+}
+
+// WriteSSTablesFS writes key-value pairs to SSTables of fsys like WriteSSTables
+func WriteSSTablesFS(fsys vfs.FS, filename string, keyValues []KeyValuePair, targetSize int64) ([]string, error) {
+	runs := Split(keyValues, targetSize)
+	filenames := make([]string, 0, len(runs))
+	for i, run := range runs {
+		name := SplitFilename(filename, i)
+		if err := WriteSSTableFS(fsys, name, NewSSTable(run)); err != nil {
+			return filenames, err
+		}
+		filenames = append(filenames, name)
+	}
+	return filenames, nil
+}
@@ -1,13 +1,19 @@
 package sstable
 
 import (
+	"StorageEngine/vfs"
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"hash/crc32"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 )
 
 type Operation uint8
@@ -15,12 +21,33 @@ type Operation uint8
 const (
 	OpSet Operation = iota
 	OpDel
+	OpDelRange // Deletes the keys from its key up to its value, see RangeDeletion
 )
 
 const (
 	SSTableHeaderSize = 4 + 4 + 4 + 4 + 2
+	// CurrentVersion is the version of the SSTable format written by this package
+	CurrentVersion uint16 = 4
+
+	// writeBufferSize is the size of the buffer SSTables are written through
+	writeBufferSize = 64 << 10
+	// slabSize is the size of the slabs small keys and values are read into
+	slabSize = 32 << 10
+	// maxPreallocatedEntries bounds the entries preallocated from the count of an SSTable header
+	maxPreallocatedEntries = 1 << 16
 )
 
+// writers holds the buffered writers SSTables are written through, reused across tables
+var writers = sync.Pool{New: func() interface{} {
+	return bufio.NewWriterSize(nil, writeBufferSize)
+}}
+
+// ErrChecksumMismatch is returned when the checksum stored in an SSTable doesn't match its entries
+var ErrChecksumMismatch = errors.New("Checksum mismatch!")
+
+// SupportedVersions lists the SSTable format versions this package can read
+var SupportedVersions = []uint16{1, 2, 3, 4}
+
 // SSTableHeader represents the header of the SSTable file.
 type SSTableHeader struct {
 	MagicNumber uint32
@@ -28,11 +55,17 @@ type SSTableHeader struct {
 	SmallestKey []byte
 	LargestKey  []byte
 	Version     uint16
+	Encrypted   bool   // Whether the entries are encrypted, see EncryptFS
+	KeyName     string // Name of the key the entries are encrypted with, in place of the key prefixes
+	// Algorithm of the checksum of the entries, set by SetChecksum
+	ChecksumType ChecksumType
+	// Properties of the table, stored after the header from version 3, nil for older tables
+	Properties *Properties
 }
 
 // KeyValuePair represents a key-value pair with an operation flag.
 type KeyValuePair struct {
-	Operation Operation // Indicates 'set' or 'delete' operation
+	Operation Operation // Indicates 'set', 'delete' or 'delete range' operation
 	Key       []byte
 	Value     []byte
 }
@@ -53,11 +86,27 @@ type Pair struct {
 
 // CreateAndWriteSSTable writes a memtable to an SSTable file.
 func CreateAndWriteSSTable(filename string, data map[string]Pair) error {
+	table := NewSSTable(MemtableKeyValues(data))
+
+	// Write the SSTable to the file
+	return WriteSSTable(filename, table)
+}
+
+// CreateAndWriteSSTables writes a memtable like CreateAndWriteSSTable, split into SSTables of at most
+// targetSize bytes as by WriteSSTables. It returns the names of the tables written.
+func CreateAndWriteSSTables(filename string, data map[string]Pair, targetSize int64) ([]string, error) {
+	return WriteSSTables(filename, MemtableKeyValues(data), targetSize)
+}
+
+// MemtableKeyValues converts a memtable to key-value pairs sorted by key
+func MemtableKeyValues(data map[string]Pair) []KeyValuePair {
 	// Convert map to a slice of KeyValuePair
 	var keyValuePairs []KeyValuePair
 	for key, value := range data {
+		// A deleted key is written as a deletion alone, never with a value
 		if value.Marker {
 			keyValuePairs = append(keyValuePairs, KeyValuePair{Operation: OpDel, Key: []byte(key), Value: nil})
+			continue
 		}
 		keyValuePairs = append(keyValuePairs, KeyValuePair{Operation: OpSet, Key: []byte(key), Value: value.Value})
 	}
@@ -66,7 +115,19 @@ func CreateAndWriteSSTable(filename string, data map[string]Pair) error {
 	sort.Slice(keyValuePairs, func(i, j int) bool {
 		return bytes.Compare(keyValuePairs[i].Key, keyValuePairs[j].Key) < 0
 	})
+	return keyValuePairs
+}
+
+// ShadowedByDeletion reports whether the i-th entry of keyValues is a set following a deletion of the same key.
+// Flushes used to write deleted keys as a deletion plus a set of their old value, in either order; the deletion
+// prevails, so readers skip such a set.
+func ShadowedByDeletion(keyValues []KeyValuePair, i int) bool {
+	return i > 0 && keyValues[i].Operation == OpSet && keyValues[i-1].Operation == OpDel &&
+		bytes.Equal(keyValues[i-1].Key, keyValues[i].Key)
+}
 
+// NewSSTable builds an SSTable, header and checksum included, from key-value pairs sorted by key.
+func NewSSTable(keyValuePairs []KeyValuePair) *SSTable {
 	// Set the smallest and largest keys
 	smallestKey := keyValuePairs[0].Key
 	largestKey := keyValuePairs[len(keyValuePairs)-1].Key
@@ -78,97 +139,195 @@ func CreateAndWriteSSTable(filename string, data map[string]Pair) error {
 			EntryCount:  uint32(len(keyValuePairs)), // Number of entries in the SSTable
 			SmallestKey: smallestKey,                // Smallest key in the SSTable
 			LargestKey:  largestKey,                 // Largest key in the SSTable
-			Version:     1,                          // Version number for the SSTable format
+			Version:     CurrentVersion,             // Version number for the SSTable format
 		},
 		KeyValues: keyValuePairs,
 		Checksum:  uint32(0), // Checksum is initially set to 0
 	}
+	table.Header.Properties = newProperties(keyValuePairs, CurrentVersion)
 
 	// Calculate Checksum
 	checksum := calculateChecksum(table)
 	table.Checksum = checksum
 
-	// Write the SSTable to the file
-	return WriteSSTable(filename, table)
+	return table
 }
 
-// WriteSSTable writes the SSTable to a file.
+// WriteSSTable writes the SSTable to a new file, failing if filename already exists rather than writing over it.
+// Writes go through a buffer flushed once at the end, instead of three system calls per entry.
 func WriteSSTable(filename string, table *SSTable) error {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return WriteSSTableFS(vfs.OS, filename, table)
+}
+
+// WriteSSTableFS writes the SSTable to a new file of fsys like WriteSSTable, encrypted if fsys is set up to by
+// EncryptFS or the key of EncryptionKeyEnv is set
+func WriteSSTableFS(fsys vfs.FS, filename string, table *SSTable) error {
+	return writeTable(fsys, filename, table, NewSliceIterator(table.KeyValues), false)
+}
+
+// writeTable writes the header, the properties and the checksum of table to a new file of fsys, with the
+// table.Header.EntryCount entries read from entries. If streamed is set, the entries are checked against the
+// checksum, and the iterator is left after the last entry written.
+func writeTable(fsys vfs.FS, filename string, table *SSTable, entries Iterator, streamed bool) error {
+	enc, err := EncryptionOf(fsys)
+	if err != nil {
+		return err
+	}
+	file, err := fsys.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-
-	//  Write the header
-	if err := writeHeader(file, &table.Header); err != nil {
+	w := writers.Get().(*bufio.Writer)
+	w.Reset(file)
+	defer func() {
+		w.Reset(nil)
+		writers.Put(w)
+	}()
+
+	//  Write the header, then the rest of the file encrypted if a key is set
+	header := table.Header
+	var sealer *blockWriter
+	if enc != nil {
+		header.Encrypted, header.KeyName = true, enc.Current()
+		header.SmallestKey, header.LargestKey = nil, nil // Not even key prefixes in clear
+	}
+	if err := writeHeader(w, &header); err != nil {
 		return err
 	}
-	// Write the key-value pairs
-	for _, kv := range table.KeyValues {
-		if err := writeKeyValuePair(file, &kv); err != nil {
+	if enc != nil {
+		if err := w.Flush(); err != nil {
 			return err
 		}
+		raw := encodeHeader(&header)
+		if sealer, err = enc.newBlockWriter(file, raw[:]); err != nil {
+			return err
+		}
+		w.Reset(sealer)
+	}
+	// Write the properties block, encrypted with the entries
+	offset := int64(SSTableHeaderSize)
+	if hasProperties(header.Version) {
+		properties := encodeProperties(table.properties())
+		if _, err := w.Write(properties); err != nil {
+			return err
+		}
+		offset += int64(len(properties))
+	}
+	// Write the key-value pairs, cut into the blocks of the index
+	index := newIndexBuilder(header.Version, header.ChecksumType, offset, true)
+	crc := header.ChecksumType.newHash()
+	count := int(header.EntryCount)
+	if !streamed {
+		count = len(table.KeyValues)
+	}
+	var prev []byte
+	for i := 0; i < count; i++ {
+		if !entries.Valid() {
+			if err := entries.Err(); err != nil {
+				return err
+			}
+			return ErrEntriesChanged
+		}
+		kv := KeyValuePair{Operation: entries.Operation(), Key: entries.Key(), Value: entries.Value()}
+		if err := entries.Err(); err != nil {
+			return err // The value couldn't be read
+		}
+		if streamed {
+			// The index keeps keys, which the iterator may reuse
+			kv.Key = append([]byte(nil), kv.Key...)
+			crc.Write(kv.Key)
+			crc.Write(kv.Value)
+		}
+		shared := index.shared(prev, kv.Key, i, header.Version)
+		if err := writeKeyValuePair(w, &kv, header.Version, shared, index); err != nil {
+			return err
+		}
+		prev = kv.Key
+		entries.Next()
+	}
+	if streamed && crc.Sum32() != table.Checksum {
+		return ErrEntriesChanged
 	}
 
 	// Write the checksum to the file
-	cs := make([]byte, 4)
-	binary.BigEndian.PutUint32(cs, table.Checksum)
-	_, err = file.Write(cs)
+	var cs [4]byte
+	binary.BigEndian.PutUint32(cs[:], table.Checksum)
+	_, err = w.Write(cs[:])
 	if err != nil {
 		return err
 	}
+	// Then the index and the footer
+	if index != nil {
+		if _, err := w.Write(index.finish(index.offset + 4)); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	// Flush the buffered writes before the file is closed
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if sealer != nil {
+		if err := sealer.Close(); err != nil {
+			return err
+		}
+	}
+	// Tables are only referenced once written, so they are synced before anything points at them
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
 }
 
 // writeHeader writes SSTable header to a file.
-func writeHeader(file *os.File, header *SSTableHeader) error {
+func writeHeader(w *bufio.Writer, header *SSTableHeader) error {
+	data := encodeHeader(header)
+	_, err := w.Write(data[:])
+	return err
+}
 
-	// Prepare the data to be written
-	data := make([]byte, SSTableHeaderSize)
+// encodeHeader returns the bytes of an SSTable header
+func encodeHeader(header *SSTableHeader) [SSTableHeaderSize]byte {
+	var data [SSTableHeaderSize]byte
 
 	magicNumber := uint32(header.MagicNumber)
 	entryCount := uint32(header.EntryCount)
 	binary.BigEndian.PutUint32(data[:4], magicNumber)
 	binary.BigEndian.PutUint32(data[4:8], entryCount)
 
-	copy(data[8:12], header.SmallestKey)
-	copy(data[12:16], header.LargestKey)
-
-	version := uint16(header.Version)
-	binary.BigEndian.PutUint16(data[16:18], version)
-
-	_, err := file.Write(data)
-	if err != nil {
-		return err
+	if header.Encrypted {
+		copy(data[8:16], header.KeyName)
+	} else {
+		copy(data[8:12], header.SmallestKey)
+		copy(data[12:16], header.LargestKey)
 	}
 
-	return nil
+	version := uint16(header.Version) | uint16(header.ChecksumType)<<checksumShift
+	if header.Encrypted {
+		version |= encryptedFlag
+	}
+	binary.BigEndian.PutUint16(data[16:18], version)
+	return data
 }
 
-// Function to write KeyValuePair to file
-func writeKeyValuePair(file *os.File, kv *KeyValuePair) error {
+// Function to write KeyValuePair to file in the format of version, without the shared bytes of its key, adding it
+// to index if not nil. The entry header is encoded in the free space of the buffer, so nothing is allocated per entry.
+func writeKeyValuePair(w *bufio.Writer, kv *KeyValuePair, version uint16, shared int, index *indexBuilder) error {
 
 	// Prepare the data to be written
-	data := make([]byte, 9)
+	data := appendEntryHeader(w.AvailableBuffer(), version, kv, shared)
+	index.add(kv, data, shared)
 
-	op := uint8(kv.Operation)
-	keyLen := uint32(len(kv.Key))
-	valueLen := uint32(len(kv.Value))
-	data[0] = byte(op)
-	binary.BigEndian.PutUint32(data[1:5], keyLen)
-	binary.BigEndian.PutUint32(data[5:9], valueLen)
-
-	_, err := file.Write(data)
+	_, err := w.Write(data)
 	if err != nil {
 		return err
 	}
-	_, err = file.Write(kv.Key)
+	_, err = w.Write(kv.Key[shared:])
 	if err != nil {
 		return err
 	}
-	_, err = file.Write(kv.Value)
+	_, err = w.Write(kv.Value)
 	if err != nil {
 		return err
 	}
@@ -176,11 +335,11 @@ func writeKeyValuePair(file *os.File, kv *KeyValuePair) error {
 
 }
 
-// calculateChecksum calculates a CRC32 checksum for an SSTable.
-// It uses CRC (Cyclic Redundancy Check) to generate a checksum by hashing the bytes of all keys and values in the SSTable.
+// calculateChecksum calculates the checksum of an SSTable with the algorithm of its header, CRC32 by default.
+// It hashes the bytes of all keys and values in the SSTable.
 // This helps detect data corruption or errors during read operations.
 func calculateChecksum(table *SSTable) uint32 {
-	crc := crc32.NewIEEE()
+	crc := table.Header.ChecksumType.newHash()
 
 	for _, kv := range table.KeyValues {
 		crc.Write(kv.Key)
@@ -191,23 +350,55 @@ func calculateChecksum(table *SSTable) uint32 {
 }
 
 // ReadSSTable reads the SSTable from a file.
+// The header is read with a positioned read, the entries and the checksum through one buffered pass over the rest.
 func ReadSSTable(filename string) (*SSTable, error) {
+	return ReadSSTableFS(vfs.OS, filename)
+}
+
+// ReadSSTableFS reads the SSTable from a file of fsys like ReadSSTable, decrypting it with the key of fsys
+func ReadSSTableFS(fsys vfs.FS, filename string) (*SSTable, error) {
+	enc, err := EncryptionOf(fsys)
+	if err != nil {
+		return nil, err
+	}
 
 	// Open the file
-	file, err := os.Open(filename)
+	file, err := vfs.Open(fsys, filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
+	return ReadSSTableAtWith(file, enc)
+}
 
+// ReadSSTableAt reads an SSTable through r, e.g. a remote object, decrypting it with the key of EncryptionKeyEnv
+func ReadSSTableAt(r io.ReaderAt) (*SSTable, error) {
+	enc, err := EncryptionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return ReadSSTableAtWith(r, enc)
+}
+
+// ReadSSTableAtWith reads an SSTable through r like ReadSSTableAt, decrypting it with enc
+func ReadSSTableAtWith(r io.ReaderAt, enc *Encryption) (*SSTable, error) {
 	// Read the header
-	header, err := readHeader(file)
+	header, err := readHeader(r)
 	if err != nil {
 		return nil, err
 	}
+	if r, err = openBody(r, header, enc); err != nil {
+		return nil, err
+	}
+	var propertiesSize int64
+	if header.Properties, propertiesSize, err = readProperties(r, header.Version); err != nil {
+		return nil, err
+	}
 
 	// Read the key-value pairs
-	keyValues, err := readKeyValues(file, header.EntryCount)
+	start := SSTableHeaderSize + propertiesSize
+	body := bufio.NewReader(io.NewSectionReader(r, start, math.MaxInt64-start))
+	keyValues, err := readKeyValues(body, header.EntryCount, header.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -216,14 +407,20 @@ func ReadSSTable(filename string) (*SSTable, error) {
 	expectedChecksum := calculateChecksum(&SSTable{Header: *header, KeyValues: keyValues})
 
 	actualChecksumBuffer := make([]byte, 4)
-	_, err = io.ReadFull(file, actualChecksumBuffer)
+	_, err = io.ReadFull(body, actualChecksumBuffer)
 	if err != nil {
 		return nil, err
 	}
 	actualChecksum := binary.BigEndian.Uint32(actualChecksumBuffer[:4])
 
 	if actualChecksum != expectedChecksum {
-		return nil, errors.New("Checksum mismatch!")
+		return nil, ErrChecksumMismatch
+	}
+	// The index must describe the entries
+	if hasIndex(header.Version) {
+		if err := verifyIndex(body, keyValues, header, start); err != nil {
+			return nil, err
+		}
 	}
 
 	return &SSTable{
@@ -233,11 +430,11 @@ func ReadSSTable(filename string) (*SSTable, error) {
 	}, nil
 }
 
-// Function to read SSTable header from file
-func readHeader(file *os.File) (*SSTableHeader, error) {
+// Function to read SSTable header from the start of a file
+func readHeader(file io.ReaderAt) (*SSTableHeader, error) {
 
 	data := make([]byte, SSTableHeaderSize)
-	_, err := io.ReadFull(file, data)
+	_, err := io.ReadFull(io.NewSectionReader(file, 0, SSTableHeaderSize), data)
 	if err != nil {
 		return nil, err
 	}
@@ -248,47 +445,69 @@ func readHeader(file *os.File) (*SSTableHeader, error) {
 	smallestKey := data[8:12]
 	largestKey := data[12:16]
 
-	version := binary.BigEndian.Uint16(data[16:18])
+	field := binary.BigEndian.Uint16(data[16:18])
+	version, checksum := splitVersion(field)
+	if !checksum.valid() {
+		return nil, ErrUnknownChecksum
+	}
+
+	// Encrypted tables hold the name of their key instead
+	if field&encryptedFlag != 0 {
+		return &SSTableHeader{MagicNumber: magicNumber,
+			EntryCount:   entryCount,
+			Version:      version,
+			Encrypted:    true,
+			KeyName:      string(bytes.TrimRight(data[8:16], "\x00")),
+			ChecksumType: checksum}, nil
+	}
 
 	return &SSTableHeader{MagicNumber: magicNumber,
-		EntryCount:  entryCount,
-		SmallestKey: smallestKey,
-		LargestKey:  largestKey,
-		Version:     version}, nil
+		EntryCount:   entryCount,
+		SmallestKey:  smallestKey,
+		LargestKey:   largestKey,
+		Version:      version,
+		ChecksumType: checksum}, nil
 }
 
-// Function to read KeyValues from file
-func readKeyValues(file *os.File, count uint32) ([]KeyValuePair, error) {
-	var keyValues []KeyValuePair
+// Function to read count KeyValues in the format of version from a reader positioned on the first entry.
+// Small keys and values are carved out of shared slabs rather than allocated one entry at a time.
+func readKeyValues(r *bufio.Reader, count uint32, version uint16) ([]KeyValuePair, error) {
+	// The count comes from the file, don't trust it for more than a bounded preallocation
+	keyValues := make([]KeyValuePair, 0, min(count, maxPreallocatedEntries))
+	var slab []byte
+	var prev []byte
 	for i := uint32(0); i < count; i++ {
 		kv := KeyValuePair{}
 
-		data := make([]byte, 9)
-		_, err := io.ReadFull(file, data)
+		h, err := readEntryHeader(r, version, int(i), len(prev))
 		if err != nil {
 			return nil, err
 		}
-
-		op := Operation(data[0])
-		keyLen := binary.BigEndian.Uint32(data[1:5])
-		valueLen := binary.BigEndian.Uint32(data[5:9])
-
-		key := make([]byte, keyLen)
-		_, err = io.ReadFull(file, key)
-		if err != nil {
-			return nil, err
+		keyLen := h.keyLen()
+
+		// Key and value share one allocation, taken from the slab when they are small
+		size := keyLen + h.valueLen
+		var buf []byte
+		if size > slabSize/4 {
+			buf = make([]byte, size)
+		} else {
+			if len(slab) < size {
+				slab = make([]byte, slabSize)
+			}
+			buf, slab = slab[:size:size], slab[size:]
 		}
-
-		val := make([]byte, valueLen)
-		_, err = io.ReadFull(file, val)
+		// The shared bytes of the key come from the previous one
+		copy(buf, prev[:h.shared])
+		_, err = io.ReadFull(r, buf[h.shared:])
 		if err != nil {
 			return nil, err
 		}
 
-		kv.Operation = op
-		kv.Key = key
-		kv.Value = val
+		kv.Operation = h.op
+		kv.Key = buf[:keyLen:keyLen]
+		kv.Value = buf[keyLen:]
 		keyValues = append(keyValues, kv)
+		prev = kv.Key
 	}
 	return keyValues, nil
 }
@@ -296,13 +515,24 @@ func readKeyValues(file *os.File, count uint32) ([]KeyValuePair, error) {
 // MergeSSTables merges multiple SSTable files into a single, larger SSTable file as part of the compaction process
 // This function is called in the memdb.go file
 func MergeSSTables(sstableIDs []string, outputDir string) (string, error) {
+	filenames, err := MergeSSTablesSplit(sstableIDs, outputDir, 0)
+	if err != nil {
+		return "", err
+	}
+	return filenames[0], nil
+}
+
+// MergeSSTablesSplit merges SSTable files like MergeSSTables, into tables of at most targetSize bytes
+// named as by SplitFilename. It returns the names of the tables written. The range deletions of a table delete the
+// keys of the tables before it, and aren't written to the output.
+func MergeSSTablesSplit(sstableIDs []string, outputDir string, targetSize int64) ([]string, error) {
 	// Read data from all SSTable files specified by sstableIDs
 	var mergedData map[string]Pair
 
 	for _, sstableID := range sstableIDs {
 		sst, err := ReadSSTable(sstableID)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		
 		// Logic to merge contents (keys and values) from sst into mergedData
@@ -313,13 +543,25 @@ func MergeSSTables(sstableIDs []string, outputDir string) (string, error) {
 
 		// Merge data from this SSTable into the mergedData map
 		// i.e. simulate the process
-		for _, kv := range sst.KeyValues {
+		for i, kv := range sst.KeyValues {
+			// The deletion of a key prevails over a set of it in the same table
+			if ShadowedByDeletion(sst.KeyValues, i) {
+				continue
+			}
 			switch kv.Operation {
 			case OpSet:
 				mergedData[string(kv.Key)] = Pair{Value: kv.Value, Marker: false}
 			case OpDel:
 				// If there's a delete operation, mark the key as deleted in the mergedData
 				mergedData[string(kv.Key)] = Pair{Value: nil, Marker: true}
+			case OpDelRange:
+				// The keys of the older tables in the range are deleted, those of this table are newer
+				ranges := RangeDeletions{{Start: kv.Key, End: kv.Value}}
+				for key := range mergedData {
+					if ranges.Covers([]byte(key)) {
+						mergedData[key] = Pair{Value: nil, Marker: true}
+					}
+				}
 			}
 		}
 	}
@@ -328,11 +570,90 @@ func MergeSSTables(sstableIDs []string, outputDir string) (string, error) {
 	// The name will be compact_sstable_[x.time].sst
 	// where x is from the last sst file in sstableIDs
 	lastSST := sstableIDs[len(sstableIDs)-1]
-	mergedSSTableFilename := outputDir + "/compact_sstable_" + lastSST[len(outputDir)+1+12:]
-	err := CreateAndWriteSSTable(mergedSSTableFilename, mergedData)
+	mergedSSTableFilename := filepath.Join(outputDir, "compact_sstable_"+strings.TrimPrefix(filepath.Base(lastSST), "sstable_file"))
+	return CreateAndWriteSSTables(mergedSSTableFilename, mergedData, targetSize)
+}
+
+// SalvageSSTable decodes the entries of a possibly corrupted SSTable file, stopping at the first entry that can't be
+// decoded: unknown operation, length past the end of the file, or key out of order. The checksum isn't verified.
+// It returns the entries decoded so far, and an error describing where decoding stopped if it didn't reach
+// the entry count of the header. Encrypted tables are decrypted with the key of EncryptionKeyEnv, up to the first
+// block that doesn't decrypt.
+func SalvageSSTable(filename string) ([]KeyValuePair, error) {
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if len(data) < SSTableHeaderSize {
+		return nil, errors.New("Truncated header")
+	}
+	// An encrypted table is salvaged up to its first block that doesn't decrypt
+	if header, _ := readHeader(bytes.NewReader(data)); header.Encrypted {
+		enc, err := EncryptionFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		plain, err := openBody(bytes.NewReader(data), header, enc)
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(io.NewSectionReader(plain, 0, math.MaxInt64))
+		if err == ErrDecryption {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The entry count can only be trusted if the header looks valid
+	count := -1
+	if binary.BigEndian.Uint32(data[:4]) == 221003 {
+		count = int(binary.BigEndian.Uint32(data[4:8]))
+	}
+
+	version, _ := splitVersion(binary.BigEndian.Uint16(data[16:18]))
+
+	// The entries follow the properties block, whose checksum isn't verified either
+	pos := SSTableHeaderSize
+	if hasProperties(version) {
+		if len(data) < pos+4 {
+			return nil, errors.New("Truncated properties")
+		}
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if size > len(data) || pos+size+8 > len(data) {
+			return nil, errors.New("Truncated properties")
+		}
+		pos += size + 8
 	}
 
-	return mergedSSTableFilename, nil
+	var keyValues []KeyValuePair
+	var prev []byte
+	for count < 0 || len(keyValues) < count {
+		h, err := parseEntryHeader(data[pos:], version, len(keyValues), len(prev))
+		if err == io.ErrUnexpectedEOF {
+			return keyValues, fmt.Errorf("Truncated entry %d at offset %d", len(keyValues), pos)
+		}
+		if err != nil {
+			return keyValues, fmt.Errorf("Invalid key prefix in entry %d at offset %d", len(keyValues), pos)
+		}
+		if h.op > OpDelRange {
+			return keyValues, fmt.Errorf("Invalid operation in entry %d at offset %d", len(keyValues), pos)
+		}
+		start := pos + h.size
+		if h.unshared > len(data) || h.valueLen > len(data) || start+h.unshared+h.valueLen > len(data) {
+			return keyValues, fmt.Errorf("Truncated entry %d at offset %d", len(keyValues), pos)
+		}
+		key := data[start : start+h.unshared]
+		if h.shared > 0 {
+			key = append(append(make([]byte, 0, h.keyLen()), prev[:h.shared]...), key...)
+		}
+		if n := len(keyValues); n > 0 && bytes.Compare(key, keyValues[n-1].Key) < 0 {
+			return keyValues, fmt.Errorf("Key out of order in entry %d at offset %d", len(keyValues), pos)
+		}
+		keyValues = append(keyValues, KeyValuePair{Operation: h.op, Key: key, Value: data[start+h.unshared : start+h.unshared+h.valueLen]})
+		prev = key
+		pos = start + h.unshared + h.valueLen
+	}
+	return keyValues, nil
 }
@@ -0,0 +1,128 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxHash64 (https://github.com/Cyan4973/xxHash) with a seed of 0, computed in stripes of 32 bytes
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxHash64 is the state of an xxHash64 computation. It implements hash.Hash32 with the low 32 bits of the hash, the
+// whole of it being returned by Sum64.
+type xxHash64 struct {
+	v     [4]uint64 // Accumulators of the lanes of the stripes
+	total uint64    // Bytes written
+	buf   [32]byte  // Bytes written past the last whole stripe
+	n     int       // Bytes held by buf
+}
+
+// newXXHash64 returns a new xxHash64 computation
+func newXXHash64() *xxHash64 {
+	h := &xxHash64{}
+	h.Reset()
+	return h
+}
+
+// Reset restarts the computation
+func (h *xxHash64) Reset() {
+	// The accumulators start from sums wrapping around, which constants can't
+	p1, p2 := xxPrime1, xxPrime2
+	h.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	h.total, h.n = 0, 0
+}
+
+// Size returns the size of the checksums returned by Sum
+func (h *xxHash64) Size() int { return 4 }
+
+// BlockSize returns the size of the stripes
+func (h *xxHash64) BlockSize() int { return 32 }
+
+// Write adds p to the hashed bytes
+func (h *xxHash64) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n+len(p) < 32 {
+		h.n += copy(h.buf[h.n:], p)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.buf[h.n:], p)
+		h.stripe(h.buf[:])
+		p, h.n = p[c:], 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		h.stripe(p)
+	}
+	h.n = copy(h.buf[:], p)
+	return n, nil
+}
+
+// stripe mixes 32 bytes into the accumulators
+func (h *xxHash64) stripe(p []byte) {
+	h.v[0] = xxRound(h.v[0], binary.LittleEndian.Uint64(p[0:8]))
+	h.v[1] = xxRound(h.v[1], binary.LittleEndian.Uint64(p[8:16]))
+	h.v[2] = xxRound(h.v[2], binary.LittleEndian.Uint64(p[16:24]))
+	h.v[3] = xxRound(h.v[3], binary.LittleEndian.Uint64(p[24:32]))
+}
+
+// Sum64 returns the hash of the bytes written so far
+func (h *xxHash64) Sum64() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		v := h.v
+		sum = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) + bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, lane := range v {
+			sum ^= xxRound(0, lane)
+			sum = sum*xxPrime1 + xxPrime4
+		}
+	} else {
+		sum = xxPrime5
+	}
+	sum += h.total
+
+	p := h.buf[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		sum ^= xxRound(0, binary.LittleEndian.Uint64(p))
+		sum = bits.RotateLeft64(sum, 27)*xxPrime1 + xxPrime4
+	}
+	if len(p) >= 4 {
+		sum ^= uint64(binary.LittleEndian.Uint32(p)) * xxPrime1
+		sum = bits.RotateLeft64(sum, 23)*xxPrime2 + xxPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		sum ^= uint64(b) * xxPrime5
+		sum = bits.RotateLeft64(sum, 11) * xxPrime1
+	}
+
+	sum ^= sum >> 33
+	sum *= xxPrime2
+	sum ^= sum >> 29
+	sum *= xxPrime3
+	sum ^= sum >> 32
+	return sum
+}
+
+// Sum32 returns the low 32 bits of the hash
+func (h *xxHash64) Sum32() uint32 {
+	return uint32(h.Sum64())
+}
+
+// Sum appends the low 32 bits of the hash to b, big-endian
+func (h *xxHash64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, h.Sum32())
+}
+
+// xxRound mixes 8 bytes of input into an accumulator
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
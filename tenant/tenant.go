@@ -0,0 +1,231 @@
+package tenant
+
+import (
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrTenantExists   = errors.New("Tenant already exists")
+	ErrInvalidTenant  = errors.New("Invalid tenant name")
+	ErrTenantNotFound = errors.New("Tenant not found")
+)
+
+const (
+	// TenantHeader selects the tenant when the request path has no tenant prefix
+	TenantHeader = "X-Tenant"
+	// APIKeyHeader carries the tenant's API key
+	APIKeyHeader = "X-API-Key"
+	// WALFileName is the name of the WAL file inside a tenant directory
+	WALFileName = "wal.log"
+	// SSTableDirName is the name of the SSTable directory inside a tenant directory
+	SSTableDirName = "SSTableFiles"
+)
+
+// Config describes a tenant hosted by the server
+type Config struct {
+	Name    string         // Name of the tenant, used as the path prefix
+	Dir     string         // Directory holding the tenant's WAL and SSTables
+	APIKey  string         // Key required in the X-API-Key header, no authentication if empty
+	Options []memdb.Option // Options for the tenant's database
+	Rate    float64        // Requests per second allowed on average, no limit if 0
+	Burst   int            // Requests allowed at once above Rate, one second of requests if 0
+}
+
+// Stats holds the request counters of a tenant
+type Stats struct {
+	Name     string            `json:"name"`
+	Requests uint64            `json:"requests"`
+	Errors   uint64            `json:"errors"`          // Requests answered with a 4xx or 5xx status
+	Rejected uint64            `json:"unauthorized"`    // Requests rejected because of a wrong API key
+	Limited  uint64            `json:"rate_limited"`    // Requests rejected because of the request rate quota
+	Quota    *memdb.QuotaStats `json:"quota,omitempty"` // Storage quota usage, nil if the tenant has no quota
+}
+
+// Tenant is an independent database hosted by the server
+type Tenant struct {
+	Name     string
+	DB       *memdb.DB
+	WAL      *memdb.WAL
+	apiKey   string
+	mux      *http.ServeMux
+	requests uint64
+	errors   uint64
+	rejected uint64
+	limited  uint64
+	limiter  *rateLimiter // Request rate quota, nil if there is none
+}
+
+// Stats returns a snapshot of the tenant's request counters
+func (t *Tenant) Stats() Stats {
+	return Stats{
+		Name:     t.Name,
+		Requests: atomic.LoadUint64(&t.requests),
+		Errors:   atomic.LoadUint64(&t.errors),
+		Rejected: atomic.LoadUint64(&t.rejected),
+		Limited:  atomic.LoadUint64(&t.limited),
+		Quota:    t.DB.QuotaStats(),
+	}
+}
+
+// Registry holds the tenants hosted by a server and routes requests to them
+type Registry struct {
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{tenants: make(map[string]*Tenant)}
+}
+
+// validName reports whether name can be used as a tenant name, i.e. a single path segment
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// Open opens (or creates) the database of a tenant and adds it to the registry
+func (r *Registry) Open(cfg Config) (*Tenant, error) {
+	if !validName(cfg.Name) {
+		return nil, ErrInvalidTenant
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tenants[cfg.Name]; ok {
+		return nil, ErrTenantExists
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	wal, err := memdb.OpenWAL(filepath.Join(cfg.Dir, WALFileName))
+	if err != nil {
+		return nil, err
+	}
+	db, err := memdb.NewDB(wal, filepath.Join(cfg.Dir, SSTableDirName), cfg.Options...)
+	if err != nil {
+		wal.Close()
+		return nil, err
+	}
+
+	t := &Tenant{
+		Name:    cfg.Name,
+		DB:      db,
+		WAL:     wal,
+		apiKey:  cfg.APIKey,
+		limiter: newRateLimiter(cfg.Rate, cfg.Burst),
+	}
+	t.mux = handlers.NewMux(db, wal)
+	t.mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Stats())
+	})
+	r.tenants[cfg.Name] = t
+	return t, nil
+}
+
+// Get returns the tenant with the given name
+func (r *Registry) Get(name string) (*Tenant, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tenants[name]
+	return t, ok
+}
+
+// Names returns the sorted names of all tenants
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tenants))
+	for name := range r.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the database and WAL of every tenant and empties the registry
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var firstErr error
+	for name, t := range r.tenants {
+		if err := t.DB.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := t.WAL.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(r.tenants, name)
+	}
+	return firstErr
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// ServeHTTP routes a request to its tenant.
+// The tenant is taken from the X-Tenant header if present, otherwise from the first path segment
+// (/{tenant}/get?key=...), which is stripped before the request reaches the tenant's handlers.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := req.Header.Get(TenantHeader)
+	path := req.URL.Path
+	if name == "" {
+		trimmed := strings.TrimPrefix(path, "/")
+		name, path, _ = strings.Cut(trimmed, "/")
+		path = "/" + path
+	}
+
+	t, ok := r.Get(name)
+	if !ok {
+		http.Error(w, ErrTenantNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	atomic.AddUint64(&t.requests, 1)
+
+	if t.apiKey != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get(APIKeyHeader)), []byte(t.apiKey)) != 1 {
+		atomic.AddUint64(&t.rejected, 1)
+		atomic.AddUint64(&t.errors, 1)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if ok, wait := t.limiter.allow(); !ok {
+		atomic.AddUint64(&t.limited, 1)
+		atomic.AddUint64(&t.errors, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Request rate quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	// Hand a copy of the request with the tenant prefix removed to the tenant's mux
+	routed := req.Clone(req.Context())
+	routed.URL.Path = path
+	routed.URL.RawPath = ""
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	t.mux.ServeHTTP(rec, routed)
+	if rec.status >= 400 {
+		atomic.AddUint64(&t.errors, 1)
+	}
+}
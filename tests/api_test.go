@@ -3,10 +3,18 @@ package tests
 import (
 	"StorageEngine/handlers"
 	"StorageEngine/memdb"
+	"StorageEngine/sstable"
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -162,3 +170,624 @@ func notGrantedDeleteTest(t *testing.T, db *memdb.DB, wal *memdb.WAL, key string
 		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, recorder.Code)
 	}
 }
+
+// TestHotKeys checks that the most accessed keys are reported by /admin/hotkeys
+func TestHotKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.HotKeys(2, 1))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	setTest(t, db, wal, `{"a":"1", "b":"2", "c":"3"}`)
+	for i := 0; i < 10; i++ {
+		grantedGetTest(t, db, "b", "2")
+	}
+	for i := 0; i < 5; i++ {
+		grantedGetTest(t, db, "c", "3")
+	}
+
+	req, err := http.NewRequest("GET", "/admin/hotkeys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handlers.HotKeysHandler(db).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	var hot []memdb.HotKey
+	if err := json.Unmarshal(recorder.Body.Bytes(), &hot); err != nil {
+		t.Fatal(err)
+	}
+	expected := []memdb.HotKey{{Key: "b", Count: 11}, {Key: "c", Count: 6}}
+	if !reflect.DeepEqual(hot, expected) {
+		t.Errorf("Expected hot keys %v, got %v", expected, hot)
+	}
+}
+
+// TestAccessStats checks the per-key read and write counters reported by /stats/hotkeys and Stats
+func TestAccessStats(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.AccessStats(2, 0, 0))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	setTest(t, db, wal, `{"a":"1", "b":"2", "c":"3"}`)
+	for i := 0; i < 10; i++ {
+		grantedGetTest(t, db, "b", "2")
+	}
+	for i := 0; i < 5; i++ {
+		grantedGetTest(t, db, "c", "3")
+	}
+	if err := db.Set("c", []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "/stats/hotkeys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handlers.AccessStatsHandler(db).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var hot []memdb.KeyAccess
+	if err := json.Unmarshal(recorder.Body.Bytes(), &hot); err != nil {
+		t.Fatal(err)
+	}
+	expected := []memdb.KeyAccess{{Key: "b", Reads: 10, Writes: 1}, {Key: "c", Reads: 5, Writes: 2}}
+	if !reflect.DeepEqual(hot, expected) {
+		t.Errorf("Expected hot keys %v, got %v", expected, hot)
+	}
+
+	// Keys outside of the report can still be looked up
+	if access, ok := db.KeyAccessStats("a"); !ok || access.Reads != 0 || access.Writes != 1 {
+		t.Errorf("Unexpected access stats for a: %+v", access)
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats.HotKeys, expected) {
+		t.Errorf("Expected hot keys %v in stats, got %v", expected, stats.HotKeys)
+	}
+}
+
+// TestVersion checks that /version reports the build and the formats found on disk
+func TestVersion(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	setTest(t, db, wal, `{"a":"1", "b":"2"}`)
+
+	req, err := http.NewRequest("GET", "/version", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handlers.VersionHandler(db).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	var version handlers.VersionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Build.Version != memdb.Version() || version.Build.Commit == "" {
+		t.Errorf("Unexpected build info: %+v", version.Build)
+	}
+	if version.Formats.WALVersion != memdb.WALFormatVersion {
+		t.Errorf("Expected WAL version %d, got %d", memdb.WALFormatVersion, version.Formats.WALVersion)
+	}
+	expected := map[uint16]int{sstable.CurrentVersion: 1}
+	if !reflect.DeepEqual(version.Formats.SSTableVersions, expected) {
+		t.Errorf("Expected SSTable versions %v, got %v", expected, version.Formats.SSTableVersions)
+	}
+}
+
+// TestIdempotency checks that a write retried with the same Idempotency-Key is only applied once
+func TestIdempotency(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	handler := handlers.NewIdempotency(0, 0).Handler(handlers.NewMux(db, wal))
+
+	send := func(method string, target string, body string, key string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, target, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set(handlers.IdempotencyKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if rec := send("POST", "/set", `{"a":"1"}`, "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// The retry of a deletion gets the answer of the first attempt, not 404
+	first := send("DELETE", "/del?key=a", "", "k2")
+	retry := send("DELETE", "/del?key=a", "", "k2")
+	if first.Code != http.StatusOK || retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected the retry to be answered like the first attempt, got %d %q then %d %q",
+			first.Code, first.Body.String(), retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Errorf("Expected the retry to be marked as replayed")
+	}
+	if rec := send("DELETE", "/del?key=a", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d without a key, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// A key can't be reused for another request
+	if rec := send("DELETE", "/del?key=b", "", "k2"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+
+	// Endpoints are matched by their full path: the retry of an append doesn't append again
+	first = send("POST", "/stream/append?stream=events", "e1", "k4")
+	retry = send("POST", "/stream/append?stream=events", "e1", "k4")
+	if first.Code != http.StatusOK || retry.Body.String() != first.Body.String() || retry.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Errorf("Expected the retry of the append to be replayed, got %d %q then %q", first.Code, first.Body.String(), retry.Body.String())
+	}
+	if entries, err := db.StreamRead("events", 0, 0); err != nil || len(entries) != 1 {
+		t.Errorf("Expected a single entry appended, got %d (%v)", len(entries), err)
+	}
+
+	// Retries of a push, a pop or a transaction aren't applied again either
+	send("POST", "/list/push?key=queue", "j1", "k5")
+	send("POST", "/list/push?key=queue", "j2", "k6")
+	if rec := send("POST", "/list/push?key=queue", "j2", "k6"); rec.Code != http.StatusOK || rec.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Errorf("Expected the retry of the push to be replayed, got %d", rec.Code)
+	}
+	if length, err := db.ListLen("queue"); err != nil || length != 2 {
+		t.Errorf("Expected 2 elements pushed, got %d (%v)", length, err)
+	}
+	first = send("POST", "/list/pop?key=queue", "", "k7")
+	retry = send("POST", "/list/pop?key=queue", "", "k7")
+	if first.Body.String() != "j2" || retry.Body.String() != "j2" {
+		t.Errorf("Expected the retry of the pop to answer j2 again, got %q then %q", first.Body.String(), retry.Body.String())
+	}
+	if length, err := db.ListLen("queue"); err != nil || length != 1 {
+		t.Errorf("Expected 1 element left in the list, got %d (%v)", length, err)
+	}
+	tx := `{"reads": {"c": null}, "writes": {"c": "1"}}`
+	if rec := send("POST", "/tx", tx, "k8"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	// Applied again, the transaction would conflict with its own write
+	if rec := send("POST", "/tx", tx, "k8"); rec.Code != http.StatusOK || rec.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Errorf("Expected the retry of the transaction to be replayed, got %d", rec.Code)
+	}
+
+	// The retry of an acquisition gets the token of the first attempt instead of 409 Conflict
+	first = send("POST", "/lock/acquire?name=job&ttl=30s", "", "k9")
+	retry = send("POST", "/lock/acquire?name=job&ttl=30s", "", "k9")
+	var acquired, replayed struct {
+		Token uint64 `json:"token"`
+	}
+	if err := json.Unmarshal(first.Body.Bytes(), &acquired); err != nil || first.Code != http.StatusOK {
+		t.Fatalf("Expected the lock to be acquired, got %d %q", first.Code, first.Body.String())
+	}
+	if err := json.Unmarshal(retry.Body.Bytes(), &replayed); err != nil || retry.Code != http.StatusOK ||
+		replayed.Token != acquired.Token || retry.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Errorf("Expected the retry of the acquisition to answer token %d again, got %d %q", acquired.Token, retry.Code, retry.Body.String())
+	}
+	release := fmt.Sprintf("/lock/release?name=job&token=%d", acquired.Token)
+	if rec := send("POST", release, "", "k10"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := send("POST", release, "", "k10"); rec.Code != http.StatusNoContent || rec.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Errorf("Expected the retry of the release to be replayed, got %d", rec.Code)
+	}
+
+	// Keys are forgotten once the store is full
+	handler = handlers.NewIdempotency(0, 1).Handler(handlers.NewMux(db, wal))
+	send("POST", "/set", `{"a":"1"}`, "k1")
+	send("POST", "/set", `{"b":"2"}`, "k3")
+	if rec := send("POST", "/set", `{"a":"2"}`, "k1"); rec.Code != http.StatusOK || rec.Header().Get(handlers.IdempotentReplayedHeader) != "" {
+		t.Errorf("Expected the forgotten key to be applied again, got %d", rec.Code)
+	}
+	if value, err := db.Get("a"); err != nil || string(value) != "2" {
+		t.Errorf("Expected value 2, got %s (%v)", value, err)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.SizeLimits(8, 16))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// Writes over the limits are refused, those at the limits accepted
+	if err := db.Set("12345678", bytes.Repeat([]byte("v"), 16)); err != nil {
+		t.Errorf("Expected write at the limits to succeed, got %v", err)
+	}
+	if err := db.Set("123456789", []byte("v")); err != memdb.ErrKeyTooLarge {
+		t.Errorf("Expected key too large error, got %v", err)
+	}
+	if err := db.Set("key", bytes.Repeat([]byte("v"), 17)); err != memdb.ErrValueTooLarge {
+		t.Errorf("Expected value too large error, got %v", err)
+	}
+	if err := db.Ingest([]memdb.KeyValue{{Key: "a", Value: []byte("v")}, {Key: "b", Value: bytes.Repeat([]byte("v"), 17)}}); err != memdb.ErrValueTooLarge {
+		t.Errorf("Expected value too large error on ingestion, got %v", err)
+	}
+	if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected nothing ingested, got %v", err)
+	}
+	if err := db.SetPath("doc", "list", []byte(`["aaaa","bbbb"]`)); err != memdb.ErrValueTooLarge {
+		t.Errorf("Expected the updated document to be too large, got %v", err)
+	}
+
+	// The HTTP API answers 413
+	mux := handlers.NewMux(db, wal)
+	for _, test := range []struct {
+		target string
+		body   string
+	}{
+		{"/set", `{"123456789":"v"}`},
+		{"/set", `{"key":"12345678901234567"}`},
+		{"/set", `{"key":"` + strings.Repeat("v", 4<<10) + `"}`},
+		{"/setpath?key=doc&path=a", `"12345678901234567"`},
+	} {
+		req := httptest.NewRequest("POST", test.target, strings.NewReader(test.body))
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status code %d for %s %s, got %d", http.StatusRequestEntityTooLarge, test.target, test.body, recorder.Code)
+		}
+	}
+	if value, err := db.Get("12345678"); err != nil || len(value) != 16 {
+		t.Errorf("Expected the value written at the limits, got %q, %v", value, err)
+	}
+}
+
+func TestBinaryKeysAPI(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	mux := handlers.NewMux(db, wal)
+	do := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Keys are written in base64 in the JSON body, and read back URL-escaped or in base64
+	body := make(map[string]string)
+	for _, key := range binaryKeys {
+		body[base64.StdEncoding.EncodeToString([]byte(key))] = "value"
+	}
+	data, _ := json.Marshal(body)
+	if recorder := do("POST", "/set?encoding=base64", string(data)); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+	for _, key := range binaryKeys {
+		if value, err := db.Get(key); err != nil || string(value) != "value" {
+			t.Errorf("Expected %q stored, got %q, %v", key, value, err)
+		}
+		if recorder := do("GET", "/get?key="+url.QueryEscape(key), ""); recorder.Code != http.StatusOK {
+			t.Errorf("Expected status code %d for %q, got %d", http.StatusOK, key, recorder.Code)
+		}
+		if recorder := do("GET", "/get?encoding=base64&key="+url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(key))), ""); recorder.Code != http.StatusOK {
+			t.Errorf("Expected status code %d for %q in base64, got %d", http.StatusOK, key, recorder.Code)
+		}
+	}
+
+	// Scans return the keys in base64, the prefix being in base64 too
+	recorder := do("GET", "/scan?encoding=base64&prefix="+url.QueryEscape(base64.StdEncoding.EncodeToString([]byte("\xff"))), "")
+	var results []handlers.ScanResult
+	if err := json.NewDecoder(recorder.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Key != base64.StdEncoding.EncodeToString([]byte("\xff\xfe\x80")) {
+		t.Errorf("Expected the invalid UTF-8 key in base64, got %v", results)
+	}
+
+	// Deletions too, and keys that aren't base64 are refused
+	if recorder := do("GET", "/del?key=a%00b", ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if _, err := db.Get("a\x00b"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected deleted key to be missing, got %v", err)
+	}
+	if recorder := do("GET", "/get?encoding=base64&key=not*base64", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if recorder := do("GET", "/get?encoding=hex&key=00", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestBlobAPI(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.ChunkSize(1<<10))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(handlers.NewMux(db, wal))
+	defer server.Close()
+
+	// The body is stored in chunks as it is received, and read back raw
+	blob := bytes.Repeat([]byte{0, 1, 2, 3, 255}, 3<<10)
+	req, _ := http.NewRequest("PUT", server.URL+"/blob?key=file", bytes.NewReader(blob))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	resp, err = http.Get(server.URL + "/blob?key=file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, blob) {
+		t.Errorf("Expected the blob, got status code %d and %d bytes", resp.StatusCode, len(body))
+	}
+
+	// The ETag of a chunked value doesn't read its chunks, but changes with an upload
+	req, _ = http.NewRequest("GET", server.URL+"/blob?key=file", nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotModified, resp.StatusCode)
+	}
+	if err := db.SetReader("file", bytes.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d after an upload, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/blob?key=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+// TestETag checks that /get answers 304 Not Modified while the value matches the If-None-Match of the request
+func TestETag(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(handlers.NewMux(db, wal))
+	defer server.Close()
+
+	get := func(ifNoneMatch string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", server.URL+"/get?key=hot", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if err := db.Set("hot", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := get("")
+	tag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || body != "Value: v1" || !strings.HasPrefix(tag, `"`) {
+		t.Fatalf("Expected v1 with an ETag, got %d, %q, %q", resp.StatusCode, body, tag)
+	}
+	for _, ifNoneMatch := range []string{tag, `"other", W/` + tag, "*"} {
+		if resp, body := get(ifNoneMatch); resp.StatusCode != http.StatusNotModified || body != "" || resp.Header.Get("ETag") != tag {
+			t.Errorf("If-None-Match %s: expected 304 without a body, got %d, %q", ifNoneMatch, resp.StatusCode, body)
+		}
+	}
+
+	// Flushed values keep their tag, new ones get another one
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get(tag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304 after a flush, got %d", resp.StatusCode)
+	}
+	if err := db.Set("hot", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := get(tag); resp.StatusCode != http.StatusOK || body != "Value: v2" || resp.Header.Get("ETag") == tag {
+		t.Errorf("Expected v2 with a new ETag, got %d, %q", resp.StatusCode, body)
+	}
+}
+
+// TestTxHandler checks that /tx commits its writes only while the keys read still have the values the client read
+func TestTxHandler(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(handlers.NewMux(db, wal))
+	defer server.Close()
+
+	commit := func(body string) int {
+		resp, err := http.Post(server.URL+"/tx", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	db.Set("stock", []byte("5"))
+	tx := `{"reads": {"stock": "5", "reserved/1": null}, "writes": {"stock": "4", "reserved/1": "1"}, "deletes": ["cart/1"]}`
+	if status := commit(tx); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	values, _ := db.GetMulti([]string{"stock", "reserved/1"})
+	if string(values["stock"]) != "4" || string(values["reserved/1"]) != "1" {
+		t.Errorf("Expected the writes of the transaction, got %q", values)
+	}
+
+	// The same request again read stale values
+	if status := commit(tx); status != http.StatusConflict {
+		t.Errorf("Expected 409 for stale reads, got %d", status)
+	}
+	if value, _ := db.Get("stock"); string(value) != "4" {
+		t.Errorf("Expected the conflicting transaction to write nothing, got %q", value)
+	}
+	if status := commit(`{"writes": {"": "1"}}`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty key, got %d", status)
+	}
+	if status := commit(`not json`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid body, got %d", status)
+	}
+}
+
+// TestSetIfMatch checks that /set with an If-Match header only writes while the value has the tag
+func TestSetIfMatch(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(handlers.NewMux(db, wal))
+	defer server.Close()
+
+	set := func(ifMatch string, body string) int {
+		req, _ := http.NewRequest("POST", server.URL+"/set", strings.NewReader(body))
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := set("*", `{"doc": "v1"}`); status != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for a missing key, got %d", status)
+	}
+	if err := db.Set("doc", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := db.ETag("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := set(`"stale"`, `{"doc": "v2"}`); status != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for another tag, got %d", status)
+	}
+	// If-Match compares tags strongly: the weak form of the current tag doesn't match
+	if status := set("W/"+tag, `{"doc": "v2"}`); status != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for a weak tag, got %d", status)
+	}
+	if status := set(tag, `{"doc": "v1", "other": "v1"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for several pairs, got %d", status)
+	}
+	if status := set(tag, `{"doc": "v2"}`); status != http.StatusOK {
+		t.Errorf("Expected 200 for the current tag, got %d", status)
+	}
+	if value, _ := db.Get("doc"); string(value) != "v2" {
+		t.Errorf("Expected v2, got %q", value)
+	}
+
+	// The tag read before the write is stale now
+	if status := set(tag, `{"doc": "v3"}`); status != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for the previous tag, got %d", status)
+	}
+	if status := set("*", `{"doc": {"n": 3}}`); status != http.StatusOK {
+		t.Errorf("Expected 200 for *, got %d", status)
+	}
+	if value, _ := db.Get("doc"); string(value) != `{"n":3}` {
+		t.Errorf("Expected the JSON value, got %q", value)
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"StorageEngine/audit"
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestAuditLog checks that successful writes are recorded with their actor and failed ones are not
+func TestAuditLog(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	logPath := tempDir + "/audit.log"
+	auditLog, err := audit.OpenFile(logPath)
+	if err != nil {
+		t.Fatalf("Error opening audit log: %s", err)
+	}
+	handler := audit.Handler(handlers.NewMux(db, wal), auditLog.Sink(func(err error) { t.Error(err) }))
+
+	serve := func(method, target, body string) {
+		req, err := http.NewRequest(method, target, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(audit.APIKeyHeader, "secret")
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve("POST", "/set", `{"name":"imane", "age":"20"}`)
+	serve("GET", "/get?key=name", "")
+	serve("DELETE", "/del?key=name", "")
+	serve("DELETE", "/del?key=missing", "") // Fails, so not recorded
+
+	// The handler still received the whole body
+	if val, err := db.Get("age"); err != nil || string(val) != "20" {
+		t.Errorf("Expected value 20, got: %s (%v)", val, err)
+	}
+
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []audit.Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event audit.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	expected := []struct{ operation, key string }{{"set", "age"}, {"set", "name"}, {"del", "name"}}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d audit events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, event := range events {
+		if event.Operation != expected[i].operation || event.Key != expected[i].key {
+			t.Errorf("Expected event %v, got %+v", expected[i], event)
+		}
+		if event.RemoteAddr != "10.0.0.1" || event.Actor == "secret" || event.Actor == "anonymous" {
+			t.Errorf("Unexpected actor in event %+v", event)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package tests
+
+import (
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"StorageEngine/objstore"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackup(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// One flushed SSTable, and two keys in the memtable
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if err := db.Set(key, []byte(key+"1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// SSTable filenames have a one-second resolution
+	time.Sleep(1100 * time.Millisecond)
+	backupDir := tempDir + "/backup"
+	manifest, err := db.Backup(backupDir)
+	if err != nil {
+		t.Fatalf("Error backing up: %s", err)
+	}
+	if len(manifest.SSTables) != 2 || manifest.WAL.Name != memdb.BackupWALName {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+	if _, err := os.Stat(filepath.Join(backupDir, memdb.BackupManifestName)); err != nil {
+		t.Errorf("Expected a manifest file: %s", err)
+	}
+	if _, err := db.Backup(backupDir); err != memdb.ErrBackupDirNotEmpty {
+		t.Errorf("Expected ErrBackupDirNotEmpty, got %v", err)
+	}
+
+	// Writes after the backup don't show up in it
+	if err := db.Set("a", []byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("f", []byte("f1")); err != nil {
+		t.Fatal(err)
+	}
+
+	backupWAL, err := memdb.OpenWAL(filepath.Join(backupDir, memdb.BackupWALName))
+	if err != nil {
+		t.Fatalf("Error opening backup WAL: %s", err)
+	}
+	defer backupWAL.Close()
+	backup, err := memdb.NewDB(backupWAL, filepath.Join(backupDir, memdb.BackupSSTableDirName))
+	if err != nil {
+		t.Fatalf("Error opening backup: %s", err)
+	}
+	defer backup.Close()
+	keys, err := backup.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 || keys[0] != "a" || keys[1] != "c" || keys[3] != "e" {
+		t.Errorf("Unexpected keys in backup: %v", keys)
+	}
+	if value, err := backup.Get("a"); err != nil || string(value) != "a1" {
+		t.Errorf("Expected a=a1 in backup, got %s (%v)", value, err)
+	}
+
+	// Through the HTTP API
+	time.Sleep(1100 * time.Millisecond)
+	req, err := http.NewRequest("POST", "/admin/backup?dir="+url.QueryEscape(tempDir+"/backup2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handlers.BackupHandler(db).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &manifest); err != nil || len(manifest.SSTables) != 3 {
+		t.Errorf("Unexpected manifest: %+v (%v)", manifest, err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// One flushed SSTable, and a key and a deletion in the memtable
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := db.Set(key, []byte(key+"1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	cloneDir := tempDir + "/clone"
+	if err := db.Clone(cloneDir); err != nil {
+		t.Fatalf("Error cloning: %s", err)
+	}
+	if err := db.Clone(cloneDir); err != memdb.ErrCloneDirNotEmpty {
+		t.Errorf("Expected ErrCloneDirNotEmpty, got %v", err)
+	}
+	if len(db.SSTableIDs) != 1 {
+		t.Errorf("Expected the memtable not to be flushed, got %v", db.SSTableIDs)
+	}
+	// The SSTables are shared
+	original, err := os.Stat(db.SSTableIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	linked, err := os.Stat(filepath.Join(cloneDir, memdb.BackupSSTableDirName, filepath.Base(db.SSTableIDs[0])))
+	if err != nil || !os.SameFile(original, linked) {
+		t.Errorf("Expected the SSTable to be hard-linked: %v", err)
+	}
+
+	cloneWAL, err := memdb.OpenWAL(filepath.Join(cloneDir, memdb.BackupWALName))
+	if err != nil {
+		t.Fatalf("Error opening the clone WAL: %s", err)
+	}
+	defer cloneWAL.Close()
+	clone, err := memdb.NewDB(cloneWAL, filepath.Join(cloneDir, memdb.BackupSSTableDirName), memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error opening the clone: %s", err)
+	}
+	defer clone.Close()
+	if value, err := clone.Get("d"); err != nil || string(value) != "d1" {
+		t.Errorf("Expected d=d1 in the clone, got %q, %v", value, err)
+	}
+	if _, err := clone.Get("b"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected b to be deleted in the clone, got %v", err)
+	}
+
+	// The two databases diverge
+	for _, key := range []string{"a", "e", "f"} {
+		if err := clone.Set(key, []byte(key+"2")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := clone.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("g", []byte("g1")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("a"); err != nil || string(value) != "a1" {
+		t.Errorf("Expected a=a1 in the database, got %q, %v", value, err)
+	}
+	if _, err := db.Get("e"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected no e in the database, got %v", err)
+	}
+	if _, err := clone.Get("g"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected no g in the clone, got %v", err)
+	}
+	if value, err := clone.Get("a"); err != nil || string(value) != "a2" {
+		t.Errorf("Expected a=a2 in the clone, got %q, %v", value, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := db.Set(key, []byte(key+"1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(1100 * time.Millisecond)
+	backupDir := tempDir + "/backup"
+	if _, err := db.Backup(backupDir); err != nil {
+		t.Fatalf("Error backing up: %s", err)
+	}
+
+	restoredWAL, restoredDir := tempDir+"/restored_wal.log", tempDir+"/restoredSSTableFiles"
+	manifest, err := memdb.Restore(backupDir, restoredWAL, restoredDir)
+	if err != nil {
+		t.Fatalf("Error restoring: %s", err)
+	}
+	if len(manifest.SSTables) != 2 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+	if _, err := memdb.Restore(backupDir, restoredWAL, restoredDir); err != memdb.ErrRestoreTargetExists {
+		t.Errorf("Expected ErrRestoreTargetExists, got %v", err)
+	}
+
+	wal2, err := memdb.OpenWAL(restoredWAL)
+	if err != nil {
+		t.Fatalf("Error opening restored WAL: %s", err)
+	}
+	defer wal2.Close()
+	restored, err := memdb.NewDB(wal2, restoredDir)
+	if err != nil {
+		t.Fatalf("Error opening restored DB: %s", err)
+	}
+	defer restored.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if value, err := restored.Get(key); err != nil || string(value) != key+"1" {
+			t.Errorf("Expected %s=%s1, got %s (%v)", key, key, value, err)
+		}
+	}
+
+	// A damaged backup is refused
+	sstable := filepath.Join(backupDir, filepath.FromSlash(manifest.SSTables[0].Name))
+	data, err := os.ReadFile(sstable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	os.Remove(sstable) // The file may be a hard link to the live SSTable
+	if err := os.WriteFile(sstable, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memdb.VerifyBackup(backupDir); !errors.Is(err, memdb.ErrBackupCorrupted) {
+		t.Errorf("Expected ErrBackupCorrupted, got %v", err)
+	}
+	os.Remove(filepath.Join(backupDir, memdb.BackupManifestName))
+	if _, err := memdb.Restore(backupDir, tempDir+"/other_wal.log", tempDir+"/otherSSTableFiles"); err != memdb.ErrBackupIncomplete {
+		t.Errorf("Expected ErrBackupIncomplete, got %v", err)
+	}
+}
+
+func TestRemoteBackup(t *testing.T) {
+	tempDir := t.TempDir()
+	store, err := objstore.NewDir(tempDir + "/bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		db.Set(key, []byte(key+"1"))
+	}
+	time.Sleep(1100 * time.Millisecond) // SSTables are named after the second they are flushed in
+
+	first, err := db.BackupRemote(store)
+	if err != nil {
+		t.Fatalf("Error backing up: %s", err)
+	}
+	if len(first.Manifest.SSTables) != 2 || first.Uploaded != 3 {
+		t.Errorf("Expected the 2 SSTables and the WAL to be uploaded, got %+v", first)
+	}
+
+	// The next backup only uploads the new table
+	db.Set("e", []byte("e1"))
+	time.Sleep(1100 * time.Millisecond)
+	second, err := db.BackupRemote(store)
+	if err != nil {
+		t.Fatalf("Error backing up: %s", err)
+	}
+	if len(second.Manifest.SSTables) != 3 || second.Uploaded != 2 || second.ID == first.ID {
+		t.Errorf("Expected only the new SSTable and the WAL to be uploaded, got %+v", second)
+	}
+	if backups, err := memdb.ListRemoteBackups(store); err != nil || len(backups) != 2 || backups[1].ID != second.ID {
+		t.Errorf("Expected the 2 backups, got %+v, %v", backups, err)
+	}
+
+	// The most recent backup is restored by default
+	restoredWAL, restoredDir := tempDir+"/restored_wal.log", tempDir+"/restoredSSTableFiles"
+	if _, err := memdb.RestoreRemote(store, "", restoredWAL, restoredDir); err != nil {
+		t.Fatalf("Error restoring: %s", err)
+	}
+	wal2, err := memdb.OpenWAL(restoredWAL)
+	if err != nil {
+		t.Fatalf("Error opening restored WAL: %s", err)
+	}
+	defer wal2.Close()
+	restored, err := memdb.NewDB(wal2, restoredDir)
+	if err != nil {
+		t.Fatalf("Error opening restored DB: %s", err)
+	}
+	defer restored.Close()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if value, err := restored.Get(key); err != nil || string(value) != key+"1" {
+			t.Errorf("Expected %s=%s1 in the restored DB, got %q, %v", key, key, value, err)
+		}
+	}
+
+	// Retention deletes the old backup but not the tables the remaining one still uses
+	deleted, err := memdb.PruneRemoteBackups(store, memdb.RetentionPolicy{Keep: 1})
+	if err != nil || len(deleted) != 1 || deleted[0] != first.ID {
+		t.Errorf("Expected the first backup to be deleted, got %v, %v", deleted, err)
+	}
+	if tables, err := store.List(memdb.RemoteSSTablePrefix); err != nil || len(tables) != 3 {
+		t.Errorf("Expected the 3 SSTables to be kept, got %v, %v", tables, err)
+	}
+	if _, err := memdb.RestoreRemote(store, first.ID, tempDir+"/other_wal.log", tempDir+"/otherSSTableFiles"); err != memdb.ErrRemoteBackupNotFound {
+		t.Errorf("Expected ErrRemoteBackupNotFound restoring a deleted backup, got %v", err)
+	}
+}
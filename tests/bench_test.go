@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"StorageEngine/memdb"
+	"StorageEngine/sstable"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func benchmarkKeyValues(n int, valueSize int) []sstable.KeyValuePair {
+	kvs := make([]sstable.KeyValuePair, n)
+	for i := range kvs {
+		kvs[i] = sstable.KeyValuePair{Key: []byte(fmt.Sprintf("key%08d", i)), Value: make([]byte, valueSize), Operation: sstable.OpSet}
+	}
+	return kvs
+}
+
+func BenchmarkWALWriteEntry(b *testing.B) {
+	wal, err := memdb.OpenWAL(filepath.Join(b.TempDir(), "wal.log"))
+	if err != nil {
+		b.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	record := memdb.WALRecord{Operation: memdb.OpSet, Key: []byte("key00000001"), Value: make([]byte, 100)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := wal.WriteEntry(record); err != nil {
+			b.Fatalf("Error writing WAL entry: %s", err)
+		}
+	}
+}
+
+func BenchmarkWALWriteEntries(b *testing.B) {
+	wal, err := memdb.OpenWAL(filepath.Join(b.TempDir(), "wal.log"))
+	if err != nil {
+		b.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	records := make([]memdb.WALRecord, 100)
+	for i := range records {
+		records[i] = memdb.WALRecord{Operation: memdb.OpSet, Key: []byte(fmt.Sprintf("key%08d", i)), Value: make([]byte, 100)}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += len(records) {
+		if err := wal.WriteEntries(records); err != nil {
+			b.Fatalf("Error writing WAL entries: %s", err)
+		}
+	}
+}
+
+func BenchmarkWriteSSTable(b *testing.B) {
+	dir := b.TempDir()
+	table := sstable.NewSSTable(benchmarkKeyValues(1000, 100))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sstable.WriteSSTable(filepath.Join(dir, fmt.Sprintf("%d.sst", i)), table); err != nil {
+			b.Fatalf("Error writing SSTable: %s", err)
+		}
+	}
+}
+
+func BenchmarkReadSSTable(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "table.sst")
+	if err := sstable.WriteSSTable(path, sstable.NewSSTable(benchmarkKeyValues(1000, 100))); err != nil {
+		b.Fatalf("Error writing SSTable: %s", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sstable.ReadSSTable(path); err != nil {
+			b.Fatalf("Error reading SSTable: %s", err)
+		}
+	}
+}
+
+func BenchmarkReadSSTableChecksum(b *testing.B) {
+	for _, checksum := range []sstable.ChecksumType{sstable.ChecksumCRC32, sstable.ChecksumCRC32C, sstable.ChecksumXXHash64} {
+		b.Run(checksum.String(), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "table.sst")
+			table := sstable.NewSSTable(benchmarkKeyValues(100, 64<<10))
+			table.SetChecksum(checksum)
+			if err := sstable.WriteSSTable(path, table); err != nil {
+				b.Fatalf("Error writing SSTable: %s", err)
+			}
+
+			b.SetBytes(100 * 64 << 10)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := sstable.ReadSSTable(path); err != nil {
+					b.Fatalf("Error reading SSTable: %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"StorageEngine/cdc"
+	"StorageEngine/memdb"
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCDCWebhook(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath := tempDir + "/test_wal.log"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mu sync.Mutex
+	var received []cdc.Change
+	failing := false
+	setFailing := func(f bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		failing = f
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var changes []cdc.Change
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			t.Errorf("Error decoding changes: %s", err)
+		}
+		received = append(received, changes...)
+	}))
+	defer server.Close()
+
+	cfg := cdc.Config{WALPath: walPath, CursorPath: tempDir + "/cdc.cursor", Sink: &cdc.WebhookSink{URL: server.URL}, BatchSize: 2}
+	publisher, err := cdc.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set("a", []byte("1"))
+	db.Set("b", []byte("2"))
+	db.Delete("a")
+	if n, err := publisher.Poll(); n != 3 || err != nil {
+		t.Fatalf("Expected 3 changes published, got %d, %v", n, err)
+	}
+	if len(received) != 3 || received[0].Key != "a" || string(received[0].Value) != "1" || received[2].Op != cdc.OpDel {
+		t.Errorf("Unexpected changes: %+v", received)
+	}
+
+	// A failed delivery is retried by the next poll, and a new publisher resumes from the cursor
+	db.Set("c", []byte("3"))
+	setFailing(true)
+	if _, err := publisher.Poll(); err == nil {
+		t.Errorf("Expected an error from a failing webhook")
+	}
+	setFailing(false)
+	publisher, err = cdc.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := publisher.Poll(); n != 1 || err != nil {
+		t.Fatalf("Expected 1 change published, got %d, %v", n, err)
+	}
+	if len(received) != 4 || received[3].Key != "c" {
+		t.Errorf("Unexpected changes: %+v", received)
+	}
+
+	// After the WAL is reset, publishing starts over from its first record
+	if err := db.DropAll(); err != nil {
+		t.Fatal(err)
+	}
+	db.Set("d", []byte("4"))
+	if n, err := publisher.Poll(); n != 1 || err != nil || received[4].Key != "d" {
+		t.Fatalf("Expected d published after the reset, got %d, %v", n, err)
+	}
+
+	// The changes of a transaction share the position of its record, and are published together
+	tx := db.BeginTx()
+	tx.Set("e", []byte("5"))
+	tx.Set("f", []byte("6"))
+	tx.Delete("d")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := publisher.Poll(); n != 3 || err != nil {
+		t.Fatalf("Expected 3 changes published, got %d, %v", n, err)
+	}
+	if len(received) != 8 || received[5].Op != cdc.OpDel || received[7].Key != "f" || received[5].Position != received[7].Position {
+		t.Errorf("Unexpected changes: %+v", received[5:])
+	}
+}
+
+func TestCDCNATS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// A minimal NATS server collecting the published payloads
+	messages := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {}\r\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PUB"):
+				payload, _ := reader.ReadString('\n')
+				messages <- strings.Fields(line)[1] + " " + strings.TrimSpace(payload)
+			case strings.HasPrefix(line, "PING"):
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	}()
+
+	sink := &cdc.NATSSink{Addr: listener.Addr().String(), Subject: "changes"}
+	defer sink.Close()
+	if err := sink.Publish([]cdc.Change{{Op: cdc.OpSet, Key: "a", Value: []byte("1"), Position: 16}}); err != nil {
+		t.Fatalf("Error publishing: %s", err)
+	}
+	expected := `changes {"op":"set","key":"a","value":"MQ==","position":16}`
+	if message := <-messages; message != expected {
+		t.Errorf("Expected %s, got %s", expected, message)
+	}
+}
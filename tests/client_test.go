@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"StorageEngine/client"
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// The first two requests are refused as if the server were overloaded
+	var requests int32
+	mux := handlers.NewMux(db, wal)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			http.Error(w, "Busy", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := client.New(server.URL, client.Retries(2, time.Millisecond))
+	if err := c.Set(ctx, "name", []byte("imane")); err != nil {
+		t.Fatalf("Error setting through the client: %s", err)
+	}
+	if atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("Expected the request to be retried twice, got %d requests", requests)
+	}
+	if err := c.Batch(ctx, map[string][]byte{"user/1": []byte("a"), "user/2": []byte("b")}); err != nil {
+		t.Fatalf("Error setting a batch: %s", err)
+	}
+	if value, err := c.Get(ctx, "name"); err != nil || string(value) != "imane" {
+		t.Errorf("Expected name=imane, got %s (%v)", value, err)
+	}
+	kvs, err := c.Scan(ctx, client.ScanOptions{Prefix: "user/"})
+	if err != nil || len(kvs) != 2 || kvs[1].Key != "user/2" || string(kvs[1].Value) != "b" {
+		t.Errorf("Unexpected scan: %v (%v)", kvs, err)
+	}
+	values, err := c.GetMulti(ctx, []string{"user/2", "missing", "name"})
+	if err != nil || len(values) != 2 || string(values["user/2"]) != "b" || string(values["name"]) != "imane" {
+		t.Errorf("Unexpected values: %q (%v)", values, err)
+	}
+	if value, err := c.Delete(ctx, "name"); err != nil || string(value) != "imane" {
+		t.Errorf("Expected the deleted value imane, got %s (%v)", value, err)
+	}
+	if _, err := c.Get(ctx, "name"); err != client.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if stats, err := c.Stats(ctx); err != nil || stats.MemtableEntries != 3 {
+		t.Errorf("Unexpected stats: %+v (%v)", stats, err)
+	}
+
+	// Errors that aren't temporary are returned at once
+	var statusErr *client.StatusError
+	if _, err := c.Get(ctx, ""); !errors.As(err, &statusErr) || statusErr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 status error, got %v", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Get(canceled, "user/1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"StorageEngine/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "storage.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Error writing configuration: %s", err)
+	}
+	return path
+}
+
+func TestConfig(t *testing.T) {
+	path := writeConfig(t, `
+# Production settings
+[server]
+addr = "127.0.0.1:9090"
+
+[storage]
+wal = "/var/lib/storage/wal.log" # Kept on the fast disk
+threshold = 100
+scrub = "1h"
+
+[tenants]
+list = ["alice:secret", "bob"]
+quota_keys = 1000
+`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Error loading configuration: %s", err)
+	}
+	if cfg.Server.Addr != "127.0.0.1:9090" || cfg.Storage.WAL != "/var/lib/storage/wal.log" {
+		t.Errorf("Unexpected paths: %+v", cfg)
+	}
+	if cfg.Storage.Threshold != 100 || cfg.Storage.Scrub != time.Hour || cfg.Tenants.QuotaKeys != 1000 {
+		t.Errorf("Unexpected values: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Tenants.List, []string{"alice:secret", "bob"}) {
+		t.Errorf("Unexpected tenants: %v", cfg.Tenants.List)
+	}
+	// Settings missing from the file keep their defaults
+	defaults := config.Default()
+	if cfg.Storage.SSTables != defaults.Storage.SSTables || cfg.Stats != defaults.Stats {
+		t.Errorf("Expected defaults for the missing settings, got %+v", cfg)
+	}
+
+	for name, content := range map[string]string{
+		"unknown key":    "[storage]\nthreshhold = 10\n",
+		"wrong type":     "[storage]\nthreshold = \"10\"\n",
+		"lone tls_cert":  "[server]\ntls_cert = \"cert.pem\"\n",
+		"zero threshold": "[storage]\nthreshold = 0\n",
+		"bad duration":   "[storage]\nscrub = \"often\"\n",
+	} {
+		if _, err := config.Load(writeConfig(t, content)); err == nil {
+			t.Errorf("Expected an error for a configuration with a %s", name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"StorageEngine/exporter"
+	"StorageEngine/handlers"
+	"StorageEngine/importer"
+	"StorageEngine/memdb"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	kvs := []memdb.KeyValue{
+		{Key: "a", Value: []byte("plain")},
+		{Key: "b,c", Value: []byte("with \"quotes\"\nand a newline")},
+	}
+
+	var buf bytes.Buffer
+	n, err := exporter.Write(&buf, kvs, exporter.Options{Format: exporter.FormatCSV})
+	if err != nil || n != 2 {
+		t.Fatalf("Error exporting CSV: %d keys, %v", n, err)
+	}
+	expected := "key,value\na,plain\n\"b,c\",\"with \"\"quotes\"\"\nand a newline\"\n"
+	if buf.String() != expected {
+		t.Errorf("Expected CSV %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if _, err := exporter.Write(&buf, kvs, exporter.Options{Format: exporter.FormatNDJSON}); err != nil {
+		t.Fatalf("Error exporting NDJSON: %s", err)
+	}
+	expected = "{\"key\":\"a\",\"value\":\"plain\"}\n{\"key\":\"b,c\",\"value\":\"with \\\"quotes\\\"\\nand a newline\"}\n"
+	if buf.String() != expected {
+		t.Errorf("Expected NDJSON %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if _, err := exporter.Write(&buf, kvs[:1], exporter.Options{Format: exporter.FormatNDJSON, Base64: true}); err != nil {
+		t.Fatalf("Error exporting NDJSON: %s", err)
+	}
+	if expected = "{\"key\":\"a\",\"value\":\"cGxhaW4=\"}\n"; buf.String() != expected {
+		t.Errorf("Expected NDJSON %q, got %q", expected, buf.String())
+	}
+
+	if _, err := exporter.Write(&buf, kvs, exporter.Options{Format: "xml"}); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestExportBinaryRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %v", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	expected := []memdb.KeyValue{
+		{Key: "a\x00b", Value: []byte{0, 1, 2}},
+		{Key: "text", Value: []byte("plain")},
+		{Key: "\xff\xfe\x80", Value: []byte("\xc3\x28 invalid UTF-8")},
+	}
+	for _, kv := range expected {
+		if err := db.Set(kv.Key, kv.Value); err != nil {
+			t.Fatalf("Error setting %q: %s", kv.Key, err)
+		}
+	}
+	server := httptest.NewServer(handlers.NewMux(db, wal))
+	defer server.Close()
+
+	opts := exporter.Options{Base64: true, Base64Keys: true}
+	for _, format := range []string{exporter.FormatCSV, exporter.FormatNDJSON} {
+		// From the server, keys and values are asked in base64 and decoded as they arrive
+		opts.Format = format
+		var buf bytes.Buffer
+		writer, err := exporter.NewWriter(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get(server.URL + "/scan?encoding=base64&value_encoding=base64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = exporter.WriteScan(writer, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Error exporting %s: %s", format, err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if writer.Written() != len(expected) {
+			t.Errorf("Expected %d keys exported as %s, got %d", len(expected), format, writer.Written())
+		}
+
+		// The export reads back as the keys and values written
+		path := tempDir + "/export." + format
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		kvs, _, err := importer.Read(path, importer.Options{Format: format, Base64: true, Base64Keys: true})
+		if err != nil {
+			t.Fatalf("Error reading the %s export: %s", format, err)
+		}
+		if len(kvs) != len(expected) {
+			t.Fatalf("Expected %d keys read back from %s, got %v", len(expected), format, kvs)
+		}
+		for i, kv := range kvs {
+			if kv.Key != expected[i].Key || !bytes.Equal(kv.Value, expected[i].Value) {
+				t.Errorf("Expected %q=%q read back from %s, got %q=%q", expected[i].Key, expected[i].Value, format, kv.Key, kv.Value)
+			}
+		}
+	}
+}
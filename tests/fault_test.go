@@ -0,0 +1,350 @@
+package tests
+
+import (
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"StorageEngine/vfs"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// openFaultDB opens the database of fsys with a small memtable and automatic compactions, so that a short workload
+// goes through flushes and compactions
+func openFaultDB(fsys vfs.FS) (*memdb.WAL, *memdb.DB, error) {
+	wal, err := memdb.OpenWALFS(fsys, "wal.log")
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := memdb.NewDB(wal, "SSTableFiles", memdb.Threshold(4),
+		memdb.Compaction(memdb.CompactionOptions{MinFiles: 2, MaxFiles: 3, Auto: true}))
+	if err != nil {
+		wal.Close()
+		return nil, nil, err
+	}
+	return wal, db, nil
+}
+
+// faultOp is a write of the crash workload, a deletion if value is empty
+type faultOp struct {
+	key   string
+	value string
+}
+
+// crashWorkload returns writes that overwrite and delete a few keys over several flushes and compactions
+func crashWorkload() []faultOp {
+	var ops []faultOp
+	for i := 0; i < 40; i++ {
+		ops = append(ops, faultOp{key: fmt.Sprintf("key%02d", i%11), value: fmt.Sprintf("value%d", i)})
+		if i%5 == 4 {
+			ops = append(ops, faultOp{key: fmt.Sprintf("key%02d", i%7)})
+		}
+	}
+	return ops
+}
+
+// TestFaultInjection checks that failed writes and short writes leave the database usable and consistent
+func TestFaultInjection(t *testing.T) {
+	mem := vfs.NewMem()
+	fsys := vfs.NewFault(mem)
+	wal, db, err := openFaultDB(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	defer db.Close()
+
+	// A torn WAL write fails the write, which isn't applied, and the next one succeeds
+	fsys.Inject(vfs.Fault{Op: vfs.OpWrite, Path: "wal.log", Short: true})
+	if err := db.Set("a", []byte("1")); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("Expected an injected error, got %v", err)
+	}
+	if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected the failed write to be invisible, got %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed SSTable write fails the flush, which keeps the memtable and can be retried
+	fsys.Inject(vfs.Fault{Op: vfs.OpWrite, Path: ".sst"})
+	if err := db.Set("d", []byte("d")); !errors.Is(err, vfs.ErrInjected) {
+		t.Fatalf("Expected the flush to fail, got %v", err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatalf("Expected the flush to succeed once the fault is gone, got %v", err)
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if value, err := db.Get(key); err != nil || string(value) != key {
+			t.Errorf("Expected %s, got %q, %v", key, value, err)
+		}
+	}
+	report, err := db.VerifyIntegrity()
+	if err != nil || report.Worst() == memdb.SeverityError {
+		t.Errorf("Expected no integrity error, got %+v, %v", report.Findings, err)
+	}
+
+	// The records survive a reopen
+	db.Close()
+	wal.Close()
+	wal, db, err = openFaultDB(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if value, err := db.Get(key); err != nil || string(value) != key {
+			t.Errorf("Expected %s after reopening, got %q, %v", key, value, err)
+		}
+	}
+}
+
+// TestCrashRecovery crashes the database at every file system operation of a workload in turn, in the middle of the
+// writes to the WAL, flushes and compactions, then reopens it: every acknowledged write must be there, the write in
+// progress may or may not be, and the files must pass the integrity checks
+func TestCrashRecovery(t *testing.T) {
+	workload := crashWorkload()
+
+	// A first run counts the operations
+	fsys := vfs.NewFault(vfs.NewMem())
+	wal, db, err := openFaultDB(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := fsys.Ops()
+	for _, op := range workload {
+		if err := applyFaultOp(db, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total := fsys.Ops() - start
+	db.Close()
+	wal.Close()
+
+	step := 1
+	if testing.Short() {
+		step = 7
+	}
+	for crashAt := 0; crashAt < total; crashAt += step {
+		mem := vfs.NewMem()
+		fsys := vfs.NewFault(mem)
+		wal, db, err := openFaultDB(fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys.CrashAfter(crashAt)
+
+		acked := make(map[string]string) // Value of the keys written, "" for the deleted ones
+		var inFlight *faultOp
+		for i, op := range workload {
+			if err := applyFaultOp(db, op); err != nil {
+				if !errors.Is(err, vfs.ErrCrashed) {
+					t.Fatalf("Crash at %d: expected the crash to fail op %d, got %v", crashAt, i, err)
+				}
+				inFlight = &workload[i]
+				break
+			}
+			acked[op.key] = op.value
+		}
+		db.Close()
+		wal.Close()
+
+		// Reopen after the crash, the files as they were left
+		wal, db, err = openFaultDB(mem)
+		if err != nil {
+			t.Fatalf("Crash at %d: reopening failed: %v", crashAt, err)
+		}
+		for key, expected := range acked {
+			value, err := db.Get(key)
+			got := string(value)
+			if err == memdb.ErrKeyNotFound {
+				got, err = "", nil
+			}
+			if err != nil {
+				t.Errorf("Crash at %d: reading %s failed: %v", crashAt, key, err)
+				continue
+			}
+			if got != expected && (inFlight == nil || inFlight.key != key || got != inFlight.value) {
+				t.Errorf("Crash at %d: expected %s = %q, got %q", crashAt, key, expected, got)
+			}
+		}
+		report, err := db.VerifyIntegrity()
+		if err != nil || report.Worst() == memdb.SeverityError {
+			t.Errorf("Crash at %d: expected no integrity error, got %+v, %v", crashAt, report.Findings, err)
+		}
+		// The database takes writes again
+		if err := db.Set("after", []byte("crash")); err != nil {
+			t.Errorf("Crash at %d: writing after the crash failed: %v", crashAt, err)
+		}
+		db.Close()
+		wal.Close()
+	}
+}
+
+// TestDropAllCrash crashes, then fails, DropAll at each of its file system operations in turn: the data must be
+// dropped all at once or not at all, in memory as after reopening, and the files must pass the integrity checks
+func TestDropAllCrash(t *testing.T) {
+	workload := crashWorkload()[:20] // Flushed and compacted tables, and a memtable
+	expected := make(map[string]string)
+	for _, op := range workload {
+		expected[op.key] = op.value
+	}
+	setup := func() (*vfs.MemFS, *vfs.FaultFS, *memdb.WAL, *memdb.DB) {
+		mem := vfs.NewMem()
+		fsys := vfs.NewFault(mem)
+		wal, db, err := openFaultDB(fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, op := range workload {
+			if err := applyFaultOp(db, op); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return mem, fsys, wal, db
+	}
+	// dropped returns whether the keys of the workload are all gone, failing if only some of them are
+	dropped := func(db *memdb.DB, label string) bool {
+		live, kept := 0, 0
+		for key, value := range expected {
+			got, err := db.Get(key)
+			switch {
+			case value == "" && err == memdb.ErrKeyNotFound:
+			case value == "" || err != nil && err != memdb.ErrKeyNotFound:
+				t.Errorf("%s: reading %s failed: %q, %v", label, key, got, err)
+			case err == nil && string(got) == value:
+				live, kept = live+1, kept+1
+			case err == memdb.ErrKeyNotFound:
+				live++
+			default:
+				t.Errorf("%s: expected %s = %q, got %q", label, key, value, got)
+			}
+		}
+		if kept != 0 && kept != live {
+			t.Errorf("%s: expected the keys to be all dropped or all kept, %d of %d kept", label, kept, live)
+		}
+		return kept == 0
+	}
+
+	// A first run counts the operations
+	_, fsys, wal, db := setup()
+	start := fsys.Ops()
+	if err := db.DropAll(); err != nil {
+		t.Fatal(err)
+	}
+	total := fsys.Ops() - start
+	db.Close()
+	wal.Close()
+
+	for at := 0; at <= total; at++ {
+		for _, crash := range []bool{true, false} {
+			label := fmt.Sprintf("Crash at %d", at)
+			mem, fsys, wal, db := setup()
+			if crash {
+				fsys.CrashAfter(at)
+			} else {
+				label = fmt.Sprintf("Error at %d", at)
+				fsys.Inject(vfs.Fault{After: at})
+			}
+			// Without a crash, the database goes on with what it has in memory
+			err := db.DropAll()
+			var droppedBefore bool
+			if !crash || err == nil {
+				droppedBefore = dropped(db, label)
+				if err == nil && !droppedBefore {
+					t.Errorf("%s: expected the keys to be dropped", label)
+				}
+			}
+			db.Close()
+			wal.Close()
+
+			wal, db, err = openFaultDB(mem)
+			if err != nil {
+				t.Fatalf("%s: reopening failed: %v", label, err)
+			}
+			if droppedAfter := dropped(db, label+", reopened"); !crash && droppedAfter != droppedBefore {
+				t.Errorf("%s: expected the keys to be dropped %v after reopening as before, got %v", label, droppedBefore, droppedAfter)
+			}
+			report, err := db.VerifyIntegrity()
+			if err != nil || report.Worst() == memdb.SeverityError {
+				t.Errorf("%s: expected no integrity error, got %+v, %v", label, report.Findings, err)
+			}
+			if err := db.Set("after", []byte("drop")); err != nil {
+				t.Errorf("%s: writing after the drop failed: %v", label, err)
+			}
+			db.Close()
+			wal.Close()
+		}
+	}
+}
+
+// applyFaultOp applies a write of the crash workload
+func applyFaultOp(db *memdb.DB, op faultOp) error {
+	if op.value == "" {
+		return db.Delete(op.key)
+	}
+	return db.Set(op.key, []byte(op.value))
+}
+
+// TestSyncWrites checks that writes sync the WAL when their options or the database ask for it, through the API too
+func TestSyncWrites(t *testing.T) {
+	fsys := vfs.NewFault(vfs.NewMem())
+	wal, db, err := openFaultDB(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		wal.Close()
+	}()
+
+	// Every sync of the WAL fails, so the writes that sync report it
+	fsys.Inject(vfs.Fault{Op: vfs.OpSync, Path: "wal.log", Count: -1})
+	if err := db.Set("a", []byte("1")); err != nil {
+		t.Errorf("Expected a write without sync to succeed, got %v", err)
+	}
+	if err := db.SetWith("b", []byte("2"), memdb.WriteOptions{Sync: memdb.SyncOn}); !errors.Is(err, vfs.ErrInjected) {
+		t.Errorf("Expected the sync to fail, got %v", err)
+	}
+	if err := db.DeleteWith("a", memdb.WriteOptions{Sync: memdb.SyncOn}); !errors.Is(err, vfs.ErrInjected) {
+		t.Errorf("Expected the sync to fail, got %v", err)
+	}
+
+	server := httptest.NewServer(handlers.NewMux(db, wal))
+	defer server.Close()
+	for query, expected := range map[string]int{"": http.StatusOK, "sync=false": http.StatusOK, "sync=true": http.StatusInternalServerError, "sync=maybe": http.StatusBadRequest} {
+		resp, err := http.Post(server.URL+"/set?"+query, "application/json", strings.NewReader(`{"c": "3"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("/set?%s: expected %d, got %d", query, expected, resp.StatusCode)
+		}
+	}
+
+	// With SyncWrites, writes sync unless told not to
+	db.Close()
+	wal.Close()
+	fsys.Reset()
+	if wal, err = memdb.OpenWALFS(fsys, "wal.log"); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = memdb.NewDB(wal, "SSTableFiles", memdb.Threshold(4), memdb.SyncWrites(true)); err != nil {
+		t.Fatal(err)
+	}
+	fsys.Inject(vfs.Fault{Op: vfs.OpSync, Path: "wal.log", Count: -1})
+	if err := db.Set("d", []byte("4")); !errors.Is(err, vfs.ErrInjected) {
+		t.Errorf("Expected the sync to fail, got %v", err)
+	}
+	if err := db.SetWith("e", []byte("5"), memdb.WriteOptions{Sync: memdb.SyncOff}); err != nil {
+		t.Errorf("Expected a write without sync to succeed, got %v", err)
+	}
+	if value, err := db.Get("d"); err != nil || string(value) != "4" {
+		t.Errorf("Expected a write whose sync failed to be visible, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,313 @@
+package tests
+
+import (
+	"StorageEngine/importer"
+	"StorageEngine/memdb"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"testing"
+	"time"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// maskedCRC computes a crc32c checksum masked the way LevelDB stores them
+func maskedCRC(parts ...[]byte) uint32 {
+	crc := uint32(0)
+	for _, part := range parts {
+		crc = crc32.Update(crc, castagnoli, part)
+	}
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
+
+// levelDBBlock encodes entries, without prefix compression, as a block with a single restart point
+func levelDBBlock(entries [][2][]byte) []byte {
+	var block []byte
+	for _, entry := range entries {
+		block = binary.AppendUvarint(block, 0)
+		block = binary.AppendUvarint(block, uint64(len(entry[0])))
+		block = binary.AppendUvarint(block, uint64(len(entry[1])))
+		block = append(block, entry[0]...)
+		block = append(block, entry[1]...)
+	}
+	block = binary.LittleEndian.AppendUint32(block, 0)
+	return binary.LittleEndian.AppendUint32(block, 1)
+}
+
+// snappyLiteral encodes data as a snappy block made of a single literal
+func snappyLiteral(data []byte) []byte {
+	block := binary.AppendUvarint(nil, uint64(len(data)))
+	block = append(block, 61<<2) // Literal whose length minus one is stored on the next 2 bytes
+	block = binary.LittleEndian.AppendUint16(block, uint16(len(data)-1))
+	return append(block, data...)
+}
+
+// internalKey appends the sequence number and the type of an entry to a user key
+func internalKey(key string, seq uint64, entryType uint64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte(key), seq<<8|entryType)
+}
+
+// writeLevelDBTable writes a table holding a single data block, compressed if compress is set
+func writeLevelDBTable(t *testing.T, path string, entries [][2][]byte, compress bool) {
+	var file []byte
+	appendBlock := func(block []byte, compression byte) []byte {
+		handle := binary.AppendUvarint(nil, uint64(len(file)))
+		handle = binary.AppendUvarint(handle, uint64(len(block)))
+		file = append(file, block...)
+		file = append(file, compression)
+		file = binary.LittleEndian.AppendUint32(file, maskedCRC(block, []byte{compression}))
+		return handle
+	}
+
+	data, compression := levelDBBlock(entries), byte(0)
+	if compress {
+		data, compression = snappyLiteral(data), 1
+	}
+	dataHandle := appendBlock(data, compression)
+	metaindexHandle := appendBlock(levelDBBlock(nil), 0)
+	indexHandle := appendBlock(levelDBBlock([][2][]byte{{entries[len(entries)-1][0], dataHandle}}), 0)
+
+	footer := append(metaindexHandle, indexHandle...)
+	footer = append(footer, make([]byte, 40-len(footer))...)
+	footer = binary.LittleEndian.AppendUint64(footer, 0xdb4775248b80fb57)
+	if err := os.WriteFile(path, append(file, footer...), 0644); err != nil {
+		t.Fatalf("Error writing table: %s", err)
+	}
+}
+
+// rdbString encodes a short RDB string
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func TestImportLevelDB(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := tempDir + "/leveldb"
+	if err := os.Mkdir(sourceDir, 0755); err != nil {
+		t.Fatalf("Error creating source directory: %s", err)
+	}
+
+	// An older table, overridden by a newer one for k2 and by the log for k1 and k3
+	writeLevelDBTable(t, sourceDir+"/000005.ldb", [][2][]byte{
+		{internalKey("k1", 1, 1), []byte("old1")},
+		{internalKey("k2", 2, 1), []byte("old2")},
+		{internalKey("k3", 3, 1), []byte("old3")},
+	}, false)
+	writeLevelDBTable(t, sourceDir+"/000007.ldb", [][2][]byte{
+		{internalKey("k2", 5, 1), []byte("v2")},
+		{internalKey("k4", 6, 1), []byte("v4")},
+		{internalKey("k5", 7, 0), nil},
+	}, true)
+
+	// A log holding a batch setting k1 and deleting k3
+	batch := binary.LittleEndian.AppendUint64(nil, 10)
+	batch = binary.LittleEndian.AppendUint32(batch, 2)
+	batch = append(batch, 1)
+	batch = append(batch, rdbString("k1")...)
+	batch = append(batch, rdbString("v1")...)
+	batch = append(batch, 0)
+	batch = append(batch, rdbString("k3")...)
+	record := binary.LittleEndian.AppendUint32(nil, maskedCRC([]byte{1}, batch))
+	record = binary.LittleEndian.AppendUint16(record, uint16(len(batch)))
+	record = append(record, 1)
+	record = append(record, batch...)
+	if err := os.WriteFile(sourceDir+"/000008.log", record, 0644); err != nil {
+		t.Fatalf("Error writing log: %s", err)
+	}
+
+	kvs, report, err := importer.ReadLevelDB(sourceDir)
+	if err != nil {
+		t.Fatalf("Error reading LevelDB directory: %s", err)
+	}
+	expected := []memdb.KeyValue{{Key: "k1", Value: []byte("v1")}, {Key: "k2", Value: []byte("v2")}, {Key: "k4", Value: []byte("v4")}}
+	if len(kvs) != len(expected) {
+		t.Fatalf("Expected %d keys, got %v", len(expected), kvs)
+	}
+	for i, kv := range kvs {
+		if kv.Key != expected[i].Key || string(kv.Value) != string(expected[i].Value) {
+			t.Errorf("Expected %s=%s, got %s=%s", expected[i].Key, expected[i].Value, kv.Key, kv.Value)
+		}
+	}
+	if report.Keys != 3 || report.Deleted != 2 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	// A corrupted table is rejected
+	table, _ := os.ReadFile(sourceDir + "/000005.ldb")
+	table[0] ^= 0xff
+	os.WriteFile(sourceDir+"/000005.ldb", table, 0644)
+	if _, _, err := importer.ReadLevelDB(sourceDir); err == nil {
+		t.Errorf("Expected an error reading a corrupted table")
+	}
+}
+
+func TestImportRDB(t *testing.T) {
+	tempDir := t.TempDir()
+
+	dump := []byte("REDIS0011")
+	dump = append(dump, 0xFA)
+	dump = append(dump, rdbString("redis-ver")...)
+	dump = append(dump, rdbString("7.2.0")...)
+	dump = append(dump, 0xFE, 0, 0xFB, 5, 1)
+	// Plain string
+	dump = append(dump, 0)
+	dump = append(dump, rdbString("name")...)
+	dump = append(dump, rdbString("imane")...)
+	// Integer encoded string
+	dump = append(dump, 0)
+	dump = append(dump, rdbString("count")...)
+	dump = append(dump, 0xC1, 0x39, 0x30)
+	// LZF compressed string: literal "abc", then a 6 bytes back reference 3 bytes back
+	dump = append(dump, 0)
+	dump = append(dump, rdbString("lzf")...)
+	dump = append(dump, 0xC3, 6, 9, 2, 'a', 'b', 'c', 0x80, 2)
+	// String that already expired
+	dump = append(dump, 0xFC)
+	dump = binary.LittleEndian.AppendUint64(dump, uint64(time.Now().Add(-time.Hour).UnixMilli()))
+	dump = append(dump, 0)
+	dump = append(dump, rdbString("expired")...)
+	dump = append(dump, rdbString("x")...)
+	// String that expires later
+	dump = append(dump, 0xFC)
+	dump = binary.LittleEndian.AppendUint64(dump, uint64(time.Now().Add(time.Hour).UnixMilli()))
+	dump = append(dump, 0)
+	dump = append(dump, rdbString("session")...)
+	dump = append(dump, rdbString("s")...)
+	// List, which can't be imported
+	dump = append(dump, 1)
+	dump = append(dump, rdbString("list")...)
+	dump = append(dump, 2)
+	dump = append(dump, rdbString("a")...)
+	dump = append(dump, rdbString("b")...)
+	// Key of another Redis database
+	dump = append(dump, 0xFE, 1, 0)
+	dump = append(dump, rdbString("other")...)
+	dump = append(dump, rdbString("o")...)
+	dump = append(dump, 0xFF)
+	dump = append(dump, make([]byte, 8)...)
+	if err := os.WriteFile(tempDir+"/dump.rdb", dump, 0644); err != nil {
+		t.Fatalf("Error writing dump: %s", err)
+	}
+
+	// Import into a database holding an older value for name
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	if err := db.Set("name", []byte("old")); err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+	if err := db.Set("kept", []byte("k")); err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+
+	report, err := importer.Import(db, tempDir+"/dump.rdb", importer.Options{Format: importer.FormatRDB}, 2)
+	if err != nil {
+		t.Fatalf("Error importing dump: %s", err)
+	}
+	if report.Keys != 4 || report.Skipped != 2 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	expected := map[string]string{"name": "imane", "count": "12345", "lzf": "abcabcabc", "session": "s", "kept": "k"}
+	for key, value := range expected {
+		got, err := db.Get(key)
+		if err != nil || string(got) != value {
+			t.Errorf("Expected %s=%s, got %s (%v)", key, value, got, err)
+		}
+	}
+	for _, key := range []string{"expired", "list", "other"} {
+		if _, err := db.Get(key); err != memdb.ErrKeyNotFound {
+			t.Errorf("Expected %s not to be imported, got %v", key, err)
+		}
+	}
+
+	// The ingested SSTables are found again when the database is reopened
+	db.Close()
+	db, err = memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error reopening DB: %s", err)
+	}
+	defer db.Close()
+	if got, err := db.Get("lzf"); err != nil || string(got) != "abcabcabc" {
+		t.Errorf("Expected lzf=abcabcabc after reopening, got %s (%v)", got, err)
+	}
+
+	if _, _, err := importer.ReadRDB(tempDir+"/test_wal.log", 0); err != importer.ErrNotRDB {
+		t.Errorf("Expected ErrNotRDB, got %v", err)
+	}
+}
+
+func TestImportOffline(t *testing.T) {
+	tempDir := t.TempDir()
+	sstableDir := tempDir + "/testSSTableFiles"
+
+	// An existing database holding an older value for b
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(1))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	if err := db.Set("b", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	wal.Close()
+
+	csvFile := "key,value\nc,3\na,1\nb,2\n\"d,e\",\"4\"\nc,33\n"
+	if err := os.WriteFile(tempDir+"/data.csv", []byte(csvFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kvs, report, err := importer.Read(tempDir+"/data.csv", importer.Options{Format: importer.FormatCSV})
+	if err != nil || report.Keys != 4 {
+		t.Fatalf("Error reading CSV: %+v, %v", report, err)
+	}
+	tables, err := memdb.IngestOffline(sstableDir, kvs, 3)
+	if err != nil || len(tables) != 2 {
+		t.Fatalf("Error ingesting: %v, %v", tables, err)
+	}
+
+	ndjsonFile := "{\"key\":\"f\",\"value\":\"Ng==\"}\n\n{\"key\":\"a\",\"value\":\"MTE=\"}\n"
+	if err := os.WriteFile(tempDir+"/data.ndjson", []byte(ndjsonFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kvs, _, err = importer.Read(tempDir+"/data.ndjson", importer.Options{Format: importer.FormatNDJSON, Base64: true})
+	if err != nil || len(kvs) != 2 || kvs[0].Key != "a" {
+		t.Fatalf("Error reading NDJSON: %v, %v", kvs, err)
+	}
+	if _, err := memdb.IngestOffline(sstableDir, kvs, 0); err != nil {
+		t.Fatalf("Error ingesting: %s", err)
+	}
+	if err := os.WriteFile(tempDir+"/bad.ndjson", []byte("{\"value\":\"x\"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := importer.Read(tempDir+"/bad.ndjson", importer.Options{Format: importer.FormatNDJSON}); err == nil {
+		t.Errorf("Expected an error for a line without a key")
+	}
+
+	wal, err = memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstableDir)
+	if err != nil {
+		t.Fatalf("Error opening DB: %s", err)
+	}
+	defer db.Close()
+	expected := map[string]string{"a": "11", "b": "2", "c": "33", "d,e": "4", "f": "6"}
+	for key, value := range expected {
+		if got, err := db.Get(key); err != nil || string(got) != value {
+			t.Errorf("Expected %s=%s, got %s (%v)", key, value, got, err)
+		}
+	}
+}
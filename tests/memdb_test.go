@@ -2,10 +2,24 @@ package tests
 
 import (
 	"StorageEngine/memdb"
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestMemdb_SetGetDelete(t *testing.T) {
@@ -52,7 +66,7 @@ func TestMemdb_SetGetDelete(t *testing.T) {
 	}
 
 	// Test Delete
-	val, err = db.Delete(key)
+	val, err = db.DeleteReturning(key)
 	if err != nil {
 		t.Errorf("Error deleting key: %s", err)
 	}
@@ -65,7 +79,7 @@ func TestMemdb_SetGetDelete(t *testing.T) {
 		t.Errorf("Expected key not found error, got: %s", err)
 	}
 
-	val, err = db.Delete(key)
+	val, err = db.DeleteReturning(key)
 	if err != memdb.ErrKeyNotFound {
 		t.Errorf("Expected key not found error, got: %s", err)
 	}
@@ -73,6 +87,19 @@ func TestMemdb_SetGetDelete(t *testing.T) {
 		t.Errorf("Expected deleted value: nil, got: %v", val)
 	}
 
+	// Blind deletes don't care whether the key exists
+	if err := db.Delete("missing"); err != nil {
+		t.Errorf("Expected no error deleting a missing key, got: %s", err)
+	}
+	if err := db.Set(key, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(key); err != nil {
+		t.Errorf("Error deleting key: %s", err)
+	}
+	if _, err := db.Get(key); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key not found error, got: %s", err)
+	}
 }
 
 func TestMemdb_ListKeys(t *testing.T) {
@@ -90,12 +117,16 @@ func TestMemdb_ListKeys(t *testing.T) {
 	}
 
 	defer func() {
+		db.Close()
 		if err := wal.Close(); err != nil {
 			t.Fatal(err)
 		}
 		if err := os.Remove(filePath); err != nil {
 			t.Fatal(err)
 		}
+		if err := os.RemoveAll(sstablesDirectory); err != nil {
+			t.Fatalf("Error removing test SSTable files directory: %s", err)
+		}
 	}()
 
 	keys := []string{"c", "a", "b"}
@@ -107,10 +138,2371 @@ func TestMemdb_ListKeys(t *testing.T) {
 		}
 	}
 
-	sortedKeys := db.ListKeys()
+	sortedKeys, err := db.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
 	expectedKeys := []string{"a", "b", "c"}
 
 	if !reflect.DeepEqual(sortedKeys, expectedKeys) {
 		t.Errorf("Expected keys: %v, got: %v", expectedKeys, sortedKeys)
 	}
 }
+
+func TestMemdb_ListKeysIncludesSSTables(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(4))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// The first 4 keys are flushed, the deletion of user:2 and user:4 stay in the memtable
+	for _, key := range []string{"user:3", "user:1", "user:2", "video:1"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("user:2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("user:4", []byte("user:4")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := db.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"user:1", "user:3", "user:4", "video:1"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys: %v, got: %v", expected, keys)
+	}
+
+	keys, err = db.ListKeysPrefix("user:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"user:1", "user:3", "user:4"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys: %v, got: %v", expected, keys)
+	}
+}
+
+func TestMemdb_DropAllAndDestroy(t *testing.T) {
+
+	// Create the db, laid out like a tenant
+	dbDir := t.TempDir() + "/db"
+	walPath := filepath.Join(dbDir, memdb.BackupWALName)
+	sstablesDirectory := filepath.Join(dbDir, memdb.BackupSSTableDirName)
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstablesDirectory, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// Set enough keys to have both flushed and in-memory data
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.SSTableIDs) != 1 {
+		t.Fatalf("Expected 1 SSTable, got %d", len(db.SSTableIDs))
+	}
+
+	if err := db.DropAll(); err != nil {
+		t.Fatalf("Error dropping data: %s", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := db.Get(key); err != memdb.ErrKeyNotFound {
+			t.Errorf("Expected key not found error for %s, got: %v", key, err)
+		}
+	}
+	if _, err := os.Stat(sstablesDirectory); err != nil {
+		t.Errorf("Expected SSTable directory to be kept: %s", err)
+	}
+
+	// The database stays usable, and nothing comes back after a restart
+	if err := db.Set("d", []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error reopening WAL: %s", err)
+	}
+	db, err = memdb.NewDB(wal, sstablesDirectory, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error recovering DB: %s", err)
+	}
+	if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key not found error, got: %v", err)
+	}
+	if val, err := db.Get("d"); err != nil || string(val) != "d" {
+		t.Errorf("Expected value d, got: %s (%v)", val, err)
+	}
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Destroy refuses to touch a directory holding foreign files, next to the WAL or among the tables
+	for _, foreign := range []string{dbDir + "/notes.txt", sstablesDirectory + "/notes.txt"} {
+		if err := os.WriteFile(foreign, []byte("keep me"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := memdb.Destroy(vfs.OS, dbDir); err != memdb.ErrForeignFiles {
+			t.Errorf("Expected foreign files error for %s, got: %v", foreign, err)
+		}
+		if err := os.Remove(foreign); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The leftovers of interrupted rewrites are the engine's own
+	for _, leftover := range []string{sstablesDirectory + "/000009.sst.tmp", sstablesDirectory + "/MANIFEST.tmp", walPath + ".tmp"} {
+		if err := os.WriteFile(leftover, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := memdb.Destroy(vfs.OS, dbDir); err != nil {
+		t.Fatalf("Error destroying DB: %s", err)
+	}
+	if _, err := os.Stat(dbDir); !os.IsNotExist(err) {
+		t.Errorf("Expected database directory to be removed, got: %v", err)
+	}
+	if _, err := os.Stat(walPath); !os.IsNotExist(err) {
+		t.Errorf("Expected WAL to be removed, got: %v", err)
+	}
+}
+
+func TestMemdb_Lock(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	walPath := tempDir + "/test_wal.log"
+	sstablesDirectory := tempDir + "/testSSTableFiles"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstablesDirectory)
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// A second writer is refused, on the WAL and on the SSTable directory
+	if _, err := memdb.OpenWAL(walPath); err != memdb.ErrLocked {
+		t.Errorf("Expected locked error on the WAL, got: %v", err)
+	}
+	other, err := memdb.OpenWAL(tempDir + "/other_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := memdb.NewDB(other, sstablesDirectory); err != memdb.ErrLocked {
+		t.Errorf("Expected locked error on the SSTable directory, got: %v", err)
+	}
+
+	// The locks are released once closed
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error reopening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstablesDirectory)
+	if err != nil {
+		t.Fatalf("Error reopening DB: %s", err)
+	}
+	db.Close()
+}
+
+func TestMemdb_Stats(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// "a" and "b" are flushed, "c" stays in the memtable
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("Error getting stats: %s", err)
+	}
+	if stats.MemtableEntries != 1 || stats.MemtableBytes != int64(len("c")+len("value")) {
+		t.Errorf("Unexpected memtable stats: %+v", stats)
+	}
+	if len(stats.Levels) != 1 || stats.Levels[0].Files != 1 || stats.Levels[0].Bytes == 0 {
+		t.Errorf("Unexpected level stats: %+v", stats.Levels)
+	}
+	// One record of 13 header bytes, 1 key byte and 5 value bytes is not flushed yet
+	recordSize := int64(memdb.WALRecordHeaderSize + 1 + 5)
+	if stats.WALLag != recordSize {
+		t.Errorf("Expected WAL lag %d, got %d", recordSize, stats.WALLag)
+	}
+	if stats.WALBytes != memdb.WALMetadataSize+3*recordSize {
+		t.Errorf("Expected WAL size %d, got %d", memdb.WALMetadataSize+3*recordSize, stats.WALBytes)
+	}
+	if stats.PendingCompactions != 0 {
+		t.Errorf("Expected no pending compaction, got %d", stats.PendingCompactions)
+	}
+}
+
+func TestMemdb_Quota(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	walPath := tempDir + "/test_wal.log"
+	sstablesDirectory := tempDir + "/testSSTableFiles"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstablesDirectory, memdb.Threshold(2), memdb.Quota(3, 20))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// 3 keys of 4 bytes each, "k1" and "k2" are flushed
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := db.Set(key, []byte("vv")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("k4", []byte("vv")); err != memdb.ErrQuotaExceeded {
+		t.Errorf("Expected quota exceeded error, got: %v", err)
+	}
+	// Updating an existing key (even one living in an SSTable) doesn't add a key
+	// This flushes again, so wait for the SSTable file name to change
+	time.Sleep(1100 * time.Millisecond)
+	if err := db.Set("k1", []byte("vvvvvv")); err != nil {
+		t.Errorf("Error updating key: %s", err)
+	}
+	if err := db.Set("k2", []byte("vvvvvvvvvv")); err != memdb.ErrQuotaExceeded {
+		t.Errorf("Expected quota exceeded error, got: %v", err)
+	}
+	if err := db.Delete("k3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("k4", []byte("vv")); err != nil {
+		t.Errorf("Error setting key after delete: %s", err)
+	}
+
+	expected := memdb.QuotaStats{QuotaLimits: memdb.QuotaLimits{MaxKeys: 3, MaxBytes: 20}, Keys: 3, Bytes: 16, Rejected: 2}
+	if stats := db.QuotaStats(); stats == nil || *stats != expected {
+		t.Errorf("Expected quota stats %+v, got %+v", expected, stats)
+	}
+
+	// The usage is recomputed when the database is reopened
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error reopening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstablesDirectory, memdb.Threshold(2), memdb.Quota(3, 20))
+	if err != nil {
+		t.Fatalf("Error recovering DB: %s", err)
+	}
+	expected.Rejected = 0
+	if stats := db.QuotaStats(); stats == nil || *stats != expected {
+		t.Errorf("Expected quota stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestMemdb_LowDiskSpace(t *testing.T) {
+
+	// Create a db requiring more free space than any disk has
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.MinFreeSpace(math.MaxUint64))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("Error getting stats: %s", err)
+	}
+	if stats.Disk == nil {
+		t.Skip("Disk usage is not supported on this platform")
+	}
+	if stats.Disk.SSTableFreeBytes == 0 || stats.Disk.WALFreeBytes == 0 {
+		t.Errorf("Expected free space to be reported, got %+v", stats.Disk)
+	}
+
+	if err := db.Set("key", []byte("value")); err != memdb.ErrLowDiskSpace {
+		t.Errorf("Expected low disk space error, got: %v", err)
+	}
+	if err := db.Delete("key"); err != memdb.ErrLowDiskSpace {
+		t.Errorf("Expected low disk space error, got: %v", err)
+	}
+	if _, err := db.Get("key"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected reads to keep working, got: %v", err)
+	}
+
+	stats, err = db.Stats()
+	if err != nil {
+		t.Fatalf("Error getting stats: %s", err)
+	}
+	if !stats.Disk.ReadOnly {
+		t.Errorf("Expected the database to be read-only")
+	}
+}
+
+func TestMemdb_Purge(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	walPath := tempDir + "/test_wal.log"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// "secret" and "a" are flushed together, then "secret" is updated in the memtable
+	for _, key := range []string{"secret", "a"} {
+		if err := db.Set(key, []byte("sensitive")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("secret", []byte("sensitive")); err != nil {
+		t.Fatal(err)
+	}
+	flushed := db.SSTableIDs[0]
+
+	// The purge flushes again, so wait for the SSTable file name to change
+	time.Sleep(1100 * time.Millisecond)
+	report, err := db.Purge("secret")
+	if err != nil {
+		t.Fatalf("Error purging key: %s", err)
+	}
+	if !report.Existed || !report.WALTruncated {
+		t.Errorf("Unexpected purge report: %+v", report)
+	}
+	if len(report.FilesRewritten) != 1 || report.FilesRewritten[0] != flushed {
+		t.Errorf("Expected %s to be rewritten, got %v", flushed, report.FilesRewritten)
+	}
+	// The SSTable flushed by the purge only held the tombstone
+	if len(report.FilesRemoved) != 1 || len(db.SSTableIDs) != 1 {
+		t.Errorf("Expected the tombstone SSTable to be removed, got %+v", report)
+	}
+
+	if _, err := db.Get("secret"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key not found error, got: %v", err)
+	}
+	if val, err := db.Get("a"); err != nil || string(val) != "sensitive" {
+		t.Errorf("Expected other keys to be kept, got: %s (%v)", val, err)
+	}
+
+	// No file of the database contains the value anymore
+	files := append([]string{walPath}, db.SSTableIDs...)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret")) {
+			t.Errorf("Found a copy of the purged key in %s", file)
+		}
+	}
+}
+
+func TestMemdb_Events(t *testing.T) {
+
+	// Create the db, flushing on every write and keeping 2 events
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(1), memdb.EventHistory(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	if events := db.Events(); len(events) != 0 {
+		t.Errorf("Expected no events, got %+v", events)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := db.Events()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	for _, event := range events {
+		if event.Type != memdb.EventFlush || event.Entries != 1 || len(event.Outputs) != 1 || event.OutputBytes == 0 || event.Error != "" {
+			t.Errorf("Unexpected flush event: %+v", event)
+		}
+	}
+	if events[1].Start.Before(events[0].Start) {
+		t.Errorf("Expected events from the oldest to the most recent")
+	}
+}
+
+func TestMemdb_JSONPath(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	if err := db.Set("user", []byte(`{"name":"imane","address":{"lines":["a","b"]}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	getTests := []struct {
+		path     string
+		expected string
+		err      error
+	}{
+		{"name", `"imane"`, nil},
+		{"address.lines[1]", `"b"`, nil},
+		{"address.lines", `["a","b"]`, nil},
+		{"address.lines[2]", "", memdb.ErrPathNotFound},
+		{"age", "", memdb.ErrPathNotFound},
+		{"address..lines", "", memdb.ErrInvalidPath},
+		{"address.lines[x]", "", memdb.ErrInvalidPath},
+	}
+	for _, test := range getTests {
+		val, err := db.GetPath("user", test.path)
+		if err != test.err || string(val) != test.expected {
+			t.Errorf("GetPath(%q): expected %s (%v), got %s (%v)", test.path, test.expected, test.err, val, err)
+		}
+	}
+
+	// Update a field, append to an array and create missing objects
+	if err := db.SetPath("user", "name", []byte(`"ilham"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPath("user", "address.lines[2]", []byte(`"c"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPath("user", "meta.visits", []byte(`3`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPath("user", "name.first", []byte(`"x"`)); err != memdb.ErrPathNotFound {
+		t.Errorf("Expected path not found error, got: %v", err)
+	}
+	if err := db.SetPath("user", "name", []byte(`not json`)); err != memdb.ErrNotJSON {
+		t.Errorf("Expected not JSON error, got: %v", err)
+	}
+
+	val, err := db.Get("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"address":{"lines":["a","b","c"]},"meta":{"visits":3},"name":"ilham"}`
+	if string(val) != expected {
+		t.Errorf("Expected document %s, got %s", expected, val)
+	}
+}
+
+func TestMemdb_PeriodicCompaction(t *testing.T) {
+
+	// Create the db, compacting tables older than a second
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2), memdb.PeriodicCompaction(time.Second))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	db.Set("a", []byte("1"))
+	db.Set("b", []byte("2"))
+	time.Sleep(1100 * time.Millisecond) // SSTables are named after the second they are flushed in
+	db.Delete("a")
+	db.Set("c", []byte("3"))
+
+	// Below the compaction threshold, the tables are still merged once they are old enough
+	deadline := time.Now().Add(5 * time.Second)
+	var tables []memdb.SSTableInfo
+	for time.Now().Before(deadline) {
+		if tables, err = db.ListSSTables(); err != nil {
+			t.Fatal(err)
+		}
+		if len(tables) == 1 && tables[0].Tombstones == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(tables) != 1 || tables[0].Tombstones != 0 || tables[0].Entries != 2 {
+		t.Fatalf("Expected a single table of 2 entries without tombstones, got %+v", tables)
+	}
+	if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected a to stay deleted, got %v", err)
+	}
+	for key, expected := range map[string]string{"b": "2", "c": "3"} {
+		if value, err := db.Get(key); err != nil || string(value) != expected {
+			t.Errorf("Expected %s=%s, got %q, %v", key, expected, value, err)
+		}
+	}
+}
+
+func TestMemdb_TombstoneGrace(t *testing.T) {
+
+	// Create the db, compacting tables older than a second and keeping deletions for 2 seconds
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2), memdb.PeriodicCompaction(time.Second),
+		memdb.TombstoneGrace(2*time.Second))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	db.Set("a", []byte("1"))
+	db.Set("b", []byte("2"))
+	time.Sleep(1100 * time.Millisecond)
+	db.Delete("a")
+	db.Set("c", []byte("3"))
+
+	// waitForTables waits for the tables to be merged into one holding tombstones deletions
+	waitForTables := func(tombstones int) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		var tables []memdb.SSTableInfo
+		for time.Now().Before(deadline) {
+			if tables, err = db.ListSSTables(); err != nil {
+				t.Fatal(err)
+			}
+			if len(tables) == 1 && tables[0].Tombstones == tombstones {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatalf("Expected a single table with %d tombstones, got %+v", tombstones, tables)
+	}
+
+	// The first compaction keeps the deletion, younger than the grace period
+	waitForTables(1)
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TombstoneBytes != int64(len("a")+8) { // The key and the time of the flush
+		t.Errorf("Expected %d tombstone bytes, got %d", len("a")+8, stats.TombstoneBytes)
+	}
+	if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected a to stay deleted, got %v", err)
+	}
+
+	// A later one drops it
+	waitForTables(0)
+	if stats, err := db.Stats(); err != nil || stats.TombstoneBytes != 0 {
+		t.Errorf("Expected no tombstone bytes, got %d, %v", stats.TombstoneBytes, err)
+	}
+	if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected a to stay deleted, got %v", err)
+	}
+}
+
+func TestMemdb_TimeSeries(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	sstableDir := tempDir + "/testSSTableFiles"
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	// A table of several windows, written before the time-series mode
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(100))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	db.Set(memdb.TimeKey("cpu", old, "a"), []byte("1"))
+	db.Set(memdb.TimeKey("cpu", now, "a"), []byte("2"))
+	db.Set("meta", []byte("3"))
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = memdb.NewDB(wal, sstableDir, memdb.Threshold(100),
+		memdb.TimeSeries(memdb.TimeSeriesPolicy{Window: time.Hour, Retention: 24 * time.Hour}))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// Flushes write a table for each window
+	db.Set(memdb.TimeKey("cpu", old, "b"), []byte("4"))
+	db.Set(memdb.TimeKey("cpu", now, "b"), []byte("5"))
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	if tables, err := db.ListSSTables(); err != nil || len(tables) != 3 {
+		t.Fatalf("Expected the old table and a table for each window, got %+v, %v", tables, err)
+	}
+
+	// The retention pass splits the old table by window, then drops the expired window whole
+	dropped, err := db.ApplyRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 {
+		t.Errorf("Expected the table of the expired window to be dropped, got %v", dropped)
+	}
+	for _, key := range []string{memdb.TimeKey("cpu", old, "a"), memdb.TimeKey("cpu", old, "b")} {
+		if _, err := db.Get(key); err != memdb.ErrKeyNotFound {
+			t.Errorf("Expected %s to be dropped, got %v", key, err)
+		}
+	}
+	for key, expected := range map[string]string{memdb.TimeKey("cpu", now, "a"): "2", memdb.TimeKey("cpu", now, "b"): "5", "meta": "3"} {
+		if value, err := db.Get(key); err != nil || string(value) != expected {
+			t.Errorf("Expected %s=%s, got %q, %v", key, expected, value, err)
+		}
+	}
+	events := db.Events()
+	if last := events[len(events)-1]; last.Type != memdb.EventRetention || !reflect.DeepEqual(last.Inputs, dropped) {
+		t.Errorf("Expected a retention event, got %+v", last)
+	}
+
+	// Compactions merge the tables of a window, never those of different windows
+	db.Set(memdb.TimeKey("cpu", now, "c"), []byte("6"))
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	tables, err := db.ListSSTables()
+	if err != nil || len(tables) != 2 {
+		t.Fatalf("Expected a table for the current window and one for the other keys, got %+v, %v", tables, err)
+	}
+	for _, table := range tables {
+		if table.SmallestKey == "meta" && table.LargestKey != "meta" {
+			t.Errorf("Expected the other keys in a table of their own, got %+v", table)
+		}
+	}
+	if value, err := db.Get(memdb.TimeKey("cpu", now, "c")); err != nil || string(value) != "6" {
+		t.Errorf("Expected 6, got %q, %v", value, err)
+	}
+}
+
+func TestMemdb_CompactionOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(1), memdb.Compaction(memdb.CompactionOptions{MinFiles: 3, MaxFiles: 3, Auto: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Every write is flushed, the third SSTable triggers a compaction of the three
+	for i, key := range []string{"a", "b", "c"} {
+		if i > 0 {
+			time.Sleep(1100 * time.Millisecond) // SSTables are named after the second they are flushed in
+		}
+		if err := db.Set(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			stats, err := db.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(db.SSTableIDs) != 2 || stats.PendingCompactions != 0 {
+				t.Errorf("Expected 2 SSTables and no pending compaction, got %d and %d", len(db.SSTableIDs), stats.PendingCompactions)
+			}
+		}
+	}
+	if len(db.SSTableIDs) != 1 {
+		t.Errorf("Expected the SSTables to be compacted into one, got %v", db.SSTableIDs)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if value, err := db.Get(key); err != nil || string(value) != "value" {
+			t.Errorf("Expected value for %q, got %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestMemdb_ReadersOpenedOnWrite(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Open files can't be removed on Windows")
+	}
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(1), memdb.Compaction(memdb.CompactionOptions{MinFiles: 2}))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// The tables written by flushes and compactions are looked up without opening their file again
+	for _, key := range []string{"a", "b"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("c", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	for _, sstableID := range db.SSTableIDs {
+		if err := os.Remove(sstableID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if val, err := db.Get(key); err != nil || string(val) != key {
+			t.Errorf("Expected value %s, got: %s (%v)", key, val, err)
+		}
+	}
+	if _, err := db.Get("d"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key not found error, got: %v", err)
+	}
+}
+
+func TestMemdb_ParallelLookup(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2), memdb.ParallelLookup(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// Overlapping tables, newer ones overwriting and deleting the keys of older ones
+	for i := 0; i < 8; i++ {
+		if err := db.Set("a", []byte{byte('0' + i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Set(string(rune('b'+i)), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("z", []byte("z")); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.SSTableIDs) < 8 {
+		t.Fatalf("Expected at least 8 SSTables, got %d", len(db.SSTableIDs))
+	}
+
+	// The newest table holding a key answers
+	if val, err := db.GetValueFromSSTables("a"); err != nil || string(val) != "7" {
+		t.Errorf("Expected value 7, got: %s (%v)", val, err)
+	}
+	if val, err := db.GetValueFromSSTables("b"); err != nil || string(val) != "x" {
+		t.Errorf("Expected value x, got: %s (%v)", val, err)
+	}
+	if _, err := db.GetValueFromSSTables("c"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key not found error for a deleted key, got: %v", err)
+	}
+	if _, err := db.GetValueFromSSTables("y"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key not found error, got: %v", err)
+	}
+}
+
+func TestMemdb_ValueCache(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(1), memdb.ValueCache(4))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	get := func(key string, expected string) {
+		t.Helper()
+		if val, err := db.Get(key); err != nil || string(val) != expected {
+			t.Errorf("Expected value %s, got: %s (%v)", expected, val, err)
+		}
+	}
+
+	// The second read of a flushed key is served by the cache
+	if err := db.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	get("a", "1")
+	get("a", "1")
+
+	// Writes drop the cached value, once flushed too
+	if err := db.Set("a", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	get("a", "2")
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key not found error, got: %v", err)
+	}
+
+	// Least recently read values are dropped once the cache is full
+	for _, key := range []string{"b", "c", "d"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		get(key, key)
+	}
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := memdb.CacheStats{Entries: 2, Bytes: 4, Capacity: 4, Hits: 1, Misses: 5, Evictions: 1}
+	if stats.ValueCache == nil || *stats.ValueCache != expected {
+		t.Errorf("Expected cache stats %+v, got %+v", expected, stats.ValueCache)
+	}
+}
+
+func TestMemdb_GenerationFilenames(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath := tempDir + "/test_wal.log"
+	sstableDir := tempDir + "/testSSTableFiles"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(1), memdb.Compaction(memdb.CompactionOptions{MinFiles: 3, MaxFiles: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flushes within the same second get their own SSTables
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("a", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	names := func() []string {
+		names := make([]string, len(db.SSTableIDs))
+		for i, sstableID := range db.SSTableIDs {
+			names[i] = filepath.Base(sstableID)
+		}
+		return names
+	}
+	if expected := []string{"000001.sst", "000002.sst", "000003.sst", "000004.sst"}; !reflect.DeepEqual(names(), expected) {
+		t.Fatalf("Expected SSTables %v, got %v", expected, names())
+	}
+
+	// The outputs of a compaction take the place of the newest table merged, before the newer ones
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"000003-1.sst", "000004.sst"}; !reflect.DeepEqual(names(), expected) {
+		t.Errorf("Expected SSTables %v, got %v", expected, names())
+	}
+
+	// The order is the same once reopened, and new tables follow the last generation
+	db.Close()
+	wal.Close()
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstableDir, memdb.Threshold(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("d", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"000003-1.sst", "000004.sst", "000005.sst"}; !reflect.DeepEqual(names(), expected) {
+		t.Errorf("Expected SSTables %v, got %v", expected, names())
+	}
+	for key, expected := range map[string]string{"a": "new", "b": "old", "c": "old", "d": "old"} {
+		if value, err := db.Get(key); err != nil || string(value) != expected {
+			t.Errorf("Expected %q for %q, got %q, %v", expected, key, value, err)
+		}
+	}
+
+	// An existing SSTable is never written over
+	if err := sstable.WriteSSTable(db.SSTableIDs[0], sstable.NewSSTable([]sstable.KeyValuePair{{Operation: sstable.OpSet, Key: []byte("a")}})); !os.IsExist(err) {
+		t.Errorf("Expected writing over an SSTable to fail, got %v", err)
+	}
+}
+
+func TestMemdb_MemFS(t *testing.T) {
+	fsys := vfs.NewMem()
+	walPath := "data/test_wal.log"
+	sstableDir := "data/testSSTableFiles"
+	if err := fsys.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := memdb.OpenWALFS(fsys, walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2), memdb.Compaction(memdb.CompactionOptions{MinFiles: 3, Auto: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flushes and compactions write their SSTables in memory
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := db.Set(key, []byte("value-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("g", []byte("value-g")); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.SSTableIDs) != 2 {
+		t.Errorf("Expected a compacted SSTable and a new one, got %v", db.SSTableIDs)
+	}
+	files, err := fsys.ReadDir(sstableDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 { // The SSTables, the LOCK file and the MANIFEST
+		t.Errorf("Expected 4 files in the SSTable directory, got %d", len(files))
+	}
+	if _, err := os.Stat(sstableDir); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written to disk, got %v", err)
+	}
+
+	// A second writer is refused
+	if _, err := memdb.OpenWALFS(fsys, walPath); err != memdb.ErrLocked {
+		t.Errorf("Expected locked error on the WAL, got: %v", err)
+	}
+
+	// Everything is recovered from the same file system, the unflushed records from the WAL
+	if err := db.Set("h", []byte("value-h")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = memdb.OpenWALFS(fsys, walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstableDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "c", "d", "e", "f", "g", "h"} {
+		if value, err := db.Get(key); err != nil || string(value) != "value-"+key {
+			t.Errorf("Expected %q for %q, got %q, %v", "value-"+key, key, value, err)
+		}
+	}
+	if _, err := db.Get("b"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected deleted key to be missing, got %v", err)
+	}
+}
+
+func TestMemdb_DirectIO(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3), memdb.DirectIO(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Tables of a few bytes and of several blocks, neither a multiple of the block size
+	for i := 0; i < 9; i++ {
+		if err := db.Set(string(rune('a'+i)), bytes.Repeat([]byte{byte('0' + i)}, 1000*i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sstableID := range db.SSTableIDs {
+		direct, err := vfs.ReadFileDirect(sstableID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := os.ReadFile(sstableID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(direct, expected) {
+			t.Errorf("Expected %d bytes read from %s, got %d", len(expected), sstableID, len(direct))
+		}
+	}
+
+	// Whole tables are read around the page cache
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := db.ListKeys()
+	if err != nil || len(keys) != 9 {
+		t.Errorf("Expected 9 keys, got %v, %v", keys, err)
+	}
+	if result := db.ScrubNow(); result.Checked != len(db.SSTableIDs) || len(result.Corrupted) > 0 {
+		t.Errorf("Expected every SSTable to be verified, got %+v", result)
+	}
+	if value, err := db.Get("i"); err != nil || len(value) != 8000 {
+		t.Errorf("Expected a value of 8000 bytes, got %d, %v", len(value), err)
+	}
+}
+
+func TestMemdb_KeyRules(t *testing.T) {
+	open := func(mode memdb.KeyMode) *memdb.DB {
+		tempDir := t.TempDir()
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { wal.Close() })
+		db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Keys(mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	invalid := func(err error, reason string) bool {
+		var keyErr *memdb.InvalidKeyError
+		return errors.As(err, &keyErr) && keyErr.Reason == reason
+	}
+
+	// Empty and reserved keys are refused by every operation
+	db := open(memdb.KeysBinary)
+	if err := db.Set("", []byte("v")); !invalid(err, "empty") {
+		t.Errorf("Expected empty key error, got %v", err)
+	}
+	if err := db.Set(memdb.ReservedKeyPrefix+"meta", []byte("v")); !invalid(err, "reserved prefix") {
+		t.Errorf("Expected reserved prefix error, got %v", err)
+	}
+	if _, err := db.Get(memdb.ReservedKeyPrefix + "meta"); !invalid(err, "reserved prefix") {
+		t.Errorf("Expected reserved prefix error on read, got %v", err)
+	}
+	if err := db.Delete(""); !invalid(err, "empty") {
+		t.Errorf("Expected empty key error on delete, got %v", err)
+	}
+	if err := db.Set("\xff\xfe", []byte("v")); err != nil {
+		t.Errorf("Expected binary key to be accepted, got %v", err)
+	}
+
+	// UTF-8 keys only
+	db = open(memdb.KeysUTF8)
+	if err := db.Set("\xff\xfe", []byte("v")); !invalid(err, "not valid UTF-8") {
+		t.Errorf("Expected invalid UTF-8 error, got %v", err)
+	}
+	if err := db.Set("clé", []byte("v")); err != nil {
+		t.Errorf("Expected UTF-8 key to be accepted, got %v", err)
+	}
+
+	// Escaped keys are stored escaped and found again
+	db = open(memdb.KeysEscaped)
+	for _, key := range []string{"\xff", `\xff`, memdb.ReservedKeyPrefix + "meta", "plain"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"\xff", `\xff`, memdb.ReservedKeyPrefix + "meta", "plain"} {
+		if value, err := db.Get(key); err != nil || string(value) != key {
+			t.Errorf("Expected %q for %q, got %q, %v", key, key, value, err)
+		}
+	}
+	keys, err := db.ListKeys()
+	if expected := []string{`\\xff`, `\x00meta`, `\xff`, "plain"}; err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %q, got %q, %v", expected, keys, err)
+	}
+}
+
+// binaryKeys are keys that aren't friendly strings: separators, control characters, UTF-8 and invalid UTF-8
+var binaryKeys = []string{"a/b/c", "../x", "tab\tnew\nline", "\x01\x7f", "clé-日本", "\xff\xfe\x80", "a\x00b", "%2F+ ="}
+
+func TestMemdb_BinaryKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath := filepath.Join(tempDir, "test_wal.log")
+	sstableDir := filepath.Join(tempDir, "testSSTableFiles")
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Half of the keys end up in an SSTable, the others only in the WAL
+	for i, key := range binaryKeys {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatalf("Set %q: %v", key, err)
+		}
+		if i == len(binaryKeys)/2 {
+			if err := db.FlushToSSTable(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstableDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range binaryKeys {
+		if value, err := db.Get(key); err != nil || string(value) != key {
+			t.Errorf("Expected %q, got %q, %v", key, value, err)
+		}
+	}
+	kvs, err := db.Scan(memdb.ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != len(binaryKeys) {
+		t.Errorf("Expected %d keys scanned, got %d", len(binaryKeys), len(kvs))
+	}
+	for i := 1; i < len(kvs); i++ {
+		if kvs[i-1].Key >= kvs[i].Key {
+			t.Errorf("Expected keys in byte order, got %q before %q", kvs[i-1].Key, kvs[i].Key)
+		}
+	}
+	if err := db.Delete("\xff\xfe\x80"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("\xff\xfe\x80"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected deleted key to be missing, got %v", err)
+	}
+}
+
+func TestMemdb_TombstonesCarryNoValue(t *testing.T) {
+	tempDir := t.TempDir()
+	sstableDir := filepath.Join(tempDir, "testSSTableFiles")
+	wal, err := memdb.OpenWAL(filepath.Join(tempDir, "test_wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, sstableDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Deleting a key only found in an SSTable flushes a deletion alone
+	if err := db.Set("key", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.DeleteReturning("key"); err != nil || string(value) != "secret" {
+		t.Fatalf("Expected the deleted value, got %q, %v", value, err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	sst, err := sstable.ReadSSTable(db.SSTableIDs[len(db.SSTableIDs)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sst.KeyValues) != 1 || sst.KeyValues[0].Operation != sstable.OpDel || len(sst.KeyValues[0].Value) != 0 {
+		t.Errorf("Expected a single deletion without value, got %+v", sst.KeyValues)
+	}
+	if _, err := db.Get("key"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected deleted key to be missing, got %v", err)
+	}
+
+	// Tables written by older flushes hold a set of the old value next to the deletion, in either order
+	legacyDir := filepath.Join(tempDir, "legacy")
+	if err := os.MkdirAll(legacyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, keyValues := range [][]sstable.KeyValuePair{
+		{{Operation: sstable.OpDel, Key: []byte("a")}, {Operation: sstable.OpSet, Key: []byte("a"), Value: []byte("v")}},
+		{{Operation: sstable.OpSet, Key: []byte("b"), Value: []byte("v")}, {Operation: sstable.OpDel, Key: []byte("b")}},
+	} {
+		if sstable.ShadowedByDeletion(keyValues, 1) != (i == 0) {
+			t.Errorf("Expected only a set following a deletion to be shadowed")
+		}
+		path := filepath.Join(legacyDir, fmt.Sprintf("%06d.sst", i+1))
+		if err := sstable.WriteSSTable(path, sstable.NewSSTable(keyValues)); err != nil {
+			t.Fatal(err)
+		}
+		merged, err := sstable.MergeSSTables([]string{path}, tempDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sst, err := sstable.ReadSSTable(merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sst.KeyValues) != 1 || sst.KeyValues[0].Operation != sstable.OpDel {
+			t.Errorf("Expected the merge to keep the deletion alone, got %+v", sst.KeyValues)
+		}
+	}
+	legacyWAL, err := memdb.OpenWAL(filepath.Join(tempDir, "legacy_wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacyWAL.Close()
+	legacy, err := memdb.NewDB(legacyWAL, legacyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+	if kvs, err := legacy.Scan(memdb.ScanOptions{}); err != nil || len(kvs) != 0 {
+		t.Errorf("Expected no key scanned, got %v, %v", kvs, err)
+	}
+	if keys, err := legacy.ListKeysPrefix(""); err != nil || len(keys) != 0 {
+		t.Errorf("Expected no key listed, got %v, %v", keys, err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := legacy.Get(key); err != memdb.ErrKeyNotFound {
+			t.Errorf("Expected %q to be missing, got %v", key, err)
+		}
+	}
+}
+
+func TestMemdb_Encryption(t *testing.T) {
+	tempDir := t.TempDir()
+	sstableDir := tempDir + "/testSSTableFiles"
+	key := bytes.Repeat([]byte{7}, 32)
+	open := func(options ...memdb.Option) (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := memdb.NewDB(wal, sstableDir, append([]memdb.Option{memdb.Threshold(3)}, options...)...)
+		if err != nil {
+			wal.Close()
+			t.Fatal(err)
+		}
+		return db, wal
+	}
+
+	// A table written in clear before the key is set
+	db, wal := open()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte("clear-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	wal.Close()
+
+	db, wal = open(memdb.Encryption(key), memdb.DirectIO(true))
+	for _, key := range []string{"d", "e", "f"} {
+		if err := db.Set(key, []byte("secret-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"a", "f"} {
+		if _, err := db.Get(key); err != nil {
+			t.Errorf("Error getting %s: %s", key, err)
+		}
+	}
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	for _, sstableID := range db.SSTableIDs {
+		raw, err := os.ReadFile(sstableID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, []byte("clear-")) || bytes.Contains(raw, []byte("secret-")) {
+			t.Errorf("Expected %s to be encrypted", sstableID)
+		}
+	}
+	keys, err := db.ListKeys()
+	if err != nil || len(keys) != 6 {
+		t.Errorf("Expected 6 keys, got %v, %v", keys, err)
+	}
+	db.Close()
+	wal.Close()
+
+	// The tables only read back with the key
+	db, wal = open()
+	if _, err := db.Get("a"); !errors.Is(err, sstable.ErrNoEncryptionKey) {
+		t.Errorf("Expected ErrNoEncryptionKey, got %v", err)
+	}
+	db.Close()
+	wal.Close()
+	db, wal = open(memdb.Encryption(key))
+	defer wal.Close()
+	defer db.Close()
+	if value, err := db.Get("e"); err != nil || string(value) != "secret-e" {
+		t.Errorf("Expected e=secret-e, got %q, %v", value, err)
+	}
+}
+
+func TestMemdb_EncryptionKeyRotation(t *testing.T) {
+	tempDir := t.TempDir()
+	keys := sstable.StaticKeys{"2026a": bytes.Repeat([]byte{1}, 32), "2026b": bytes.Repeat([]byte{2}, 16)}
+	fetched := make(map[string]int)
+	provider := sstable.KeyProviderFunc(func(name string) ([]byte, error) {
+		fetched[name]++
+		return keys.Key(name)
+	})
+	open := func(current string) (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2), memdb.EncryptionKeys(provider, current))
+		if err != nil {
+			wal.Close()
+			t.Fatal(err)
+		}
+		return db, wal
+	}
+	keyNames := func(db *memdb.DB) []string {
+		infos, err := db.ListSSTables()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(infos))
+		for _, info := range infos {
+			names = append(names, info.KeyName)
+		}
+		return names
+	}
+
+	db, wal := open("2026a")
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := db.Set(key, []byte("value-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	wal.Close()
+
+	// New tables use the new key, old ones are read with theirs
+	db, wal = open("2026b")
+	for _, key := range []string{"e", "f"} {
+		if err := db.Set(key, []byte("value-"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names := keyNames(db); !reflect.DeepEqual(names, []string{"2026a", "2026a", "2026b"}) {
+		t.Errorf("Expected 2 tables encrypted with 2026a and 1 with 2026b, got %v", names)
+	}
+	if value, err := db.Get("a"); err != nil || string(value) != "value-a" {
+		t.Errorf("Expected a=value-a, got %q, %v", value, err)
+	}
+	// Compactions re-encrypt the tables they rewrite
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range keyNames(db) {
+		if name != "2026b" {
+			t.Errorf("Expected every table to be encrypted with 2026b, got %v", keyNames(db))
+			break
+		}
+	}
+	db.Close()
+	wal.Close()
+	if fetched["2026a"] == 0 || fetched["2026b"] == 0 {
+		t.Errorf("Expected both keys to be fetched from the provider, got %v", fetched)
+	}
+
+	// The old key can go
+	delete(keys, "2026a")
+	db, wal = open("2026b")
+	defer wal.Close()
+	defer db.Close()
+	keys2, err := db.ListKeys()
+	if err != nil || len(keys2) != 6 {
+		t.Errorf("Expected 6 keys, got %v, %v", keys2, err)
+	}
+	if _, err := sstable.NewKeyring(provider, "2026a"); !errors.Is(err, sstable.ErrUnknownEncryptionKey) {
+		t.Errorf("Expected ErrUnknownEncryptionKey, got %v", err)
+	}
+	if _, err := sstable.NewKeyring(provider, "too-long-name"); err != sstable.ErrInvalidKeyName {
+		t.Errorf("Expected ErrInvalidKeyName, got %v", err)
+	}
+}
+
+func TestMemdb_RecordReplay(t *testing.T) {
+	open := func(options ...memdb.Option) (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWALFS(vfs.NewMem(), "wal.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := memdb.NewDB(wal, "SSTableFiles", append(options, memdb.Threshold(3))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db, wal
+	}
+
+	// Record a workload, errors included
+	var recording bytes.Buffer
+	db, wal := open(memdb.Record(&recording, nil))
+	if _, err := db.Get("key1"); err != memdb.ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Set(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf(`{"n":%d}`, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("key3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("key3"); err != memdb.ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if err := db.SetPath("key4", "m", []byte("true")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Scan(memdb.ScanOptions{Prefix: "key", Limit: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Purge("key5"); err != nil {
+		t.Fatal(err)
+	}
+	want, err := db.Scan(memdb.ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	wal.Close()
+	if lines := bytes.Count(recording.Bytes(), []byte("\n")); lines != 17 {
+		t.Fatalf("Expected 17 recorded operations, got %d:\n%s", lines, recording.String())
+	}
+
+	// The replay on a new database ends with the same data, and no operation diverges
+	db, wal = open()
+	defer wal.Close()
+	defer db.Close()
+	report, err := memdb.Replay(db, bytes.NewReader(recording.Bytes()), memdb.ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Ops != 17 || report.ByOp[memdb.RecordSet].Count != 10 || report.ByOp[memdb.RecordGet].Count != 2 || report.ByOp[memdb.RecordScan].Count != 2 {
+		t.Errorf("Unexpected counts %+v", report)
+	}
+	if report.Diverged != 0 {
+		t.Errorf("Expected no divergence, got %v", report.Divergences)
+	}
+	got, err := db.Scan(memdb.ScanOptions{})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v after the replay, got %v, %v", want, got, err)
+	}
+
+	// Replaying on a database that already holds the keys diverges where the first recorded get didn't find key1
+	report, err = memdb.Replay(db, bytes.NewReader(recording.Bytes()), memdb.ReplayOptions{})
+	if err != nil || report.Diverged != 1 {
+		t.Errorf("Expected one divergence, got %v, %v", report.Divergences, err)
+	}
+	if _, err := memdb.Replay(db, strings.NewReader(`{"op":"flushall"}`), memdb.ReplayOptions{}); !errors.Is(err, memdb.ErrUnknownRecordedOp) {
+		t.Errorf("Expected ErrUnknownRecordedOp, got %v", err)
+	}
+}
+
+func TestMemdb_ChunkedValues(t *testing.T) {
+	fsys := vfs.NewMem()
+	open := func() (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWALFS(fsys, "wal.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := memdb.NewDB(wal, "SSTableFiles", memdb.Threshold(4), memdb.ChunkSize(16), memdb.SizeLimits(0, 32),
+			memdb.Compaction(memdb.CompactionOptions{MinFiles: 2, Auto: true}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db, wal
+	}
+	chunks := func(db *memdb.DB) int {
+		tables, err := db.ReadSSTables()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, table := range tables {
+			for _, kv := range table.KeyValues {
+				if bytes.HasPrefix(kv.Key, []byte(memdb.ReservedKeyPrefix+"chunk/")) {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	db, wal := open()
+
+	// A value larger than the largest value accepted by Set is stored in chunks and read whole
+	blob := bytes.Repeat([]byte("0123456789"), 20)
+	if err := db.SetReader("blob", bytes.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetReader("small", strings.NewReader("fits")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("blob"); err != nil || !bytes.Equal(value, blob) {
+		t.Errorf("Expected the whole value, got %q, %v", value, err)
+	}
+	var streamed bytes.Buffer
+	if err := db.GetWriter("blob", &streamed); err != nil || !bytes.Equal(streamed.Bytes(), blob) {
+		t.Errorf("Expected the value streamed, got %q, %v", streamed.Bytes(), err)
+	}
+	if value, err := db.Get("small"); err != nil || string(value) != "fits" {
+		t.Errorf("Expected a regular value, got %q, %v", value, err)
+	}
+	if err := db.GetWriter("missing", &streamed); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	// The chunks don't show up in listings and scans
+	if keys, err := db.ListKeys(); err != nil || !reflect.DeepEqual(keys, []string{"blob", "small"}) {
+		t.Errorf("Expected only the keys written, got %q, %v", keys, err)
+	}
+	kvs, err := db.Scan(memdb.ScanOptions{})
+	if err != nil || len(kvs) != 2 || !bytes.Equal(kvs[0].Value, blob) {
+		t.Errorf("Expected the whole value scanned, got %v, %v", kvs, err)
+	}
+	// Set can't write what would read as a chunked value
+	if err := db.Set("fake", []byte("\x00CHUNKS\x01\x01\x01\x01")); err != memdb.ErrReservedValue {
+		t.Errorf("Expected ErrReservedValue, got %v", err)
+	}
+
+	// An upload failing half-way leaves the previous value
+	failing := io.MultiReader(bytes.NewReader(bytes.Repeat([]byte("x"), 40)), iotest.ErrReader(errors.New("broken")))
+	if err := db.SetReader("blob", failing); err == nil || err.Error() != "broken" {
+		t.Errorf("Expected the error of the reader, got %v", err)
+	}
+	if value, err := db.Get("blob"); err != nil || !bytes.Equal(value, blob) {
+		t.Errorf("Expected the previous value, got %q, %v", value, err)
+	}
+
+	// Once the value is replaced, compactions drop the chunks of the previous one
+	replaced := bytes.Repeat([]byte("abc"), 20)
+	if err := db.SetReader("blob", bytes.NewReader(replaced)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	if n := chunks(db); n != 4 {
+		t.Errorf("Expected only the 4 chunks of the current value, got %d", n)
+	}
+	db.Close()
+	wal.Close()
+
+	db, wal = open()
+	defer wal.Close()
+	defer db.Close()
+	if value, err := db.Get("blob"); err != nil || !bytes.Equal(value, replaced) {
+		t.Errorf("Expected the value after reopening, got %q, %v", value, err)
+	}
+}
+
+func TestMemdb_Iterator(t *testing.T) {
+	wal, err := memdb.OpenWALFS(vfs.NewMem(), "wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := memdb.NewDB(wal, "SSTableFiles", memdb.Threshold(3), memdb.ChunkSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Versions spread over several SSTables and the memtable
+	for i, kv := range [][2]string{{"a", "1"}, {"b", "old"}, {"c", "3"}, {"d", "4"}, {"b", "2"}, {"e", "5"}, {"f", "6"}} {
+		if err := db.Set(kv[0], []byte(kv[1])); err != nil {
+			t.Fatalf("Error setting %d: %s", i, err)
+		}
+	}
+	if err := db.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetReader("g", strings.NewReader("a value of several chunks")); err != nil {
+		t.Fatal(err)
+	}
+
+	collect := func(opts memdb.IteratorOptions) string {
+		it, err := db.NewIterator(opts)
+		if err != nil {
+			t.Fatalf("Error creating iterator: %s", err)
+		}
+		defer it.Close()
+		var out []string
+		for ; it.Valid(); it.Next() {
+			out = append(out, it.Key()+"="+string(it.Value()))
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Error iterating: %s", err)
+		}
+		return strings.Join(out, " ")
+	}
+	if got, want := collect(memdb.IteratorOptions{}), "a=1 b=2 d=4 e=5 f=6 g=a value of several chunks"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := collect(memdb.IteratorOptions{Start: "b", End: "f"}), "b=2 d=4 e=5"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := collect(memdb.IteratorOptions{Prefix: "e"}), "e=5"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Writes wait for the iterator to be closed
+	it, err := db.NewIterator(memdb.IteratorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- db.Set("h", []byte("8")) }()
+	select {
+	case <-done:
+		t.Fatal("Expected the write to wait for the iterator")
+	case <-time.After(50 * time.Millisecond):
+	}
+	it.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemdb_Leases(t *testing.T) {
+	tempDir := t.TempDir()
+	open := func() (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatalf("Error opening WAL: %s", err)
+		}
+		db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+		if err != nil {
+			t.Fatalf("Error creating DB: %s", err)
+		}
+		return db, wal
+	}
+	db, wal := open()
+
+	// A held lock can't be acquired again, and its name doesn't collide with a key
+	lock, err := db.AcquireLock("jobs", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AcquireLock("jobs", time.Minute); err != memdb.ErrLockHeld {
+		t.Errorf("Expected ErrLockHeld, got %v", err)
+	}
+	if _, err := db.Get("jobs"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected the lock to be hidden from keys, got %v", err)
+	}
+	if _, err := db.AcquireLock("jobs", 0); err != memdb.ErrInvalidTTL {
+		t.Errorf("Expected ErrInvalidTTL, got %v", err)
+	}
+
+	// Only the holder refreshes and releases it
+	if err := db.ReleaseLock("jobs", lock.Token+1); err != memdb.ErrLockNotHeld {
+		t.Errorf("Expected ErrLockNotHeld with another token, got %v", err)
+	}
+	expires := lock.Expires
+	if err := lock.Refresh(time.Hour); err != nil || !lock.Expires.After(expires) {
+		t.Errorf("Expected the lock to be extended, got %v, %v", lock.Expires, err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(); err != memdb.ErrLockNotHeld {
+		t.Errorf("Expected ErrLockNotHeld once released, got %v", err)
+	}
+
+	// An expired lock is acquired again with a larger token, and its old holder lost it
+	short, err := db.AcquireLock("jobs", 20*time.Millisecond)
+	if err != nil || short.Token <= lock.Token {
+		t.Fatalf("Expected a token larger than %d, got %v, %v", lock.Token, short, err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	next, err := db.AcquireLock("jobs", time.Minute)
+	if err != nil || next.Token <= short.Token {
+		t.Fatalf("Expected to acquire the expired lock with a larger token, got %v, %v", next, err)
+	}
+	if err := short.Refresh(time.Minute); err != memdb.ErrLockNotHeld {
+		t.Errorf("Expected ErrLockNotHeld for the expired holder, got %v", err)
+	}
+
+	// Locks survive a restart, and tokens keep increasing
+	db.Close()
+	wal.Close()
+	db, wal = open()
+	defer wal.Close()
+	defer db.Close()
+	if _, err := db.AcquireLock("jobs", time.Minute); err != memdb.ErrLockHeld {
+		t.Errorf("Expected the lock to be held after reopening, got %v", err)
+	}
+	if err := db.ReleaseLock("jobs", next.Token); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := db.AcquireLock("jobs", time.Minute); err != nil || last.Token <= next.Token {
+		t.Errorf("Expected a token larger than %d after reopening, got %v, %v", next.Token, last, err)
+	}
+}
+
+func TestMemdb_LeaseTokenAfterFailover(t *testing.T) {
+	// The previous holder got its token from a node whose clock was an hour ahead, then released the lease
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	previous := uint64(time.Now().Add(time.Hour).UnixNano())
+	value := memdb.EncodeExpiring(time.Now().Add(-time.Minute), binary.BigEndian.AppendUint64(nil, previous))
+	record := memdb.WALRecord{Operation: memdb.OpSet, Key: []byte(memdb.ReservedKeyPrefix + "lock/jobs"), Value: value}
+	if err := wal.WriteEntry(record); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	// Reopening starts the sequence from this clock, lower than the previous token
+	wal, err = memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	first, err := db.AcquireLock("jobs", time.Minute)
+	if err != nil || first.Token <= previous {
+		t.Fatalf("Expected a token larger than %d, got %v, %v", previous, first, err)
+	}
+
+	// Releasing keeps the token, the next one is still larger
+	if err := db.ReleaseLock("jobs", first.Token); err != nil {
+		t.Fatal(err)
+	}
+	if second, err := db.AcquireLock("jobs", time.Minute); err != nil || second.Token <= first.Token {
+		t.Errorf("Expected a token larger than %d after releasing, got %v, %v", first.Token, second, err)
+	}
+}
+
+func TestMemdb_Snapshot(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// A snapshot over a flushed table and the memtable
+	db.Set("a", []byte("1"))
+	db.Set("b", []byte("2"))
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	db.Set("c", []byte("3"))
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+
+	// Writes, flushes and a compaction removing the table the snapshot reads don't change what it sees
+	db.Set("a", []byte("changed"))
+	db.Delete("b")
+	db.Set("d", []byte("4"))
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.SSTableIDs) != 1 {
+		t.Fatalf("Expected the tables to be compacted, got %v", db.SSTableIDs)
+	}
+	if value, err := snap.Get("a"); err != nil || string(value) != "1" {
+		t.Errorf("Expected a=1 in the snapshot, got %q, %v", value, err)
+	}
+	if value, err := snap.Get("b"); err != nil || string(value) != "2" {
+		t.Errorf("Expected b=2 in the snapshot, got %q, %v", value, err)
+	}
+	if _, err := snap.Get("d"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected d to be missing from the snapshot, got %v", err)
+	}
+	kvs, err := snap.Scan(memdb.ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := make([]string, len(kvs))
+	for i, kv := range kvs {
+		pairs[i] = kv.Key + "=" + string(kv.Value)
+	}
+	if got := strings.Join(pairs, " "); got != "a=1 b=2 c=3" {
+		t.Errorf("Expected a=1 b=2 c=3, got %s", got)
+	}
+	if value, err := db.Get("a"); err != nil || string(value) != "changed" {
+		t.Errorf("Expected the database to see the new value, got %q, %v", value, err)
+	}
+
+	// Reads fail once it is released
+	snap.Release()
+	if _, err := snap.Get("a"); err != memdb.ErrSnapshotReleased {
+		t.Errorf("Expected ErrSnapshotReleased, got %v", err)
+	}
+}
+
+func TestMemdb_DeleteRange(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstablesDirectory := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstablesDirectory, memdb.Quota(100, 0))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// Keys in two tables and in the memtable
+	for _, key := range []string{"a", "user/1", "user/2"} {
+		db.Set(key, []byte("old"))
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"user/3", "users", "z"} {
+		db.Set(key, []byte("old"))
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	db.Set("user/4", []byte("old"))
+
+	if err := db.DeletePrefix("user/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteRange("y", "zz"); err != nil {
+		t.Fatal(err)
+	}
+	// A key written after the range deletion is live
+	db.Set("user/2", []byte("new"))
+
+	check := func(when string) {
+		t.Helper()
+		want := []string{"a", "user/2", "users"}
+		if keys, err := db.ListKeys(); err != nil || !reflect.DeepEqual(keys, want) {
+			t.Errorf("%s: expected keys %v, got %v, %v", when, want, keys, err)
+		}
+		kvs, err := db.Scan(memdb.ScanOptions{})
+		if err != nil || len(kvs) != len(want) {
+			t.Fatalf("%s: expected %d keys scanned, got %v, %v", when, len(want), kvs, err)
+		}
+		for i, kv := range kvs {
+			if kv.Key != want[i] {
+				t.Errorf("%s: expected key %q scanned, got %q", when, want[i], kv.Key)
+			}
+		}
+		it, err := db.NewIterator(memdb.IteratorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var iterated []string
+		for ; it.Valid(); it.Next() {
+			iterated = append(iterated, it.Key())
+		}
+		it.Close()
+		if !reflect.DeepEqual(iterated, want) {
+			t.Errorf("%s: expected keys %v iterated, got %v", when, want, iterated)
+		}
+		for _, key := range []string{"user/1", "user/3", "user/4", "z"} {
+			if _, err := db.Get(key); err != memdb.ErrKeyNotFound {
+				t.Errorf("%s: expected %q to be deleted, got %v", when, key, err)
+			}
+		}
+		if value, err := db.Get("user/2"); err != nil || string(value) != "new" {
+			t.Errorf("%s: expected user/2=new, got %q, %v", when, value, err)
+		}
+	}
+	check("In the memtable")
+	if quota := db.QuotaStats(); quota == nil || quota.Keys != 3 {
+		t.Errorf("Expected 3 keys in use, got %+v", quota)
+	}
+
+	// The range deletions are replayed from the WAL
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if wal, err = memdb.OpenWAL(walPath); err != nil {
+		t.Fatalf("Error reopening WAL: %s", err)
+	}
+	defer wal.Close()
+	if db, err = memdb.NewDB(wal, sstablesDirectory); err != nil {
+		t.Fatalf("Error reopening DB: %s", err)
+	}
+	defer db.Close()
+	check("Recovered")
+
+	// then flushed as entries of their own, which compactions keep for the older tables
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	check("Flushed")
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	check("Compacted")
+
+	if err := db.DeleteRange("", "b"); err == nil {
+		t.Error("Expected an error for an empty start")
+	}
+}
+
+// TestMemdb_CompareAndSwap checks that CompareAndSwap only writes when the current value is the expected one, and that
+// concurrent increments don't lose each other's writes
+func TestMemdb_CompareAndSwap(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// A nil expected value creates the key, only if it is missing
+	if err := db.CompareAndSwap("counter", nil, []byte("0")); err != nil {
+		t.Fatalf("Expected the key to be created, got %v", err)
+	}
+	if err := db.CompareAndSwap("counter", nil, []byte("1")); err != memdb.ErrCompareFailed {
+		t.Errorf("Expected ErrCompareFailed for an existing key, got %v", err)
+	}
+	if err := db.CompareAndSwap("counter", []byte("5"), []byte("6")); err != memdb.ErrCompareFailed {
+		t.Errorf("Expected ErrCompareFailed for another value, got %v", err)
+	}
+	if err := db.CompareAndSwap("missing", []byte(""), []byte("1")); err != memdb.ErrCompareFailed {
+		t.Errorf("Expected ErrCompareFailed for a missing key, got %v", err)
+	}
+	if value, _ := db.Get("counter"); string(value) != "0" {
+		t.Fatalf("Expected the failed swaps to leave 0, got %q", value)
+	}
+
+	// The comparison sees the value in the SSTables too
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 25; n++ {
+				for {
+					current, err := db.Get("counter")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					count, _ := strconv.Atoi(string(current))
+					err = db.CompareAndSwap("counter", current, []byte(strconv.Itoa(count+1)))
+					if err == nil {
+						break
+					}
+					if err != memdb.ErrCompareFailed {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := db.Get("counter"); string(value) != "200" {
+		t.Errorf("Expected 200 increments, got %q", value)
+	}
+	if _, err := db.Get("missing"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected the missing key to stay missing, got %v", err)
+	}
+}
+
+// TestMemdb_GetMulti checks that GetMulti reads keys written one after the other at a single point in time
+func TestMemdb_GetMulti(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	if err := db.Set("order/1/total", []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("order/1/paid", []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	values, err := db.GetMulti([]string{"order/1/total", "order/1/missing", "order/1/paid"})
+	if err != nil || len(values) != 2 || string(values["order/1/total"]) != "0" || string(values["order/1/paid"]) != "0" {
+		t.Fatalf("Expected both values from the SSTable, got %q (%v)", values, err)
+	}
+	if _, err := db.GetMulti([]string{"order/1/total", ""}); err == nil {
+		t.Error("Expected an error for an empty key")
+	}
+
+	// The writer sets total then paid, so a consistent read never sees paid ahead of total
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 1; n <= 500; n++ {
+			db.Set("order/1/total", []byte(strconv.Itoa(n)))
+			db.Set("order/1/paid", []byte(strconv.Itoa(n)))
+		}
+	}()
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		values, err := db.GetMulti([]string{"order/1/paid", "order/1/total"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		total, _ := strconv.Atoi(string(values["order/1/total"]))
+		paid, _ := strconv.Atoi(string(values["order/1/paid"]))
+		if paid != total && paid != total-1 {
+			t.Fatalf("Expected paid to trail total by at most one, got total %d, paid %d", total, paid)
+		}
+	}
+}
+
+// TestMemdb_Transactions checks that a transaction commits its writes at once, unless a key it read was written since,
+// and that a torn commit record is recovered as none of its writes
+func TestMemdb_Transactions(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstablesDirectory := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstablesDirectory)
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// Reads see the writes of the transaction, others see them once committed
+	db.Set("account/a", []byte("100"))
+	db.Set("account/b", []byte("0"))
+	tx := db.BeginTx()
+	if value, err := tx.Get("account/a"); err != nil || string(value) != "100" {
+		t.Fatalf("Expected 100, got %q (%v)", value, err)
+	}
+	tx.Set("account/a", []byte("70"))
+	tx.Set("account/b", []byte("30"))
+	tx.Delete("pending/1")
+	if value, _ := tx.Get("account/a"); string(value) != "70" {
+		t.Errorf("Expected the transaction to read its own write, got %q", value)
+	}
+	if value, _ := db.Get("account/a"); string(value) != "100" {
+		t.Errorf("Expected the write to be buffered until the commit, got %q", value)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+	if err := tx.Commit(); err != memdb.ErrTxDone {
+		t.Errorf("Expected ErrTxDone, got %v", err)
+	}
+	values, _ := db.GetMulti([]string{"account/a", "account/b"})
+	if string(values["account/a"]) != "70" || string(values["account/b"]) != "30" {
+		t.Errorf("Expected the committed writes, got %q", values)
+	}
+
+	// Keys over the size limit are refused when buffered, deletions included
+	tx = db.BeginTx()
+	long := strings.Repeat("k", memdb.DefaultMaxKeySize+1)
+	if err := tx.Set(long, []byte("v")); err != memdb.ErrKeyTooLarge {
+		t.Errorf("Expected ErrKeyTooLarge for a write, got %v", err)
+	}
+	if err := tx.Delete(long); err != memdb.ErrKeyTooLarge {
+		t.Errorf("Expected ErrKeyTooLarge for a deletion, got %v", err)
+	}
+	tx.Rollback()
+
+	// A key read, missing keys included, then written by someone else fails the commit
+	tx = db.BeginTx()
+	tx.Get("account/a")
+	if _, err := tx.Get("account/c"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	tx.Set("account/b", []byte("0"))
+	db.Set("account/c", []byte("1"))
+	if err := tx.Commit(); err != memdb.ErrTxConflict {
+		t.Errorf("Expected ErrTxConflict, got %v", err)
+	}
+	if value, _ := db.Get("account/b"); string(value) != "30" {
+		t.Errorf("Expected the conflicting transaction to write nothing, got %q", value)
+	}
+
+	// Writes to keys the transaction didn't read aren't conflicts
+	tx = db.BeginTx()
+	tx.Get("account/a")
+	tx.Set("account/a", []byte("60"))
+	db.Set("account/b", []byte("40"))
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Expected the commit to succeed, got %v", err)
+	}
+
+	// Concurrent read-modify-writes retried on conflicts don't lose updates
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				for {
+					tx := db.BeginTx()
+					a, _ := tx.Get("account/a")
+					b, _ := tx.Get("account/b")
+					x, _ := strconv.Atoi(string(a))
+					y, _ := strconv.Atoi(string(b))
+					tx.Set("account/a", []byte(strconv.Itoa(x-1)))
+					tx.Set("account/b", []byte(strconv.Itoa(y+1)))
+					err := tx.Commit()
+					if err == nil {
+						break
+					}
+					if err != memdb.ErrTxConflict {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	values, _ = db.GetMulti([]string{"account/a", "account/b"})
+	if string(values["account/a"]) != "-20" || string(values["account/b"]) != "120" {
+		t.Errorf("Expected -20 and 120, got %q", values)
+	}
+
+	// The last commit is one WAL record: cut short, none of its writes are recovered
+	size, err := wal.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = db.BeginTx()
+	tx.Set("account/a", []byte("0"))
+	tx.Set("account/b", []byte("100"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	wal.Close()
+	if err := os.Truncate(walPath, size+memdb.WALRecordHeaderSize+20); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstablesDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	values, _ = db.GetMulti([]string{"account/a", "account/b"})
+	if string(values["account/a"]) != "-20" || string(values["account/b"]) != "120" {
+		t.Errorf("Expected the torn commit to be dropped, got %q", values)
+	}
+}
+
+// TestMemdb_ConcurrentFlushAndCompaction runs flushes and compactions while keys are written and read, for go test
+// -race: no key may be lost, before or after reopening the database
+func TestMemdb_ConcurrentFlushAndCompaction(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(16),
+		memdb.Compaction(memdb.CompactionOptions{MinFiles: 2, MaxFiles: 4}))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	const writers, keys = 4, 200
+	var wg, background sync.WaitGroup
+	done := make(chan struct{})
+	for _, maintain := range []func() error{db.FlushToSSTable, db.CompactSSTables} {
+		background.Add(1)
+		go func(maintain func() error) {
+			defer background.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := maintain(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(maintain)
+	}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("w%d-%03d", w, i)
+				if err := db.Set(key, []byte(key)); err != nil {
+					t.Error(err)
+					return
+				}
+				// Every key written so far stays readable, wherever it is
+				read := fmt.Sprintf("w%d-%03d", w, i/2)
+				if value, err := db.Get(read); err != nil || string(value) != read {
+					t.Errorf("Expected %s to be readable, got %q (%v)", read, value, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	background.Wait()
+
+	check := func(db *memdb.DB) {
+		for w := 0; w < writers; w++ {
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("w%d-%03d", w, i)
+				if value, err := db.Get(key); err != nil || string(value) != key {
+					t.Errorf("Expected %s to be kept, got %q (%v)", key, value, err)
+				}
+			}
+		}
+	}
+	check(db)
+	db.Close()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error reopening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error recovering DB: %s", err)
+	}
+	defer db.Close()
+	check(db)
+}
@@ -2,8 +2,12 @@ package tests
 
 import (
 	"StorageEngine/memdb"
+	"StorageEngine/sstable"
+	"bytes"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestRecovery(t *testing.T) {
@@ -40,6 +44,7 @@ func TestRecovery(t *testing.T) {
 	}
 
 	// Simulate a crash or abrupt shutdown by closing the WAL without flushing
+	db.Close()
 	if err := wal.Close(); err != nil {
 		t.Fatal(err)
 	}
@@ -71,4 +76,577 @@ func TestRecovery(t *testing.T) {
 	if string(value) != string(expectedValue) {
 		t.Errorf("Expected value %s, got %s", expectedValue, value)
 	}
-}
\ No newline at end of file
+}
+
+func TestRecoveryDoesNotRewriteWAL(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstableDir := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	if err := db.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	size, err := wal.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	wal.Close()
+
+	// Restarting replays the records without appending them again
+	for i := 0; i < 3; i++ {
+		wal, err = memdb.OpenWAL(walPath)
+		if err != nil {
+			t.Fatalf("Error opening WAL: %s", err)
+		}
+		db, err = memdb.NewDB(wal, sstableDir, memdb.Threshold(3))
+		if err != nil {
+			t.Fatalf("Error recovering DB: %s", err)
+		}
+		if recovered, _ := wal.Size(); recovered != size {
+			t.Errorf("Restart %d: expected a WAL of %d bytes, got %d", i, size, recovered)
+		}
+		if _, err := db.Get("a"); err != memdb.ErrKeyNotFound {
+			t.Errorf("Restart %d: expected a to stay deleted, got %v", i, err)
+		}
+		if value, err := db.Get("b"); err != nil || string(value) != "2" {
+			t.Errorf("Restart %d: expected b=2, got %s (%v)", i, value, err)
+		}
+		if i < 2 {
+			db.Close()
+			wal.Close()
+		}
+	}
+
+	// The next flush moves the watermark past the replayed records
+	if err := db.Set("c", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := db.Stats(); err != nil || stats.WALLag != 0 || len(db.SSTableIDs) != 1 {
+		t.Errorf("Expected the replayed records to be flushed, got WAL lag %d and %d SSTables (%v)", stats.WALLag, len(db.SSTableIDs), err)
+	}
+	db.Close()
+	wal.Close()
+}
+
+func TestFollower(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstableDir := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	if err := db.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refresh interval is long enough for the test to drive every refresh
+	followerWAL, err := memdb.OpenWALReadOnly(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL read-only: %s", err)
+	}
+	defer followerWAL.Close()
+	follower, err := memdb.NewDB(followerWAL, sstableDir, memdb.Follower(time.Hour))
+	if err != nil {
+		t.Fatalf("Error creating follower: %s", err)
+	}
+	defer follower.Close()
+	if value, err := follower.Get("a"); err != nil || string(value) != "1" {
+		t.Errorf("Expected the follower to read 1, got %q, %v", value, err)
+	}
+
+	// Writes reach the follower once it refreshes, whether they are flushed or not
+	for _, key := range []string{"b", "c", "d"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := follower.Get("d"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected the follower to miss d before refreshing, got %v", err)
+	}
+	if err := follower.Refresh(); err != nil {
+		t.Fatalf("Error refreshing: %s", err)
+	}
+	for _, key := range []string{"b", "c", "d"} {
+		if value, err := follower.Get(key); err != nil || string(value) != key {
+			t.Errorf("Expected the follower to read %s, got %q, %v", key, value, err)
+		}
+	}
+	if _, err := follower.Get("a"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected a to be deleted on the follower, got %v", err)
+	}
+	if len(follower.SSTableIDs) != 1 {
+		t.Errorf("Expected the follower to load the flushed SSTable, got %v", follower.SSTableIDs)
+	}
+
+	if err := follower.Set("e", []byte("5")); err != memdb.ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly writing to the follower, got %v", err)
+	}
+	if err := follower.Delete("b"); err != memdb.ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly deleting from the follower, got %v", err)
+	}
+	if value, err := db.Get("b"); err != nil || string(value) != "b" {
+		t.Errorf("Expected the writer to still read b, got %q, %v", value, err)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstableDir := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte(key+"-value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	wal.Close()
+
+	// Reopened with a lower threshold, the replayed memtable is over it but isn't flushed on open
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstableDir, memdb.Threshold(1), memdb.Warmup(2))
+	if err != nil {
+		t.Fatalf("Error opening DB: %s", err)
+	}
+	defer db.Close()
+	if len(db.SSTableIDs) != 1 {
+		t.Errorf("Expected no flush when opening, got SSTables %v", db.SSTableIDs)
+	}
+
+	select {
+	case <-db.WarmupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Warmup didn't finish")
+	}
+	events := db.Events()
+	if len(events) != 1 || events[0].Type != memdb.EventWarmup || events[0].Entries != 2 || events[0].Error != "" {
+		t.Errorf("Expected a warmup event over 2 entries, got %+v", events)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if value, err := db.Get(key); err != nil || string(value) != key+"-value" {
+			t.Errorf("Expected %s=%s-value, got %s (%v)", key, key, value, err)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstableDir := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		if i == 2 {
+			// SSTable names have a one second resolution
+			time.Sleep(1100 * time.Millisecond)
+		}
+		if err := db.Set(key, []byte(key+"-value")); err != nil {
+			t.Fatalf("Error setting value: %s", err)
+		}
+	}
+	tables := db.SSTableIDs
+	db.Close()
+	wal.Close()
+	if len(tables) != 2 {
+		t.Fatalf("Expected 2 SSTables, got %v", tables)
+	}
+
+	// Cut the second SSTable in the middle of its last entry
+	data, err := os.ReadFile(tables[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(tables[1], int64(bytes.LastIndex(data, []byte("d-value"))+3)); err != nil {
+		t.Fatal(err)
+	}
+	// A record written to the WAL without its metadata being updated, followed by a torn record
+	walFile, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walFile.Write([]byte{0, 0, 0, 0, 1, 0, 0, 0, 7, 'f', 'f', '-', 'v', 'a', 'l', 'u', 'e'})
+	walFile.Write([]byte{0, 0, 0})
+	walFile.Close()
+	// A leftover of an interrupted rewrite
+	if err := os.WriteFile(tables[0]+".tmp", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := memdb.Repair(walPath, sstableDir)
+	if err != nil {
+		t.Fatalf("Error repairing: %s", err)
+	}
+	if len(report.Tables) != 2 || report.Tables[0].Status != memdb.RepairOK || report.Tables[1].Status != memdb.RepairSalvaged || report.Tables[1].Entries != 1 {
+		t.Errorf("Unexpected SSTable repairs: %+v", report.Tables)
+	}
+	if _, err := os.Stat(report.Tables[1].Quarantined); err != nil {
+		t.Errorf("Expected the corrupted original to be kept: %s", err)
+	}
+	if report.WAL.Recovered != 1 || report.WAL.TruncatedBytes != 3 {
+		t.Errorf("Unexpected WAL repair: %+v", report.WAL)
+	}
+	if len(report.RemovedFiles) != 1 || len(report.Order) != 2 || report.Order[1] != tables[1] {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	// The database opens with everything that could be salvaged
+	wal, err = memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err = memdb.NewDB(wal, sstableDir)
+	if err != nil {
+		t.Fatalf("Error opening repaired DB: %s", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "b", "c", "e", "f"} {
+		if value, err := db.Get(key); err != nil || string(value) != key+"-value" {
+			t.Errorf("Expected %s=%s-value, got %s (%v)", key, key, value, err)
+		}
+	}
+	if _, err := db.Get("d"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected d to be lost, got %v", err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstableDir := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+	manifestPath := sstableDir + "/" + memdb.ManifestFileName
+
+	open := func() (*memdb.WAL, *memdb.DB, error) {
+		wal, err := memdb.OpenWAL(walPath)
+		if err != nil {
+			t.Fatalf("Error opening WAL: %s", err)
+		}
+		db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2))
+		if err != nil {
+			wal.Close()
+		}
+		return wal, db, err
+	}
+	check := func(step string) {
+		wal, db, err := open()
+		if err != nil {
+			t.Fatalf("%s: Error opening DB: %s", step, err)
+		}
+		defer wal.Close()
+		defer db.Close()
+		if value, err := db.Get("a"); err != nil || string(value) != "a-2" {
+			t.Errorf("%s: Expected the newest value of a, got %q, %v", step, value, err)
+		}
+	}
+
+	wal, db, err := open()
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	for _, kv := range [][2]string{{"a", "a-1"}, {"b", "b"}, {"a", "a-2"}, {"c", "c"}} {
+		if err := db.Set(kv[0], []byte(kv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.SSTableIDs) != 2 {
+		t.Fatalf("Expected 2 SSTables, got %v", db.SSTableIDs)
+	}
+	db.Close()
+	wal.Close()
+
+	// An edit torn by a crash is cut off
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifestPath, append(append([]byte(nil), data...), 0, 0, 0, 40, 1, 2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check("Torn edit")
+	if fileInfo, err := os.Stat(manifestPath); err != nil || fileInfo.Size() != int64(len(data)) {
+		t.Errorf("Expected the torn edit to be cut off, got %v, %v", fileInfo, err)
+	}
+
+	// A table left by an interrupted flush isn't read, even though it is the newest
+	orphan := sstableDir + "/000009.sst"
+	table := sstable.NewSSTable([]sstable.KeyValuePair{{Operation: sstable.OpSet, Key: []byte("a"), Value: []byte("orphan")}})
+	if err := sstable.WriteSSTable(orphan, table); err != nil {
+		t.Fatal(err)
+	}
+	check("Orphan table")
+	report, err := memdb.Doctor(walPath, sstableDir)
+	if err != nil || len(report.Findings) == 0 || report.Findings[0].Path != orphan || report.Worst() != memdb.SeverityWarning {
+		t.Errorf("Expected a warning about the orphan table, got %+v, %v", report, err)
+	}
+	repair, err := memdb.Repair(walPath, sstableDir)
+	if err != nil || len(repair.RemovedFiles) != 1 || repair.RemovedFiles[0] != orphan || len(repair.Order) != 2 {
+		t.Errorf("Expected the orphan table to be removed, got %+v, %v", repair, err)
+	}
+
+	// A corrupted edit before the last one fails the opening, until the MANIFEST is repaired
+	data, err = os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(manifestPath, append(data, data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := open(); err != memdb.ErrCorruptedManifest {
+		t.Fatalf("Expected ErrCorruptedManifest, got %v", err)
+	}
+	if _, err := memdb.Repair(walPath, sstableDir); err != nil {
+		t.Fatalf("Error repairing: %s", err)
+	}
+	check("Repaired")
+
+	// A directory written before the MANIFEST is given one
+	if err := os.Remove(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+	check("No MANIFEST")
+	if _, err := os.Stat(manifestPath); err != nil {
+		t.Errorf("Expected a MANIFEST to be written, got %v", err)
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstableDir := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	for i, key := range []string{"a", "c", "b", "d", "e"} {
+		if i == 2 {
+			// SSTable names have a one second resolution
+			time.Sleep(1100 * time.Millisecond)
+		}
+		if err := db.Set(key, []byte(key+"-value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tables := db.SSTableIDs
+	db.Close()
+	wal.Close()
+
+	report, err := memdb.Doctor(walPath, sstableDir)
+	if err != nil {
+		t.Fatalf("Error checking: %s", err)
+	}
+	if report.Tables != 2 || report.WALRecords != 5 || report.Worst() != memdb.SeverityInfo {
+		t.Errorf("Expected only the overlap to be reported, got %+v", report)
+	}
+
+	// Bytes appended to a table, equal modification times of tables named by time and a leftover file, in a
+	// directory written before the MANIFEST
+	if err := os.Remove(sstableDir + "/" + memdb.ManifestFileName); err != nil {
+		t.Fatal(err)
+	}
+	for i, table := range tables {
+		tables[i] = fmt.Sprintf("%s/sstable_file_%d.sst", sstableDir, i)
+		if err := os.Rename(table, tables[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, err := os.OpenFile(tables[0], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("garbage"))
+	file.Close()
+	now := time.Now()
+	for _, table := range tables {
+		if err := os.Chtimes(table, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(tables[1]+".tmp", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = memdb.Doctor(walPath, sstableDir)
+	if err != nil {
+		t.Fatalf("Error checking: %s", err)
+	}
+	severities := make(map[string]int)
+	for _, finding := range report.Findings {
+		severities[finding.Severity]++
+		if finding.Severity != memdb.SeverityInfo && finding.Action == "" {
+			t.Errorf("Expected an action for %+v", finding)
+		}
+	}
+	if report.Worst() != memdb.SeverityError || severities[memdb.SeverityError] != 1 || severities[memdb.SeverityWarning] != 2 {
+		t.Errorf("Unexpected findings: %+v", report.Findings)
+	}
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	tempDir := t.TempDir()
+	sstableDir := tempDir + "/testSSTableFiles"
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if err := db.Set(key, []byte(key+"-value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := db.VerifyIntegrity()
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	if report.Tables != 2 || report.WALRecords != 5 || len(report.Findings) != 0 {
+		t.Errorf("Expected 2 consistent tables and 5 WAL records, got %+v", report)
+	}
+
+	// A corrupted table, a table the database doesn't read and a leftover file
+	data, err := os.ReadFile(db.SSTableIDs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(db.SSTableIDs[1], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sstableDir+"/stray.sst", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sstableDir+"/stray.sst.tmp", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = db.VerifyIntegrity()
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	severities := make(map[string]int)
+	for _, finding := range report.Findings {
+		severities[finding.Severity]++
+		if finding.Action == "" {
+			t.Errorf("Expected an action for %+v", finding)
+		}
+	}
+	if report.Worst() != memdb.SeverityError || severities[memdb.SeverityError] != 1 || severities[memdb.SeverityWarning] != 2 {
+		t.Errorf("Unexpected findings: %+v", report.Findings)
+	}
+}
+
+// TestWALFirstWrites checks that writes reach the WAL before the memtable: an acknowledged write survives a crash
+// right after it, and a write the WAL refuses leaves the database as it was
+func TestWALFirstWrites(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath := tempDir + "/test_wal.log"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("key1", []byte("value1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("key2", []byte("value2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("key2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash leaves the WAL as it is once the writes returned, without closing anything
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tempDir+"/crash_wal.log", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	crashWAL, err := memdb.OpenWAL(tempDir + "/crash_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crashWAL.Close()
+	recovered, err := memdb.NewDB(crashWAL, tempDir+"/crashSSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if value, err := recovered.Get("key1"); err != nil || string(value) != "value1" {
+		t.Errorf("Expected value1 after the crash, got %q, %v", value, err)
+	}
+	if _, err := recovered.Get("key2"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected key2 to stay deleted after the crash, got %v", err)
+	}
+
+	// Writes the WAL refuses don't reach the memtable
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("key3", []byte("value3")); err == nil {
+		t.Fatal("Expected the write to fail with the WAL closed")
+	}
+	if _, err := db.Get("key3"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected the failed write to be invisible, got %v", err)
+	}
+	if err := db.Delete("key1"); err == nil {
+		t.Fatal("Expected the deletion to fail with the WAL closed")
+	}
+	if value, err := db.Get("key1"); err != nil || string(value) != "value1" {
+		t.Errorf("Expected the failed deletion to leave value1, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,452 @@
+package tests
+
+import (
+	"StorageEngine/client"
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"StorageEngine/replication"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replicationNode is a database replicated with a peer over HTTP
+type replicationNode struct {
+	db         *memdb.DB
+	replicator *replication.Replicator
+}
+
+// newReplicatedPair returns two databases replicating with each other, sharing resolver
+func newReplicatedPair(t *testing.T, resolver replication.Resolver) (*replicationNode, *replicationNode) {
+	muxes := []*http.ServeMux{http.NewServeMux(), http.NewServeMux()}
+	servers := []*httptest.Server{httptest.NewServer(muxes[0]), httptest.NewServer(muxes[1])}
+	nodes := make([]*replicationNode, 2)
+	for i, id := range []string{"eu", "us"} {
+		dir := t.TempDir()
+		wal, err := memdb.OpenWAL(dir + "/test_wal.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := memdb.NewDB(wal, dir+"/testSSTableFiles")
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicator, err := replication.New(db, replication.Config{
+			NodeID:    id,
+			WALPath:   dir + "/test_wal.log",
+			StateDir:  dir + "/replication",
+			Transport: &replication.HTTPTransport{URL: servers[1-i].URL, Token: "secret"},
+			Resolver:  resolver,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		replication.RegisterHandler(muxes[i], replicator, "secret")
+		t.Cleanup(func() {
+			replicator.Close()
+			db.Close()
+			wal.Close()
+		})
+		nodes[i] = &replicationNode{db: db, replicator: replicator}
+	}
+	t.Cleanup(func() {
+		servers[0].Close()
+		servers[1].Close()
+	})
+	return nodes[0], nodes[1]
+}
+
+func (n *replicationNode) sync(t *testing.T) int {
+	t.Helper()
+	shipped, err := n.replicator.Sync()
+	if err != nil {
+		t.Fatalf("Error syncing: %s", err)
+	}
+	return shipped
+}
+
+func expectValue(t *testing.T, db *memdb.DB, key string, expected string) {
+	t.Helper()
+	value, err := db.Get(key)
+	if expected == "" {
+		if err != memdb.ErrKeyNotFound {
+			t.Errorf("Expected %q to be deleted, got %q, %v", key, value, err)
+		}
+		return
+	}
+	if err != nil || string(value) != expected {
+		t.Errorf("Expected %q for %q, got %q, %v", expected, key, value, err)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	eu, us := newReplicatedPair(t, nil)
+
+	// Writes on either side reach the other, without being shipped back
+	eu.db.Set("a", []byte("1"))
+	eu.db.Set("b", []byte("2"))
+	if shipped := eu.sync(t); shipped != 2 {
+		t.Errorf("Expected 2 changes shipped, got %d", shipped)
+	}
+	expectValue(t, us.db, "a", "1")
+	expectValue(t, us.db, "b", "2")
+	if shipped := us.sync(t); shipped != 0 {
+		t.Errorf("Expected the changes of the peer not to be shipped back, got %d", shipped)
+	}
+	us.db.Delete("a")
+	us.sync(t)
+	expectValue(t, eu.db, "a", "")
+
+	// Conflicting writes converge to the last one stamped
+	eu.db.Set("c", []byte("eu"))
+	us.db.Set("c", []byte("us"))
+	eu.sync(t)
+	us.sync(t)
+	eu.sync(t)
+	expectValue(t, eu.db, "c", "us")
+	expectValue(t, us.db, "c", "us")
+	for _, node := range []*replicationNode{eu, us} {
+		if pending, err := node.replicator.Pending(); pending != 0 || err != nil {
+			t.Errorf("Expected no pending change, got %d, %v", pending, err)
+		}
+	}
+
+	// A change delivered twice is only applied once
+	changes := []replication.Change{{Key: "d", Value: []byte("4"), Version: replication.Version{Timestamp: 1, Origin: "us"}}}
+	for _, expected := range []int{1, 0} {
+		if applied, err := eu.replicator.Apply(changes); applied != expected || err != nil {
+			t.Errorf("Expected %d change applied, got %d, %v", expected, applied, err)
+		}
+	}
+	// An older change loses against the local version
+	eu.db.Set("d", []byte("local"))
+	eu.sync(t)
+	changes[0].Value = []byte("old")
+	changes[0].Version.Timestamp = 2
+	if applied, err := eu.replicator.Apply(changes); applied != 0 || err != nil {
+		t.Errorf("Expected the older change to be ignored, got %d, %v", applied, err)
+	}
+	expectValue(t, eu.db, "d", "local")
+
+	// The apply endpoint requires the token
+	req := httptest.NewRequest(http.MethodPost, replication.ApplyPath, strings.NewReader("[]"))
+	rec := httptest.NewRecorder()
+	replication.Handler(eu.replicator, "secret").ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without token, got %d", rec.Code)
+	}
+}
+
+func TestReplicationResolver(t *testing.T) {
+	// Keeps the greatest value, whatever the order of the writes
+	greatest := func(local replication.Change, remote replication.Change) replication.Change {
+		if bytes.Compare(remote.Value, local.Value) > 0 {
+			return remote
+		}
+		return local
+	}
+	eu, us := newReplicatedPair(t, greatest)
+
+	eu.db.Set("k", []byte("b"))
+	eu.sync(t)
+	us.db.Set("k", []byte("a"))
+	eu.db.Set("k", []byte("c"))
+	us.sync(t)
+	eu.sync(t)
+	us.sync(t)
+	expectValue(t, eu.db, "k", "c")
+	expectValue(t, us.db, "k", "c")
+
+	// The winner of a conflict is written on both sides
+	us.db.Set("k", []byte("a"))
+	eu.db.Set("k", []byte("b"))
+	us.sync(t)
+	eu.sync(t)
+	us.sync(t)
+	expectValue(t, eu.db, "k", "b")
+	expectValue(t, us.db, "k", "b")
+}
+
+func TestReplicaBootstrap(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := memdb.OpenWAL(dir + "/primary_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	primary, err := memdb.NewDB(wal, dir+"/primary/SSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	mux := http.NewServeMux()
+	replication.RegisterPrimaryHandlers(mux, primary, dir+"/primary_wal.log", "secret", nil)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	primary.Set("a", []byte("1"))
+	primary.Set("b", []byte("2"))
+	primary.Set("c", []byte("3"))
+
+	cfg := replication.ReplicaConfig{Primary: server.URL, Token: "wrong", CursorPath: dir + "/replica.cursor"}
+	if _, err := replication.Bootstrap(cfg, dir+"/replica/wal.log", dir+"/replica/SSTableFiles"); err == nil {
+		t.Errorf("Expected an error with the wrong token")
+	}
+	cfg.Token = "secret"
+	manifest, err := replication.Bootstrap(cfg, dir+"/replica/wal.log", dir+"/replica/SSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The snapshot flushes the memtable
+	if len(manifest.SSTables) != 1 || manifest.WALRecords != 0 || manifest.WALOffset <= memdb.WALMetadataSize {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+
+	replicaWAL, err := memdb.OpenWAL(dir + "/replica/wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaWAL.Close()
+	db, err := memdb.NewDB(replicaWAL, dir+"/replica/SSTableFiles", memdb.Replica())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, value := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		expectValue(t, db, key, value)
+	}
+	if err := db.Set("d", []byte("4")); err != memdb.ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly from a write to the replica, got %v", err)
+	}
+
+	// The records written after the snapshot follow, from the cursor saved by Bootstrap
+	primary.Set("d", []byte("4"))
+	primary.Delete("a")
+	primary.Set("b", []byte("22"))
+	replica, err := replication.NewReplica(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := replica.Poll(); applied != 3 || err != nil {
+		t.Fatalf("Expected 3 records applied, got %d, %v", applied, err)
+	}
+	for key, value := range map[string]string{"a": "", "b": "22", "c": "3", "d": "4"} {
+		expectValue(t, db, key, value)
+	}
+	if applied, err := replica.Poll(); applied != 0 || err != nil {
+		t.Errorf("Expected nothing new, got %d, %v", applied, err)
+	}
+
+	// A replica whose position is gone from the WAL of the primary must be bootstrapped again
+	if _, err := primary.Purge("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.Poll(); err != replication.ErrWALReset {
+		t.Errorf("Expected ErrWALReset after the WAL of the primary was reset, got %v", err)
+	}
+	if _, err := replication.NewReplica(db, replication.ReplicaConfig{CursorPath: dir + "/missing.cursor"}); err != replication.ErrNotBootstrapped {
+		t.Errorf("Expected ErrNotBootstrapped without cursor, got %v", err)
+	}
+}
+
+// flakyLeaseStore fails every call while down is set
+type flakyLeaseStore struct {
+	replication.LeaseStore
+	down bool
+}
+
+func (s *flakyLeaseStore) CompareAndSwap(current replication.Lease, next replication.Lease) (bool, error) {
+	if s.down {
+		return false, errors.New("Lease store unreachable")
+	}
+	return s.LeaseStore.CompareAndSwap(current, next)
+}
+
+func TestFailover(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := memdb.OpenWAL(dir + "/test_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, dir+"/testSSTableFiles", memdb.Replica())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := &flakyLeaseStore{LeaseStore: &replication.FileLeaseStore{Path: dir + "/lease.json"}}
+	const ttl = 200 * time.Millisecond
+	newNode := func(id string, standby bool, onPromote func(replication.Lease) error) *replication.Failover {
+		f, err := replication.NewFailover(replication.FailoverConfig{
+			NodeID:    id,
+			Address:   "http://" + id,
+			Store:     store,
+			TTL:       ttl,
+			Standby:   standby,
+			OnPromote: onPromote,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	primary := newNode("primary", false, nil)
+	// The replica is the one taking writes to db once promoted
+	replica := newNode("replica", true, func(replication.Lease) error {
+		db.SetReplica(false)
+		return nil
+	})
+	step := func(f *replication.Failover, expected string) {
+		t.Helper()
+		f.Step()
+		if role := f.Role(); role != expected {
+			t.Fatalf("Expected role %s, got %s", expected, role)
+		}
+	}
+
+	// The replica waits for the primary to lead first
+	step(replica, replication.RoleCandidate)
+	step(primary, replication.RoleLeader)
+	step(replica, replication.RoleCandidate)
+	if lease := replica.Leader(); lease.Holder != "primary" || lease.Epoch != 1 {
+		t.Errorf("Unexpected lease: %+v", lease)
+	}
+
+	// Reads are served by the replica, writes redirected to the leader
+	handler := replica.Redirect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, test := range []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodGet, "/get?key=a", http.StatusOK},
+		{http.MethodPost, "/set", http.StatusTemporaryRedirect},
+		{http.MethodGet, "/del?key=a", http.StatusTemporaryRedirect},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(test.method, test.target, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.target, test.status, rec.Code)
+		}
+		if test.status == http.StatusTemporaryRedirect && rec.Header().Get("Location") != "http://primary"+test.target {
+			t.Errorf("Unexpected redirection to %q", rec.Header().Get("Location"))
+		}
+	}
+
+	// A leader cut from the lease store fences itself before the lease expires
+	store.down = true
+	step(primary, replication.RoleLeader)
+	time.Sleep(ttl / 2)
+	step(primary, replication.RoleFenced)
+	store.down = false
+	step(replica, replication.RoleCandidate)
+
+	// Then the replica takes over and is promoted
+	time.Sleep(ttl / 2)
+	if err := db.Set("a", []byte("1")); err != memdb.ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly before the promotion, got %v", err)
+	}
+	step(replica, replication.RoleLeader)
+	if lease := replica.Leader(); lease.Holder != "replica" || lease.Epoch != 2 {
+		t.Errorf("Unexpected lease: %+v", lease)
+	}
+	if err := db.Set("a", []byte("1")); err != nil {
+		t.Errorf("Expected the promoted database to take writes, got %v", err)
+	}
+
+	// The old primary stays fenced and redirects everything to the new leader
+	step(primary, replication.RoleFenced)
+	rec := httptest.NewRecorder()
+	primary.Redirect(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/get?key=a", nil))
+	if rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("Location") != "http://replica/get?key=a" {
+		t.Errorf("Expected a redirection to the new leader, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	// So does a restarted one
+	step(newNode("primary", false, nil), replication.RoleFenced)
+}
+
+func TestConsistency(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := memdb.OpenWAL(dir + "/primary_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	primary, err := memdb.NewDB(wal, dir+"/primary/SSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	acks := replication.NewAckTracker()
+	mux := handlers.NewMux(primary, wal)
+	replication.RegisterPrimaryHandlers(mux, primary, dir+"/primary_wal.log", "secret", acks)
+	consistency := replication.NewConsistency(replication.ConsistencyConfig{Acks: acks, Position: wal.Offset, SyncTimeout: 200 * time.Millisecond})
+	primaryServer := httptest.NewServer(consistency.Handler(mux))
+	defer primaryServer.Close()
+
+	cfg := replication.ReplicaConfig{Primary: primaryServer.URL, Token: "secret", NodeID: "replica", CursorPath: dir + "/replica.cursor", Interval: 10 * time.Millisecond}
+	if _, err := replication.Bootstrap(cfg, dir+"/replica/wal.log", dir+"/replica/SSTableFiles"); err != nil {
+		t.Fatal(err)
+	}
+	replicaWAL, err := memdb.OpenWAL(dir + "/replica/wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaWAL.Close()
+	db, err := memdb.NewDB(replicaWAL, dir+"/replica/SSTableFiles", memdb.Replica())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	replica, err := replication.NewReplica(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaConsistency := replication.NewConsistency(replication.ConsistencyConfig{Position: replicaWAL.Offset, Replica: replica})
+	replicaServer := httptest.NewServer(replicaConsistency.Handler(handlers.NewMux(db, replicaWAL)))
+	defer replicaServer.Close()
+
+	ctx := context.Background()
+	toPrimary := client.New(primaryServer.URL, client.Retries(0, 0), client.Consistency("", client.WriteSync))
+	toReplica := client.New(replicaServer.URL, client.Retries(0, 0))
+
+	// A sync write waits for the replica, which isn't polling yet
+	if err := toPrimary.Set(ctx, "a", []byte("1")); err != client.ErrNotReplicated {
+		t.Errorf("Expected ErrNotReplicated without replica, got %v", err)
+	}
+	if value, err := primary.Get("a"); err != nil || string(value) != "1" {
+		t.Errorf("Expected the write to be kept by the primary, got %q, %v", value, err)
+	}
+	replica.Start()
+	if err := toPrimary.Set(ctx, "b", []byte("2")); err != nil {
+		t.Errorf("Expected the sync write to be acknowledged, got %v", err)
+	}
+	expectValue(t, db, "b", "2")
+	replica.Close()
+
+	// Stale reads miss the writes the replica didn't apply yet, quorum and leader reads don't
+	if err := toPrimary.Set(client.WithConsistency(ctx, client.WriteAsync), "c", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toReplica.Get(ctx, "c"); err != client.ErrNotFound {
+		t.Errorf("Expected a stale read to miss the write, got %v", err)
+	}
+	if value, err := toReplica.Get(client.WithConsistency(ctx, client.ReadLeader), "c"); err != nil || string(value) != "3" {
+		t.Errorf("Expected the leader to answer, got %q, %v", value, err)
+	}
+	expectValue(t, db, "c", "")
+	if value, err := toReplica.Get(client.WithConsistency(ctx, client.ReadQuorum), "c"); err != nil || string(value) != "3" {
+		t.Errorf("Expected the replica to catch up, got %q, %v", value, err)
+	}
+	expectValue(t, db, "c", "3")
+
+	var statusErr *client.StatusError
+	if _, err := toReplica.Get(client.WithConsistency(ctx, client.WriteSync), "c"); !errors.As(err, &statusErr) || statusErr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a write level on a read, got %v", err)
+	}
+}
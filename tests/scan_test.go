@@ -0,0 +1,812 @@
+package tests
+
+import (
+	"StorageEngine/handlers"
+	"StorageEngine/memdb"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanKeys returns the keys of scan results
+func scanKeys(kvs []memdb.KeyValue) []string {
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestScan(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// The first 3 users are flushed, the others stay in the memtable
+	users := []struct{ key, value string }{
+		{"user:1", `{"name":"imane","city":"azilal","tags":["admin"]}`},
+		{"user:2", `{"name":"ilham","city":"rabat","tags":[]}`},
+		{"user:3", `{"name":"yassine","city":"azilal","tags":["dev","admin"]}`},
+		{"user:4", `{"name":"salma","city":"fes"}`},
+		{"video:1", `not json at all`},
+	}
+	for _, user := range users {
+		if err := db.Set(user.key, []byte(user.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("user:2"); err != nil {
+		t.Fatal(err)
+	}
+
+	cityFilter, err := memdb.JSONFieldEquals("city", []byte(`"azilal"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminFilter, err := memdb.JSONFieldContains("tags", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameFilter, err := memdb.JSONFieldContains("name", "ma")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts     memdb.ScanOptions
+		expected []string
+	}{
+		{memdb.ScanOptions{}, []string{"user:1", "user:3", "user:4", "video:1"}},
+		{memdb.ScanOptions{Prefix: "user:"}, []string{"user:1", "user:3", "user:4"}},
+		{memdb.ScanOptions{Start: "user:3", End: "video:1"}, []string{"user:3", "user:4"}},
+		{memdb.ScanOptions{Limit: 2}, []string{"user:1", "user:3"}},
+		{memdb.ScanOptions{Filter: cityFilter}, []string{"user:1", "user:3"}},
+		{memdb.ScanOptions{Filter: memdb.AllOf(cityFilter, adminFilter), Limit: 1}, []string{"user:1"}},
+		{memdb.ScanOptions{Filter: nameFilter}, []string{"user:1", "user:4"}},
+		{memdb.ScanOptions{Filter: memdb.ValueSizeBetween(0, 20)}, []string{"video:1"}},
+	}
+	for _, test := range tests {
+		kvs, err := db.Scan(test.opts)
+		if err != nil {
+			t.Fatalf("Error scanning: %s", err)
+		}
+		if keys := scanKeys(kvs); !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("Scan(%+v): expected %v, got %v", test.opts, test.expected, keys)
+		}
+	}
+
+	// Same filters through the HTTP API
+	req, err := http.NewRequest("GET", "/scan?prefix=user:&field=city&eq=azilal&limit=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handlers.ScanHandler(db).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var results []handlers.ScanResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Key != "user:1" || results[0].Value != users[0].value || results[1].Key != "user:3" {
+		t.Errorf("Unexpected scan results: %+v", results)
+	}
+}
+
+// TestScanPages checks that paginated scans resume after the last key of a page while writes continue
+func TestScanPages(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5", "other"} {
+		if err := db.Set(key, []byte("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := memdb.ScanOptions{Prefix: "k", Limit: 2}
+	page, err := db.ScanPage(opts, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := scanKeys(page.KVs); !reflect.DeepEqual(keys, []string{"k1", "k2"}) || page.Cursor == "" || page.Changed {
+		t.Fatalf("Expected the first page k1, k2 with a cursor, got %v, %+v", keys, page)
+	}
+	if string(page.KVs[1].Value) != "vk2" {
+		t.Errorf("Expected the values in the page, got %q", page.KVs[1].Value)
+	}
+
+	// Writes between pages show in the next ones, without repeating or skipping keys
+	if err := db.Set("k0", []byte("before the cursor")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("k3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("k6", []byte("vk6")); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for cursor := page.Cursor; cursor != ""; cursor = page.Cursor {
+		if page, err = db.ScanPage(opts, cursor); err != nil {
+			t.Fatal(err)
+		}
+		if !page.Changed {
+			t.Errorf("Expected the pages to report the writes since the first one")
+		}
+		keys = append(keys, scanKeys(page.KVs)...)
+	}
+	if !reflect.DeepEqual(keys, []string{"k4", "k5", "k6"}) {
+		t.Errorf("Expected the next pages to hold k4, k5, k6, got %v", keys)
+	}
+
+	// Without writes, the cursors carry the sequence of the first page
+	page, err = db.ListKeysPage(memdb.ScanOptions{Start: "k5", Limit: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page, err = db.ListKeysPage(memdb.ScanOptions{Start: "k5", Limit: 1}, page.Cursor); err != nil {
+		t.Fatal(err)
+	}
+	if keys := scanKeys(page.KVs); !reflect.DeepEqual(keys, []string{"k6"}) || page.Changed || page.KVs[0].Value != nil {
+		t.Errorf("Expected the unchanged page k6 without its value, got %+v", page)
+	}
+	if _, err := db.ScanPage(opts, "not a cursor"); err != memdb.ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+
+	// Through the HTTP API, the cursor of the next page is in X-Next-Cursor
+	get := func(handler http.HandlerFunc, target string, into interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), into); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder
+	}
+	var listed []string
+	for target := "/keys?prefix=k&limit=4"; ; {
+		var page []string
+		recorder := get(handlers.ListKeysHandler(db), target, &page)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+		}
+		listed = append(listed, page...)
+		cursor := recorder.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+		target = "/keys?prefix=k&limit=4&cursor=" + cursor
+	}
+	if !reflect.DeepEqual(listed, []string{"k0", "k1", "k2", "k4", "k5", "k6"}) {
+		t.Errorf("Unexpected keys listed through /keys: %v", listed)
+	}
+	var results []handlers.ScanResult
+	recorder := get(handlers.ScanHandler(db), "/scan?prefix=k&limit=1", &results)
+	if len(results) != 1 || results[0].Key != "k0" || recorder.Header().Get("X-Next-Cursor") == "" {
+		t.Errorf("Expected the first page k0 and a cursor from /scan, got %+v, %v", results, recorder.Header())
+	}
+	if recorder := get(handlers.ScanHandler(db), "/scan?cursor=bad", &results); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an invalid cursor, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestScanTimeRange(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// Events logged every minute, in two buckets, with two events at the same time
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if err := db.Set(memdb.TimeKey("cpu", base.Add(time.Duration(i)*time.Minute), ""), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Error setting key: %s", err)
+		}
+	}
+	for _, id := range []string{"host1", "host2"} {
+		if err := db.Set(memdb.TimeKey("mem", base.Add(time.Minute), id), []byte(id)); err != nil {
+			t.Fatalf("Error setting key: %s", err)
+		}
+	}
+
+	kvs, err := db.ScanTimeRange("cpu", base.Add(time.Minute), base.Add(3*time.Minute))
+	if err != nil {
+		t.Fatalf("Error scanning: %s", err)
+	}
+	if len(kvs) != 2 || string(kvs[0].Value) != "1" || string(kvs[1].Value) != "2" {
+		t.Errorf("Expected the events of minutes 1 and 2, got %v", kvs)
+	}
+
+	kvs, err = db.ScanTimeRange("mem", base, time.Time{})
+	if err != nil {
+		t.Fatalf("Error scanning: %s", err)
+	}
+	if len(kvs) != 2 {
+		t.Fatalf("Expected 2 mem events, got %v", kvs)
+	}
+	bucket, at, id, err := memdb.ParseTimeKey(kvs[1].Key)
+	if err != nil || bucket != "mem" || !at.Equal(base.Add(time.Minute)) || id != "host2" {
+		t.Errorf("Unexpected parsed key %q: %s %s %s %v", kvs[1].Key, bucket, at, id, err)
+	}
+	if _, _, _, err := memdb.ParseTimeKey("cpu/12"); err != memdb.ErrInvalidTimeKey {
+		t.Errorf("Expected ErrInvalidTimeKey, got %v", err)
+	}
+
+	// Same range through the HTTP API
+	req, err := http.NewRequest("GET", "/scan?bucket=cpu&from=2024-03-01T12:03:00Z", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handlers.ScanHandler(db).ServeHTTP(recorder, req)
+	var results []handlers.ScanResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Value != "3" || results[1].Value != "4" {
+		t.Errorf("Unexpected scan results: %+v", results)
+	}
+}
+
+func TestScanReadAhead(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath := tempDir + "/test_wal.log"
+	sstableDir := tempDir + "/testSSTableFiles"
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	// Many small SSTables, later ones overwriting and deleting keys of earlier ones
+	for i := 0; i < 40; i++ {
+		if err := db.Set("key:"+strconv.Itoa(i%15), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+		if i%7 == 0 {
+			if err := db.Delete("key:" + strconv.Itoa(i%5)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expected, err := db.Scan(memdb.ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedKeys, err := db.ListKeysPrefix("key:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	wal.Close()
+
+	// The same results whatever the number of tables read ahead
+	for _, readAhead := range []int64{1, 200, 1 << 20} {
+		wal, err := memdb.OpenWAL(walPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2), memdb.ReadAhead(readAhead))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(db.SSTableIDs) < 10 {
+			t.Fatalf("Expected many SSTables, got %d", len(db.SSTableIDs))
+		}
+		results, err := db.Scan(memdb.ScanOptions{})
+		if err != nil || !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected the same scan with %d bytes read ahead, got %v, %v", readAhead, scanKeys(results), err)
+		}
+		keys, err := db.ListKeysPrefix("key:1")
+		if err != nil || !reflect.DeepEqual(keys, expectedKeys) {
+			t.Errorf("Expected keys %v with %d bytes read ahead, got %v, %v", expectedKeys, readAhead, keys, err)
+		}
+		db.Close()
+		wal.Close()
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	tempDir := t.TempDir()
+	open := func() (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatalf("Error opening WAL: %s", err)
+		}
+		db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3), memdb.Indexes("city", "tags[0]"))
+		if err != nil {
+			t.Fatalf("Error creating DB: %s", err)
+		}
+		return db, wal
+	}
+	query := func(db *memdb.DB, path string, value string) []string {
+		keys, err := db.QueryIndex(path, []byte(value))
+		if err != nil {
+			t.Fatalf("Error querying %s: %s", path, err)
+		}
+		return keys
+	}
+
+	// Flushed and unflushed documents are indexed, values without the path or that aren't JSON are not
+	db, wal := open()
+	for key, value := range map[string]string{
+		"user:1": `{"name":"imane","city":"azilal","tags":["admin"]}`,
+		"user:2": `{"name":"sara","city":"rabat","tags":[1.0]}`,
+		"user:3": `{"name":"omar","city":"azilal"}`,
+		"user:4": `{"name":"lina"}`,
+		"raw":    `azilal`,
+	} {
+		if err := db.Set(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys := query(db, "city", `"azilal"`); !reflect.DeepEqual(keys, []string{"user:1", "user:3"}) {
+		t.Errorf("Expected user:1 and user:3, got %v", keys)
+	}
+	if keys := query(db, "tags[0]", `1`); !reflect.DeepEqual(keys, []string{"user:2"}) {
+		t.Errorf("Expected numbers to match whatever their writing, got %v", keys)
+	}
+
+	// Updates move the key, deletions and purges drop it
+	if err := db.Set("user:3", []byte(`{"name":"omar","city":"rabat"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("user:2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Purge("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ingest([]memdb.KeyValue{{Key: "user:5", Value: []byte(`{"city":"rabat"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := query(db, "city", `"rabat"`); !reflect.DeepEqual(keys, []string{"user:3", "user:5"}) {
+		t.Errorf("Expected user:3 and user:5, got %v", keys)
+	}
+	if keys := query(db, "city", `"azilal"`); len(keys) != 0 {
+		t.Errorf("Expected no key, got %v", keys)
+	}
+	if _, err := db.QueryIndex("name", []byte(`"lina"`)); err != memdb.ErrNoIndex {
+		t.Errorf("Expected no index error, got %v", err)
+	}
+
+	// The indexes are rebuilt when the database is opened again
+	db.Close()
+	wal.Close()
+	db, wal = open()
+	defer wal.Close()
+	defer db.Close()
+	if keys := query(db, "city", `"rabat"`); !reflect.DeepEqual(keys, []string{"user:3", "user:5"}) {
+		t.Errorf("Expected user:3 and user:5 after reopening, got %v", keys)
+	}
+
+	// The HTTP API takes the value as JSON or as a plain string
+	mux := handlers.NewMux(db, wal)
+	for _, target := range []string{"/index?path=city&value=rabat", `/index?path=city&value="rabat"`} {
+		req := httptest.NewRequest("GET", target, nil)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		var keys []string
+		if err := json.NewDecoder(recorder.Body).Decode(&keys); err != nil || !reflect.DeepEqual(keys, []string{"user:3", "user:5"}) {
+			t.Errorf("Expected user:3 and user:5 for %s, got %v, %v", target, keys, err)
+		}
+	}
+	req := httptest.NewRequest("GET", "/index?path=name&value=lina", nil)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	for key, value := range map[string]string{
+		"user:1": `{"name":"imane","age":31,"city":"azilal","tags":["admin"]}`,
+		"user:2": `{"name":"sara","age":25,"city":"rabat"}`,
+		"user:3": `{"name":"omar","age":40,"city":"azilal","deleted_at":"2024-01-01"}`,
+		"user:4": `{"name":"ilyas","age":"unknown"}`,
+		"item:1": `{"name":"pen","age":50}`,
+		"raw":    `not json`,
+	} {
+		if err := db.Set(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		expr string
+		keys []string
+	}{
+		{`city == "azilal"`, []string{"user:1", "user:3"}},
+		{`age >= 31`, []string{"item:1", "user:1", "user:3"}},
+		{`age>30 and age<45`, []string{"user:1", "user:3"}},
+		{`city == "azilal" and not exists(deleted_at)`, []string{"user:1"}},
+		{`prefix(name, "i") or tags[0] == "admin"`, []string{"user:1", "user:4"}},
+		{`(city == "rabat" or city == "azilal") and age < 30`, []string{"user:2"}},
+		{`city != "rabat"`, []string{"user:1", "user:3"}},
+		{`age < "z"`, []string{"user:4"}},
+	} {
+		filter, err := memdb.ParseFilter(test.expr)
+		if err != nil {
+			t.Errorf("Error parsing %s: %s", test.expr, err)
+			continue
+		}
+		kvs, err := db.Scan(memdb.ScanOptions{Filter: filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := scanKeys(kvs); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("Expected %v for %s, got %v", test.keys, test.expr, keys)
+		}
+	}
+
+	for _, expr := range []string{``, `age >`, `age => 3`, `city == "azilal`, `exists(a..b)`, `prefix(name, 3)`, `(age > 3`, `age > 3 city`} {
+		var invalid *memdb.QueryError
+		if _, err := memdb.ParseFilter(expr); !errors.As(err, &invalid) {
+			t.Errorf("Expected a query error for %q, got %v", expr, err)
+		}
+	}
+
+	// POST /query filters inside the bounds of a scan
+	mux := handlers.NewMux(db, wal)
+	req := httptest.NewRequest("POST", "/query", strings.NewReader(`{"filter":"age > 30","prefix":"user:","limit":1}`))
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	var results []handlers.ScanResult
+	if err := json.NewDecoder(recorder.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Key != "user:1" {
+		t.Errorf("Expected user:1, got %v", results)
+	}
+	req = httptest.NewRequest("POST", "/query", strings.NewReader(`{"filter":"age >"}`))
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tempDir := t.TempDir()
+	sstableDir := tempDir + "/testSSTableFiles"
+	open := func() (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatalf("Error opening WAL: %s", err)
+		}
+		db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(3), memdb.FullText("title", "tags"))
+		if err != nil {
+			t.Fatalf("Error creating DB: %s", err)
+		}
+		return db, wal
+	}
+	search := func(db *memdb.DB, q string) []memdb.SearchResult {
+		results, err := db.Search(q, 0)
+		if err != nil {
+			t.Fatalf("Error searching %q: %s", q, err)
+		}
+		return results
+	}
+
+	// Documents match every word of the query, ranked by occurrences, whether flushed or not
+	db, wal := open()
+	for key, value := range map[string]string{
+		"post:1": `{"title":"Storage engines, storage layouts","tags":["go"]}`,
+		"post:2": `{"title":"A storage engine in Go","tags":["Go","go"]}`,
+		"post:3": `{"title":"Cooking pasta"}`,
+		"post:4": `{"body":"storage engine"}`,
+		"raw":    `storage engine`,
+	} {
+		if err := db.Set(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []memdb.SearchResult{{Key: "post:2", Score: 4}, {Key: "post:1", Score: 3}}
+	if results := search(db, "GO storage go"); !reflect.DeepEqual(results, want) {
+		t.Errorf("Expected %v, got %v", want, results)
+	}
+	if results := search(db, "storage pasta"); len(results) != 0 {
+		t.Errorf("Expected no result, got %v", results)
+	}
+
+	// Updates replace the words of a key, deletions drop it
+	if err := db.Set("post:3", []byte(`{"title":"Pasta for storage engineers","tags":["go"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("post:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	want = []memdb.SearchResult{{Key: "post:2", Score: 4}, {Key: "post:3", Score: 2}}
+	if results := search(db, "go storage"); !reflect.DeepEqual(results, want) {
+		t.Errorf("Expected %v, got %v", want, results)
+	}
+
+	// The segments are merged by compactions and loaded when the database is opened again
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("post:5", []byte(`{"title":"go go go go"}`)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	wal.Close()
+	segments, err := os.ReadDir(sstableDir + "/" + memdb.SearchDir)
+	if err != nil || len(segments) != 1 {
+		t.Errorf("Expected one merged segment, got %d, %v", len(segments), err)
+	}
+	want = []memdb.SearchResult{{Key: "post:5", Score: 4}, {Key: "post:2", Score: 3}, {Key: "post:3", Score: 1}}
+	db, wal = open()
+	if results := search(db, "go"); !reflect.DeepEqual(results, want) {
+		t.Errorf("Expected %v after reopening, got %v", want, results)
+	}
+
+	// Without its segments, the index is rebuilt from the data
+	db.Close()
+	wal.Close()
+	if err := os.RemoveAll(sstableDir + "/" + memdb.SearchDir); err != nil {
+		t.Fatal(err)
+	}
+	db, wal = open()
+	defer wal.Close()
+	defer db.Close()
+	if results := search(db, "go"); !reflect.DeepEqual(results, want) {
+		t.Errorf("Expected %v after rebuilding, got %v", want, results)
+	}
+
+	// The HTTP API returns the results as JSON, and 404 Not Found without full-text index
+	mux := handlers.NewMux(db, wal)
+	req := httptest.NewRequest("GET", "/search?q=go&limit=1", nil)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	var results []memdb.SearchResult
+	if err := json.NewDecoder(recorder.Body).Decode(&results); err != nil || !reflect.DeepEqual(results, want[:1]) {
+		t.Errorf("Expected %v, got %v, %v", want[:1], results, err)
+	}
+
+	otherWAL, err := memdb.OpenWAL(tempDir + "/other_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherWAL.Close()
+	other, err := memdb.NewDB(otherWAL, tempDir+"/otherSSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	recorder = httptest.NewRecorder()
+	handlers.NewMux(other, otherWAL).ServeHTTP(recorder, httptest.NewRequest("GET", "/search?q=go", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	tempDir := t.TempDir()
+	open := func() (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatalf("Error opening WAL: %s", err)
+		}
+		db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+		if err != nil {
+			t.Fatalf("Error creating DB: %s", err)
+		}
+		return db, wal
+	}
+
+	// Appends take increasing sequence numbers, per stream
+	db, wal := open()
+	for i, payload := range []string{"a", "b", "c", "d"} {
+		seq, err := db.StreamAppend("orders", []byte(payload))
+		if err != nil || seq != uint64(i+1) {
+			t.Fatalf("Expected sequence number %d, got %d, %v", i+1, seq, err)
+		}
+	}
+	if seq, err := db.StreamAppend("orders/eu", []byte("x")); err != nil || seq != 1 {
+		t.Errorf("Expected sequence number 1 in another stream, got %d, %v", seq, err)
+	}
+	if _, err := db.StreamAppend(memdb.ReservedKeyPrefix+"orders", []byte("x")); !errors.As(err, new(*memdb.InvalidKeyError)) {
+		t.Errorf("Expected an invalid key error, got %v", err)
+	}
+
+	// Reads start at a sequence number, without the entries of other streams
+	entries, err := db.StreamRead("orders", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []memdb.StreamEntry{{Seq: 2, Payload: []byte("b")}, {Seq: 3, Payload: []byte("c")}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Expected %v, got %v", want, entries)
+	}
+	if value, err := db.Get(memdb.StreamKey("orders", 4)); err != nil || string(value) != "d" {
+		t.Errorf("Expected entries to be regular keys, got %q, %v", value, err)
+	}
+
+	// Trimming keeps the last entry, so that sequence numbers carry on after reopening
+	if deleted, err := db.StreamTrim("orders", 10); err != nil || deleted != 3 {
+		t.Errorf("Expected 3 entries deleted, got %d, %v", deleted, err)
+	}
+	db.Close()
+	wal.Close()
+	db, wal = open()
+	defer wal.Close()
+	defer db.Close()
+	if seq, err := db.StreamAppend("orders", []byte("e")); err != nil || seq != 5 {
+		t.Errorf("Expected sequence number 5 after reopening, got %d, %v", seq, err)
+	}
+	if entries, err := db.StreamRead("orders", 0, 0); err != nil || len(entries) != 2 || entries[0].Seq != 4 {
+		t.Errorf("Expected entries 4 and 5, got %v, %v", entries, err)
+	}
+
+	// The HTTP API appends the body and returns the payloads in base64
+	mux := handlers.NewMux(db, wal)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/stream/append?stream=orders", strings.NewReader("f")))
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"seq":6}` {
+		t.Errorf("Expected sequence number 6, got %d %s", recorder.Code, recorder.Body)
+	}
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/stream/read?stream=orders&from=6", nil))
+	if err := json.NewDecoder(recorder.Body).Decode(&entries); err != nil || len(entries) != 1 || string(entries[0].Payload) != "f" {
+		t.Errorf("Expected entry 6, got %v, %v", entries, err)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	tempDir := t.TempDir()
+	open := func() (*memdb.DB, *memdb.WAL) {
+		wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+		if err != nil {
+			t.Fatalf("Error opening WAL: %s", err)
+		}
+		db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+		if err != nil {
+			t.Fatalf("Error creating DB: %s", err)
+		}
+		return db, wal
+	}
+	strs := func(values [][]byte) string {
+		out := make([]string, len(values))
+		for i, v := range values {
+			out[i] = string(v)
+		}
+		return strings.Join(out, " ")
+	}
+
+	// Pushes to both ends keep the order, ranges count from either end
+	db, wal := open()
+	if n, err := db.ListPush("queue", []byte("b"), []byte("c"), []byte("d")); err != nil || n != 3 {
+		t.Fatalf("Expected length 3, got %d, %v", n, err)
+	}
+	if n, err := db.ListPushFront("queue", []byte("a"), []byte("z")); err != nil || n != 5 {
+		t.Fatalf("Expected length 5, got %d, %v", n, err)
+	}
+	if values, err := db.ListRange("queue", 0, -1); err != nil || strs(values) != "z a b c d" {
+		t.Errorf("Expected z a b c d, got %q, %v", strs(values), err)
+	}
+	if values, err := db.ListRange("queue", -2, 10); err != nil || strs(values) != "c d" {
+		t.Errorf("Expected c d, got %q, %v", strs(values), err)
+	}
+	if value, err := db.ListPop("queue", true); err != nil || string(value) != "z" {
+		t.Errorf("Expected z from the front, got %q, %v", value, err)
+	}
+	if value, err := db.ListPop("queue", false); err != nil || string(value) != "d" {
+		t.Errorf("Expected d from the back, got %q, %v", value, err)
+	}
+
+	// Sets hold each member once, in byte order
+	if added, err := db.SetAdd("tags", []byte("red"), []byte("blue"), []byte("red")); err != nil || added != 2 {
+		t.Errorf("Expected 2 members added, got %d, %v", added, err)
+	}
+	if removed, err := db.SetRemove("tags", []byte("red"), []byte("green")); err != nil || removed != 1 {
+		t.Errorf("Expected 1 member removed, got %d, %v", removed, err)
+	}
+	db.SetAdd("tags", []byte("amber"))
+	if ok, err := db.SetContains("tags", []byte("blue")); err != nil || !ok {
+		t.Errorf("Expected blue to be a member, got %v, %v", ok, err)
+	}
+
+	// Collections don't show as keys, and a collection named after a prefix of another doesn't see its elements
+	db.SetAdd("tag", []byte("s"))
+	if members, err := db.SetMembers("tag"); err != nil || strs(members) != "s" {
+		t.Errorf("Expected s, got %q, %v", strs(members), err)
+	}
+	if kvs, err := db.Scan(memdb.ScanOptions{}); err != nil || len(kvs) != 0 {
+		t.Errorf("Expected no keys, got %v, %v", kvs, err)
+	}
+
+	// Collections survive reopening, flushed or not
+	db.Close()
+	wal.Close()
+	db, wal = open()
+	defer wal.Close()
+	defer db.Close()
+	if n, err := db.ListLen("queue"); err != nil || n != 3 {
+		t.Errorf("Expected length 3 after reopening, got %d, %v", n, err)
+	}
+	if n, err := db.ListPushFront("queue", []byte("y")); err != nil || n != 4 {
+		t.Errorf("Expected length 4, got %d, %v", n, err)
+	}
+	if members, err := db.SetMembers("tags"); err != nil || strs(members) != "amber blue" {
+		t.Errorf("Expected amber blue, got %q, %v", strs(members), err)
+	}
+
+	// The HTTP API pushes and adds the body and returns elements in base64
+	mux := handlers.NewMux(db, wal)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/list/push?key=queue", strings.NewReader("e")))
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != `{"length":5}` {
+		t.Errorf("Expected length 5, got %d %s", recorder.Code, recorder.Body)
+	}
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/list/range?key=queue&start=0&stop=1", nil))
+	var values [][]byte
+	if err := json.NewDecoder(recorder.Body).Decode(&values); err != nil || strs(values) != "y a" {
+		t.Errorf("Expected y a, got %q, %v", strs(values), err)
+	}
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/set/add?key=tags", strings.NewReader("blue")))
+	if strings.TrimSpace(recorder.Body.String()) != `{"added":0}` {
+		t.Errorf("Expected no member added, got %d %s", recorder.Code, recorder.Body)
+	}
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/list/pop?key=empty", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an empty list, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"StorageEngine/memdb"
+	"StorageEngine/sessions"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	store := sessions.New(db, 100*time.Millisecond, "")
+
+	// Sessions get distinct IDs and keep their data
+	session, err := store.Create([]byte("user=ann"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.Create(nil)
+	if err != nil || other.ID == session.ID {
+		t.Fatalf("Expected a distinct session, got %v, %v", other, err)
+	}
+	if got, err := store.Get(session.ID); err != nil || string(got.Data) != "user=ann" {
+		t.Errorf("Expected the data of the session, got %v, %v", got, err)
+	}
+	if _, err := db.Get(sessions.DefaultPrefix + session.ID); err != nil {
+		t.Errorf("Expected the session under the default prefix, got %v", err)
+	}
+
+	// Touching slides the expiry, the untouched session expires
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := store.Touch(session.ID); err != nil {
+			t.Fatalf("Expected the session to be touched, got %v", err)
+		}
+	}
+	if _, err := store.Get(other.ID); err != sessions.ErrSessionNotFound {
+		t.Errorf("Expected the untouched session to expire, got %v", err)
+	}
+	if got, err := store.Update(session.ID, []byte("user=bob")); err != nil || string(got.Data) != "user=bob" {
+		t.Errorf("Expected the data to be updated, got %v, %v", got, err)
+	}
+
+	// Sweep deletes the expired sessions, Destroy the others
+	third, _ := store.Create(nil)
+	time.Sleep(120 * time.Millisecond)
+	if deleted, err := store.Sweep(); err != nil || deleted != 2 {
+		t.Errorf("Expected 2 sessions swept, got %d, %v", deleted, err)
+	}
+	if _, err := db.Get(sessions.DefaultPrefix + third.ID); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected the expired session to be deleted, got %v", err)
+	}
+	fresh, _ := store.Create(nil)
+	if err := store.Destroy(fresh.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Touch(fresh.ID); err != sessions.ErrSessionNotFound {
+		t.Errorf("Expected a destroyed session to be gone, got %v", err)
+	}
+}
@@ -3,9 +3,17 @@ package tests
 import (
 	"StorageEngine/memdb"
 	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
 	"testing"
 	"time"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func TestSSTable(t *testing.T) {
@@ -93,7 +101,7 @@ func TestSSTable(t *testing.T) {
 		t.Errorf("Expected Largest Key %s, got %s", expectedLargestKey, string(ssts[0].Header.LargestKey))
 	}
 
-	expectedVersion := 1
+	expectedVersion := 4
 	if ssts[0].Header.Version != uint16(expectedVersion) {
 		t.Errorf("Expected Version %d, got %d", expectedVersion, ssts[0].Header.Version)
 	}
@@ -130,3 +138,909 @@ func TestSSTable(t *testing.T) {
 		t.Errorf("Expected Checksum %d, got %d", expectedChecksum, ssts[0].Checksum)
 	}
 }
+
+func TestScrubQuarantinesCorruptedSSTables(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	var reported []memdb.CorruptTable
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(2),
+		memdb.Scrub(time.Hour, true, func(table memdb.CorruptTable) { reported = append(reported, table) }))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+
+	// Flush one SSTable
+	for _, key := range []string{"a", "b"} {
+		if err := db.Set(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sstableID := db.SSTableIDs[0]
+
+	if result := db.ScrubNow(); result.Checked != 1 || len(result.Corrupted) != 0 {
+		t.Fatalf("Expected 1 valid SSTable, got %+v", result)
+	}
+
+	// Flip a byte of the last value, just before the checksum
+	data, err := os.ReadFile(sstableID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xff
+	if err := os.WriteFile(sstableID, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := db.ScrubNow()
+	if result.Checked != 1 || len(result.Corrupted) != 1 {
+		t.Fatalf("Expected 1 corrupted SSTable, got %+v", result)
+	}
+	if len(reported) != 1 || reported[0].Path != sstableID {
+		t.Errorf("Expected the corrupted SSTable to be reported, got %+v", reported)
+	}
+	quarantined := tempDir + "/testSSTableFiles/" + memdb.QuarantineDirName + "/" + filepath.Base(sstableID)
+	if result.Corrupted[0].Quarantined != quarantined {
+		t.Errorf("Expected SSTable to be moved to %s, got %s", quarantined, result.Corrupted[0].Quarantined)
+	}
+	if _, err := os.Stat(quarantined); err != nil {
+		t.Errorf("Expected quarantined SSTable to exist: %s", err)
+	}
+	if len(db.SSTableIDs) != 0 {
+		t.Errorf("Expected no SSTable left, got %v", db.SSTableIDs)
+	}
+	if last := db.LastScrub(); last == nil || len(last.Corrupted) != 1 {
+		t.Errorf("Expected last scrub to report the corruption, got %+v", last)
+	}
+}
+
+func TestListSSTables(t *testing.T) {
+
+	// Create the db
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(3))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+
+	if err := db.Set("apple", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("banana", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("cherry", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := db.ListSSTables()
+	if err != nil {
+		t.Fatalf("Error listing SSTables: %s", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("Expected 1 SSTable, got %d", len(infos))
+	}
+	info := infos[0]
+	if info.Path != db.SSTableIDs[0] || info.FileNumber == 0 || info.Size == 0 || info.Created.IsZero() {
+		t.Errorf("Unexpected file information: %+v", info)
+	}
+	if info.SmallestKey != "apple" || info.LargestKey != "cherry" || info.Entries != 3 || info.Tombstones != 0 {
+		t.Errorf("Unexpected content information: %+v", info)
+	}
+}
+
+// TestCompactOffline checks that a full offline compaction keeps the newest version of every live key
+func TestCompactOffline(t *testing.T) {
+	tempDir := t.TempDir()
+	sstableDir := tempDir + "/testSSTableFiles"
+	if err := os.Mkdir(sstableDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(key, value string) sstable.KeyValuePair {
+		return sstable.KeyValuePair{Operation: sstable.OpSet, Key: []byte(key), Value: []byte(value)}
+	}
+	del := func(key string) sstable.KeyValuePair {
+		return sstable.KeyValuePair{Operation: sstable.OpDel, Key: []byte(key)}
+	}
+	// Tables from the oldest to the newest
+	tables := [][]sstable.KeyValuePair{
+		{set("a", "1"), set("b", "1"), set("c", "1")},
+		{set("b", "2"), del("c"), set("d", "2")},
+		{del("a"), set("c", "3"), del("e")},
+	}
+	base := time.Now().Add(-time.Hour)
+	for i, keyValues := range tables {
+		path := fmt.Sprintf("%s/sstable_file_%d.sst", sstableDir, i)
+		if err := sstable.WriteSSTable(path, sstable.NewSSTable(keyValues)); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := memdb.CompactOffline(sstableDir, 0)
+	if err != nil {
+		t.Fatalf("Error compacting: %s", err)
+	}
+	if len(report.Inputs) != 3 || report.InputEntries != 9 || report.OutputEntries != 3 || report.DroppedTombstones != 2 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	files, _ := os.ReadDir(sstableDir)
+	if len(files) != 1 {
+		t.Fatalf("Expected a single SSTable left, got %d files", len(files))
+	}
+	if len(report.Outputs) != 1 {
+		t.Fatalf("Expected a single output, got %v", report.Outputs)
+	}
+	sst, err := sstable.ReadSSTable(report.Outputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []sstable.KeyValuePair{set("b", "2"), set("c", "3"), set("d", "2")}
+	if !reflect.DeepEqual(sst.KeyValues, expected) {
+		t.Errorf("Expected %v, got %v", expected, sst.KeyValues)
+	}
+
+	// Merging without dropping tombstones keeps the deletions
+	merged := sstable.Merge([]*sstable.SSTable{sstable.NewSSTable(tables[0]), sstable.NewSSTable(tables[2])}, false)
+	expected = []sstable.KeyValuePair{del("a"), set("b", "1"), set("c", "3"), del("e")}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected %v, got %v", expected, merged)
+	}
+}
+
+// TestMigrate checks that migration verifies every file and refuses unknown format versions
+func TestMigrate(t *testing.T) {
+	tempDir := t.TempDir()
+	walPath, sstableDir := tempDir+"/test_wal.log", tempDir+"/testSSTableFiles"
+
+	wal, err := memdb.OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("Error opening WAL: %s", err)
+	}
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(2))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	wal.Close()
+
+	report, err := memdb.Migrate(walPath, sstableDir, false)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	if report.TablesVerified != 1 || report.WALRecords != 3 || len(report.Tables) != 1 || report.Tables[0].Rewritten {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	// A table of a format version this build doesn't know, in a directory written before the MANIFEST
+	if err := os.Remove(sstableDir + "/" + memdb.ManifestFileName); err != nil {
+		t.Fatal(err)
+	}
+	table := sstable.NewSSTable([]sstable.KeyValuePair{{Operation: sstable.OpSet, Key: []byte("z"), Value: []byte("z")}})
+	table.Header.Version = 99
+	if err := sstable.WriteSSTable(sstableDir+"/future.sst", table); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memdb.Migrate(walPath, sstableDir, true); !errors.Is(err, memdb.ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestSSTableReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "table.sst")
+	err := sstable.CreateAndWriteSSTable(path, map[string]sstable.Pair{
+		"a": {Value: []byte("1")},
+		"b": {Value: []byte("old"), Marker: true},
+		"c": {Value: []byte("333")},
+	})
+	if err != nil {
+		t.Fatalf("Error writing SSTable: %s", err)
+	}
+
+	reader, err := sstable.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Error opening reader: %s", err)
+	}
+	defer reader.Close()
+	if kv, found, err := reader.Get([]byte("c")); err != nil || !found || string(kv.Value) != "333" {
+		t.Errorf("Expected c=333, got %+v, %v, %v", kv, found, err)
+	}
+	// b is written as a deletion alone
+	if kv, found, err := reader.Get([]byte("b")); err != nil || !found || kv.Operation != sstable.OpDel {
+		t.Errorf("Expected a deletion of b, got %+v, %v, %v", kv, found, err)
+	}
+	if _, found, err := reader.Get([]byte("bb")); err != nil || found {
+		t.Errorf("Expected bb not to be found, got %v, %v", found, err)
+	}
+	if !reader.InRange([]byte("bb")) || reader.InRange([]byte("0")) || reader.InRange([]byte("d")) {
+		t.Errorf("Expected only keys from a to c to be in range")
+	}
+
+	// Corruption is detected when the block holding it is read, the reader only loads the index
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bytes.LastIndex(data, []byte("333"))] ^= 0xff // Value of c
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	corrupted, err := sstable.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Error opening SSTable reader: %s", err)
+	}
+	defer corrupted.Close()
+	if _, _, err := corrupted.Get([]byte("c")); err != sstable.ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := sstable.ReadSSTable(path); err != sstable.ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+// readerAtOnly hides the size of a reader, so that SSTables are read without their index
+type readerAtOnly struct{ r io.ReaderAt }
+
+func (r readerAtOnly) ReadAt(p []byte, off int64) (int, error) { return r.r.ReadAt(p, off) }
+
+// TestSSTableIndex checks that lookups and iterations through the index of a table of many blocks find what a full
+// pass over the entries finds, and that a corrupted index is refused
+func TestSSTableIndex(t *testing.T) {
+	data := make(map[string]sstable.Pair)
+	for i := 0; i < 3000; i++ {
+		data[fmt.Sprintf("key%05d", i*2)] = sstable.Pair{Value: bytes.Repeat([]byte{byte(i)}, 64), Marker: i%7 == 0}
+	}
+	path := filepath.Join(t.TempDir(), "table.sst")
+	table := sstable.NewSSTable(sstable.MemtableKeyValues(data))
+	if err := sstable.WriteSSTable(path, table); err != nil {
+		t.Fatalf("Error writing SSTable: %s", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(raw)) != table.EncodedSize() {
+		t.Errorf("Expected a file of %d bytes, got %d", table.EncodedSize(), len(raw))
+	}
+
+	indexed, err := sstable.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Error opening reader: %s", err)
+	}
+	scanned, err := sstable.NewReader(readerAtOnly{bytes.NewReader(raw)})
+	if err != nil {
+		t.Fatalf("Error opening reader: %s", err)
+	}
+	if indexed.Len() != 3000 || indexed.TombstoneBytes() != scanned.TombstoneBytes() {
+		t.Errorf("Expected 3000 entries and %d bytes of tombstones, got %d and %d", scanned.TombstoneBytes(), indexed.Len(), indexed.TombstoneBytes())
+	}
+	for i := 0; i < 6001; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		kv, found, err := indexed.Get(key)
+		want, wantFound, _ := scanned.Get(key)
+		if err != nil || found != wantFound || !reflect.DeepEqual(kv, want) {
+			t.Fatalf("%s: expected %+v, %v, got %+v, %v, %v", key, want, wantFound, kv, found, err)
+		}
+	}
+	if indexed.InRange([]byte("key")) || !indexed.InRange([]byte("key05998")) || indexed.InRange([]byte("key05999")) {
+		t.Errorf("Expected only keys from key00000 to key05998 to be in range")
+	}
+
+	// An iteration from a key between two entries, across the blocks
+	it := indexed.NewIterator()
+	it.Seek([]byte("key01001"))
+	count := 0
+	for ; it.Valid(); it.Next() {
+		want := table.KeyValues[501+count]
+		if string(it.Key()) != string(want.Key) || it.Operation() != want.Operation || !bytes.Equal(it.Value(), want.Value) {
+			t.Fatalf("Expected %s, got %s", want.Key, it.Key())
+		}
+		count++
+	}
+	if it.Err() != nil || count != 2499 {
+		t.Errorf("Expected 2499 entries from key01002, got %d, %v", count, it.Err())
+	}
+
+	// The footer points to an index matching its checksum
+	raw[len(raw)-21] ^= 0xff // Last byte of the index, before the footer
+	if _, err := sstable.NewReader(bytes.NewReader(raw)); err != sstable.ErrCorruptedIndex {
+		t.Errorf("Expected ErrCorruptedIndex, got %v", err)
+	}
+	if _, err := sstable.ReadSSTableAt(bytes.NewReader(raw)); err != sstable.ErrCorruptedIndex {
+		t.Errorf("Expected ErrCorruptedIndex, got %v", err)
+	}
+}
+
+// TestMergingIterator checks the merge of a file read lazily with an in-memory table, newest version first
+func TestMergingIterator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "table.sst")
+	err := sstable.CreateAndWriteSSTable(path, map[string]sstable.Pair{
+		"a": {Value: []byte("old")},
+		"b": {Value: []byte("2")},
+		"c": {Value: []byte("3")},
+		"e": {Value: []byte("5")},
+	})
+	if err != nil {
+		t.Fatalf("Error writing SSTable: %s", err)
+	}
+	reader, err := sstable.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Error opening reader: %s", err)
+	}
+	defer reader.Close()
+	newer := []sstable.KeyValuePair{
+		{Operation: sstable.OpSet, Key: []byte("a"), Value: []byte("1")},
+		{Operation: sstable.OpDel, Key: []byte("c")},
+		{Operation: sstable.OpSet, Key: []byte("d"), Value: []byte("4")},
+	}
+
+	collect := func(it sstable.Iterator) string {
+		var out []string
+		for ; it.Valid(); it.Next() {
+			out = append(out, fmt.Sprintf("%s=%s/%d", it.Key(), it.Value(), it.Operation()))
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Error iterating: %s", err)
+		}
+		return strings.Join(out, " ")
+	}
+	inputs := func() []sstable.Iterator {
+		return []sstable.Iterator{reader.NewIterator(), sstable.NewSliceIterator(newer)}
+	}
+
+	if got, want := collect(reader.NewIterator()), "a=old/0 b=2/0 c=3/0 e=5/0"; got != want {
+		t.Errorf("Expected the file to read %q, got %q", want, got)
+	}
+	if got, want := collect(sstable.NewMergingIterator(inputs(), false)), "a=1/0 b=2/0 c=/1 d=4/0 e=5/0"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := collect(sstable.NewMergingIterator(inputs(), true)), "a=1/0 b=2/0 d=4/0 e=5/0"; got != want {
+		t.Errorf("Expected the deletion dropped, %q, got %q", want, got)
+	}
+
+	it := sstable.NewMergingIterator(inputs(), true)
+	it.Seek([]byte("bb"))
+	if got, want := collect(it), "d=4/0 e=5/0"; got != want {
+		t.Errorf("Expected %q after seeking, got %q", want, got)
+	}
+
+	// A deletion sitting next to a set entry for the same key in the same input prevails
+	shadowed := []sstable.KeyValuePair{
+		{Operation: sstable.OpDel, Key: []byte("b")},
+		{Operation: sstable.OpSet, Key: []byte("b"), Value: []byte("stale")},
+	}
+	merged := sstable.NewMergingIterator([]sstable.Iterator{reader.NewIterator(), sstable.NewSliceIterator(shadowed)}, true)
+	if got, want := collect(merged), "a=old/0 c=3/0 e=5/0"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestAnalyze checks the key space analysis over overlapping SSTables
+func TestAnalyze(t *testing.T) {
+	sstableDir := t.TempDir()
+	set := func(key, value string) sstable.KeyValuePair {
+		return sstable.KeyValuePair{Operation: sstable.OpSet, Key: []byte(key), Value: []byte(value)}
+	}
+	del := func(key string) sstable.KeyValuePair {
+		return sstable.KeyValuePair{Operation: sstable.OpDel, Key: []byte(key)}
+	}
+	tables := [][]sstable.KeyValuePair{
+		{set("order:1", "c"), set("user:1", "aa"), set("user:2", "bbbb")},
+		{del("order:1"), set("user:2", "x"), set("x", "yyyyyyyy")},
+	}
+	base := time.Now().Add(-time.Hour)
+	for i, keyValues := range tables {
+		path := fmt.Sprintf("%s/sstable_file_%d.sst", sstableDir, i)
+		if err := sstable.WriteSSTable(path, sstable.NewSSTable(keyValues)); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	analysis, err := memdb.Analyze(sstableDir, memdb.AnalyzeOptions{Separator: ":", PrefixLength: 4, TopPrefixes: 1})
+	if err != nil {
+		t.Fatalf("Error analyzing: %s", err)
+	}
+	if analysis.Entries != 6 || analysis.Tombstones != 1 || analysis.DistinctKeys != 4 || analysis.LiveKeys != 3 {
+		t.Errorf("Unexpected counts: %+v", analysis)
+	}
+	if len(analysis.Tables) != 2 || analysis.Tables[0].Shadowed != 2 || analysis.Tables[1].Shadowed != 0 {
+		t.Errorf("Unexpected tables: %+v", analysis.Tables)
+	}
+	if len(analysis.Overlaps) != 1 || analysis.Overlaps[0].SharedKeys != 2 {
+		t.Errorf("Unexpected overlaps: %+v", analysis.Overlaps)
+	}
+	expected := []memdb.PrefixCount{{Prefix: "user:", Keys: 2}}
+	if analysis.DistinctPrefixes != 2 || !reflect.DeepEqual(analysis.TopPrefixes, expected) {
+		t.Errorf("Expected %v out of 2 prefixes, got %v out of %d", expected, analysis.TopPrefixes, analysis.DistinctPrefixes)
+	}
+	if d := analysis.ValueSizes; d.Count != 3 || d.Min != 1 || d.Max != 8 || d.P50 != 3 || d.P99 != 8 {
+		t.Errorf("Unexpected value sizes: %+v", d)
+	}
+}
+
+// TestTargetFileSize checks that flushes and compactions split their output at key boundaries
+func TestTargetFileSize(t *testing.T) {
+	kv := func(op sstable.Operation, key string, valueSize int) sstable.KeyValuePair {
+		return sstable.KeyValuePair{Operation: op, Key: []byte(key), Value: make([]byte, valueSize)}
+	}
+	// Header and checksum take 22 bytes, each entry 9 bytes plus its key and value
+	keyValues := []sstable.KeyValuePair{
+		kv(sstable.OpSet, "a", 40),
+		kv(sstable.OpDel, "b", 0), kv(sstable.OpSet, "b", 40),
+		kv(sstable.OpSet, "c", 200),
+		kv(sstable.OpSet, "d", 10),
+	}
+	runs := sstable.Split(keyValues, 100)
+	var lengths []int
+	for _, run := range runs {
+		lengths = append(lengths, len(run))
+	}
+	if !reflect.DeepEqual(lengths, []int{1, 2, 1, 1}) {
+		t.Errorf("Expected runs of 1, 2, 1 and 1 entries, got %v", lengths)
+	}
+	if len(sstable.Split(keyValues, 0)) != 1 {
+		t.Errorf("Expected a single run without a target size")
+	}
+
+	tempDir := t.TempDir()
+	wal, err := memdb.OpenWAL(tempDir + "/test_wal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles", memdb.Threshold(10), memdb.TargetFileSize(200))
+	if err != nil {
+		t.Fatalf("Error creating DB: %s", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Set(fmt.Sprintf("key%d", i), make([]byte, 50)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.SSTableIDs) != 5 {
+		t.Fatalf("Expected the flush to write 5 SSTables of 2 keys, got %v", db.SSTableIDs)
+	}
+	for _, sstableID := range db.SSTableIDs {
+		if fileInfo, err := os.Stat(sstableID); err != nil || fileInfo.Size() > 200 {
+			t.Errorf("Expected %s to exist within 200 bytes: %v", sstableID, err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := db.Get(fmt.Sprintf("key%d", i)); err != nil {
+			t.Errorf("Error getting key%d: %s", i, err)
+		}
+	}
+
+	// Merging tables already at the target size writes as many tables again, which ends the compaction
+	compacted := make(chan error, 1)
+	go func() { compacted <- db.CompactSSTables() }()
+	select {
+	case err := <-compacted:
+		if err != nil {
+			t.Fatalf("Error compacting: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected CompactSSTables to return, still running after 5s")
+	}
+	if len(db.SSTableIDs) != 5 {
+		t.Errorf("Expected the compaction to keep 5 SSTables, got %v", db.SSTableIDs)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := db.Get(fmt.Sprintf("key%d", i)); err != nil {
+			t.Errorf("Error getting key%d after compacting: %s", i, err)
+		}
+	}
+
+	report, err := memdb.CompactOffline(tempDir+"/testSSTableFiles", 400)
+	if err != nil {
+		t.Fatalf("Error compacting: %s", err)
+	}
+	if len(report.Outputs) != 2 || report.OutputEntries != 10 {
+		t.Errorf("Expected 10 entries in 2 outputs, got %+v", report)
+	}
+}
+
+// TestSSTableEncryption checks that encrypted SSTables only read back with their key
+func TestSSTableEncryption(t *testing.T) {
+	if _, err := sstable.NewEncryption([]byte("short")); err != sstable.ErrInvalidEncryptionKey {
+		t.Errorf("Expected ErrInvalidEncryptionKey, got %v", err)
+	}
+	enc, err := sstable.ParseEncryptionKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := sstable.EncryptFS(vfs.OS, enc)
+	keyring, err := sstable.ParseEncryptionKey("new:" + strings.Repeat("ab", 16) + ", old:" + strings.Repeat("cd", 32))
+	if err != nil || keyring.Current() != "new" {
+		t.Errorf("Expected named keys with new as the current one, got %v", err)
+	}
+
+	// Values spanning several blocks
+	data := make(map[string]sstable.Pair)
+	for i := 0; i < 40; i++ {
+		data[fmt.Sprintf("secret%02d", i)] = sstable.Pair{Value: []byte(strings.Repeat(fmt.Sprintf("plaintext%02d", i), 400))}
+	}
+	path := filepath.Join(t.TempDir(), "table.sst")
+	if err := sstable.WriteSSTableFS(fsys, path, sstable.NewSSTable(sstable.MemtableKeyValues(data))); err != nil {
+		t.Fatalf("Error writing SSTable: %s", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret")) || bytes.Contains(raw, []byte("plaintext")) {
+		t.Errorf("Expected no key or value in clear in the file")
+	}
+
+	sst, err := sstable.ReadSSTableFS(fsys, path)
+	if err != nil {
+		t.Fatalf("Error reading SSTable: %s", err)
+	}
+	if !sst.Header.Encrypted || len(sst.KeyValues) != 40 || string(sst.KeyValues[7].Value) != strings.Repeat("plaintext07", 400) {
+		t.Errorf("Expected the 40 entries of an encrypted table, got %d", len(sst.KeyValues))
+	}
+	reader, err := sstable.OpenReaderFS(fsys, path)
+	if err != nil {
+		t.Fatalf("Error opening reader: %s", err)
+	}
+	defer reader.Close()
+	if kv, found, err := reader.Get([]byte("secret39")); err != nil || !found || string(kv.Value) != strings.Repeat("plaintext39", 400) {
+		t.Errorf("Expected secret39 to be found, got %v, %v", found, err)
+	}
+
+	// Without the key, or with another one
+	if _, err := sstable.ReadSSTable(path); err != sstable.ErrNoEncryptionKey {
+		t.Errorf("Expected ErrNoEncryptionKey, got %v", err)
+	}
+	other, err := sstable.NewEncryption(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sstable.ReadSSTableFS(sstable.EncryptFS(vfs.OS, other), path); err != sstable.ErrDecryption {
+		t.Errorf("Expected ErrDecryption with the wrong key, got %v", err)
+	}
+
+	// Altered blocks don't decrypt
+	raw[len(raw)-1] ^= 0xff
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sstable.ReadSSTableFS(fsys, path); err != sstable.ErrDecryption {
+		t.Errorf("Expected ErrDecryption for an altered table, got %v", err)
+	}
+}
+
+// TestPrefixCompression checks that keys sharing a prefix shrink the tables, and that tables of version 1, with
+// whole keys, stay readable and are migrated
+func TestPrefixCompression(t *testing.T) {
+	keyValues := make([]sstable.KeyValuePair, 0)
+	for user := 0; user < 20; user++ {
+		for _, field := range []string{"email", "name", "phone"} {
+			key := fmt.Sprintf("user:%07d:%s", user, field)
+			keyValues = append(keyValues, sstable.KeyValuePair{Operation: sstable.OpSet, Key: []byte(key), Value: []byte(field)})
+		}
+	}
+	tempDir := t.TempDir()
+	compressed := sstable.NewSSTable(keyValues)
+	whole := sstable.NewSSTable(keyValues)
+	whole.Header.Version = 1
+	if err := sstable.WriteSSTable(tempDir+"/v2.sst", compressed); err != nil {
+		t.Fatal(err)
+	}
+	if err := sstable.WriteSSTable(tempDir+"/v1.sst", whole); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := os.Stat(tempDir + "/v1.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := os.Stat(tempDir + "/v2.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.Size() != compressed.EncodedSize() || v1.Size() != whole.EncodedSize() {
+		t.Errorf("Expected sizes of %d and %d bytes, got %d and %d", compressed.EncodedSize(), whole.EncodedSize(), v2.Size(), v1.Size())
+	}
+	if v2.Size() > v1.Size()*6/10 {
+		t.Errorf("Expected prefix compression to save 40%% at least, got %d bytes instead of %d", v2.Size(), v1.Size())
+	}
+
+	for _, name := range []string{"v1.sst", "v2.sst"} {
+		sst, err := sstable.ReadSSTable(tempDir + "/" + name)
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", name, err)
+		}
+		if !reflect.DeepEqual(sst.KeyValues, keyValues) {
+			t.Errorf("Expected the entries written to %s to read back", name)
+		}
+		salvaged, err := sstable.SalvageSSTable(tempDir + "/" + name)
+		if err != nil || len(salvaged) != len(keyValues) {
+			t.Errorf("Expected %d entries salvaged from %s, got %d, %v", len(keyValues), name, len(salvaged), err)
+		}
+		reader, err := sstable.OpenReader(tempDir + "/" + name)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", name, err)
+		}
+		// Past a restart point
+		if kv, found, err := reader.Get([]byte("user:0000017:phone")); err != nil || !found || string(kv.Value) != "phone" {
+			t.Errorf("Expected user:0000017:phone in %s, got %v, %v", name, found, err)
+		}
+		reader.Close()
+	}
+
+	// Version 1 tables are rewritten by a migration
+	report, err := memdb.Migrate(tempDir+"/test_wal.log", tempDir, false)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	for _, table := range report.Tables {
+		if table.Rewritten != (table.FromVersion == 1) || table.ToVersion != sstable.CurrentVersion {
+			t.Errorf("Unexpected migration: %+v", table)
+		}
+	}
+	if fileInfo, err := os.Stat(tempDir + "/v1.sst"); err != nil || fileInfo.Size() != v2.Size() {
+		t.Errorf("Expected v1.sst to be rewritten with prefix compression: %v", err)
+	}
+}
+
+// prefixCounter counts the keys of an SSTable starting with prefix
+type prefixCounter struct {
+	prefix string
+	keys   int
+}
+
+func (c *prefixCounter) Add(kv sstable.KeyValuePair) {
+	if strings.HasPrefix(string(kv.Key), c.prefix) {
+		c.keys++
+	}
+}
+
+func (c *prefixCounter) Finish() map[string]string {
+	return map[string]string{c.prefix + "keys": fmt.Sprint(c.keys)}
+}
+
+// TestSSTableProperties checks the properties block written with the tables, and the user properties of a collector
+func TestSSTableProperties(t *testing.T) {
+	tempDir := t.TempDir()
+	sstableDir := filepath.Join(tempDir, "testSSTableFiles")
+	wal, err := memdb.OpenWAL(filepath.Join(tempDir, "test_wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	collector := func() sstable.PropertiesCollector { return &prefixCounter{prefix: "user:"} }
+	db, err := memdb.NewDB(wal, sstableDir, memdb.Threshold(100), memdb.PropertiesCollectors(collector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	for _, key := range []string{"order:1", "user:1", "user:2"} {
+		if err := db.Set(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The properties are read without the entries
+	props, err := sstable.ReadProperties(vfs.OS, db.SSTableIDs[0])
+	if err != nil {
+		t.Fatalf("Error reading properties: %s", err)
+	}
+	if props.Entries != 3 || props.Tombstones != 0 || props.RawSize != 34 || props.MinSequence != 1 || props.MaxSequence != 1 ||
+		props.Created.Before(start.Truncate(time.Second)) || props.User["user:keys"] != "2" {
+		t.Errorf("Unexpected properties of the first table: %+v", props)
+	}
+	fileInfo, err := os.Stat(db.SSTableIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props.DataSize == 0 || int64(props.DataSize) >= fileInfo.Size() {
+		t.Errorf("Expected the entries to take less than the %d bytes of the file, got %d", fileInfo.Size(), props.DataSize)
+	}
+	if props, err := sstable.ReadProperties(vfs.OS, db.SSTableIDs[1]); err != nil || props.Tombstones != 1 || props.MinSequence != 2 {
+		t.Errorf("Expected the second table to hold a tombstone of sequence 2, got %+v, %v", props, err)
+	}
+
+	// A compaction spans the sequences of its inputs
+	if err := db.CompactSSTables(); err != nil {
+		t.Fatal(err)
+	}
+	tables, err := db.ListSSTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Properties == nil {
+		t.Fatalf("Expected a single table with properties, got %+v", tables)
+	}
+	if props := tables[0].Properties; props.MinSequence != 1 || props.MaxSequence != 2 || props.Tombstones != 1 || props.User["user:keys"] != "2" {
+		t.Errorf("Unexpected properties of the compacted table: %+v", props)
+	}
+
+	// Tables written before version 3 have none
+	v2 := sstable.NewSSTable([]sstable.KeyValuePair{{Operation: sstable.OpSet, Key: []byte("a"), Value: []byte("1")}})
+	v2.Header.Version = 2
+	if err := sstable.WriteSSTable(filepath.Join(tempDir, "v2.sst"), v2); err != nil {
+		t.Fatal(err)
+	}
+	if props, err := sstable.ReadProperties(vfs.OS, filepath.Join(tempDir, "v2.sst")); err != nil || props != nil {
+		t.Errorf("Expected no properties for version 2, got %+v, %v", props, err)
+	}
+	if sst, err := sstable.ReadSSTable(filepath.Join(tempDir, "v2.sst")); err != nil || len(sst.KeyValues) != 1 {
+		t.Errorf("Expected a table of version 2 to read back, got %v", err)
+	}
+
+	// A corrupted properties block is detected
+	data, err := os.ReadFile(tables[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[sstable.SSTableHeaderSize+4] ^= 0xff // Entry count, the first property
+	if err := os.WriteFile(filepath.Join(tempDir, "corrupted.sst"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sstable.ReadSSTable(filepath.Join(tempDir, "corrupted.sst")); err != sstable.ErrCorruptedProperties {
+		t.Errorf("Expected ErrCorruptedProperties, got %v", err)
+	}
+}
+
+// TestSSTableChecksumTypes checks that tables are read back and verified with the checksum algorithm they were
+// written with, and that the database writes its tables with the algorithm of the Checksum option
+func TestSSTableChecksumTypes(t *testing.T) {
+	tempDir := t.TempDir()
+	keyValues := []sstable.KeyValuePair{
+		{Operation: sstable.OpSet, Key: []byte("a"), Value: bytes.Repeat([]byte("x"), 100)},
+		{Operation: sstable.OpDel, Key: []byte("b")},
+		{Operation: sstable.OpSet, Key: []byte("c"), Value: []byte("3")},
+	}
+	for _, checksum := range []sstable.ChecksumType{sstable.ChecksumCRC32, sstable.ChecksumCRC32C, sstable.ChecksumXXHash64} {
+		path := filepath.Join(tempDir, checksum.String()+".sst")
+		table := sstable.NewSSTable(keyValues)
+		table.SetChecksum(checksum)
+		if err := sstable.WriteSSTable(path, table); err != nil {
+			t.Fatal(err)
+		}
+		sst, err := sstable.ReadSSTable(path)
+		if err != nil || sst.Header.ChecksumType != checksum || sst.Checksum != table.Checksum || !reflect.DeepEqual(sst.KeyValues[0], keyValues[0]) {
+			t.Errorf("%s: expected the table to read back, got %+v, %v", checksum, sst, err)
+		}
+		if _, err := sstable.OpenReader(path); err != nil {
+			t.Errorf("%s: expected the table to open, got %v", checksum, err)
+		}
+
+		// A corrupted value is detected
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[bytes.Index(data, keyValues[0].Value)] ^= 0xff
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sstable.ReadSSTable(path); err != sstable.ErrChecksumMismatch {
+			t.Errorf("%s: expected ErrChecksumMismatch, got %v", checksum, err)
+		}
+	}
+	if c, err := sstable.ParseChecksumType("xxhash64"); err != nil || c != sstable.ChecksumXXHash64 {
+		t.Errorf("Expected xxhash64 to parse, got %v, %v", c, err)
+	}
+	if _, err := sstable.ParseChecksumType("md5"); err == nil {
+		t.Errorf("Expected md5 to be refused")
+	}
+
+	wal, err := memdb.OpenWAL(filepath.Join(tempDir, "test_wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	db, err := memdb.NewDB(wal, filepath.Join(tempDir, "testSSTableFiles"), memdb.Checksum(sstable.ChecksumCRC32C))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FlushToSSTable(); err != nil {
+		t.Fatal(err)
+	}
+	tables, err := db.ListSSTables()
+	if err != nil || len(tables) != 1 || tables[0].Checksum != sstable.ChecksumCRC32C {
+		t.Errorf("Expected a table with a CRC32C checksum, got %+v, %v", tables, err)
+	}
+	if value, err := db.Get("key"); err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+}
+
+func TestSSTableRangeDeletions(t *testing.T) {
+	tempDir := t.TempDir()
+	older := []sstable.KeyValuePair{
+		{Operation: sstable.OpSet, Key: []byte("a"), Value: []byte("1")},
+		{Operation: sstable.OpSet, Key: []byte("b"), Value: []byte("2")},
+		{Operation: sstable.OpSet, Key: []byte("d"), Value: []byte("4")},
+		{Operation: sstable.OpSet, Key: []byte("x"), Value: []byte("5")},
+	}
+	// The newer table deletes from b up to e, and sets c again after the deletion
+	newer := sstable.WithRangeDeletions([]sstable.KeyValuePair{
+		{Operation: sstable.OpSet, Key: []byte("c"), Value: []byte("3")},
+	}, sstable.RangeDeletions{{Start: []byte("b"), End: []byte("e")}})
+	if len(newer) != 2 || newer[0].Operation != sstable.OpDelRange {
+		t.Fatalf("Expected the range deletion to sort first, got %v", newer)
+	}
+
+	path := filepath.Join(tempDir, "newer.sst")
+	if err := sstable.WriteSSTable(path, sstable.NewSSTable(newer)); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := sstable.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Error opening the table: %s", err)
+	}
+	defer reader.Close()
+	if ranges := reader.RangeDeletions(); len(ranges) != 1 || string(ranges[0].End) != "e" {
+		t.Errorf("Expected the range deletion to be read from the index, got %v", ranges)
+	}
+	// A key past the last entry of the table but in the range is deleted, the table's own entry wins
+	if !reader.InRange([]byte("d")) {
+		t.Error("Expected d to be in the range of the table")
+	}
+	if kv, found, err := reader.Get([]byte("d")); err != nil || !found || kv.Operation != sstable.OpDel {
+		t.Errorf("Expected d to be deleted, got %v, %t, %v", kv, found, err)
+	}
+	if kv, found, err := reader.Get([]byte("c")); err != nil || !found || string(kv.Value) != "3" {
+		t.Errorf("Expected c=3, got %v, %t, %v", kv, found, err)
+	}
+	if _, found, err := reader.Get([]byte("x")); err != nil || found {
+		t.Errorf("Expected x not to be found, got %t, %v", found, err)
+	}
+
+	// A merge drops the keys of the older table in the range, and keeps the range deletion for the tables before
+	merged := sstable.Merge([]*sstable.SSTable{sstable.NewSSTable(older), sstable.NewSSTable(newer)}, false)
+	var keys []string
+	for _, kv := range merged {
+		keys = append(keys, fmt.Sprintf("%d:%s", kv.Operation, kv.Key))
+	}
+	if want := []string{"0:a", "2:b", "0:c", "0:x"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected %v merged, got %v", want, keys)
+	}
+	merged = sstable.Merge([]*sstable.SSTable{sstable.NewSSTable(older), sstable.NewSSTable(newer)}, true)
+	if len(merged) != 3 {
+		t.Errorf("Expected the range deletion to be dropped with the tombstones, got %v", merged)
+	}
+}
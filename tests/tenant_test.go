@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"StorageEngine/tenant"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestTenants checks that tenants are isolated, authenticated and routed by path prefix or header
+func TestTenants(t *testing.T) {
+	tempDir := t.TempDir()
+	registry := tenant.NewRegistry()
+	defer func() {
+		if err := registry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if _, err := registry.Open(tenant.Config{Name: "alpha", Dir: tempDir + "/alpha", APIKey: "secret"}); err != nil {
+		t.Fatalf("Error opening tenant: %s", err)
+	}
+	if _, err := registry.Open(tenant.Config{Name: "beta", Dir: tempDir + "/beta"}); err != nil {
+		t.Fatalf("Error opening tenant: %s", err)
+	}
+	if _, err := registry.Open(tenant.Config{Name: "beta", Dir: tempDir + "/beta2"}); err != tenant.ErrTenantExists {
+		t.Errorf("Expected tenant exists error, got: %v", err)
+	}
+
+	serve := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, target, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, req)
+		return recorder
+	}
+	auth := map[string]string{tenant.APIKeyHeader: "secret"}
+
+	// Wrong or missing API key
+	if rec := serve("POST", "/alpha/set", `{"name":"imane"}`, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if rec := serve("POST", "/alpha/set", `{"name":"imane"}`, auth); rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if rec := serve("GET", "/alpha/get?key=name", "", auth); rec.Body.String() != "Value: imane" {
+		t.Errorf("Expected: Value: imane, got: %s", rec.Body.String())
+	}
+
+	// The key is not visible to the other tenant, selected through the header this time
+	if rec := serve("GET", "/get?key=name", "", map[string]string{tenant.TenantHeader: "beta"}); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	if rec := serve("GET", "/gamma/get?key=name", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// Per-tenant statistics
+	rec := serve("GET", "/alpha/info", "", auth)
+	var stats tenant.Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	expected := tenant.Stats{Name: "alpha", Requests: 4, Errors: 1, Rejected: 1}
+	if stats != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestTenantRateQuota(t *testing.T) {
+	registry := tenant.NewRegistry()
+	defer registry.Close()
+	// A rate low enough that no token is added back during the test
+	if _, err := registry.Open(tenant.Config{Name: "alpha", Dir: t.TempDir(), Rate: 0.01, Burst: 2}); err != nil {
+		t.Fatalf("Error opening tenant: %s", err)
+	}
+
+	statuses := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/alpha/get?key=name", nil))
+		statuses = append(statuses, recorder.Code)
+		if recorder.Code == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected a Retry-After header")
+		}
+	}
+	expected := []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected statuses %v, got %v", expected, statuses)
+	}
+
+	tenantAlpha, _ := registry.Get("alpha")
+	if stats := tenantAlpha.Stats(); stats.Requests != 3 || stats.Limited != 1 {
+		t.Errorf("Expected 3 requests with 1 rate limited, got %+v", stats)
+	}
+}
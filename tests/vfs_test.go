@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"StorageEngine/sstable"
+	"StorageEngine/vfs"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestVFS_Mem tests that files kept in memory behave like those of the operating system
+func TestVFS_Mem(t *testing.T) {
+	fsys := vfs.NewMem()
+
+	// Files need their parent directory
+	if _, err := fsys.OpenFile("dir/file", os.O_CREATE|os.O_RDWR, 0644); !os.IsNotExist(err) {
+		t.Errorf("Expected missing directory error, got %v", err)
+	}
+	if err := fsys.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := fsys.OpenFile("dir/file", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.OpenFile("dir/file", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); !os.IsExist(err) {
+		t.Errorf("Expected existing file error, got %v", err)
+	}
+
+	// Writes at the offset or at a position, reads see them
+	if _, err := file.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("world"), 6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || string(data) != "hello\x00world" {
+		t.Errorf("Expected %q, got %q, %v", "hello\x00world", data, err)
+	}
+	if err := file.Truncate(5); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fsys.Stat("dir/file"); err != nil || info.Size() != 5 || info.IsDir() {
+		t.Errorf("Expected a file of 5 bytes, got %v, %v", info, err)
+	}
+	file.Close()
+	if _, err := file.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected reading a closed file to fail")
+	}
+
+	// Directories list their entries sorted and move with them
+	entries, err := fsys.ReadDir("dir")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "file" || !entries[1].IsDir() {
+		t.Errorf("Expected file and sub in dir, got %v, %v", entries, err)
+	}
+	if err := fsys.Remove("dir"); err == nil {
+		t.Errorf("Expected removing a non-empty directory to fail")
+	}
+	if err := fsys.Rename("dir", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := vfs.ReadFile(fsys, "moved/file"); err != nil || string(data) != "hello" {
+		t.Errorf("Expected %q, got %q, %v", "hello", data, err)
+	}
+	if err := fsys.RemoveAll("moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("moved/sub"); !os.IsNotExist(err) {
+		t.Errorf("Expected removed directory, got %v", err)
+	}
+
+	// Locks are exclusive until released
+	lock, err := fsys.Lock("LOCK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Lock("LOCK"); err != vfs.ErrLocked {
+		t.Errorf("Expected locked error, got %v", err)
+	}
+	lock.Close()
+	if lock, err = fsys.Lock("LOCK"); err != nil {
+		t.Errorf("Expected lock to be released, got %v", err)
+	} else {
+		lock.Close()
+	}
+
+	// SSTables are written and read like on disk
+	table := sstable.NewSSTable([]sstable.KeyValuePair{{Operation: sstable.OpSet, Key: []byte("key"), Value: []byte("value")}})
+	if err := sstable.WriteSSTableFS(fsys, "table.sst", table); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := sstable.OpenReaderFS(fsys, "table.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if kv, found, err := reader.Get([]byte("key")); err != nil || !found || string(kv.Value) != "value" {
+		t.Errorf("Expected value, got %q, %v, %v", kv.Value, found, err)
+	}
+}
+
+// TestVFS_WriteFileAtomic tests that files are replaced whole, on disk and in memory
+func TestVFS_WriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	mem := vfs.NewMem()
+	if err := mem.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, fsys := range []vfs.FS{vfs.OS, mem} {
+		name := filepath.Join(dir, "cursor")
+		for _, content := range []string{"first", "second"} {
+			if err := vfs.WriteFileAtomic(fsys, name, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := vfs.ReadFile(fsys, name); err != nil || string(data) != content {
+				t.Errorf("Expected %q, got %q, %v", content, data, err)
+			}
+		}
+		// The temporary file is gone once renamed
+		if _, err := fsys.Stat(name + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("Expected no temporary file, got %v", err)
+		}
+		if err := fsys.SyncDir(filepath.Join(dir, "missing")); err == nil {
+			t.Errorf("Expected syncing a missing directory to fail")
+		}
+	}
+}
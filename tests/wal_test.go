@@ -3,6 +3,7 @@ package tests
 import (
 	"StorageEngine/memdb"
 	"bytes"
+	"encoding/binary"
 	"os"
 	"testing"
 )
@@ -100,3 +101,229 @@ func TestWALWriteAndReadEntry(t *testing.T) {
 // 		t.Errorf("WAL checkpoint is not set correctly")
 // 	}
 // }
+
+// TestScanWALFile tests reading the records of a WAL file without moving its watermark
+func TestScanWALFile(t *testing.T) {
+
+	filePath := t.TempDir() + "/test_wal.log"
+	wal, err := memdb.OpenWAL(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	records := []memdb.WALRecord{
+		{Operation: memdb.OpSet, Key: []byte("name"), Value: []byte("imane")},
+		{Operation: memdb.OpDel, Key: []byte("name")},
+		{Operation: memdb.OpSet, Key: []byte("city"), Value: []byte("azilal")},
+	}
+	for _, record := range records {
+		if err := wal.WriteEntry(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first record is flushed
+	if _, err := wal.ReadNextEntry(); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []memdb.WALEntry
+	meta, err := memdb.ScanWALFile(filePath, func(entry memdb.WALEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta != wal.MetaData {
+		t.Errorf("Expected metadata %+v, got %+v", wal.MetaData, meta)
+	}
+	if len(entries) != len(records) {
+		t.Fatalf("Expected %d records, got %d", len(records), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Seq != int64(i) || entry.Operation != records[i].Operation || !bytes.Equal(entry.Key, records[i].Key) || !bytes.Equal(entry.Value, records[i].Value) {
+			t.Errorf("Record %d: expected %+v, got %+v", i, records[i], entry)
+		}
+		if entry.Flushed != (i == 0) {
+			t.Errorf("Record %d: unexpected flushed state %v", i, entry.Flushed)
+		}
+	}
+	if entries[1].Position != entries[0].Position+entries[0].Size {
+		t.Errorf("Unexpected positions %d and %d", entries[0].Position, entries[1].Position)
+	}
+
+	// The watermark didn't move, the second record is still the next one to replay
+	record, err := wal.ReadNextEntry()
+	if err != nil || record.Operation != memdb.OpDel {
+		t.Errorf("Expected the del record, got %+v (%v)", record, err)
+	}
+
+	// A record cut in the middle is reported
+	if err := os.Truncate(filePath, meta.Offset-2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memdb.ScanWALFile(filePath, func(memdb.WALEntry) error { return nil }); err != memdb.ErrTruncatedWAL {
+		t.Errorf("Expected ErrTruncatedWAL, got %v", err)
+	}
+}
+
+// TestWALOffsetFromRecords tests that the records appended since the metadata was last written are recovered,
+// and that a record torn by a crash is cut when the WAL is opened
+func TestWALOffsetFromRecords(t *testing.T) {
+
+	filePath := t.TempDir() + "/test_wal.log"
+	wal, err := memdb.OpenWAL(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []memdb.WALRecord{
+		{Operation: memdb.OpSet, Key: []byte("name"), Value: []byte("imane")},
+		{Operation: memdb.OpSet, Key: []byte("city"), Value: []byte("azilal")},
+		{Operation: memdb.OpDel, Key: []byte("name")},
+	}
+	if err := wal.WriteEntries(records); err != nil {
+		t.Fatal(err)
+	}
+	offset := wal.Offset()
+
+	// A crash leaves the metadata as it was when the WAL was opened, followed by the records and half of another one
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+	if err := os.WriteFile(filePath, append(data[:offset], 0, 0, 0, 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale := make([]byte, memdb.WALMetadataSize)
+	binary.BigEndian.PutUint64(stale[0:8], memdb.WALMetadataSize)
+	binary.BigEndian.PutUint64(stale[8:16], memdb.WALMetadataSize)
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(stale, 0); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	count := 0
+	meta, err := memdb.ScanWALFile(filePath, func(memdb.WALEntry) error {
+		count++
+		return nil
+	})
+	if err != nil || count != len(records) || meta.Offset != offset {
+		t.Errorf("Expected %d records up to %d, got %d up to %d (%v)", len(records), offset, count, meta.Offset, err)
+	}
+
+	wal, err = memdb.OpenWAL(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	if wal.Offset() != offset {
+		t.Errorf("Expected offset %d, got %d", offset, wal.Offset())
+	}
+	if size, err := wal.Size(); err != nil || size != offset {
+		t.Errorf("Expected the torn record to be cut at %d, got %d (%v)", offset, size, err)
+	}
+	for _, expected := range records {
+		record, err := wal.ReadNextEntry()
+		if err != nil || record.Operation != expected.Operation || !bytes.Equal(record.Key, expected.Key) {
+			t.Errorf("Expected %+v, got %+v (%v)", expected, record, err)
+		}
+	}
+
+	// The next record follows the recovered ones
+	if err := wal.WriteEntry(memdb.WALRecord{Operation: memdb.OpSet, Key: []byte("country"), Value: []byte("morocco")}); err != nil {
+		t.Fatal(err)
+	}
+	record, err := wal.ReadNextEntry()
+	if err != nil || string(record.Key) != "country" {
+		t.Errorf("Expected the country record, got %+v (%v)", record, err)
+	}
+}
+
+// TestWALChecksums tests that a record that doesn't match its checksum is reported by scans and cut off when the WAL
+// is opened, with the records after it, and that records written without a checksum are still read
+func TestWALChecksums(t *testing.T) {
+
+	tempDir := t.TempDir()
+	filePath := tempDir + "/test_wal.log"
+	wal, err := memdb.OpenWAL(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []memdb.WALRecord{
+		{Operation: memdb.OpSet, Key: []byte("a"), Value: []byte("first")},
+		{Operation: memdb.OpSet, Key: []byte("b"), Value: []byte("second")},
+		{Operation: memdb.OpSet, Key: []byte("c"), Value: []byte("third")},
+	}
+	if err := wal.WriteEntries(records); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	// A bit flipped in the value of the second record, below the stored offset
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bytes.Index(data, []byte("second"))] ^= 1
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memdb.ScanWALFile(filePath, func(memdb.WALEntry) error { return nil }); err != memdb.ErrCorruptedWALRecord {
+		t.Errorf("Expected ErrCorruptedWALRecord, got %v", err)
+	}
+
+	// Recovery replays the first record only
+	wal, err = memdb.OpenWAL(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := int64(memdb.WALMetadataSize + memdb.WALRecordHeaderSize + len("a") + len("first"))
+	if size, err := wal.Size(); err != nil || wal.Offset() != end || size != end {
+		t.Errorf("Expected the WAL to be cut at %d, got offset %d and size %d (%v)", end, wal.Offset(), size, err)
+	}
+	db, err := memdb.NewDB(wal, tempDir+"/testSSTableFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("a"); err != nil || string(value) != "first" {
+		t.Errorf("Expected the first record to be recovered, got %q (%v)", value, err)
+	}
+	if _, err := db.Get("b"); err != memdb.ErrKeyNotFound {
+		t.Errorf("Expected the corrupted record to be dropped, got %v", err)
+	}
+	db.Close()
+	wal.Close()
+
+	// A record written before checksums, followed by a new one
+	legacy := make([]byte, memdb.WALMetadataSize, 64)
+	legacy = append(legacy, byte(memdb.OpSet), 0, 0, 0, 3, 0, 0, 0, 5)
+	legacy = append(legacy, "old"...)
+	legacy = append(legacy, "value"...)
+	binary.BigEndian.PutUint64(legacy[0:8], uint64(len(legacy)))
+	binary.BigEndian.PutUint64(legacy[8:16], memdb.WALMetadataSize)
+	if err := os.WriteFile(filePath, legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = memdb.OpenWAL(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	if err := wal.WriteEntry(memdb.WALRecord{Operation: memdb.OpDel, Key: []byte("old")}); err != nil {
+		t.Fatal(err)
+	}
+	record, err := wal.ReadNextEntry()
+	if err != nil || string(record.Key) != "old" || string(record.Value) != "value" {
+		t.Errorf("Expected the record without a checksum, got %+v (%v)", record, err)
+	}
+	record, err = wal.ReadNextEntry()
+	if err != nil || record.Operation != memdb.OpDel || string(record.Key) != "old" {
+		t.Errorf("Expected the del record, got %+v (%v)", record, err)
+	}
+}
@@ -0,0 +1,9 @@
+package vfs
+
+// ReadFileDirect returns the content of a file of the operating system read around its page cache, with O_DIRECT
+// on Linux and F_NOCACHE on macOS, so that reading a large file once, e.g. for a compaction, doesn't evict the
+// pages other reads use. Where the file system doesn't support it, e.g. tmpfs, and on other platforms, the file
+// is read through the page cache.
+func ReadFileDirect(name string) ([]byte, error) {
+	return readFileDirect(name)
+}
@@ -0,0 +1,20 @@
+//go:build darwin
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+func readFileDirect(name string) ([]byte, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// Best effort, the file is still read if caching can't be turned off
+	syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), syscall.F_NOCACHE, 1)
+	return io.ReadAll(file)
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	directAlignment = 4096    // Of the buffers, offsets and lengths of O_DIRECT reads, a multiple of the block size
+	directChunk     = 1 << 20 // Bytes read at once
+)
+
+func readFileDirect(name string) ([]byte, error) {
+	file, err := os.OpenFile(name, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if errors.Is(err, syscall.EINVAL) {
+		return os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reads past the end are cut short by the kernel, the buffer is rounded up to whole blocks
+	size := (int(fileInfo.Size())/directAlignment + 1) * directAlignment
+	buf := alignedBuffer(size)
+	n := 0
+	for n < len(buf) {
+		m, err := file.Read(buf[n:min(n+directChunk, len(buf))])
+		n += m
+		if err == io.EOF || m == 0 {
+			break
+		}
+		if errors.Is(err, syscall.EINVAL) {
+			return os.ReadFile(name) // Unaligned after a short read
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf[:n], nil
+}
+
+// alignedBuffer returns a buffer of size bytes starting at a multiple of directAlignment
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directAlignment)
+	offset := directAlignment - int(uintptr(unsafe.Pointer(&buf[0]))%directAlignment)
+	return buf[offset : offset+size]
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package vfs
+
+import "os"
+
+// readFileDirect reads through the page cache on this platform
+func readFileDirect(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
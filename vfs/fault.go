@@ -0,0 +1,341 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInjected is the error of the operations failed by a Fault
+	ErrInjected = errors.New("Injected fault")
+	// ErrCrashed is the error of every operation of a FaultFS once a Fault crashed it
+	ErrCrashed = errors.New("File system crashed by an injected fault")
+)
+
+// Op is a kind of operation of a FaultFS, hit by the Faults of that kind
+type Op uint8
+
+const (
+	OpAny    Op = iota // Every operation
+	OpOpen             // OpenFile
+	OpRead             // Read and ReadAt
+	OpWrite            // Write, WriteAt and Truncate
+	OpSync             // Sync and SyncDir
+	OpRename           // Rename
+	OpRemove           // Remove and RemoveAll
+	OpOther            // Stat, ReadDir, MkdirAll, Chtimes, Lock and Seek
+)
+
+// Fault describes when operations of a FaultFS fail, and how
+type Fault struct {
+	Op    Op     // Operations hit, OpAny for all of them
+	Path  string // Only the operations on a path containing Path, all of them if empty
+	After int    // Matching operations let through before the fault hits, 0 to hit the next one
+	Count int    // Matching operations failed once the fault hits, 1 if 0, -1 for all of them
+	Err   error  // Error of the failed operations, ErrInjected if nil
+	Short bool   // Writes hit write the first half of their bytes before failing, as a torn write
+	Crash bool   // The file system crashes when the fault hits, see FaultFS.Crash
+}
+
+// FaultFS wraps a file system and fails its operations as told by Faults: errors, short writes and crashes, to test
+// how the engine copes with them. Operations are counted, so that a test can crash the file system at each of the
+// operations of a workload in turn, e.g. in the middle of a flush or a compaction, then reopen the database on the
+// wrapped file system to check what survived. Errors are *os.PathError wrapping ErrInjected or ErrCrashed.
+type FaultFS struct {
+	fs      FS
+	mu      sync.Mutex
+	faults  []*faultState
+	ops     int
+	crashed bool
+	locks   map[*faultLock]bool // Held locks, released by a crash
+}
+
+// faultState is a Fault with the matching operations it saw
+type faultState struct {
+	Fault
+	seen int
+	hits int
+}
+
+// NewFault returns a FaultFS wrapping fs, which fails nothing until faults are injected
+func NewFault(fs FS) *FaultFS {
+	return &FaultFS{fs: fs, locks: make(map[*faultLock]bool)}
+}
+
+// Inject adds a fault, which counts the operations from now on
+func (f *FaultFS) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &faultState{Fault: fault})
+}
+
+// CrashAfter crashes the file system at the operation following the next n, in the middle of a write if it's one
+func (f *FaultFS) CrashAfter(n int) {
+	f.Inject(Fault{After: n, Crash: true, Short: true})
+}
+
+// Crash makes every later operation fail with ErrCrashed, as when the process is killed: what was written so far
+// stays in the wrapped file system, and the locks taken through f are released
+func (f *FaultFS) Crash() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.crash()
+}
+
+// crash implements Crash, the caller must hold f.mu
+func (f *FaultFS) crash() {
+	f.crashed = true
+	for lock := range f.locks {
+		lock.lock.Close()
+	}
+	f.locks = make(map[*faultLock]bool)
+}
+
+// Crashed reports whether the file system crashed
+func (f *FaultFS) Crashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
+
+// Ops returns the number of operations attempted so far
+func (f *FaultFS) Ops() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ops
+}
+
+// Reset removes the faults, ends a crash and sets the operation count back to 0
+func (f *FaultFS) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults, f.ops, f.crashed = nil, 0, false
+}
+
+// check counts an operation of kind op named name on path and returns its error, and whether a write must be torn
+func (f *FaultFS) check(op Op, name string, path string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return false, &os.PathError{Op: name, Path: path, Err: ErrCrashed}
+	}
+	f.ops++
+	for _, fault := range f.faults {
+		if (fault.Op != OpAny && fault.Op != op) || !strings.Contains(path, fault.Path) {
+			continue
+		}
+		if fault.seen < fault.After {
+			fault.seen++
+			continue
+		}
+		if count := max(fault.Count, 1); fault.Count >= 0 && fault.hits >= count {
+			continue
+		}
+		fault.hits++
+		err := fault.Err
+		if err == nil {
+			err = ErrInjected
+		}
+		if fault.Crash {
+			f.crash()
+			err = ErrCrashed
+		}
+		return fault.Short, &os.PathError{Op: name, Path: path, Err: err}
+	}
+	return false, nil
+}
+
+func (f *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if _, err := f.check(OpOpen, "open", name); err != nil {
+		return nil, err
+	}
+	file, err := f.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: f, file: file}, nil
+}
+
+func (f *FaultFS) Stat(name string) (os.FileInfo, error) {
+	if _, err := f.check(OpOther, "stat", name); err != nil {
+		return nil, err
+	}
+	return f.fs.Stat(name)
+}
+
+func (f *FaultFS) ReadDir(name string) ([]os.DirEntry, error) {
+	if _, err := f.check(OpOther, "readdir", name); err != nil {
+		return nil, err
+	}
+	return f.fs.ReadDir(name)
+}
+
+func (f *FaultFS) MkdirAll(path string, perm os.FileMode) error {
+	if _, err := f.check(OpOther, "mkdir", path); err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(path, perm)
+}
+
+func (f *FaultFS) Remove(name string) error {
+	if _, err := f.check(OpRemove, "remove", name); err != nil {
+		return err
+	}
+	return f.fs.Remove(name)
+}
+
+func (f *FaultFS) RemoveAll(path string) error {
+	if _, err := f.check(OpRemove, "remove", path); err != nil {
+		return err
+	}
+	return f.fs.RemoveAll(path)
+}
+
+// Rename is hit by the faults on the path of either file
+func (f *FaultFS) Rename(oldpath string, newpath string) error {
+	if _, err := f.check(OpRename, "rename", oldpath+" "+newpath); err != nil {
+		return err
+	}
+	return f.fs.Rename(oldpath, newpath)
+}
+
+func (f *FaultFS) SyncDir(name string) error {
+	if _, err := f.check(OpSync, "sync", name); err != nil {
+		return err
+	}
+	return f.fs.SyncDir(name)
+}
+
+func (f *FaultFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if _, err := f.check(OpOther, "chtimes", name); err != nil {
+		return err
+	}
+	return f.fs.Chtimes(name, atime, mtime)
+}
+
+// Lock takes a lock of the wrapped file system, released by a crash
+func (f *FaultFS) Lock(name string) (io.Closer, error) {
+	if _, err := f.check(OpOther, "lock", name); err != nil {
+		return nil, err
+	}
+	lock, err := f.fs.Lock(name)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		lock.Close()
+		return nil, &os.PathError{Op: "lock", Path: name, Err: ErrCrashed}
+	}
+	l := &faultLock{fs: f, lock: lock}
+	f.locks[l] = true
+	return l, nil
+}
+
+// faultLock is a lock taken through a FaultFS
+type faultLock struct {
+	fs   *FaultFS
+	lock io.Closer
+}
+
+// Close releases the lock, unless a crash already did
+func (l *faultLock) Close() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	if !l.fs.locks[l] {
+		return nil
+	}
+	delete(l.fs.locks, l)
+	return l.lock.Close()
+}
+
+// faultFile is a file open through a FaultFS
+type faultFile struct {
+	fs   *FaultFS
+	file File
+}
+
+func (f *faultFile) Name() string {
+	return f.file.Name()
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if _, err := f.fs.check(OpRead, "read", f.file.Name()); err != nil {
+		return 0, err
+	}
+	return f.file.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := f.fs.check(OpRead, "read", f.file.Name()); err != nil {
+		return 0, err
+	}
+	return f.file.ReadAt(p, off)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	short, err := f.fs.check(OpWrite, "write", f.file.Name())
+	if err != nil {
+		if !short {
+			return 0, err
+		}
+		n, _ := f.file.Write(p[:len(p)/2])
+		return n, err
+	}
+	return f.file.Write(p)
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	short, err := f.fs.check(OpWrite, "write", f.file.Name())
+	if err != nil {
+		if !short {
+			return 0, err
+		}
+		n, _ := f.file.WriteAt(p[:len(p)/2], off)
+		return n, err
+	}
+	return f.file.WriteAt(p, off)
+}
+
+func (f *faultFile) Seek(offset int64, whence int) (int64, error) {
+	if _, err := f.fs.check(OpOther, "seek", f.file.Name()); err != nil {
+		return 0, err
+	}
+	return f.file.Seek(offset, whence)
+}
+
+func (f *faultFile) Stat() (os.FileInfo, error) {
+	if _, err := f.fs.check(OpOther, "stat", f.file.Name()); err != nil {
+		return nil, err
+	}
+	return f.file.Stat()
+}
+
+func (f *faultFile) Sync() error {
+	if _, err := f.fs.check(OpSync, "sync", f.file.Name()); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if _, err := f.fs.check(OpWrite, "truncate", f.file.Name()); err != nil {
+		return err
+	}
+	return f.file.Truncate(size)
+}
+
+// Close isn't an operation faults hit: it closes the wrapped file even after a crash, which closes the files of
+// a killed process
+func (f *faultFile) Close() error {
+	err := f.file.Close()
+	if f.fs.Crashed() {
+		return &os.PathError{Op: "close", Path: f.file.Name(), Err: ErrCrashed}
+	}
+	return err
+}
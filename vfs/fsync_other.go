@@ -0,0 +1,27 @@
+//go:build !windows
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// renameFile replaces newpath with oldpath, rename(2) is atomic
+func renameFile(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// syncDir syncs a directory. File systems that can't, e.g. some network file systems, are left as they are.
+func syncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	errorAccessDenied     = syscall.Errno(5)
+	errorSharingViolation = syscall.Errno(32)
+)
+
+// renameFile replaces newpath with oldpath. MoveFileEx fails while another process, e.g. an antivirus or
+// an indexer, briefly holds newpath open, so the rename is retried for a little while.
+func renameFile(oldpath string, newpath string) error {
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		err = os.Rename(oldpath, newpath)
+		if !errors.Is(err, errorAccessDenied) && !errors.Is(err, errorSharingViolation) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * 10 * time.Millisecond)
+	}
+	return err
+}
+
+// syncDir does nothing, directories can't be synced on Windows and NTFS journals their entries
+func syncDir(name string) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package vfs
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockFile takes an exclusive lock on file without waiting, released when the file is closed. Windows locks
+// are mandatory, so the byte locked is far past the end of the file, where nobody reads.
+func lockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{Offset: 0xFFFFFFFF, OffsetHigh: 0x7FFFFFFF}
+	r, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		if err == errorLockViolation {
+			return ErrLocked
+		}
+		return err
+	}
+	return nil
+}
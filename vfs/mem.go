@@ -0,0 +1,480 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIsDir       = errors.New("is a directory")
+	errNotDir      = errors.New("not a directory")
+	errNotEmpty    = errors.New("directory not empty")
+	errNotReadable = errors.New("file not open for reading")
+	errNotWritable = errors.New("file not open for writing")
+	errNegative    = errors.New("negative offset")
+)
+
+// MemFS is a file system kept in memory, safe for concurrent use. Like on Unix, a file removed or renamed while
+// open can still be read and written through the open File.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode // By cleaned path, the root directories excepted
+	locks map[string]bool     // Paths locked by Lock
+}
+
+// memNode is a file or a directory of a MemFS, its fields are guarded by MemFS.mu
+type memNode struct {
+	dir     bool
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+// NewMem returns an empty file system kept in memory
+func NewMem() *MemFS {
+	return &MemFS{nodes: make(map[string]*memNode), locks: make(map[string]bool)}
+}
+
+// isRoot reports whether a cleaned path is the root of the paths, relative or absolute
+func isRoot(path string) bool {
+	return path == "." || filepath.Dir(path) == path
+}
+
+// dirExists reports whether a cleaned path is a directory, the caller must hold m.mu
+func (m *MemFS) dirExists(path string) bool {
+	node, ok := m.nodes[path]
+	return isRoot(path) || (ok && node.dir)
+}
+
+// parentError returns the error of an operation on path when its parent directory doesn't exist, the caller must
+// hold m.mu
+func (m *MemFS) parentError(op string, path string) error {
+	if m.dirExists(filepath.Dir(path)) {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+}
+
+// OpenFile opens a file like os.OpenFile
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Clean(name)
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	file := &memFile{fs: m, name: name, readable: access != os.O_WRONLY, writable: access != os.O_RDONLY,
+		append: flag&os.O_APPEND != 0}
+
+	node, exists := m.nodes[path]
+	switch {
+	case isRoot(path):
+		node = &memNode{dir: true, mode: os.ModeDir | 0755}
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !exists:
+		if err := m.parentError("open", name); err != nil {
+			return nil, err
+		}
+		node = &memNode{mode: perm & os.ModePerm, modTime: time.Now()}
+		m.nodes[path] = node
+	}
+	if node.dir && file.writable {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	if flag&os.O_TRUNC != 0 && file.writable {
+		node.data, node.modTime = nil, time.Now()
+	}
+	file.node = node
+	return file, nil
+}
+
+// Stat describes a file or a directory
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Clean(name)
+	if isRoot(path) {
+		return &memFileInfo{name: filepath.Base(path), node: memNode{dir: true, mode: os.ModeDir | 0755}}, nil
+	}
+	node, ok := m.nodes[path]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFileInfo{name: filepath.Base(path), node: *node}, nil
+}
+
+// ReadDir returns the entries of a directory sorted by name
+func (m *MemFS) ReadDir(name string) ([]os.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Clean(name)
+	if node, ok := m.nodes[path]; ok && !node.dir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	if !m.dirExists(path) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]os.DirEntry, 0)
+	for child, node := range m.nodes {
+		if filepath.Dir(child) == path && child != path {
+			entries = append(entries, fs.FileInfoToDirEntry(&memFileInfo{name: filepath.Base(child), node: *node}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// MkdirAll creates a directory and its missing parents
+func (m *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var missing []string
+	for path := filepath.Clean(name); !isRoot(path); path = filepath.Dir(path) {
+		node, ok := m.nodes[path]
+		if ok && !node.dir {
+			return &os.PathError{Op: "mkdir", Path: path, Err: errNotDir}
+		}
+		if ok {
+			break
+		}
+		missing = append(missing, path)
+	}
+	for _, path := range missing {
+		m.nodes[path] = &memNode{dir: true, mode: os.ModeDir | perm&os.ModePerm, modTime: time.Now()}
+	}
+	return nil
+}
+
+// hasChildren reports whether a cleaned path holds files or directories, the caller must hold m.mu
+func (m *MemFS) hasChildren(path string) bool {
+	for child := range m.nodes {
+		if filepath.Dir(child) == path && child != path {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes a file or an empty directory
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Clean(name)
+	node, ok := m.nodes[path]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.dir && m.hasChildren(path) {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	delete(m.nodes, path)
+	return nil
+}
+
+// RemoveAll removes a file or a directory and everything it holds, it does nothing if it doesn't exist
+func (m *MemFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Clean(name)
+	for child := range m.nodes {
+		if child == path || isWithin(child, path) {
+			delete(m.nodes, child)
+		}
+	}
+	return nil
+}
+
+// isWithin reports whether the cleaned path child is inside the directory dir
+func isWithin(child string, dir string) bool {
+	if isRoot(dir) {
+		return !isRoot(child) && (dir != "." || !filepath.IsAbs(child))
+	}
+	return strings.HasPrefix(child, dir+string(filepath.Separator))
+}
+
+// Rename moves a file or a directory, replacing the file or the empty directory at newpath
+func (m *MemFS) Rename(oldpath string, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, to := filepath.Clean(oldpath), filepath.Clean(newpath)
+	node, ok := m.nodes[from]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	if err := m.parentError("rename", newpath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	if from == to {
+		return nil
+	}
+	if target, ok := m.nodes[to]; ok {
+		switch {
+		case target.dir && !node.dir:
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errIsDir}
+		case !target.dir && node.dir:
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errNotDir}
+		case target.dir && m.hasChildren(to):
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errNotEmpty}
+		}
+	}
+	if node.dir && isWithin(to, from) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("invalid argument")}
+	}
+	for child, childNode := range m.nodes {
+		if isWithin(child, from) {
+			delete(m.nodes, child)
+			m.nodes[to+strings.TrimPrefix(child, from)] = childNode
+		}
+	}
+	delete(m.nodes, from)
+	m.nodes[to] = node
+	return nil
+}
+
+// SyncDir does nothing, the files of a MemFS are never persisted
+func (m *MemFS) SyncDir(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirExists(filepath.Clean(name)) {
+		return &os.PathError{Op: "sync", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// Chtimes sets the modification time of a file or a directory, files of a MemFS have no access time
+func (m *MemFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, ok := m.nodes[filepath.Clean(name)]
+	if !ok {
+		return &os.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	node.modTime = mtime
+	return nil
+}
+
+// Lock takes a lock on the file name, only seen by the users of m
+func (m *MemFS) Lock(name string) (io.Closer, error) {
+	file, err := m.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Clean(name)
+	if m.locks[path] {
+		return nil, ErrLocked
+	}
+	m.locks[path] = true
+	return &memLock{fs: m, path: path}, nil
+}
+
+// memLock releases a lock taken by MemFS.Lock
+type memLock struct {
+	fs   *MemFS
+	path string
+	once sync.Once
+}
+
+func (l *memLock) Close() error {
+	l.once.Do(func() {
+		l.fs.mu.Lock()
+		delete(l.fs.locks, l.path)
+		l.fs.mu.Unlock()
+	})
+	return nil
+}
+
+// memFileInfo describes a file of a MemFS, with a copy of its node taken by Stat
+type memFileInfo struct {
+	name string
+	node memNode
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return int64(len(i.node.data)) }
+func (i *memFileInfo) Mode() os.FileMode  { return i.node.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.node.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.node.dir }
+func (i *memFileInfo) Sys() interface{}   { return nil }
+
+// memFile is a File open on a node of a MemFS
+type memFile struct {
+	fs       *MemFS
+	node     *memNode
+	name     string
+	offset   int64
+	readable bool
+	writable bool
+	append   bool
+	closed   bool
+}
+
+// check returns the error of an operation on the file, the caller must hold f.fs.mu
+func (f *memFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	case f.node.dir:
+		return &os.PathError{Op: op, Path: f.name, Err: errIsDir}
+	case write && !f.writable:
+		return &os.PathError{Op: op, Path: f.name, Err: errNotWritable}
+	case !write && !f.readable:
+		return &os.PathError{Op: op, Path: f.name, Err: errNotReadable}
+	}
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+// readAt implements ReadAt, the caller must hold f.fs.mu
+func (f *memFile) readAt(p []byte, off int64) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errNegative}
+	}
+	if off >= int64(len(f.node.data)) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.writeAt(p, off)
+}
+
+// writeAt implements WriteAt, the caller must hold f.fs.mu
+func (f *memFile) writeAt(p []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errNegative}
+	}
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		if end > int64(cap(f.node.data)) {
+			data := make([]byte, end, max(end, 2*int64(cap(f.node.data))))
+			copy(data, f.node.data)
+			f.node.data = data
+		}
+		f.node.data = f.node.data[:end]
+	}
+	copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errNegative}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	return &memFileInfo{name: filepath.Base(f.name), node: *f.node}, nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &os.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errNegative}
+	}
+	if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,156 @@
+// Package vfs abstracts the file operations of the WAL and of the SSTables, so that the engine runs the same on
+// the files of the operating system (OS) and on files kept in memory (NewMem), e.g. in hermetic tests, or on any
+// other backend implementing FS.
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned by FS.Lock when the lock is already held
+var ErrLocked = errors.New("File is locked by another process")
+
+// File is an open file of an FS
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FS is a file system. Its errors are *os.PathError like those of the os package, so os.IsNotExist and
+// os.IsExist apply to them.
+type FS interface {
+	// OpenFile opens a file like os.OpenFile, with the os.O_RDONLY, os.O_WRONLY, os.O_RDWR, os.O_CREATE,
+	// os.O_EXCL, os.O_TRUNC and os.O_APPEND flags
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns the entries of a directory sorted by name
+	ReadDir(name string) ([]os.DirEntry, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	// Rename replaces newpath with oldpath in a single step, readers see either the old or the new file
+	Rename(oldpath string, newpath string) error
+	// SyncDir makes the entries of a directory, e.g. a file just created or renamed, durable
+	SyncDir(name string) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	// Lock takes an exclusive lock on the file name, creating it if needed, until the returned Closer is closed.
+	// It returns ErrLocked without waiting if the lock is already held.
+	Lock(name string) (io.Closer, error)
+}
+
+// Open opens a file of fs for reading
+func Open(fs FS, name string) (File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// ReadFile returns the content of a file of fs
+func ReadFile(fs FS, name string) ([]byte, error) {
+	file, err := Open(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// ReplaceFile renames oldpath to newpath, replacing it, then syncs their directory so that the rename survives
+// a crash
+func ReplaceFile(fs FS, oldpath string, newpath string) error {
+	if err := fs.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	return fs.SyncDir(filepath.Dir(newpath))
+}
+
+// WriteFileAtomic writes data to the file name of fs: a crash leaves either the previous content or the new one,
+// never a part of it. The data is written to a temporary file, synced, then renamed over name.
+func WriteFileAtomic(fs FS, name string, data []byte, perm os.FileMode) error {
+	tmp := name + ".tmp"
+	file, err := fs.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	return ReplaceFile(fs, tmp, name)
+}
+
+// OS is the file system of the operating system
+var OS FS = osFS{}
+
+// osFS implements FS with the os package
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err // Not a nil *os.File in a non-nil File
+	}
+	return file, nil
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFS) Rename(oldpath string, newpath string) error {
+	return renameFile(oldpath, newpath)
+}
+
+func (osFS) SyncDir(name string) error {
+	return syncDir(name)
+}
+
+func (osFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// Lock takes an advisory lock, flock or LockFileEx, that other processes see
+func (osFS) Lock(name string) (io.Closer, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}